@@ -0,0 +1,46 @@
+// Command rsync-agent orchestrates rsync transfer containers, see package agent
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		fmt.Fprintf(os.Stderr, "usage: %s %s|%s\n", os.Args[0], agent.RoleClient, agent.RoleServer)
+		os.Exit(2)
+	}
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	a := agent.New()
+	var rc int
+	var err error
+	switch os.Args[1] {
+	case agent.RoleClient:
+		config := agent.ClientConfig{}
+		if err = agent.ConfigFromEnv(os.Getenv, &config); err == nil {
+			rc, err = a.RunClient(ctx, config)
+		}
+	case agent.RoleServer:
+		config := agent.ServerConfig{}
+		if err = agent.ConfigFromEnv(os.Getenv, &config); err == nil {
+			rc, err = a.RunServer(ctx, config)
+		}
+	default:
+		err = fmt.Errorf("unknown role %s", os.Args[1])
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		if rc == 0 {
+			rc = 1
+		}
+	}
+	os.Exit(rc)
+}
@@ -2,11 +2,21 @@ package endpoint
 
 import (
 	"context"
+	"errors"
 
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+var (
+	// ErrEndpointNotReady is returned when the resources backing an endpoint exist
+	// but are not yet in a state where they can accept connections
+	ErrEndpointNotReady = errors.New("endpoint not ready")
+	// ErrHostnameTooLong is returned when the hostname of an endpoint exceeds the
+	// length limits of DNS names
+	ErrHostnameTooLong = errors.New("hostname exceeds DNS length limits")
+)
+
 // Endpoint knows how to connect with a Transport or a Transfer
 type Endpoint interface {
 	// NamespacedName returns a ns name to identify this endpoint
@@ -0,0 +1,77 @@
+package external
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type external struct {
+	namespacedName types.NamespacedName
+	hostname       string
+	ingressPort    int32
+	backendPort    int32
+}
+
+// New creates an endpoint for a network path managed outside of the cluster, e.g. a VIP
+// or a hardware load balancer forwarding ingressPort on hostname to backendPort of the
+// transfer pods. No resources are reconciled for the endpoint, the callers are responsible
+// for routing the traffic to the pods.
+//
+// The namespacedName is only used to identify the endpoint, hostname can either be a DNS
+// name or an IP address.
+func New(namespacedName types.NamespacedName, hostname string, ingressPort, backendPort int32) (endpoint.Endpoint, error) {
+	if hostname == "" {
+		return nil, fmt.Errorf("hostname cannot be empty")
+	}
+	if len(hostname) > validation.DNS1123SubdomainMaxLength {
+		return nil, fmt.Errorf("%w: hostname %s is longer than %d characters",
+			endpoint.ErrHostnameTooLong, hostname, validation.DNS1123SubdomainMaxLength)
+	}
+	for _, port := range []int32{ingressPort, backendPort} {
+		if errs := validation.IsValidPortNum(int(port)); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid port %d: %v", port, errs)
+		}
+	}
+	return &external{
+		namespacedName: namespacedName,
+		hostname:       hostname,
+		ingressPort:    ingressPort,
+		backendPort:    backendPort,
+	}, nil
+}
+
+func (e *external) NamespacedName() types.NamespacedName {
+	return e.namespacedName
+}
+
+func (e *external) Hostname() string {
+	return e.hostname
+}
+
+func (e *external) BackendPort() int32 {
+	return e.backendPort
+}
+
+func (e *external) IngressPort() int32 {
+	return e.ingressPort
+}
+
+// IsHealthy always returns true, the health of the network path is not known to the cluster
+func (e *external) IsHealthy(ctx context.Context, c client.Client) (bool, error) {
+	return true, nil
+}
+
+// Health always reports the endpoint healthy, see IsHealthy
+func (e *external) Health(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	return endpoint.Healthy(), nil
+}
+
+// MarkForCleanup is a no-op, there are no resources created for the endpoint
+func (e *external) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
+	return nil
+}
@@ -0,0 +1,91 @@
+package external
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name        string
+		hostname    string
+		ingressPort int32
+		backendPort int32
+		wantErr     bool
+		wantErrIs   error
+	}{
+		{
+			name:        "valid hostname and ports, must return the endpoint",
+			hostname:    "vip.example.com",
+			ingressPort: 443,
+			backendPort: 6443,
+			wantErr:     false,
+		},
+		{
+			name:        "ip address, must return the endpoint",
+			hostname:    "192.168.0.10",
+			ingressPort: 443,
+			backendPort: 6443,
+			wantErr:     false,
+		},
+		{
+			name:        "empty hostname, must return error",
+			hostname:    "",
+			ingressPort: 443,
+			backendPort: 6443,
+			wantErr:     true,
+		},
+		{
+			name:        "hostname too long, must return ErrHostnameTooLong",
+			hostname:    strings.Repeat("a.", 130) + "com",
+			ingressPort: 443,
+			backendPort: 6443,
+			wantErr:     true,
+			wantErrIs:   endpoint.ErrHostnameTooLong,
+		},
+		{
+			name:        "invalid port, must return error",
+			hostname:    "vip.example.com",
+			ingressPort: 0,
+			backendPort: 6443,
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+			e, err := New(namespacedName, tt.hostname, tt.ingressPort, tt.backendPort)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+				t.Errorf("New() error = %v, want %v", err, tt.wantErrIs)
+			}
+			if tt.wantErr {
+				return
+			}
+			if e.Hostname() != tt.hostname || e.IngressPort() != tt.ingressPort || e.BackendPort() != tt.backendPort {
+				t.Errorf("New() = %s:%d -> %d, want %s:%d -> %d", e.Hostname(), e.IngressPort(), e.BackendPort(),
+					tt.hostname, tt.ingressPort, tt.backendPort)
+			}
+			c := fake.NewClientBuilder().Build()
+			healthy, err := e.IsHealthy(context.Background(), c)
+			if err != nil || !healthy {
+				t.Errorf("IsHealthy() = %v, %v, want healthy", healthy, err)
+			}
+			health, err := endpoint.GetHealth(context.Background(), c, e)
+			if err != nil || health.Reason != endpoint.HealthReasonHealthy {
+				t.Errorf("GetHealth() = %v, %v, want healthy", health, err)
+			}
+			if err := e.MarkForCleanup(context.Background(), c, "key", "value"); err != nil {
+				t.Errorf("MarkForCleanup() unexpected error %v", err)
+			}
+		})
+	}
+}
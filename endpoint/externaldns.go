@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// ExternalDNSHostnameAnnotation requests external-dns to publish records for the annotated resource
+	ExternalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	// ExternalDNSTTLAnnotation sets the TTL of the records published by external-dns
+	ExternalDNSTTLAnnotation = "external-dns.alpha.kubernetes.io/ttl"
+)
+
+// ExternalDNS publishes a DNS name for an endpoint with external-dns, endpoints are healthy
+// once the name resolves
+type ExternalDNS struct {
+	// Hostname is the DNS name published for the endpoint
+	Hostname string
+	// TTL is the TTL of the records in seconds, the default TTL of external-dns is used when 0
+	TTL int64
+	// LookupHost resolves Hostname in health checks, defaults to the resolver of the Go runtime
+	LookupHost func(ctx context.Context, host string) ([]string, error)
+}
+
+// Validate returns an error when the hostname or the TTL cannot be published
+func (e *ExternalDNS) Validate() error {
+	if errs := validation.IsDNS1123Subdomain(e.Hostname); len(errs) > 0 {
+		return fmt.Errorf("invalid external-dns hostname %s: %s", e.Hostname, strings.Join(errs, ", "))
+	}
+	for _, label := range strings.Split(e.Hostname, ".") {
+		if len(label) > validation.DNS1123LabelMaxLength {
+			return fmt.Errorf("%w: label %s of hostname %s is longer than %d characters",
+				ErrHostnameTooLong, label, e.Hostname, validation.DNS1123LabelMaxLength)
+		}
+	}
+	if e.TTL < 0 {
+		return fmt.Errorf("invalid external-dns TTL %d, it must not be negative", e.TTL)
+	}
+	return nil
+}
+
+// Annotations returns the annotations requesting the records from external-dns
+func (e *ExternalDNS) Annotations() map[string]string {
+	annotations := map[string]string{
+		ExternalDNSHostnameAnnotation: e.Hostname,
+	}
+	if e.TTL > 0 {
+		annotations[ExternalDNSTTLAnnotation] = strconv.FormatInt(e.TTL, 10)
+	}
+	return annotations
+}
+
+// Resolves returns whether Hostname resolves, it returns false without an error while the
+// records are not published yet
+func (e *ExternalDNS) Resolves(ctx context.Context) (bool, error) {
+	lookupHost := e.LookupHost
+	if lookupHost == nil {
+		lookupHost = net.DefaultResolver.LookupHost
+	}
+	addresses, err := lookupHost(ctx, e.Hostname)
+	dnsErr := &net.DNSError{}
+	switch {
+	case errors.As(err, &dnsErr) && (dnsErr.IsNotFound || dnsErr.IsTemporary || dnsErr.IsTimeout):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return len(addresses) > 0, nil
+}
+
+// MergeAnnotations returns the annotations of an endpoint with the external-dns annotations
+// added, external-dns may be nil
+func MergeAnnotations(annotations map[string]string, externalDNS *ExternalDNS) map[string]string {
+	if externalDNS == nil {
+		return annotations
+	}
+	merged := map[string]string{}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	for key, value := range externalDNS.Annotations() {
+		merged[key] = value
+	}
+	return merged
+}
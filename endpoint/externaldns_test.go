@@ -0,0 +1,103 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestExternalDNS_Validate(t *testing.T) {
+	tests := []struct {
+		name        string
+		externalDNS ExternalDNS
+		wantErr     bool
+	}{
+		{
+			name:        "valid hostname and TTL, must be valid",
+			externalDNS: ExternalDNS{Hostname: "transfer.example.com", TTL: 60},
+		},
+		{
+			name:        "invalid hostname, must return an error",
+			externalDNS: ExternalDNS{Hostname: "Transfer_Example.com"},
+			wantErr:     true,
+		},
+		{
+			name:        "label longer than 63 characters, must return an error",
+			externalDNS: ExternalDNS{Hostname: strings.Repeat("a", 64) + ".example.com"},
+			wantErr:     true,
+		},
+		{
+			name:        "negative TTL, must return an error",
+			externalDNS: ExternalDNS{Hostname: "transfer.example.com", TTL: -1},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.externalDNS.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMergeAnnotations(t *testing.T) {
+	annotations := map[string]string{"foo": "bar"}
+	got := MergeAnnotations(annotations, &ExternalDNS{Hostname: "transfer.example.com", TTL: 60})
+	want := map[string]string{
+		"foo":                         "bar",
+		ExternalDNSHostnameAnnotation: "transfer.example.com",
+		ExternalDNSTTLAnnotation:      "60",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeAnnotations() = %v, want %v", got, want)
+	}
+	if len(annotations) != 1 {
+		t.Errorf("MergeAnnotations() modified the given annotations %v", annotations)
+	}
+	if got := MergeAnnotations(annotations, nil); !reflect.DeepEqual(got, annotations) {
+		t.Errorf("MergeAnnotations() = %v, want %v", got, annotations)
+	}
+}
+
+func TestExternalDNS_Resolves(t *testing.T) {
+	tests := []struct {
+		name       string
+		lookupHost func(ctx context.Context, host string) ([]string, error)
+		want       bool
+		wantErr    bool
+	}{
+		{
+			name: "records published, must resolve",
+			lookupHost: func(ctx context.Context, host string) ([]string, error) {
+				return []string{"10.0.0.1"}, nil
+			},
+			want: true,
+		},
+		{
+			name: "records not published yet, must not resolve",
+			lookupHost: func(ctx context.Context, host string) ([]string, error) {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			},
+		},
+		{
+			name: "unexpected error, must return the error",
+			lookupHost: func(ctx context.Context, host string) ([]string, error) {
+				return nil, errors.New("resolver unavailable")
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &ExternalDNS{Hostname: "transfer.example.com", LookupHost: tt.lookupHost}
+			got, err := e.Resolves(context.Background())
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("Resolves() = %v, %v, want %v, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
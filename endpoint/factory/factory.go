@@ -0,0 +1,141 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/endpoint/ingress"
+	"github.com/backube/pvc-transfer/endpoint/route"
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Type is the type of the endpoint chosen by NewBest
+type Type string
+
+const (
+	TypeRoute        Type = "Route"
+	TypeLoadBalancer Type = "LoadBalancer"
+	TypeIngress      Type = "Ingress"
+	TypeNodePort     Type = "NodePort"
+)
+
+const (
+	defaultBackendPort = 6443
+	defaultIngressPort = 443
+)
+
+// DefaultPreference is the order in which endpoint types are tried by NewBest
+var DefaultPreference = []Type{TypeRoute, TypeLoadBalancer, TypeIngress, TypeNodePort}
+
+// Options configure the endpoint created by NewBest, fields which do not apply to
+// the chosen endpoint type are ignored
+type Options struct {
+	// Preference is the order in which endpoint types are tried, defaults to DefaultPreference
+	Preference []Type
+	// Labels are applied to the endpoint resources and used as the service selector
+	Labels map[string]string
+	// OwnerReferences are applied to the endpoint resources
+	OwnerReferences []metav1.OwnerReference
+	// BackendPort is the port of the pods behind the endpoint, defaults to 6443. Ingress
+	// endpoints always use 6443
+	BackendPort int32
+	// IngressPort is the port exposed by LoadBalancer endpoints, defaults to 443
+	IngressPort int32
+	// LoadBalancerAvailable declares that the cluster provisions LoadBalancer services, e.g.
+	// with a cloud provider or MetalLB. The Kubernetes API does not tell whether a load
+	// balancer implementation is installed, LoadBalancer endpoints are skipped unless it is set
+	LoadBalancerAvailable bool
+	// Subdomain is used to generate the hostname of Ingress endpoints, Ingress
+	// endpoints are skipped when not set
+	Subdomain string
+	// Hostname is the host of Route endpoints, it is assigned by the router when empty.
+	// Set it to the endpoint.PinnedHostname of a transfer which began, so that a recreated
+	// route keeps the host known to the transfer clients.
+	Hostname string
+	// IngressClassName is the class of Ingress endpoints, when nil the default
+	// ingress class of the cluster is required
+	IngressClassName *string
+	// IngressProfile configures TLS passthrough for Ingress endpoints
+	IngressProfile ingress.IngressControllerProfile
+	// IngressTLSSecretName is the TLS secret of Ingress endpoints terminating TLS in the ingress
+	// controller, see ingress.Options.TLSSecretName
+	IngressTLSSecretName string
+	// ExternalDNS publishes a DNS name for the endpoint with external-dns, Ingress endpoints
+	// use it instead of Subdomain. See endpoint.ExternalDNS
+	ExternalDNS *endpoint.ExternalDNS
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
+}
+
+// NewBest probes the cluster for the endpoint types it supports, creates an endpoint of the
+// first supported type in the order of preference and returns the type that was chosen.
+// Refer the New function of the chosen endpoint package for details on using the endpoint.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+func NewBest(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, Type, error) {
+	t, err := Detect(ctx, c, options)
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Info("creating endpoint", "type", t, "endpoint", namespacedName)
+
+	e, err := New(ctx, c, logger, t, namespacedName, options)
+	if err != nil {
+		return nil, t, err
+	}
+	return e, t, nil
+}
+
+// Detect returns the first endpoint type in the order of preference supported by the cluster
+func Detect(ctx context.Context, c client.Client, options Options) (Type, error) {
+	preference := options.Preference
+	if len(preference) == 0 {
+		preference = DefaultPreference
+	}
+	for _, t := range preference {
+		supported, err := IsSupported(ctx, c, t, options)
+		if err != nil {
+			return "", err
+		}
+		if supported {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("none of the endpoint types %v are supported by the cluster", preference)
+}
+
+// isRouteSupported checks if route.openshift.io is served by the cluster
+func isRouteSupported(c client.Client) (bool, error) {
+	_, err := route.APIsToWatch(c)
+	switch {
+	case errors.Is(err, route.ErrRouteAPIUnavailable):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func portOrDefault(port, defaultPort int32) int32 {
+	if port == 0 {
+		return defaultPort
+	}
+	return port
+}
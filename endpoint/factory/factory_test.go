@@ -0,0 +1,169 @@
+package factory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/endpoint/route"
+	"github.com/go-logr/logr/testr"
+	routev1 "github.com/openshift/api/route/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metaapi "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// fakeClientWithRESTMapper allows tests to control the APIs discovered by the client
+type fakeClientWithRESTMapper struct {
+	client.Client
+	mapper metaapi.RESTMapper
+}
+
+func (f fakeClientWithRESTMapper) RESTMapper() metaapi.RESTMapper {
+	return f.mapper
+}
+
+func fakeClient(withRouteAPI bool, objs ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	clientgoscheme.AddToScheme(scheme)
+	route.AddToScheme(scheme)
+	mapper := metaapi.NewDefaultRESTMapper([]schema.GroupVersion{})
+	if withRouteAPI {
+		mapper.Add(routev1.GroupVersion.WithKind("Route"), metaapi.RESTScopeNamespace)
+	}
+	return fakeClientWithRESTMapper{
+		Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build(),
+		mapper: mapper,
+	}
+}
+
+func TestDetect(t *testing.T) {
+	defaultIngressClass := &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "nginx",
+			Annotations: map[string]string{networkingv1.AnnotationIsDefaultIngressClass: "true"},
+		},
+	}
+	tests := []struct {
+		name         string
+		withRouteAPI bool
+		objects      []client.Object
+		options      Options
+		want         Type
+		wantErr      bool
+	}{
+		{
+			name:         "route API available, must choose route",
+			withRouteAPI: true,
+			options:      Options{LoadBalancerAvailable: true},
+			want:         TypeRoute,
+		},
+		{
+			name:    "load balancers available, must choose loadbalancer",
+			objects: []client.Object{defaultIngressClass},
+			options: Options{Subdomain: "apps.example.com", LoadBalancerAvailable: true},
+			want:    TypeLoadBalancer,
+		},
+		{
+			name:    "default ingress class and subdomain, must choose ingress",
+			objects: []client.Object{defaultIngressClass},
+			options: Options{Subdomain: "apps.example.com"},
+			want:    TypeIngress,
+		},
+		{
+			name:    "default ingress class and external-dns without subdomain, must choose ingress",
+			objects: []client.Object{defaultIngressClass},
+			options: Options{ExternalDNS: &endpoint.ExternalDNS{Hostname: "transfer.example.com"}},
+			want:    TypeIngress,
+		},
+		{
+			name:    "default ingress class without subdomain, must choose nodeport",
+			objects: []client.Object{defaultIngressClass},
+			want:    TypeNodePort,
+		},
+		{
+			name:    "ingress class does not exist, must choose nodeport",
+			objects: []client.Object{defaultIngressClass},
+			options: Options{Subdomain: "apps.example.com", IngressClassName: pointer.String("missing")},
+			want:    TypeNodePort,
+		},
+		{
+			name:    "custom preference, must follow the preference",
+			objects: []client.Object{defaultIngressClass},
+			options: Options{
+				Subdomain:             "apps.example.com",
+				LoadBalancerAvailable: true,
+				Preference:            []Type{TypeIngress, TypeLoadBalancer},
+			},
+			want: TypeIngress,
+		},
+		{
+			name:    "no preferred type supported, must return error",
+			options: Options{Preference: []Type{TypeRoute, TypeLoadBalancer}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Detect(context.Background(), fakeClient(tt.withRouteAPI, tt.objects...), tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Detect() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Detect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewBest(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	c := fakeClient(false)
+	e, got, err := NewBest(context.Background(), c, testr.New(t), namespacedName, Options{
+		Labels: map[string]string{"test": "me"},
+	})
+	if err != nil {
+		t.Fatalf("NewBest() unexpected error %v", err)
+	}
+	if got != TypeNodePort {
+		t.Errorf("NewBest() type = %v, want %v", got, TypeNodePort)
+	}
+	if e.BackendPort() != defaultBackendPort {
+		t.Errorf("BackendPort() = %v, want %v", e.BackendPort(), defaultBackendPort)
+	}
+	svc := &corev1.Service{}
+	err = c.Get(context.Background(), namespacedName, svc)
+	if err != nil {
+		t.Fatalf("unable to get service %v", err)
+	}
+	if svc.Spec.Type != corev1.ServiceTypeNodePort {
+		t.Errorf("service type = %v, want %v", svc.Spec.Type, corev1.ServiceTypeNodePort)
+	}
+}
+
+func TestNew_routeHostname(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	c := fakeClient(true)
+	_, err := New(context.Background(), c, testr.New(t), TypeRoute, namespacedName, Options{
+		Hostname: "foo-bar.apps.example.com",
+	})
+	if err != nil {
+		t.Fatalf("New() unexpected error %v", err)
+	}
+	r := &routev1.Route{}
+	err = c.Get(context.Background(), namespacedName, r)
+	if err != nil {
+		t.Fatalf("unable to get route %v", err)
+	}
+	if r.Spec.Host != "foo-bar.apps.example.com" {
+		t.Errorf("route host = %v, want the pinned hostname", r.Spec.Host)
+	}
+}
@@ -0,0 +1,212 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/endpoint/ingress"
+	"github.com/backube/pvc-transfer/endpoint/route"
+	"github.com/backube/pvc-transfer/endpoint/service"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrTypeNotRegistered is returned when an endpoint type is not found in the registry
+	ErrTypeNotRegistered = errors.New("endpoint type not registered")
+	// ErrTypeAlreadyRegistered is returned when registering an endpoint type twice
+	ErrTypeAlreadyRegistered = errors.New("endpoint type already registered")
+)
+
+// Capabilities describe what an endpoint type requires from the cluster
+type Capabilities struct {
+	// RequiresRouteAPI is set for endpoint types backed by OpenShift routes
+	RequiresRouteAPI bool
+	// RequiresLoadBalancer is set for endpoint types backed by LoadBalancer services
+	RequiresLoadBalancer bool
+	// RequiresIngressClass is set for endpoint types backed by ingresses, these
+	// also require a subdomain to be set in the options
+	RequiresIngressClass bool
+}
+
+// Constructor creates an endpoint of a registered type
+type Constructor func(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error)
+
+// SupportedFunc returns whether the cluster supports an endpoint type for the given options
+type SupportedFunc func(ctx context.Context, c client.Client, options Options) (bool, error)
+
+// Registration describes an endpoint type known to the factory
+type Registration struct {
+	Capabilities Capabilities
+	// New creates the endpoint
+	New Constructor
+	// Supported probes the cluster for the endpoint type, the type is
+	// always considered supported when it is not set
+	Supported SupportedFunc
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[Type]Registration{}
+)
+
+func init() {
+	for t, r := range map[Type]Registration{
+		TypeRoute: {
+			Capabilities: Capabilities{RequiresRouteAPI: true},
+			New:          newRoute,
+			Supported: func(ctx context.Context, c client.Client, options Options) (bool, error) {
+				return isRouteSupported(c)
+			},
+		},
+		TypeLoadBalancer: {
+			Capabilities: Capabilities{RequiresLoadBalancer: true},
+			New:          newService(corev1.ServiceTypeLoadBalancer),
+			Supported: func(ctx context.Context, c client.Client, options Options) (bool, error) {
+				return options.LoadBalancerAvailable, nil
+			},
+		},
+		TypeIngress: {
+			Capabilities: Capabilities{RequiresIngressClass: true},
+			New:          newIngress,
+			Supported: func(ctx context.Context, c client.Client, options Options) (bool, error) {
+				if options.Subdomain == "" && options.ExternalDNS == nil {
+					return false, nil
+				}
+				return ingress.IsIngressClassAvailable(ctx, c, options.IngressClassName)
+			},
+		},
+		TypeNodePort: {
+			New: newService(corev1.ServiceTypeNodePort),
+		},
+	} {
+		if err := Register(t, r); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Register adds an endpoint type to the registry, allowing NewBest, Detect and New to
+// create endpoints of that type. It is expected to be called from init functions.
+func Register(t Type, r Registration) error {
+	if r.New == nil {
+		return fmt.Errorf("constructor of endpoint type %s is not set", t)
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[t]; ok {
+		return fmt.Errorf("%w: %s", ErrTypeAlreadyRegistered, t)
+	}
+	registry[t] = r
+	return nil
+}
+
+// Lookup returns the registration of an endpoint type
+func Lookup(t Type) (Registration, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	r, ok := registry[t]
+	if !ok {
+		return Registration{}, fmt.Errorf("%w: %s", ErrTypeNotRegistered, t)
+	}
+	return r, nil
+}
+
+// RegisteredTypes returns the sorted list of registered endpoint types
+func RegisteredTypes() []Type {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	registered := make([]Type, 0, len(registry))
+	for t := range registry {
+		registered = append(registered, t)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i] < registered[j] })
+	return registered
+}
+
+// New creates an endpoint of the given registered type without probing the cluster,
+// callers building the type from user input should check it with IsSupported first
+func New(ctx context.Context, c client.Client, logger logr.Logger,
+	t Type,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error) {
+	r, err := Lookup(t)
+	if err != nil {
+		return nil, err
+	}
+	return r.New(ctx, c, logger, namespacedName, options)
+}
+
+// IsSupported returns whether the cluster supports the given registered endpoint type
+func IsSupported(ctx context.Context, c client.Client, t Type, options Options) (bool, error) {
+	r, err := Lookup(t)
+	if err != nil {
+		return false, err
+	}
+	if r.Supported == nil {
+		return true, nil
+	}
+	return r.Supported(ctx, c, options)
+}
+
+func newRoute(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error) {
+	var hostname *string
+	if options.Hostname != "" {
+		hostname = &options.Hostname
+	}
+	return route.NewWithOptions(ctx, c, logger, namespacedName, route.EndpointTypePassthrough, route.Options{
+		Hostname:        hostname,
+		Labels:          options.Labels,
+		OwnerReferences: options.OwnerReferences,
+		BackendPort:     options.BackendPort,
+		ExternalDNS:     options.ExternalDNS,
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	})
+}
+
+func newIngress(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error) {
+	return ingress.NewWithOptions(ctx, c, logger, namespacedName, ingress.Options{
+		IngressClassName: options.IngressClassName,
+		Subdomain:        options.Subdomain,
+		Labels:           options.Labels,
+		OwnerReferences:  options.OwnerReferences,
+		Profile:          options.IngressProfile,
+		TLSSecretName:    options.IngressTLSSecretName,
+		ExternalDNS:      options.ExternalDNS,
+		ServerSideApply:  options.ServerSideApply,
+		FieldManager:     options.FieldManager,
+		Adopt:            options.Adopt,
+	})
+}
+
+func newService(svcType corev1.ServiceType) Constructor {
+	return func(ctx context.Context, c client.Client, logger logr.Logger,
+		namespacedName types.NamespacedName,
+		options Options) (endpoint.Endpoint, error) {
+		return service.NewWithOptions(ctx, c, logger, namespacedName, service.Options{
+			BackendPort:     portOrDefault(options.BackendPort, defaultBackendPort),
+			IngressPort:     portOrDefault(options.IngressPort, defaultIngressPort),
+			Type:            svcType,
+			Labels:          options.Labels,
+			OwnerReferences: options.OwnerReferences,
+			ExternalDNS:     options.ExternalDNS,
+			ServerSideApply: options.ServerSideApply,
+			FieldManager:    options.FieldManager,
+			Adopt:           options.Adopt,
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/endpoint/external"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRegister(t *testing.T) {
+	newExternal := func(ctx context.Context, c client.Client, logger logr.Logger,
+		namespacedName types.NamespacedName,
+		options Options) (endpoint.Endpoint, error) {
+		return external.New(namespacedName, "test.example.com", 443, portOrDefault(options.BackendPort, defaultBackendPort))
+	}
+	tests := []struct {
+		name         string
+		endpointType Type
+		registration Registration
+		wantErr      error
+	}{
+		{
+			name:         "new endpoint type, must be created by New",
+			endpointType: "TestExternal",
+			registration: Registration{New: newExternal},
+		},
+		{
+			name:         "built-in endpoint type, must return ErrTypeAlreadyRegistered",
+			endpointType: TypeRoute,
+			registration: Registration{New: newExternal},
+			wantErr:      ErrTypeAlreadyRegistered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Register(tt.endpointType, tt.registration)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Register() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			supported, err := IsSupported(context.Background(), fakeClient(false), tt.endpointType, Options{})
+			if err != nil || !supported {
+				t.Errorf("IsSupported() = %v, %v, want true", supported, err)
+			}
+			e, err := New(context.Background(), fakeClient(false), testr.New(t), tt.endpointType,
+				types.NamespacedName{Namespace: "bar", Name: "foo"}, Options{})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if e.Hostname() != "test.example.com" {
+				t.Errorf("Hostname() = %v, want test.example.com", e.Hostname())
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpointType Type
+		want         Capabilities
+		wantErr      error
+	}{
+		{
+			name:         "route endpoint, must require the route API",
+			endpointType: TypeRoute,
+			want:         Capabilities{RequiresRouteAPI: true},
+		},
+		{
+			name:         "load balancer endpoint, must require a load balancer",
+			endpointType: TypeLoadBalancer,
+			want:         Capabilities{RequiresLoadBalancer: true},
+		},
+		{
+			name:         "node port endpoint, must not require anything",
+			endpointType: TypeNodePort,
+			want:         Capabilities{},
+		},
+		{
+			name:         "unknown endpoint, must return ErrTypeNotRegistered",
+			endpointType: "Unknown",
+			wantErr:      ErrTypeNotRegistered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lookup(tt.endpointType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got.Capabilities, tt.want) {
+				t.Errorf("Lookup() capabilities = %+v, want %+v", got.Capabilities, tt.want)
+			}
+		})
+	}
+}
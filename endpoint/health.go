@@ -0,0 +1,84 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// HealthReason is a machine readable reason for the health of an endpoint
+type HealthReason string
+
+const (
+	// HealthReasonHealthy is reported once the endpoint accepts connections
+	HealthReasonHealthy HealthReason = "Healthy"
+	// HealthReasonProvisioning is reported while the cluster provisions the resources of the
+	// endpoint, e.g. the address of a load balancer or the endpoints of a service
+	HealthReasonProvisioning HealthReason = "Provisioning"
+	// HealthReasonAwaitingAdmission is reported while the router or the ingress controller
+	// has not admitted the endpoint
+	HealthReasonAwaitingAdmission HealthReason = "AwaitingAdmission"
+	// HealthReasonDNSNotResolved is reported while the hostname of the endpoint does not resolve
+	HealthReasonDNSNotResolved HealthReason = "DNSNotResolved"
+	// HealthReasonMisconfigured is reported when the endpoint cannot become healthy without
+	// changes from the user, e.g. a route rejected by the router
+	HealthReasonMisconfigured HealthReason = "Misconfigured"
+)
+
+// Health is the detailed health of an endpoint
+type Health struct {
+	Healthy bool
+	Reason  HealthReason
+	// Detail refines Reason with a machine readable reason specific to the type of the
+	// endpoint, e.g. ingress.HealthDetailIngressClassNotFound. It is empty when healthy
+	Detail  string
+	Message string
+}
+
+// HealthReporter is implemented by endpoints to explain why they are unhealthy, use
+// GetHealth to get the health of any endpoint
+type HealthReporter interface {
+	// Health returns the health of the endpoint, errors are only returned when the
+	// health cannot be determined
+	Health(ctx context.Context, c client.Client) (*Health, error)
+}
+
+// Healthy returns the health of an endpoint accepting connections
+func Healthy() *Health {
+	return &Health{Healthy: true, Reason: HealthReasonHealthy}
+}
+
+// Unhealthy returns the health of an endpoint not accepting connections for the given reason
+func Unhealthy(reason HealthReason, format string, args ...interface{}) *Health {
+	return &Health{
+		Healthy: false,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// IsExpected returns whether the endpoint is expected to become healthy without changes
+// from the user
+func (h *Health) IsExpected() bool {
+	return h.Healthy || h.Reason != HealthReasonMisconfigured
+}
+
+// GetHealth returns the health of the endpoint. Endpoints not implementing HealthReporter
+// are reported as Provisioning while IsHealthy returns false or ErrEndpointNotReady.
+func GetHealth(ctx context.Context, c client.Client, e Endpoint) (*Health, error) {
+	if r, ok := e.(HealthReporter); ok {
+		return r.Health(ctx, c)
+	}
+	healthy, err := e.IsHealthy(ctx, c)
+	switch {
+	case errors.Is(err, ErrEndpointNotReady):
+		return Unhealthy(HealthReasonProvisioning, "%v", err), nil
+	case err != nil:
+		return nil, err
+	case !healthy:
+		return Unhealthy(HealthReasonProvisioning, "endpoint %s is not healthy yet", e.NamespacedName()), nil
+	}
+	return Healthy(), nil
+}
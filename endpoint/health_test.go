@@ -0,0 +1,80 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeHealthReporter struct {
+	fakeEndpoint
+	health *Health
+}
+
+func (f *fakeHealthReporter) Health(ctx context.Context, c client.Client) (*Health, error) {
+	return f.health, nil
+}
+
+func TestGetHealth(t *testing.T) {
+	tests := []struct {
+		name         string
+		endpoint     Endpoint
+		wantReason   HealthReason
+		wantExpected bool
+		wantErr      bool
+	}{
+		{
+			name:         "healthy endpoint, must report Healthy",
+			endpoint:     &fakeEndpoint{},
+			wantReason:   HealthReasonHealthy,
+			wantExpected: true,
+		},
+		{
+			name:         "unhealthy endpoint, must report Provisioning",
+			endpoint:     &fakeEndpoint{unhealthy: true},
+			wantReason:   HealthReasonProvisioning,
+			wantExpected: true,
+		},
+		{
+			name:         "endpoint not ready, must report Provisioning",
+			endpoint:     &fakeEndpoint{healthErr: fmt.Errorf("%w: route not admitted", ErrEndpointNotReady)},
+			wantReason:   HealthReasonProvisioning,
+			wantExpected: true,
+		},
+		{
+			name:     "endpoint health unknown, must return the error",
+			endpoint: &fakeEndpoint{healthErr: errors.New("connection refused")},
+			wantErr:  true,
+		},
+		{
+			name:         "health reporter, must report the health of the endpoint",
+			endpoint:     &fakeHealthReporter{health: Unhealthy(HealthReasonMisconfigured, "route rejected")},
+			wantReason:   HealthReasonMisconfigured,
+			wantExpected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetHealth(context.Background(), fake.NewClientBuilder().Build(), tt.endpoint)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetHealth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Reason != tt.wantReason {
+				t.Errorf("GetHealth() reason = %v, want %v", got.Reason, tt.wantReason)
+			}
+			if got.Healthy != (tt.wantReason == HealthReasonHealthy) {
+				t.Errorf("GetHealth() healthy = %v for reason %v", got.Healthy, got.Reason)
+			}
+			if got.IsExpected() != tt.wantExpected {
+				t.Errorf("IsExpected() = %v, want %v", got.IsExpected(), tt.wantExpected)
+			}
+		})
+	}
+}
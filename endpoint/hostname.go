@@ -0,0 +1,99 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// pinnedHostnameKey is the key of the pinned hostname in the configmap returned by PinnedHostnameName
+	pinnedHostnameKey = "hostname"
+)
+
+var (
+	// ErrHostnameChanged is returned when the hostname of an endpoint differs from the
+	// hostname pinned once the transfer began, e.g. after the endpoint was recreated
+	ErrHostnameChanged = errors.New("endpoint hostname changed")
+)
+
+// PinOptions configure the configmap holding a pinned hostname
+type PinOptions struct {
+	// Labels are applied to the configmap, they are expected to be the labels of the endpoint
+	// so that the configmap is cleaned up with it
+	Labels map[string]string
+	// OwnerReferences are applied to the configmap
+	OwnerReferences []metav1.OwnerReference
+	// Reconcile determines how the configmap is written to the apiserver
+	Reconcile reconcile.Options
+}
+
+// PinnedHostnameName returns the configmap holding the pinned hostname of an endpoint
+func PinnedHostnameName(namespacedName types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: namespacedName.Namespace,
+		Name:      fmt.Sprintf("%s-hostname", namespacedName.Name),
+	}
+}
+
+// PinnedHostname returns the hostname pinned for the endpoint with the given name, it is
+// empty when no hostname was pinned
+func PinnedHostname(ctx context.Context, c client.Client, namespacedName types.NamespacedName) (string, error) {
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, PinnedHostnameName(namespacedName), cm)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return "", nil
+	case err != nil:
+		return "", err
+	}
+	return cm.Data[pinnedHostnameKey], nil
+}
+
+// PinHostname persists the hostname of the endpoint the first time it is called, callers are
+// expected to call it once a transfer begins. Later calls return an error wrapping
+// ErrHostnameChanged when the endpoint no longer reports the pinned hostname, as clients
+// would then connect to another host or fail to verify its certificate.
+func PinHostname(ctx context.Context, c client.Client, logger logr.Logger, e Endpoint, options PinOptions) error {
+	if e.Hostname() == "" {
+		return fmt.Errorf("%w: hostname of endpoint %s is not set", ErrEndpointNotReady, e.NamespacedName())
+	}
+	pinned, err := PinnedHostname(ctx, c, e.NamespacedName())
+	if err != nil {
+		return err
+	}
+	switch pinned {
+	case e.Hostname():
+		return nil
+	case "":
+	default:
+		return fmt.Errorf("%w: endpoint %s reports hostname %s, the transfer began with %s",
+			ErrHostnameChanged, e.NamespacedName(), e.Hostname(), pinned)
+	}
+
+	logger.Info("pinning endpoint hostname", "endpoint", e.NamespacedName(), "hostname", e.Hostname())
+	key := PinnedHostnameName(e.NamespacedName())
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, cm, options.Reconcile, func() error {
+		cm.Labels = options.Labels
+		cm.OwnerReferences = options.OwnerReferences
+		cm.Data = map[string]string{
+			pinnedHostnameKey: e.Hostname(),
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,114 @@
+package endpoint
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeEndpoint struct {
+	hostname  string
+	unhealthy bool
+	healthErr error
+}
+
+func (f *fakeEndpoint) NamespacedName() types.NamespacedName {
+	return types.NamespacedName{Namespace: "foo", Name: "bar"}
+}
+
+func (f *fakeEndpoint) Hostname() string {
+	return f.hostname
+}
+
+func (f *fakeEndpoint) BackendPort() int32 {
+	return 6443
+}
+
+func (f *fakeEndpoint) IngressPort() int32 {
+	return 443
+}
+
+func (f *fakeEndpoint) IsHealthy(ctx context.Context, c client.Client) (bool, error) {
+	return !f.unhealthy && f.healthErr == nil, f.healthErr
+}
+
+func (f *fakeEndpoint) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
+	return nil
+}
+
+func TestPinHostname(t *testing.T) {
+	tests := []struct {
+		name     string
+		pinned   string
+		hostname string
+		wantErr  error
+	}{
+		{
+			name:     "no pinned hostname, must pin the hostname",
+			hostname: "a.example.com",
+		},
+		{
+			name:     "same pinned hostname, must be valid",
+			pinned:   "a.example.com",
+			hostname: "a.example.com",
+		},
+		{
+			name:     "different pinned hostname, must return ErrHostnameChanged",
+			pinned:   "a.example.com",
+			hostname: "b.example.com",
+			wantErr:  ErrHostnameChanged,
+		},
+		{
+			name:    "hostname not assigned yet, must return ErrEndpointNotReady",
+			wantErr: ErrEndpointNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := fake.NewClientBuilder().Build()
+			logger := testr.New(t)
+			if tt.pinned != "" {
+				err := PinHostname(ctx, c, logger, &fakeEndpoint{hostname: tt.pinned}, PinOptions{})
+				if err != nil {
+					t.Fatalf("unable to pin hostname %v", err)
+				}
+			}
+			e := &fakeEndpoint{hostname: tt.hostname}
+			labels := map[string]string{"app": "test"}
+			err := PinHostname(ctx, c, logger, e, PinOptions{Labels: labels})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("PinHostname() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			pinned, err := PinnedHostname(ctx, c, e.NamespacedName())
+			if err != nil || pinned != tt.hostname {
+				t.Errorf("PinnedHostname() = %v, %v, want %v", pinned, err, tt.hostname)
+			}
+			cm := &corev1.ConfigMap{}
+			err = c.Get(ctx, PinnedHostnameName(e.NamespacedName()), cm)
+			if err != nil {
+				t.Fatalf("unable to get pinned hostname configmap %v", err)
+			}
+			if tt.pinned == "" && cm.Labels["app"] != "test" {
+				t.Errorf("pinned hostname configmap labels = %v, want %v", cm.Labels, labels)
+			}
+		})
+	}
+}
+
+func TestPinnedHostname_notPinned(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	pinned, err := PinnedHostname(context.Background(), c, types.NamespacedName{Namespace: "foo", Name: "bar"})
+	if err != nil || pinned != "" {
+		t.Errorf("PinnedHostname() = %v, %v, want no hostname", pinned, err)
+	}
+}
@@ -0,0 +1,224 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultIngressClassAnnotation = "ingressclass.kubernetes.io/is-default-class"
+	legacyIngressClassAnnotation  = "kubernetes.io/ingress.class"
+	defaultProbeTimeout           = 5 * time.Second
+)
+
+// Details of the health of the ingress endpoint, they refine the reason of endpoint.Health
+const (
+	// HealthDetailHostNotSet is reported when the ingress has no host in its rules
+	HealthDetailHostNotSet = "HostNotSet"
+	// HealthDetailIngressClassNotFound is reported when the ingress class of the ingress
+	// does not exist, or when no class is set and the cluster has no default ingress class
+	HealthDetailIngressClassNotFound = "IngressClassNotFound"
+	// HealthDetailNotAdmitted is reported when the ingress controller has not admitted
+	// the resource yet
+	HealthDetailNotAdmitted = "NotAdmitted"
+	// HealthDetailNoServiceEndpoints is reported when the backend service has no ready endpoints
+	HealthDetailNoServiceEndpoints = "NoServiceEndpoints"
+	// HealthDetailHostnameNotResolved is reported while the external-dns hostname of the
+	// endpoint does not resolve
+	HealthDetailHostnameNotResolved = "HostnameNotResolved"
+	// HealthDetailProbeFailed is reported when the TCP probe to the hostname of the endpoint fails
+	HealthDetailProbeFailed = "ProbeFailed"
+)
+
+func unhealthy(reason endpoint.HealthReason, detail string, format string, args ...interface{}) *endpoint.Health {
+	health := endpoint.Unhealthy(reason, format, args...)
+	health.Detail = detail
+	return health
+}
+
+// Health checks that the ingress was admitted by the ingress controller serving its class,
+// that the backend service has ready endpoints and optionally probes the hostname. The detail
+// of the health is one of the HealthDetail constants. Errors are only returned when the
+// health cannot be determined.
+func (i *ingress) Health(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	svc := &corev1.Service{}
+	err := c.Get(ctx, i.NamespacedName(), svc)
+	if err != nil {
+		i.logger.Error(err, "failed to get service")
+		return nil, err
+	}
+
+	var health *endpoint.Health
+	if i.profile.usesIngress() {
+		health, err = i.ingressHealth(ctx, c)
+	} else {
+		health, err = i.proxyHealth(ctx, c)
+	}
+	if err != nil || health != nil {
+		return health, err
+	}
+
+	health, err = i.serviceEndpointsHealth(ctx, c)
+	if err != nil || health != nil {
+		return health, err
+	}
+
+	if i.externalDNS != nil {
+		health, err = i.externalDNSHealth(ctx)
+		if err != nil || health != nil {
+			return health, err
+		}
+	}
+
+	if i.tcpProbe {
+		health = i.probeHealth(ctx)
+		if health != nil {
+			return health, nil
+		}
+	}
+
+	return endpoint.Healthy(), nil
+}
+
+// ingressHealth returns the health of the ingress if it is not yet usable, nil otherwise
+func (i *ingress) ingressHealth(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	ingress := &networkingv1.Ingress{}
+	err := c.Get(ctx, i.NamespacedName(), ingress)
+	if err != nil {
+		i.logger.Error(err, "failed to get ingress")
+		return nil, err
+	}
+	if len(ingress.Spec.Rules) > 0 && ingress.Spec.Rules[0].Host == "" {
+		return unhealthy(endpoint.HealthReasonProvisioning, HealthDetailHostNotSet, "host not set for ingress %s", i.NamespacedName()), nil
+	}
+
+	resolved, err := isIngressClassResolved(ctx, c, ingress)
+	if err != nil {
+		return nil, err
+	}
+	if !resolved {
+		return unhealthy(endpoint.HealthReasonMisconfigured, HealthDetailIngressClassNotFound, "ingress class for ingress %s not found", i.NamespacedName()), nil
+	}
+
+	for _, lbIngress := range ingress.Status.LoadBalancer.Ingress {
+		if lbIngress.Hostname != "" || lbIngress.IP != "" {
+			return nil, nil
+		}
+	}
+	return unhealthy(endpoint.HealthReasonAwaitingAdmission, HealthDetailNotAdmitted, "ingress %s not admitted by the ingress controller", i.NamespacedName()), nil
+}
+
+// proxyHealth returns the health of the proxy resource of the Traefik and Contour profiles
+// if it is not yet usable, nil otherwise
+func (i *ingress) proxyHealth(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	_, err := i.isProxyHealthy(ctx, c)
+	switch {
+	case errors.Is(err, endpoint.ErrEndpointNotReady):
+		return unhealthy(endpoint.HealthReasonAwaitingAdmission, HealthDetailNotAdmitted, "%v", err), nil
+	case err != nil:
+		return nil, err
+	}
+	return nil, nil
+}
+
+// IsIngressClassAvailable checks that the given ingress class exists, or that the cluster has a
+// default ingress class serving ingresses without a class when no class is given
+func IsIngressClassAvailable(ctx context.Context, c client.Client, ingressClassName *string) (bool, error) {
+	if ingressClassName != nil && *ingressClassName != "" {
+		err := c.Get(ctx, client.ObjectKey{Name: *ingressClassName}, &networkingv1.IngressClass{})
+		switch {
+		case k8serrors.IsNotFound(err):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+		return true, nil
+	}
+
+	ingressClasses := &networkingv1.IngressClassList{}
+	err := c.List(ctx, ingressClasses)
+	if err != nil {
+		return false, err
+	}
+	for _, ingressClass := range ingressClasses.Items {
+		if ingressClass.Annotations[defaultIngressClassAnnotation] == "true" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// isIngressClassResolved checks that the class of the ingress exists. Ingresses without
+// a class are served by the default ingress class of the cluster, if there is one
+func isIngressClassResolved(ctx context.Context, c client.Client, ingress *networkingv1.Ingress) (bool, error) {
+	if _, ok := ingress.Annotations[legacyIngressClassAnnotation]; ok {
+		return true, nil
+	}
+	return IsIngressClassAvailable(ctx, c, ingress.Spec.IngressClassName)
+}
+
+// serviceEndpointsHealth returns the health of the ingress if the backend service has no ready
+// endpoints, nil otherwise
+func (i *ingress) serviceEndpointsHealth(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	endpoints := &corev1.Endpoints{}
+	err := c.Get(ctx, i.NamespacedName(), endpoints)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return unhealthy(endpoint.HealthReasonProvisioning, HealthDetailNoServiceEndpoints, "endpoints for service %s not found", i.NamespacedName()), nil
+	case err != nil:
+		i.logger.Error(err, "failed to get endpoints")
+		return nil, err
+	}
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return nil, nil
+		}
+	}
+	return unhealthy(endpoint.HealthReasonProvisioning, HealthDetailNoServiceEndpoints, "service %s has no ready endpoints", i.NamespacedName()), nil
+}
+
+// externalDNSHealth returns the health of the ingress if the external-dns hostname does not
+// resolve, nil otherwise
+func (i *ingress) externalDNSHealth(ctx context.Context) (*endpoint.Health, error) {
+	resolves, err := i.externalDNS.Resolves(ctx)
+	if err != nil {
+		i.logger.Error(err, "unable to resolve external-dns hostname", "hostname", i.externalDNS.Hostname)
+		return nil, err
+	}
+	if !resolves {
+		return unhealthy(endpoint.HealthReasonDNSNotResolved, HealthDetailHostnameNotResolved, "hostname %s does not resolve yet", i.externalDNS.Hostname), nil
+	}
+	return nil, nil
+}
+
+// probeHealth returns the health of the ingress if a TCP connection to the endpoint cannot be
+// established, nil otherwise
+func (i *ingress) probeHealth(ctx context.Context) *endpoint.Health {
+	timeout := i.probeTimeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	address := net.JoinHostPort(i.Hostname(), strconv.Itoa(int(i.IngressPort())))
+	dial := i.dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return unhealthy(endpoint.HealthReasonProvisioning, HealthDetailProbeFailed, "unable to connect to %s: %v", address, err)
+	}
+	conn.Close()
+	return nil
+}
@@ -0,0 +1,179 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testDefaultIngressClass() *networkingv1.IngressClass {
+	return &networkingv1.IngressClass{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{defaultIngressClassAnnotation: "true"},
+		},
+	}
+}
+
+func testEndpoints(name, namespace string) *corev1.Endpoints {
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Subsets: []corev1.EndpointSubset{
+			{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}},
+		},
+	}
+}
+
+func testAdmittedIngress(ingressClassName *string) *networkingv1.Ingress {
+	return &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"},
+		Spec: networkingv1.IngressSpec{
+			IngressClassName: ingressClassName,
+			Rules:            []networkingv1.IngressRule{{Host: "test-test-ns.test.net"}},
+		},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.0.0"}}},
+		},
+	}
+}
+
+func Test_ingress_Health(t *testing.T) {
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"}}
+	tests := []struct {
+		name       string
+		objects    []client.Object
+		tcpProbe   bool
+		dialErr    error
+		resolves   *bool
+		wantReason endpoint.HealthReason
+		wantDetail string
+	}{
+		{
+			name: "ingress without host, must report HostNotSet",
+			objects: []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"),
+				&networkingv1.Ingress{
+					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"},
+					Spec:       networkingv1.IngressSpec{Rules: []networkingv1.IngressRule{{}}},
+				}},
+			wantReason: endpoint.HealthReasonProvisioning,
+			wantDetail: HealthDetailHostNotSet,
+		},
+		{
+			name:       "ingress class does not exist, must report IngressClassNotFound",
+			objects:    []client.Object{svc, testEndpoints("test", "test-ns"), testAdmittedIngress(pointer.String("missing"))},
+			wantReason: endpoint.HealthReasonMisconfigured,
+			wantDetail: HealthDetailIngressClassNotFound,
+		},
+		{
+			name:       "no ingress class and no default ingress class, must report IngressClassNotFound",
+			objects:    []client.Object{svc, testEndpoints("test", "test-ns"), testAdmittedIngress(nil)},
+			wantReason: endpoint.HealthReasonMisconfigured,
+			wantDetail: HealthDetailIngressClassNotFound,
+		},
+		{
+			name: "ingress without loadbalancer status, must report NotAdmitted",
+			objects: []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"),
+				&networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"}}},
+			wantReason: endpoint.HealthReasonAwaitingAdmission,
+			wantDetail: HealthDetailNotAdmitted,
+		},
+		{
+			name:       "service without endpoints, must report NoServiceEndpoints",
+			objects:    []client.Object{svc, testDefaultIngressClass(), testAdmittedIngress(nil)},
+			wantReason: endpoint.HealthReasonProvisioning,
+			wantDetail: HealthDetailNoServiceEndpoints,
+		},
+		{
+			name: "ingress with existing class and endpoints, must report Healthy",
+			objects: []client.Object{svc, testEndpoints("test", "test-ns"), testAdmittedIngress(pointer.String("nginx")),
+				&networkingv1.IngressClass{ObjectMeta: metav1.ObjectMeta{Name: "nginx"}}},
+			wantReason: endpoint.HealthReasonHealthy,
+		},
+		{
+			name:       "tcp probe fails, must report ProbeFailed",
+			objects:    []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"), testAdmittedIngress(nil)},
+			tcpProbe:   true,
+			dialErr:    errors.New("connection refused"),
+			wantReason: endpoint.HealthReasonProvisioning,
+			wantDetail: HealthDetailProbeFailed,
+		},
+		{
+			name:       "tcp probe succeeds, must report Healthy",
+			objects:    []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"), testAdmittedIngress(nil)},
+			tcpProbe:   true,
+			dialErr:    nil,
+			wantReason: endpoint.HealthReasonHealthy,
+		},
+		{
+			name:       "external-dns hostname does not resolve, must report HostnameNotResolved",
+			objects:    []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"), testAdmittedIngress(nil)},
+			resolves:   pointer.Bool(false),
+			wantReason: endpoint.HealthReasonDNSNotResolved,
+			wantDetail: HealthDetailHostnameNotResolved,
+		},
+		{
+			name:       "external-dns hostname resolves, must report Healthy",
+			objects:    []client.Object{svc, testDefaultIngressClass(), testEndpoints("test", "test-ns"), testAdmittedIngress(nil)},
+			tcpProbe:   true,
+			resolves:   pointer.Bool(true),
+			wantReason: endpoint.HealthReasonHealthy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var dialedAddress string
+			i := &ingress{
+				logger:         testr.New(t),
+				namespacedName: types.NamespacedName{Name: "test", Namespace: "test-ns"},
+				subdomain:      "test.net",
+				ingressPort:    ingressPort,
+				tcpProbe:       tt.tcpProbe,
+				dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+					dialedAddress = address
+					if tt.dialErr != nil {
+						return nil, tt.dialErr
+					}
+					client, server := net.Pipe()
+					server.Close()
+					return client, nil
+				},
+			}
+			if tt.resolves != nil {
+				i.externalDNS = &endpoint.ExternalDNS{
+					Hostname: "test-test-ns.test.net",
+					LookupHost: func(ctx context.Context, host string) ([]string, error) {
+						if !*tt.resolves {
+							return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+						}
+						return []string{"10.0.0.0"}, nil
+					},
+				}
+			}
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			got, err := i.Health(context.Background(), c)
+			if err != nil {
+				t.Fatalf("Health() unexpected error %v", err)
+			}
+			if got.Reason != tt.wantReason || got.Detail != tt.wantDetail {
+				t.Errorf("Health() reason = %v, detail = %v, want %v, %v, message %s", got.Reason, got.Detail, tt.wantReason, tt.wantDetail, got.Message)
+			}
+			if got.Healthy != (tt.wantReason == endpoint.HealthReasonHealthy) {
+				t.Errorf("Health() healthy = %v for reason %v", got.Healthy, got.Reason)
+			}
+			if tt.tcpProbe && dialedAddress != "test-test-ns.test.net:443" {
+				t.Errorf("TCP probe dialed %s, want test-test-ns.test.net:443", dialedAddress)
+			}
+		})
+	}
+}
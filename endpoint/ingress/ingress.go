@@ -3,8 +3,11 @@ package ingress
 import (
 	"context"
 	"fmt"
+	"net"
+	"time"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/internal/utils"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -14,7 +17,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
@@ -37,6 +39,55 @@ type ingress struct {
 	backendPort        int32
 	ingressClassName   *string
 	subdomain          string
+	profile            IngressControllerProfile
+	tlsSecretName      string
+	externalDNS        *endpoint.ExternalDNS
+	tcpProbe           bool
+	probeTimeout       time.Duration
+	reconcileOptions   reconcile.Options
+
+	// dial is used by the TCP probe, overridden in tests
+	dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Options allows callers to configure the ingress endpoint created by NewWithOptions
+type Options struct {
+	// IngressClassName is the class of the ingress, when nil the default ingress class is used
+	IngressClassName *string
+	// Subdomain is the subdomain used to build the hostname of the ingress, it is only
+	// optional when ExternalDNS is set
+	Subdomain string
+	// Labels are applied to the ingress and the service, they are also used as the service selector
+	Labels map[string]string
+	// Annotations are applied to the ingress
+	Annotations map[string]string
+	// Profile configures TLS passthrough for the given ingress controller, when empty
+	// passthrough must be configured by the callers using Annotations
+	Profile IngressControllerProfile
+	// TLSSecretName is a kubernetes.io/tls secret in the namespace of the ingress holding the
+	// certificate served by the ingress controller for the hostname of the endpoint, e.g. a
+	// wildcard certificate of Subdomain. It requires the ingress controller to terminate TLS
+	// and cannot be used with the passthrough profiles.
+	TLSSecretName string
+	// ExternalDNS annotates the ingress for external-dns to publish a DNS name for it. The name
+	// is used as the hostname of the ingress instead of the name built from Subdomain, the
+	// ingress is healthy once the name resolves
+	ExternalDNS *endpoint.ExternalDNS
+	// TCPProbe enables a TCP connection probe to the hostname of the endpoint in the
+	// health checks, it requires the hostname to be resolvable by the caller
+	TCPProbe bool
+	// ProbeTimeout is the timeout for the TCP probe, defaults to 5s
+	ProbeTimeout time.Duration
+	// OwnerReferences are applied to the ingress and the service
+	OwnerReferences []metav1.OwnerReference
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate,
+	// see the ServerSideApply field of reconcile.Options
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply, defaults to "pvc-transfer"
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
 }
 
 func (i *ingress) NamespacedName() types.NamespacedName {
@@ -44,6 +95,9 @@ func (i *ingress) NamespacedName() types.NamespacedName {
 }
 
 func (i *ingress) Hostname() string {
+	if i.externalDNS != nil {
+		return i.externalDNS.Hostname
+	}
 	prefix := fmt.Sprintf("%s-%s",
 		i.namespacedName.Name,
 		i.namespacedName.Namespace)
@@ -63,32 +117,17 @@ func (i *ingress) IngressPort() int32 {
 }
 
 func (i *ingress) IsHealthy(ctx context.Context, c client.Client) (bool, error) {
-	svc := &corev1.Service{}
-	err := c.Get(ctx, i.NamespacedName(), svc)
+	health, err := i.Health(ctx, c)
 	if err != nil {
-		i.logger.Error(err, "failed to get service")
 		return false, err
 	}
-
-	ingress := &networkingv1.Ingress{}
-	err = c.Get(ctx, i.NamespacedName(), ingress)
-	if err != nil {
-		i.logger.Error(err, "failed to get ingress")
-		return false, err
+	if health.Detail == HealthDetailHostNotSet {
+		return false, fmt.Errorf("%w: %s", endpoint.ErrEndpointNotReady, health.Message)
 	}
-	if len(ingress.Spec.Rules) > 0 && ingress.Spec.Rules[0].Host == "" {
-		return false, fmt.Errorf("host not set for ingress: %s", ingress)
-	}
-	if len(ingress.Status.LoadBalancer.Ingress) > 0 {
-		if ingress.Status.LoadBalancer.Ingress[0].Hostname != "" {
-			return true, nil
-		}
-		if ingress.Status.LoadBalancer.Ingress[0].IP != "" {
-			return true, nil
-		}
+	if !health.Healthy {
+		i.logger.Info("endpoint is unhealthy", "reason", health.Reason, "detail", health.Detail, "message", health.Message)
 	}
-	i.logger.Info("endpoint is unhealthy")
-	return false, nil
+	return health.Healthy, nil
 }
 
 func (i *ingress) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
@@ -104,12 +143,15 @@ func (i *ingress) MarkForCleanup(ctx context.Context, c client.Client, key, valu
 		i.logger.Error(err, "failed to mark endpoint svc for cleanup", "svc", i)
 		return err
 	}
-	ingress := &networkingv1.Ingress{
+	var ingress client.Object = &networkingv1.Ingress{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      i.namespacedName.Name,
 			Namespace: i.namespacedName.Namespace,
 		},
 	}
+	if !i.profile.usesIngress() {
+		ingress = i.proxyObject()
+	}
 	err = utils.UpdateWithLabel(ctx, c, ingress, key, value)
 	if err != nil {
 		i.logger.Error(err, "failed to mark endpoint ingress for cleanup", "ingress", i)
@@ -139,40 +181,110 @@ func APIsToWatch() ([]client.Object, error) {
 // In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
 func New(ctx context.Context, c client.Client, logger logr.Logger,
 	namespacedName types.NamespacedName,
 	ingressClassName *string,
 	subdomain string,
 	labels, ingressAnnotations map[string]string,
 	ownerReferences []metav1.OwnerReference) (endpoint.Endpoint, error) {
+	return NewWithOptions(ctx, c, logger, namespacedName, Options{
+		IngressClassName: ingressClassName,
+		Subdomain:        subdomain,
+		Labels:           labels,
+		Annotations:      ingressAnnotations,
+		OwnerReferences:  ownerReferences,
+	})
+}
+
+// NewWithOptions creates the ingress endpoint object using the given options, refer New
+// for details on using the endpoint.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingresses,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=ingressclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=endpoints,verbs=get;list;watch
+//
+// When using the Traefik or Contour profiles, add the following line as well and call
+// APIsToWatchForProfile instead of APIsToWatch.
+// +kubebuilder:rbac:groups=traefik.containo.us,resources=ingressroutetcps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=projectcontour.io,resources=httpproxies,verbs=get;list;watch;create;update;patch;delete
+//
+// When using TLSSecretName, add the following line as well.
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+func NewWithOptions(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error) {
 	ingressLogger := logger.WithValues("ingress", namespacedName)
 
 	ingressEndpoint := &ingress{
 		logger:             ingressLogger,
 		namespacedName:     namespacedName,
-		labels:             labels,
-		ingressAnnotations: ingressAnnotations,
-		ownerReferences:    ownerReferences,
+		labels:             options.Labels,
+		ingressAnnotations: endpoint.MergeAnnotations(options.Annotations, options.ExternalDNS),
+		ownerReferences:    options.OwnerReferences,
 		backendPort:        backendPort,
 		ingressPort:        ingressPort,
-		ingressClassName:   ingressClassName,
-		subdomain:          subdomain,
+		ingressClassName:   options.IngressClassName,
+		subdomain:          options.Subdomain,
+		profile:            options.Profile,
+		tlsSecretName:      options.TLSSecretName,
+		externalDNS:        options.ExternalDNS,
+		tcpProbe:           options.TCPProbe,
+		probeTimeout:       options.ProbeTimeout,
+		reconcileOptions: reconcile.Options{
+			ServerSideApply: options.ServerSideApply,
+			FieldManager:    options.FieldManager,
+			Adopt:           options.Adopt,
+		},
 	}
 
-	if ingressClassName == nil || *ingressClassName == "" {
+	if options.IngressClassName == nil || *options.IngressClassName == "" {
 		ingressLogger.Info("ingress class not specified, using default ingress class in the cluster")
 	}
 
-	if subdomain == "" {
+	if options.ExternalDNS != nil {
+		err := options.ExternalDNS.Validate()
+		if err != nil {
+			return nil, err
+		}
+	} else if options.Subdomain == "" {
 		return nil, fmt.Errorf("subdomain cannot be empty")
 	}
 
+	if _, err := APIsToWatchForProfile(options.Profile); err != nil {
+		return nil, err
+	}
+
+	if options.TLSSecretName != "" {
+		if options.Profile != "" {
+			return nil, fmt.Errorf("%w: ingress controller profile %s does not terminate TLS",
+				endpoint.ErrTLSSecretInvalid, options.Profile)
+		}
+		_, err := endpoint.GetTLSSecret(ctx, c, types.NamespacedName{
+			Namespace: namespacedName.Namespace,
+			Name:      options.TLSSecretName,
+		}, ingressEndpoint.Hostname())
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	err := ingressEndpoint.reconcileServiceForIngress(ctx, c)
 	if err != nil {
 		return nil, err
 	}
 
-	err = ingressEndpoint.reconcileIngress(ctx, c)
+	switch options.Profile {
+	case IngressControllerProfileTraefik:
+		err = ingressEndpoint.reconcileIngressRouteTCP(ctx, c)
+	case IngressControllerProfileContour:
+		err = ingressEndpoint.reconcileHTTPProxy(ctx, c)
+	default:
+		err = ingressEndpoint.reconcileIngress(ctx, c)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -189,7 +301,7 @@ func (i *ingress) reconcileServiceForIngress(ctx context.Context, c client.Clien
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, c, service, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, i.logger, service, i.reconcileOptions, func() error {
 		service.Labels = i.labels
 		service.OwnerReferences = i.ownerReferences
 
@@ -221,15 +333,24 @@ func (i *ingress) reconcileIngress(ctx context.Context, c client.Client) error {
 		},
 	}
 	pathType := networkingv1.PathTypePrefix
-	_, err := controllerutil.CreateOrUpdate(ctx, c, ingress, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, i.logger, ingress, i.reconcileOptions, func() error {
 		ingress.Labels = i.labels
 		ingress.OwnerReferences = i.ownerReferences
-		ingress.Annotations = i.ingressAnnotations
+		ingress.Annotations = i.annotations()
 
 		if i.ingressClassName != nil {
 			ingress.Spec.IngressClassName = i.ingressClassName
 		}
 
+		if i.tlsSecretName != "" {
+			ingress.Spec.TLS = []networkingv1.IngressTLS{
+				{
+					Hosts:      []string{i.Hostname()},
+					SecretName: i.tlsSecretName,
+				},
+			}
+		}
+
 		ingress.Spec.Rules = []networkingv1.IngressRule{
 			{
 				Host: i.Hostname(),
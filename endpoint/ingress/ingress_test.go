@@ -2,10 +2,12 @@ package ingress
 
 import (
 	"context"
+	"errors"
 	"reflect"
 	"testing"
 
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
 	networkingv1 "k8s.io/api/networking/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -76,7 +78,7 @@ func Test_ingress_reconcileServiceForIngress(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			i := &ingress{
-				logger:          logrtesting.TestLogger{T: t},
+				logger:          testr.New(t),
 				namespacedName:  tt.fields.namespacedName,
 				labels:          tt.fields.labels,
 				ownerReferences: tt.fields.ownerReferences,
@@ -157,9 +159,11 @@ func Test_ingress_IsHealthy(t *testing.T) {
 				&networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"},
 					Status: networkingv1.IngressStatus{
-						LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{Hostname: "test.net"}}},
+						LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: []networkingv1.IngressLoadBalancerIngress{{Hostname: "test.net"}}},
 					},
 				},
+				testDefaultIngressClass(),
+				testEndpoints("test", "test-ns"),
 			).Build(),
 			want:    true,
 			wantErr: false,
@@ -175,9 +179,11 @@ func Test_ingress_IsHealthy(t *testing.T) {
 				&networkingv1.Ingress{
 					ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "test-ns"},
 					Status: networkingv1.IngressStatus{
-						LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{{IP: "10.0.0.0"}}},
+						LoadBalancer: networkingv1.IngressLoadBalancerStatus{Ingress: []networkingv1.IngressLoadBalancerIngress{{IP: "10.0.0.0"}}},
 					},
 				},
+				testDefaultIngressClass(),
+				testEndpoints("test", "test-ns"),
 			).Build(),
 			want:    true,
 			wantErr: false,
@@ -186,7 +192,7 @@ func Test_ingress_IsHealthy(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			i := &ingress{
-				logger:         logrtesting.TestLogger{T: t},
+				logger:         testr.New(t),
 				namespacedName: tt.namespacedName,
 			}
 			got, err := i.IsHealthy(context.Background(), tt.c)
@@ -277,7 +283,7 @@ func Test_ingress_reconcileIngress(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			i := &ingress{
-				logger:             logrtesting.TestLogger{T: t},
+				logger:             testr.New(t),
 				namespacedName:     tt.fields.namespacedName,
 				labels:             tt.fields.labels,
 				ingressAnnotations: tt.fields.ingressAnnotations,
@@ -311,3 +317,91 @@ func Test_ingress_reconcileIngress(t *testing.T) {
 		})
 	}
 }
+
+func Test_ingress_reconcileIngress_tlsSecret(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	i := &ingress{
+		logger:         testr.New(t),
+		namespacedName: types.NamespacedName{Name: "test", Namespace: "test-ns"},
+		subdomain:      "test.net",
+		tlsSecretName:  "wildcard",
+	}
+	if err := i.reconcileIngress(context.Background(), c); err != nil {
+		t.Fatalf("ingress.reconcileIngress() error = %v", err)
+	}
+	ingress := &networkingv1.Ingress{}
+	err := c.Get(context.Background(), i.namespacedName, ingress)
+	if err != nil {
+		t.Fatalf("unable to get ingress %v", err)
+	}
+	want := []networkingv1.IngressTLS{{Hosts: []string{"test-test-ns.test.net"}, SecretName: "wildcard"}}
+	if !reflect.DeepEqual(ingress.Spec.TLS, want) {
+		t.Errorf("ingress tls = %v, want %v", ingress.Spec.TLS, want)
+	}
+}
+
+func TestNewWithOptions_tlsSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile IngressControllerProfile
+		secret  *corev1.Secret
+		wantErr error
+	}{
+		{
+			name:    "passthrough profile, must return ErrTLSSecretInvalid",
+			profile: IngressControllerProfileNginx,
+			wantErr: endpoint.ErrTLSSecretInvalid,
+		},
+		{
+			name: "secret without a private key, must return ErrTLSSecretInvalid",
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "wildcard", Namespace: "test-ns"},
+				Data:       map[string][]byte{corev1.TLSCertKey: []byte("test-cert")},
+			},
+			wantErr: endpoint.ErrTLSSecretInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			builder := fake.NewClientBuilder()
+			if tt.secret != nil {
+				builder = builder.WithObjects(tt.secret)
+			}
+			_, err := NewWithOptions(context.Background(), builder.Build(), testr.New(t),
+				types.NamespacedName{Name: "test", Namespace: "test-ns"}, Options{
+					Subdomain:     "test.net",
+					Profile:       tt.profile,
+					TLSSecretName: "wildcard",
+				})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_externalDNS(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	e, err := NewWithOptions(context.Background(), c, testr.New(t),
+		types.NamespacedName{Name: "test", Namespace: "test-ns"}, Options{
+			ExternalDNS: &endpoint.ExternalDNS{Hostname: "transfer.example.com", TTL: 60},
+		})
+	if err != nil {
+		t.Fatalf("NewWithOptions() must not require a subdomain with external-dns, got %v", err)
+	}
+	if e.Hostname() != "transfer.example.com" {
+		t.Errorf("Hostname() = %v, want transfer.example.com", e.Hostname())
+	}
+	ingress := &networkingv1.Ingress{}
+	err = c.Get(context.Background(), types.NamespacedName{Name: "test", Namespace: "test-ns"}, ingress)
+	if err != nil {
+		t.Fatalf("unable to get ingress %v", err)
+	}
+	if len(ingress.Spec.Rules) == 0 || ingress.Spec.Rules[0].Host != "transfer.example.com" {
+		t.Errorf("ingress rules = %v, want host transfer.example.com", ingress.Spec.Rules)
+	}
+	if ingress.Annotations[endpoint.ExternalDNSHostnameAnnotation] != "transfer.example.com" ||
+		ingress.Annotations[endpoint.ExternalDNSTTLAnnotation] != "60" {
+		t.Errorf("ingress annotations = %v, want external-dns annotations", ingress.Annotations)
+	}
+}
@@ -0,0 +1,202 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// IngressControllerProfile identifies the ingress controller serving the endpoint. Ingress
+// controllers differ in how TLS passthrough is configured, the profile determines the
+// annotations or the custom resources created for the endpoint.
+type IngressControllerProfile string
+
+const (
+	// IngressControllerProfileNginx configures passthrough for ingress-nginx using
+	// the ssl-passthrough annotation on the Ingress
+	IngressControllerProfileNginx IngressControllerProfile = "nginx"
+	// IngressControllerProfileHAProxy configures passthrough for the HAProxy ingress
+	// controller using the ssl-passthrough annotation on the Ingress
+	IngressControllerProfileHAProxy IngressControllerProfile = "haproxy"
+	// IngressControllerProfileTraefik configures passthrough for Traefik using an
+	// IngressRouteTCP instead of an Ingress
+	IngressControllerProfileTraefik IngressControllerProfile = "traefik"
+	// IngressControllerProfileContour configures passthrough for Contour using an
+	// HTTPProxy instead of an Ingress
+	IngressControllerProfileContour IngressControllerProfile = "contour"
+)
+
+const (
+	HAProxyIngressPassthroughAnnotation = "haproxy.org/ssl-passthrough"
+)
+
+var (
+	traefikIngressRouteTCPGVK = schema.GroupVersionKind{
+		Group:   "traefik.containo.us",
+		Version: "v1alpha1",
+		Kind:    "IngressRouteTCP",
+	}
+	contourHTTPProxyGVK = schema.GroupVersionKind{
+		Group:   "projectcontour.io",
+		Version: "v1",
+		Kind:    "HTTPProxy",
+	}
+)
+
+// APIsToWatchForProfile give a list of APIs to watch if using this package
+// to deploy the endpoint with the given ingress controller profile
+func APIsToWatchForProfile(profile IngressControllerProfile) ([]client.Object, error) {
+	switch profile {
+	case IngressControllerProfileTraefik:
+		return []client.Object{&corev1.Service{}, newUnstructured(traefikIngressRouteTCPGVK)}, nil
+	case IngressControllerProfileContour:
+		return []client.Object{&corev1.Service{}, newUnstructured(contourHTTPProxyGVK)}, nil
+	case "", IngressControllerProfileNginx, IngressControllerProfileHAProxy:
+		return APIsToWatch()
+	default:
+		return nil, fmt.Errorf("unsupported ingress controller profile %s", profile)
+	}
+}
+
+func newUnstructured(gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	return u
+}
+
+// usesIngress returns whether the profile is served using a networking.k8s.io Ingress
+func (p IngressControllerProfile) usesIngress() bool {
+	return p != IngressControllerProfileTraefik && p != IngressControllerProfileContour
+}
+
+// passthroughAnnotations returns the annotations enabling passthrough on the Ingress
+func (p IngressControllerProfile) passthroughAnnotations() map[string]string {
+	switch p {
+	case IngressControllerProfileNginx:
+		return map[string]string{NginxIngressPassthroughAnnotation: "true"}
+	case IngressControllerProfileHAProxy:
+		return map[string]string{HAProxyIngressPassthroughAnnotation: "true"}
+	}
+	return nil
+}
+
+// annotations returns the annotations for the Ingress, annotations passed
+// by the callers take precedence over the ones set by the profile
+func (i *ingress) annotations() map[string]string {
+	profileAnnotations := i.profile.passthroughAnnotations()
+	if len(profileAnnotations) == 0 {
+		return i.ingressAnnotations
+	}
+	annotations := map[string]string{}
+	for key, value := range profileAnnotations {
+		annotations[key] = value
+	}
+	for key, value := range i.ingressAnnotations {
+		annotations[key] = value
+	}
+	return annotations
+}
+
+// reconcileIngressRouteTCP reconciles a Traefik IngressRouteTCP routing TLS connections
+// for the hostname of the endpoint to the service without terminating them
+func (i *ingress) reconcileIngressRouteTCP(ctx context.Context, c client.Client) error {
+	ingressRoute := i.proxyObject()
+
+	_, err := reconcile.CreateOrUpdate(ctx, c, i.logger, ingressRoute, i.reconcileOptions, func() error {
+		ingressRoute.SetLabels(i.labels)
+		ingressRoute.SetAnnotations(i.ingressAnnotations)
+		ingressRoute.SetOwnerReferences(i.ownerReferences)
+		return unstructured.SetNestedField(ingressRoute.Object, map[string]interface{}{
+			"routes": []interface{}{
+				map[string]interface{}{
+					"match": fmt.Sprintf("HostSNI(`%s`)", i.Hostname()),
+					"services": []interface{}{
+						map[string]interface{}{
+							"name": i.namespacedName.Name,
+							"port": int64(i.backendPort),
+						},
+					},
+				},
+			},
+			"tls": map[string]interface{}{
+				"passthrough": true,
+			},
+		}, "spec")
+	})
+	return err
+}
+
+// reconcileHTTPProxy reconciles a Contour HTTPProxy routing TLS connections for the
+// hostname of the endpoint to the service without terminating them
+func (i *ingress) reconcileHTTPProxy(ctx context.Context, c client.Client) error {
+	httpProxy := i.proxyObject()
+
+	_, err := reconcile.CreateOrUpdate(ctx, c, i.logger, httpProxy, i.reconcileOptions, func() error {
+		httpProxy.SetLabels(i.labels)
+		httpProxy.SetAnnotations(i.ingressAnnotations)
+		httpProxy.SetOwnerReferences(i.ownerReferences)
+		spec := map[string]interface{}{
+			"virtualhost": map[string]interface{}{
+				"fqdn": i.Hostname(),
+				"tls": map[string]interface{}{
+					"passthrough": true,
+				},
+			},
+			"tcpproxy": map[string]interface{}{
+				"services": []interface{}{
+					map[string]interface{}{
+						"name": i.namespacedName.Name,
+						"port": int64(i.backendPort),
+					},
+				},
+			},
+		}
+		if i.ingressClassName != nil && *i.ingressClassName != "" {
+			spec["ingressClassName"] = *i.ingressClassName
+		}
+		return unstructured.SetNestedField(httpProxy.Object, spec, "spec")
+	})
+	return err
+}
+
+// proxyObject returns the custom resource created instead of an Ingress for the
+// Traefik and Contour profiles
+func (i *ingress) proxyObject() *unstructured.Unstructured {
+	gvk := traefikIngressRouteTCPGVK
+	if i.profile == IngressControllerProfileContour {
+		gvk = contourHTTPProxyGVK
+	}
+	proxy := newUnstructured(gvk)
+	proxy.SetName(i.namespacedName.Name)
+	proxy.SetNamespace(i.namespacedName.Namespace)
+	return proxy
+}
+
+// isProxyHealthy checks the custom resource created for the Traefik and Contour profiles.
+// Traefik does not report status for IngressRouteTCP, it is healthy once it exists
+func (i *ingress) isProxyHealthy(ctx context.Context, c client.Client) (bool, error) {
+	proxy := i.proxyObject()
+	err := c.Get(ctx, i.NamespacedName(), proxy)
+	if err != nil {
+		i.logger.Error(err, "failed to get proxy", "kind", proxy.GetKind())
+		return false, err
+	}
+	if i.profile != IngressControllerProfileContour {
+		return true, nil
+	}
+	status, _, err := unstructured.NestedString(proxy.Object, "status", "currentStatus")
+	if err != nil {
+		return false, err
+	}
+	if status != "valid" {
+		description, _, _ := unstructured.NestedString(proxy.Object, "status", "description")
+		return false, fmt.Errorf("%w: HTTPProxy %s is %q: %s", endpoint.ErrEndpointNotReady, i.NamespacedName(), status, description)
+	}
+	return true, nil
+}
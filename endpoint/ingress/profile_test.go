@@ -0,0 +1,179 @@
+package ingress
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/go-logr/logr/testr"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNewWithOptions_profiles(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "test", Namespace: "test-ns"}
+	tests := []struct {
+		name            string
+		options         Options
+		wantAnnotations map[string]string
+		wantSpec        map[string]interface{}
+		wantErr         bool
+	}{
+		{
+			name: "nginx profile, must set the nginx passthrough annotation",
+			options: Options{
+				Subdomain: "test.net",
+				Profile:   IngressControllerProfileNginx,
+			},
+			wantAnnotations: map[string]string{NginxIngressPassthroughAnnotation: "true"},
+		},
+		{
+			name: "haproxy profile with caller annotations, must merge the annotations",
+			options: Options{
+				Subdomain:   "test.net",
+				Profile:     IngressControllerProfileHAProxy,
+				Annotations: map[string]string{"haproxy.org/timeout-tunnel": "24h"},
+			},
+			wantAnnotations: map[string]string{
+				HAProxyIngressPassthroughAnnotation: "true",
+				"haproxy.org/timeout-tunnel":        "24h",
+			},
+		},
+		{
+			name: "traefik profile, must create an IngressRouteTCP with passthrough",
+			options: Options{
+				Subdomain: "test.net",
+				Profile:   IngressControllerProfileTraefik,
+			},
+			wantSpec: map[string]interface{}{
+				"routes": []interface{}{
+					map[string]interface{}{
+						"match": "HostSNI(`test-test-ns.test.net`)",
+						"services": []interface{}{
+							map[string]interface{}{"name": "test", "port": int64(backendPort)},
+						},
+					},
+				},
+				"tls": map[string]interface{}{"passthrough": true},
+			},
+		},
+		{
+			name: "contour profile, must create an HTTPProxy with passthrough",
+			options: Options{
+				Subdomain:        "test.net",
+				Profile:          IngressControllerProfileContour,
+				IngressClassName: pointer.String("contour"),
+			},
+			wantSpec: map[string]interface{}{
+				"virtualhost": map[string]interface{}{
+					"fqdn": "test-test-ns.test.net",
+					"tls":  map[string]interface{}{"passthrough": true},
+				},
+				"tcpproxy": map[string]interface{}{
+					"services": []interface{}{
+						map[string]interface{}{"name": "test", "port": int64(backendPort)},
+					},
+				},
+				"ingressClassName": "contour",
+			},
+		},
+		{
+			name: "unknown profile, must return error",
+			options: Options{
+				Subdomain: "test.net",
+				Profile:   "unknown",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().Build()
+			_, err := NewWithOptions(context.Background(), c, testr.New(t), namespacedName, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if tt.options.Profile.usesIngress() {
+				ingress := &networkingv1.Ingress{}
+				err = c.Get(context.Background(), namespacedName, ingress)
+				if err != nil {
+					t.Fatalf("unable to get ingress %v", err)
+				}
+				if !reflect.DeepEqual(ingress.Annotations, tt.wantAnnotations) {
+					t.Errorf("ingress annotations = %v, want %v", ingress.Annotations, tt.wantAnnotations)
+				}
+				return
+			}
+			proxy := (&ingress{namespacedName: namespacedName, profile: tt.options.Profile}).proxyObject()
+			err = c.Get(context.Background(), namespacedName, proxy)
+			if err != nil {
+				t.Fatalf("unable to get %s %v", proxy.GetKind(), err)
+			}
+			spec, _, _ := unstructured.NestedMap(proxy.Object, "spec")
+			if !reflect.DeepEqual(spec, tt.wantSpec) {
+				t.Errorf("%s spec = %#v, want %#v", proxy.GetKind(), spec, tt.wantSpec)
+			}
+		})
+	}
+}
+
+func Test_ingress_isProxyHealthy(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "test", Namespace: "test-ns"}
+	tests := []struct {
+		name    string
+		profile IngressControllerProfile
+		status  map[string]interface{}
+		want    bool
+		wantErr error
+	}{
+		{
+			name:    "traefik IngressRouteTCP exists, must return healthy",
+			profile: IngressControllerProfileTraefik,
+			want:    true,
+			wantErr: nil,
+		},
+		{
+			name:    "contour HTTPProxy is valid, must return healthy",
+			profile: IngressControllerProfileContour,
+			status:  map[string]interface{}{"currentStatus": "valid"},
+			want:    true,
+			wantErr: nil,
+		},
+		{
+			name:    "contour HTTPProxy is invalid, must return ErrEndpointNotReady",
+			profile: IngressControllerProfileContour,
+			status:  map[string]interface{}{"currentStatus": "invalid", "description": "duplicate fqdn"},
+			want:    false,
+			wantErr: endpoint.ErrEndpointNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &ingress{
+				logger:         testr.New(t),
+				namespacedName: namespacedName,
+				profile:        tt.profile,
+			}
+			proxy := i.proxyObject()
+			if tt.status != nil {
+				proxy.Object["status"] = tt.status
+			}
+			c := fake.NewClientBuilder().WithObjects(proxy).Build()
+			got, err := i.isProxyHealthy(context.Background(), c)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("isProxyHealthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("isProxyHealthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/internal/utils"
+	"github.com/backube/pvc-transfer/transport"
 	"github.com/go-logr/logr"
 	routev1 "github.com/openshift/api/route/v1"
 	corev1 "k8s.io/api/core/v1"
@@ -16,17 +19,43 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
 	EndpointTypePassthrough             = "EndpointTypePassthrough"
 	EndpointTypeInsecureEdge            = "EndpointTypeInsecureEdge"
+	EndpointTypeReencrypt               = "EndpointTypeReencrypt"
 	InsecureEdgeTerminationPolicyPort   = 8080
 	TLSTerminationPassthroughPolicyPort = 6443
+	TLSTerminationReencryptPolicyPort   = 6443
 )
 
+var (
+	// ErrRouteAPIUnavailable is returned when route.openshift.io APIs are not served
+	// by the kube apiserver, the route package is unusable in such clusters
+	ErrRouteAPIUnavailable = errors.New("route.openshift.io API is unavailable")
+)
+
+// routeAPIUnavailableError wraps the error returned by the RESTMapper so that
+// callers can check for both ErrRouteAPIUnavailable and meta.NoResourceMatchError
+type routeAPIUnavailableError struct {
+	err error
+}
+
+func (e *routeAPIUnavailableError) Error() string {
+	return fmt.Sprintf("route package unusable: %v", e.err)
+}
+
+func (e *routeAPIUnavailableError) Unwrap() error {
+	return e.err
+}
+
+func (e *routeAPIUnavailableError) Is(target error) bool {
+	return target == ErrRouteAPIUnavailable
+}
+
 // AddToScheme should be used as soon as scheme is created to add
 // route objects for encoding/decoding
 func AddToScheme(scheme *runtime.Scheme) error {
@@ -36,8 +65,7 @@ func AddToScheme(scheme *runtime.Scheme) error {
 // APIsToWatch give a list of APIs to watch if using this package
 // to deploy the endpoint. The error can be checked as follows to determine if
 // the package is not usable with the given kube apiserver
-//  	noResourceError := &metaapi.NoResourceMatchError{}
-//		if errors.As(err, &noResourceError) {
+//		if errors.Is(err, route.ErrRouteAPIUnavailable) {
 // 		}
 func APIsToWatch(c client.Client) ([]client.Object, error) {
 	_, err := c.RESTMapper().ResourceFor(schema.GroupVersionResource{
@@ -47,10 +75,10 @@ func APIsToWatch(c client.Client) ([]client.Object, error) {
 	})
 	noResourceError := &metaapi.NoResourceMatchError{}
 	if errors.As(err, &noResourceError) {
-		return []client.Object{}, fmt.Errorf("route package unusable: %w", err)
+		return []client.Object{}, &routeAPIUnavailableError{err: err}
 	}
 	if err != nil {
-		return []client.Object{}, fmt.Errorf("unable to find the resource needed for this package")
+		return []client.Object{}, fmt.Errorf("unable to find the resource needed for this package: %w", err)
 	}
 	return []client.Object{&routev1.Route{}, &corev1.Service{}}, nil
 }
@@ -63,11 +91,67 @@ type route struct {
 	hostname *string
 	logger   logr.Logger
 
-	port            int32
-	endpointType    EndpointType
-	namespacedName  types.NamespacedName
-	labels          map[string]string
-	ownerReferences []metav1.OwnerReference
+	port             int32
+	endpointType     EndpointType
+	namespacedName   types.NamespacedName
+	labels           map[string]string
+	selectorLabels   map[string]string
+	annotations      map[string]string
+	destinationCA    string
+	backendCreds     *transport.Credentials
+	tlsSecretName    string
+	externalDNS      *endpoint.ExternalDNS
+	ownerReferences  []metav1.OwnerReference
+	reconcileOptions reconcile.Options
+}
+
+// Options allows callers to configure the route endpoint created by NewWithOptions
+type Options struct {
+	// Hostname is the desired host of the route, when nil the host is generated from
+	// Subdomain or assigned by the router
+	Hostname *string
+	// Subdomain is used to generate the host of the route as <name>-<namespace>.<subdomain>
+	// when Hostname is not set. The <name>-<namespace> prefix is truncated to fit in a DNS label
+	Subdomain string
+	// Labels are applied to the route and the service, they are also used as the service selector
+	// unless SelectorLabels are set
+	Labels map[string]string
+	// SelectorLabels are used as the service selector to find the pods backing the route
+	SelectorLabels map[string]string
+	// Annotations are applied to the route, e.g. haproxy.router.openshift.io/timeout to
+	// keep long running transfers connected
+	Annotations map[string]string
+	// BackendPort is the port of the pods the route forwards traffic to, defaults to
+	// 6443 for passthrough and 8080 for insecure edge routes
+	BackendPort int32
+	// DestinationCACertificate is the PEM encoded CA certificate used by the router to verify
+	// the certificate served by the backend of a reencrypt route, e.g. the ca.crt of the stunnel
+	// credentials secret. When empty, the router uses the service serving CA
+	DestinationCACertificate string
+	// DestinationCredentials are the credentials of the transport server behind a reencrypt
+	// route, the router verifies the certificate served by the backend with the ca.crt of
+	// their secret instead of DestinationCACertificate. The router uses the service serving
+	// CA for credentials read from a provider, e.g. credentials.ServiceCA
+	DestinationCredentials *transport.Credentials
+	// TLSSecretName is a kubernetes.io/tls secret in the namespace of the route holding the
+	// certificate served by the router for edge and reencrypt routes, e.g. a wildcard certificate
+	// of the apps domain. The optional ca.crt of the secret is served as the certificate chain.
+	// When empty, the router serves its default certificate.
+	TLSSecretName string
+	// ExternalDNS annotates the route for external-dns to publish a DNS name pointing to the
+	// router. The name is used as the host of the route when Hostname is not set, the route is
+	// healthy once the name resolves
+	ExternalDNS *endpoint.ExternalDNS
+	// OwnerReferences are applied to the route and the service
+	OwnerReferences []metav1.OwnerReference
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate,
+	// see the ServerSideApply field of reconcile.Options
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply, defaults to "pvc-transfer"
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
 }
 
 // New creates the route endpoint object, deploys the resource on the cluster
@@ -76,9 +160,8 @@ type route struct {
 //
 // In order to identify if the route API exists check for the following error after calling
 // New()
-// noResourceError := &metaapi.NoResourceMatchError{}
 //	switch {
-//	case errors.As(err, &noResourceError):
+//	case errors.Is(err, route.ErrRouteAPIUnavailable):
 //		// log route is not available, reconcilers should not requeue at this point
 //		log.Info("route.openshift.io is unavailable, route endpoint will be disabled")
 //  }
@@ -92,9 +175,46 @@ func New(ctx context.Context, c client.Client, logger logr.Logger,
 	hostname *string,
 	labels map[string]string,
 	ownerReferences []metav1.OwnerReference) (endpoint.Endpoint, error) {
-	if eType != EndpointTypePassthrough && eType != EndpointTypeInsecureEdge {
+	return NewWithOptions(ctx, c, logger, namespacedName, eType, Options{
+		Hostname:        hostname,
+		Labels:          labels,
+		OwnerReferences: ownerReferences,
+	})
+}
+
+// NewWithOptions creates the route endpoint object using the given options, refer New
+// for details on using the endpoint.
+//
+// Routes of EndpointTypeReencrypt terminate TLS at the router, which opens another TLS
+// connection to the backend. Transport clients are served the certificate of the router, they
+// can't pin the certificate of the server nor verify its hostname, see transport.Options. The
+// router presents no client certificate to the backend: mutual TLS is not possible, transport
+// servers must use one way TLS credentials, e.g. credentials.ServiceCA.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//
+// When using TLSSecretName or DestinationCredentials, add the following line as well.
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get
+func NewWithOptions(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	eType EndpointType,
+	options Options) (endpoint.Endpoint, error) {
+	if eType != EndpointTypePassthrough && eType != EndpointTypeInsecureEdge && eType != EndpointTypeReencrypt {
 		return nil, fmt.Errorf("unsupported endpoint type for routes")
 	}
+	if eType == EndpointTypePassthrough && options.TLSSecretName != "" {
+		return nil, fmt.Errorf("%w: passthrough routes do not terminate TLS", endpoint.ErrTLSSecretInvalid)
+	}
+	if eType != EndpointTypeReencrypt && options.DestinationCredentials != nil {
+		return nil, fmt.Errorf("%w: only reencrypt routes verify the backend", endpoint.ErrTLSSecretInvalid)
+	}
+
+	hostname, err := getHostname(namespacedName, options)
+	if err != nil {
+		return nil, err
+	}
 
 	rLogger := logger.WithValues("route", namespacedName)
 	r := &route{
@@ -102,8 +222,19 @@ func New(ctx context.Context, c client.Client, logger logr.Logger,
 		logger:          rLogger,
 		namespacedName:  namespacedName,
 		endpointType:    eType,
-		labels:          labels,
-		ownerReferences: ownerReferences,
+		labels:          options.Labels,
+		selectorLabels:  options.SelectorLabels,
+		annotations:     endpoint.MergeAnnotations(options.Annotations, options.ExternalDNS),
+		destinationCA:   options.DestinationCACertificate,
+		backendCreds:    options.DestinationCredentials,
+		tlsSecretName:   options.TLSSecretName,
+		externalDNS:     options.ExternalDNS,
+		ownerReferences: options.OwnerReferences,
+		reconcileOptions: reconcile.Options{
+			ServerSideApply: options.ServerSideApply,
+			FieldManager:    options.FieldManager,
+			Adopt:           options.Adopt,
+		},
 	}
 
 	switch r.endpointType {
@@ -113,14 +244,31 @@ func New(ctx context.Context, c client.Client, logger logr.Logger,
 	case EndpointTypePassthrough:
 		r.logger.Info("endpoint with", "type", EndpointTypePassthrough, "port", TLSTerminationPassthroughPolicyPort)
 		r.port = int32(TLSTerminationPassthroughPolicyPort)
+	case EndpointTypeReencrypt:
+		r.logger.Info("endpoint with", "type", EndpointTypeReencrypt, "port", TLSTerminationReencryptPolicyPort)
+		r.port = int32(TLSTerminationReencryptPolicyPort)
+		if r.destinationCA == "" && r.backendCreds == nil {
+			r.logger.Info("destination CA certificate not specified, router will use the service serving CA")
+		}
+	}
+	if options.BackendPort != 0 {
+		r.logger.Info("endpoint with custom backend", "port", options.BackendPort)
+		r.port = options.BackendPort
+	}
+	if r.selectorLabels == nil {
+		r.selectorLabels = r.labels
 	}
 
-	err := r.reconcileServiceForRoute(ctx, c)
+	err = r.reconcileServiceForRoute(ctx, c)
 	if err != nil {
 		return nil, err
 	}
 
 	err = r.reconcileRoute(ctx, c)
+	noResourceError := &metaapi.NoResourceMatchError{}
+	if errors.As(err, &noResourceError) {
+		return nil, &routeAPIUnavailableError{err: err}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -128,6 +276,49 @@ func New(ctx context.Context, c client.Client, logger logr.Logger,
 	return r, nil
 }
 
+// getHostname returns the host to be set on the route, nil if the host is to be assigned
+// by the router
+func getHostname(namespacedName types.NamespacedName, options Options) (*string, error) {
+	if options.ExternalDNS != nil {
+		err := options.ExternalDNS.Validate()
+		if err != nil {
+			return nil, err
+		}
+		if options.Hostname != nil && *options.Hostname != options.ExternalDNS.Hostname {
+			return nil, fmt.Errorf("external-dns hostname %s does not match route hostname %s",
+				options.ExternalDNS.Hostname, *options.Hostname)
+		}
+		return &options.ExternalDNS.Hostname, nil
+	}
+	if options.Hostname != nil {
+		return options.Hostname, validateHostname(*options.Hostname)
+	}
+	if options.Subdomain == "" {
+		return nil, nil
+	}
+	prefix := fmt.Sprintf("%s-%s", namespacedName.Name, namespacedName.Namespace)
+	if len(prefix) > validation.DNS1123LabelMaxLength {
+		prefix = strings.TrimRight(prefix[:validation.DNS1123LabelMaxLength], "-")
+	}
+	hostname := fmt.Sprintf("%s.%s", prefix, options.Subdomain)
+	return &hostname, validateHostname(hostname)
+}
+
+// validateHostname checks the hostname against the length limits of DNS names
+func validateHostname(hostname string) error {
+	if len(hostname) > validation.DNS1123SubdomainMaxLength {
+		return fmt.Errorf("%w: hostname %s is longer than %d characters",
+			endpoint.ErrHostnameTooLong, hostname, validation.DNS1123SubdomainMaxLength)
+	}
+	for _, label := range strings.Split(hostname, ".") {
+		if len(label) > validation.DNS1123LabelMaxLength {
+			return fmt.Errorf("%w: label %s of hostname %s is longer than %d characters",
+				endpoint.ErrHostnameTooLong, label, hostname, validation.DNS1123LabelMaxLength)
+		}
+	}
+	return nil
+}
+
 func (r *route) NamespacedName() types.NamespacedName {
 	return r.namespacedName
 }
@@ -148,35 +339,76 @@ func (r *route) IngressPort() int32 {
 }
 
 func (r *route) IsHealthy(ctx context.Context, c client.Client) (bool, error) {
-	route := &routev1.Route{}
-	err := c.Get(ctx, r.NamespacedName(), route)
+	health, err := r.Health(ctx, c)
 	if err != nil {
-		r.logger.Error(err, "unable to get route")
 		return false, err
 	}
+	if health.Healthy {
+		return true, nil
+	}
+	r.logger.Info("endpoint is unhealthy", "reason", health.Reason, "message", health.Message)
+	if health.Reason == endpoint.HealthReasonMisconfigured {
+		return false, errors.New(health.Message)
+	}
+	return false, fmt.Errorf("%w: %s", endpoint.ErrEndpointNotReady, health.Message)
+}
+
+// Health returns the health of the route, a route rejected by the router is misconfigured
+func (r *route) Health(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	route, err := r.getRoute(ctx, c)
+	if err != nil {
+		return nil, err
+	}
 
 	// TODO: add other sanity checks here to make sure calling interface methods out of order will not return ambiguous
 	//  results
 	if route.Spec.Host == "" {
-		return false, fmt.Errorf("hostname not set for route: %s", route)
-	}
-
-	if len(route.Status.Ingress) > 0 && len(route.Status.Ingress[0].Conditions) > 0 {
-		for _, condition := range route.Status.Ingress[0].Conditions {
-			if condition.Type == routev1.RouteAdmitted && condition.Status == corev1.ConditionTrue {
-				// TODO: remove setHostname and configure the hostname after this condition has been satisfied,
-				//  this is the implementation detail that we dont need the users of the interface work with
-				err := r.setFields(ctx, c)
-				if err != nil {
-					return true, err
-				}
-				return true, nil
+		return endpoint.Unhealthy(endpoint.HealthReasonAwaitingAdmission,
+			"hostname not set for route %s", r.NamespacedName()), nil
+	}
+
+	if len(route.Status.Ingress) == 0 {
+		return endpoint.Unhealthy(endpoint.HealthReasonAwaitingAdmission,
+			"route %s is not admitted", r.NamespacedName()), nil
+	}
+	for _, condition := range route.Status.Ingress[0].Conditions {
+		if condition.Type != routev1.RouteAdmitted {
+			continue
+		}
+		switch condition.Status {
+		case corev1.ConditionTrue:
+			// TODO: remove setHostname and configure the hostname after this condition has been satisfied,
+			//  this is the implementation detail that we dont need the users of the interface work with
+			err := r.setFields(ctx, c)
+			if err != nil {
+				return nil, err
 			}
+			return r.externalDNSHealth(ctx)
+		case corev1.ConditionFalse:
+			return endpoint.Unhealthy(endpoint.HealthReasonMisconfigured,
+				"route %s was rejected by the router: %s: %s", r.NamespacedName(), condition.Reason, condition.Message), nil
 		}
 	}
-	// TODO: probably using error.Wrap/Unwrap here makes much more sense
-	r.logger.Info("endpoint is unhealthy")
-	return false, fmt.Errorf("route status is not in valid state: %s", route.Status)
+	return endpoint.Unhealthy(endpoint.HealthReasonAwaitingAdmission,
+		"route %s is not admitted", r.NamespacedName()), nil
+}
+
+// externalDNSHealth returns the health of an admitted route, the route is healthy once its
+// external-dns hostname resolves
+func (r *route) externalDNSHealth(ctx context.Context) (*endpoint.Health, error) {
+	if r.externalDNS == nil {
+		return endpoint.Healthy(), nil
+	}
+	resolves, err := r.externalDNS.Resolves(ctx)
+	if err != nil {
+		r.logger.Error(err, "unable to resolve external-dns hostname", "hostname", r.externalDNS.Hostname)
+		return nil, err
+	}
+	if !resolves {
+		return endpoint.Unhealthy(endpoint.HealthReasonDNSNotResolved,
+			"hostname %s of route %s does not resolve yet", r.externalDNS.Hostname, r.NamespacedName()), nil
+	}
+	return endpoint.Healthy(), nil
 }
 
 func (r *route) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
@@ -212,8 +444,7 @@ func (r *route) reconcileServiceForRoute(ctx context.Context, c client.Client) e
 		},
 	}
 
-	// TODO: log the return operation from CreateOrUpdate
-	_, err := controllerutil.CreateOrUpdate(ctx, c, service, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, r.logger, service, r.reconcileOptions, func() error {
 		service.Labels = r.labels
 		service.OwnerReferences = r.ownerReferences
 
@@ -229,7 +460,7 @@ func (r *route) reconcileServiceForRoute(ctx context.Context, c client.Client) e
 			},
 		}
 
-		service.Spec.Selector = r.labels
+		service.Spec.Selector = r.selectorLabels
 		service.Spec.Type = corev1.ServiceTypeClusterIP
 		return nil
 	})
@@ -237,6 +468,26 @@ func (r *route) reconcileServiceForRoute(ctx context.Context, c client.Client) e
 	return err
 }
 
+// getDestinationCA returns the CA certificate used by the router to verify the backend of a
+// reencrypt route
+func (r *route) getDestinationCA(ctx context.Context, c client.Client) (string, error) {
+	if r.backendCreds == nil || r.backendCreds.Provider != nil {
+		return r.destinationCA, nil
+	}
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, r.backendCreds.SecretRef, secret)
+	if err != nil {
+		r.logger.Error(err, "unable to get destination credentials secret")
+		return "", err
+	}
+	ca := secret.Data[corev1.ServiceAccountRootCAKey]
+	if len(ca) == 0 {
+		return "", fmt.Errorf("%w: secret %s has no %s", endpoint.ErrTLSSecretInvalid,
+			r.backendCreds.SecretRef, corev1.ServiceAccountRootCAKey)
+	}
+	return string(ca), nil
+}
+
 func (r *route) reconcileRoute(ctx context.Context, c client.Client) error {
 	termination := &routev1.TLSConfig{}
 	switch r.endpointType {
@@ -249,6 +500,28 @@ func (r *route) reconcileRoute(ctx context.Context, c client.Client) error {
 		termination = &routev1.TLSConfig{
 			Termination: routev1.TLSTerminationPassthrough,
 		}
+	case EndpointTypeReencrypt:
+		destinationCA, err := r.getDestinationCA(ctx, c)
+		if err != nil {
+			return err
+		}
+		termination = &routev1.TLSConfig{
+			Termination:              routev1.TLSTerminationReencrypt,
+			DestinationCACertificate: destinationCA,
+		}
+	}
+	if r.tlsSecretName != "" {
+		secret, err := endpoint.GetTLSSecret(ctx, c, types.NamespacedName{
+			Namespace: r.namespacedName.Namespace,
+			Name:      r.tlsSecretName,
+		}, r.Hostname())
+		if err != nil {
+			r.logger.Error(err, "unable to get route TLS secret")
+			return err
+		}
+		termination.Certificate = string(secret.Data[corev1.TLSCertKey])
+		termination.Key = string(secret.Data[corev1.TLSPrivateKeyKey])
+		termination.CACertificate = string(secret.Data[corev1.ServiceAccountRootCAKey])
 	}
 
 	route := &routev1.Route{
@@ -258,10 +531,18 @@ func (r *route) reconcileRoute(ctx context.Context, c client.Client) error {
 		},
 	}
 
-	_, err := controllerutil.CreateOrUpdate(ctx, c, route, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, r.logger, route, r.reconcileOptions, func() error {
 		route.Labels = r.labels
 		route.OwnerReferences = r.ownerReferences
 
+		// annotations are merged, the router and other controllers annotate routes too
+		for key, value := range r.annotations {
+			if route.Annotations == nil {
+				route.Annotations = map[string]string{}
+			}
+			route.Annotations[key] = value
+		}
+
 		if r.hostname != nil {
 			route.Spec.Host = *r.hostname
 		}
@@ -297,10 +578,10 @@ func (r *route) setFields(ctx context.Context, c client.Client) error {
 	}
 
 	if route.Spec.Host == "" {
-		return fmt.Errorf("route %s has empty spec.host field", r.NamespacedName())
+		return fmt.Errorf("%w: route %s has empty spec.host field", endpoint.ErrEndpointNotReady, r.NamespacedName())
 	}
 	if route.Spec.Port == nil {
-		return fmt.Errorf("route %s has empty spec.port field", r.NamespacedName())
+		return fmt.Errorf("%w: route %s has empty spec.port field", endpoint.ErrEndpointNotReady, r.NamespacedName())
 	}
 
 	r.hostname = &route.Spec.Host
@@ -313,7 +594,7 @@ func (r *route) setFields(ctx context.Context, c client.Client) error {
 	case routev1.TLSTerminationPassthrough:
 		r.endpointType = EndpointTypePassthrough
 	case routev1.TLSTerminationReencrypt:
-		return fmt.Errorf("route %s has unsupported spec.spec.tls.termination value", r.NamespacedName())
+		r.endpointType = EndpointTypeReencrypt
 	}
 
 	return nil
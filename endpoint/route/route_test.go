@@ -2,16 +2,22 @@ package route
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/backube/pvc-transfer/endpoint"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr/testr"
 	routev1 "github.com/openshift/api/route/v1"
 	corev1 "k8s.io/api/core/v1"
+	metaapi "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/utils/pointer"
@@ -25,6 +31,16 @@ func fakeClientWithObjects(objs ...client.Object) client.WithWatch {
 	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()
 }
 
+// fakeClientWithRESTMapper allows tests to control the APIs discovered by the client
+type fakeClientWithRESTMapper struct {
+	client.Client
+	mapper metaapi.RESTMapper
+}
+
+func (f fakeClientWithRESTMapper) RESTMapper() metaapi.RESTMapper {
+	return f.mapper
+}
+
 func testOwnerReferences() []metav1.OwnerReference {
 	return []metav1.OwnerReference{metav1.OwnerReference{
 		APIVersion:         "api.foo",
@@ -143,8 +159,8 @@ func TestNew(t *testing.T) {
 			}
 			AddToScheme(fakeClient.Scheme())
 			ctx := context.WithValue(context.Background(), "test", tt.name)
-			fakeLogger := logrtesting.TestLogger{t}
-			endpoint, gotError := New(ctx, fakeClient, fakeLogger, tt.namespacedName, tt.eType, nil, tt.labels, tt.ownerReferences)
+			fakeLogger := testr.New(t)
+			e, gotError := New(ctx, fakeClient, fakeLogger, tt.namespacedName, tt.eType, nil, tt.labels, tt.ownerReferences)
 			route := &routev1.Route{}
 			err := fakeClient.Get(context.Background(), tt.namespacedName, route)
 			if err != nil {
@@ -163,11 +179,511 @@ func TestNew(t *testing.T) {
 			if svc.Spec.Type != corev1.ServiceTypeClusterIP && !reflect.DeepEqual(svc.Spec.Selector, tt.labels) && svc.Spec.Ports[0].Port != TLSTerminationPassthroughPolicyPort {
 				t.Errorf("didnt get the expected service %#v", svc)
 			}
-			_, gotError = endpoint.IsHealthy(context.TODO(), fakeClient)
+			_, gotError = e.IsHealthy(context.TODO(), fakeClient)
 			if (gotError != nil) != tt.wantErr {
 				t.Errorf("New() error = %v, wantErr %v", gotError, tt.wantErr)
 				return
 			}
+			if tt.wantErr && !errors.Is(gotError, endpoint.ErrEndpointNotReady) {
+				t.Errorf("IsHealthy() error = %v, want ErrEndpointNotReady", gotError)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	tests := []struct {
+		name             string
+		eType            EndpointType
+		options          Options
+		existing         []client.Object
+		wantPort         int32
+		wantSelector     map[string]string
+		wantAnnotations  map[string]string
+		wantServiceLabel map[string]string
+	}{
+		{
+			name:  "passthrough route with default options, must use default port and labels as selector",
+			eType: EndpointTypePassthrough,
+			options: Options{
+				Labels: map[string]string{"test": "me"},
+			},
+			wantPort:         TLSTerminationPassthroughPolicyPort,
+			wantSelector:     map[string]string{"test": "me"},
+			wantAnnotations:  nil,
+			wantServiceLabel: map[string]string{"test": "me"},
+		},
+		{
+			name:  "passthrough route with custom port, annotations and selector",
+			eType: EndpointTypePassthrough,
+			options: Options{
+				Labels:         map[string]string{"test": "me"},
+				SelectorLabels: map[string]string{"app": "rsync"},
+				Annotations:    map[string]string{"haproxy.router.openshift.io/timeout": "24h"},
+				BackendPort:    9443,
+			},
+			wantPort:         9443,
+			wantSelector:     map[string]string{"app": "rsync"},
+			wantAnnotations:  map[string]string{"haproxy.router.openshift.io/timeout": "24h"},
+			wantServiceLabel: map[string]string{"test": "me"},
+		},
+		{
+			name:  "existing route with router annotations, must preserve them",
+			eType: EndpointTypeInsecureEdge,
+			options: Options{
+				Labels:      map[string]string{"test": "me"},
+				Annotations: map[string]string{"haproxy.router.openshift.io/timeout": "24h"},
+			},
+			existing: []client.Object{&routev1.Route{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "foo",
+					Namespace:   "bar",
+					Annotations: map[string]string{"openshift.io/host.generated": "true"},
+				},
+			}},
+			wantPort:     InsecureEdgeTerminationPolicyPort,
+			wantSelector: map[string]string{"test": "me"},
+			wantAnnotations: map[string]string{
+				"openshift.io/host.generated":         "true",
+				"haproxy.router.openshift.io/timeout": "24h",
+			},
+			wantServiceLabel: map[string]string{"test": "me"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+			fakeClient := fakeClientWithObjects(tt.existing...)
+			e, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, tt.eType, tt.options)
+			if err != nil {
+				t.Fatalf("NewWithOptions() unexpected error %v", err)
+			}
+			if e.BackendPort() != tt.wantPort {
+				t.Errorf("BackendPort() = %v, want %v", e.BackendPort(), tt.wantPort)
+			}
+
+			route := &routev1.Route{}
+			err = fakeClient.Get(context.Background(), namespacedName, route)
+			if err != nil {
+				t.Fatalf("%#v should not be getting error from fake client", err)
+			}
+			if route.Spec.Port.TargetPort.IntVal != tt.wantPort {
+				t.Errorf("route target port = %v, want %v", route.Spec.Port.TargetPort.IntVal, tt.wantPort)
+			}
+			if !reflect.DeepEqual(route.Annotations, tt.wantAnnotations) {
+				t.Errorf("route annotations = %#v, want %#v", route.Annotations, tt.wantAnnotations)
+			}
+
+			svc := &corev1.Service{}
+			err = fakeClient.Get(context.Background(), namespacedName, svc)
+			if err != nil {
+				t.Fatalf("%#v should not be getting error from fake client", err)
+			}
+			if svc.Spec.Ports[0].TargetPort.IntVal != tt.wantPort {
+				t.Errorf("service target port = %v, want %v", svc.Spec.Ports[0].TargetPort.IntVal, tt.wantPort)
+			}
+			if !reflect.DeepEqual(svc.Spec.Selector, tt.wantSelector) {
+				t.Errorf("service selector = %#v, want %#v", svc.Spec.Selector, tt.wantSelector)
+			}
+			if !reflect.DeepEqual(svc.Labels, tt.wantServiceLabel) {
+				t.Errorf("service labels = %#v, want %#v", svc.Labels, tt.wantServiceLabel)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_reencrypt(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	e, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, EndpointTypeReencrypt, Options{
+		Labels:                   map[string]string{"test": "me"},
+		Hostname:                 pointer.String("foo.bar"),
+		DestinationCACertificate: "test-ca",
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() unexpected error %v", err)
+	}
+	if e.BackendPort() != TLSTerminationReencryptPolicyPort {
+		t.Errorf("BackendPort() = %v, want %v", e.BackendPort(), TLSTerminationReencryptPolicyPort)
+	}
+
+	route := &routev1.Route{}
+	err = fakeClient.Get(context.Background(), namespacedName, route)
+	if err != nil {
+		t.Fatalf("%#v should not be getting error from fake client", err)
+	}
+	wantTLS := &routev1.TLSConfig{
+		Termination:              routev1.TLSTerminationReencrypt,
+		DestinationCACertificate: "test-ca",
+	}
+	if !reflect.DeepEqual(route.Spec.TLS, wantTLS) {
+		t.Errorf("route tls = %#v, want %#v", route.Spec.TLS, wantTLS)
+	}
+
+	route.Status = routev1.RouteStatus{Ingress: []routev1.RouteIngress{{Conditions: []routev1.RouteIngressCondition{{Type: routev1.RouteAdmitted, Status: corev1.ConditionTrue}}}}}
+	err = fakeClient.Update(context.Background(), route)
+	if err != nil {
+		t.Fatalf("%#v should not be getting error from fake client", err)
+	}
+	healthy, err := e.IsHealthy(context.Background(), fakeClient)
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v, want healthy reencrypt route", healthy, err)
+	}
+}
+
+func TestNewWithOptions_destinationCredentials(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	credentialsRef := types.NamespacedName{Namespace: "bar", Name: "stunnel-creds"}
+	tests := []struct {
+		name        string
+		eType       EndpointType
+		credentials *transport.Credentials
+		objects     []client.Object
+		wantCA      string
+		wantErr     error
+	}{
+		{
+			name:        "reencrypt route, must verify the backend with the CA of the credentials",
+			eType:       EndpointTypeReencrypt,
+			credentials: &transport.Credentials{SecretRef: credentialsRef},
+			objects: []client.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "stunnel-creds"},
+				Data:       map[string][]byte{corev1.ServiceAccountRootCAKey: []byte("stunnel-ca")},
+			}},
+			wantCA: "stunnel-ca",
+		},
+		{
+			name:        "credentials secret without ca.crt, must return ErrTLSSecretInvalid",
+			eType:       EndpointTypeReencrypt,
+			credentials: &transport.Credentials{SecretRef: credentialsRef},
+			objects: []client.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "stunnel-creds"},
+			}},
+			wantErr: endpoint.ErrTLSSecretInvalid,
+		},
+		{
+			name:        "passthrough route, must return ErrTLSSecretInvalid",
+			eType:       EndpointTypePassthrough,
+			credentials: &transport.Credentials{SecretRef: credentialsRef},
+			wantErr:     endpoint.ErrTLSSecretInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects(tt.objects...)
+			_, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, tt.eType, Options{
+				Hostname:               pointer.String("foo.bar"),
+				DestinationCredentials: tt.credentials,
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			route := &routev1.Route{}
+			err = fakeClient.Get(context.Background(), namespacedName, route)
+			if err != nil {
+				t.Fatalf("%#v should not be getting error from fake client", err)
+			}
+			if route.Spec.TLS.DestinationCACertificate != tt.wantCA {
+				t.Errorf("route destination CA = %q, want %q", route.Spec.TLS.DestinationCACertificate, tt.wantCA)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_tlsSecret(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "bar", Name: "wildcard"},
+		Data: map[string][]byte{
+			corev1.TLSCertKey:              []byte("test-cert"),
+			corev1.TLSPrivateKeyKey:        []byte("test-key"),
+			corev1.ServiceAccountRootCAKey: []byte("test-chain"),
+		},
+	}
+	tests := []struct {
+		name    string
+		eType   EndpointType
+		want    *routev1.TLSConfig
+		wantErr error
+	}{
+		{
+			name:  "edge route, must serve the certificate of the secret",
+			eType: EndpointTypeInsecureEdge,
+			want: &routev1.TLSConfig{
+				Termination:                   routev1.TLSTerminationEdge,
+				InsecureEdgeTerminationPolicy: "Allow",
+				Certificate:                   "test-cert",
+				Key:                           "test-key",
+				CACertificate:                 "test-chain",
+			},
+		},
+		{
+			name:  "reencrypt route, must serve the certificate of the secret",
+			eType: EndpointTypeReencrypt,
+			want: &routev1.TLSConfig{
+				Termination:   routev1.TLSTerminationReencrypt,
+				Certificate:   "test-cert",
+				Key:           "test-key",
+				CACertificate: "test-chain",
+			},
+		},
+		{
+			name:    "passthrough route, must return ErrTLSSecretInvalid",
+			eType:   EndpointTypePassthrough,
+			wantErr: endpoint.ErrTLSSecretInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects(secret.DeepCopy())
+			_, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, tt.eType, Options{
+				TLSSecretName: "wildcard",
+			})
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			route := &routev1.Route{}
+			err = fakeClient.Get(context.Background(), namespacedName, route)
+			if err != nil {
+				t.Fatalf("%#v should not be getting error from fake client", err)
+			}
+			if !reflect.DeepEqual(route.Spec.TLS, tt.want) {
+				t.Errorf("route tls = %#v, want %#v", route.Spec.TLS, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getHostname(t *testing.T) {
+	longName := strings.Repeat("a", 70)
+	tests := []struct {
+		name           string
+		namespacedName types.NamespacedName
+		options        Options
+		want           *string
+		wantErr        error
+	}{
+		{
+			name:           "no hostname and no subdomain, must leave the host to the router",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{},
+			want:           nil,
+			wantErr:        nil,
+		},
+		{
+			name:           "hostname set, must use the hostname over the subdomain",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{Hostname: pointer.String("test.example.com"), Subdomain: "apps.example.com"},
+			want:           pointer.String("test.example.com"),
+			wantErr:        nil,
+		},
+		{
+			name:           "subdomain set, must generate the host",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{Subdomain: "apps.example.com"},
+			want:           pointer.String("foo-bar.apps.example.com"),
+			wantErr:        nil,
+		},
+		{
+			name:           "subdomain set with long name, must truncate the prefix",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: longName},
+			options:        Options{Subdomain: "apps.example.com"},
+			want:           pointer.String(longName[:63] + ".apps.example.com"),
+			wantErr:        nil,
+		},
+		{
+			name:           "generated host longer than DNS limits, must return ErrHostnameTooLong",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{Subdomain: strings.Repeat("apps.", 60) + "com"},
+			want:           nil,
+			wantErr:        endpoint.ErrHostnameTooLong,
+		},
+		{
+			name:           "hostname with a label longer than DNS limits, must return ErrHostnameTooLong",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{Hostname: pointer.String(longName + ".example.com")},
+			want:           nil,
+			wantErr:        endpoint.ErrHostnameTooLong,
+		},
+		{
+			name:           "external-dns set, must use the external-dns hostname over the subdomain",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{Subdomain: "apps.example.com", ExternalDNS: &endpoint.ExternalDNS{Hostname: "transfer.example.com"}},
+			want:           pointer.String("transfer.example.com"),
+			wantErr:        nil,
+		},
+		{
+			name:           "external-dns with a label longer than DNS limits, must return ErrHostnameTooLong",
+			namespacedName: types.NamespacedName{Namespace: "bar", Name: "foo"},
+			options:        Options{ExternalDNS: &endpoint.ExternalDNS{Hostname: longName + ".example.com"}},
+			want:           nil,
+			wantErr:        endpoint.ErrHostnameTooLong,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getHostname(tt.namespacedName, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("getHostname() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getHostname() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_externalDNS(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	published := false
+	externalDNS := &endpoint.ExternalDNS{
+		Hostname: "foo.bar",
+		TTL:      60,
+		LookupHost: func(ctx context.Context, host string) ([]string, error) {
+			if !published {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return []string{"192.168.0.1"}, nil
+		},
+	}
+	fakeClient := fakeClientWithObjects(testRouteObjects(true, namespacedName, map[string]string{"test": "me"}, testOwnerReferences())...)
+	e, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, EndpointTypePassthrough, Options{
+		Labels:          map[string]string{"test": "me"},
+		ExternalDNS:     externalDNS,
+		OwnerReferences: testOwnerReferences(),
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() unexpected error %v", err)
+	}
+	route := &routev1.Route{}
+	err = fakeClient.Get(context.Background(), namespacedName, route)
+	if err != nil {
+		t.Fatalf("%#v should not be getting error from fake client", err)
+	}
+	if route.Annotations[endpoint.ExternalDNSHostnameAnnotation] != "foo.bar" || route.Annotations[endpoint.ExternalDNSTTLAnnotation] != "60" {
+		t.Errorf("route annotations = %v, want external-dns annotations", route.Annotations)
+	}
+
+	healthy, err := e.IsHealthy(context.Background(), fakeClient)
+	if healthy || !errors.Is(err, endpoint.ErrEndpointNotReady) {
+		t.Errorf("IsHealthy() = %v, %v, want ErrEndpointNotReady until the hostname resolves", healthy, err)
+	}
+	published = true
+	healthy, err = e.IsHealthy(context.Background(), fakeClient)
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v, must be healthy once the hostname resolves", healthy, err)
+	}
+	if e.Hostname() != "foo.bar" {
+		t.Errorf("Hostname() = %v, want foo.bar", e.Hostname())
+	}
+}
+
+func Test_route_Health(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	rejected := testRouteObjects(false, namespacedName, nil, nil)
+	rejected[0].(*routev1.Route).Status = routev1.RouteStatus{Ingress: []routev1.RouteIngress{{
+		Conditions: []routev1.RouteIngressCondition{{
+			Type:    routev1.RouteAdmitted,
+			Status:  corev1.ConditionFalse,
+			Reason:  "HostAlreadyClaimed",
+			Message: "route foo already exposes foo.bar",
+		}},
+	}}}
+	tests := []struct {
+		name       string
+		objects    []client.Object
+		wantReason endpoint.HealthReason
+	}{
+		{
+			name:       "admitted route, must report Healthy",
+			objects:    testRouteObjects(true, namespacedName, nil, nil),
+			wantReason: endpoint.HealthReasonHealthy,
+		},
+		{
+			name:       "route without status, must report AwaitingAdmission",
+			objects:    testRouteObjects(false, namespacedName, nil, nil),
+			wantReason: endpoint.HealthReasonAwaitingAdmission,
+		},
+		{
+			name:       "route rejected by the router, must report Misconfigured",
+			objects:    rejected,
+			wantReason: endpoint.HealthReasonMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &route{logger: testr.New(t), namespacedName: namespacedName}
+			c := fakeClientWithObjects(tt.objects...)
+			got, err := r.Health(context.Background(), c)
+			if err != nil {
+				t.Fatalf("Health() unexpected error %v", err)
+			}
+			if got.Reason != tt.wantReason {
+				t.Errorf("Health() reason = %v, want %v, message %s", got.Reason, tt.wantReason, got.Message)
+			}
+			_, err = r.IsHealthy(context.Background(), c)
+			wantNotReady := tt.wantReason != endpoint.HealthReasonHealthy && tt.wantReason != endpoint.HealthReasonMisconfigured
+			if errors.Is(err, endpoint.ErrEndpointNotReady) != wantNotReady {
+				t.Errorf("IsHealthy() error = %v, want ErrEndpointNotReady %v", err, wantNotReady)
+			}
+		})
+	}
+}
+
+func TestAPIsToWatch(t *testing.T) {
+	tests := []struct {
+		name           string
+		withRouteAPI   bool
+		wantErr        bool
+		wantObjectsLen int
+	}{
+		{
+			name:           "route API is registered, must return route and service objects",
+			withRouteAPI:   true,
+			wantErr:        false,
+			wantObjectsLen: 2,
+		},
+		{
+			name:           "route API is not registered, must return ErrRouteAPIUnavailable",
+			withRouteAPI:   false,
+			wantErr:        true,
+			wantObjectsLen: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mapper := metaapi.NewDefaultRESTMapper([]schema.GroupVersion{})
+			if tt.withRouteAPI {
+				mapper.Add(routev1.GroupVersion.WithKind("Route"), metaapi.RESTScopeNamespace)
+			}
+			c := fakeClientWithRESTMapper{Client: fakeClientWithObjects(), mapper: mapper}
+			objs, err := APIsToWatch(c)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("APIsToWatch() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if len(objs) != tt.wantObjectsLen {
+				t.Errorf("APIsToWatch() got %d objects, want %d", len(objs), tt.wantObjectsLen)
+			}
+			if !tt.wantErr {
+				return
+			}
+			if !errors.Is(err, ErrRouteAPIUnavailable) {
+				t.Errorf("APIsToWatch() error = %v, want ErrRouteAPIUnavailable", err)
+			}
+			noResourceError := &metaapi.NoResourceMatchError{}
+			if !errors.As(err, &noResourceError) {
+				t.Errorf("APIsToWatch() error = %v, want NoResourceMatchError", err)
+			}
 		})
 	}
 }
@@ -196,7 +712,7 @@ func Test_route_MarkForCleanup(t *testing.T) {
 				namespacedName:  tt.namespacedName,
 				labels:          tt.labels,
 				ownerReferences: testOwnerReferences(),
-				logger:          logrtesting.TestLogger{t},
+				logger:          testr.New(t),
 			}
 			ctx := context.WithValue(context.Background(), "test", tt.name)
 			fakeClient := fakeClientWithObjects(testRouteObjects(true, tt.namespacedName, tt.labels, r.ownerReferences)...)
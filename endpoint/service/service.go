@@ -2,9 +2,12 @@ package service
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/internal/utils"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -13,7 +16,6 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 type service struct {
@@ -27,6 +29,72 @@ type service struct {
 	labels          map[string]string
 	annotations     map[string]string
 	ownerReferences []metav1.OwnerReference
+
+	loadBalancerSourceRanges []string
+	loadBalancerClass        *string
+
+	ipFamilyPolicy *corev1.IPFamilyPolicyType
+	ipFamilies     []corev1.IPFamily
+
+	externalTrafficPolicy corev1.ServiceExternalTrafficPolicyType
+	healthCheckNodePort   int32
+	sessionAffinity       corev1.ServiceAffinity
+	sessionAffinityConfig *corev1.SessionAffinityConfig
+
+	externalDNS *endpoint.ExternalDNS
+
+	reconcileOptions reconcile.Options
+}
+
+// Options allows callers to configure the service endpoint created by NewWithOptions
+type Options struct {
+	// BackendPort is the port of the pods the service forwards traffic to
+	BackendPort int32
+	// IngressPort is the port exposed by the service
+	IngressPort int32
+	// Type is the type of the service, one of LoadBalancer, NodePort or ClusterIP
+	Type corev1.ServiceType
+	// Labels are applied to the service, they are also used as the service selector
+	Labels map[string]string
+	// Annotations are applied to the service, e.g. to configure the cloud load balancer
+	// with service.beta.kubernetes.io/aws-load-balancer-internal
+	Annotations map[string]string
+	// LoadBalancerSourceRanges restricts the client CIDRs allowed by the load balancer,
+	// only valid for LoadBalancer services
+	LoadBalancerSourceRanges []string
+	// LoadBalancerClass selects the load balancer implementation, only valid for LoadBalancer
+	// services. It cannot be changed once the service is created
+	LoadBalancerClass *string
+	// IPFamilyPolicy is the dual-stack policy of the service, defaults to the cluster default
+	IPFamilyPolicy *corev1.IPFamilyPolicyType
+	// IPFamilies are the IP families of the service, the first family is used to pick
+	// the address returned by Hostname. They cannot be changed once the service is created
+	IPFamilies []corev1.IPFamily
+	// ExternalTrafficPolicy set to Local preserves the client IP and avoids a second hop
+	// to the node running the pod, only valid for LoadBalancer and NodePort services
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType
+	// HealthCheckNodePort is the node port used by the load balancer to health check nodes,
+	// only valid for LoadBalancer services with the Local external traffic policy. It is
+	// allocated by the cluster when not set
+	HealthCheckNodePort int32
+	// SessionAffinity pins connections from a client to the same pod
+	SessionAffinity corev1.ServiceAffinity
+	// SessionAffinityConfig configures the ClientIP session affinity
+	SessionAffinityConfig *corev1.SessionAffinityConfig
+	// ExternalDNS annotates the service for external-dns to publish a DNS name for it. Once
+	// the name resolves, the service is healthy and Hostname returns the name instead of the
+	// address of the service
+	ExternalDNS *endpoint.ExternalDNS
+	// OwnerReferences are applied to the service
+	OwnerReferences []metav1.OwnerReference
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate,
+	// see the ServerSideApply field of reconcile.Options
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply, defaults to "pvc-transfer"
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
 }
 
 // AddToScheme should be used as soon as scheme is created to add
@@ -41,9 +109,12 @@ func APIsToWatch() ([]client.Object, error) {
 	return []client.Object{&corev1.Service{}}, nil
 }
 
-// New creates a service endpoint object, deploys the resources on  the cluster
-// and then checks for the health of the service. Before using the fields
-// it is always recommended to check if the service is healthy.
+// New creates a service endpoint object and deploys the resources on  the cluster.
+// Before using the fields it is always recommended to check if the service is healthy.
+//
+// New does not wait for the service to be provisioned. While the load balancer of a
+// LoadBalancer service is provisioning, IsHealthy returns false without an error, callers
+// are expected to poll IsHealthy instead of treating it as a failure.
 //
 // In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
@@ -54,18 +125,53 @@ func New(ctx context.Context, c client.Client, logger logr.Logger,
 	labels map[string]string,
 	annotations map[string]string,
 	ownerReferences []metav1.OwnerReference) (endpoint.Endpoint, error) {
+	return NewWithOptions(ctx, c, logger, namespacedName, Options{
+		BackendPort:     backendPort,
+		IngressPort:     ingressPort,
+		Type:            svcType,
+		Labels:          labels,
+		Annotations:     annotations,
+		OwnerReferences: ownerReferences,
+	})
+}
+
+// NewWithOptions creates the service endpoint object using the given options, refer New
+// for details on using the endpoint.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+func NewWithOptions(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	options Options) (endpoint.Endpoint, error) {
 
 	svcLogger := logger.WithValues("service", namespacedName)
 
 	s := &service{
 		namespacedName:  namespacedName,
-		svcType:         svcType,
-		labels:          labels,
-		annotations:     annotations,
-		ownerReferences: ownerReferences,
-		backendPort:     backendPort,
-		ingressPort:     ingressPort,
+		svcType:         options.Type,
+		labels:          options.Labels,
+		annotations:     endpoint.MergeAnnotations(options.Annotations, options.ExternalDNS),
+		ownerReferences: options.OwnerReferences,
+		backendPort:     options.BackendPort,
+		ingressPort:     options.IngressPort,
 		logger:          svcLogger,
+
+		loadBalancerSourceRanges: options.LoadBalancerSourceRanges,
+		loadBalancerClass:        options.LoadBalancerClass,
+
+		ipFamilyPolicy: options.IPFamilyPolicy,
+		ipFamilies:     options.IPFamilies,
+
+		externalTrafficPolicy: options.ExternalTrafficPolicy,
+		healthCheckNodePort:   options.HealthCheckNodePort,
+		sessionAffinity:       options.SessionAffinity,
+		sessionAffinityConfig: options.SessionAffinityConfig,
+		externalDNS:           options.ExternalDNS,
+		reconcileOptions: reconcile.Options{
+			ServerSideApply: options.ServerSideApply,
+			FieldManager:    options.FieldManager,
+			Adopt:           options.Adopt,
+		},
 	}
 
 	err := s.validate()
@@ -100,32 +206,43 @@ func (s *service) IngressPort() int32 {
 }
 
 func (s *service) IsHealthy(ctx context.Context, c client.Client) (bool, error) {
+	health, err := s.Health(ctx, c)
+	if err != nil {
+		return false, err
+	}
+	if health.Reason == endpoint.HealthReasonMisconfigured {
+		return false, errors.New(health.Message)
+	}
+	if !health.Healthy {
+		s.logger.Info("endpoint is unhealthy", "reason", health.Reason, "message", health.Message)
+	}
+	return health.Healthy, nil
+}
+
+// Health returns the health of the service, the hostname is set to the address assigned
+// by the cluster or to the external-dns hostname once it resolves
+func (s *service) Health(ctx context.Context, c client.Client) (*endpoint.Health, error) {
 	svc := &corev1.Service{}
 	err := c.Get(ctx, s.NamespacedName(), svc)
 	if err != nil {
 		s.logger.Error(err, "unable to get service")
-		return false, err
+		return nil, err
 	}
 
 	switch s.svcType {
 	case corev1.ServiceTypeLoadBalancer:
-		if len(svc.Status.LoadBalancer.Ingress) > 0 {
-			if svc.Status.LoadBalancer.Ingress[0].Hostname != "" {
-				s.hostname = svc.Status.LoadBalancer.Ingress[0].Hostname
-			}
-			if svc.Status.LoadBalancer.Ingress[0].IP != "" {
-				s.hostname = svc.Status.LoadBalancer.Ingress[0].IP
-			}
-			return true, nil
+		if len(svc.Status.LoadBalancer.Ingress) == 0 {
+			return endpoint.Unhealthy(endpoint.HealthReasonProvisioning,
+				"load balancer of service %s is provisioning", s.NamespacedName()), nil
 		}
+		s.hostname = s.loadBalancerAddress(svc.Status.LoadBalancer.Ingress)
 	case corev1.ServiceTypeClusterIP:
 		if svc.Spec.ClusterIP != "" {
-			s.hostname = svc.Spec.ClusterIP
+			s.hostname = s.clusterIP(svc)
 		}
-		return true, nil
 	case corev1.ServiceTypeNodePort:
 		if svc.Spec.ClusterIP != "" {
-			s.hostname = svc.Spec.ClusterIP
+			s.hostname = s.clusterIP(svc)
 			if len(svc.Spec.Ports) > 0 {
 				port := svc.Spec.Ports[0]
 				if port.NodePort != 0 {
@@ -133,12 +250,74 @@ func (s *service) IsHealthy(ctx context.Context, c client.Client) (bool, error)
 				}
 			}
 		}
-		return true, nil
 	default:
-		return false, fmt.Errorf("unsupported service type %s", s.svcType)
+		return endpoint.Unhealthy(endpoint.HealthReasonMisconfigured, "unsupported service type %s", s.svcType), nil
+	}
+
+	if s.externalDNS != nil {
+		resolves, err := s.externalDNS.Resolves(ctx)
+		if err != nil {
+			s.logger.Error(err, "unable to resolve external-dns hostname", "hostname", s.externalDNS.Hostname)
+			return nil, err
+		}
+		if !resolves {
+			return endpoint.Unhealthy(endpoint.HealthReasonDNSNotResolved,
+				"hostname %s of service %s does not resolve yet", s.externalDNS.Hostname, s.NamespacedName()), nil
+		}
+		s.hostname = s.externalDNS.Hostname
 	}
-	s.logger.Info("endpoint is unhealthy")
-	return false, nil
+	return endpoint.Healthy(), nil
+}
+
+// preferredFamily returns the IP family of the addresses returned by Hostname, empty if
+// no family was requested
+func (s *service) preferredFamily() corev1.IPFamily {
+	if len(s.ipFamilies) == 0 {
+		return ""
+	}
+	return s.ipFamilies[0]
+}
+
+// clusterIP returns the cluster IP of the preferred family for dual-stack services
+func (s *service) clusterIP(svc *corev1.Service) string {
+	for _, ip := range svc.Spec.ClusterIPs {
+		if isIPFamily(ip, s.preferredFamily()) {
+			return ip
+		}
+	}
+	return svc.Spec.ClusterIP
+}
+
+// loadBalancerAddress returns the address of the load balancer, preferring an IP of
+// the preferred family when the load balancer reports more than one address
+func (s *service) loadBalancerAddress(ingresses []corev1.LoadBalancerIngress) string {
+	if s.preferredFamily() != "" {
+		for _, ingress := range ingresses {
+			if ingress.IP != "" && isIPFamily(ingress.IP, s.preferredFamily()) {
+				return ingress.IP
+			}
+		}
+	}
+	if ingresses[0].IP != "" {
+		return ingresses[0].IP
+	}
+	return ingresses[0].Hostname
+}
+
+// isIPFamily returns whether the ip belongs to the given family, any valid ip matches
+// an empty family
+func isIPFamily(ip string, family corev1.IPFamily) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	switch family {
+	case corev1.IPv4Protocol:
+		return parsed.To4() != nil
+	case corev1.IPv6Protocol:
+		return parsed.To4() == nil
+	}
+	return true
 }
 
 func (s *service) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
@@ -162,6 +341,35 @@ func (s *service) validate() error {
 	default:
 		return fmt.Errorf("unsupported service type %s", s.svcType)
 	}
+	if s.svcType != corev1.ServiceTypeLoadBalancer &&
+		(len(s.loadBalancerSourceRanges) > 0 || s.loadBalancerClass != nil) {
+		return fmt.Errorf("load balancer options are not supported for service type %s", s.svcType)
+	}
+	if s.externalTrafficPolicy != "" && s.svcType == corev1.ServiceTypeClusterIP {
+		return fmt.Errorf("external traffic policy is not supported for service type %s", s.svcType)
+	}
+	if s.healthCheckNodePort != 0 && (s.svcType != corev1.ServiceTypeLoadBalancer ||
+		s.externalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal) {
+		return fmt.Errorf("health check node port requires a LoadBalancer service with the %s external traffic policy",
+			corev1.ServiceExternalTrafficPolicyTypeLocal)
+	}
+	if len(s.ipFamilies) > 2 {
+		return fmt.Errorf("at most two ip families are supported, got %v", s.ipFamilies)
+	}
+	for _, family := range s.ipFamilies {
+		if family != corev1.IPv4Protocol && family != corev1.IPv6Protocol {
+			return fmt.Errorf("unsupported ip family %s", family)
+		}
+	}
+	for _, sourceRange := range s.loadBalancerSourceRanges {
+		_, _, err := net.ParseCIDR(sourceRange)
+		if err != nil {
+			return fmt.Errorf("invalid load balancer source range %s: %w", sourceRange, err)
+		}
+	}
+	if s.externalDNS != nil {
+		return s.externalDNS.Validate()
+	}
 	return nil
 }
 
@@ -171,11 +379,18 @@ func (s *service) reconcileService(ctx context.Context, c client.Client) error {
 		Namespace: s.namespacedName.Namespace,
 	}}
 
-	// TODO: log the return operation from CreateOrUpdate
-	_, err := controllerutil.CreateOrUpdate(ctx, c, service, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, s.logger, service, s.reconcileOptions, func() error {
 		service.Labels = s.labels
 		service.OwnerReferences = s.ownerReferences
 
+		// annotations are merged, cloud providers annotate services too
+		for key, value := range s.annotations {
+			if service.Annotations == nil {
+				service.Annotations = map[string]string{}
+			}
+			service.Annotations[key] = value
+		}
+
 		service.Spec.Ports = []corev1.ServicePort{
 			{
 				Name:     s.namespacedName.Name,
@@ -190,6 +405,24 @@ func (s *service) reconcileService(ctx context.Context, c client.Client) error {
 		service.Spec.Selector = s.labels
 		if service.CreationTimestamp.IsZero() {
 			service.Spec.Type = s.svcType
+			service.Spec.LoadBalancerClass = s.loadBalancerClass
+			service.Spec.IPFamilies = s.ipFamilies
+		}
+		if s.ipFamilyPolicy != nil {
+			service.Spec.IPFamilyPolicy = s.ipFamilyPolicy
+		}
+		if s.externalTrafficPolicy != "" {
+			service.Spec.ExternalTrafficPolicy = s.externalTrafficPolicy
+		}
+		if s.healthCheckNodePort != 0 {
+			service.Spec.HealthCheckNodePort = s.healthCheckNodePort
+		}
+		if s.sessionAffinity != "" {
+			service.Spec.SessionAffinity = s.sessionAffinity
+			service.Spec.SessionAffinityConfig = s.sessionAffinityConfig
+		}
+		if s.svcType == corev1.ServiceTypeLoadBalancer {
+			service.Spec.LoadBalancerSourceRanges = s.loadBalancerSourceRanges
 		}
 		return nil
 	})
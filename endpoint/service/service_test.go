@@ -3,11 +3,12 @@ package service
 import (
 	"context"
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
 
 	"github.com/backube/pvc-transfer/endpoint"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -143,7 +144,7 @@ func TestNew(t *testing.T) {
 				fakeClient = fakeClientWithObjects()
 			}
 			ctx := context.WithValue(context.Background(), "test", tt.name)
-			fakeLogger := logrtesting.TestLogger{t}
+			fakeLogger := testr.New(t)
 			e, _ := New(ctx, fakeClient, fakeLogger, tt.namespacedName, tt.backendPort, tt.ingressPort, tt.svcType, tt.labels, tt.annotations, tt.ownerReferences)
 
 			healthy, _ := e.IsHealthy(context.TODO(), fakeClient)
@@ -170,6 +171,381 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestNewWithOptions_loadBalancer(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	tests := []struct {
+		name             string
+		options          Options
+		existing         []client.Object
+		wantErr          bool
+		wantAnnotations  map[string]string
+		wantSourceRanges []string
+		wantClass        *string
+	}{
+		{
+			name: "loadbalancer with cloud annotations, source ranges and class",
+			options: Options{
+				BackendPort:              8080,
+				IngressPort:              8080,
+				Type:                     corev1.ServiceTypeLoadBalancer,
+				Annotations:              map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"},
+				LoadBalancerSourceRanges: []string{"10.0.0.0/8"},
+				LoadBalancerClass:        pointer.String("example.com/lb"),
+			},
+			wantErr:          false,
+			wantAnnotations:  map[string]string{"service.beta.kubernetes.io/aws-load-balancer-internal": "true"},
+			wantSourceRanges: []string{"10.0.0.0/8"},
+			wantClass:        pointer.String("example.com/lb"),
+		},
+		{
+			name: "existing loadbalancer with annotations from the cloud provider, must preserve them",
+			options: Options{
+				BackendPort: 8080,
+				IngressPort: 8080,
+				Type:        corev1.ServiceTypeLoadBalancer,
+				Annotations: map[string]string{"service.beta.kubernetes.io/aws-load-balancer-type": "nlb"},
+			},
+			existing: []client.Object{&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "foo",
+					Namespace:   "bar",
+					Annotations: map[string]string{"cloud.example.com/lb-id": "123"},
+				},
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			}},
+			wantErr: false,
+			wantAnnotations: map[string]string{
+				"cloud.example.com/lb-id":                           "123",
+				"service.beta.kubernetes.io/aws-load-balancer-type": "nlb",
+			},
+		},
+		{
+			name: "invalid source range, must return error",
+			options: Options{
+				Type:                     corev1.ServiceTypeLoadBalancer,
+				LoadBalancerSourceRanges: []string{"10.0.0.0"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "load balancer options on a ClusterIP service, must return error",
+			options: Options{
+				Type:              corev1.ServiceTypeClusterIP,
+				LoadBalancerClass: pointer.String("example.com/lb"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects(tt.existing...)
+			_, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			svc := &corev1.Service{}
+			err = fakeClient.Get(context.Background(), namespacedName, svc)
+			if err != nil {
+				t.Fatalf("got an unexpected error from test client: %#v", err)
+			}
+			if !reflect.DeepEqual(svc.Annotations, tt.wantAnnotations) {
+				t.Errorf("service annotations = %#v, want %#v", svc.Annotations, tt.wantAnnotations)
+			}
+			if !reflect.DeepEqual(svc.Spec.LoadBalancerSourceRanges, tt.wantSourceRanges) {
+				t.Errorf("service source ranges = %#v, want %#v", svc.Spec.LoadBalancerSourceRanges, tt.wantSourceRanges)
+			}
+			if !reflect.DeepEqual(svc.Spec.LoadBalancerClass, tt.wantClass) {
+				t.Errorf("service load balancer class = %v, want %v", svc.Spec.LoadBalancerClass, tt.wantClass)
+			}
+		})
+	}
+}
+
+func TestNew_loadBalancerProvisioning(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects(testSVCObjects(false, corev1.ServiceTypeLoadBalancer, namespacedName, map[string]string{"test": "me"}, testOwnerReferences(), 8080, 8080)...)
+	e, err := New(context.Background(), fakeClient, testr.New(t), namespacedName, 8080, 8080, corev1.ServiceTypeLoadBalancer, map[string]string{"test": "me"}, nil, testOwnerReferences())
+	if err != nil {
+		t.Fatalf("New() must not fail while the load balancer is provisioning, got %v", err)
+	}
+	healthy, err := e.IsHealthy(context.Background(), fakeClient)
+	if err != nil {
+		t.Errorf("IsHealthy() must not fail while the load balancer is provisioning, got %v", err)
+	}
+	if healthy {
+		t.Errorf("IsHealthy() must be false while the load balancer is provisioning")
+	}
+}
+
+func TestNewWithOptions_trafficPolicy(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	affinityConfig := &corev1.SessionAffinityConfig{ClientIP: &corev1.ClientIPConfig{TimeoutSeconds: pointer.Int32(3600)}}
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{
+			name: "loadbalancer with local traffic policy, health check port and session affinity",
+			options: Options{
+				Type:                  corev1.ServiceTypeLoadBalancer,
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+				HealthCheckNodePort:   30000,
+				SessionAffinity:       corev1.ServiceAffinityClientIP,
+				SessionAffinityConfig: affinityConfig,
+			},
+			wantErr: false,
+		},
+		{
+			name: "nodeport with local traffic policy",
+			options: Options{
+				Type:                  corev1.ServiceTypeNodePort,
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+			},
+			wantErr: false,
+		},
+		{
+			name: "clusterip with traffic policy, must return error",
+			options: Options{
+				Type:                  corev1.ServiceTypeClusterIP,
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeLocal,
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check port with cluster traffic policy, must return error",
+			options: Options{
+				Type:                  corev1.ServiceTypeLoadBalancer,
+				ExternalTrafficPolicy: corev1.ServiceExternalTrafficPolicyTypeCluster,
+				HealthCheckNodePort:   30000,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			_, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewWithOptions() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			svc := &corev1.Service{}
+			err = fakeClient.Get(context.Background(), namespacedName, svc)
+			if err != nil {
+				t.Fatalf("got an unexpected error from test client: %#v", err)
+			}
+			if svc.Spec.ExternalTrafficPolicy != tt.options.ExternalTrafficPolicy {
+				t.Errorf("external traffic policy = %v, want %v", svc.Spec.ExternalTrafficPolicy, tt.options.ExternalTrafficPolicy)
+			}
+			if svc.Spec.HealthCheckNodePort != tt.options.HealthCheckNodePort {
+				t.Errorf("health check node port = %v, want %v", svc.Spec.HealthCheckNodePort, tt.options.HealthCheckNodePort)
+			}
+			if svc.Spec.SessionAffinity != tt.options.SessionAffinity {
+				t.Errorf("session affinity = %v, want %v", svc.Spec.SessionAffinity, tt.options.SessionAffinity)
+			}
+			if !reflect.DeepEqual(svc.Spec.SessionAffinityConfig, tt.options.SessionAffinityConfig) {
+				t.Errorf("session affinity config = %v, want %v", svc.Spec.SessionAffinityConfig, tt.options.SessionAffinityConfig)
+			}
+		})
+	}
+}
+
+func Test_service_IsHealthy_ipFamilies(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	tests := []struct {
+		name         string
+		svcType      corev1.ServiceType
+		ipFamilies   []corev1.IPFamily
+		spec         corev1.ServiceSpec
+		status       corev1.ServiceStatus
+		wantHostname string
+	}{
+		{
+			name:         "dual-stack ClusterIP preferring IPv6, must return the IPv6 cluster IP",
+			svcType:      corev1.ServiceTypeClusterIP,
+			ipFamilies:   []corev1.IPFamily{corev1.IPv6Protocol, corev1.IPv4Protocol},
+			spec:         corev1.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1", "fd00::1"}},
+			wantHostname: "fd00::1",
+		},
+		{
+			name:         "ClusterIP without ip families, must return the primary cluster IP",
+			svcType:      corev1.ServiceTypeClusterIP,
+			spec:         corev1.ServiceSpec{ClusterIP: "10.0.0.1", ClusterIPs: []string{"10.0.0.1", "fd00::1"}},
+			wantHostname: "10.0.0.1",
+		},
+		{
+			name:       "dual-stack LoadBalancer preferring IPv6, must return the IPv6 address",
+			svcType:    corev1.ServiceTypeLoadBalancer,
+			ipFamilies: []corev1.IPFamily{corev1.IPv6Protocol},
+			status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+				{IP: "192.168.0.1"}, {IP: "2001:db8::1"},
+			}}},
+			wantHostname: "2001:db8::1",
+		},
+		{
+			name:    "LoadBalancer with a hostname, must return the hostname",
+			svcType: corev1.ServiceTypeLoadBalancer,
+			status: corev1.ServiceStatus{LoadBalancer: corev1.LoadBalancerStatus{Ingress: []corev1.LoadBalancerIngress{
+				{Hostname: "foo.bar"},
+			}}},
+			wantHostname: "foo.bar",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: namespacedName.Name, Namespace: namespacedName.Namespace},
+				Spec:       tt.spec,
+				Status:     tt.status,
+			}
+			s := &service{
+				logger:         testr.New(t),
+				namespacedName: namespacedName,
+				svcType:        tt.svcType,
+				ipFamilies:     tt.ipFamilies,
+			}
+			healthy, err := s.IsHealthy(context.Background(), fakeClientWithObjects(svc))
+			if err != nil || !healthy {
+				t.Fatalf("IsHealthy() = %v, %v, want healthy", healthy, err)
+			}
+			if s.Hostname() != tt.wantHostname {
+				t.Errorf("Hostname() = %v, want %v", s.Hostname(), tt.wantHostname)
+			}
+		})
+	}
+}
+
+func TestNewWithOptions_externalDNS(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	published := false
+	externalDNS := &endpoint.ExternalDNS{
+		Hostname: "transfer.example.com",
+		TTL:      60,
+		LookupHost: func(ctx context.Context, host string) ([]string, error) {
+			if !published {
+				return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			}
+			return []string{"192.168.0.1"}, nil
+		},
+	}
+	fakeClient := fakeClientWithObjects(testSVCObjects(true, corev1.ServiceTypeLoadBalancer, namespacedName, map[string]string{"test": "me"}, testOwnerReferences(), 8080, 8080)...)
+	e, err := NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, Options{
+		BackendPort:     8080,
+		IngressPort:     8080,
+		Type:            corev1.ServiceTypeLoadBalancer,
+		Labels:          map[string]string{"test": "me"},
+		Annotations:     map[string]string{"foo": "bar"},
+		ExternalDNS:     externalDNS,
+		OwnerReferences: testOwnerReferences(),
+	})
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	svc := &corev1.Service{}
+	err = fakeClient.Get(context.Background(), namespacedName, svc)
+	if err != nil {
+		t.Fatalf("got an unexpected error from test client: %#v", err)
+	}
+	wantAnnotations := map[string]string{
+		"foo":                                  "bar",
+		endpoint.ExternalDNSHostnameAnnotation: "transfer.example.com",
+		endpoint.ExternalDNSTTLAnnotation:      "60",
+	}
+	if !reflect.DeepEqual(svc.Annotations, wantAnnotations) {
+		t.Errorf("annotations = %v, want %v", svc.Annotations, wantAnnotations)
+	}
+
+	healthy, err := e.IsHealthy(context.Background(), fakeClient)
+	if err != nil || healthy {
+		t.Errorf("IsHealthy() = %v, %v, must be unhealthy until the hostname resolves", healthy, err)
+	}
+	published = true
+	healthy, err = e.IsHealthy(context.Background(), fakeClient)
+	if err != nil || !healthy {
+		t.Fatalf("IsHealthy() = %v, %v, must be healthy once the hostname resolves", healthy, err)
+	}
+	if e.Hostname() != externalDNS.Hostname {
+		t.Errorf("Hostname() = %v, want %v", e.Hostname(), externalDNS.Hostname)
+	}
+
+	_, err = NewWithOptions(context.Background(), fakeClient, testr.New(t), namespacedName, Options{
+		Type:        corev1.ServiceTypeLoadBalancer,
+		ExternalDNS: &endpoint.ExternalDNS{Hostname: "Invalid_Name"},
+	})
+	if err == nil {
+		t.Errorf("NewWithOptions() must fail for an invalid external-dns hostname")
+	}
+}
+
+func Test_service_Health(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	notResolved := &endpoint.ExternalDNS{
+		Hostname: "transfer.example.com",
+		LookupHost: func(ctx context.Context, host string) ([]string, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		},
+	}
+	tests := []struct {
+		name        string
+		svcType     corev1.ServiceType
+		admitted    bool
+		externalDNS *endpoint.ExternalDNS
+		wantReason  endpoint.HealthReason
+	}{
+		{
+			name:       "provisioned nodeport, must report Healthy",
+			svcType:    corev1.ServiceTypeNodePort,
+			admitted:   true,
+			wantReason: endpoint.HealthReasonHealthy,
+		},
+		{
+			name:       "loadbalancer without address, must report Provisioning",
+			svcType:    corev1.ServiceTypeLoadBalancer,
+			wantReason: endpoint.HealthReasonProvisioning,
+		},
+		{
+			name:        "external-dns hostname does not resolve, must report DNSNotResolved",
+			svcType:     corev1.ServiceTypeLoadBalancer,
+			admitted:    true,
+			externalDNS: notResolved,
+			wantReason:  endpoint.HealthReasonDNSNotResolved,
+		},
+		{
+			name:       "unsupported service type, must report Misconfigured",
+			svcType:    corev1.ServiceTypeExternalName,
+			wantReason: endpoint.HealthReasonMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				logger:         testr.New(t),
+				namespacedName: namespacedName,
+				svcType:        tt.svcType,
+				externalDNS:    tt.externalDNS,
+			}
+			c := fakeClientWithObjects(testSVCObjects(tt.admitted, tt.svcType, namespacedName, nil, nil, 8080, 8080)...)
+			got, err := s.Health(context.Background(), c)
+			if err != nil {
+				t.Fatalf("Health() unexpected error %v", err)
+			}
+			if got.Reason != tt.wantReason {
+				t.Errorf("Health() reason = %v, want %v, message %s", got.Reason, tt.wantReason, got.Message)
+			}
+			healthy, err := s.IsHealthy(context.Background(), c)
+			if healthy != got.Healthy || (err != nil) != (tt.wantReason == endpoint.HealthReasonMisconfigured) {
+				t.Errorf("IsHealthy() = %v, %v, inconsistent with reason %v", healthy, err, got.Reason)
+			}
+		})
+	}
+}
+
 func Test_route_MarkForCleanup(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -196,7 +572,7 @@ func Test_route_MarkForCleanup(t *testing.T) {
 				namespacedName:  tt.namespacedName,
 				labels:          tt.labels,
 				ownerReferences: testOwnerReferences(),
-				logger:          logrtesting.TestLogger{t},
+				logger:          testr.New(t),
 			}
 			ctx := context.WithValue(context.Background(), "test", tt.name)
 			fakeClient := fakeClientWithObjects(
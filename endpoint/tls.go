@@ -0,0 +1,51 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrTLSSecretInvalid is returned when the TLS secret of an endpoint cannot be used to
+	// terminate TLS for the hostname of the endpoint
+	ErrTLSSecretInvalid = errors.New("TLS secret invalid")
+)
+
+// GetTLSSecret returns the kubernetes.io/tls secret used by an endpoint to terminate TLS. When
+// hostname is not empty, the certificate in the secret must be valid for it, wildcard
+// certificates included.
+func GetTLSSecret(ctx context.Context, c client.Client, key types.NamespacedName, hostname string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, key, secret)
+	if err != nil {
+		return nil, err
+	}
+	for _, dataKey := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		if len(secret.Data[dataKey]) == 0 {
+			return nil, fmt.Errorf("%w: secret %s has no %s", ErrTLSSecretInvalid, key, dataKey)
+		}
+	}
+	if hostname == "" {
+		return secret, nil
+	}
+	block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+	if block == nil {
+		return nil, fmt.Errorf("%w: %s of secret %s is not PEM encoded", ErrTLSSecretInvalid, corev1.TLSCertKey, key)
+	}
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s of secret %s: %v", ErrTLSSecretInvalid, corev1.TLSCertKey, key, err)
+	}
+	err = certificate.VerifyHostname(hostname)
+	if err != nil {
+		return nil, fmt.Errorf("%w: certificate of secret %s: %v", ErrTLSSecretInvalid, key, err)
+	}
+	return secret, nil
+}
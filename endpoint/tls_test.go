@@ -0,0 +1,92 @@
+package endpoint
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testCertificate returns a PEM encoded self-signed certificate for the given DNS names
+func testCertificate(t *testing.T, dnsNames ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("unable to generate key %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: dnsNames[0]},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("unable to create certificate %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestGetTLSSecret(t *testing.T) {
+	key := types.NamespacedName{Namespace: "foo", Name: "tls"}
+	tests := []struct {
+		name     string
+		data     map[string][]byte
+		hostname string
+		wantErr  error
+	}{
+		{
+			name:     "wildcard certificate for the hostname, must return the secret",
+			data:     map[string][]byte{"tls.crt": testCertificate(t, "*.apps.example.com"), "tls.key": []byte("key")},
+			hostname: "transfer-foo.apps.example.com",
+		},
+		{
+			name: "no hostname, must return the secret",
+			data: map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+		},
+		{
+			name:     "certificate for another hostname, must return ErrTLSSecretInvalid",
+			data:     map[string][]byte{"tls.crt": testCertificate(t, "*.example.org"), "tls.key": []byte("key")},
+			hostname: "transfer-foo.apps.example.com",
+			wantErr:  ErrTLSSecretInvalid,
+		},
+		{
+			name:     "certificate not PEM encoded, must return ErrTLSSecretInvalid",
+			data:     map[string][]byte{"tls.crt": []byte("cert"), "tls.key": []byte("key")},
+			hostname: "transfer-foo.apps.example.com",
+			wantErr:  ErrTLSSecretInvalid,
+		},
+		{
+			name:    "no private key, must return ErrTLSSecretInvalid",
+			data:    map[string][]byte{"tls.crt": []byte("cert")},
+			wantErr: ErrTLSSecretInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name},
+				Data:       tt.data,
+			}).Build()
+			secret, err := GetTLSSecret(context.Background(), c, key, tt.hostname)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Fatalf("GetTLSSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && secret.Name != key.Name {
+				t.Errorf("GetTLSSecret() = %v, want secret %v", secret.Name, key)
+			}
+		})
+	}
+}
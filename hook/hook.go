@@ -0,0 +1,144 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/utils"
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrHookFailed is returned once a hook failed, hooks are not retried
+	ErrHookFailed = errors.New("hook failed")
+)
+
+// Hook is run in the source cluster around a transfer, e.g. to freeze a filesystem or flush a
+// database before the final sync and to resume it after
+type Hook interface {
+	// NamespacedName returns the name of the resources created by the hook
+	NamespacedName() types.NamespacedName
+	// Run starts the hook and returns whether it completed. It is idempotent, callers are expected
+	// to call it again until it completes. Once the hook failed, it returns an error wrapping
+	// ErrHookFailed.
+	Run(ctx context.Context, c client.Client, logger logr.Logger) (bool, error)
+	// MarkForCleanup adds a key-value label to all the resources to be cleaned up
+	MarkForCleanup(ctx context.Context, c client.Client, key, value string) error
+}
+
+// Options configure the resources created by hooks
+type Options struct {
+	// Labels are applied to the resources created by the hook
+	Labels map[string]string
+	// OwnerReferences are applied to the resources created by the hook
+	OwnerReferences []metav1.OwnerReference
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
+}
+
+type job struct {
+	namespacedName types.NamespacedName
+	podSpec        corev1.PodSpec
+	options        Options
+}
+
+// NewJob returns a hook running podSpec in a Job, the hook completes with the Job and fails
+// without retries when the pod fails. The pod is typically given a service account allowed
+// to exec into the application pods or to call the application API.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete;deletecollection
+func NewJob(namespacedName types.NamespacedName, podSpec corev1.PodSpec, options Options) Hook {
+	return &job{
+		namespacedName: namespacedName,
+		podSpec:        podSpec,
+		options:        options,
+	}
+}
+
+func (j *job) NamespacedName() types.NamespacedName {
+	return j.namespacedName
+}
+
+func (j *job) Run(ctx context.Context, c client.Client, logger logr.Logger) (bool, error) {
+	logger = logger.WithValues("hook", j.namespacedName)
+	backoffLimit := int32(0)
+	podSpec := *j.podSpec.DeepCopy()
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+
+	hookJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.namespacedName.Name,
+			Namespace: j.namespacedName.Namespace,
+		},
+	}
+	_, err := reconcile.CreateOrUpdate(ctx, c, logger, hookJob, reconcile.Options{
+		ServerSideApply: j.options.ServerSideApply,
+		FieldManager:    j.options.FieldManager,
+		Adopt:           j.options.Adopt,
+	}, func() error {
+		hookJob.Labels = j.options.Labels
+		hookJob.OwnerReferences = j.options.OwnerReferences
+		// the pod template of jobs is immutable
+		if hookJob.CreationTimestamp.IsZero() {
+			hookJob.Spec = batchv1.JobSpec{
+				BackoffLimit: &backoffLimit,
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: metav1.ObjectMeta{Labels: j.options.Labels},
+					Spec:       podSpec,
+				},
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, condition := range hookJob.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return true, nil
+		case batchv1.JobFailed:
+			return false, fmt.Errorf("%w: job %s: %s", ErrHookFailed, j.namespacedName, condition.Message)
+		}
+	}
+	return false, nil
+}
+
+func (j *job) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
+	hookJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      j.namespacedName.Name,
+			Namespace: j.namespacedName.Namespace,
+		},
+	}
+	return utils.UpdateWithLabel(ctx, c, hookJob, key, value)
+}
+
+// RunAll runs hooks in order and returns whether all of them completed, a hook is only started
+// once the previous one completed
+func RunAll(ctx context.Context, c client.Client, logger logr.Logger, hooks []Hook) (bool, error) {
+	for _, h := range hooks {
+		completed, err := h.Run(ctx, c, logger)
+		if err != nil || !completed {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,94 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr/testr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestJob_Run(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "foo", Name: "freeze"}
+	jobWithCondition := func(conditionType batchv1.JobConditionType) *batchv1.Job {
+		return &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespacedName.Namespace, Name: namespacedName.Name},
+			Status: batchv1.JobStatus{Conditions: []batchv1.JobCondition{{
+				Type:   conditionType,
+				Status: corev1.ConditionTrue,
+			}}},
+		}
+	}
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    bool
+		wantErr error
+	}{
+		{
+			name: "no job, must create the job and return not completed",
+		},
+		{
+			name:    "completed job, must return completed",
+			objects: []client.Object{jobWithCondition(batchv1.JobComplete)},
+			want:    true,
+		},
+		{
+			name:    "failed job, must return ErrHookFailed",
+			objects: []client.Object{jobWithCondition(batchv1.JobFailed)},
+			wantErr: ErrHookFailed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			h := NewJob(namespacedName, corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "freeze", Image: "busybox", Command: []string{"sync"}}},
+			}, Options{Labels: map[string]string{"test": "me"}})
+			got, err := h.Run(context.Background(), c, testr.New(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Run() = %v, want %v", got, tt.want)
+			}
+			job := &batchv1.Job{}
+			err = c.Get(context.Background(), namespacedName, job)
+			if err != nil {
+				t.Fatalf("unable to get hook job %v", err)
+			}
+			if job.Labels["test"] != "me" {
+				t.Errorf("hook job labels = %v, want the hook labels", job.Labels)
+			}
+		})
+	}
+}
+
+func TestJob_Run_podSpec(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	namespacedName := types.NamespacedName{Namespace: "foo", Name: "freeze"}
+	h := NewJob(namespacedName, corev1.PodSpec{
+		Containers: []corev1.Container{{Name: "freeze", Image: "busybox"}},
+	}, Options{})
+	_, err := h.Run(context.Background(), c, testr.New(t))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	job := &batchv1.Job{}
+	err = c.Get(context.Background(), namespacedName, job)
+	if err != nil {
+		t.Fatalf("unable to get hook job %v", err)
+	}
+	if job.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("hook pod restart policy = %s, want %s", job.Spec.Template.Spec.RestartPolicy, corev1.RestartPolicyNever)
+	}
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit != 0 {
+		t.Errorf("hook job backoff limit = %v, want 0", job.Spec.BackoffLimit)
+	}
+}
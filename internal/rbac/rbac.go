@@ -0,0 +1,145 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr"
+	securityv1 "github.com/openshift/api/security/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Names are the names of the service account, role and role binding of transfer pods
+type Names struct {
+	ServiceAccount string
+	Role           string
+	RoleBinding    string
+}
+
+// Options configure the service account, role and role binding of transfer pods
+type Options struct {
+	// Labels are applied to all the reconciled objects
+	Labels map[string]string
+	// OwnerReferences are applied to all the reconciled objects
+	OwnerReferences []metav1.OwnerReference
+	// SCCName is the OpenShift SecurityContextConstraints the service account is allowed to
+	// use, the role grants nothing when it is empty
+	SCCName string
+	// ServiceAccountName is an existing service account bound to the role instead of the
+	// service account of Names, which is then not created
+	ServiceAccountName string
+	// Reconcile determines how objects are written to the apiserver
+	Reconcile reconcile.Options
+}
+
+// Reconcile creates a service account for transfer pods along with a role and a role binding
+// granting it the least privileges required. Transfer pods do not talk to the apiserver, the
+// service account token is not mounted and the role only allows using the SCC of the options.
+//
+// Creating a role allowing to use an SCC requires the caller to be allowed to use it, an error
+// wrapping transfer.ErrSCCNotUsable is returned otherwise.
+func Reconcile(ctx context.Context, c client.Client, logger logr.Logger, namespace string, names Names, options Options) error {
+	serviceAccountName := options.ServiceAccountName
+	if serviceAccountName == "" {
+		serviceAccountName = names.ServiceAccount
+		automountToken := false
+		sa := &corev1.ServiceAccount{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      names.ServiceAccount,
+				Namespace: namespace,
+			},
+		}
+		_, err := reconcile.CreateOrUpdate(ctx, c, logger, sa, options.Reconcile, func() error {
+			sa.Labels = options.Labels
+			sa.OwnerReferences = options.OwnerReferences
+			sa.AutomountServiceAccountToken = &automountToken
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.Role,
+			Namespace: namespace,
+		},
+	}
+	_, err := reconcile.CreateOrUpdate(ctx, c, logger, role, options.Reconcile, func() error {
+		role.Labels = options.Labels
+		role.OwnerReferences = options.OwnerReferences
+		role.Rules = Rules(options)
+		return nil
+	})
+	if k8serrors.IsForbidden(err) && options.SCCName != "" {
+		return fmt.Errorf("%w: unable to grant the use of SCC %s: %v", transfer.ErrSCCNotUsable, options.SCCName, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	roleBinding := &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.RoleBinding,
+			Namespace: namespace,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, roleBinding, options.Reconcile, func() error {
+		roleBinding.Labels = options.Labels
+		roleBinding.OwnerReferences = options.OwnerReferences
+		// the role reference of a role binding is immutable
+		roleBinding.RoleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     names.Role,
+		}
+		roleBinding.Subjects = []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccountName,
+			Namespace: namespace,
+		}}
+		return nil
+	})
+	return err
+}
+
+// ValidateSCC returns an error wrapping transfer.ErrSCCNotUsable when the SCC does not exist or
+// the cluster does not serve SCCs. The security.openshift.io/v1 types must be added to the scheme
+// of the client.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get;use
+func ValidateSCC(ctx context.Context, c client.Client, name string) error {
+	scc := &securityv1.SecurityContextConstraints{}
+	err := c.Get(ctx, types.NamespacedName{Name: name}, scc)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return fmt.Errorf("%w: SCC %s not found", transfer.ErrSCCNotUsable, name)
+	case meta.IsNoMatchError(err):
+		return fmt.Errorf("%w: SCCs are not served by the cluster", transfer.ErrSCCNotUsable)
+	}
+	return err
+}
+
+// Rules returns the rules of the role of transfer pods
+func Rules(options Options) []rbacv1.PolicyRule {
+	rules := []rbacv1.PolicyRule{}
+	if options.SCCName != "" {
+		rules = append(rules, rbacv1.PolicyRule{
+			APIGroups:     []string{"security.openshift.io"},
+			Resources:     []string{"securitycontextconstraints"},
+			ResourceNames: []string{options.SCCName},
+			Verbs:         []string{"use"},
+		})
+	}
+	return rules
+}
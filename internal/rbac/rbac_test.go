@@ -0,0 +1,144 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr/testr"
+	securityv1 "github.com/openshift/api/security/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestReconcile(t *testing.T) {
+	names := Names{ServiceAccount: "rsync-sa-foo", Role: "rsync-role-foo", RoleBinding: "rsync-rolebinding-foo"}
+	tests := []struct {
+		name      string
+		options   Options
+		wantRules []rbacv1.PolicyRule
+	}{
+		{
+			name:      "no SCC, role must grant nothing",
+			options:   Options{Labels: map[string]string{"test": "me"}},
+			wantRules: []rbacv1.PolicyRule{},
+		},
+		{
+			name:    "SCC set, role must only allow using the SCC",
+			options: Options{Labels: map[string]string{"test": "me"}, SCCName: "rsync-scc"},
+			wantRules: []rbacv1.PolicyRule{{
+				APIGroups:     []string{"security.openshift.io"},
+				Resources:     []string{"securitycontextconstraints"},
+				ResourceNames: []string{"rsync-scc"},
+				Verbs:         []string{"use"},
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = corev1.AddToScheme(scheme)
+			_ = rbacv1.AddToScheme(scheme)
+			c := fake.NewClientBuilder().WithScheme(scheme).Build()
+			ctx := context.Background()
+
+			err := Reconcile(ctx, c, testr.New(t), "foo", names, tt.options)
+			if err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			sa := &corev1.ServiceAccount{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: "foo", Name: names.ServiceAccount}, sa)
+			if err != nil {
+				t.Fatalf("unable to get service account %v", err)
+			}
+			if sa.AutomountServiceAccountToken == nil || *sa.AutomountServiceAccountToken {
+				t.Error("service account token must not be mounted")
+			}
+			role := &rbacv1.Role{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: "foo", Name: names.Role}, role)
+			if err != nil {
+				t.Fatalf("unable to get role %v", err)
+			}
+			if !reflect.DeepEqual(role.Rules, tt.wantRules) {
+				t.Errorf("role rules = %v, want %v", role.Rules, tt.wantRules)
+			}
+			roleBinding := &rbacv1.RoleBinding{}
+			err = c.Get(ctx, types.NamespacedName{Namespace: "foo", Name: names.RoleBinding}, roleBinding)
+			if err != nil {
+				t.Fatalf("unable to get role binding %v", err)
+			}
+			if roleBinding.RoleRef.Name != names.Role || len(roleBinding.Subjects) != 1 ||
+				roleBinding.Subjects[0].Name != names.ServiceAccount {
+				t.Errorf("role binding = %v, want the role bound to the service account", roleBinding)
+			}
+			if sa.Labels["test"] != "me" || role.Labels["test"] != "me" || roleBinding.Labels["test"] != "me" {
+				t.Error("labels not applied to all the reconciled objects")
+			}
+		})
+	}
+}
+
+func TestValidateSCC(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = securityv1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&securityv1.SecurityContextConstraints{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-scc"},
+	}).Build()
+	tests := []struct {
+		name    string
+		scc     string
+		wantErr error
+	}{
+		{
+			name: "existing SCC, must be valid",
+			scc:  "rsync-scc",
+		},
+		{
+			name:    "missing SCC, must return ErrSCCNotUsable",
+			scc:     "privileged",
+			wantErr: transfer.ErrSCCNotUsable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSCC(context.Background(), c, tt.scc)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateSCC() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReconcile_existingServiceAccount(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	_ = rbacv1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	names := Names{ServiceAccount: "rsync-sa-foo", Role: "rsync-role-foo", RoleBinding: "rsync-rolebinding-foo"}
+
+	err := Reconcile(context.Background(), c, testr.New(t), "foo", names,
+		Options{SCCName: "rsync-scc", ServiceAccountName: "custom"})
+	if err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: names.ServiceAccount}, &corev1.ServiceAccount{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("service account created for an existing service account, error = %v", err)
+	}
+	roleBinding := &rbacv1.RoleBinding{}
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: names.RoleBinding}, roleBinding)
+	if err != nil {
+		t.Fatalf("unable to get role binding %v", err)
+	}
+	if len(roleBinding.Subjects) != 1 || roleBinding.Subjects[0].Name != "custom" {
+		t.Errorf("role binding subjects = %v, want the existing service account", roleBinding.Subjects)
+	}
+}
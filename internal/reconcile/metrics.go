@@ -0,0 +1,27 @@
+package reconcile
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const resultError = "error"
+
+var operationsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "pvc_transfer_reconcile_operations_total",
+		Help: "Total number of objects reconciled by pvc-transfer, partitioned by kind and result",
+	},
+	[]string{"kind", "result"},
+)
+
+func init() {
+	// registered with the controller-runtime registry so that the metrics are
+	// served by the manager of the consuming controller
+	metrics.Registry.MustRegister(operationsTotal)
+}
+
+// recordOperation increments the reconcile operation counter for the given kind and result
+func recordOperation(kind, result string) {
+	operationsTotal.WithLabelValues(kind, result).Inc()
+}
@@ -0,0 +1,176 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// DefaultFieldManager is the field manager used for Server-Side Apply when
+// callers do not specify one
+const DefaultFieldManager = "pvc-transfer"
+
+// ErrResourceConflict is returned when an object to reconcile already exists and is owned
+// by an owner other than the owners of the desired state
+var ErrResourceConflict = errors.New("resource conflict")
+
+// Options determine how objects are written to the apiserver, the options of the transports,
+// endpoints and transfers are passed on to them
+type Options struct {
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate.
+	// Only the fields set by the mutate function are applied, leaving fields defaulted by
+	// other controllers and webhooks untouched instead of reverting them on every update.
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply
+	FieldManager string
+	// Adopt takes over existing objects owned by other owners instead of returning
+	// ErrResourceConflict, e.g. when owners were recreated after a controller upgrade
+	Adopt bool
+}
+
+// CreateOrUpdate reconciles obj with the desired state set by the mutate function f.
+//
+// By default, it delegates to controllerutil.CreateOrUpdate which reads the object,
+// runs f on the existing object and updates it. When ServerSideApply is set, f runs on an
+// object holding only the name and namespace and the result is applied with the configured
+// field manager, so that fields set by other controllers and webhooks are left untouched.
+// With Server-Side Apply, f must set the complete desired state every time it is called.
+//
+// An error wrapping ErrResourceConflict is returned, and the object left untouched, when it
+// exists with an owner reference which is not among the owner references set by f, unless
+// Adopt is set. Objects without owner references are not considered conflicting.
+//
+// The result of the operation is logged using the given logger and recorded in the
+// reconcile operation metrics.
+func CreateOrUpdate(ctx context.Context, c client.Client, logger logr.Logger, obj client.Object, o Options, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	var (
+		result controllerutil.OperationResult
+		err    error
+	)
+	if o.ServerSideApply {
+		result, err = apply(ctx, c, obj, o, f)
+	} else {
+		result, err = controllerutil.CreateOrUpdate(ctx, c, obj, func() error {
+			// the resource version is only set once the object was read from the apiserver
+			if obj.GetResourceVersion() == "" {
+				return f()
+			}
+			existingOwners := obj.GetOwnerReferences()
+			err := f()
+			if err != nil {
+				return err
+			}
+			return checkOwners(obj, existingOwners, o)
+		})
+	}
+
+	kind := kindForObject(c, obj)
+	if err != nil {
+		recordOperation(kind, resultError)
+		logger.Error(err, "unable to reconcile object", "kind", kind, "object", client.ObjectKeyFromObject(obj))
+		return result, err
+	}
+
+	recordOperation(kind, string(result))
+	switch result {
+	case controllerutil.OperationResultNone:
+		logger.V(4).Info("object unchanged", "kind", kind, "object", client.ObjectKeyFromObject(obj))
+	default:
+		logger.Info("object "+string(result), "kind", kind, "object", client.ObjectKeyFromObject(obj))
+	}
+	return result, nil
+}
+
+// kindForObject returns the kind of the object for logs and metrics
+func kindForObject(c client.Client, obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return "unknown"
+	}
+	return gvk.Kind
+}
+
+func apply(ctx context.Context, c client.Client, obj client.Object, o Options, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	key := client.ObjectKeyFromObject(obj)
+
+	// the existing object is only read to report the result of the operation
+	existing, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return controllerutil.OperationResultNone, fmt.Errorf("unable to copy object %s", key)
+	}
+	exists := true
+	err := c.Get(ctx, key, existing)
+	switch {
+	case k8serrors.IsNotFound(err):
+		exists = false
+	case err != nil:
+		return controllerutil.OperationResultNone, err
+	}
+
+	if err := f(); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	if exists {
+		if err := checkOwners(obj, existing.GetOwnerReferences(), o); err != nil {
+			return controllerutil.OperationResultNone, err
+		}
+	}
+	if key != client.ObjectKeyFromObject(obj) {
+		return controllerutil.OperationResultNone, fmt.Errorf("MutateFn cannot mutate object name and/or object namespace")
+	}
+
+	// apply patches must carry the apiVersion and kind of the object
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetManagedFields(nil)
+	obj.SetResourceVersion("")
+
+	fieldManager := o.FieldManager
+	if fieldManager == "" {
+		fieldManager = DefaultFieldManager
+	}
+
+	err = c.Patch(ctx, obj, client.Apply, client.FieldOwner(fieldManager), client.ForceOwnership)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+
+	switch {
+	case !exists:
+		return controllerutil.OperationResultCreated, nil
+	case existing.GetResourceVersion() == obj.GetResourceVersion():
+		return controllerutil.OperationResultNone, nil
+	default:
+		return controllerutil.OperationResultUpdated, nil
+	}
+}
+
+// checkOwners returns an error wrapping ErrResourceConflict when an existing owner reference
+// is not among the owner references of the desired state obj and adoption is not allowed
+func checkOwners(obj client.Object, existingOwners []metav1.OwnerReference, o Options) error {
+	if o.Adopt {
+		return nil
+	}
+	desired := map[types.UID]bool{}
+	for _, owner := range obj.GetOwnerReferences() {
+		desired[owner.UID] = true
+	}
+	for _, owner := range existingOwners {
+		if !desired[owner.UID] {
+			return fmt.Errorf("%w: %s is owned by %s %s (%s)", ErrResourceConflict,
+				client.ObjectKeyFromObject(obj), owner.Kind, owner.Name, owner.UID)
+		}
+	}
+	return nil
+}
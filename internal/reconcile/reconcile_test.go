@@ -0,0 +1,250 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-logr/logr/testr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// applyRecordingClient records apply patches instead of sending them, the fake
+// client does not support Server-Side Apply
+type applyRecordingClient struct {
+	client.Client
+
+	patchType    types.PatchType
+	fieldManager string
+	force        bool
+	gvk          schema.GroupVersionKind
+	data         map[string]string
+}
+
+func (c *applyRecordingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	patchOptions := &client.PatchOptions{}
+	patchOptions.ApplyOptions(opts)
+	c.patchType = patch.Type()
+	c.fieldManager = patchOptions.FieldManager
+	c.force = patchOptions.Force != nil && *patchOptions.Force
+	c.gvk = obj.GetObjectKind().GroupVersionKind()
+	if cm, ok := obj.(*corev1.ConfigMap); ok {
+		c.data = cm.Data
+	}
+	obj.SetResourceVersion("2")
+	return nil
+}
+
+func testConfigMap(data map[string]string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "foo",
+			Namespace:       "bar",
+			ResourceVersion: "1",
+		},
+		Data: data,
+	}
+}
+
+func TestCreateOrUpdate(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing []client.Object
+		data     map[string]string
+		want     controllerutil.OperationResult
+	}{
+		{
+			name:     "object does not exist, must be created",
+			existing: []client.Object{},
+			data:     map[string]string{"foo": "bar"},
+			want:     controllerutil.OperationResultCreated,
+		},
+		{
+			name:     "object exists with different data, must be updated",
+			existing: []client.Object{testConfigMap(map[string]string{"foo": "baz"})},
+			data:     map[string]string{"foo": "bar"},
+			want:     controllerutil.OperationResultUpdated,
+		},
+		{
+			name:     "object exists with same data, must not be updated",
+			existing: []client.Object{testConfigMap(map[string]string{"foo": "bar"})},
+			data:     map[string]string{"foo": "bar"},
+			want:     controllerutil.OperationResultNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.existing...).Build()
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+			got, err := CreateOrUpdate(context.Background(), c, testr.New(t), cm, Options{}, func() error {
+				cm.Data = tt.data
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("CreateOrUpdate() unexpected error %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("CreateOrUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdate_serverSideApply(t *testing.T) {
+	tests := []struct {
+		name             string
+		existing         []client.Object
+		options          Options
+		wantResult       controllerutil.OperationResult
+		wantFieldManager string
+	}{
+		{
+			name:             "object does not exist, must be applied with default field manager",
+			existing:         []client.Object{},
+			options:          Options{ServerSideApply: true},
+			wantResult:       controllerutil.OperationResultCreated,
+			wantFieldManager: DefaultFieldManager,
+		},
+		{
+			name:             "object exists, must be applied with the given field manager",
+			existing:         []client.Object{testConfigMap(map[string]string{"foo": "baz"})},
+			options:          Options{ServerSideApply: true, FieldManager: "test-manager"},
+			wantResult:       controllerutil.OperationResultUpdated,
+			wantFieldManager: "test-manager",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &applyRecordingClient{Client: fake.NewClientBuilder().WithObjects(tt.existing...).Build()}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+			got, err := CreateOrUpdate(context.Background(), c, testr.New(t), cm, tt.options, func() error {
+				cm.Data = map[string]string{"foo": "bar"}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("CreateOrUpdate() unexpected error %v", err)
+			}
+			if got != tt.wantResult {
+				t.Errorf("CreateOrUpdate() = %v, want %v", got, tt.wantResult)
+			}
+			if c.patchType != types.ApplyPatchType {
+				t.Errorf("patch type = %v, want %v", c.patchType, types.ApplyPatchType)
+			}
+			if c.fieldManager != tt.wantFieldManager {
+				t.Errorf("field manager = %v, want %v", c.fieldManager, tt.wantFieldManager)
+			}
+			if !c.force {
+				t.Errorf("apply patch must force ownership")
+			}
+			if c.gvk.Kind != "ConfigMap" || c.gvk.Version != "v1" {
+				t.Errorf("apply patch must carry the GVK of the object, got %v", c.gvk)
+			}
+			if c.data["foo"] != "bar" {
+				t.Errorf("apply patch must carry the desired state, got %v", c.data)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdate_mutateName(t *testing.T) {
+	for _, ssa := range []bool{false, true} {
+		c := &applyRecordingClient{Client: fake.NewClientBuilder().Build()}
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+		_, err := CreateOrUpdate(context.Background(), c, testr.New(t), cm, Options{ServerSideApply: ssa}, func() error {
+			cm.Name = "baz"
+			return nil
+		})
+		if err == nil {
+			t.Errorf("CreateOrUpdate() with ServerSideApply %v must fail when the name is mutated", ssa)
+		}
+	}
+}
+
+func TestCreateOrUpdate_owners(t *testing.T) {
+	owner := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "owner-uid"}
+	other := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "other", UID: "other-uid"}
+	ownedBy := func(owners ...metav1.OwnerReference) *corev1.ConfigMap {
+		cm := testConfigMap(map[string]string{"foo": "baz"})
+		cm.OwnerReferences = owners
+		return cm
+	}
+	tests := []struct {
+		name     string
+		existing *corev1.ConfigMap
+		options  Options
+		wantErr  error
+	}{
+		{
+			name:     "object owned by the desired owner, must be updated",
+			existing: ownedBy(owner),
+		},
+		{
+			name:     "object without owners, must be updated",
+			existing: ownedBy(),
+		},
+		{
+			name:     "object owned by another owner, must return ErrResourceConflict",
+			existing: ownedBy(other),
+			wantErr:  ErrResourceConflict,
+		},
+		{
+			name:     "object owned by another owner with adoption, must be updated",
+			existing: ownedBy(other),
+			options:  Options{Adopt: true},
+		},
+		{
+			name:     "object owned by another owner with Server-Side Apply, must return ErrResourceConflict",
+			existing: ownedBy(other),
+			options:  Options{ServerSideApply: true},
+			wantErr:  ErrResourceConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &applyRecordingClient{Client: fake.NewClientBuilder().WithObjects(tt.existing).Build()}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+			_, err := CreateOrUpdate(context.Background(), c, testr.New(t), cm, tt.options, func() error {
+				cm.OwnerReferences = []metav1.OwnerReference{owner}
+				cm.Data = map[string]string{"foo": "bar"}
+				return nil
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateOrUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil || tt.options.ServerSideApply {
+				return
+			}
+			existing := &corev1.ConfigMap{}
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(cm), existing)
+			if err != nil {
+				t.Fatalf("unable to get configmap %v", err)
+			}
+			if existing.Data["foo"] != "baz" {
+				t.Errorf("conflicting object was updated, data = %v", existing.Data)
+			}
+		})
+	}
+}
+
+func TestCreateOrUpdate_metrics(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	before := testutil.ToFloat64(operationsTotal.WithLabelValues("ConfigMap", string(controllerutil.OperationResultCreated)))
+	cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+	_, err := CreateOrUpdate(context.Background(), c, testr.New(t), cm, Options{}, func() error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("CreateOrUpdate() unexpected error %v", err)
+	}
+	after := testutil.ToFloat64(operationsTotal.WithLabelValues("ConfigMap", string(controllerutil.OperationResultCreated)))
+	if after-before != 1 {
+		t.Errorf("created operations for ConfigMap increased by %v, want 1", after-before)
+	}
+}
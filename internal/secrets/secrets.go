@@ -0,0 +1,63 @@
+package secrets
+
+import (
+	"crypto/rand"
+	b64 "encoding/base64"
+	"fmt"
+	"math/big"
+)
+
+const (
+	// PasswordLength is the length of the passwords generated by GeneratePassword
+	PasswordLength = 32
+	// PSKLength is the number of random bytes in the keys generated by GeneratePSK
+	PSKLength = 32
+)
+
+var letters = []byte("abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
+
+// RandomBytes returns n bytes read from the cryptographically secure random source
+func RandomBytes(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// RandomString returns a string of n alphanumeric characters picked uniformly
+// from the cryptographically secure random source
+func RandomString(n int) (string, error) {
+	if n < 0 {
+		return "", fmt.Errorf("invalid length %d", n)
+	}
+	s := make([]byte, 0, n)
+	max := big.NewInt(int64(len(letters)))
+	for i := 0; i < n; i++ {
+		num, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		s = append(s, letters[num.Int64()])
+	}
+	return string(s), nil
+}
+
+// GeneratePassword returns an alphanumeric password of PasswordLength characters
+func GeneratePassword() (string, error) {
+	return RandomString(PasswordLength)
+}
+
+// GeneratePSK returns a base64 encoded pre-shared key of PSKLength random bytes,
+// the encoded key only holds printable characters as required by stunnel
+func GeneratePSK() (string, error) {
+	key, err := RandomBytes(PSKLength)
+	if err != nil {
+		return "", err
+	}
+	return b64.StdEncoding.EncodeToString(key), nil
+}
@@ -0,0 +1,111 @@
+package secrets
+
+import (
+	b64 "encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestRandomString(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{
+			name: "32 characters",
+			n:    32,
+		},
+		{
+			name: "empty string",
+			n:    0,
+		},
+		{
+			name:    "negative length, must fail",
+			n:       -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RandomString(tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RandomString() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(got) != tt.n {
+				t.Errorf("RandomString() length = %d, want %d", len(got), tt.n)
+			}
+			for _, c := range got {
+				if !strings.ContainsRune(string(letters), c) {
+					t.Errorf("RandomString() = %q, contains invalid character %q", got, c)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		n       int
+		wantErr bool
+	}{
+		{
+			name: "32 bytes",
+			n:    32,
+		},
+		{
+			name:    "negative length, must fail",
+			n:       -1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RandomBytes(tt.n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("RandomBytes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && len(got) != tt.n {
+				t.Errorf("RandomBytes() length = %d, want %d", len(got), tt.n)
+			}
+		})
+	}
+}
+
+func TestGeneratePassword(t *testing.T) {
+	got, err := GeneratePassword()
+	if err != nil {
+		t.Fatalf("GeneratePassword() error = %v", err)
+	}
+	if len(got) != PasswordLength {
+		t.Errorf("GeneratePassword() length = %d, want %d", len(got), PasswordLength)
+	}
+	if strings.ContainsRune(got, 0) {
+		t.Errorf("GeneratePassword() = %q, contains NUL bytes", got)
+	}
+	other, err := GeneratePassword()
+	if err != nil {
+		t.Fatalf("GeneratePassword() error = %v", err)
+	}
+	if got == other {
+		t.Error("GeneratePassword() returned the same password twice")
+	}
+}
+
+func TestGeneratePSK(t *testing.T) {
+	got, err := GeneratePSK()
+	if err != nil {
+		t.Fatalf("GeneratePSK() error = %v", err)
+	}
+	key, err := b64.StdEncoding.DecodeString(got)
+	if err != nil {
+		t.Fatalf("GeneratePSK() = %q, not base64 encoded: %v", got, err)
+	}
+	if len(key) != PSKLength {
+		t.Errorf("GeneratePSK() decoded length = %d, want %d", len(key), PSKLength)
+	}
+}
@@ -27,5 +27,5 @@ func UpdateWithLabel(ctx context.Context, c client.Client, obj client.Object, ke
 
 	obj.SetLabels(labels)
 
-	return c.Update(context.TODO(), obj)
+	return c.Update(ctx, obj)
 }
@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/internal/testutils"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestUpdateWithLabel(t *testing.T) {
+	tests := []struct {
+		name       string
+		cancelled  bool
+		wantErr    error
+		wantLabels map[string]string
+	}{
+		{
+			name:       "context is not cancelled, must add the label",
+			cancelled:  false,
+			wantErr:    nil,
+			wantLabels: map[string]string{"test": "me", "cleanup-key": "cleanup-value"},
+		},
+		{
+			name:       "context is cancelled, must return context error and not update the object",
+			cancelled:  true,
+			wantErr:    context.Canceled,
+			wantLabels: map[string]string{"test": "me"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testutils.ContextAwareClient{Client: fake.NewClientBuilder().WithObjects(&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "foo",
+					Namespace: "bar",
+					Labels:    map[string]string{"test": "me"},
+				},
+			}).Build()}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancelled {
+				cancel()
+			}
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}}
+			err := UpdateWithLabel(ctx, c, cm, "cleanup-key", "cleanup-value")
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("UpdateWithLabel() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := &corev1.ConfigMap{}
+			err = c.Client.Get(context.Background(), types.NamespacedName{Name: "foo", Namespace: "bar"}, got)
+			if err != nil {
+				t.Fatalf("should not be getting error from fake client %v", err)
+			}
+			if !reflect.DeepEqual(got.Labels, tt.wantLabels) {
+				t.Errorf("labels on configmap = %#v, wanted %#v", got.Labels, tt.wantLabels)
+			}
+		})
+	}
+}
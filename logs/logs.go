@@ -0,0 +1,65 @@
+// Package logs reads the logs of the pods of a transfer, e.g. to show them in a UI. The pods of
+// a transfer are returned by the Pods of its transfer client and server:
+//
+//	pods, err := client.Pods(ctx, c)
+//	...
+//	tail, err := logs.TailLogs(ctx, restConfig, pods[pvc], rsync.RsyncContainer, 20)
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrLinesInvalid is returned when the number of lines to tail is not positive
+var ErrLinesInvalid = errors.New("lines invalid")
+
+// TailLogs returns the last lines of the logs of the container of a pod
+func TailLogs(ctx context.Context, config *rest.Config, pod client.ObjectKey, container string, lines int64) (string, error) {
+	if lines <= 0 {
+		return "", fmt.Errorf("%w: %d lines must be positive", ErrLinesInvalid, lines)
+	}
+	stream, err := streamLogs(ctx, config, pod, &corev1.PodLogOptions{Container: container, TailLines: &lines})
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// FollowLogs streams the logs of the container of a pod until the container terminates or the
+// context is done, callers are expected to close the stream
+func FollowLogs(ctx context.Context, config *rest.Config, pod client.ObjectKey, container string) (io.ReadCloser, error) {
+	return streamLogs(ctx, config, pod, &corev1.PodLogOptions{Container: container, Follow: true})
+}
+
+// streamLogs opens the stream of the logs of a pod. Logs cannot be read with the clients of
+// controller-runtime which only read objects, they are read with a REST client of the core API.
+func streamLogs(ctx context.Context, config *rest.Config, pod client.ObjectKey, options *corev1.PodLogOptions) (io.ReadCloser, error) {
+	config = rest.CopyConfig(config)
+	config.APIPath = "/api"
+	config.GroupVersion = &corev1.SchemeGroupVersion
+	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
+	restClient, err := rest.RESTClientFor(config)
+	if err != nil {
+		return nil, err
+	}
+	return restClient.Get().
+		Namespace(pod.Namespace).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("log").
+		VersionedParams(options, scheme.ParameterCodec).
+		Stream(ctx)
+}
@@ -0,0 +1,89 @@
+package logs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// testServer serves the logs of the rsync container of the pod foo/bar, the query of each
+// request is echoed back in the logs
+func testServer(t *testing.T) *rest.Config {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/api/v1/namespaces/foo/pods/bar/log" || query.Get("container") != "rsync" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "tailLines=%s follow=%s", query.Get("tailLines"), query.Get("follow"))
+	}))
+	t.Cleanup(server.Close)
+	return &rest.Config{Host: server.URL}
+}
+
+func TestTailLogs(t *testing.T) {
+	config := testServer(t)
+	tests := []struct {
+		name      string
+		pod       client.ObjectKey
+		container string
+		lines     int64
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "tail of the container logs, must return the last lines",
+			pod:       client.ObjectKey{Namespace: "foo", Name: "bar"},
+			container: "rsync",
+			lines:     10,
+			want:      "tailLines=10 follow=",
+		},
+		{
+			name:      "unknown container, must return an error",
+			pod:       client.ObjectKey{Namespace: "foo", Name: "bar"},
+			container: "stunnel",
+			lines:     10,
+			wantErr:   true,
+		},
+		{
+			name:      "no lines, must return ErrLinesInvalid",
+			pod:       client.ObjectKey{Namespace: "foo", Name: "bar"},
+			container: "rsync",
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TailLogs(context.Background(), config, tt.pod, tt.container, tt.lines)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("TailLogs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.lines <= 0 && !errors.Is(err, ErrLinesInvalid) {
+				t.Errorf("TailLogs() error = %v, want ErrLinesInvalid", err)
+			}
+			if got != tt.want {
+				t.Errorf("TailLogs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowLogs(t *testing.T) {
+	config := testServer(t)
+	stream, err := FollowLogs(context.Background(), config, client.ObjectKey{Namespace: "foo", Name: "bar"}, "rsync")
+	if err != nil {
+		t.Fatalf("FollowLogs() error = %v", err)
+	}
+	defer stream.Close()
+	got, err := io.ReadAll(stream)
+	if err != nil || string(got) != "tailLines= follow=true" {
+		t.Errorf("FollowLogs() = %q, %v, want the followed logs", got, err)
+	}
+}
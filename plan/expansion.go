@@ -0,0 +1,176 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrExpansionInvalid is returned when the expansion of the destination PVCs of a plan is
+	// not valid
+	ErrExpansionInvalid = errors.New("expansion invalid")
+)
+
+// ExpandedForAnnotation is set on the destination PVCs expanded by a plan, it holds the time
+// the transfer client failed on a full destination. The client is retried once the PVCs
+// expanded for its failure were resized.
+const ExpandedForAnnotation = "pvc-transfer.backube.dev/expanded-for"
+
+// DefaultExpansionFactor is the factor of expansions which do not set one
+const DefaultExpansionFactor = 1.5
+
+// Expansion grows the destination PVCs of a plan whose transfer client failed with
+// transfer.ErrDestinationFull and retries the transfer client once they were resized. The
+// failure does not tell which PVC is full, all the destination PVCs are grown. PVCs are only
+// grown when their StorageClass allows volume expansion, the plan fails when none can be grown.
+type Expansion struct {
+	// Factor multiplies the size of the destination PVCs on every expansion, it must be greater
+	// than 1 and defaults to DefaultExpansionFactor
+	Factor float64
+	// MaxSize caps the size of each destination PVC, PVCs are not capped when nil
+	MaxSize *resource.Quantity
+}
+
+// Validate returns an error wrapping ErrExpansionInvalid when the factor or the maximum size
+// of the expansion are out of range
+func (e Expansion) Validate() error {
+	if e.Factor != 0 && e.Factor <= 1 {
+		return fmt.Errorf("%w: factor %v must be greater than 1", ErrExpansionInvalid, e.Factor)
+	}
+	if e.MaxSize != nil && e.MaxSize.Sign() <= 0 {
+		return fmt.Errorf("%w: max size %s must be positive", ErrExpansionInvalid, e.MaxSize.String())
+	}
+	return nil
+}
+
+// grow returns the size of a PVC of the given size once expanded, capped at MaxSize
+func (e Expansion) grow(size resource.Quantity) resource.Quantity {
+	factor := e.Factor
+	if factor == 0 {
+		factor = DefaultExpansionFactor
+	}
+	grown := resource.NewQuantity(int64(math.Ceil(float64(size.Value())*factor)), resource.BinarySI)
+	if e.MaxSize != nil && grown.Cmp(*e.MaxSize) > 0 {
+		return e.MaxSize.DeepCopy()
+	}
+	return *grown
+}
+
+// pvcSize returns the size of a PVC, the larger of its requested storage and its capacity
+func pvcSize(pvc *corev1.PersistentVolumeClaim) resource.Quantity {
+	size := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if capacity, ok := pvc.Status.Capacity[corev1.ResourceStorage]; ok && capacity.Cmp(size) > 0 {
+		return capacity
+	}
+	return size
+}
+
+// isResizing returns whether the capacity of a PVC is not the storage it requests yet
+func isResizing(pvc *corev1.PersistentVolumeClaim) bool {
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	capacity := pvc.Status.Capacity[corev1.ResourceStorage]
+	return capacity.Cmp(request) < 0
+}
+
+// retryOnFullDestination expands the destination PVCs of a transfer client which failed with
+// transfer.ErrDestinationFull, then retries the client once they were resized. It returns
+// whether the transfer is retried, failures are not retried when the plan does not expand
+// PVCs, the client cannot be retried or no destination PVC can grow.
+func (p *Plan) retryOnFullDestination(ctx context.Context, completed *transfer.Completed) (bool, error) {
+	if p.options.Expansion == nil || !errors.Is(completed.Err, transfer.ErrDestinationFull) {
+		return false, nil
+	}
+	retriable, ok := p.client.(transfer.RetriableClient)
+	if !ok || completed.FinishedAt == nil {
+		return false, nil
+	}
+	failedAt := completed.FinishedAt.UTC().Format(time.RFC3339)
+
+	c := p.clusters.Destination
+	pvcs := []*corev1.PersistentVolumeClaim{}
+	expanded := true
+	for _, pvc := range p.destination.PVCList.PVCs() {
+		current := &corev1.PersistentVolumeClaim{}
+		err := c.Get(ctx, client.ObjectKeyFromObject(pvc.Claim()), current)
+		if err != nil {
+			return false, err
+		}
+		pvcs = append(pvcs, current)
+		expanded = expanded && current.Annotations[ExpandedForAnnotation] == failedAt
+	}
+	if !expanded {
+		return p.expandDestination(ctx, c, pvcs, failedAt)
+	}
+
+	for _, pvc := range pvcs {
+		if isResizing(pvc) {
+			p.logger.Info("waiting for destination PVC to be resized before retrying the transfer client",
+				"pvc", client.ObjectKeyFromObject(pvc))
+			return true, nil
+		}
+	}
+	clientCluster, _ := p.clientSide()
+	return true, retriable.Retry(ctx, clientCluster)
+}
+
+// expandDestination grows the destination PVCs which can be grown and marks all of them as
+// expanded for the failure at failedAt, it returns false without updating them when none can
+func (p *Plan) expandDestination(ctx context.Context, c client.Client, pvcs []*corev1.PersistentVolumeClaim, failedAt string) (bool, error) {
+	sizes := map[*corev1.PersistentVolumeClaim]resource.Quantity{}
+	for _, pvc := range pvcs {
+		expandable, err := allowsExpansion(ctx, c, pvc)
+		if err != nil {
+			return false, err
+		}
+		size := p.options.Expansion.grow(pvcSize(pvc))
+		if expandable && size.Cmp(pvc.Spec.Resources.Requests[corev1.ResourceStorage]) > 0 {
+			sizes[pvc] = size
+		}
+	}
+	if len(sizes) == 0 {
+		p.logger.Info("destination full and no destination PVC can be expanded, the transfer is not retried")
+		return false, nil
+	}
+
+	for _, pvc := range pvcs {
+		if pvc.Annotations == nil {
+			pvc.Annotations = map[string]string{}
+		}
+		pvc.Annotations[ExpandedForAnnotation] = failedAt
+		if size, ok := sizes[pvc]; ok {
+			if pvc.Spec.Resources.Requests == nil {
+				pvc.Spec.Resources.Requests = corev1.ResourceList{}
+			}
+			pvc.Spec.Resources.Requests[corev1.ResourceStorage] = size
+			p.logger.Info("expanding destination PVC", "pvc", client.ObjectKeyFromObject(pvc), "size", size.String())
+		}
+		err := c.Update(ctx, pvc)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// allowsExpansion returns whether the StorageClass of a PVC allows volume expansion, PVCs
+// without a StorageClass cannot be expanded
+func allowsExpansion(ctx context.Context, c client.Client, pvc *corev1.PersistentVolumeClaim) (bool, error) {
+	if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+		return false, nil
+	}
+	storageClass := &storagev1.StorageClass{}
+	err := c.Get(ctx, client.ObjectKey{Name: *pvc.Spec.StorageClassName}, storageClass)
+	if err != nil {
+		return false, err
+	}
+	return storageClass.AllowVolumeExpansion != nil && *storageClass.AllowVolumeExpansion, nil
+}
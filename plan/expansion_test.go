@@ -0,0 +1,172 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakeRetriableClient struct {
+	transfer.Client
+	retries int
+}
+
+func (f *fakeRetriableClient) Retry(ctx context.Context, c client.Client) error {
+	f.retries++
+	return nil
+}
+
+func TestExpansion_Validate(t *testing.T) {
+	negative := resource.MustParse("-1Gi")
+	tests := []struct {
+		name      string
+		expansion Expansion
+		wantErr   error
+	}{
+		{name: "default factor, must be valid"},
+		{name: "factor greater than 1, must be valid", expansion: Expansion{Factor: 2}},
+		{name: "factor of 1, must return ErrExpansionInvalid", expansion: Expansion{Factor: 1}, wantErr: ErrExpansionInvalid},
+		{name: "negative max size, must return ErrExpansionInvalid", expansion: Expansion{MaxSize: &negative}, wantErr: ErrExpansionInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.expansion.Validate()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExpansion_grow(t *testing.T) {
+	maxSize := resource.MustParse("3Gi")
+	tests := []struct {
+		name      string
+		expansion Expansion
+		size      string
+		want      string
+	}{
+		{name: "default factor, must grow by half", size: "2Gi", want: "3Gi"},
+		{name: "factor set, must grow by the factor", expansion: Expansion{Factor: 2}, size: "2Gi", want: "4Gi"},
+		{name: "max size reached, must be capped", expansion: Expansion{Factor: 2, MaxSize: &maxSize}, size: "2Gi", want: "3Gi"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.expansion.grow(resource.MustParse(tt.size))
+			if want := resource.MustParse(tt.want); got.Cmp(want) != 0 {
+				t.Errorf("grow() = %s, want %s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func testExpansionPlan(t *testing.T, c client.Client, name, storageClass string, retriable *fakeRetriableClient) *Plan {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "dst"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			StorageClassName: pointer.StringPtr(storageClass),
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+			},
+		},
+		Status: corev1.PersistentVolumeClaimStatus{
+			Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")},
+		},
+	}
+	if err := c.Create(context.Background(), pvc); err != nil {
+		t.Fatalf("unable to create pvc %v", err)
+	}
+	pvcList, _ := transfer.NewPVCList(pvc)
+	return &Plan{
+		logger:      testr.New(t),
+		clusters:    transfer.SingleCluster(c),
+		destination: Side{PVCList: pvcList},
+		options:     Options{Expansion: &Expansion{}},
+		client:      retriable,
+	}
+}
+
+func TestPlan_retryOnFullDestination(t *testing.T) {
+	ctx := context.Background()
+	c := fakeClient()
+	for name, allowed := range map[string]bool{"expandable": true, "fixed": false} {
+		err := c.Create(ctx, &storagev1.StorageClass{
+			ObjectMeta:           metav1.ObjectMeta{Name: name},
+			Provisioner:          "csi.test",
+			AllowVolumeExpansion: pointer.BoolPtr(allowed),
+		})
+		if err != nil {
+			t.Fatalf("unable to create storage class %v", err)
+		}
+	}
+	finishedAt := metav1.Now()
+	full := &transfer.Completed{
+		Failure:    true,
+		FinishedAt: &finishedAt,
+		Err:        fmt.Errorf("%w: rsync exited with 11", transfer.ErrDestinationFull),
+	}
+	pvcKey := client.ObjectKey{Namespace: "dst", Name: "data"}
+
+	retriable := &fakeRetriableClient{}
+	p := testExpansionPlan(t, c, "data", "expandable", retriable)
+
+	// other failures are not retried
+	retried, err := p.retryOnFullDestination(ctx, &transfer.Completed{Failure: true, FinishedAt: &finishedAt})
+	if err != nil || retried {
+		t.Fatalf("retryOnFullDestination() = %v, %v, want other failures not retried", retried, err)
+	}
+
+	// the destination PVC is expanded
+	retried, err = p.retryOnFullDestination(ctx, full)
+	if err != nil || !retried {
+		t.Fatalf("retryOnFullDestination() = %v, %v, want retried", retried, err)
+	}
+	pvc := &corev1.PersistentVolumeClaim{}
+	if err := c.Get(ctx, pvcKey, pvc); err != nil {
+		t.Fatalf("unable to get pvc %v", err)
+	}
+	request := pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if request.Cmp(resource.MustParse("3Gi")) != 0 || pvc.Annotations[ExpandedForAnnotation] == "" {
+		t.Errorf("pvc request = %s, annotations = %v, want expanded to 3Gi", request.String(), pvc.Annotations)
+	}
+
+	// the client is not retried while the PVC is resized, nor expanded again
+	retried, err = p.retryOnFullDestination(ctx, full)
+	if err != nil || !retried || retriable.retries != 0 {
+		t.Fatalf("retryOnFullDestination() = %v, %v, retries = %d, want waiting for the resize", retried, err, retriable.retries)
+	}
+	if err := c.Get(ctx, pvcKey, pvc); err != nil {
+		t.Fatalf("unable to get pvc %v", err)
+	}
+	request = pvc.Spec.Resources.Requests[corev1.ResourceStorage]
+	if request.Cmp(resource.MustParse("3Gi")) != 0 {
+		t.Errorf("pvc request = %s, want not expanded again", request.String())
+	}
+
+	// the client is retried once the PVC was resized
+	pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("3Gi")}
+	if err := c.Status().Update(ctx, pvc); err != nil {
+		t.Fatalf("unable to update pvc %v", err)
+	}
+	retried, err = p.retryOnFullDestination(ctx, full)
+	if err != nil || !retried || retriable.retries != 1 {
+		t.Errorf("retryOnFullDestination() = %v, %v, retries = %d, want the client retried", retried, err, retriable.retries)
+	}
+
+	// PVCs of StorageClasses which do not allow expansion fail the transfer
+	fixed := testExpansionPlan(t, c, "logs", "fixed", &fakeRetriableClient{})
+	retried, err = fixed.retryOnFullDestination(ctx, full)
+	if err != nil || retried {
+		t.Errorf("retryOnFullDestination() = %v, %v, want not retried", retried, err)
+	}
+}
@@ -0,0 +1,73 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/logs"
+	"github.com/backube/pvc-transfer/transfer/rsync"
+	"k8s.io/client-go/rest"
+)
+
+// ErrLogTailInvalid is returned when the log tail of a plan is not valid
+var ErrLogTailInvalid = errors.New("log tail invalid")
+
+// DefaultLogTailLines is the number of lines tailed by log tails which do not set one
+const DefaultLogTailLines = 20
+
+// LogTail tails the logs of the transfer client pods of the PVCs whose transfer failed into
+// the LogTail of their status, see logs.TailLogs
+type LogTail struct {
+	// Config is the REST config of the cluster of the transfer client, the source cluster
+	// unless the plan pulls
+	Config *rest.Config
+	// Lines is the number of lines tailed, it defaults to DefaultLogTailLines
+	Lines int64
+}
+
+// Validate returns an error wrapping ErrLogTailInvalid when the config is not set or the
+// number of lines is negative
+func (l LogTail) Validate() error {
+	if l.Config == nil {
+		return fmt.Errorf("%w: config not set", ErrLogTailInvalid)
+	}
+	if l.Lines < 0 {
+		return fmt.Errorf("%w: %d lines must not be negative", ErrLogTailInvalid, l.Lines)
+	}
+	return nil
+}
+
+// tailFailedPVCLogs sets the tail of the logs of the transfer client pods of the PVCs whose
+// transfer failed, the logs of pods which cannot be read, e.g. deleted pods, are skipped
+func (p *Plan) tailFailedPVCLogs(ctx context.Context, status *Status) error {
+	if p.options.LogTail == nil || p.client == nil || status.Transfer == nil {
+		return nil
+	}
+	failed := status.Transfer.Summary().FailedPVCs
+	if len(failed) == 0 {
+		return nil
+	}
+	clientCluster, _ := p.clientSide()
+	pods, err := p.client.Pods(ctx, clientCluster)
+	if err != nil {
+		return err
+	}
+	lines := p.options.LogTail.Lines
+	if lines == 0 {
+		lines = DefaultLogTailLines
+	}
+	for _, pvc := range failed {
+		pod, ok := pods[pvc]
+		if !ok {
+			continue
+		}
+		tail, err := logs.TailLogs(ctx, p.options.LogTail.Config, pod, rsync.RsyncContainer, lines)
+		if err != nil {
+			p.logger.Error(err, "unable to tail the logs of the transfer client pod", "pod", pod, "pvc", pvc)
+			continue
+		}
+		status.Transfer.PVCs[pvc].LogTail = tail
+	}
+	return nil
+}
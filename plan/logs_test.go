@@ -0,0 +1,90 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr/testr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakePodsClient struct {
+	transfer.Client
+	pods map[types.NamespacedName]client.ObjectKey
+}
+
+func (f *fakePodsClient) Pods(ctx context.Context, c client.Client) (map[types.NamespacedName]client.ObjectKey, error) {
+	return f.pods, nil
+}
+
+func TestLogTail_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		logTail LogTail
+		wantErr error
+	}{
+		{name: "config set, must be valid", logTail: LogTail{Config: &rest.Config{}}},
+		{name: "config not set, must return ErrLogTailInvalid", logTail: LogTail{}, wantErr: ErrLogTailInvalid},
+		{name: "negative lines, must return ErrLogTailInvalid", logTail: LogTail{Config: &rest.Config{}, Lines: -1}, wantErr: ErrLogTailInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.logTail.Validate()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPlan_tailFailedPVCLogs(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/namespaces/src/pods/rsync-client-foo/log" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "rsync error: some files could not be transferred, tailLines=%s", r.URL.Query().Get("tailLines"))
+	}))
+	defer server.Close()
+
+	data := types.NamespacedName{Namespace: "src", Name: "data"}
+	logs := types.NamespacedName{Namespace: "src", Name: "logs"}
+	deleted := types.NamespacedName{Namespace: "src", Name: "deleted"}
+	p := &Plan{
+		logger:   testr.New(t),
+		clusters: transfer.SingleCluster(fakeClient()),
+		options:  Options{LogTail: &LogTail{Config: &rest.Config{Host: server.URL}}},
+		client: &fakePodsClient{pods: map[types.NamespacedName]client.ObjectKey{
+			data:    {Namespace: "src", Name: "rsync-client-foo"},
+			logs:    {Namespace: "src", Name: "rsync-client-foo"},
+			deleted: {Namespace: "src", Name: "rsync-client-gone"},
+		}},
+	}
+	status := &Status{Phase: PhaseFailed, Transfer: &transfer.Status{PVCs: map[types.NamespacedName]*transfer.PVCStatus{
+		data:    {Completed: &transfer.Completed{Failure: true}},
+		logs:    {Completed: &transfer.Completed{Successful: true}},
+		deleted: {Completed: &transfer.Completed{Failure: true}},
+	}}}
+
+	err := p.tailFailedPVCLogs(context.Background(), status)
+	if err != nil {
+		t.Fatalf("tailFailedPVCLogs() error = %v", err)
+	}
+	want := "rsync error: some files could not be transferred, tailLines=20"
+	if got := status.Transfer.PVCs[data].LogTail; got != want {
+		t.Errorf("failed PVC log tail = %q, want %q", got, want)
+	}
+	if got := status.Transfer.PVCs[logs].LogTail; got != "" {
+		t.Errorf("succeeded PVC log tail = %q, want no logs", got)
+	}
+	if got := status.Transfer.PVCs[deleted].LogTail; got != "" {
+		t.Errorf("deleted pod log tail = %q, want no logs", got)
+	}
+}
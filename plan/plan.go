@@ -0,0 +1,893 @@
+package plan
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	efactory "github.com/backube/pvc-transfer/endpoint/factory"
+	"github.com/backube/pvc-transfer/hook"
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/utils"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/credentials"
+	tfactory "github.com/backube/pvc-transfer/transport/factory"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr"
+	routev1 "github.com/openshift/api/route/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TransferType is the type of the transfer run by a plan
+type TransferType string
+
+const (
+	// TransferTypeRsync runs the transfer with rsync
+	TransferTypeRsync TransferType = "rsync"
+)
+
+var (
+	// ErrTransferTypeNotSupported is returned when the transfer type of a plan is not supported
+	ErrTransferTypeNotSupported = errors.New("transfer type not supported")
+)
+
+// Phase is the phase of a plan
+type Phase string
+
+const (
+	// PhasePending is reported until the endpoint is healthy and the transfer client started
+	PhasePending Phase = "Pending"
+	// PhaseRunning is reported while the transfer client is running
+	PhaseRunning Phase = "Running"
+	// PhaseSucceeded is reported once the transfer client completed successfully
+	PhaseSucceeded Phase = "Succeeded"
+	// PhaseFailed is reported once the transfer client failed
+	PhaseFailed Phase = "Failed"
+	// PhasePaused is reported while the transfer client is paused
+	PhasePaused Phase = "Paused"
+	// PhaseCancelled is reported once the plan was cancelled
+	PhaseCancelled Phase = "Cancelled"
+)
+
+// Status is the aggregate status of a plan
+type Status struct {
+	Phase Phase
+	// Transfer is the status reported by the transfer client, it is nil while the
+	// transfer client status is not known
+	Transfer *transfer.Status
+}
+
+// Side is the PVCs of one side of a transfer and the options of the resources created there
+type Side struct {
+	// PVCList are the PVCs to transfer, they must be in a single namespace
+	PVCList transfer.PVCList
+	// Labels are applied to all the resources created on this side
+	Labels map[string]string
+	// OwnerReferences are applied to all the resources created on this side
+	OwnerReferences []metav1.OwnerReference
+	// PodOptions configure the transfer pods of this side
+	PodOptions transfer.PodOptions
+}
+
+// Options select the endpoint, transport and transfer of a plan
+type Options struct {
+	// EndpointType is the type of endpoint exposing the destination, the best endpoint
+	// supported by the destination cluster is picked when it is not set
+	EndpointType efactory.Type
+	// EndpointOptions configure the endpoint, labels and owner references are
+	// taken from the destination side
+	EndpointOptions efactory.Options
+	// TransportType is the type of transport, defaults to stunnel
+	TransportType transport.Type
+	// TransportOptions configure the transport server and client, labels and owner
+	// references are taken from each side
+	TransportOptions transport.Options
+	// TransferType is the type of transfer, defaults to rsync
+	TransferType TransferType
+	// PreSyncHooks run in order in the source cluster before the transfer client is created,
+	// e.g. to freeze a filesystem or flush a database for a consistent sync
+	PreSyncHooks []hook.Hook
+	// PostSyncHooks run in order in the source cluster once the transfer client succeeded
+	PostSyncHooks []hook.Hook
+	// TransferID identifies the transfer in the logs of the plan and in the
+	// transfer.TransferIDLabel of all the resources created on both sides. It defaults to an
+	// ID derived from the source and destination PVCs, which is stable across calls to New.
+	TransferID string
+	// History records the iterations of the transfer client once completed, e.g. a
+	// transfer.ConfigMapHistory, it is not recorded when not set
+	History transfer.History
+	// Pull runs the endpoint, the transport server and the transfer server on the source and
+	// the transfer client on the destination, which pulls the source PVCs, see rsync.Options.Pull.
+	// It is used when only the destination cluster can open connections to the other cluster.
+	// Endpoint options then apply to the source side.
+	Pull bool
+	// IntegrityManifest verifies the destination PVCs against a sha256 manifest of the source
+	// PVCs once the transfer client succeeded, see rsync.Options.IntegrityManifest. The plan is
+	// running until the verification completed and fails when it failed, the result is reported
+	// in the Integrity of the transfer status. It cannot be used with Pull.
+	IntegrityManifest bool
+	// AutoTune adjusts the bandwidth limit and the compression level of the transfer client
+	// between iterations from the throughput of the iterations recorded in the History, see
+	// rsync.AutoTune. It requires History, the command options of the client side must be
+	// rsync command options when set.
+	AutoTune *rsync.AutoTune
+	// Streams is the number of rsync processes transferring each PVC in parallel through the
+	// transport, see rsync.Options.Streams. It cannot be used with Pull.
+	Streams int
+	// ImageProfile describes the rsync of the transfer images of both sides, see
+	// rsync.Options.ImageProfile
+	ImageProfile *rsync.ImageProfile
+	// Expansion grows the destination PVCs when the transfer client fails on a full destination
+	// and retries it, see Expansion. The plan is running until the client was retried.
+	Expansion *Expansion
+	// LogTail tails the logs of the transfer client pods of failed PVCs into the PVC statuses
+	// of the transfer status, see LogTail. Logs are not tailed when nil.
+	LogTail *LogTail
+}
+
+// Plan is a transfer of PVCs from a source to a destination, it reconciles the endpoint,
+// the transport server and the transfer server on the destination, then the transport
+// client and the transfer client on the source. Sides are swapped for plans which pull.
+type Plan struct {
+	logger          logr.Logger
+	clusters        transfer.ClusterPair
+	source          Side
+	destination     Side
+	options         Options
+	endpoint        endpoint.Endpoint
+	transportServer transport.Transport
+	transportClient transport.Transport
+	server          transfer.Server
+	client          transfer.Client
+	credentialsRef  *types.NamespacedName
+	transferID      string
+	cancelled       bool
+	// postSyncCompleted is set once all the post-sync hooks completed
+	postSyncCompleted bool
+}
+
+// New reconciles all the resources of the plan in order, the destination resources with the
+// destination client of the cluster pair and the source resources with its source client.
+// The transfer client is only created
+// once the endpoint is healthy, until then the plan reports PhasePending and callers are
+// expected to call New again, e.g. on the next reconcile. New is idempotent.
+//
+// When the source pod options pre-scan the source PVCs, New returns an error wrapping
+// transfer.ErrDestinationFull while the estimate exceeds the capacity of the destination PVCs,
+// callers are expected to expand them and pass the updated PVCs to New.
+//
+// Plans with an Expansion update the destination PVCs and read their StorageClasses, add the
+// following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;update
+// +kubebuilder:rbac:groups=storage.k8s.io,resources=storageclasses,verbs=get
+//
+// Plans with a LogTail read the logs of the transfer client pods, add the following line to
+// the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
+//
+// Before passing the clients make sure to call AddToScheme() of the endpoint and transport
+// packages in use. In order to generate the right RBAC, add the RBAC annotations of the
+// endpoint, transport and transfer packages in use to the Reconcile function annotations.
+func New(ctx context.Context, logger logr.Logger,
+	clusters transfer.ClusterPair,
+	source, destination Side,
+	options Options) (*Plan, error) {
+	err := clusters.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if options.TransportType == "" {
+		options.TransportType = stunnel.TransportTypeStunnel
+	}
+	if options.TransferType == "" {
+		options.TransferType = TransferTypeRsync
+	}
+	if options.TransferType != TransferTypeRsync {
+		return nil, fmt.Errorf("%w: %s", ErrTransferTypeNotSupported, options.TransferType)
+	}
+	err = tfactory.Validate(options.TransportType, &options.TransportOptions)
+	if err != nil {
+		return nil, err
+	}
+	if options.AutoTune != nil {
+		if options.History == nil {
+			return nil, fmt.Errorf("%w: auto-tuning requires a history", rsync.ErrAutoTuneInvalid)
+		}
+		err = options.AutoTune.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.Expansion != nil {
+		err = options.Expansion.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if options.LogTail != nil {
+		err = options.LogTail.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	destinationName, err := getNamespacedName(destination.PVCList)
+	if err != nil {
+		return nil, err
+	}
+	sourceName, err := getNamespacedName(source.PVCList)
+	if err != nil {
+		return nil, err
+	}
+
+	transferID := options.TransferID
+	if transferID == "" {
+		transferID = getTransferID(sourceName, destinationName)
+	}
+	err = transfer.ValidateTransferID(transferID)
+	if err != nil {
+		return nil, err
+	}
+	source.Labels = withTransferID(source.Labels, transferID)
+	destination.Labels = withTransferID(destination.Labels, transferID)
+
+	p := &Plan{
+		logger:      logger.WithValues("plan", destinationName, "transferID", transferID),
+		clusters:    clusters,
+		source:      source,
+		destination: destination,
+		options:     options,
+		transferID:  transferID,
+	}
+
+	serverName, clientName := destinationName, sourceName
+	if options.Pull {
+		serverName, clientName = sourceName, destinationName
+	}
+	err = p.reconcileServer(ctx, serverName)
+	if err != nil {
+		return nil, err
+	}
+
+	serverCluster, _ := p.serverSide()
+	health, err := endpoint.GetHealth(ctx, serverCluster, p.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if !health.IsExpected() {
+		return nil, fmt.Errorf("endpoint %s cannot become healthy: %s", p.endpoint.NamespacedName(), health.Message)
+	}
+	if !health.Healthy || p.endpoint.Hostname() == "" {
+		p.logger.Info("waiting for endpoint to become healthy before creating the transfer client",
+			"reason", health.Reason, "message", health.Message)
+		return p, nil
+	}
+
+	// the transfer begins, clients must keep connecting to the same host across reconciles
+	err = p.pinHostname(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	completed, err := hook.RunAll(ctx, clusters.Source, p.logger, options.PreSyncHooks)
+	if err != nil {
+		return nil, err
+	}
+	if !completed {
+		p.logger.Info("waiting for pre-sync hooks to complete before creating the transfer client")
+		return p, nil
+	}
+
+	err = p.reconcileClient(ctx, clientName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = p.runPostSyncHooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// runPostSyncHooks runs the post-sync hooks once the transfer client succeeded
+func (p *Plan) runPostSyncHooks(ctx context.Context) error {
+	status, err := p.transferStatus(ctx)
+	if err != nil || status.Phase != PhaseSucceeded {
+		return err
+	}
+	p.postSyncCompleted, err = hook.RunAll(ctx, p.clusters.Source, p.logger, p.options.PostSyncHooks)
+	return err
+}
+
+func getNamespacedName(pvcList transfer.PVCList) (types.NamespacedName, error) {
+	if pvcList == nil || len(pvcList.Namespaces()) == 0 {
+		return types.NamespacedName{}, transfer.ErrPVCListEmpty
+	}
+	namespaces := pvcList.Namespaces()
+	if len(namespaces) != 1 {
+		return types.NamespacedName{}, transfer.ErrPVCsMultipleNamespaces
+	}
+	return types.NamespacedName{
+		Namespace: namespaces[0],
+		Name:      transfer.NamespaceHashForNames(pvcList)[namespaces[0]],
+	}, nil
+}
+
+// getTransferID returns the transfer ID derived from the names of the source and destination
+func getTransferID(source, destination types.NamespacedName) string {
+	hash := md5.Sum([]byte(source.String() + ":" + destination.String()))
+	return hex.EncodeToString(hash[:])
+}
+
+// withTransferID returns a copy of labels with the transfer ID label
+func withTransferID(labels map[string]string, transferID string) map[string]string {
+	merged := map[string]string{}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	merged[transfer.TransferIDLabel] = transferID
+	return merged
+}
+
+// serverSide returns the cluster and the side of the transfer server, the destination
+// unless the plan pulls
+func (p *Plan) serverSide() (client.Client, Side) {
+	if p.options.Pull {
+		return p.clusters.Source, p.source
+	}
+	return p.clusters.Destination, p.destination
+}
+
+// clientSide returns the cluster and the side of the transfer client, the source
+// unless the plan pulls
+func (p *Plan) clientSide() (client.Client, Side) {
+	if p.options.Pull {
+		return p.clusters.Destination, p.destination
+	}
+	return p.clusters.Source, p.source
+}
+
+// rsyncOptions returns the options shared by the rsync server and client of the plan
+func (p *Plan) rsyncOptions() rsync.Options {
+	options := rsync.Options{
+		Pull:              p.options.Pull,
+		IntegrityManifest: p.options.IntegrityManifest,
+		Streams:           p.options.Streams,
+		ImageProfile:      p.options.ImageProfile,
+	}
+	// the pre-scan of clients which pull measures the PVCs they write to
+	if capacity := transfer.Capacity(p.destination.PVCList); !p.options.Pull && !capacity.IsZero() {
+		options.DestinationCapacity = &capacity
+	}
+	return options
+}
+
+func (p *Plan) reconcileServer(ctx context.Context, namespacedName types.NamespacedName) error {
+	c, side := p.serverSide()
+	endpointOptions := p.options.EndpointOptions
+	endpointOptions.Labels = side.Labels
+	endpointOptions.OwnerReferences = side.OwnerReferences
+
+	var err error
+	if endpointOptions.Hostname == "" {
+		// recreated endpoints keep the hostname of a transfer which began
+		endpointOptions.Hostname, err = endpoint.PinnedHostname(ctx, c, namespacedName)
+		if err != nil {
+			return err
+		}
+	}
+	if p.options.EndpointType == "" {
+		p.endpoint, _, err = efactory.NewBest(ctx, c, p.logger, namespacedName, endpointOptions)
+	} else {
+		p.endpoint, err = efactory.New(ctx, c, p.logger, p.options.EndpointType, namespacedName, endpointOptions)
+	}
+	if err != nil {
+		return err
+	}
+
+	serverOptions := p.transportOptions(side)
+	defaultServiceCAService(serverOptions, p.endpoint.NamespacedName().Name)
+	p.transportServer, err = tfactory.NewServer(ctx, c, p.logger, p.options.TransportType, namespacedName, p.endpoint, serverOptions)
+	if err != nil {
+		return err
+	}
+
+	p.server, err = rsync.NewServerFromOptions(ctx, c, p.logger, rsync.ServerOptions{
+		PVCList:         side.PVCList,
+		Transport:       p.transportServer,
+		Endpoint:        p.endpoint,
+		Labels:          side.Labels,
+		OwnerReferences: side.OwnerReferences,
+		PodOptions:      side.PodOptions,
+		Options:         p.rsyncOptions(),
+	})
+	return err
+}
+
+// pinHostname pins the hostname of the endpoint, an error wrapping endpoint.ErrHostnameChanged
+// is returned when the endpoint no longer reports the hostname pinned by a previous reconcile
+func (p *Plan) pinHostname(ctx context.Context) error {
+	c, side := p.serverSide()
+	return endpoint.PinHostname(ctx, c, p.logger, p.endpoint, endpoint.PinOptions{
+		Labels:          side.Labels,
+		OwnerReferences: side.OwnerReferences,
+		Reconcile: reconcile.Options{
+			ServerSideApply: side.PodOptions.ServerSideApply,
+			FieldManager:    side.PodOptions.FieldManager,
+			Adopt:           side.PodOptions.Adopt,
+		},
+	})
+}
+
+func (p *Plan) reconcileClient(ctx context.Context, namespacedName types.NamespacedName) error {
+	c, side := p.clientSide()
+	clientOptions := p.transportOptions(side)
+
+	// the transport client must use the credentials of the transport server, transports
+	// such as the null transport have none. Credentials read from a secret store are read
+	// by the client from the store of its own namespace.
+	var err error
+	usesProvider := clientOptions.Credentials != nil && clientOptions.Credentials.Provider != nil
+	if p.transportServer.Credentials().Name != "" && !usesProvider {
+		err = p.reconcileCredentials(ctx, namespacedName.Namespace, clientOptions)
+		if err != nil {
+			return err
+		}
+		clientOptions.Credentials = &transport.Credentials{
+			SecretRef: *p.credentialsRef,
+		}
+		if p.options.TransportOptions.Credentials != nil {
+			clientOptions.Credentials.Type = p.options.TransportOptions.Credentials.Type
+		}
+	}
+
+	p.transportClient, err = tfactory.NewClient(ctx, c, p.logger, p.options.TransportType, namespacedName,
+		p.endpoint.Hostname(), p.endpoint.IngressPort(), clientOptions)
+	if err != nil {
+		return err
+	}
+
+	podOptions := side.PodOptions
+	podOptions.CommandOptions, err = p.tunedCommandOptions(ctx, podOptions.CommandOptions)
+	if err != nil {
+		return err
+	}
+
+	p.client, err = rsync.NewClientFromOptions(ctx, c, p.logger, rsync.ClientOptions{
+		PVCList:         side.PVCList,
+		Transport:       p.transportClient,
+		Labels:          side.Labels,
+		OwnerReferences: side.OwnerReferences,
+		PodOptions:      podOptions,
+		Options:         p.rsyncOptions(),
+	})
+	return err
+}
+
+// tunedCommandOptions returns the command options of the transfer client with the tuning of
+// the next iteration applied when the plan auto-tunes the transfer. Clients are only tuned when
+// created, existing clients keep the tuning they were created with.
+func (p *Plan) tunedCommandOptions(ctx context.Context, options transfer.CommandOptions) (transfer.CommandOptions, error) {
+	if p.options.AutoTune == nil {
+		return options, nil
+	}
+	records, err := p.options.History.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tuning := p.options.AutoTune.Tune(records)
+	switch rsyncOptions := options.(type) {
+	case nil:
+		return rsync.NewDefaultOptionsFrom(tuning), nil
+	case *rsync.CommandOptions:
+		tuned := *rsyncOptions
+		return &tuned, tuned.Apply(tuning)
+	default:
+		return nil, fmt.Errorf("%w: command options %T are not rsync command options", rsync.ErrAutoTuneInvalid, options)
+	}
+}
+
+// recordIteration adds the iteration of a completed transfer client to the history, with the
+// tuning the client was created with when the plan auto-tunes the transfer
+func (p *Plan) recordIteration(ctx context.Context, record transfer.IterationRecord) error {
+	if p.options.AutoTune != nil {
+		records, err := p.options.History.List(ctx)
+		if err != nil {
+			return err
+		}
+		// the tuning of the client is derived from the iterations recorded before it, adding
+		// an iteration already recorded is a no-op
+		tuning := p.options.AutoTune.Tune(records)
+		record.BandwidthLimit = tuning.BwLimit
+		record.CompressionLevel = tuning.CompressionLevel
+	}
+	return p.options.History.Add(ctx, record)
+}
+
+// transportOptions returns the transport options of a side
+func (p *Plan) transportOptions(side Side) *transport.Options {
+	options := p.options.TransportOptions
+	options.Labels = side.Labels
+	options.Owners = side.OwnerReferences
+	options.ServerSideApply = side.PodOptions.ServerSideApply
+	options.FieldManager = side.PodOptions.FieldManager
+	options.Adopt = side.PodOptions.Adopt
+	options.RequireImageDigests = options.RequireImageDigests || side.PodOptions.RequireImageDigests
+	return &options
+}
+
+// defaultServiceCAService defaults the Service of the service CA provider to the Service of
+// the endpoint, the service CA issues the certificate of the server for it
+func defaultServiceCAService(options *transport.Options, service string) {
+	if options.Credentials == nil {
+		return
+	}
+	provider, ok := options.Credentials.Provider.(credentials.ServiceCA)
+	if !ok || provider.Service != "" {
+		return
+	}
+	serverCredentials := *options.Credentials
+	serverCredentials.Provider = credentials.ServiceCA{Service: service}
+	options.Credentials = &serverCredentials
+}
+
+// reconcileCredentials copies the credentials of the transport server to the namespace of the client,
+// only the client credentials are copied when the transport server stores them separately
+func (p *Plan) reconcileCredentials(ctx context.Context, namespace string, options *transport.Options) error {
+	serverCluster, _ := p.serverSide()
+	clientCluster, side := p.clientSide()
+	credentialsRef := p.transportServer.Credentials()
+	if provider, ok := p.transportServer.(transport.ClientCredentialsProvider); ok {
+		credentialsRef = provider.ClientCredentials()
+	}
+	serverSecret := &corev1.Secret{}
+	err := serverCluster.Get(ctx, credentialsRef, serverSecret)
+	if err != nil {
+		p.logger.Error(err, "unable to get transport server credentials")
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      serverSecret.Name,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, clientCluster, p.logger, secret, reconcile.Options{
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	}, func() error {
+		secret.Labels = side.Labels
+		secret.OwnerReferences = side.OwnerReferences
+		secret.Data = serverSecret.Data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	p.credentialsRef = &types.NamespacedName{Namespace: secret.Namespace, Name: secret.Name}
+	return nil
+}
+
+// TransferID returns the ID of the transfer stamped on all the resources of the plan
+func (p *Plan) TransferID() string {
+	return p.transferID
+}
+
+// Endpoint returns the endpoint of the plan
+func (p *Plan) Endpoint() endpoint.Endpoint {
+	return p.endpoint
+}
+
+// Server returns the transfer server of the plan
+func (p *Plan) Server() transfer.Server {
+	return p.server
+}
+
+// Client returns the transfer client of the plan, it is nil while the plan is pending
+func (p *Plan) Client() transfer.Client {
+	return p.client
+}
+
+// Status returns the aggregate status of the plan, a plan with post-sync hooks is running
+// until they completed
+func (p *Plan) Status(ctx context.Context) (*Status, error) {
+	status, err := p.transferStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status.Phase == PhaseSucceeded && !p.postSyncCompleted {
+		err = p.runPostSyncHooks(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !p.postSyncCompleted {
+			status.Phase = PhaseRunning
+		}
+	}
+	if status.Phase == PhaseSucceeded && p.options.IntegrityManifest {
+		err = p.verifyIntegrity(ctx, status)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = p.tailFailedPVCLogs(ctx, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// verifyIntegrity verifies the data received by the transfer server once the transfer client
+// succeeded, the status is running until the verification completed and failed when it failed
+func (p *Plan) verifyIntegrity(ctx context.Context, status *Status) error {
+	verifier, ok := p.server.(transfer.IntegrityVerifier)
+	if !ok {
+		return nil
+	}
+	serverCluster, _ := p.serverSide()
+	integrity, err := verifier.VerifyIntegrity(ctx, serverCluster)
+	if err != nil {
+		return err
+	}
+	status.Transfer.Integrity = integrity
+	switch {
+	case integrity == nil:
+		status.Phase = PhaseRunning
+	case !integrity.Verified:
+		p.logger.Info("integrity verification failed", "message", integrity.Message, "configMap", integrity.ConfigMap)
+		status.Phase = PhaseFailed
+	}
+	return nil
+}
+
+// transferStatus returns the status of the plan derived from the transfer client only
+func (p *Plan) transferStatus(ctx context.Context) (*Status, error) {
+	if p.client == nil && p.cancelled {
+		return &Status{Phase: PhaseCancelled}, nil
+	}
+	if p.client == nil {
+		return &Status{Phase: PhasePending}, nil
+	}
+	clientCluster, _ := p.clientSide()
+	status, err := p.client.Status(ctx, clientCluster)
+	switch {
+	case errors.Is(err, transfer.ErrStatusUnknown):
+		return &Status{Phase: PhaseRunning}, nil
+	case err != nil:
+		return nil, err
+	}
+
+	if record, ok := transfer.NewIterationRecord(status); ok && p.options.History != nil {
+		err = p.recordIteration(ctx, record)
+		if err != nil {
+			p.logger.Error(err, "unable to record transfer iteration")
+			return nil, err
+		}
+	}
+
+	switch {
+	case status.Cancelled != nil:
+		return &Status{Phase: PhaseCancelled, Transfer: status}, nil
+	case status.Paused != nil:
+		return &Status{Phase: PhasePaused, Transfer: status}, nil
+	case status.Completed != nil && status.Completed.Successful:
+		return &Status{Phase: PhaseSucceeded, Transfer: status}, nil
+	case status.Completed != nil:
+		retried, err := p.retryOnFullDestination(ctx, status.Completed)
+		if err != nil {
+			return nil, err
+		}
+		if retried {
+			return &Status{Phase: PhaseRunning, Transfer: status}, nil
+		}
+		return &Status{Phase: PhaseFailed, Transfer: status}, nil
+	default:
+		return &Status{Phase: PhaseRunning, Transfer: status}, nil
+	}
+}
+
+// History returns the iterations recorded in the history of the plan options, it is empty
+// when the plan has no history
+func (p *Plan) History(ctx context.Context) ([]transfer.IterationRecord, error) {
+	if p.options.History == nil {
+		return []transfer.IterationRecord{}, nil
+	}
+	return p.options.History.List(ctx)
+}
+
+// Pause pauses the transfer client of the plan, see transfer.PausableClient. An error wrapping
+// transfer.ErrPauseNotSupported is returned when the transfer client is not created yet or
+// cannot be paused.
+func (p *Plan) Pause(ctx context.Context) error {
+	pausable, err := p.pausableClient()
+	if err != nil {
+		return err
+	}
+	clientCluster, _ := p.clientSide()
+	return pausable.Pause(ctx, clientCluster)
+}
+
+// Resume resumes the paused transfer client of the plan
+func (p *Plan) Resume(ctx context.Context) error {
+	pausable, err := p.pausableClient()
+	if err != nil {
+		return err
+	}
+	clientCluster, _ := p.clientSide()
+	return pausable.Resume(ctx, clientCluster)
+}
+
+func (p *Plan) pausableClient() (transfer.PausableClient, error) {
+	if p.client == nil {
+		return nil, fmt.Errorf("%w: transfer client not created yet", transfer.ErrPauseNotSupported)
+	}
+	pausable, ok := p.client.(transfer.PausableClient)
+	if !ok {
+		return nil, fmt.Errorf("%w: transfer client cannot be paused", transfer.ErrPauseNotSupported)
+	}
+	return pausable, nil
+}
+
+// Cancel stops the transfer client and server of the plan gracefully and marks their resources
+// for cleanup with transfer.CancelledLabel, see transfer.Cancellable. It returns false while
+// the transfer pods are terminating, callers are expected to requeue and call it again.
+// Cancelled plans report PhaseCancelled, callers are expected to call Cleanup instead of New
+// once Cancel returned true.
+func (p *Plan) Cancel(ctx context.Context) (bool, error) {
+	serverCluster, _ := p.serverSide()
+	clientCluster, _ := p.clientSide()
+	cancelled := true
+	if cancellable, ok := p.client.(transfer.Cancellable); ok {
+		stopped, err := cancellable.Cancel(ctx, clientCluster)
+		if err != nil {
+			return false, err
+		}
+		cancelled = cancelled && stopped
+	}
+	if cancellable, ok := p.server.(transfer.Cancellable); ok {
+		stopped, err := cancellable.Cancel(ctx, serverCluster)
+		if err != nil {
+			return false, err
+		}
+		cancelled = cancelled && stopped
+	}
+	p.cancelled = true
+	if !cancelled {
+		p.logger.Info("plan cancelling, waiting for the transfer pods to terminate")
+		return false, nil
+	}
+	p.logger.Info("plan cancelled")
+	return true, nil
+}
+
+// IsHealthy returns whether the endpoint, the transports and the transfer server of the plan
+// are healthy. Errors returned by the components are returned as is, allowing callers to
+// tell a misconfigured transport from an endpoint which is not ready yet.
+func (p *Plan) IsHealthy(ctx context.Context) (bool, error) {
+	type healthCheck struct {
+		c         client.Client
+		isHealthy func(context.Context, client.Client) (bool, error)
+	}
+	serverCluster, _ := p.serverSide()
+	clientCluster, _ := p.clientSide()
+	checks := []healthCheck{
+		{serverCluster, p.endpoint.IsHealthy},
+		{serverCluster, p.transportServer.IsHealthy},
+		{serverCluster, p.server.IsHealthy},
+	}
+	if p.transportClient != nil {
+		checks = append(checks, healthCheck{clientCluster, p.transportClient.IsHealthy})
+	}
+	for _, check := range checks {
+		healthy, err := check.isHealthy(ctx, check.c)
+		if err != nil || !healthy {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Completed returns whether the transfer client of the plan completed, successfully or not,
+// or the plan was cancelled
+func (p *Plan) Completed(ctx context.Context) (bool, error) {
+	status, err := p.Status(ctx)
+	if err != nil {
+		return false, err
+	}
+	return status.Phase == PhaseSucceeded || status.Phase == PhaseFailed || status.Phase == PhaseCancelled, nil
+}
+
+// cleanupKinds are the kinds of resources created by plans
+var cleanupKinds = []client.Object{
+	&corev1.Pod{},
+	&corev1.ConfigMap{},
+	&corev1.Secret{},
+	&corev1.Service{},
+	&corev1.ServiceAccount{},
+	&rbacv1.Role{},
+	&rbacv1.RoleBinding{},
+	&networkingv1.Ingress{},
+	&routev1.Route{},
+	&batchv1.Job{},
+}
+
+// Cleanup marks all the resources of the plan with the key/value label and deletes them on both
+// sides. Kinds which are not served by a cluster, such as routes outside of OpenShift, are skipped.
+func (p *Plan) Cleanup(ctx context.Context, key, value string) error {
+	serverCluster, _ := p.serverSide()
+	clientCluster, clientSide := p.clientSide()
+	err := p.server.MarkForCleanup(ctx, serverCluster, key, value)
+	if err != nil {
+		return err
+	}
+	pinnedHostname := endpoint.PinnedHostnameName(p.endpoint.NamespacedName())
+	err = utils.UpdateWithLabel(ctx, serverCluster, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: pinnedHostname.Namespace, Name: pinnedHostname.Name},
+	}, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	if p.client != nil {
+		err = p.client.MarkForCleanup(ctx, clientCluster, key, value)
+		if err != nil {
+			return err
+		}
+	}
+	for _, h := range append(p.options.PreSyncHooks, p.options.PostSyncHooks...) {
+		err = h.MarkForCleanup(ctx, p.clusters.Source, key, value)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	if p.credentialsRef != nil {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Namespace: p.credentialsRef.Namespace, Name: p.credentialsRef.Name},
+		}
+		err = utils.UpdateWithLabel(ctx, clientCluster, secret, key, value)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err = deleteMarked(ctx, serverCluster, p.endpoint.NamespacedName().Namespace, key, value)
+	if err != nil {
+		return err
+	}
+	// hooks run on the source, which is the server side of plans which pull
+	if p.client == nil && (len(p.options.PreSyncHooks) == 0 || p.options.Pull) {
+		return nil
+	}
+	return deleteMarked(ctx, clientCluster, clientSide.PVCList.Namespaces()[0], key, value)
+}
+
+func deleteMarked(ctx context.Context, c client.Client, namespace, key, value string) error {
+	for _, kind := range cleanupKinds {
+		err := c.DeleteAllOf(ctx, kind.DeepCopyObject().(client.Object),
+			client.InNamespace(namespace), client.MatchingLabels{key: value},
+			client.PropagationPolicy(metav1.DeletePropagationBackground))
+		switch {
+		case meta.IsNoMatchError(err), runtime.IsNotRegisteredError(err):
+			continue
+		case err != nil:
+			return err
+		}
+	}
+	return nil
+}
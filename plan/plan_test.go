@@ -0,0 +1,673 @@
+package plan
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	efactory "github.com/backube/pvc-transfer/endpoint/factory"
+	"github.com/backube/pvc-transfer/hook"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync"
+	tfactory "github.com/backube/pvc-transfer/transport/factory"
+	"github.com/go-logr/logr"
+	"github.com/go-logr/logr/testr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func fakeClient() client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	return fake.NewClientBuilder().WithScheme(scheme).Build()
+}
+
+func testSide(t *testing.T, namespace string) Side {
+	pvcList, err := transfer.NewPVCList(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: namespace},
+	})
+	if err != nil {
+		t.Fatalf("unable to create pvc list %v", err)
+	}
+	return Side{
+		PVCList: pvcList,
+		Labels:  map[string]string{"app": "plan-test"},
+	}
+}
+
+func TestNew_options(t *testing.T) {
+	tests := []struct {
+		name     string
+		clusters transfer.ClusterPair
+		options  Options
+		wantErr  error
+	}{
+		{
+			name:     "source client not set, must return ErrClusterPairInvalid",
+			clusters: transfer.ClusterPair{Destination: fakeClient()},
+			wantErr:  transfer.ErrClusterPairInvalid,
+		},
+		{
+			name:     "unsupported transfer type, must return ErrTransferTypeNotSupported",
+			clusters: transfer.SingleCluster(fakeClient()),
+			options:  Options{TransferType: "restic"},
+			wantErr:  ErrTransferTypeNotSupported,
+		},
+		{
+			name:     "unknown transport type, must return ErrTypeNotRegistered",
+			clusters: transfer.SingleCluster(fakeClient()),
+			options:  Options{TransportType: "wireguard"},
+			wantErr:  tfactory.ErrTypeNotRegistered,
+		},
+		{
+			name:     "unknown endpoint type, must return ErrTypeNotRegistered",
+			clusters: transfer.SingleCluster(fakeClient()),
+			options:  Options{EndpointType: "Unknown"},
+			wantErr:  efactory.ErrTypeNotRegistered,
+		},
+		{
+			name:     "auto-tuning without history, must return ErrAutoTuneInvalid",
+			clusters: transfer.SingleCluster(fakeClient()),
+			options:  Options{AutoTune: &rsync.AutoTune{LinkBandwidth: 10000}},
+			wantErr:  rsync.ErrAutoTuneInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(context.Background(), testr.New(t), tt.clusters, testSide(t, "src"), testSide(t, "dst"), tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	source, destination := testSide(t, "src"), testSide(t, "dst")
+	options := Options{EndpointType: efactory.TypeNodePort, History: &transfer.MemoryHistory{}}
+
+	p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	status, err := p.Status(ctx)
+	if err != nil || status.Phase != PhasePending {
+		t.Fatalf("Status() = %v, %v, want phase %v while the endpoint has no hostname", status, err, PhasePending)
+	}
+	if p.Client() != nil {
+		t.Fatal("Client() is set before the endpoint is healthy")
+	}
+
+	// the service is assigned a cluster IP
+	svc := &corev1.Service{}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Destination.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.Client() == nil {
+		t.Fatal("Client() is not set once the endpoint is healthy")
+	}
+	credentials := p.Client().Transport().Credentials()
+	if credentials.Namespace != "src" || credentials != *p.credentialsRef {
+		t.Errorf("transport client credentials = %v, want the copied server credentials %v", credentials, p.credentialsRef)
+	}
+	serverSecret, clientSecret := &corev1.Secret{}, &corev1.Secret{}
+	err = clusters.Destination.Get(ctx, p.Server().Transport().Credentials(), serverSecret)
+	if err != nil {
+		t.Fatalf("unable to get server credentials %v", err)
+	}
+	err = clusters.Source.Get(ctx, credentials, clientSecret)
+	if err != nil {
+		t.Fatalf("unable to get client credentials %v", err)
+	}
+	if string(clientSecret.Data["ca.crt"]) != string(serverSecret.Data["ca.crt"]) {
+		t.Error("transport client and server credentials do not share the same CA")
+	}
+
+	status, err = p.Status(ctx)
+	if err != nil || status.Phase != PhaseRunning {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhaseRunning)
+	}
+
+	pods := &corev1.PodList{}
+	err = clusters.Source.List(ctx, pods, client.InNamespace("src"))
+	if err != nil || len(pods.Items) != 1 {
+		t.Fatalf("unable to find transfer client pod %v", err)
+	}
+	pod := pods.Items[0]
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name: "rsync",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ExitCode: 0,
+			Message:  "files=3 bytes=2048",
+		}},
+	}}
+	err = clusters.Source.Status().Update(ctx, &pod)
+	if err != nil {
+		t.Fatalf("unable to update transfer client pod %v", err)
+	}
+	completed, err := p.Completed(ctx)
+	if err != nil || !completed {
+		t.Errorf("Completed() = %v, %v, want true", completed, err)
+	}
+	status, err = p.Status(ctx)
+	if err != nil || status.Phase != PhaseSucceeded {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhaseSucceeded)
+	}
+	history, err := p.History(ctx)
+	if err != nil || len(history) != 1 || history[0].FilesTransferred != 3 || history[0].BytesTransferred != 2048 {
+		t.Errorf("History() = %v, %v, want the completed iteration", history, err)
+	}
+
+	err = p.Cleanup(ctx, "cleanup", "true")
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	err = clusters.Source.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}, &corev1.Pod{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("transfer client pod not deleted, error = %v", err)
+	}
+	err = clusters.Source.Get(ctx, credentials, &corev1.Secret{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("copied credentials not deleted, error = %v", err)
+	}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), &corev1.Service{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("endpoint service not deleted, error = %v", err)
+	}
+}
+
+func TestNew_autoTune(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	source, destination := testSide(t, "src"), testSide(t, "dst")
+	start := metav1.NewTime(time.Now().Add(-10 * time.Minute).Truncate(time.Second))
+	finish := metav1.NewTime(start.Add(10 * time.Minute))
+	history := &transfer.MemoryHistory{}
+	// the previous iteration reached half of the target of 8000 KiB/s with its limit of 4000 KiB/s
+	err := history.Add(ctx, transfer.IterationRecord{
+		StartedAt: &start, FinishedAt: &finish, BytesSent: 4000 * 1024 * 600, BandwidthLimit: 4000,
+	})
+	if err != nil {
+		t.Fatalf("unable to add iteration %v", err)
+	}
+	options := Options{
+		EndpointType: efactory.TypeNodePort,
+		History:      history,
+		AutoTune:     &rsync.AutoTune{LinkBandwidth: 10000},
+	}
+
+	p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	svc := &corev1.Service{}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Destination.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil || p.Client() == nil {
+		t.Fatalf("New() error = %v, want the transfer client created", err)
+	}
+
+	pods := &corev1.PodList{}
+	err = clusters.Source.List(ctx, pods, client.InNamespace("src"))
+	if err != nil || len(pods.Items) != 1 {
+		t.Fatalf("unable to find transfer client pod %v", err)
+	}
+	pod := pods.Items[0]
+	command := strings.Join(pod.Spec.Containers[0].Command, " ")
+	if !strings.Contains(command, "--bwlimit=8000") || strings.Contains(command, "--compress") {
+		t.Errorf("transfer client command %q, want the bandwidth limit raised to 8000 without compression", command)
+	}
+
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+		Name: "rsync",
+		State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+			ExitCode: 0,
+			Message:  "files=3 bytes=2048 sent=4096 received=128",
+		}},
+	}}
+	err = clusters.Source.Status().Update(ctx, &pod)
+	if err != nil {
+		t.Fatalf("unable to update transfer client pod %v", err)
+	}
+	_, err = p.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status() error = %v", err)
+	}
+	records, err := p.History(ctx)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("History() = %v, %v, want the completed iteration", records, err)
+	}
+	if records[1].BandwidthLimit != 8000 || records[1].BytesSent != 4096 || records[1].BytesReceived != 128 {
+		t.Errorf("iteration = %+v, want the tuning and the bytes on the wire recorded", records[1])
+	}
+}
+
+func TestNew_preSyncHooks(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.SingleCluster(fakeClient())
+	source, destination := testSide(t, "src"), testSide(t, "dst")
+	hookName := types.NamespacedName{Namespace: "src", Name: "freeze"}
+	options := Options{
+		EndpointType: efactory.TypeNodePort,
+		PreSyncHooks: []hook.Hook{hook.NewJob(hookName, corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "freeze", Image: "busybox"}},
+		}, hook.Options{})},
+	}
+
+	p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	svc := &corev1.Service{}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Destination.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.Client() != nil {
+		t.Fatal("Client() is set before the pre-sync hooks completed")
+	}
+
+	job := &batchv1.Job{}
+	err = clusters.Source.Get(ctx, hookName, job)
+	if err != nil {
+		t.Fatalf("unable to get pre-sync hook job %v", err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	err = clusters.Source.Status().Update(ctx, job)
+	if err != nil {
+		t.Fatalf("unable to update pre-sync hook job %v", err)
+	}
+
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.Client() == nil {
+		t.Fatal("Client() is not set once the pre-sync hooks completed")
+	}
+}
+
+func TestNew_pull(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	source, destination := testSide(t, "src"), testSide(t, "dst")
+	options := Options{EndpointType: efactory.TypeNodePort, Pull: true}
+
+	p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	svc := &corev1.Service{}
+	err = clusters.Source.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service on the source %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Source.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if p.Client() == nil {
+		t.Fatal("Client() is not set once the endpoint is healthy")
+	}
+	credentials := p.Client().Transport().Credentials()
+	if credentials.Namespace != "dst" {
+		t.Errorf("transport client credentials = %v, want a copy in the destination namespace", credentials)
+	}
+	err = clusters.Destination.Get(ctx, credentials, &corev1.Secret{})
+	if err != nil {
+		t.Fatalf("unable to get client credentials on the destination %v", err)
+	}
+
+	for _, tt := range []struct {
+		c         client.Client
+		namespace string
+		want      string
+	}{
+		{clusters.Source, "src", "rsync-server-"},
+		{clusters.Destination, "dst", "rsync-client-"},
+	} {
+		pods := &corev1.PodList{}
+		err = tt.c.List(ctx, pods, client.InNamespace(tt.namespace))
+		if err != nil || len(pods.Items) != 1 || !strings.HasPrefix(pods.Items[0].Name, tt.want) {
+			t.Errorf("pods in %s = %v, %v, want a single %s pod", tt.namespace, pods.Items, err, tt.want)
+		}
+	}
+
+	status, err := p.Status(ctx)
+	if err != nil || status.Phase != PhaseRunning {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhaseRunning)
+	}
+
+	err = p.Cleanup(ctx, "cleanup", "true")
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	err = clusters.Destination.Get(ctx, credentials, &corev1.Secret{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("copied credentials not deleted, error = %v", err)
+	}
+	err = clusters.Source.Get(ctx, p.Endpoint().NamespacedName(), &corev1.Service{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("endpoint service not deleted, error = %v", err)
+	}
+}
+
+func TestNew_hostnameChanged(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	source, destination := testSide(t, "src"), testSide(t, "dst")
+	options := Options{EndpointType: efactory.TypeNodePort}
+
+	p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	setClusterIP := func(ip string) {
+		svc := &corev1.Service{}
+		err := clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+		if err != nil {
+			t.Fatalf("unable to get endpoint service %v", err)
+		}
+		svc.Spec.ClusterIP = ip
+		err = clusters.Destination.Update(ctx, svc)
+		if err != nil {
+			t.Fatalf("unable to update endpoint service %v", err)
+		}
+	}
+	setClusterIP("10.0.0.1")
+
+	p, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	pinned, err := endpoint.PinnedHostname(ctx, clusters.Destination, p.Endpoint().NamespacedName())
+	if err != nil || pinned != p.Endpoint().Hostname() {
+		t.Fatalf("PinnedHostname() = %v, %v, want %v", pinned, err, p.Endpoint().Hostname())
+	}
+
+	// the endpoint was recreated with another address
+	setClusterIP("10.0.0.2")
+	_, err = New(ctx, testr.New(t), clusters, source, destination, options)
+	if !errors.Is(err, endpoint.ErrHostnameChanged) {
+		t.Fatalf("New() error = %v, want %v", err, endpoint.ErrHostnameChanged)
+	}
+
+	err = p.Cleanup(ctx, "cleanup", "true")
+	if err != nil {
+		t.Fatalf("Cleanup() error = %v", err)
+	}
+	err = clusters.Destination.Get(ctx, endpoint.PinnedHostnameName(p.Endpoint().NamespacedName()), &corev1.ConfigMap{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("pinned hostname not deleted, error = %v", err)
+	}
+}
+
+// misconfiguredEndpoint is a NodePort endpoint reporting that it cannot become healthy
+type misconfiguredEndpoint struct {
+	endpoint.Endpoint
+}
+
+func (m misconfiguredEndpoint) Health(ctx context.Context, c client.Client) (*endpoint.Health, error) {
+	return endpoint.Unhealthy(endpoint.HealthReasonMisconfigured, "rejected by the test"), nil
+}
+
+func TestNew_endpointMisconfigured(t *testing.T) {
+	const typeMisconfigured efactory.Type = "Misconfigured"
+	nodePort, err := efactory.Lookup(efactory.TypeNodePort)
+	if err != nil {
+		t.Fatalf("Lookup() error = %v", err)
+	}
+	err = efactory.Register(typeMisconfigured, efactory.Registration{
+		New: func(ctx context.Context, c client.Client, logger logr.Logger,
+			namespacedName types.NamespacedName, options efactory.Options) (endpoint.Endpoint, error) {
+			e, err := nodePort.New(ctx, c, logger, namespacedName, options)
+			if err != nil {
+				return nil, err
+			}
+			return misconfiguredEndpoint{Endpoint: e}, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	_, err = New(context.Background(), testr.New(t), clusters, testSide(t, "src"), testSide(t, "dst"),
+		Options{EndpointType: typeMisconfigured})
+	if err == nil || !strings.Contains(err.Error(), "rejected by the test") {
+		t.Fatalf("New() error = %v, want the endpoint to be reported as misconfigured", err)
+	}
+}
+
+func TestNew_transferID(t *testing.T) {
+	tests := []struct {
+		name       string
+		transferID string
+		want       string
+		wantErr    error
+	}{
+		{
+			name: "no transfer ID, must derive it from the source and destination",
+			want: getTransferID(types.NamespacedName{Namespace: "src", Name: transfer.NamespaceHashForNames(testSide(t, "src").PVCList)["src"]},
+				types.NamespacedName{Namespace: "dst", Name: transfer.NamespaceHashForNames(testSide(t, "dst").PVCList)["dst"]}),
+		},
+		{
+			name:       "transfer ID set, must stamp it",
+			transferID: "migration-42",
+			want:       "migration-42",
+		},
+		{
+			name:       "invalid transfer ID, must return ErrTransferIDInvalid",
+			transferID: "migration/42",
+			wantErr:    transfer.ErrTransferIDInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+			source, destination := testSide(t, "src"), testSide(t, "dst")
+			options := Options{EndpointType: efactory.TypeNodePort, TransferID: tt.transferID}
+			p, err := New(ctx, testr.New(t), clusters, source, destination, options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if p.TransferID() != tt.want {
+				t.Errorf("TransferID() = %v, want %v", p.TransferID(), tt.want)
+			}
+			if _, ok := destination.Labels[transfer.TransferIDLabel]; ok {
+				t.Error("labels of the destination side were modified")
+			}
+			svc := &corev1.Service{}
+			err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+			if err != nil {
+				t.Fatalf("unable to get endpoint service %v", err)
+			}
+			if svc.Labels[transfer.TransferIDLabel] != tt.want || svc.Labels["app"] != "plan-test" {
+				t.Errorf("endpoint service labels = %v, want the transfer ID %v", svc.Labels, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlan_Pause(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.SingleCluster(fakeClient())
+	p, err := New(ctx, testr.New(t), clusters, testSide(t, "src"), testSide(t, "dst"), Options{EndpointType: efactory.TypeNodePort})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	err = p.Pause(ctx)
+	if !errors.Is(err, transfer.ErrPauseNotSupported) {
+		t.Errorf("Pause() error = %v, want %v while the transfer client is not created", err, transfer.ErrPauseNotSupported)
+	}
+
+	// the service is assigned a cluster IP
+	svc := &corev1.Service{}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Destination.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+	p, err = New(ctx, testr.New(t), clusters, testSide(t, "src"), testSide(t, "dst"), Options{EndpointType: efactory.TypeNodePort})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	err = p.Pause(ctx)
+	if err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	status, err := p.Status(ctx)
+	if err != nil || status.Phase != PhasePaused {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhasePaused)
+	}
+	err = p.Resume(ctx)
+	if err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	status, err = p.Status(ctx)
+	if err != nil || status.Phase != PhaseRunning {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhaseRunning)
+	}
+}
+
+func TestPlan_Cancel(t *testing.T) {
+	ctx := context.Background()
+	clusters := transfer.ClusterPair{Source: fakeClient(), Destination: fakeClient()}
+	options := Options{EndpointType: efactory.TypeNodePort}
+	p, err := New(ctx, testr.New(t), clusters, testSide(t, "src"), testSide(t, "dst"), options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	svc := &corev1.Service{}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil {
+		t.Fatalf("unable to get endpoint service %v", err)
+	}
+	svc.Spec.ClusterIP = "10.0.0.1"
+	err = clusters.Destination.Update(ctx, svc)
+	if err != nil {
+		t.Fatalf("unable to update endpoint service %v", err)
+	}
+	p, err = New(ctx, testr.New(t), clusters, testSide(t, "src"), testSide(t, "dst"), options)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// the transfer pods are deleted by the first call, the next one finds them gone
+	cancelled, err := p.Cancel(ctx)
+	if err != nil || cancelled {
+		t.Fatalf("Cancel() = %v, %v, want cancelling while the transfer pods terminate", cancelled, err)
+	}
+	cancelled, err = p.Cancel(ctx)
+	if err != nil || !cancelled {
+		t.Fatalf("Cancel() = %v, %v, want cancelled", cancelled, err)
+	}
+	status, err := p.Status(ctx)
+	if err != nil || status.Phase != PhaseCancelled {
+		t.Errorf("Status() = %v, %v, want phase %v", status, err, PhaseCancelled)
+	}
+	for _, c := range []client.Client{clusters.Source, clusters.Destination} {
+		pods := &corev1.PodList{}
+		err = c.List(ctx, pods)
+		if err != nil || len(pods.Items) != 0 {
+			t.Errorf("transfer pods not deleted on cancel, pods = %v, error = %v", pods.Items, err)
+		}
+	}
+	err = clusters.Destination.Get(ctx, p.Endpoint().NamespacedName(), svc)
+	if err != nil || svc.Labels[transfer.CancelledLabel] != "true" {
+		t.Errorf("endpoint service not marked for cleanup, labels = %v, error = %v", svc.Labels, err)
+	}
+}
+
+type fakeVerifier struct {
+	transfer.Server
+	integrity *transfer.Integrity
+}
+
+func (f fakeVerifier) VerifyIntegrity(ctx context.Context, c client.Client) (*transfer.Integrity, error) {
+	return f.integrity, nil
+}
+
+func TestPlan_verifyIntegrity(t *testing.T) {
+	tests := []struct {
+		name      string
+		integrity *transfer.Integrity
+		wantPhase Phase
+	}{
+		{name: "verification running, must be running", wantPhase: PhaseRunning},
+		{name: "verification succeeded, must succeed", integrity: &transfer.Integrity{Verified: true}, wantPhase: PhaseSucceeded},
+		{name: "verification failed, must fail", integrity: &transfer.Integrity{Message: "verification failed for data"}, wantPhase: PhaseFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Plan{
+				logger:   testr.New(t),
+				clusters: transfer.SingleCluster(fakeClient()),
+				server:   fakeVerifier{integrity: tt.integrity},
+			}
+			status := &Status{Phase: PhaseSucceeded, Transfer: &transfer.Status{}}
+			if err := p.verifyIntegrity(context.Background(), status); err != nil {
+				t.Fatalf("verifyIntegrity() error = %v", err)
+			}
+			if status.Phase != tt.wantPhase || status.Transfer.Integrity != tt.integrity {
+				t.Errorf("verifyIntegrity() status = %v, integrity %v, want phase %v", status.Phase, status.Transfer.Integrity, tt.wantPhase)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package preflight
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/utils"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	defaultImage          = "quay.io/konveyor/rsync-transfer:latest"
+	defaultTimeoutSeconds = 10
+	probeContainer        = "probe"
+	credentialsVolume     = "credentials"
+	credentialsMountPath  = "/etc/preflight/certs"
+)
+
+// exit codes of the probe script mapped to failure types
+const (
+	exitCodeDNS  = 10
+	exitCodeTCP  = 11
+	exitCodeTLS  = 12
+	exitCodeAuth = 13
+)
+
+// probeScript checks that the endpoint resolves, accepts TCP connections and, when TLS
+// credentials are mounted, completes a TLS handshake using the transport client credentials,
+// presenting the client certificate unless the credentials are one way.
+// Handshakes failing on the verification of the server certificate are reported as TLS
+// failures, all other handshake failures are reported as auth failures.
+const probeScript = `
+if ! getent hosts "${HOST}" > /dev/null; then
+  echo "unable to resolve ${HOST}" > /dev/termination-log
+  exit 10
+fi
+if ! timeout "${TIMEOUT}" bash -c "exec 3<>/dev/tcp/${HOST}/${PORT}"; then
+  echo "unable to connect to ${HOST}:${PORT}" > /dev/termination-log
+  exit 11
+fi
+if [ "${TLS}" != "true" ]; then
+  exit 0
+fi
+client_cert=()
+if [ "${CLIENT_CERT}" == "true" ]; then
+  client_cert=(-cert "${CERTS}/client.crt" -key "${CERTS}/client.key")
+fi
+out=$(timeout "${TIMEOUT}" openssl s_client -connect "${HOST}:${PORT}" -servername "${HOST}" \
+  -CAfile "${CERTS}/ca.crt" "${client_cert[@]}" \
+  -verify_return_error < /dev/null 2>&1)
+if [ $? -eq 0 ]; then
+  exit 0
+fi
+echo "${out}" | tail -n 5 > /dev/termination-log
+if echo "${out}" | grep -qi "verify"; then
+  exit 12
+fi
+exit 13
+`
+
+// FailureType is the category of a connectivity failure
+type FailureType string
+
+const (
+	// FailureDNS is reported when the hostname of the endpoint does not resolve
+	FailureDNS FailureType = "DNS"
+	// FailureTCP is reported when the endpoint does not accept TCP connections
+	FailureTCP FailureType = "TCP"
+	// FailureTLS is reported when the certificate of the server cannot be verified
+	FailureTLS FailureType = "TLS"
+	// FailureAuth is reported when the server rejects the client credentials
+	FailureAuth FailureType = "Auth"
+	// FailureUnknown is reported when the probe failed for any other reason
+	FailureUnknown FailureType = "Unknown"
+)
+
+// Failure is a connectivity failure found by the preflight check
+type Failure struct {
+	Type    FailureType
+	Message string
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("preflight %s failure: %s", f.Type, f.Message)
+}
+
+// Result is the result of the preflight check
+type Result struct {
+	// Completed is false while the probe is still running
+	Completed bool
+	// Failure is set when the probe completed and found a connectivity problem
+	Failure *Failure
+}
+
+// Options configure the preflight check
+type Options struct {
+	// Image is the image of the probe pod, it requires bash, getent, timeout and openssl
+	Image string
+	// Credentials of the transport client, the TLS handshake is only checked for
+	// credentials of SSL type. They are mounted like in stunnel clients, see
+	// stunnel.ClientCredentialsVolumeSource
+	Credentials *transport.Credentials
+	// KubernetesTLSSecrets reads the client key pair of the credentials from tls.crt and
+	// tls.key, see transport.Options
+	KubernetesTLSSecrets bool
+	// TimeoutSeconds bounds each of the connection attempts, defaults to 10s
+	TimeoutSeconds int32
+	// Labels are applied to the probe pod
+	Labels map[string]string
+	// OwnerReferences are applied to the probe pod
+	OwnerReferences []metav1.OwnerReference
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
+}
+
+// Check is a connectivity check from the namespace of a transfer client to an endpoint
+type Check struct {
+	logger         logr.Logger
+	namespacedName types.NamespacedName
+}
+
+// AddToScheme should be used as soon as scheme is created to add
+// core objects for encoding/decoding
+func AddToScheme(scheme *runtime.Scheme) error {
+	return corev1.AddToScheme(scheme)
+}
+
+// APIsToWatch give a list of APIs to watch if using this package
+// to run the preflight check
+func APIsToWatch() ([]client.Object, error) {
+	return []client.Object{&corev1.Pod{}}, nil
+}
+
+// NewConnectivityCheck creates a short lived probe pod named after namespacedName, the pod
+// verifies that the hostname of the endpoint resolves, accepts connections on the ingress
+// port and completes a TLS handshake with the transport credentials. Callers are expected
+// to poll Result until the check completes, and to run it before creating the transfer client.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;create;update;patch;delete
+func NewConnectivityCheck(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	e endpoint.Endpoint,
+	options Options) (*Check, error) {
+	check := &Check{
+		logger:         logger.WithValues("preflight", namespacedName),
+		namespacedName: namespacedName,
+	}
+
+	if e.Hostname() == "" {
+		return nil, fmt.Errorf("%w: hostname of endpoint %s is not set", endpoint.ErrEndpointNotReady, e.NamespacedName())
+	}
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      namespacedName.Name,
+			Namespace: namespacedName.Namespace,
+		},
+	}
+	podSpec := getPodSpec(e, options)
+	_, err := reconcile.CreateOrUpdate(ctx, c, check.logger, pod, reconcile.Options{
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	}, func() error {
+		pod.Labels = options.Labels
+		pod.OwnerReferences = options.OwnerReferences
+		if pod.CreationTimestamp.IsZero() {
+			pod.Spec = podSpec
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return check, nil
+}
+
+func getPodSpec(e endpoint.Endpoint, options Options) corev1.PodSpec {
+	image := options.Image
+	if image == "" {
+		image = defaultImage
+	}
+	timeout := options.TimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultTimeoutSeconds
+	}
+	useTLS := options.Credentials != nil && options.Credentials.Type == stunnel.CredentialsTypeSSL
+	clientCert := useTLS && !isOneWayTLS(options.Credentials)
+
+	container := corev1.Container{
+		Name:    probeContainer,
+		Image:   image,
+		Command: []string{"/bin/bash", "-c", probeScript},
+		Env: []corev1.EnvVar{
+			{Name: "HOST", Value: e.Hostname()},
+			{Name: "PORT", Value: strconv.Itoa(int(e.IngressPort()))},
+			{Name: "TIMEOUT", Value: strconv.Itoa(int(timeout))},
+			{Name: "TLS", Value: strconv.FormatBool(useTLS)},
+			{Name: "CLIENT_CERT", Value: strconv.FormatBool(clientCert)},
+			{Name: "CERTS", Value: credentialsMountPath},
+		},
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	podSpec := corev1.PodSpec{
+		Containers:    []corev1.Container{container},
+		RestartPolicy: corev1.RestartPolicyNever,
+		// the probe makes two connection attempts, leave room for pulling the image
+		ActiveDeadlineSeconds: pointer.Int64(int64(timeout)*2 + 300),
+	}
+	if useTLS {
+		podSpec.Containers[0].VolumeMounts = []corev1.VolumeMount{
+			{Name: credentialsVolume, MountPath: credentialsMountPath},
+		}
+		podSpec.Volumes = []corev1.Volume{
+			{
+				Name: credentialsVolume,
+				VolumeSource: stunnel.ClientCredentialsVolumeSource(&transport.Options{
+					Credentials:          options.Credentials,
+					KubernetesTLSSecrets: options.KubernetesTLSSecrets,
+				}),
+			},
+		}
+	}
+	return podSpec
+}
+
+// isOneWayTLS returns whether the provider of the credentials issues no client certificate
+func isOneWayTLS(c *transport.Credentials) bool {
+	p, ok := c.Provider.(transport.OneWayTLSProvider)
+	return ok && p.OneWayTLS()
+}
+
+// Result returns the result of the check, the result is not completed while the probe is running
+func (ch *Check) Result(ctx context.Context, c client.Client) (*Result, error) {
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, ch.namespacedName, pod)
+	if err != nil {
+		ch.logger.Error(err, "unable to get probe pod")
+		return nil, err
+	}
+
+	switch pod.Status.Phase {
+	case corev1.PodSucceeded:
+		return &Result{Completed: true}, nil
+	case corev1.PodFailed:
+		return &Result{Completed: true, Failure: getFailure(pod)}, nil
+	default:
+		return &Result{Completed: false}, nil
+	}
+}
+
+func getFailure(pod *corev1.Pod) *Failure {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name != probeContainer || status.State.Terminated == nil {
+			continue
+		}
+		terminated := status.State.Terminated
+		failure := &Failure{Type: FailureUnknown, Message: terminated.Message}
+		switch terminated.ExitCode {
+		case exitCodeDNS:
+			failure.Type = FailureDNS
+		case exitCodeTCP:
+			failure.Type = FailureTCP
+		case exitCodeTLS:
+			failure.Type = FailureTLS
+		case exitCodeAuth:
+			failure.Type = FailureAuth
+		}
+		return failure
+	}
+	return &Failure{Type: FailureUnknown, Message: fmt.Sprintf("probe pod failed: %s %s", pod.Status.Reason, pod.Status.Message)}
+}
+
+// MarkForCleanup adds a label to the probe pod
+func (ch *Check) MarkForCleanup(ctx context.Context, c client.Client, key, value string) error {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      ch.namespacedName.Name,
+			Namespace: ch.namespacedName.Namespace,
+		},
+	}
+	return utils.UpdateWithLabel(ctx, c, pod, key, value)
+}
@@ -0,0 +1,273 @@
+package preflight
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/endpoint/external"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/credentials"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type fakeEndpoint struct {
+	endpoint.Endpoint
+	hostname string
+}
+
+func (f fakeEndpoint) Hostname() string { return f.hostname }
+
+func (f fakeEndpoint) NamespacedName() types.NamespacedName {
+	return types.NamespacedName{Name: "endpoint", Namespace: "test-ns"}
+}
+
+func TestNewConnectivityCheck(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "preflight", Namespace: "test-ns"}
+	e, err := external.New(types.NamespacedName{Name: "endpoint", Namespace: "test-ns"}, "test.example.com", 443, 6443)
+	if err != nil {
+		t.Fatalf("unable to create endpoint %v", err)
+	}
+	tests := []struct {
+		name        string
+		endpoint    endpoint.Endpoint
+		options     Options
+		wantEnv     map[string]string
+		wantVolumes bool
+		// wantItems maps the keys of the credentials to their paths in the probe container
+		wantItems map[string]string
+		wantErr   error
+	}{
+		{
+			name:     "no credentials, must only check DNS and TCP",
+			endpoint: e,
+			options:  Options{},
+			wantEnv: map[string]string{
+				"HOST":        "test.example.com",
+				"PORT":        "443",
+				"TIMEOUT":     "10",
+				"TLS":         "false",
+				"CLIENT_CERT": "false",
+				"CERTS":       credentialsMountPath,
+			},
+		},
+		{
+			name:     "PSK credentials, must only check DNS and TCP",
+			endpoint: e,
+			options: Options{
+				Credentials: &transport.Credentials{
+					SecretRef: types.NamespacedName{Name: "creds", Namespace: "test-ns"},
+					Type:      stunnel.CredentialsTypePSK,
+				},
+			},
+			wantEnv: map[string]string{
+				"HOST":        "test.example.com",
+				"PORT":        "443",
+				"TIMEOUT":     "10",
+				"TLS":         "false",
+				"CLIENT_CERT": "false",
+				"CERTS":       credentialsMountPath,
+			},
+		},
+		{
+			name:     "SSL credentials, must mount the credentials and check TLS",
+			endpoint: e,
+			options: Options{
+				TimeoutSeconds: 5,
+				Credentials: &transport.Credentials{
+					SecretRef: types.NamespacedName{Name: "creds", Namespace: "test-ns"},
+					Type:      stunnel.CredentialsTypeSSL,
+				},
+			},
+			wantEnv: map[string]string{
+				"HOST":        "test.example.com",
+				"PORT":        "443",
+				"TIMEOUT":     "5",
+				"TLS":         "true",
+				"CLIENT_CERT": "true",
+				"CERTS":       credentialsMountPath,
+			},
+			wantVolumes: true,
+			wantItems:   map[string]string{"client.crt": "client.crt", "client.key": "client.key", "ca.crt": "ca.crt"},
+		},
+		{
+			name:     "kubernetes.io/tls credentials, must mount tls.crt and tls.key as the client key pair",
+			endpoint: e,
+			options: Options{
+				Credentials: &transport.Credentials{
+					SecretRef: types.NamespacedName{Name: "creds-client", Namespace: "test-ns"},
+					Type:      stunnel.CredentialsTypeSSL,
+				},
+				KubernetesTLSSecrets: true,
+			},
+			wantEnv: map[string]string{
+				"HOST":        "test.example.com",
+				"PORT":        "443",
+				"TIMEOUT":     "10",
+				"TLS":         "true",
+				"CLIENT_CERT": "true",
+				"CERTS":       credentialsMountPath,
+			},
+			wantVolumes: true,
+			wantItems:   map[string]string{"tls.crt": "client.crt", "tls.key": "client.key", "ca.crt": "ca.crt"},
+		},
+		{
+			name:     "one way credentials, must check TLS without a client certificate",
+			endpoint: e,
+			options: Options{
+				Credentials: &transport.Credentials{
+					SecretRef: types.NamespacedName{Name: "creds", Namespace: "test-ns"},
+					Type:      stunnel.CredentialsTypeSSL,
+					Provider:  credentials.ServiceCA{Service: "foo"},
+				},
+			},
+			wantEnv: map[string]string{
+				"HOST":        "test.example.com",
+				"PORT":        "443",
+				"TIMEOUT":     "10",
+				"TLS":         "true",
+				"CLIENT_CERT": "false",
+				"CERTS":       credentialsMountPath,
+			},
+			wantVolumes: true,
+			wantItems:   map[string]string{credentials.ServiceCABundleKey: "ca.crt"},
+		},
+		{
+			name:     "endpoint without hostname, must return ErrEndpointNotReady",
+			endpoint: fakeEndpoint{},
+			wantErr:  endpoint.ErrEndpointNotReady,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().Build()
+			_, err := NewConnectivityCheck(context.Background(), c, testr.New(t), namespacedName, tt.endpoint, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewConnectivityCheck() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			pod := &corev1.Pod{}
+			err = c.Get(context.Background(), namespacedName, pod)
+			if err != nil {
+				t.Fatalf("unable to get probe pod %v", err)
+			}
+			if pod.Spec.RestartPolicy != corev1.RestartPolicyNever {
+				t.Errorf("pod restart policy = %v, want %v", pod.Spec.RestartPolicy, corev1.RestartPolicyNever)
+			}
+			env := map[string]string{}
+			for _, envVar := range pod.Spec.Containers[0].Env {
+				env[envVar.Name] = envVar.Value
+			}
+			if !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("probe env = %v, want %v", env, tt.wantEnv)
+			}
+			if hasVolumes := len(pod.Spec.Volumes) > 0; hasVolumes != tt.wantVolumes {
+				t.Errorf("probe volumes = %v, want volumes %v", pod.Spec.Volumes, tt.wantVolumes)
+			}
+			if tt.wantItems != nil {
+				volumeSource := pod.Spec.Volumes[0].VolumeSource
+				items := []corev1.KeyToPath{}
+				switch {
+				case volumeSource.Secret != nil:
+					items = volumeSource.Secret.Items
+				case volumeSource.ConfigMap != nil:
+					items = volumeSource.ConfigMap.Items
+				}
+				got := map[string]string{}
+				for _, item := range items {
+					got[item.Key] = item.Path
+				}
+				if !reflect.DeepEqual(got, tt.wantItems) {
+					t.Errorf("credentials items = %v, want %v", got, tt.wantItems)
+				}
+			}
+		})
+	}
+}
+
+func TestCheck_Result(t *testing.T) {
+	namespacedName := types.NamespacedName{Name: "preflight", Namespace: "test-ns"}
+	terminated := func(exitCode int32, message string) corev1.PodStatus {
+		return corev1.PodStatus{
+			Phase: corev1.PodFailed,
+			ContainerStatuses: []corev1.ContainerStatus{
+				{
+					Name: probeContainer,
+					State: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Message: message},
+					},
+				},
+			},
+		}
+	}
+	tests := []struct {
+		name   string
+		status corev1.PodStatus
+		want   *Result
+	}{
+		{
+			name:   "probe running, must not be completed",
+			status: corev1.PodStatus{Phase: corev1.PodRunning},
+			want:   &Result{Completed: false},
+		},
+		{
+			name:   "probe succeeded, must be completed without failure",
+			status: corev1.PodStatus{Phase: corev1.PodSucceeded},
+			want:   &Result{Completed: true},
+		},
+		{
+			name:   "hostname not resolved, must return DNS failure",
+			status: terminated(exitCodeDNS, "unable to resolve test.example.com"),
+			want:   &Result{Completed: true, Failure: &Failure{Type: FailureDNS, Message: "unable to resolve test.example.com"}},
+		},
+		{
+			name:   "connection refused, must return TCP failure",
+			status: terminated(exitCodeTCP, "unable to connect to test.example.com:443"),
+			want:   &Result{Completed: true, Failure: &Failure{Type: FailureTCP, Message: "unable to connect to test.example.com:443"}},
+		},
+		{
+			name:   "server certificate not verified, must return TLS failure",
+			status: terminated(exitCodeTLS, "verify error:num=20"),
+			want:   &Result{Completed: true, Failure: &Failure{Type: FailureTLS, Message: "verify error:num=20"}},
+		},
+		{
+			name:   "client certificate rejected, must return Auth failure",
+			status: terminated(exitCodeAuth, "alert bad certificate"),
+			want:   &Result{Completed: true, Failure: &Failure{Type: FailureAuth, Message: "alert bad certificate"}},
+		},
+		{
+			name:   "pod deadline exceeded, must return Unknown failure",
+			status: corev1.PodStatus{Phase: corev1.PodFailed, Reason: "DeadlineExceeded", Message: "pod was active too long"},
+			want: &Result{Completed: true, Failure: &Failure{
+				Type:    FailureUnknown,
+				Message: "probe pod failed: DeadlineExceeded pod was active too long",
+			}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: namespacedName.Name, Namespace: namespacedName.Namespace},
+				Status:     tt.status,
+			}
+			c := fake.NewClientBuilder().WithObjects(pod).Build()
+			check := &Check{logger: testr.New(t), namespacedName: namespacedName}
+			got, err := check.Result(context.Background(), c)
+			if err != nil {
+				t.Fatalf("Result() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Result() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
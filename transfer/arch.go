@@ -0,0 +1,80 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ArchitectureAuto schedules transfer pods on nodes of the architecture of the nodes the PVCs of
+// the transfer are attached to, see GetPVCsArchitecture
+const ArchitectureAuto = "auto"
+
+// selectedNodeAnnotation is set on PVCs by the scheduler when binding volumes on first consumer
+const selectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+// ErrArchitectureConflict is returned when the PVCs of a transfer are attached to nodes of
+// different architectures
+var ErrArchitectureConflict = errors.New("PVCs attached to nodes of different architectures")
+
+// GetPVCsArchitecture returns the architecture of the nodes the PVCs in pvcList are attached to,
+// found from the node selected when binding them or the nodes of the pods mounting them. It
+// returns an empty string when none of the PVCs is attached to a node. Listing nodes requires
+// the permission to get nodes.
+func GetPVCsArchitecture(ctx context.Context, c client.Client, pvcList PVCList) (string, error) {
+	nodes := map[string]bool{}
+	for _, namespace := range pvcList.Namespaces() {
+		pList := &corev1.PodList{}
+		err := c.List(ctx, pList, client.InNamespace(namespace))
+		if err != nil {
+			return "", err
+		}
+		for _, pvc := range pvcList.InNamespace(namespace).PVCs() {
+			if node := pvc.Claim().Annotations[selectedNodeAnnotation]; node != "" {
+				nodes[node] = true
+			}
+			for i := range pList.Items {
+				pod := &pList.Items[i]
+				if pod.Spec.NodeName != "" && !isPodTerminated(pod) && mountsPVC(pod, pvc.Claim().Name) {
+					nodes[pod.Spec.NodeName] = true
+				}
+			}
+		}
+	}
+
+	architecture := ""
+	for name := range nodes {
+		node := &corev1.Node{}
+		err := c.Get(ctx, client.ObjectKey{Name: name}, node)
+		if err != nil {
+			return "", err
+		}
+		nodeArchitecture := node.Labels[corev1.LabelArchStable]
+		switch {
+		case nodeArchitecture == "":
+			continue
+		case architecture == "":
+			architecture = nodeArchitecture
+		case architecture != nodeArchitecture:
+			return "", fmt.Errorf("%w: %s and %s", ErrArchitectureConflict, architecture, nodeArchitecture)
+		}
+	}
+	return architecture, nil
+}
+
+// ResolveArchitecture returns the pod options with the architecture of the PVCs in pvcList when
+// their Architecture is ArchitectureAuto, the architecture is left empty when it is not known
+func ResolveArchitecture(ctx context.Context, c client.Client, pvcList PVCList, options PodOptions) (PodOptions, error) {
+	if options.Architecture != ArchitectureAuto {
+		return options, nil
+	}
+	architecture, err := GetPVCsArchitecture(ctx, c, pvcList)
+	if err != nil {
+		return options, err
+	}
+	options.Architecture = architecture
+	return options, nil
+}
@@ -0,0 +1,87 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetPVCsArchitecture(t *testing.T) {
+	node := func(name, arch string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{corev1.LabelArchStable: arch}}}
+	}
+	pod := func(name, nodeName, claimName string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foo"},
+			Spec: corev1.PodSpec{
+				NodeName: nodeName,
+				Volumes: []corev1.Volume{{
+					Name: "data",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claimName},
+					},
+				}},
+			},
+		}
+	}
+	pvc := func(name, selectedNode string) *corev1.PersistentVolumeClaim {
+		claim := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foo"}}
+		if selectedNode != "" {
+			claim.Annotations = map[string]string{selectedNodeAnnotation: selectedNode}
+		}
+		return claim
+	}
+	tests := []struct {
+		name    string
+		pvcs    []*corev1.PersistentVolumeClaim
+		objects []client.Object
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "PVC mounted by a pod, must return the architecture of its node",
+			pvcs:    []*corev1.PersistentVolumeClaim{pvc("data", "")},
+			objects: []client.Object{node("node-1", "arm64"), pod("app", "node-1", "data")},
+			want:    "arm64",
+		},
+		{
+			name:    "PVC bound on a selected node, must return the architecture of the node",
+			pvcs:    []*corev1.PersistentVolumeClaim{pvc("data", "node-1")},
+			objects: []client.Object{node("node-1", "amd64")},
+			want:    "amd64",
+		},
+		{
+			name:    "PVC not attached, must return an empty architecture",
+			pvcs:    []*corev1.PersistentVolumeClaim{pvc("data", "")},
+			objects: []client.Object{node("node-1", "arm64"), pod("app", "node-1", "other")},
+			want:    "",
+		},
+		{
+			name:    "PVCs attached to nodes of different architectures, must return an error",
+			pvcs:    []*corev1.PersistentVolumeClaim{pvc("data", "node-1"), pvc("logs", "node-2")},
+			objects: []client.Object{node("node-1", "arm64"), node("node-2", "amd64")},
+			wantErr: ErrArchitectureConflict,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			pvcList, err := NewPVCList(tt.pvcs...)
+			if err != nil {
+				t.Fatalf("NewPVCList() error = %v", err)
+			}
+			got, err := GetPVCsArchitecture(context.Background(), c, pvcList)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("GetPVCsArchitecture() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("GetPVCsArchitecture() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ErrDestinationFull is returned when the destination PVCs cannot hold the data of the source,
+// callers are expected to expand the destination PVCs rather than retry the transfer
+var ErrDestinationFull = errors.New("destination full")
+
+// Capacity returns the total storage capacity of the PVCs of the list, the capacity of bound
+// PVCs or the storage requested by the others
+func Capacity(pvcList PVCList) resource.Quantity {
+	total := resource.Quantity{}
+	for _, pvc := range pvcList.PVCs() {
+		capacity, ok := pvc.Claim().Status.Capacity[corev1.ResourceStorage]
+		if !ok {
+			capacity = pvc.Claim().Spec.Resources.Requests[corev1.ResourceStorage]
+		}
+		total.Add(capacity)
+	}
+	return total
+}
+
+// CheckCapacity returns an error wrapping ErrDestinationFull when the estimated size of the
+// source exceeds the capacity of the destination. The overhead of the filesystem of the
+// destination is not accounted for, transfers filling it almost entirely may still fail with
+// ErrDestinationFull. Nil estimates, e.g. of failed pre-scans, are not checked.
+func CheckCapacity(estimate *Estimate, capacity resource.Quantity) error {
+	if estimate == nil || capacity.CmpInt64(estimate.TotalBytes) >= 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: the source holds %d bytes, the destination has a capacity of %s",
+		ErrDestinationFull, estimate.TotalBytes, capacity.String())
+}
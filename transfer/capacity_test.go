@@ -0,0 +1,98 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPVCWithCapacity(name, request, capacity string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foo"},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.VolumeResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(request)},
+			},
+		},
+	}
+	if capacity != "" {
+		pvc.Status.Capacity = corev1.ResourceList{corev1.ResourceStorage: resource.MustParse(capacity)}
+	}
+	return pvc
+}
+
+func TestCapacity(t *testing.T) {
+	tests := []struct {
+		name string
+		pvcs []*corev1.PersistentVolumeClaim
+		want string
+	}{
+		{
+			name: "no PVCs, must return zero",
+			want: "0",
+		},
+		{
+			name: "bound PVC, must return its capacity",
+			pvcs: []*corev1.PersistentVolumeClaim{testPVCWithCapacity("data", "1Gi", "2Gi")},
+			want: "2Gi",
+		},
+		{
+			name: "pending PVC, must return its request",
+			pvcs: []*corev1.PersistentVolumeClaim{testPVCWithCapacity("data", "1Gi", "")},
+			want: "1Gi",
+		},
+		{
+			name: "several PVCs, must return the sum",
+			pvcs: []*corev1.PersistentVolumeClaim{
+				testPVCWithCapacity("data", "1Gi", "2Gi"),
+				testPVCWithCapacity("logs", "1Gi", ""),
+			},
+			want: "3Gi",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvcList, _ := NewPVCList(tt.pvcs...)
+			got := Capacity(pvcList)
+			if want := resource.MustParse(tt.want); got.Cmp(want) != 0 {
+				t.Errorf("Capacity() = %s, want %s", got.String(), want.String())
+			}
+		})
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate *Estimate
+		capacity string
+		wantErr  error
+	}{
+		{
+			name:     "no estimate, must not return an error",
+			capacity: "1Ki",
+		},
+		{
+			name:     "estimate within the capacity, must not return an error",
+			estimate: &Estimate{TotalFiles: 2, TotalBytes: 1024},
+			capacity: "1Ki",
+		},
+		{
+			name:     "estimate exceeding the capacity, must return ErrDestinationFull",
+			estimate: &Estimate{TotalFiles: 2, TotalBytes: 1025},
+			capacity: "1Ki",
+			wantErr:  ErrDestinationFull,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckCapacity(tt.estimate, resource.MustParse(tt.capacity))
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("CheckCapacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package transfer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Diagnosable is implemented by transfer clients and servers which can explain why their pods
+// are not running, e.g. to surface the reasons of a transfer stuck in a pending phase
+type Diagnosable interface {
+	// Diagnose returns the reasons why the transfer pods are not running, see DiagnosePods
+	Diagnose(ctx context.Context, c client.Client) ([]string, error)
+}
+
+// waitingReasons are the reasons of waiting containers reported by DiagnosePods, other
+// reasons such as ContainerCreating are transient
+var waitingReasons = map[string]bool{
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CrashLoopBackOff":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+	"RunContainerError":          true,
+}
+
+// DiagnosePods returns human readable reasons why the given pods are not running, it is empty
+// for running pods. Pods are diagnosed from their scheduling condition, the state of their
+// containers, the binding of the PVCs they mount and their warning events. Pods which do not
+// exist are reported as such.
+//
+// Diagnosing pods lists events, add the following line to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=events,verbs=get;list;watch
+func DiagnosePods(ctx context.Context, c client.Client, pods ...client.ObjectKey) ([]string, error) {
+	reasons := []string{}
+	for _, key := range pods {
+		pod := &corev1.Pod{}
+		err := c.Get(ctx, key, pod)
+		switch {
+		case k8serrors.IsNotFound(err):
+			reasons = append(reasons, fmt.Sprintf("pod %s not found", key))
+			continue
+		case err != nil:
+			return nil, err
+		}
+		podReasons, err := diagnosePod(ctx, c, pod)
+		if err != nil {
+			return nil, err
+		}
+		reasons = append(reasons, podReasons...)
+	}
+	return reasons, nil
+}
+
+// diagnosePod returns the reasons why a pod is not running
+func diagnosePod(ctx context.Context, c client.Client, pod *corev1.Pod) ([]string, error) {
+	key := client.ObjectKeyFromObject(pod)
+	reasons := []string{}
+	if pod.Status.Phase == corev1.PodFailed {
+		reasons = append(reasons, withDetails(fmt.Sprintf("pod %s failed", key), pod.Status.Reason, pod.Status.Message))
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionFalse {
+			reasons = append(reasons, fmt.Sprintf("pod %s cannot be scheduled: %s: %s", key, condition.Reason, condition.Message))
+		}
+	}
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...)
+	statuses = append(statuses, pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		if reason := diagnoseContainer(key, status); reason != "" {
+			reasons = append(reasons, reason)
+		}
+	}
+	if pod.Status.Phase != corev1.PodPending && len(reasons) == 0 {
+		return reasons, nil
+	}
+
+	pvcReasons, err := diagnosePVCs(ctx, c, pod)
+	if err != nil {
+		return nil, err
+	}
+	reasons = append(reasons, pvcReasons...)
+	eventReasons, err := diagnoseEvents(ctx, c, pod)
+	if err != nil {
+		return nil, err
+	}
+	return append(reasons, eventReasons...), nil
+}
+
+// diagnoseContainer returns why a container is not running, it is empty for running containers
+// and for containers waiting for a transient reason
+func diagnoseContainer(pod client.ObjectKey, status corev1.ContainerStatus) string {
+	waiting := status.State.Waiting
+	if waiting == nil || !waitingReasons[waiting.Reason] {
+		return ""
+	}
+	switch waiting.Reason {
+	case "ErrImagePull", "ImagePullBackOff", "InvalidImageName":
+		return fmt.Sprintf("container %s of pod %s cannot pull image %s: %s", status.Name, pod, status.Image, waiting.Message)
+	case "CrashLoopBackOff":
+		reason := fmt.Sprintf("container %s of pod %s is crash looping after %d restarts", status.Name, pod, status.RestartCount)
+		if terminated := status.LastTerminationState.Terminated; terminated != nil {
+			reason = withDetails(fmt.Sprintf("%s, last exit code %d", reason, terminated.ExitCode), terminated.Reason, terminated.Message)
+		}
+		return reason
+	default:
+		return fmt.Sprintf("container %s of pod %s is waiting: %s: %s", status.Name, pod, waiting.Reason, waiting.Message)
+	}
+}
+
+// withDetails appends the non empty details to a reason
+func withDetails(reason string, details ...string) string {
+	for _, detail := range details {
+		if detail = strings.TrimSpace(detail); detail != "" {
+			reason = fmt.Sprintf("%s: %s", reason, detail)
+		}
+	}
+	return reason
+}
+
+// diagnosePVCs returns the PVCs mounted by a pod which are missing or not bound
+func diagnosePVCs(ctx context.Context, c client.Client, pod *corev1.Pod) ([]string, error) {
+	reasons := []string{}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		key := client.ObjectKey{Namespace: pod.Namespace, Name: volume.PersistentVolumeClaim.ClaimName}
+		pvc := &corev1.PersistentVolumeClaim{}
+		err := c.Get(ctx, key, pvc)
+		switch {
+		case k8serrors.IsNotFound(err):
+			reasons = append(reasons, fmt.Sprintf("PVC %s mounted by pod %s not found", key, pod.Name))
+		case err != nil:
+			return nil, err
+		case pvc.Status.Phase != corev1.ClaimBound:
+			reasons = append(reasons, fmt.Sprintf("PVC %s mounted by pod %s is not bound: %s", key, pod.Name, pvc.Status.Phase))
+		}
+	}
+	return reasons, nil
+}
+
+// diagnoseEvents returns the distinct warning events of a pod, oldest first
+func diagnoseEvents(ctx context.Context, c client.Client, pod *corev1.Pod) ([]string, error) {
+	eventList := &corev1.EventList{}
+	err := c.List(ctx, eventList, client.InNamespace(pod.Namespace))
+	if err != nil {
+		return nil, err
+	}
+	events := []corev1.Event{}
+	for _, event := range eventList.Items {
+		involved := event.InvolvedObject
+		if event.Type != corev1.EventTypeWarning || involved.Kind != "Pod" || involved.Name != pod.Name ||
+			(pod.UID != "" && involved.UID != "" && involved.UID != pod.UID) {
+			continue
+		}
+		events = append(events, event)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].LastTimestamp.Before(&events[j].LastTimestamp)
+	})
+
+	reasons := []string{}
+	seen := map[string]bool{}
+	for _, event := range events {
+		reason := fmt.Sprintf("event on pod %s: %s: %s", pod.Name, event.Reason, event.Message)
+		if !seen[reason] {
+			seen[reason] = true
+			reasons = append(reasons, reason)
+		}
+	}
+	return reasons, nil
+}
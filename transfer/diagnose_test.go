@@ -0,0 +1,131 @@
+package transfer
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testDiagnosedPod(phase corev1.PodPhase, statuses ...corev1.ContainerStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar", UID: "123"},
+		Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+			Name: "mnt",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data"},
+			},
+		}}},
+		Status: corev1.PodStatus{Phase: phase, ContainerStatuses: statuses},
+	}
+}
+
+func testDiagnosedPVC(phase corev1.PersistentVolumeClaimPhase) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "bar"},
+		Status:     corev1.PersistentVolumeClaimStatus{Phase: phase},
+	}
+}
+
+func testEvent(name, eventType, reason string) *corev1.Event {
+	return &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: name, Namespace: "bar"},
+		InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "foo", Namespace: "bar", UID: "123"},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        "0/3 nodes are available",
+	}
+}
+
+func TestDiagnosePods(t *testing.T) {
+	unschedulable := testDiagnosedPod(corev1.PodPending)
+	unschedulable.Status.Conditions = []corev1.PodCondition{{
+		Type:    corev1.PodScheduled,
+		Status:  corev1.ConditionFalse,
+		Reason:  "Unschedulable",
+		Message: "0/3 nodes are available",
+	}}
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    []string
+	}{
+		{
+			name:    "running pod, must return no reason",
+			objects: []client.Object{testDiagnosedPod(corev1.PodRunning), testDiagnosedPVC(corev1.ClaimBound)},
+			want:    []string{},
+		},
+		{
+			name:    "missing pod, must be reported",
+			objects: []client.Object{},
+			want:    []string{"pod bar/foo not found"},
+		},
+		{
+			name: "unschedulable pod with an unbound PVC, must report the condition, the PVC and the warning events",
+			objects: []client.Object{
+				unschedulable,
+				testDiagnosedPVC(corev1.ClaimPending),
+				testEvent("scheduling", corev1.EventTypeWarning, "FailedScheduling"),
+				testEvent("scheduling-again", corev1.EventTypeWarning, "FailedScheduling"),
+				testEvent("normal", corev1.EventTypeNormal, "Scheduled"),
+			},
+			want: []string{
+				"pod bar/foo cannot be scheduled: Unschedulable: 0/3 nodes are available",
+				"PVC bar/data mounted by pod foo is not bound: Pending",
+				"event on pod foo: FailedScheduling: 0/3 nodes are available",
+			},
+		},
+		{
+			name: "pod failing to pull its image, must report the image",
+			objects: []client.Object{
+				testDiagnosedPod(corev1.PodPending, corev1.ContainerStatus{
+					Name:  "rsync",
+					Image: "quay.io/konveyor/rsync-transfer:latest",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "back-off pulling image"}},
+				}),
+				testDiagnosedPVC(corev1.ClaimBound),
+			},
+			want: []string{"container rsync of pod bar/foo cannot pull image quay.io/konveyor/rsync-transfer:latest: back-off pulling image"},
+		},
+		{
+			name: "crash looping running pod, must report the last exit code",
+			objects: []client.Object{
+				testDiagnosedPod(corev1.PodRunning, corev1.ContainerStatus{
+					Name:                 "rsync",
+					RestartCount:         3,
+					State:                corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+					LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 5, Reason: "Error"}},
+				}),
+				testDiagnosedPVC(corev1.ClaimBound),
+			},
+			want: []string{"container rsync of pod bar/foo is crash looping after 3 restarts, last exit code 5: Error"},
+		},
+		{
+			name: "pod creating its containers, must return no reason",
+			objects: []client.Object{
+				testDiagnosedPod(corev1.PodPending, corev1.ContainerStatus{
+					Name:  "rsync",
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+				}),
+				testDiagnosedPVC(corev1.ClaimBound),
+			},
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			got, err := DiagnosePods(context.Background(), c, client.ObjectKey{Namespace: "bar", Name: "foo"})
+			if err != nil {
+				t.Fatalf("DiagnosePods() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiagnosePods() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package transfer
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ConfigHashAnnotation is the hash of the ConfigMaps and Secrets mounted by a transfer pod when
+// it was created, see RestartOnDrift
+const ConfigHashAnnotation = "pvc-transfer.backube.dev/config-hash"
+
+// Reconciler is implemented by transfer servers and clients which can restore their resources,
+// e.g. after the rsyncd ConfigMap or the stunnel Secret was edited mid-transfer. Reconcile
+// restores the resources and restarts the pods whose ConfigMaps and Secrets changed since they
+// were created, the pods are recreated by the next call.
+type Reconciler interface {
+	Reconcile(ctx context.Context, c client.Client) error
+}
+
+// ConfigHash returns a hash of the data of the ConfigMaps and Secrets referenced by the volumes,
+// missing ConfigMaps and Secrets are hashed as such
+func ConfigHash(ctx context.Context, c client.Client, namespace string, volumes []corev1.Volume) (string, error) {
+	refs := map[string]client.Object{}
+	for _, volume := range volumes {
+		switch {
+		case volume.ConfigMap != nil:
+			refs["configmap/"+volume.ConfigMap.Name] = configMapRef(namespace, volume.ConfigMap.Name)
+		case volume.Secret != nil:
+			refs["secret/"+volume.Secret.SecretName] = secretRef(namespace, volume.Secret.SecretName)
+		case volume.Projected != nil:
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					refs["configmap/"+source.ConfigMap.Name] = configMapRef(namespace, source.ConfigMap.Name)
+				}
+				if source.Secret != nil {
+					refs["secret/"+source.Secret.Name] = secretRef(namespace, source.Secret.Name)
+				}
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(refs))
+	for key := range refs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	hash := sha256.New()
+	for _, key := range keys {
+		obj := refs[key]
+		err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		switch {
+		case k8serrors.IsNotFound(err):
+			fmt.Fprintf(hash, "%s missing\n", key)
+			continue
+		case err != nil:
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\n", key)
+		switch o := obj.(type) {
+		case *corev1.ConfigMap:
+			hashData(hash, o.Data, o.BinaryData)
+		case *corev1.Secret:
+			hashData(hash, o.StringData, o.Data)
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func configMapRef(namespace, name string) client.Object {
+	return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+}
+
+func secretRef(namespace, name string) client.Object {
+	return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+}
+
+// hashData writes the sorted keys and values of the data to the hash
+func hashData(hash io.Writer, data map[string]string, binaryData map[string][]byte) {
+	keys := make([]string, 0, len(data)+len(binaryData))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	for key := range binaryData {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, ok := data[key]
+		if !ok {
+			value = string(binaryData[key])
+		}
+		fmt.Fprintf(hash, "%s=%d:%s\n", key, len(value), value)
+	}
+}
+
+// PodConfigHash returns the value of ConfigHashAnnotation for the pod mounting the volumes. An
+// existing pod keeps the hash of the content it was created with, the hash of the current
+// content is returned for new pods and for pods created without the annotation.
+func PodConfigHash(ctx context.Context, c client.Client, key types.NamespacedName, volumes []corev1.Volume) (string, error) {
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, key, pod)
+	switch {
+	case err == nil && pod.Annotations[ConfigHashAnnotation] != "":
+		return pod.Annotations[ConfigHashAnnotation], nil
+	case err != nil && !k8serrors.IsNotFound(err):
+		return "", err
+	}
+	return ConfigHash(ctx, c, key.Namespace, volumes)
+}
+
+// RestartOnDrift deletes the pod when the ConfigMaps and Secrets it mounts changed since it was
+// created, according to its ConfigHashAnnotation, and returns whether the pod was deleted.
+// Terminated pods, pods being deleted and pods without the annotation are left untouched.
+func RestartOnDrift(ctx context.Context, c client.Client, logger logr.Logger, key types.NamespacedName) (bool, error) {
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, key, pod)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	want := pod.Annotations[ConfigHashAnnotation]
+	if want == "" || pod.DeletionTimestamp != nil ||
+		pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+		return false, nil
+	}
+	got, err := ConfigHash(ctx, c, key.Namespace, pod.Spec.Volumes)
+	if err != nil {
+		return false, err
+	}
+	if got == want {
+		return false, nil
+	}
+	logger.Info("configuration mounted by the pod drifted, restarting the pod", "pod", key)
+	err = c.Delete(ctx, pod)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+// WithConfigHash returns the annotations with ConfigHashAnnotation set to hash
+func WithConfigHash(annotations map[string]string, hash string) map[string]string {
+	merged := map[string]string{}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	merged[ConfigHashAnnotation] = hash
+	return merged
+}
@@ -0,0 +1,166 @@
+package transfer
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func driftVolumes() []corev1.Volume {
+	return []corev1.Volume{
+		{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{Name: "rsync-config"},
+				},
+			},
+		},
+		{
+			Name: "certs",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: "stunnel-creds"},
+			},
+		},
+	}
+}
+
+func driftConfigMap(conf string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-config", Namespace: "foo"},
+		Data:       map[string]string{"rsyncd.conf": conf},
+	}
+}
+
+func driftSecret(key string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "stunnel-creds", Namespace: "foo"},
+		Data:       map[string][]byte{"tls.key": []byte(key)},
+	}
+}
+
+func TestConfigHash(t *testing.T) {
+	hash := func(objs ...client.Object) string {
+		got, err := ConfigHash(context.Background(), fake.NewClientBuilder().WithObjects(objs...).Build(), "foo", driftVolumes())
+		if err != nil {
+			t.Fatalf("ConfigHash() error = %v", err)
+		}
+		return got
+	}
+	original := hash(driftConfigMap("port = 8080"), driftSecret("key"))
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		wantSame bool
+	}{
+		{
+			name:     "same content, must return the same hash",
+			objects:  []client.Object{driftConfigMap("port = 8080"), driftSecret("key")},
+			wantSame: true,
+		},
+		{
+			name:    "configmap edited, must return a different hash",
+			objects: []client.Object{driftConfigMap("port = 9090"), driftSecret("key")},
+		},
+		{
+			name:    "secret rotated, must return a different hash",
+			objects: []client.Object{driftConfigMap("port = 8080"), driftSecret("rotated")},
+		},
+		{
+			name:    "secret deleted, must return a different hash",
+			objects: []client.Object{driftConfigMap("port = 8080")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hash(tt.objects...); (got == original) != tt.wantSame {
+				t.Errorf("ConfigHash() = %v, original %v, wantSame %v", got, original, tt.wantSame)
+			}
+		})
+	}
+}
+
+func TestRestartOnDrift(t *testing.T) {
+	original, err := ConfigHash(context.Background(),
+		fake.NewClientBuilder().WithObjects(driftConfigMap("port = 8080"), driftSecret("key")).Build(), "foo", driftVolumes())
+	if err != nil {
+		t.Fatalf("ConfigHash() error = %v", err)
+	}
+	pod := func(annotations map[string]string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "rsync-server-foo", Namespace: "foo", Annotations: annotations},
+			Spec:       corev1.PodSpec{Volumes: driftVolumes()},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	tests := []struct {
+		name    string
+		objects []client.Object
+		want    bool
+		noPod   bool
+	}{
+		{
+			name:    "configuration unchanged, must not restart",
+			objects: []client.Object{pod(WithConfigHash(nil, original), corev1.PodRunning), driftConfigMap("port = 8080"), driftSecret("key")},
+		},
+		{
+			name:    "configmap edited, must restart",
+			objects: []client.Object{pod(WithConfigHash(nil, original), corev1.PodRunning), driftConfigMap("port = 9090"), driftSecret("key")},
+			want:    true,
+		},
+		{
+			name:    "pod without the annotation, must not restart",
+			objects: []client.Object{pod(nil, corev1.PodRunning), driftConfigMap("port = 9090"), driftSecret("key")},
+		},
+		{
+			name:    "pod succeeded, must not restart",
+			objects: []client.Object{pod(WithConfigHash(nil, original), corev1.PodSucceeded), driftConfigMap("port = 9090"), driftSecret("key")},
+		},
+		{
+			name:    "pod missing, must not restart",
+			objects: []client.Object{driftConfigMap("port = 9090"), driftSecret("key")},
+			noPod:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			key := types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}
+			got, err := RestartOnDrift(context.Background(), c, testr.New(t), key)
+			if err != nil {
+				t.Fatalf("RestartOnDrift() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("RestartOnDrift() = %v, want %v", got, tt.want)
+			}
+			err = c.Get(context.Background(), key, &corev1.Pod{})
+			if deleted := k8serrors.IsNotFound(err); deleted != (tt.want || tt.noPod) {
+				t.Errorf("RestartOnDrift() pod deleted = %v, want %v", deleted, tt.want)
+			}
+		})
+	}
+}
+
+func TestPodConfigHash(t *testing.T) {
+	key := types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}
+	c := fake.NewClientBuilder().WithObjects(driftConfigMap("port = 9090")).Build()
+	current, err := PodConfigHash(context.Background(), c, key, driftVolumes())
+	if err != nil || current == "" {
+		t.Fatalf("PodConfigHash() = %v, %v, want the hash of the current content", current, err)
+	}
+
+	c = fake.NewClientBuilder().WithObjects(driftConfigMap("port = 9090"), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: key.Name, Namespace: key.Namespace, Annotations: WithConfigHash(nil, "created")},
+	}).Build()
+	got, err := PodConfigHash(context.Background(), c, key, driftVolumes())
+	if err != nil || got != "created" {
+		t.Errorf("PodConfigHash() = %v, %v, want the hash the pod was created with", got, err)
+	}
+}
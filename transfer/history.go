@@ -0,0 +1,202 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// DefaultHistoryLimit is the number of iterations kept by histories without a limit
+	DefaultHistoryLimit = 10
+	// historyKey is the key of the ConfigMap data holding the history
+	historyKey = "history"
+)
+
+// IterationRecord summarizes a sync iteration of a transfer, e.g. for the status of custom resources
+type IterationRecord struct {
+	StartedAt        *metav1.Time `json:"startedAt,omitempty"`
+	FinishedAt       *metav1.Time `json:"finishedAt,omitempty"`
+	ExitCode         int32        `json:"exitCode"`
+	Reason           string       `json:"reason,omitempty"`
+	FilesTransferred int64        `json:"filesTransferred"`
+	BytesTransferred int64        `json:"bytesTransferred"`
+	// BytesSent and BytesReceived are the bytes exchanged on the wire, zero when the transfer
+	// does not report them
+	BytesSent     int64 `json:"bytesSent,omitempty"`
+	BytesReceived int64 `json:"bytesReceived,omitempty"`
+	// BandwidthLimit in KiB/s and CompressionLevel are the tuning of the transfer during the
+	// iteration, zero when it was not limited or compressed
+	BandwidthLimit   int `json:"bandwidthLimit,omitempty"`
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+}
+
+// NewIterationRecord returns the record of the iteration of a completed transfer, ok is false
+// when the transfer is not completed
+func NewIterationRecord(status *Status) (record IterationRecord, ok bool) {
+	if status == nil || status.Completed == nil {
+		return IterationRecord{}, false
+	}
+	record = IterationRecord{
+		StartedAt:        status.Completed.StartedAt,
+		FinishedAt:       status.Completed.FinishedAt,
+		ExitCode:         status.Completed.ExitCode,
+		Reason:           status.Completed.Reason,
+		FilesTransferred: status.Completed.FilesTransferred,
+		BytesTransferred: status.Completed.BytesTransferred,
+	}
+	if status.Completed.Stats != nil {
+		record.BytesSent = status.Completed.Stats.BytesSent
+		record.BytesReceived = status.Completed.Stats.BytesReceived
+	}
+	return record, true
+}
+
+// sameIteration returns whether both records are of the same iteration
+func (r IterationRecord) sameIteration(other IterationRecord) bool {
+	return r.StartedAt.Equal(other.StartedAt) && r.FinishedAt.Equal(other.FinishedAt)
+}
+
+// History records the iterations of a transfer
+type History interface {
+	// Add records an iteration. Adding the last recorded iteration again is a no-op, callers
+	// can record the status of a completed transfer every time they observe it
+	Add(ctx context.Context, record IterationRecord) error
+	// List returns the recorded iterations from the oldest to the newest
+	List(ctx context.Context) ([]IterationRecord, error)
+}
+
+// appendRecord appends record to records unless it is the last record, keeping at most limit records
+func appendRecord(records []IterationRecord, record IterationRecord, limit int) ([]IterationRecord, bool) {
+	if len(records) > 0 && records[len(records)-1].sameIteration(record) {
+		return records, false
+	}
+	if limit <= 0 {
+		limit = DefaultHistoryLimit
+	}
+	records = append(records, record)
+	if len(records) > limit {
+		records = records[len(records)-limit:]
+	}
+	return records, true
+}
+
+// MemoryHistory keeps the history in memory. It can be serialized to JSON, e.g. to persist it
+// in the status of a custom resource and restore it on the next reconcile.
+type MemoryHistory struct {
+	// Limit is the number of iterations kept, defaults to DefaultHistoryLimit
+	Limit int
+
+	mutex   sync.Mutex
+	records []IterationRecord
+}
+
+// Add implements History
+func (h *MemoryHistory) Add(ctx context.Context, record IterationRecord) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.records, _ = appendRecord(h.records, record, h.Limit)
+	return nil
+}
+
+// List implements History
+func (h *MemoryHistory) List(ctx context.Context) ([]IterationRecord, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]IterationRecord{}, h.records...), nil
+}
+
+// MarshalJSON serializes the recorded iterations as a JSON array
+func (h *MemoryHistory) MarshalJSON() ([]byte, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.records == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(h.records)
+}
+
+// UnmarshalJSON restores the recorded iterations from a JSON array
+func (h *MemoryHistory) UnmarshalJSON(data []byte) error {
+	records := []IterationRecord{}
+	err := json.Unmarshal(data, &records)
+	if err != nil {
+		return err
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.records = records
+	return nil
+}
+
+// ConfigMapHistory persists the history as JSON in a ConfigMap
+type ConfigMapHistory struct {
+	Client client.Client
+	Logger logr.Logger
+	// Key is the namespaced name of the ConfigMap
+	Key types.NamespacedName
+	// Labels and OwnerReferences are applied to the ConfigMap
+	Labels          map[string]string
+	OwnerReferences []metav1.OwnerReference
+	// Limit is the number of iterations kept, defaults to DefaultHistoryLimit
+	Limit int
+}
+
+// Add implements History
+func (h *ConfigMapHistory) Add(ctx context.Context, record IterationRecord) error {
+	records, err := h.List(ctx)
+	if err != nil {
+		return err
+	}
+	records, added := appendRecord(records, record, h.Limit)
+	if !added {
+		return nil
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      h.Key.Name,
+			Namespace: h.Key.Namespace,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, h.Client, h.Logger, cm, reconcile.Options{}, func() error {
+		cm.Labels = h.Labels
+		cm.OwnerReferences = h.OwnerReferences
+		cm.Data = map[string]string{historyKey: string(data)}
+		return nil
+	})
+	return err
+}
+
+// List implements History, the history is empty while the ConfigMap does not exist
+func (h *ConfigMapHistory) List(ctx context.Context) ([]IterationRecord, error) {
+	cm := &corev1.ConfigMap{}
+	err := h.Client.Get(ctx, h.Key, cm)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return []IterationRecord{}, nil
+	case err != nil:
+		return nil, err
+	}
+	records := []IterationRecord{}
+	if cm.Data[historyKey] == "" {
+		return records, nil
+	}
+	err = json.Unmarshal([]byte(cm.Data[historyKey]), &records)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
@@ -0,0 +1,127 @@
+package transfer
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testRecord(i int) IterationRecord {
+	started := metav1.NewTime(time.Date(2021, 11, 1, i, 0, 0, 0, time.UTC))
+	finished := metav1.NewTime(started.Add(time.Minute))
+	return IterationRecord{
+		StartedAt:        &started,
+		FinishedAt:       &finished,
+		FilesTransferred: int64(i),
+		BytesTransferred: int64(i * 1024),
+	}
+}
+
+func TestNewIterationRecord(t *testing.T) {
+	record := testRecord(1)
+	tests := []struct {
+		name   string
+		status *Status
+		want   IterationRecord
+		wantOk bool
+	}{
+		{
+			name:   "running transfer, must not be recorded",
+			status: &Status{Running: &Running{}},
+		},
+		{
+			name: "completed transfer, must be recorded",
+			status: &Status{Completed: &Completed{
+				Failure:          true,
+				StartedAt:        record.StartedAt,
+				FinishedAt:       record.FinishedAt,
+				ExitCode:         23,
+				FilesTransferred: 1,
+				BytesTransferred: 1024,
+			}},
+			want:   IterationRecord{StartedAt: record.StartedAt, FinishedAt: record.FinishedAt, ExitCode: 23, FilesTransferred: 1, BytesTransferred: 1024},
+			wantOk: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := NewIterationRecord(tt.status)
+			if ok != tt.wantOk || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("NewIterationRecord() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestHistory(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	histories := map[string]func() History{
+		"memory": func() History { return &MemoryHistory{Limit: 2} },
+		"configmap": func() History {
+			return &ConfigMapHistory{
+				Client: fake.NewClientBuilder().WithScheme(scheme).Build(),
+				Logger: testr.New(t),
+				Key:    types.NamespacedName{Namespace: "foo", Name: "history"},
+				Limit:  2,
+			}
+		},
+	}
+	for name, newHistory := range histories {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			h := newHistory()
+			for _, record := range []IterationRecord{testRecord(1), testRecord(1), testRecord(2), testRecord(3)} {
+				if err := h.Add(ctx, record); err != nil {
+					t.Fatalf("Add() error = %v", err)
+				}
+			}
+			got, err := h.List(ctx)
+			if err != nil {
+				t.Fatalf("List() error = %v", err)
+			}
+			want := []IterationRecord{testRecord(2), testRecord(3)}
+			if len(got) != len(want) {
+				t.Fatalf("List() = %v, want %v", got, want)
+			}
+			for i := range want {
+				if !got[i].sameIteration(want[i]) || got[i].BytesTransferred != want[i].BytesTransferred {
+					t.Errorf("List()[%d] = %v, want %v", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryHistory_JSON(t *testing.T) {
+	ctx := context.Background()
+	h := &MemoryHistory{}
+	data, err := json.Marshal(h)
+	if err != nil || string(data) != "[]" {
+		t.Fatalf("Marshal() = %s, %v, want an empty array", data, err)
+	}
+	_ = h.Add(ctx, testRecord(1))
+	data, err = json.Marshal(h)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+
+	restored := &MemoryHistory{}
+	err = json.Unmarshal(data, restored)
+	if err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	records, _ := restored.List(ctx)
+	if len(records) != 1 || !records[0].sameIteration(testRecord(1)) {
+		t.Errorf("restored history = %v, want %v", records, []IterationRecord{testRecord(1)})
+	}
+}
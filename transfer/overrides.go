@@ -0,0 +1,104 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrPVCOverrideInvalid is returned when a key of PodOptions.PVCOverrides is not the
+// namespace/name of a PVC
+var ErrPVCOverrideInvalid = errors.New("PVC override invalid")
+
+// PodOptionsOverride overrides the PodOptions of the transfer pod of a single PVC, unset
+// fields keep the value of the PodOptions
+type PodOptionsOverride struct {
+	// Resources replace the resources of the PodOptions
+	Resources *corev1.ResourceRequirements
+	// NodeName replaces the node name of the PodOptions
+	NodeName string
+	// NodeSelector is merged over the node selector of the PodOptions
+	NodeSelector map[string]string
+	// Tolerations are added to the tolerations of the PodOptions
+	Tolerations []corev1.Toleration
+	// Affinity replaces the affinity of the PodOptions
+	Affinity *corev1.Affinity
+	// PriorityClassName replaces the priority class of the PodOptions
+	PriorityClassName string
+	// PodLabels are merged over the pod labels of the PodOptions
+	PodLabels map[string]string
+	// PodAnnotations are merged over the pod annotations of the PodOptions
+	PodAnnotations map[string]string
+	// Env is added to the environment of the PodOptions, it takes precedence
+	Env []corev1.EnvVar
+}
+
+// PVCOverrideKey returns the key of the overrides of a PVC in PodOptions.PVCOverrides
+func PVCOverrideKey(pvc PVC) string {
+	return client.ObjectKeyFromObject(pvc.Claim()).String()
+}
+
+// ValidatePVCOverrides returns an error wrapping ErrPVCOverrideInvalid when a key of the
+// PVCOverrides of the pod options is not the namespace/name of a PVC
+func (p PodOptions) ValidatePVCOverrides() error {
+	var errs []error
+	for key := range p.PVCOverrides {
+		parts := strings.Split(key, string(types.Separator))
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("%w: key %q must be namespace/name", ErrPVCOverrideInvalid, key))
+		}
+	}
+	return errorsutil.NewAggregate(errs)
+}
+
+// ForPVC returns the pod options of the transfer pod of a single PVC, the PVCOverrides of
+// the PVC merged over the pod options. The pod options are not modified.
+func (p PodOptions) ForPVC(pvc PVC) PodOptions {
+	override, ok := p.PVCOverrides[PVCOverrideKey(pvc)]
+	if !ok {
+		return p
+	}
+	if override.Resources != nil {
+		p.Resources = *override.Resources.DeepCopy()
+	}
+	if override.NodeName != "" {
+		p.NodeName = override.NodeName
+	}
+	p.NodeSelector = mergeStrings(p.NodeSelector, override.NodeSelector)
+	if len(override.Tolerations) > 0 {
+		p.Tolerations = append(append([]corev1.Toleration{}, p.Tolerations...), override.Tolerations...)
+	}
+	if override.Affinity != nil {
+		p.Affinity = override.Affinity.DeepCopy()
+	}
+	if override.PriorityClassName != "" {
+		p.PriorityClassName = override.PriorityClassName
+	}
+	p.PodLabels = mergeStrings(p.PodLabels, override.PodLabels)
+	p.PodAnnotations = mergeStrings(p.PodAnnotations, override.PodAnnotations)
+	if len(override.Env) > 0 {
+		p.Env = append(append([]corev1.EnvVar{}, p.Env...), override.Env...)
+	}
+	return p
+}
+
+// mergeStrings returns a copy of base with overrides merged over it, base is returned when
+// there are no overrides
+func mergeStrings(base, overrides map[string]string) map[string]string {
+	if len(overrides) == 0 {
+		return base
+	}
+	merged := map[string]string{}
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,85 @@
+package transfer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodOptions_ForPVC(t *testing.T) {
+	large := NewSingletonPVC(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "large", Namespace: "foo"}}).PVCs()[0]
+	small := NewSingletonPVC(&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "small", Namespace: "foo"}}).PVCs()[0]
+	largeResources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	options := PodOptions{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+		},
+		NodeSelector:   map[string]string{"node-role": "migration"},
+		PodAnnotations: map[string]string{"team": "storage"},
+		Env:            []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy"}},
+		PVCOverrides: map[string]PodOptionsOverride{
+			"foo/large": {
+				Resources:         &largeResources,
+				NodeSelector:      map[string]string{"disk": "fast"},
+				PriorityClassName: "migration-high",
+				PodAnnotations:    map[string]string{"size": "2Ti"},
+				Env:               []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://large-proxy"}},
+			},
+		},
+	}
+
+	got := options.ForPVC(large)
+	if !reflect.DeepEqual(got.Resources, largeResources) {
+		t.Errorf("ForPVC() resources = %v, want %v", got.Resources, largeResources)
+	}
+	if want := map[string]string{"node-role": "migration", "disk": "fast"}; !reflect.DeepEqual(got.NodeSelector, want) {
+		t.Errorf("ForPVC() node selector = %v, want %v", got.NodeSelector, want)
+	}
+	if want := map[string]string{"team": "storage", "size": "2Ti"}; !reflect.DeepEqual(got.PodAnnotations, want) {
+		t.Errorf("ForPVC() pod annotations = %v, want %v", got.PodAnnotations, want)
+	}
+	if got.PriorityClassName != "migration-high" {
+		t.Errorf("ForPVC() priority class = %s, want migration-high", got.PriorityClassName)
+	}
+	if len(got.Env) != 2 || got.Env[1].Value != "http://large-proxy" {
+		t.Errorf("ForPVC() env = %v, want the env of the override last", got.Env)
+	}
+	if len(options.NodeSelector) != 1 || len(options.PodAnnotations) != 1 || len(options.Env) != 1 {
+		t.Errorf("ForPVC() modified the pod options %v", options)
+	}
+
+	if got := options.ForPVC(small); !reflect.DeepEqual(got, options) {
+		t.Errorf("ForPVC() = %v, want the pod options of PVCs without overrides", got)
+	}
+}
+
+func TestPodOptions_ValidatePVCOverrides(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "namespace/name, must be valid", key: "foo/large"},
+		{name: "name only, must return an error", key: "large", wantErr: true},
+		{name: "empty namespace, must return an error", key: "/large", wantErr: true},
+		{name: "too many segments, must return an error", key: "foo/large/data", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := PodOptions{PVCOverrides: map[string]PodOptionsOverride{tt.key: {}}}
+			err := options.ValidatePVCOverrides()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidatePVCOverrides() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrPVCOverrideInvalid) {
+				t.Errorf("ValidatePVCOverrides() error = %v, want ErrPVCOverrideInvalid", err)
+			}
+		})
+	}
+}
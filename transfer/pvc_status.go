@@ -0,0 +1,63 @@
+package transfer
+
+import (
+	"sort"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// PVCStatus is the status of the transfer of a single PVC, see Status.PVCs. Both fields are
+// nil while the transfer of the PVC did not start yet.
+type PVCStatus struct {
+	Running   *Running
+	Completed *Completed
+	// LogTail is the tail of the logs of the transfer pod of the PVC, it is not set by transfer
+	// clients but by callers reading the logs, such as plans tailing the logs of failed PVCs
+	LogTail string
+}
+
+// StatusSummary rolls up the status of the transfer of each PVC, see Status.Summary
+type StatusSummary struct {
+	// Total is the number of PVCs of the transfer
+	Total int
+	// Pending, Running, Succeeded and Failed are the number of PVCs in each state
+	Pending   int
+	Running   int
+	Succeeded int
+	Failed    int
+	// AllCompleted is set once the transfer of every PVC completed, successfully or not
+	AllCompleted bool
+	// AnyFailed is set when the transfer of at least one PVC failed
+	AnyFailed bool
+	// FailedPVCs are the PVCs whose transfer failed, sorted by namespace and name
+	FailedPVCs []types.NamespacedName
+}
+
+// Summary returns the roll-up of the status of the transfer of each PVC. The summary is empty
+// when the transfer does not report the status of each PVC.
+func (s *Status) Summary() StatusSummary {
+	summary := StatusSummary{FailedPVCs: []types.NamespacedName{}}
+	if s == nil {
+		return summary
+	}
+	for key, pvcStatus := range s.PVCs {
+		summary.Total++
+		switch {
+		case pvcStatus == nil || (pvcStatus.Running == nil && pvcStatus.Completed == nil):
+			summary.Pending++
+		case pvcStatus.Completed == nil:
+			summary.Running++
+		case pvcStatus.Completed.Successful:
+			summary.Succeeded++
+		default:
+			summary.Failed++
+			summary.FailedPVCs = append(summary.FailedPVCs, key)
+		}
+	}
+	sort.Slice(summary.FailedPVCs, func(i, j int) bool {
+		return summary.FailedPVCs[i].String() < summary.FailedPVCs[j].String()
+	})
+	summary.AllCompleted = summary.Total > 0 && summary.Succeeded+summary.Failed == summary.Total
+	summary.AnyFailed = summary.Failed > 0
+	return summary
+}
@@ -0,0 +1,58 @@
+package transfer
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestStatus_Summary(t *testing.T) {
+	data := types.NamespacedName{Namespace: "foo", Name: "data"}
+	logs := types.NamespacedName{Namespace: "foo", Name: "logs"}
+	db := types.NamespacedName{Namespace: "bar", Name: "db"}
+	tests := []struct {
+		name   string
+		status *Status
+		want   StatusSummary
+	}{
+		{
+			name:   "nil status, must return an empty summary",
+			status: nil,
+			want:   StatusSummary{FailedPVCs: []types.NamespacedName{}},
+		},
+		{
+			name:   "status without PVCs, must return an empty summary",
+			status: &Status{Completed: &Completed{Successful: true}},
+			want:   StatusSummary{FailedPVCs: []types.NamespacedName{}},
+		},
+		{
+			name: "all PVCs succeeded, must be all completed",
+			status: &Status{PVCs: map[types.NamespacedName]*PVCStatus{
+				data: {Completed: &Completed{Successful: true}},
+				logs: {Completed: &Completed{Successful: true}},
+			}},
+			want: StatusSummary{Total: 2, Succeeded: 2, AllCompleted: true, FailedPVCs: []types.NamespacedName{}},
+		},
+		{
+			name: "PVCs pending, running and failed, must list the failed PVCs sorted",
+			status: &Status{PVCs: map[types.NamespacedName]*PVCStatus{
+				data:                              {Completed: &Completed{Failure: true}},
+				logs:                              {Running: &Running{}},
+				db:                                {Completed: &Completed{Failure: true}},
+				{Namespace: "foo", Name: "cache"}: {},
+			}},
+			want: StatusSummary{
+				Total: 4, Pending: 1, Running: 1, Failed: 2, AnyFailed: true,
+				FailedPVCs: []types.NamespacedName{db, data},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.status.Summary(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Summary() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
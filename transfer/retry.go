@@ -0,0 +1,70 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+)
+
+// ErrRetryPolicyInvalid is returned when a RetryPolicy cannot be rendered into transfer pods
+var ErrRetryPolicyInvalid = errors.New("retry policy invalid")
+
+// RetryPolicy determines how transfer containers retry failed attempts, delays grow
+// exponentially from InitialDelaySeconds by Factor up to MaxDelaySeconds
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first failed attempt, 0 disables retries
+	MaxRetries int
+	// InitialDelaySeconds is the delay before the first retry
+	InitialDelaySeconds int
+	// Factor multiplies the delay after every retry, 1 retries at a constant interval
+	Factor int
+	// MaxDelaySeconds caps the delay between retries, 0 does not cap it
+	MaxDelaySeconds int
+	// RetryOnExitCodes restricts retries to the given exit codes, all failures are retried when empty
+	RetryOnExitCodes []int32
+}
+
+// DefaultRetryPolicy is used by transfers without a RetryPolicy in their PodOptions
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:          4,
+		InitialDelaySeconds: 2,
+		Factor:              2,
+		MaxDelaySeconds:     60,
+	}
+}
+
+// Validate returns an error wrapping ErrRetryPolicyInvalid when a field of the policy is out of range
+func (p RetryPolicy) Validate() error {
+	var errs []error
+	if p.MaxRetries < 0 {
+		errs = append(errs, fmt.Errorf("%w: max retries must not be negative", ErrRetryPolicyInvalid))
+	}
+	if p.InitialDelaySeconds < 0 {
+		errs = append(errs, fmt.Errorf("%w: initial delay must not be negative", ErrRetryPolicyInvalid))
+	}
+	if p.Factor < 1 {
+		errs = append(errs, fmt.Errorf("%w: factor must be at least 1", ErrRetryPolicyInvalid))
+	}
+	if p.MaxDelaySeconds < 0 {
+		errs = append(errs, fmt.Errorf("%w: max delay must not be negative", ErrRetryPolicyInvalid))
+	}
+	if p.MaxDelaySeconds > 0 && p.MaxDelaySeconds < p.InitialDelaySeconds {
+		errs = append(errs, fmt.Errorf("%w: max delay must not be lower than the initial delay", ErrRetryPolicyInvalid))
+	}
+	for _, code := range p.RetryOnExitCodes {
+		if code < 1 || code > 255 {
+			errs = append(errs, fmt.Errorf("%w: exit code %d out of range 1-255", ErrRetryPolicyInvalid, code))
+		}
+	}
+	return errorsutil.NewAggregate(errs)
+}
+
+// GetRetryPolicy returns the retry policy of the pod options or DefaultRetryPolicy when unset
+func (p PodOptions) GetRetryPolicy() RetryPolicy {
+	if p.RetryPolicy != nil {
+		return *p.RetryPolicy
+	}
+	return DefaultRetryPolicy()
+}
@@ -0,0 +1,58 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRetryPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		wantErr bool
+	}{
+		{
+			name:   "default policy, must be valid",
+			policy: DefaultRetryPolicy(),
+		},
+		{
+			name:   "no retries, must be valid",
+			policy: RetryPolicy{Factor: 1},
+		},
+		{
+			name:   "retry on exit codes, must be valid",
+			policy: RetryPolicy{MaxRetries: 3, InitialDelaySeconds: 5, Factor: 1, RetryOnExitCodes: []int32{12, 30}},
+		},
+		{
+			name:    "zero factor, must return an error",
+			policy:  RetryPolicy{MaxRetries: 3, InitialDelaySeconds: 5},
+			wantErr: true,
+		},
+		{
+			name:    "negative retries, must return an error",
+			policy:  RetryPolicy{MaxRetries: -1, Factor: 2},
+			wantErr: true,
+		},
+		{
+			name:    "max delay lower than the initial delay, must return an error",
+			policy:  RetryPolicy{MaxRetries: 3, InitialDelaySeconds: 10, Factor: 2, MaxDelaySeconds: 5},
+			wantErr: true,
+		},
+		{
+			name:    "exit code out of range, must return an error",
+			policy:  RetryPolicy{MaxRetries: 3, Factor: 2, RetryOnExitCodes: []int32{0}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrRetryPolicyInvalid) {
+				t.Errorf("Validate() error = %v, want ErrRetryPolicyInvalid", err)
+			}
+		})
+	}
+}
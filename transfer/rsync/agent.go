@@ -0,0 +1,110 @@
+package rsync
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	"github.com/backube/pvc-transfer/transport"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// agentWaitTimeoutSeconds is how long client agents wait for the transport, as the client script
+	agentWaitTimeoutSeconds = 120
+	terminationFile         = "/mnt/termination/done"
+	terminationMessagePath  = "/dev/termination-log"
+)
+
+// getAgentEnv returns the environment of rsync containers run by the agent with config
+func getAgentEnv(config interface{}) ([]corev1.EnvVar, error) {
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	return []corev1.EnvVar{{Name: agent.ConfigEnv, Value: string(encoded)}}, nil
+}
+
+// pvcTerminationFile returns the termination file the client pod of a PVC sends to the server
+// once its transfer completed, servers stop once they received the file of each of their PVCs
+func pvcTerminationFile(pvc transfer.PVC) string {
+	return fmt.Sprintf("%s-%s", terminationFile, pvc.LabelSafeName())
+}
+
+// getAgentCommand returns the command and environment of the rsync client container in agent
+// mode, it runs the same steps as the script returned by getCommand
+func (tc *client) getAgentCommand(rsyncOptions []string, pvc transfer.PVC) ([]string, []corev1.EnvVar, error) {
+	connection := tc.Transport().ConnectionInfo()
+	rsyncCommand := []string{getRsyncBinary(tc.options)}
+	rsyncCommand = append(rsyncCommand, rsyncOptions...)
+	rsyncCommand = append(rsyncCommand,
+		getLocalPath(tc.options, fmt.Sprintf("/mnt/%s/%s/", pvc.Claim().Namespace, pvc.LabelSafeName())))
+	terminationCommand := []string{getRsyncBinary(tc.options), getLocalPath(tc.options, pvcTerminationFile(pvc))}
+	if tc.mode == ModeSSH {
+		sshCommand := getSSHCommand(connection.Port)
+		rsyncCommand = append(rsyncCommand, "-e", sshCommand,
+			fmt.Sprintf("%s@%s:%s/%s/", tc.username, connection.Hostname, sshDataMountPath, pvc.LabelSafeName()))
+		terminationCommand = append(strings.Fields(sshCommand),
+			fmt.Sprintf("%s@%s", tc.username, connection.Hostname), "touch", pvcTerminationFile(pvc))
+	} else {
+		rsyncCommand = append(rsyncCommand, strings.Fields(getRsyncURL(tc.username, connection, pvc.LabelSafeName()))...)
+		terminationCommand = append(terminationCommand, strings.Fields(getRsyncURL(tc.username, connection, "termination"))...)
+	}
+	if tc.pull {
+		rsyncCommand = tc.getPullCommand(rsyncOptions, pvc)
+	}
+	if tc.fanOutClient != "" {
+		// fan-out servers keep serving other clients
+		rsyncCommand = tc.getFanOutCommand(rsyncOptions, pvc)
+		terminationCommand = nil
+	}
+	doneFile := fmt.Sprintf("%s/rsync-client-container-done", rsyncCommunicationMountPath)
+	if terminatesOnCompletion(tc.Transport()) {
+		doneFile = transport.CompletionFile
+	}
+
+	env, err := getAgentEnv(agent.ClientConfig{
+		Address:                net.JoinHostPort(connection.Hostname, strconv.Itoa(int(connection.Port))),
+		WaitTimeoutSeconds:     agentWaitTimeoutSeconds,
+		Command:                rsyncCommand,
+		RetryPolicy:            getRetryPolicy(tc.options),
+		SuccessExitCodes:       getSuccessExitCodes(tc.successExitCodes),
+		TerminationCommand:     terminationCommand,
+		TerminationFile:        pvcTerminationFile(pvc),
+		DoneFile:               doneFile,
+		TerminationMessagePath: terminationMessagePath,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return []string{getAgentPath(tc.options), agent.RoleClient}, env, nil
+}
+
+// getAgentCommand returns the command and environment of the rsync server container in agent
+// mode, it runs the same steps as the script of getContainers
+func (s *server) getAgentCommand() ([]string, []corev1.EnvVar) {
+	config := agent.ServerConfig{
+		Command: []string{getRsyncBinary(s.options), "--daemon", fmt.Sprintf("--port=%d", s.ListenPort()), "--no-detach", "-vvv"},
+	}
+	if s.options.OS == transfer.OSWindows {
+		config.Command = append(config.Command, fmt.Sprintf("--config=%s", getLocalPath(s.options, "/etc/rsyncd.conf")))
+	}
+	if s.mode == ModeSSH {
+		config.Command = []string{"/usr/sbin/sshd", "-D", "-e", "-f", "/etc/ssh/sshd_config"}
+	}
+	if s.terminateOnCompletion {
+		for _, pvc := range s.pvcList.PVCs() {
+			config.TerminationFiles = append(config.TerminationFiles, pvcTerminationFile(pvc))
+		}
+		if terminatesOnCompletion(s.Transport()) {
+			config.CompletionFile = transport.CompletionFile
+		}
+	}
+	// the configuration only holds strings and cannot fail to encode
+	env, _ := getAgentEnv(config)
+	return []string{getAgentPath(s.options), agent.RoleServer}, env
+}
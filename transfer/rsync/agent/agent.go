@@ -0,0 +1,177 @@
+// Package agent orchestrates the rsync transfer containers in place of the bash scripts
+// rendered by the rsync transfer. The rsync-agent binary built from cmd/rsync-agent runs it
+// in the rsync image, rsync clients and servers configure it with the JSON encoded
+// configuration of the ConfigEnv environment variable.
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+const (
+	// ConfigEnv is the environment variable holding the JSON encoded configuration of the agent
+	ConfigEnv = "RSYNC_AGENT_CONFIG"
+	// Path is where the rsync-agent binary is installed in the rsync image
+	Path = "/usr/local/bin/rsync-agent"
+	// WindowsPath is where the rsync-agent binary is installed in Windows rsync images
+	WindowsPath = `C:\rsync-agent\rsync-agent.exe`
+	// RoleClient and RoleServer are the arguments selecting the role of the agent
+	RoleClient = "client"
+	RoleServer = "server"
+)
+
+// ErrConfigInvalid is returned when the configuration of the agent cannot be decoded or is incomplete
+var ErrConfigInvalid = errors.New("agent configuration invalid")
+
+// ClientConfig configures the agent of rsync client containers
+type ClientConfig struct {
+	// Address is the host:port of the transport the agent waits for before running Command
+	Address string `json:"address"`
+	// WaitTimeoutSeconds is how long the agent waits for Address to accept connections
+	WaitTimeoutSeconds int `json:"waitTimeoutSeconds"`
+	// Command is the rsync command synchronizing the data, --stats is appended to it
+	Command []string `json:"command"`
+	// RetryPolicy determines how failed runs of Command are retried
+	RetryPolicy transfer.RetryPolicy `json:"retryPolicy"`
+	// SuccessExitCodes are the exit codes of Command treated as a success on top of 0, the
+	// exit code is then written to the termination message and the agent exits successfully
+	SuccessExitCodes []int32 `json:"successExitCodes,omitempty"`
+	// TerminationCommand notifies the server once Command succeeded
+	TerminationCommand []string `json:"terminationCommand"`
+	// TerminationFile is created before the transfer, TerminationCommand sends it to the server
+	TerminationFile string `json:"terminationFile"`
+	// DoneFile is created when the agent exits, whether the transfer succeeded or not
+	DoneFile string `json:"doneFile"`
+	// TerminationMessagePath receives the statistics of the last run of Command
+	TerminationMessagePath string `json:"terminationMessagePath"`
+}
+
+// ServerConfig configures the agent of rsync server containers
+type ServerConfig struct {
+	// Command runs the rsync daemon or the SSH server in the foreground
+	Command []string `json:"command"`
+	// TerminationFiles are sent by clients once the transfer of each PVC completed, the agent
+	// then stops Command and exits successfully. The agent runs Command until it exits when empty.
+	TerminationFiles []string `json:"terminationFiles"`
+	// CompletionFile is created once all the TerminationFiles exist, see transport.CompletionFile
+	CompletionFile string `json:"completionFile,omitempty"`
+}
+
+// Agent runs the client and server roles, its functions are replaced in tests
+type Agent struct {
+	// Out receives the logs of the agent and the output of the commands it runs
+	Out io.Writer
+	// Exec runs command, copying its standard output to stdout, and returns its exit code. The
+	// error is only set when command could not be run.
+	Exec func(ctx context.Context, command []string, stdout io.Writer) (int, error)
+	// Dial returns nil once address accepts connections
+	Dial func(ctx context.Context, address string) error
+	// Sleep waits for d or until ctx is done
+	Sleep func(ctx context.Context, d time.Duration) error
+	// PollInterval is the interval at which the agent checks files and addresses
+	PollInterval time.Duration
+}
+
+// New returns an agent running commands with os/exec and logging to the standard output
+func New() *Agent {
+	return &Agent{
+		Out:          os.Stdout,
+		Exec:         execCommand,
+		Dial:         dial,
+		Sleep:        sleep,
+		PollInterval: time.Second,
+	}
+}
+
+// ConfigFromEnv decodes the configuration of the ConfigEnv environment variable into config
+func ConfigFromEnv(getenv func(string) string, config interface{}) error {
+	value := getenv(ConfigEnv)
+	if value == "" {
+		return fmt.Errorf("%w: %s is not set", ErrConfigInvalid, ConfigEnv)
+	}
+	if err := json.Unmarshal([]byte(value), config); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+	return nil
+}
+
+func (a *Agent) logf(format string, args ...interface{}) {
+	fmt.Fprintf(a.Out, format+"\n", args...)
+}
+
+// touch creates the file at path if it does not exist
+func touch(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// sync flushes the file system buffers, Windows does not have the sync command and flushes
+// files as they are closed
+func (a *Agent) sync(ctx context.Context) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if _, err := a.Exec(ctx, []string{"sync"}, a.Out); err != nil {
+		a.logf("unable to sync: %v", err)
+	}
+}
+
+func execCommand(ctx context.Context, command []string, stdout io.Writer) (int, error) {
+	if len(command) == 0 {
+		return 0, fmt.Errorf("%w: empty command", ErrConfigInvalid)
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+func dial(ctx context.Context, address string) error {
+	dialer := net.Dialer{Timeout: time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
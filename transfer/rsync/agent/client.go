@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ExitCodeField is the field of the termination message holding the exit code of the last
+// run of the rsync command, next to the fields written by FormatStats
+const ExitCodeField = "rsync_exit_code"
+
+// RunClient waits for the transport, runs the rsync command with the retry policy of the
+// configuration and notifies the server once it succeeded. It returns the exit code of the
+// client container.
+func (a *Agent) RunClient(ctx context.Context, config ClientConfig) (int, error) {
+	if len(config.Command) == 0 {
+		return 1, fmt.Errorf("%w: client command is empty", ErrConfigInvalid)
+	}
+	if err := config.RetryPolicy.Validate(); err != nil {
+		return 1, err
+	}
+	// transports stop once the done file exists, the transfer succeeded or not
+	defer func() {
+		if err := touch(config.DoneFile); err != nil {
+			a.logf("unable to create %s: %v", config.DoneFile, err)
+		}
+	}()
+	if err := touch(config.TerminationFile); err != nil {
+		return 1, err
+	}
+
+	start := time.Now()
+	if err := a.waitFor(ctx, config.Address, time.Duration(config.WaitTimeoutSeconds)*time.Second); err != nil {
+		a.logf("Transport not reachable at %s: %v", config.Address, err)
+		return 1, nil
+	}
+	rc, stats, err := a.runWithRetries(ctx, config)
+	if err != nil {
+		return 1, err
+	}
+	a.logf("Rsync completed in %ds", int(time.Since(start).Seconds()))
+	if config.TerminationMessagePath != "" {
+		message := FormatStats(stats) + fmt.Sprintf("%s=%d\n", ExitCodeField, rc)
+		err = ioutil.WriteFile(config.TerminationMessagePath, []byte(message), 0644)
+		if err != nil {
+			a.logf("unable to write the termination message: %v", err)
+		}
+	}
+	a.sync(ctx)
+	if !succeeded(config.SuccessExitCodes, rc) {
+		a.logf("Synchronization failed. rsync returned: %d", rc)
+		return rc, nil
+	}
+	if rc != 0 {
+		a.logf("Synchronization completed with warnings. rsync returned: %d", rc)
+	}
+	a.logf("Synchronization completed successfully. Notifying destination...")
+	if len(config.TerminationCommand) == 0 {
+		return 0, nil
+	}
+	return a.Exec(ctx, config.TerminationCommand, a.Out)
+}
+
+// waitFor waits until address accepts connections or timeout elapsed
+func (a *Agent) waitFor(ctx context.Context, address string, timeout time.Duration) error {
+	if address == "" {
+		return nil
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := a.Dial(ctx, address)
+		if err == nil {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return err
+		}
+		if err := a.Sleep(ctx, a.PollInterval); err != nil {
+			return err
+		}
+	}
+}
+
+// runWithRetries runs the rsync command until it succeeds, its exit code is not retried or
+// the retries of the policy are exhausted. It returns the exit code and the output of the last run.
+func (a *Agent) runWithRetries(ctx context.Context, config ClientConfig) (int, []byte, error) {
+	policy := config.RetryPolicy
+	command := append(append([]string{}, config.Command...), "--stats")
+	delay := time.Duration(policy.InitialDelaySeconds) * time.Second
+	maxDelay := time.Duration(policy.MaxDelaySeconds) * time.Second
+	for retry := 0; ; retry++ {
+		output := &bytes.Buffer{}
+		rc, err := a.Exec(ctx, command, io.MultiWriter(a.Out, output))
+		if err != nil {
+			return 0, nil, err
+		}
+		if succeeded(config.SuccessExitCodes, rc) || retry >= policy.MaxRetries {
+			return rc, output.Bytes(), nil
+		}
+		if !retried(policy.RetryOnExitCodes, rc) {
+			a.logf("Synchronization failed with exit code %d which is not retried.", rc)
+			return rc, output.Bytes(), nil
+		}
+		a.logf("Synchronization failed. Retrying in %d seconds. Retry %d/%d.", int(delay.Seconds()), retry+1, policy.MaxRetries)
+		if err := a.Sleep(ctx, delay); err != nil {
+			return 0, nil, err
+		}
+		delay *= time.Duration(policy.Factor)
+		if maxDelay > 0 && delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// succeeded returns whether the exit code rc is 0 or one of the success exit codes
+func succeeded(codes []int32, rc int) bool {
+	if rc == 0 {
+		return true
+	}
+	for _, code := range codes {
+		if int(code) == rc {
+			return true
+		}
+	}
+	return false
+}
+
+// retried returns whether the exit code rc is retried, all exit codes are retried when codes is empty
+func retried(codes []int32, rc int) bool {
+	if len(codes) == 0 {
+		return true
+	}
+	for _, code := range codes {
+		if int(code) == rc {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+// fakeAgent returns an agent which does not run commands but returns the exit codes of the
+// rsync command in order, recording the delays it slept for
+func fakeAgent(rsyncExitCodes []int, dialErr error) (*Agent, *[][]string, *[]time.Duration) {
+	commands := &[][]string{}
+	delays := &[]time.Duration{}
+	return &Agent{
+		Out: ioutil.Discard,
+		Exec: func(ctx context.Context, command []string, stdout io.Writer) (int, error) {
+			*commands = append(*commands, command)
+			if command[0] != "/usr/bin/rsync" || len(rsyncExitCodes) == 0 {
+				return 0, nil
+			}
+			rc := rsyncExitCodes[0]
+			rsyncExitCodes = rsyncExitCodes[1:]
+			_, _ = io.WriteString(stdout, "Number of regular files transferred: 3\nTotal transferred file size: 1,024 bytes\n")
+			return rc, nil
+		},
+		Dial: func(ctx context.Context, address string) error {
+			return dialErr
+		},
+		Sleep: func(ctx context.Context, d time.Duration) error {
+			*delays = append(*delays, d)
+			return nil
+		},
+	}, commands, delays
+}
+
+func TestAgent_RunClient(t *testing.T) {
+	tests := []struct {
+		name             string
+		rsyncExitCodes   []int
+		retryPolicy      transfer.RetryPolicy
+		successExitCodes []int32
+		dialErr          error
+		wantRC           int
+		wantRsyncRuns    int
+		wantDelays       []time.Duration
+		wantTermination  bool
+		wantStatsMessage bool
+		wantExitCode     int
+	}{
+		{
+			name:             "rsync succeeds, must notify the server",
+			rsyncExitCodes:   []int{0},
+			retryPolicy:      transfer.DefaultRetryPolicy(),
+			wantRC:           0,
+			wantRsyncRuns:    1,
+			wantTermination:  true,
+			wantStatsMessage: true,
+		},
+		{
+			name:             "rsync succeeds after retries, must back off up to the max delay",
+			rsyncExitCodes:   []int{23, 23, 23, 0},
+			retryPolicy:      transfer.RetryPolicy{MaxRetries: 4, InitialDelaySeconds: 2, Factor: 3, MaxDelaySeconds: 10},
+			wantRC:           0,
+			wantRsyncRuns:    4,
+			wantDelays:       []time.Duration{2 * time.Second, 6 * time.Second, 10 * time.Second},
+			wantTermination:  true,
+			wantStatsMessage: true,
+		},
+		{
+			name:             "rsync fails with an exit code not retried, must return it",
+			rsyncExitCodes:   []int{1, 0},
+			retryPolicy:      transfer.RetryPolicy{MaxRetries: 4, InitialDelaySeconds: 2, Factor: 2, RetryOnExitCodes: []int32{23}},
+			wantRC:           1,
+			wantRsyncRuns:    1,
+			wantStatsMessage: true,
+			wantExitCode:     1,
+		},
+		{
+			name:             "rsync keeps failing, must return its exit code once retries are exhausted",
+			rsyncExitCodes:   []int{23, 23, 23},
+			retryPolicy:      transfer.RetryPolicy{MaxRetries: 2, InitialDelaySeconds: 1, Factor: 1},
+			wantRC:           23,
+			wantRsyncRuns:    3,
+			wantDelays:       []time.Duration{time.Second, time.Second},
+			wantStatsMessage: true,
+			wantExitCode:     23,
+		},
+		{
+			name:             "rsync exits with a success exit code, must notify the server without retrying",
+			rsyncExitCodes:   []int{24, 0},
+			retryPolicy:      transfer.DefaultRetryPolicy(),
+			successExitCodes: []int32{24},
+			wantRC:           0,
+			wantRsyncRuns:    1,
+			wantTermination:  true,
+			wantStatsMessage: true,
+			wantExitCode:     24,
+		},
+		{
+			name:        "transport unreachable, must fail without running rsync",
+			retryPolicy: transfer.DefaultRetryPolicy(),
+			dialErr:     io.EOF,
+			wantRC:      1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			a, commands, delays := fakeAgent(tt.rsyncExitCodes, tt.dialErr)
+			config := ClientConfig{
+				Address:                "foo.bar.dev:2222",
+				Command:                []string{"/usr/bin/rsync", "-a", "/mnt/foo/bar/", "rsync://root@foo.bar.dev/bar/"},
+				RetryPolicy:            tt.retryPolicy,
+				SuccessExitCodes:       tt.successExitCodes,
+				TerminationCommand:     []string{"/usr/bin/rsync", "/mnt/termination/done", "rsync://root@foo.bar.dev/termination/"},
+				TerminationFile:        filepath.Join(dir, "termination"),
+				DoneFile:               filepath.Join(dir, "done"),
+				TerminationMessagePath: filepath.Join(dir, "termination-log"),
+			}
+			rc, err := a.RunClient(context.Background(), config)
+			if err != nil {
+				t.Fatalf("RunClient() error = %v", err)
+			}
+			if rc != tt.wantRC {
+				t.Errorf("RunClient() = %v, want %v", rc, tt.wantRC)
+			}
+
+			rsyncRuns, termination := 0, false
+			for _, command := range *commands {
+				switch {
+				case reflect.DeepEqual(command, config.TerminationCommand):
+					termination = true
+				case command[0] == "/usr/bin/rsync":
+					rsyncRuns++
+					if command[len(command)-1] != "--stats" {
+						t.Errorf("rsync command %v does not print stats", command)
+					}
+				}
+			}
+			if rsyncRuns != tt.wantRsyncRuns {
+				t.Errorf("rsync ran %d times, want %d", rsyncRuns, tt.wantRsyncRuns)
+			}
+			if termination != tt.wantTermination {
+				t.Errorf("server notified = %v, want %v", termination, tt.wantTermination)
+			}
+			if len(tt.wantDelays) > 0 && !reflect.DeepEqual(*delays, tt.wantDelays) {
+				t.Errorf("retry delays = %v, want %v", *delays, tt.wantDelays)
+			}
+			if _, err := os.Stat(config.DoneFile); err != nil {
+				t.Errorf("done file not created, error = %v", err)
+			}
+			message, _ := ioutil.ReadFile(config.TerminationMessagePath)
+			if got := strings.HasPrefix(string(message), "files=3 bytes=1024 "); got != tt.wantStatsMessage {
+				t.Errorf("termination message = %q, want stats %v", message, tt.wantStatsMessage)
+			}
+			if tt.wantStatsMessage && !strings.Contains(string(message), fmt.Sprintf("%s=%d\n", ExitCodeField, tt.wantExitCode)) {
+				t.Errorf("termination message = %q, want exit code %d", message, tt.wantExitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+)
+
+// RunServer runs the server command until it exits or, when the configuration has termination
+// files, until clients sent all of them. It returns the exit code of the server container.
+func (a *Agent) RunServer(ctx context.Context, config ServerConfig) (int, error) {
+	if len(config.Command) == 0 {
+		return 1, fmt.Errorf("%w: server command is empty", ErrConfigInvalid)
+	}
+	if len(config.TerminationFiles) == 0 {
+		return a.Exec(ctx, config.Command, a.Out)
+	}
+
+	commandCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		rc  int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		rc, err := a.Exec(commandCtx, config.Command, a.Out)
+		done <- result{rc: rc, err: err}
+	}()
+
+	for {
+		select {
+		case r := <-done:
+			return r.rc, r.err
+		default:
+		}
+		if allFilesExist(config.TerminationFiles) {
+			a.logf("Transfer completed, stopping the server")
+			a.sync(ctx)
+			if err := touch(config.CompletionFile); err != nil {
+				return 1, err
+			}
+			cancel()
+			<-done
+			return 0, nil
+		}
+		if err := a.Sleep(ctx, a.PollInterval); err != nil {
+			return 1, err
+		}
+	}
+}
+
+// allFilesExist returns whether all the files exist
+func allFilesExist(paths []string) bool {
+	for _, path := range paths {
+		if !fileExists(path) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package agent
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAgent_RunServer(t *testing.T) {
+	tests := []struct {
+		name            string
+		withTermination bool
+		daemonRC        int
+		wantRC          int
+	}{
+		{
+			name:            "termination file sent, must stop the daemon and complete the transport",
+			withTermination: true,
+			daemonRC:        -1,
+			wantRC:          0,
+		},
+		{
+			name:     "daemon exits, must return its exit code",
+			daemonRC: 12,
+			wantRC:   12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			config := ServerConfig{
+				Command:        []string{"/usr/bin/rsync", "--daemon", "--no-detach"},
+				CompletionFile: filepath.Join(dir, "completion"),
+			}
+			if tt.withTermination {
+				config.TerminationFiles = []string{filepath.Join(dir, "termination-a"), filepath.Join(dir, "termination-b")}
+			}
+			polls := 0
+			a := &Agent{
+				Out: ioutil.Discard,
+				Exec: func(ctx context.Context, command []string, stdout io.Writer) (int, error) {
+					if command[0] != "/usr/bin/rsync" {
+						return 0, nil
+					}
+					// the daemon runs until it is stopped unless it has an exit code
+					if tt.daemonRC >= 0 {
+						return tt.daemonRC, nil
+					}
+					<-ctx.Done()
+					return 137, nil
+				},
+				Sleep: func(ctx context.Context, d time.Duration) error {
+					polls++
+					// the clients of the PVCs complete one after the other
+					if polls%2 == 0 && polls/2 <= len(config.TerminationFiles) {
+						return touch(config.TerminationFiles[polls/2-1])
+					}
+					return nil
+				},
+			}
+			rc, err := a.RunServer(context.Background(), config)
+			if err != nil {
+				t.Fatalf("RunServer() error = %v", err)
+			}
+			if rc != tt.wantRC {
+				t.Errorf("RunServer() = %v, want %v", rc, tt.wantRC)
+			}
+			_, err = os.Stat(config.CompletionFile)
+			if completed := err == nil; completed != tt.withTermination {
+				t.Errorf("completion file created = %v, want %v", completed, tt.withTermination)
+			}
+			if tt.withTermination && polls < 2*len(config.TerminationFiles) {
+				t.Errorf("server stopped after %d polls, before all the termination files were sent", polls)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// statsFields maps the lines of the rsync --stats output to the fields of the termination
+// message of rsync client containers, see FormatStats
+var statsFields = []struct {
+	prefix string
+	key    string
+}{
+	{"Number of regular files transferred:", "files"},
+	{"Total transferred file size:", "bytes"},
+	{"Number of files:", "total_files"},
+	{"Total file size:", "total_size"},
+	{"Number of created files:", "created"},
+	{"Number of deleted files:", "deleted"},
+	{"Total bytes sent:", "sent"},
+	{"Total bytes received:", "received"},
+}
+
+// FormatStats returns the termination message of rsync client containers for the rsync
+// --stats output, it is written in the same format as the termination message of the client script
+func FormatStats(output []byte) string {
+	values := map[string]string{}
+	speedup := ""
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		for _, field := range statsFields {
+			if strings.HasPrefix(line, field.prefix) {
+				values[field.key] = firstNumber(strings.TrimPrefix(line, field.prefix))
+			}
+		}
+		if i := strings.Index(line, "speedup is "); i >= 0 {
+			value := strings.Fields(line[i+len("speedup is "):])
+			if len(value) > 0 {
+				speedup = strings.ReplaceAll(value[0], ",", "")
+			}
+		}
+	}
+	message := []string{}
+	for _, field := range statsFields {
+		value := values[field.key]
+		if value == "" {
+			value = "0"
+		}
+		message = append(message, fmt.Sprintf("%s=%s", field.key, value))
+	}
+	message = append(message, fmt.Sprintf("speedup=%s", speedup))
+	return strings.Join(message, " ") + "\n"
+}
+
+// firstNumber returns the digits of the first word of s, dropping thousands separators
+func firstNumber(s string) string {
+	words := strings.Fields(s)
+	if len(words) == 0 {
+		return ""
+	}
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, words[0])
+}
@@ -0,0 +1,42 @@
+package agent
+
+import "testing"
+
+func TestFormatStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   string
+	}{
+		{
+			name: "rsync stats, must format all fields",
+			output: `sending incremental file list
+
+Number of files: 1,234 (reg: 1,000, dir: 234)
+Number of created files: 10 (reg: 8, dir: 2)
+Number of deleted files: 3
+Number of regular files transferred: 5
+Total file size: 123,456 bytes
+Total transferred file size: 1,234 bytes
+Total bytes sent: 2,345
+Total bytes received: 123
+
+sent 2,345 bytes  received 123 bytes  4,936.00 bytes/sec
+total size is 123,456  speedup is 50.02
+`,
+			want: "files=5 bytes=1234 total_files=1234 total_size=123456 created=10 deleted=3 sent=2345 received=123 speedup=50.02\n",
+		},
+		{
+			name:   "no stats, must format zeros",
+			output: "rsync error: error in socket IO (code 10)\n",
+			want:   "files=0 bytes=0 total_files=0 total_size=0 created=0 deleted=0 sent=0 received=0 speedup=\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatStats([]byte(tt.output)); got != tt.want {
+				t.Errorf("FormatStats() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package rsync
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	"github.com/backube/pvc-transfer/transfer/rsync/exitcode"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func decodeAgentEnv(t *testing.T, env []corev1.EnvVar, config interface{}) {
+	if len(env) != 1 || env[0].Name != agent.ConfigEnv {
+		t.Fatalf("agent environment = %v, want %s", env, agent.ConfigEnv)
+	}
+	if err := json.Unmarshal([]byte(env[0].Value), config); err != nil {
+		t.Fatalf("unable to decode agent configuration, error = %v", err)
+	}
+}
+
+func Test_client_getAgentCommand(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tests := []struct {
+		name                   string
+		mode                   Mode
+		wantCommand            []string
+		wantTerminationCommand []string
+	}{
+		{
+			name: "daemon mode, must sync to the rsync module of the PVC",
+			mode: ModeDaemon,
+			wantCommand: []string{"/usr/bin/rsync", "-a", "/mnt/foo/" + pvc.LabelSafeName() + "/",
+				"rsync://root@foo.bar.dev/" + pvc.LabelSafeName() + "/", "--port", "8080"},
+			wantTerminationCommand: []string{"/usr/bin/rsync", terminationFile + "-data",
+				"rsync://root@foo.bar.dev/termination/", "--port", "8080"},
+		},
+		{
+			name: "ssh mode, must sync over ssh",
+			mode: ModeSSH,
+			wantCommand: []string{"/usr/bin/rsync", "-a", "/mnt/foo/" + pvc.LabelSafeName() + "/",
+				"-e", getSSHCommand(8080), "root@foo.bar.dev:" + sshDataMountPath + "/" + pvc.LabelSafeName() + "/"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &client{
+				username:        "root",
+				mode:            tt.mode,
+				agent:           true,
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			}
+			command, env, err := tc.getAgentCommand([]string{"-a"}, pvc)
+			if err != nil {
+				t.Fatalf("getAgentCommand() error = %v", err)
+			}
+			if !reflect.DeepEqual(command, []string{agent.Path, agent.RoleClient}) {
+				t.Errorf("getAgentCommand() command = %v", command)
+			}
+			config := agent.ClientConfig{}
+			decodeAgentEnv(t, env, &config)
+			if !reflect.DeepEqual(config.Command, tt.wantCommand) {
+				t.Errorf("agent rsync command = %v, want %v", config.Command, tt.wantCommand)
+			}
+			if tt.wantTerminationCommand != nil && !reflect.DeepEqual(config.TerminationCommand, tt.wantTerminationCommand) {
+				t.Errorf("agent termination command = %v, want %v", config.TerminationCommand, tt.wantTerminationCommand)
+			}
+			if config.Address != "foo.bar.dev:8080" {
+				t.Errorf("agent address = %s, want foo.bar.dev:8080", config.Address)
+			}
+			wantPolicy := transfer.DefaultRetryPolicy()
+			wantPolicy.RetryOnExitCodes = exitcode.RetryableCodes()
+			if !reflect.DeepEqual(config.RetryPolicy, wantPolicy) {
+				t.Errorf("agent retry policy = %v, want the default policy", config.RetryPolicy)
+			}
+		})
+	}
+}
+
+func Test_server_getAgentCommand(t *testing.T) {
+	pvcList, err := transfer.NewPVCList(testPVC("foo", "pvc-a"), testPVC("foo", "pvc-b"))
+	if err != nil {
+		t.Fatalf("NewPVCList() error = %v", err)
+	}
+	s := &server{
+		pvcList:               pvcList,
+		mode:                  ModeDaemon,
+		agent:                 true,
+		listenPort:            8080,
+		transportServer:       &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		terminateOnCompletion: true,
+	}
+	containers := s.getContainers(nil)
+	if !reflect.DeepEqual(containers[0].Command, []string{agent.Path, agent.RoleServer}) {
+		t.Errorf("rsync container command = %v", containers[0].Command)
+	}
+	config := agent.ServerConfig{}
+	decodeAgentEnv(t, containers[0].Env, &config)
+	wantCommand := []string{"/usr/bin/rsync", "--daemon", "--port=8080", "--no-detach", "-vvv"}
+	if !reflect.DeepEqual(config.Command, wantCommand) {
+		t.Errorf("agent server command = %v, want %v", config.Command, wantCommand)
+	}
+	// the server stops once the clients of all the PVCs sent their termination file
+	wantTerminationFiles := []string{
+		pvcTerminationFile(pvcList.PVCs()[0]),
+		pvcTerminationFile(pvcList.PVCs()[1]),
+	}
+	if !reflect.DeepEqual(config.TerminationFiles, wantTerminationFiles) {
+		t.Errorf("agent termination files = %v, want %v", config.TerminationFiles, wantTerminationFiles)
+	}
+}
@@ -0,0 +1,168 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+const (
+	// DefaultTargetUtilization is the share of the link used by auto-tuned transfers
+	DefaultTargetUtilization = 0.8
+	// maxCompressionLevel is the highest compression level of rsync
+	maxCompressionLevel = 9
+	// minTuningSample is the duration below which iterations are not measured, short iterations
+	// transfer too little data to be limited by the link
+	minTuningSample = time.Minute
+	// maxTuningStep bounds the factor applied to the bandwidth limit between two iterations
+	maxTuningStep = 2.0
+)
+
+// ErrAutoTuneInvalid is returned for auto-tuning options which cannot be used
+var ErrAutoTuneInvalid = errors.New("invalid rsync auto-tuning")
+
+// AutoTune adjusts the bandwidth limit and the compression level of rsync between the
+// iterations of a transfer, so that it uses a target share of a link shared with other
+// workloads, e.g. a WAN link between clusters. The throughput of each iteration is measured
+// from the bytes exchanged on the wire reported in the statistics of rsync.
+//
+// The bandwidth limit is scaled by the ratio of the target throughput to the throughput
+// observed, which compensates the overhead of the transport. When the link cannot reach the
+// target without limit, the compression level is raised to transfer more data in the same
+// bandwidth, and it is lowered once the transfer exceeds the target again.
+type AutoTune struct {
+	// LinkBandwidth is the bandwidth of the link in KiB/s
+	LinkBandwidth int
+	// TargetUtilization is the share of LinkBandwidth used by the transfer, between 0 and 1,
+	// defaults to DefaultTargetUtilization
+	TargetUtilization float64
+	// MaxCompressionLevel is the highest compression level used, from 0 which never compresses
+	// to 9, defaults to 9
+	MaxCompressionLevel *int
+}
+
+// Tuning is the bandwidth limit and the compression level of an iteration
+type Tuning struct {
+	// BwLimit is the bandwidth limit in KiB/s
+	BwLimit int
+	// CompressionLevel is the compression level from 1 to 9, 0 does not compress
+	CompressionLevel int
+}
+
+// ApplyTo sets the bandwidth limit and the compression level, the compression algorithm of
+// the options is kept unless the tuning does not compress. Algorithms without levels compress
+// at any level.
+func (t Tuning) ApplyTo(opts *CommandOptions) error {
+	bwLimit := t.BwLimit
+	opts.BwLimit = &bwLimit
+	opts.CompressLevel = nil
+	if t.CompressionLevel == 0 {
+		opts.Compress = false
+		opts.CompressChoice = CompressChoiceDefault
+		return nil
+	}
+	opts.Compress = true
+	if opts.CompressChoice.maxLevel() > 0 {
+		level := t.CompressionLevel
+		opts.CompressLevel = &level
+	}
+	return nil
+}
+
+// Validate returns an error wrapping ErrAutoTuneInvalid when the options cannot be used
+func (a AutoTune) Validate() error {
+	if a.LinkBandwidth <= 0 {
+		return fmt.Errorf("%w: link bandwidth must be positive", ErrAutoTuneInvalid)
+	}
+	if a.TargetUtilization < 0 || a.TargetUtilization > 1 {
+		return fmt.Errorf("%w: target utilization must be between 0 and 1", ErrAutoTuneInvalid)
+	}
+	if a.MaxCompressionLevel != nil && (*a.MaxCompressionLevel < 0 || *a.MaxCompressionLevel > maxCompressionLevel) {
+		return fmt.Errorf("%w: max compression level must be between 0 and %d", ErrAutoTuneInvalid, maxCompressionLevel)
+	}
+	return nil
+}
+
+// Tune returns the tuning of the next iteration from the iterations of the history, from the
+// oldest to the newest. Iterations are expected to be recorded with the tuning returned by
+// Tune for the iterations recorded before them, the first iteration is limited to the target
+// throughput without compression.
+func (a AutoTune) Tune(history []transfer.IterationRecord) Tuning {
+	target := a.target()
+	if len(history) == 0 {
+		return Tuning{BwLimit: target}
+	}
+	last := history[len(history)-1]
+	current := Tuning{BwLimit: last.BandwidthLimit, CompressionLevel: last.CompressionLevel}
+	if current.BwLimit == 0 {
+		current.BwLimit = target
+	}
+	throughput, ok := observedThroughput(last)
+	if !ok {
+		return current
+	}
+
+	ratio := float64(target) / throughput
+	ratio = math.Max(1/maxTuningStep, math.Min(maxTuningStep, ratio))
+	next := Tuning{
+		BwLimit:          int(float64(current.BwLimit) * ratio),
+		CompressionLevel: current.CompressionLevel,
+	}
+	if next.BwLimit < 1 {
+		next.BwLimit = 1
+	}
+	if next.BwLimit > a.LinkBandwidth {
+		next.BwLimit = a.LinkBandwidth
+	}
+	switch {
+	case throughput < 0.9*float64(target) && current.BwLimit >= a.LinkBandwidth:
+		// the link is the bottleneck even without limit
+		next.CompressionLevel++
+	case throughput > 1.1*float64(target):
+		next.CompressionLevel--
+	}
+	if limit := a.maxCompressionLevel(); next.CompressionLevel > limit {
+		next.CompressionLevel = limit
+	}
+	if next.CompressionLevel < 0 {
+		next.CompressionLevel = 0
+	}
+	return next
+}
+
+// target returns the target throughput in KiB/s
+func (a AutoTune) target() int {
+	utilization := a.TargetUtilization
+	if utilization == 0 {
+		utilization = DefaultTargetUtilization
+	}
+	target := int(float64(a.LinkBandwidth) * utilization)
+	if target < 1 {
+		return 1
+	}
+	return target
+}
+
+func (a AutoTune) maxCompressionLevel() int {
+	if a.MaxCompressionLevel == nil {
+		return maxCompressionLevel
+	}
+	return *a.MaxCompressionLevel
+}
+
+// observedThroughput returns the throughput of an iteration on the wire in KiB/s, ok is false
+// when the iteration is too short to be measured or did not report the bytes on the wire
+func observedThroughput(record transfer.IterationRecord) (throughput float64, ok bool) {
+	if record.StartedAt == nil || record.FinishedAt == nil {
+		return 0, false
+	}
+	duration := record.FinishedAt.Sub(record.StartedAt.Time)
+	wire := record.BytesSent + record.BytesReceived
+	if duration < minTuningSample || wire == 0 {
+		return 0, false
+	}
+	return float64(wire) / 1024 / duration.Seconds(), true
+}
@@ -0,0 +1,142 @@
+package rsync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestAutoTune_Tune(t *testing.T) {
+	start := metav1.NewTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	// iteration returns the record of an iteration of ten minutes at the throughput in KiB/s
+	iteration := func(throughput int64, tuning Tuning) transfer.IterationRecord {
+		finish := metav1.NewTime(start.Add(10 * time.Minute))
+		return transfer.IterationRecord{
+			StartedAt:        &start,
+			FinishedAt:       &finish,
+			BytesSent:        throughput * 1024 * 600,
+			BandwidthLimit:   tuning.BwLimit,
+			CompressionLevel: tuning.CompressionLevel,
+		}
+	}
+	noCompression := 0
+	tests := []struct {
+		name     string
+		autoTune AutoTune
+		history  []transfer.IterationRecord
+		want     Tuning
+	}{
+		{
+			name:     "first iteration, must limit to the target without compression",
+			autoTune: AutoTune{LinkBandwidth: 10000},
+			want:     Tuning{BwLimit: 8000},
+		},
+		{
+			name:     "throughput below the limit, must raise the limit",
+			autoTune: AutoTune{LinkBandwidth: 10000, TargetUtilization: 0.5},
+			history:  []transfer.IterationRecord{iteration(4000, Tuning{BwLimit: 5000})},
+			want:     Tuning{BwLimit: 6250},
+		},
+		{
+			name:     "throughput above the target, must lower the limit and the compression",
+			autoTune: AutoTune{LinkBandwidth: 10000},
+			history:  []transfer.IterationRecord{iteration(10000, Tuning{BwLimit: 10000, CompressionLevel: 3})},
+			want:     Tuning{BwLimit: 8000, CompressionLevel: 2},
+		},
+		{
+			name:     "link saturated without limit, must raise the compression",
+			autoTune: AutoTune{LinkBandwidth: 10000},
+			history:  []transfer.IterationRecord{iteration(4000, Tuning{BwLimit: 10000, CompressionLevel: 1})},
+			want:     Tuning{BwLimit: 10000, CompressionLevel: 2},
+		},
+		{
+			name:     "link saturated with compression disabled, must not compress",
+			autoTune: AutoTune{LinkBandwidth: 10000, MaxCompressionLevel: &noCompression},
+			history:  []transfer.IterationRecord{iteration(4000, Tuning{BwLimit: 10000})},
+			want:     Tuning{BwLimit: 10000},
+		},
+		{
+			name:     "throughput far below the target, must bound the step",
+			autoTune: AutoTune{LinkBandwidth: 100000},
+			history:  []transfer.IterationRecord{iteration(100, Tuning{BwLimit: 1000})},
+			want:     Tuning{BwLimit: 2000},
+		},
+		{
+			name:     "short iteration, must keep the tuning",
+			autoTune: AutoTune{LinkBandwidth: 10000},
+			history: []transfer.IterationRecord{{
+				StartedAt: &start, FinishedAt: &start, BytesSent: 1024,
+				BandwidthLimit: 6000, CompressionLevel: 4,
+			}},
+			want: Tuning{BwLimit: 6000, CompressionLevel: 4},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.autoTune.Tune(tt.history); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tune() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAutoTune_Validate(t *testing.T) {
+	level := 10
+	tests := []struct {
+		name     string
+		autoTune AutoTune
+		wantErr  error
+	}{
+		{
+			name:     "link bandwidth, must be valid",
+			autoTune: AutoTune{LinkBandwidth: 10000},
+		},
+		{
+			name:     "no link bandwidth, must return ErrAutoTuneInvalid",
+			autoTune: AutoTune{},
+			wantErr:  ErrAutoTuneInvalid,
+		},
+		{
+			name:     "utilization above 1, must return ErrAutoTuneInvalid",
+			autoTune: AutoTune{LinkBandwidth: 10000, TargetUtilization: 1.5},
+			wantErr:  ErrAutoTuneInvalid,
+		},
+		{
+			name:     "compression level above 9, must return ErrAutoTuneInvalid",
+			autoTune: AutoTune{LinkBandwidth: 10000, MaxCompressionLevel: &level},
+			wantErr:  ErrAutoTuneInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.autoTune.Validate(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTuning_ApplyTo(t *testing.T) {
+	c := &CommandOptions{}
+	if err := c.Apply(Compression(6), Tuning{BwLimit: 5000, CompressionLevel: 2}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got, err := c.Options()
+	if err != nil {
+		t.Fatalf("Options() error = %v", err)
+	}
+	if want := []string{"--compress", "--compress-level=2", "--bwlimit=5000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Options() = %v, want %v", got, want)
+	}
+	if err := c.Apply(Tuning{BwLimit: 5000}); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got, _ = c.Options()
+	if want := []string{"--bwlimit=5000"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Options() = %v, want %v", got, want)
+	}
+}
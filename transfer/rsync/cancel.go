@@ -0,0 +1,131 @@
+package rsync
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	rsyncServerState = "rsync-server-state"
+	// cancelGracePeriodSeconds is the time given to rsync and the transport to stop gracefully
+	cancelGracePeriodSeconds int64 = 30
+)
+
+// cancelForceDeleteDelay is the time given to a deleted pod past the end of its grace period,
+// pods still terminating afterwards are force deleted
+var cancelForceDeleteDelay = 10 * time.Second
+
+// cancelPod deletes the pod, sending SIGTERM to its containers, and returns whether it is gone.
+// It does not wait for the pod to terminate, callers are expected to call it again until it
+// returns true. Pods still terminating cancelForceDeleteDelay after their deletion timestamp,
+// which is the end of their grace period, are force deleted.
+func cancelPod(ctx context.Context, c ctrlclient.Client, logger logr.Logger, key types.NamespacedName) (bool, error) {
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, key, pod)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	gracePeriodSeconds := cancelGracePeriodSeconds
+	switch {
+	case pod.DeletionTimestamp == nil:
+	case time.Now().After(pod.DeletionTimestamp.Add(cancelForceDeleteDelay)):
+		logger.Info("pod did not terminate before the deadline, force deleting it", "pod", key)
+		gracePeriodSeconds = 0
+	default:
+		return false, nil
+	}
+	err = c.Delete(ctx, pod, ctrlclient.GracePeriodSeconds(gracePeriodSeconds))
+	switch {
+	case k8serrors.IsNotFound(err):
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	return gracePeriodSeconds == 0, nil
+}
+
+// Cancel records the cancelled state of the client and stops the client pods gracefully, it
+// returns false while they are terminating. Once they are gone all the resources of the client
+// are marked for cleanup with transfer.CancelledLabel. NewClient does not recreate the pods of
+// a cancelled client until the resources are deleted.
+func (tc *client) Cancel(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	err := tc.updateState(ctx, c, func(data map[string]string) {
+		setStateTime(data, cancelledAtKey)
+	})
+	if err != nil {
+		tc.logger.Error(err, "unable to record cancelled state of rsync client")
+		return false, err
+	}
+	stopped := true
+	for _, podKey := range tc.podKeysByPVC() {
+		gone, err := cancelPod(ctx, c, tc.logger, podKey)
+		if err != nil {
+			tc.logger.Error(err, "unable to stop rsync client pod", "pod", podKey)
+			return false, err
+		}
+		stopped = stopped && gone
+	}
+	if !stopped {
+		tc.logger.Info("rsync client cancelling, waiting for the pods to terminate")
+		return false, nil
+	}
+	tc.logger.Info("rsync client cancelled")
+	return true, tc.MarkForCleanup(ctx, c, transfer.CancelledLabel, "true")
+}
+
+// stateName returns the name of the configmap holding the state of the server
+func (s *server) stateName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("%s-%s", rsyncServerState, s.nameSuffix),
+	}
+}
+
+// Cancel records the cancelled state of the server and stops the server pod gracefully, it
+// returns false while the pod is terminating. Once it is gone all the resources of the server
+// are marked for cleanup with transfer.CancelledLabel. NewServer does not recreate the pod of
+// a cancelled server until the resources are deleted.
+func (s *server) Cancel(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	err := updateState(ctx, c, s.logger, s.stateName(), s.labels, s.ownerRefs, s.options, func(data map[string]string) {
+		setStateTime(data, cancelledAtKey)
+	})
+	if err != nil {
+		s.logger.Error(err, "unable to record cancelled state of rsync server")
+		return false, err
+	}
+	stopped, err := cancelPod(ctx, c, s.logger, types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("rsync-server-%s", s.nameSuffix),
+	})
+	if err != nil {
+		s.logger.Error(err, "unable to stop rsync server pod")
+		return false, err
+	}
+	if !stopped {
+		s.logger.Info("rsync server cancelling, waiting for the pod to terminate")
+		return false, nil
+	}
+	s.logger.Info("rsync server cancelled")
+	return true, s.MarkForCleanup(ctx, c, transfer.CancelledLabel, "true")
+}
+
+// isCancelled returns whether the server was cancelled
+func (s *server) isCancelled(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	state, err := getState(ctx, c, s.stateName())
+	if err != nil {
+		return false, err
+	}
+	return getStateTime(state, cancelledAtKey) != nil, nil
+}
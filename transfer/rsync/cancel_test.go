@@ -0,0 +1,140 @@
+package rsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// terminatingClient keeps gracefully deleted pods around with a deletion timestamp, as the
+// apiserver does during their grace period, until they are force deleted
+type terminatingClient struct {
+	ctrlclient.Client
+	forceDeleted bool
+}
+
+func (c *terminatingClient) Delete(ctx context.Context, obj ctrlclient.Object, opts ...ctrlclient.DeleteOption) error {
+	deleteOptions := &ctrlclient.DeleteOptions{}
+	deleteOptions.ApplyOptions(opts)
+	if deleteOptions.GracePeriodSeconds != nil && *deleteOptions.GracePeriodSeconds == 0 {
+		c.forceDeleted = true
+		// the fake client deletes objects once their last finalizer is removed
+		obj.SetFinalizers(nil)
+		return c.Client.Update(ctx, obj)
+	}
+	// the fake client sets the deletion timestamp of objects with finalizers
+	obj.SetFinalizers([]string{"test/terminating"})
+	if err := c.Client.Update(ctx, obj); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func Test_client_Cancel(t *testing.T) {
+	tests := []struct {
+		name            string
+		terminating     bool
+		gracePassed     bool
+		wantCancelled   []bool
+		wantForceDelete bool
+	}{
+		{
+			name:          "pod terminates, must be cancelled once the pod is gone",
+			wantCancelled: []bool{false, true},
+		},
+		{
+			name:          "pod terminating within its grace period, must be cancelling",
+			terminating:   true,
+			wantCancelled: []bool{false, false, false},
+		},
+		{
+			name:            "pod terminating past its grace period, must be force deleted",
+			terminating:     true,
+			gracePassed:     true,
+			wantCancelled:   []bool{false, true},
+			wantForceDelete: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			var c ctrlclient.Client = fakeClientWithObjects()
+			terminating := &terminatingClient{Client: c}
+			if tt.terminating {
+				c = terminating
+			}
+			if tt.gracePassed {
+				// the fake client sets the deletion timestamp to the time of the deletion
+				delay := cancelForceDeleteDelay
+				cancelForceDeleteDelay = 0
+				defer func() { cancelForceDeleteDelay = delay }()
+			}
+			tc := &client{
+				logger:   testr.New(t),
+				username: "root",
+				pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+				}),
+				nameSuffix:      "foo",
+				namespace:       "foo",
+				labels:          map[string]string{"test": "me"},
+				ownerRefs:       testOwnerReferences(),
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			}
+			podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}
+			if err := tc.reconcilePod(ctx, c, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+
+			for i, want := range tt.wantCancelled {
+				cancelled, err := tc.Cancel(ctx, c)
+				if err != nil || cancelled != want {
+					t.Fatalf("Cancel() call %d = %v, %v, want %v", i, cancelled, err, want)
+				}
+			}
+			if terminating.forceDeleted != tt.wantForceDelete {
+				t.Errorf("pod force deleted = %v, want %v", terminating.forceDeleted, tt.wantForceDelete)
+			}
+			status, err := tc.Status(context.Background(), c)
+			if err != nil || status.Cancelled == nil {
+				t.Fatalf("Status() = %v, %v, want cancelled", status, err)
+			}
+			pod := &corev1.Pod{}
+			err = c.Get(context.Background(), podKey, pod)
+			state := &corev1.ConfigMap{}
+			stateErr := c.Get(context.Background(), tc.stateName(), state)
+			if !tt.wantCancelled[len(tt.wantCancelled)-1] {
+				if err != nil || pod.DeletionTimestamp == nil {
+					t.Errorf("client pod not terminating on cancel, error = %v", err)
+				}
+				if stateErr != nil || state.Labels[transfer.CancelledLabel] != "" {
+					t.Errorf("client state marked for cleanup while cancelling, labels = %v, error = %v", state.Labels, stateErr)
+				}
+				return
+			}
+			if !k8serrors.IsNotFound(err) {
+				t.Errorf("client pod not deleted on cancel, error = %v", err)
+			}
+			if stateErr != nil || state.Labels[transfer.CancelledLabel] != "true" {
+				t.Errorf("client state not marked for cleanup, labels = %v, error = %v", state.Labels, stateErr)
+			}
+
+			// a cancelled client pod is not recreated
+			if err := tc.reconcilePod(context.Background(), c, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			err = c.Get(context.Background(), podKey, &corev1.Pod{})
+			if !k8serrors.IsNotFound(err) {
+				t.Errorf("client pod recreated once cancelled, error = %v", err)
+			}
+		})
+	}
+}
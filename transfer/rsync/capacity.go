@@ -0,0 +1,42 @@
+package rsync
+
+import (
+	"context"
+
+	"github.com/backube/pvc-transfer/transfer"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// destinationFullField is set to true in the termination message of the rsync container when
+// the output of the last rsync attempt reports a full destination
+const destinationFullField = "destination_full"
+
+// destinationFullScript appends destinationFullField to the termination message when the
+// rsync output in the file of the first argument reports ENOSPC. Only the client script looks
+// for it, the rsync agent reports the exit code alone.
+const destinationFullScript = `if grep -q "No space left on device" %s 2> /dev/null; then
+    echo "` + destinationFullField + `=true" >> /dev/termination-log
+fi`
+
+// isDestinationFull returns whether the termination message fields of the rsync container
+// report a full destination
+func isDestinationFull(fields map[string]string) bool {
+	return fields[destinationFullField] == "true"
+}
+
+// checkDestinationCapacity returns an error wrapping transfer.ErrDestinationFull when the
+// estimate of the pre-scan exceeds the destination capacity of the client
+func (tc *client) checkDestinationCapacity(ctx context.Context, c ctrlclient.Client) error {
+	if tc.destinationCapacity == nil {
+		return nil
+	}
+	state, err := getState(ctx, c, tc.stateName())
+	if err != nil {
+		return err
+	}
+	err = transfer.CheckCapacity(getEstimate(state), *tc.destinationCapacity)
+	if err != nil {
+		tc.logger.Error(err, "destination PVCs too small for the source PVCs, the transfer is not started")
+	}
+	return err
+}
@@ -0,0 +1,91 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_client_checkDestinationCapacity(t *testing.T) {
+	tests := []struct {
+		name     string
+		capacity string
+		scanned  bool
+		wantErr  error
+	}{
+		{
+			name:    "no destination capacity, must not return an error",
+			scanned: true,
+		},
+		{
+			name:     "no estimate, must not return an error",
+			capacity: "1Ki",
+		},
+		{
+			name:     "estimate within the destination capacity, must not return an error",
+			capacity: "4Ki",
+			scanned:  true,
+		},
+		{
+			name:     "estimate exceeding the destination capacity, must return ErrDestinationFull",
+			capacity: "1Ki",
+			scanned:  true,
+			wantErr:  transfer.ErrDestinationFull,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeClient := fakeClientWithObjects()
+			tc := &client{
+				logger:     testr.New(t),
+				nameSuffix: "foo",
+				namespace:  "foo",
+				labels:     map[string]string{"test": "me"},
+				ownerRefs:  testOwnerReferences(),
+			}
+			if tt.capacity != "" {
+				capacity := resource.MustParse(tt.capacity)
+				tc.destinationCapacity = &capacity
+			}
+			if tt.scanned {
+				err := tc.updateState(ctx, fakeClient, func(data map[string]string) {
+					data[totalFilesKey] = "12"
+					data[totalBytesKey] = "4096"
+				})
+				if err != nil {
+					t.Fatalf("updateState() error = %v", err)
+				}
+			}
+			err := tc.checkDestinationCapacity(ctx, fakeClient)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("checkDestinationCapacity() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_client_getCommand_destinationFull(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tc := &client{
+		username:        "root",
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	script := tc.getCommand([]string{"-a"}, pvc)[2]
+	if !strings.Contains(script, "--stats 2>&1 | tee "+rsyncStatsFile) {
+		t.Errorf("rsync script does not record the errors of rsync:\n%s", script)
+	}
+	if !strings.Contains(script, destinationFullField+"=true\" >> /dev/termination-log") {
+		t.Errorf("rsync script does not report full destinations:\n%s", script)
+	}
+}
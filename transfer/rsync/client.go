@@ -3,9 +3,12 @@ package rsync
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/internal/utils"
 	"github.com/backube/pvc-transfer/transfer"
 	"github.com/backube/pvc-transfer/transport"
@@ -13,10 +16,12 @@ import (
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	errorsutil "k8s.io/apimachinery/pkg/util/errors"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
-	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 type client struct {
@@ -32,6 +37,25 @@ type client struct {
 	options   transfer.PodOptions
 	logger    logr.Logger
 
+	mode           Mode
+	sshCredentials types.NamespacedName
+	agent          bool
+	// fanOutClient pulls the PVCs of a fan-out server, see Options.FanOutClient
+	fanOutClient      string
+	fanOutCredentials types.NamespacedName
+	// pull pulls the PVCs of the server into the PVCs of the client, see Options.Pull
+	pull bool
+	// successExitCodes are the exit codes of rsync treated as a successful transfer, see
+	// Options.SuccessExitCodes
+	successExitCodes []int32
+	// integrityManifest sends a checksum manifest of each PVC, see Options.IntegrityManifest
+	integrityManifest bool
+	// streams is the number of rsync processes transferring each PVC, see Options.Streams
+	streams int
+	// destinationCapacity is checked against the estimate of the pre-scan, see
+	// Options.DestinationCapacity
+	destinationCapacity *resource.Quantity
+
 	// TODO: this is a temporary field that needs to give away once multiple
 	//  namespace pvcList is supported
 	namespace string
@@ -49,40 +73,246 @@ func (tc *client) PVCs() []*corev1.PersistentVolumeClaim {
 	return pvcs
 }
 
+// Containers returns the names of the rsync container and of the transport containers of the
+// client pod
+func (tc *client) Containers() []string {
+	return getContainerNames(tc.Transport())
+}
+
+// Status returns the status of the client pods along with the status of the transfer of each
+// PVC. Failures of any PVC are reported over the successes of the others.
 func (tc *client) Status(ctx context.Context, c ctrlclient.Client) (*transfer.Status, error) {
-	podList := &corev1.PodList{}
-	err := c.List(ctx, podList, ctrlclient.MatchingLabels(tc.labels))
+	state, err := getState(ctx, c, tc.stateName())
+	if err != nil {
+		return nil, err
+	}
+	estimate := getEstimate(state)
+	if cancelledAt := getStateTime(state, cancelledAtKey); cancelledAt != nil {
+		return &transfer.Status{Cancelled: &transfer.Cancelled{CancelledAt: cancelledAt}, Estimate: estimate}, nil
+	}
+	if pausedAt := getStateTime(state, pausedAtKey); pausedAt != nil {
+		return &transfer.Status{Paused: &transfer.Paused{PausedAt: pausedAt}, Estimate: estimate}, nil
+	}
+
+	pods, err := tc.listPods(ctx, c)
 	if err != nil {
 		return nil, err
 	}
 
-	for _, pod := range podList.Items {
-		if len(pod.Status.ContainerStatuses) > 0 {
-			for _, containerStatus := range pod.Status.ContainerStatuses {
-				if containerStatus.Name == "rsync" && containerStatus.State.Terminated != nil {
-					if containerStatus.State.Terminated.ExitCode == 0 {
-						return &transfer.Status{
-							Completed: &transfer.Completed{
-								Successful: true,
-								Failure:    false,
-								FinishedAt: &containerStatus.State.Terminated.FinishedAt,
-							},
-						}, nil
-					} else {
-						return &transfer.Status{
-							Running: nil,
-							Completed: &transfer.Completed{
-								Successful: false,
-								Failure:    true,
-								FinishedAt: &containerStatus.State.Terminated.FinishedAt,
-							},
-						}, nil
-					}
-				}
+	pvcs := tc.pendingPVCStatuses()
+	for pvcKey, pod := range pods {
+		pod := pod
+		if pvcStatus := getPVCStatus(&pod); pvcStatus != nil {
+			pvcs[pvcKey] = pvcStatus
+		}
+	}
+	status := &transfer.Status{Estimate: estimate, PVCs: pvcs}
+	if completed := aggregateCompleted(pvcs); completed != nil {
+		status.Completed = completed
+		return status, nil
+	}
+	if running := aggregateRunning(pvcs); running != nil {
+		status.Running = running
+		return status, nil
+	}
+	return nil, fmt.Errorf("%w: unable to find the appropriate container to inspect status for rsync transfer", transfer.ErrStatusUnknown)
+}
+
+// aggregateCompleted returns the completion of the transfer once the transfers of all the
+// PVCs completed, nil otherwise. The transfer fails when any PVC failed, the first failure, or
+// else the first warning, is reported so that it is not hidden by the other PVCs. The
+// counters of the PVCs are summed, the statistics of rsync are only reported for a single PVC.
+func aggregateCompleted(pvcs map[types.NamespacedName]*transfer.PVCStatus) *transfer.Completed {
+	summary := (&transfer.Status{PVCs: pvcs}).Summary()
+	if summary.Total == 0 || !summary.AllCompleted {
+		return nil
+	}
+	keys := sortedPVCKeys(pvcs)
+	completed := *pvcs[keys[0]].Completed
+	for _, key := range keys {
+		if pvcs[key].Completed.Warning != "" {
+			completed = *pvcs[key].Completed
+			break
+		}
+	}
+	if summary.AnyFailed {
+		completed = *pvcs[summary.FailedPVCs[0]].Completed
+	}
+	if len(keys) == 1 {
+		return &completed
+	}
+	completed.Stats = nil
+	completed.FilesTransferred, completed.BytesTransferred = 0, 0
+	for _, key := range keys {
+		pvcCompleted := pvcs[key].Completed
+		completed.FilesTransferred += pvcCompleted.FilesTransferred
+		completed.BytesTransferred += pvcCompleted.BytesTransferred
+		if pvcCompleted.StartedAt != nil && (completed.StartedAt == nil || pvcCompleted.StartedAt.Before(completed.StartedAt)) {
+			completed.StartedAt = pvcCompleted.StartedAt
+		}
+		if pvcCompleted.FinishedAt != nil && (completed.FinishedAt == nil || completed.FinishedAt.Before(pvcCompleted.FinishedAt)) {
+			completed.FinishedAt = pvcCompleted.FinishedAt
+		}
+	}
+	return &completed
+}
+
+// aggregateRunning returns the progress of the transfer while the transfer of any PVC started
+// and did not complete for all of them, the transfer started with the earliest PVC
+func aggregateRunning(pvcs map[types.NamespacedName]*transfer.PVCStatus) *transfer.Running {
+	var running *transfer.Running
+	for _, key := range sortedPVCKeys(pvcs) {
+		var startedAt *metav1.Time
+		switch pvcStatus := pvcs[key]; {
+		case pvcStatus.Running != nil:
+			startedAt = pvcStatus.Running.StartedAt
+		case pvcStatus.Completed != nil:
+			startedAt = pvcStatus.Completed.StartedAt
+		default:
+			continue
+		}
+		if running == nil {
+			running = &transfer.Running{StartedAt: startedAt}
+		}
+		if startedAt != nil && (running.StartedAt == nil || startedAt.Before(running.StartedAt)) {
+			running.StartedAt = startedAt
+		}
+	}
+	return running
+}
+
+// sortedPVCKeys returns the keys of the statuses of the PVCs, sorted
+func sortedPVCKeys(pvcs map[types.NamespacedName]*transfer.PVCStatus) []types.NamespacedName {
+	keys := make([]types.NamespacedName, 0, len(pvcs))
+	for key := range pvcs {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
+
+// Pods returns the key of the client pod of each PVC once the pod is created, see listPods
+func (tc *client) Pods(ctx context.Context, c ctrlclient.Client) (map[types.NamespacedName]ctrlclient.ObjectKey, error) {
+	pods, err := tc.listPods(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	podKeys := map[types.NamespacedName]ctrlclient.ObjectKey{}
+	for pvcKey, pod := range pods {
+		podKeys[pvcKey] = ctrlclient.ObjectKeyFromObject(&pod)
+	}
+	return podKeys, nil
+}
+
+// podKey returns the key of the client pod of a PVC. Each PVC is transferred by its own pod,
+// named after the PVC so that the pods of the PVCs of a namespace do not overwrite each other.
+func (tc *client) podKey(pvc transfer.PVC) ctrlclient.ObjectKey {
+	return ctrlclient.ObjectKey{
+		Namespace: pvc.Claim().Namespace,
+		Name:      fmt.Sprintf("rsync-client-%s-%s", tc.nameSuffix, pvc.LabelSafeName()),
+	}
+}
+
+// podKeysByPVC returns the key of the client pod of each PVC of the client, see podKey
+func (tc *client) podKeysByPVC() map[types.NamespacedName]ctrlclient.ObjectKey {
+	podKeys := map[types.NamespacedName]ctrlclient.ObjectKey{}
+	if tc.pvcList == nil {
+		return podKeys
+	}
+	for _, pvc := range tc.pvcList.PVCs() {
+		podKeys[ctrlclient.ObjectKeyFromObject(pvc.Claim())] = tc.podKey(pvc)
+	}
+	return podKeys
+}
+
+// listPods returns the client pods of the transfer keyed by their PVCs. Pods are listed with
+// the labels of the client in the namespaces of its PVCs, and only the pods named after a PVC
+// of the client and owned by its owners are kept, unrelated pods reusing the labels are
+// ignored. The pod of a PVC is looked up by name when it is not listed, e.g. when its labels
+// were changed.
+func (tc *client) listPods(ctx context.Context, c ctrlclient.Client) (map[types.NamespacedName]corev1.Pod, error) {
+	podKeys := tc.podKeysByPVC()
+	listed := map[ctrlclient.ObjectKey]corev1.Pod{}
+	if tc.pvcList != nil {
+		for _, ns := range tc.pvcList.Namespaces() {
+			podList := &corev1.PodList{}
+			err := c.List(ctx, podList, ctrlclient.InNamespace(ns), ctrlclient.MatchingLabels(tc.labels))
+			if err != nil {
+				return nil, err
+			}
+			for _, pod := range podList.Items {
+				listed[ctrlclient.ObjectKeyFromObject(&pod)] = pod
+			}
+		}
+	}
+
+	pods := map[types.NamespacedName]corev1.Pod{}
+	for pvcKey, podKey := range podKeys {
+		pod, found := listed[podKey]
+		if !found {
+			err := c.Get(ctx, podKey, &pod)
+			switch {
+			case k8serrors.IsNotFound(err):
+				continue
+			case err != nil:
+				return nil, err
 			}
 		}
+		if isOwnedBy(&pod.ObjectMeta, tc.ownerRefs) {
+			pods[pvcKey] = pod
+		}
+	}
+	return pods, nil
+}
+
+// isOwnedBy returns whether the object is owned by all the given owners
+func isOwnedBy(object *metav1.ObjectMeta, ownerRefs []metav1.OwnerReference) bool {
+	uids := map[types.UID]bool{}
+	for _, ownerRef := range object.OwnerReferences {
+		uids[ownerRef.UID] = true
+	}
+	for _, ownerRef := range ownerRefs {
+		if !uids[ownerRef.UID] {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingPVCStatuses returns an empty status for each PVC of the client, the status of the
+// PVCs whose client pod started is set by Status
+func (tc *client) pendingPVCStatuses() map[types.NamespacedName]*transfer.PVCStatus {
+	pvcs := map[types.NamespacedName]*transfer.PVCStatus{}
+	if tc.pvcList == nil {
+		return pvcs
+	}
+	for _, pvc := range tc.pvcList.PVCs() {
+		pvcs[ctrlclient.ObjectKeyFromObject(pvc.Claim())] = &transfer.PVCStatus{}
+	}
+	return pvcs
+}
+
+// getPVCStatus returns the status of the transfer of the PVC mounted by a client pod from the
+// state of its rsync container, nil when the container did not start yet
+func getPVCStatus(pod *corev1.Pod) *transfer.PVCStatus {
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.Name != RsyncContainer {
+			continue
+		}
+		switch {
+		case containerStatus.State.Terminated != nil:
+			terminated := containerStatus.State.Terminated
+			completed := completedStatus(terminated.ExitCode, terminated.Message)
+			completed.FinishedAt = &terminated.FinishedAt
+			completed.StartedAt = &terminated.StartedAt
+			return &transfer.PVCStatus{Completed: completed}
+		case containerStatus.State.Running != nil:
+			return &transfer.PVCStatus{Running: &transfer.Running{StartedAt: &containerStatus.State.Running.StartedAt}}
+		}
 	}
-	return nil, fmt.Errorf("unable to find the appropriate container to inspect status for rsync transfer")
+	return nil
 }
 
 func (tc *client) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, value string) error {
@@ -91,24 +321,53 @@ func (tc *client) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key,
 		return err
 	}
 
-	err = tc.endpoint.MarkForCleanup(ctx, c, key, value)
-	if err != nil {
+	// the endpoint is only known to clients created with one
+	if tc.endpoint != nil {
+		err = tc.endpoint.MarkForCleanup(ctx, c, key, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	// update the pods of the PVCs
+	for _, podKey := range tc.podKeysByPVC() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podKey.Name,
+				Namespace: podKey.Namespace,
+			},
+		}
+		err = utils.UpdateWithLabel(ctx, c, pod, key, value)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	// the scan pod is deleted once the pre-scan completed
+	scanPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tc.scanPodName().Name,
+			Namespace: tc.namespace,
+		},
+	}
+	err = utils.UpdateWithLabel(ctx, c, scanPod, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
-	// update pod
-	pod := &corev1.Pod{
+	// the state is only created once the client was paused, cancelled or scanned
+	state := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("rsync-client-%s", tc.nameSuffix),
+			Name:      tc.stateName().Name,
 			Namespace: tc.namespace,
 		},
 	}
-	err = utils.UpdateWithLabel(ctx, c, pod, key, value)
-	if err != nil {
+	err = utils.UpdateWithLabel(ctx, c, state, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
-	// update service account
+	// service account and RBAC are not created when callers provide their own service account
 	sa := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-%s", rsyncServiceAccount, tc.nameSuffix),
@@ -116,7 +375,7 @@ func (tc *client) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key,
 		},
 	}
 	err = utils.UpdateWithLabel(ctx, c, sa, key, value)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
@@ -128,7 +387,7 @@ func (tc *client) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key,
 		},
 	}
 	err = utils.UpdateWithLabel(ctx, c, role, key, value)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
@@ -140,21 +399,20 @@ func (tc *client) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key,
 		},
 	}
 
-	return utils.UpdateWithLabel(ctx, c, roleBinding, key, value)
+	err = utils.UpdateWithLabel(ctx, c, roleBinding, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
 
 // NewClient takes PVCList, transport and endpoint object and creates all
 // the resources required by the transfer client pod as well as the transfer
 // pod. All the PVCs in the list will have rsync running against the server
 // to sync its data.
-
-// The nameSuffix will be appended to the rsync client resources (pod, sa, role and rolebinding)
-// hence it needs to adhere to the naming convention of kube resources. This allows for consumers
-// to retry with a different suffix until retries are added to the client package
-
-// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
-// +kubebuilder:rbac:groups=core,resources=pods;serviceaccounts;secrets,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//
+// Deprecated: use NewClientFromOptions, nameSuffix is not used, the names of the rsync client
+// resources are derived from the PVCs.
 func NewClient(ctx context.Context, c ctrlclient.Client,
 	pvcList transfer.PVCList,
 	t transport.Transport,
@@ -163,36 +421,162 @@ func NewClient(ctx context.Context, c ctrlclient.Client,
 	labels map[string]string,
 	ownerRefs []metav1.OwnerReference,
 	podOptions transfer.PodOptions) (transfer.Client, error) {
+	return NewClientFromOptions(ctx, c, logger, ClientOptions{
+		PVCList:         pvcList,
+		Transport:       t,
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		PodOptions:      podOptions,
+	})
+}
+
+// NewClientWithOptions is NewClient with the rsync mode selected in options.
+//
+// Deprecated: use NewClientFromOptions, nameSuffix is not used.
+func NewClientWithOptions(ctx context.Context, c ctrlclient.Client,
+	pvcList transfer.PVCList,
+	t transport.Transport,
+	logger logr.Logger,
+	nameSuffix string,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	podOptions transfer.PodOptions,
+	options Options) (transfer.Client, error) {
+	return NewClientFromOptions(ctx, c, logger, ClientOptions{
+		PVCList:         pvcList,
+		Transport:       t,
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		PodOptions:      podOptions,
+		Options:         options,
+	})
+}
+
+// NewClientFromOptions creates the resources of the rsync client of clientOptions, see
+// ClientOptions. All the PVCs in the list will have rsync running against the server to sync
+// their data. In ModeSSH Options.SSHCredentials must reference a copy of the secret returned by
+// SSHCredentials of the server, in the namespace of the client.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=pods;serviceaccounts;secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get;use
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+func NewClientFromOptions(ctx context.Context, c ctrlclient.Client, logger logr.Logger, clientOptions ClientOptions) (transfer.Client, error) {
+	err := clientOptions.Validate()
+	if err != nil {
+		return nil, err
+	}
+	pvcList, t, podOptions, options := clientOptions.PVCList, clientOptions.Transport, clientOptions.PodOptions, clientOptions.Options
+	mode, err := getMode(options)
+	if err != nil {
+		return nil, err
+	}
+	if mode == ModeSSH && options.SSHCredentials.Name == "" {
+		return nil, ErrSSHCredentialsMissing
+	}
+	podOptions, err = transfer.ResolveArchitecture(ctx, c, pvcList, podOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = transport.ValidateImage(getRsyncImage(podOptions), podOptions.RequireImageDigests)
+	if err != nil {
+		return nil, err
+	}
+	err = validateServiceMeshMode(podOptions, t)
+	if err != nil {
+		return nil, err
+	}
+	err = podOptions.GetRetryPolicy().Validate()
+	if err != nil {
+		return nil, err
+	}
+	err = validateOS(podOptions, options)
+	if err != nil {
+		return nil, err
+	}
+	err = podOptions.ValidateExtraVolumes(reservedMountPaths...)
+	if err != nil {
+		return nil, err
+	}
+	err = podOptions.ValidatePVCOverrides()
+	if err != nil {
+		return nil, err
+	}
+	err = validateFanOutClient(podOptions, options, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePullClient(podOptions, options, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = validateSuccessExitCodes(options)
+	if err != nil {
+		return nil, err
+	}
+	err = validateIntegrityManifest(options)
+	if err != nil {
+		return nil, err
+	}
+	err = validateStreams(options, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = validateImageProfile(podOptions, options)
+	if err != nil {
+		return nil, err
+	}
 	tc := &client{
-		username:        "root",
-		pvcList:         pvcList,
-		transportClient: t,
-		nameSuffix:      nameSuffix,
-		labels:          labels,
-		ownerRefs:       ownerRefs,
-		options:         podOptions,
-		logger:          logger,
+		mode:                mode,
+		sshCredentials:      options.SSHCredentials,
+		agent:               options.Agent,
+		fanOutClient:        options.FanOutClient,
+		fanOutCredentials:   options.FanOutCredentials,
+		pull:                options.Pull,
+		successExitCodes:    options.SuccessExitCodes,
+		integrityManifest:   options.IntegrityManifest,
+		streams:             options.Streams,
+		destinationCapacity: options.DestinationCapacity,
+		username:            "root",
+		pvcList:             pvcList,
+		transportClient:     t,
+		labels:              clientOptions.Labels,
+		ownerRefs:           clientOptions.OwnerReferences,
+		options:             podOptions,
+		logger:              logger,
 	}
 
-	var namespace string
-	namespaces := pvcList.Namespaces()
-	if len(namespaces) > 0 {
-		namespace = namespaces[0]
+	namespace, err := getNamespace(pvcList)
+	if err != nil {
+		return nil, err
 	}
+	tc.namespace = namespace
 
-	for _, ns := range namespaces {
-		if ns != namespace {
-			return nil, fmt.Errorf("PVC list provided has pvcs in different namespaces which is not supported")
+	tc.nameSuffix = transfer.NamespaceHashForNames(pvcList)[namespace][:10]
+	if !podOptions.AllowPVCInUse {
+		ignoredPods := []ctrlclient.ObjectKey{tc.scanPodName()}
+		for _, podKey := range tc.podKeysByPVC() {
+			ignoredPods = append(ignoredPods, podKey)
+		}
+		err = transfer.CheckPVCsNotInUse(ctx, c, pvcList, ignoredPods...)
+		if err != nil {
+			tc.logger.Error(err, "source PVCs are in use, set AllowPVCInUse to transfer anyway")
+			return nil, err
 		}
 	}
-
-	if namespace == "" {
-		return nil, fmt.Errorf("ether PVC list is empty or namespace is not specified")
+	err = tc.reconcile(ctx, c)
+	if err != nil {
+		return nil, err
 	}
-	tc.namespace = namespace
 
-	tc.nameSuffix = transfer.NamespaceHashForNames(pvcList)[namespace][:10]
+	return tc, nil
+}
+
+// reconcile reconciles all the resources of the client, the client pod last
+func (tc *client) reconcile(ctx context.Context, c ctrlclient.Client) error {
 	reconcilers := []reconcileFunc{
+		tc.reconcileServiceAccount,
 		tc.reconcilePod,
 	}
 
@@ -200,17 +584,56 @@ func NewClient(ctx context.Context, c ctrlclient.Client,
 		err := reconcile(ctx, c, tc.namespace)
 		if err != nil {
 			tc.logger.Error(err, "error reconciling rsyncServer")
-			return nil, err
+			return err
 		}
 	}
+	return nil
+}
 
-	return tc, nil
+// Reconcile restores the resources of the client and of its transport, e.g. after they were
+// edited mid-transfer, and restarts the client pod when the ConfigMaps and Secrets it mounts
+// changed since it was created. The pod is recreated by the next call.
+func (tc *client) Reconcile(ctx context.Context, c ctrlclient.Client) error {
+	if r, ok := tc.Transport().(transport.Reconciler); ok {
+		err := r.Reconcile(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return tc.reconcile(ctx, c)
+}
+
+func (tc *client) reconcileServiceAccount(ctx context.Context, c ctrlclient.Client, namespace string) error {
+	return reconcileServiceAccount(ctx, c, tc.logger, namespace, tc.nameSuffix, tc.labels, tc.ownerRefs, &tc.options)
 }
 
 // TODO: add retries
 func (tc *client) reconcilePod(ctx context.Context, c ctrlclient.Client, ns string) error {
 	var errs []error
 
+	state, err := getState(ctx, c, tc.stateName())
+	if err != nil {
+		return err
+	}
+	switch {
+	case getStateTime(state, cancelledAtKey) != nil:
+		tc.logger.Info("rsync client is cancelled, skipping the client pod")
+		return nil
+	case getStateTime(state, pausedAtKey) != nil:
+		tc.logger.Info("rsync client is paused, skipping the client pod")
+		return nil
+	}
+	if tc.options.PreScan {
+		scanned, err := tc.reconcileScan(ctx, c, state)
+		if err != nil || !scanned {
+			return err
+		}
+		err = tc.checkDestinationCapacity(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+
 	rsyncOptions, err := rsyncDefaultOptions()
 	if err != nil {
 		tc.logger.Error(err, "unable to get default options for rsync command")
@@ -225,13 +648,24 @@ func (tc *client) reconcilePod(ctx context.Context, c ctrlclient.Client, ns stri
 	}
 
 	for _, pvc := range tc.pvcList.InNamespace(ns).PVCs() {
+		options := tc.options.ForPVC(pvc)
+		pvcRsyncOptions := withSparseDefault(rsyncOptions, pvc)
 		// create Rsync command for PVC
-		rsyncContainerCommand := tc.getCommand(rsyncOptions, pvc)
+		rsyncContainerCommand := tc.getCommand(pvcRsyncOptions, pvc)
+		var rsyncContainerEnv []corev1.EnvVar
+		if tc.agent {
+			rsyncContainerCommand, rsyncContainerEnv, err = tc.getAgentCommand(pvcRsyncOptions, pvc)
+			if err != nil {
+				return err
+			}
+		}
+		rsyncContainerCommand = withIOPriority(rsyncContainerCommand, options)
 
 		volumeMounts := []corev1.VolumeMount{
 			{
 				Name:      "mnt",
 				MountPath: fmt.Sprintf("/mnt/%s/%s", pvc.Claim().Namespace, pvc.LabelSafeName()),
+				ReadOnly:  options.ReadOnlySource,
 			},
 			{
 				Name:      "rsync-communication",
@@ -239,21 +673,37 @@ func (tc *client) reconcilePod(ctx context.Context, c ctrlclient.Client, ns stri
 			},
 		}
 		volumeMounts = append(volumeMounts, getTerminationVolumeMounts()...)
+		if tc.mode == ModeSSH {
+			volumeMounts = append(volumeMounts, getSSHKeysVolumeMount())
+		}
+		if tc.fanOutClient != "" {
+			volumeMounts = append(volumeMounts, getFanOutPasswordVolumeMount())
+		}
+		if terminatesOnCompletion(tc.Transport()) {
+			volumeMounts = append(volumeMounts, getCompletionVolumeMount())
+		}
 		// create rsync container
 		containers := []corev1.Container{
 			{
 				Name:         RsyncContainer,
 				Command:      rsyncContainerCommand,
+				Env:          rsyncContainerEnv,
 				VolumeMounts: volumeMounts,
 			},
 		}
-		// attach transport containers
-		err := customizeTransportClientContainers(tc.Transport())
-		if err != nil {
-			tc.logger.Error(err, "unable to customize Transport client containers for rsync client pod")
-			return err
+		applyContainerOptions(containers, options)
+		applyFakeSuper(containers, options)
+		// attach transport containers, native sidecars are stopped by Kubernetes
+		containers, initContainers := getTransportContainers(tc.Transport(), containers)
+		if !terminatesOnCompletion(tc.Transport()) && !runsAsNativeSidecar(tc.Transport()) {
+			err := customizeTransportClientContainers(tc.Transport().Type(), containers)
+			if err != nil {
+				tc.logger.Error(err, "unable to customize Transport client containers for rsync client pod")
+				return err
+			}
 		}
-		containers = append(containers, tc.Transport().Containers()...)
+		applySELinuxOptions(containers, options)
+		applySELinuxOptions(initContainers, options)
 
 		volumes := []corev1.Volume{
 			{
@@ -261,6 +711,7 @@ func (tc *client) reconcilePod(ctx context.Context, c ctrlclient.Client, ns stri
 				VolumeSource: corev1.VolumeSource{
 					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 						ClaimName: pvc.Claim().Name,
+						ReadOnly:  options.ReadOnlySource,
 					},
 				},
 			},
@@ -273,27 +724,60 @@ func (tc *client) reconcilePod(ctx context.Context, c ctrlclient.Client, ns stri
 		}
 		volumes = append(volumes, tc.Transport().Volumes()...)
 		volumes = append(volumes, getTerminationVolumes()...)
+		if tc.mode == ModeSSH {
+			volumes = append(volumes, getSSHKeysVolume(tc.sshCredentials.Name, sshClientKey, sshKnownHosts))
+		}
+		if tc.fanOutClient != "" {
+			volumes = append(volumes, getFanOutPasswordVolume(tc.fanOutCredentials.Name))
+		}
+		err = options.ValidateExtraVolumeNames(volumes)
+		if err != nil {
+			return err
+		}
 
 		podSpec := corev1.PodSpec{
+			InitContainers:     initContainers,
 			Containers:         containers,
 			Volumes:            volumes,
 			RestartPolicy:      corev1.RestartPolicyNever,
-			ServiceAccountName: tc.options.ServiceAccountName,
+			ServiceAccountName: options.ServiceAccountName,
+			ImagePullSecrets:   getImagePullSecrets(options, tc.Transport()),
 		}
 
-		applyPodOptions(&podSpec, tc.options)
+		applyPodOptions(&podSpec, options)
 
 		pod := corev1.Pod{
 			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("rsync-client-%s", tc.nameSuffix),
+				Name:      tc.podKey(pvc).Name,
 				Namespace: pvc.Claim().Namespace,
 			},
 		}
 
-		_, err = ctrlutil.CreateOrUpdate(ctx, c, &pod, func() error {
-			pod.Labels = tc.labels
+		// pod specs are only set at creation, pods mounting a configuration rendered differently
+		// since then are deleted and recreated by the next reconcile
+		restarted, err := transfer.RestartOnDrift(ctx, c, tc.logger, ctrlclient.ObjectKeyFromObject(&pod))
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if restarted {
+			continue
+		}
+
+		configHash, err := transfer.PodConfigHash(ctx, c, ctrlclient.ObjectKeyFromObject(&pod), podSpec.Volumes)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		_, err = reconcile.CreateOrUpdate(ctx, c, tc.logger, &pod, reconcileOptions(options), func() error {
+			pod.Labels = getLabels(tc.labels, options)
 			// adding pvc name in annotation to avoid constraints on labels in naming
-			pod.Annotations = map[string]string{"pvc": pvc.Claim().Name}
+			pod.Annotations = transfer.WithConfigHash(getAnnotations(pod.Annotations, options), configHash)
+			pod.Annotations["pvc"] = pvc.Claim().Name
+			applyServiceMeshMode(&pod.ObjectMeta, options)
+			applySCC(&pod.ObjectMeta, options)
+			applyIOThrottle(&pod.ObjectMeta, options)
 			pod.OwnerReferences = tc.ownerRefs
 			if pod.CreationTimestamp.IsZero() {
 				pod.Spec = podSpec
@@ -316,61 +800,111 @@ func (tc *client) getCommand(rsyncOptions []string, pvc transfer.PVC) []string {
 	rsyncCommand := []string{"/usr/bin/rsync"}
 	rsyncCommand = append(rsyncCommand, rsyncOptions...)
 	rsyncCommand = append(rsyncCommand, fmt.Sprintf("/mnt/%s/%s/", pvc.Claim().Namespace, pvc.LabelSafeName()))
-	rsyncCommand = append(rsyncCommand,
-		fmt.Sprintf("rsync://%s@%s/%s/ --port %d",
-			tc.username,
-			tc.Transport().Hostname(),
-			pvc.LabelSafeName(), tc.Transport().ListenPort()))
+	connection := tc.Transport().ConnectionInfo()
+	rsyncCommand = append(rsyncCommand, getRsyncURL(tc.username, connection, pvc.LabelSafeName()))
 	rsyncTerminationCommand := fmt.Sprintf(
-		"/usr/bin/rsync /mnt/termination/done rsync://%s@%s/termination/ --port %d",
-		tc.username,
-		tc.Transport().Hostname(),
-		tc.Transport().ListenPort())
-	rsyncCommandBashScript := fmt.Sprintf(`trap "touch %s/rsync-client-container-done" EXIT SIGINT SIGTERM;
+		"/usr/bin/rsync %s %s", pvcTerminationFile(pvc), getRsyncURL(tc.username, connection, "termination"))
+	if tc.pull {
+		rsyncCommand = tc.getPullCommand(rsyncOptions, pvc)
+	}
+	if tc.fanOutClient != "" {
+		// fan-out servers keep serving other clients
+		rsyncCommand = tc.getFanOutCommand(rsyncOptions, pvc)
+		rsyncTerminationCommand = "true"
+	}
+	if tc.mode == ModeSSH {
+		sshCommand := getSSHCommand(connection.Port)
+		rsyncCommand = append(rsyncCommand[:len(rsyncCommand)-1],
+			"-e", fmt.Sprintf("%q", sshCommand),
+			fmt.Sprintf("%s@%s:%s/%s/", tc.username, connection.Hostname, sshDataMountPath, pvc.LabelSafeName()))
+		rsyncTerminationCommand = fmt.Sprintf("%s %s@%s touch %s",
+			sshCommand, tc.username, connection.Hostname, pvcTerminationFile(pvc))
+	}
+	// notify the transport that the transfer is done, transports which do not terminate
+	// on completion are customized to wait for the rsync communication file
+	doneFile := fmt.Sprintf("%s/rsync-client-container-done", rsyncCommunicationMountPath)
+	if terminatesOnCompletion(tc.Transport()) {
+		doneFile = transport.CompletionFile
+	}
+	shardsScript := ""
+	if tc.streams > 1 {
+		shardsScript = getShardsScript(rsyncCommand, tc.streams)
+		rsyncCommand = []string{shardsFunction}
+	}
+	retryPolicy := getRetryPolicy(tc.options)
+	rsyncCommandBashScript := fmt.Sprintf(`%strap "touch %s" EXIT SIGINT SIGTERM;
+SUCCESS_EXIT_CODES="%s"
 timeout=120;
 SECONDS=0;
 START_TIME=$SECONDS
-touch /mnt/termination/done
+touch %s
 while [ $SECONDS -lt $timeout ]
 do
-	nc -z localhost %d
+	nc -z %s %d
 	rc=$?
 	if [ $rc -eq 0 ]
 	then 
-		MAX_RETRIES=5
+		MAX_RETRIES=%d
 		RETRY=0
-		DELAY=2
-		FACTOR=2
-		rc=1
-		while [[ ${rc} -ne 0 && ${RETRY} -lt ${MAX_RETRIES} ]]
+		DELAY=%d
+		FACTOR=%d
+		MAX_DELAY=%d
+		RETRY_ON_EXIT_CODES="%s"
+		while true
 		do 
+			%s --stats 2>&1 | tee %s
+			rc=${PIPESTATUS[0]}
+			if [[ " ${SUCCESS_EXIT_CODES} " =~ " ${rc} " || ${RETRY} -ge ${MAX_RETRIES} ]]; then
+				break
+			fi
+			if [[ -n "${RETRY_ON_EXIT_CODES}" && ! " ${RETRY_ON_EXIT_CODES} " =~ " ${rc} " ]]; then
+				echo "Synchronization failed with exit code ${rc} which is not retried."
+				break
+			fi
 			RETRY=$((RETRY+1))
-			%s
-			rc=$?
-			if [[ ${rc} -ne 0 ]]; then
-				echo "Synchronization failed. Retrying in ${DELAY} seconds. Retry ${RETRY}/${MAX_RETRIES}."
-				if [[ ${RETRY} -lt ${MAX_RETRIES} ]]; then
-					sleep ${DELAY}
-					DELAY=$((DELAY * FACTOR ))
-				fi
+			echo "Synchronization failed. Retrying in ${DELAY} seconds. Retry ${RETRY}/${MAX_RETRIES}."
+			sleep ${DELAY}
+			DELAY=$((DELAY * FACTOR))
+			if [[ ${MAX_DELAY} -gt 0 && ${DELAY} -gt ${MAX_DELAY} ]]; then
+				DELAY=${MAX_DELAY}
 			fi
 		done 
 		break
 	fi
 done
 echo "Rsync completed in $(( SECONDS - START_TIME ))s"
+%s
+echo "%s=${rc}" >> /dev/termination-log
+%s
 sync
-if [[ $rc -eq 0 ]]; then
-    echo "Synchronization completed successfully. Notifying destination..."
+if [[ " ${SUCCESS_EXIT_CODES} " =~ " ${rc} " ]]; then
+    if [[ $rc -ne 0 ]]; then
+        echo "Synchronization completed with warnings. rsync returned: $rc"
+    fi
+%s    echo "Synchronization completed successfully. Notifying destination..."
     %s
 else
     echo "Synchronization failed. rsync returned: $rc"
     exit $rc
 fi
 `,
-		rsyncCommunicationMountPath,
-		tc.Transport().ListenPort(),
+		shardsScript,
+		doneFile,
+		formatExitCodes(getSuccessExitCodes(tc.successExitCodes)),
+		pvcTerminationFile(pvc),
+		connection.Hostname,
+		connection.Port,
+		retryPolicy.MaxRetries,
+		retryPolicy.InitialDelaySeconds,
+		retryPolicy.Factor,
+		retryPolicy.MaxDelaySeconds,
+		formatExitCodes(retryPolicy.RetryOnExitCodes),
 		strings.Join(rsyncCommand, " "),
+		rsyncStatsFile,
+		fmt.Sprintf(rsyncStatsScript, rsyncStatsFile),
+		rsyncExitCodeField,
+		fmt.Sprintf(destinationFullScript, rsyncStatsFile),
+		tc.getManifestScript(pvc),
 		rsyncTerminationCommand)
 	rsyncContainerCommand := []string{
 		"/bin/bash",
@@ -380,13 +914,117 @@ fi
 	return rsyncContainerCommand
 }
 
-// customizeTransportClientContainers customizes transport's client containers for specific rsync communication
-func customizeTransportClientContainers(transportClient transport.Transport) error {
-	switch transportClient.Type() {
+// formatExitCodes returns the exit codes separated by spaces for the rsync script
+func formatExitCodes(codes []int32) string {
+	formatted := make([]string, 0, len(codes))
+	for _, code := range codes {
+		formatted = append(formatted, strconv.Itoa(int(code)))
+	}
+	return strings.Join(formatted, " ")
+}
+
+// rsyncStatsFile holds the output of the last rsync attempt
+const rsyncStatsFile = rsyncCommunicationMountPath + "/rsync-stats"
+
+// rsyncStatsScript writes the statistics of the last rsync attempt to the termination message
+// of the rsync container, see parseTerminationMessage and parseStats. The statistics of the
+// parallel streams of an attempt are summed, their speedup is computed from the sums.
+const rsyncStatsScript = `awk -F': ' 'function num(s, a) {split(s, a, " "); gsub(/[^0-9]/, "", a[1]); return a[1] + 0}
+/^Number of files:/ {total_files+=num($2)}
+/^Number of created files:/ {created+=num($2)}
+/^Number of deleted files:/ {deleted+=num($2)}
+/^Number of regular files transferred:/ {files+=num($2)}
+/^Total file size:/ {total_size+=num($2)}
+/^Total transferred file size:/ {bytes+=num($2)}
+/^Total bytes sent:/ {sent+=num($2)}
+/^Total bytes received:/ {received+=num($2)}
+/speedup is/ {streams++; speedup=$0; sub(/.*speedup is /, "", speedup); sub(/ .*/, "", speedup); gsub(/,/, "", speedup)}
+END {if (streams > 1 && sent + received > 0) speedup=sprintf("%%.2f", total_size / (sent + received))
+printf "files=%%d bytes=%%d total_files=%%d total_size=%%d created=%%d deleted=%%d sent=%%d received=%%d speedup=%%s\n",
+files, bytes, total_files, total_size, created, deleted, sent, received, speedup}' %s > /dev/termination-log 2> /dev/null`
+
+// parseTerminationFields returns the key=value fields of the termination message of a container
+func parseTerminationFields(message string) map[string]string {
+	fields := map[string]string{}
+	for _, field := range strings.Fields(message) {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fields[kv[0]] = kv[1]
+	}
+	return fields
+}
+
+// parseTerminationMessage returns the number of files and bytes written by rsyncStatsScript
+// in the termination message of the rsync container
+func parseTerminationMessage(message string) (files, bytes int64) {
+	fields := parseTerminationFields(message)
+	files, _ = strconv.ParseInt(fields["files"], 10, 64)
+	bytes, _ = strconv.ParseInt(fields["bytes"], 10, 64)
+	return files, bytes
+}
+
+// parseStats returns the statistics written by rsyncStatsScript in the termination message of
+// the rsync container, it returns nil when the message holds none of them
+func parseStats(message string) *transfer.Stats {
+	stats := &transfer.Stats{}
+	found := false
+	for key, value := range parseTerminationFields(message) {
+		var field *int64
+		switch key {
+		case "files":
+			field = &stats.RegularFilesTransferred
+		case "bytes":
+			field = &stats.TotalTransferredFileSize
+		case "total_files":
+			field = &stats.NumberOfFiles
+		case "total_size":
+			field = &stats.TotalFileSize
+		case "created":
+			field = &stats.CreatedFiles
+		case "deleted":
+			field = &stats.DeletedFiles
+		case "sent":
+			field = &stats.BytesSent
+		case "received":
+			field = &stats.BytesReceived
+		case "speedup":
+			speedup, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				stats.Speedup = speedup
+				found = true
+			}
+			continue
+		default:
+			continue
+		}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		*field = parsed
+		found = true
+	}
+	if !found {
+		return nil
+	}
+	return stats
+}
+
+// getRsyncURL returns the rsync daemon URL of module reached through the transport connection
+func getRsyncURL(username string, connection transport.ConnectionInfo, module string) string {
+	return fmt.Sprintf("rsync://%s@%s/%s/ --port %d", username, connection.Hostname, module, connection.Port)
+}
+
+// customizeTransportClientContainers customizes transport's client containers of an rsync client pod for
+// specific rsync communication, it is only required for transports which do not terminate on transfer completion
+func customizeTransportClientContainers(transportType transport.Type, containers []corev1.Container) error {
+	switch transportType {
 	case stunnel.TransportTypeStunnel:
 		var stunnelContainer *corev1.Container
-		for i := range transportClient.Containers() {
-			c := &transportClient.Containers()[i]
+		for i := range containers {
+			c := &containers[i]
 			if c.Name == stunnel.Container {
 				stunnelContainer = c
 			}
@@ -404,6 +1042,7 @@ if [ $? -eq 0 ]
 then
 break
 fi
+sleep 1
 done
 exit 0`, rsyncCommunicationMountPath),
 		}
@@ -4,20 +4,32 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/backube/pvc-transfer/transfer"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/backube/pvc-transfer/transport/stunnel"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type fakeTransportClient struct {
-	transportType transport.Type
+	transportType          transport.Type
+	terminatesOnCompletion bool
+	nativeSidecar          bool
+}
+
+func (f *fakeTransportClient) TerminatesOnCompletion() bool {
+	return f.terminatesOnCompletion
+}
+
+func (f *fakeTransportClient) RunsAsNativeSidecar() bool {
+	return f.nativeSidecar
 }
 
 func (f *fakeTransportClient) NamespacedName() types.NamespacedName {
@@ -54,8 +66,20 @@ func (f *fakeTransportClient) Hostname() string {
 	return "foo.bar.dev"
 }
 
+func (f *fakeTransportClient) ConnectionInfo() transport.ConnectionInfo {
+	return transport.ConnectionInfo{
+		Scheme:   transport.SchemeTCP,
+		Hostname: f.Hostname(),
+		Port:     f.ListenPort(),
+	}
+}
+
+func (f *fakeTransportClient) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	return true, nil
+}
+
 func (f *fakeTransportClient) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, value string) error {
-	panic("implement me")
+	return nil
 }
 
 func Test_client_reconcilePod(t *testing.T) {
@@ -136,7 +160,7 @@ func Test_client_reconcilePod(t *testing.T) {
 			objects: []ctrlclient.Object{
 				&corev1.Pod{
 					ObjectMeta: metav1.ObjectMeta{
-						Name:            "rsync-client-foo",
+						Name:            "rsync-client-foo-data",
 						Namespace:       "foo",
 						Annotations:     map[string]string{"pvc": "test-pvc"},
 						Labels:          map[string]string{"test": "me"},
@@ -151,7 +175,7 @@ func Test_client_reconcilePod(t *testing.T) {
 			fakeClient := fakeClientWithObjects(tt.objects...)
 			ctx := context.WithValue(context.Background(), "test", tt.name)
 			s := &client{
-				logger:          logrtesting.TestLogger{t},
+				logger:          testr.New(t),
 				username:        tt.username,
 				pvcList:         tt.pvcList,
 				nameSuffix:      tt.nameSuffix,
@@ -166,7 +190,7 @@ func Test_client_reconcilePod(t *testing.T) {
 			pod := &corev1.Pod{}
 			err := fakeClient.Get(context.Background(), types.NamespacedName{
 				Namespace: tt.namespace,
-				Name:      "rsync-client-foo",
+				Name:      "rsync-client-foo-data",
 			}, pod)
 			if err != nil {
 				panic(fmt.Errorf("%#v should not be getting error from fake client", err))
@@ -178,9 +202,618 @@ func Test_client_reconcilePod(t *testing.T) {
 			if !reflect.DeepEqual(pod.OwnerReferences, tt.ownerRefs) {
 				t.Error("pod does not have the right owner references")
 			}
-			if !reflect.DeepEqual(pod.Annotations, map[string]string{"pvc": tt.pvcList.PVCs()[0].Claim().Name}) {
+			if pod.Annotations["pvc"] != tt.pvcList.PVCs()[0].Claim().Name || len(pod.Annotations) != 2 {
 				t.Error("pod does not have the right annotations")
 			}
+			if pod.Annotations[transfer.ConfigHashAnnotation] == "" {
+				t.Error("pod does not have the config hash annotation")
+			}
+		})
+	}
+}
+
+func Test_client_reconcilePod_terminatesOnCompletion(t *testing.T) {
+	tests := []struct {
+		name                   string
+		terminatesOnCompletion bool
+		wantDoneFile           string
+		wantCompletionMount    bool
+	}{
+		{
+			name:         "transport not terminating on completion, must create the rsync communication file",
+			wantDoneFile: rsyncCommunicationMountPath + "/rsync-client-container-done",
+		},
+		{
+			name:                   "transport terminating on completion, must create the completion file",
+			terminatesOnCompletion: true,
+			wantDoneFile:           transport.CompletionFile,
+			wantCompletionMount:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			tc := &client{
+				logger:   testr.New(t),
+				username: "root",
+				pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+				}),
+				nameSuffix: "foo",
+				transportClient: &fakeTransportClient{
+					transportType:          stunnel.TransportTypeStunnel,
+					terminatesOnCompletion: tt.terminatesOnCompletion,
+				},
+			}
+			if err := tc.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			pod := &corev1.Pod{}
+			err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}, pod)
+			if err != nil {
+				t.Fatalf("unable to get pod %v", err)
+			}
+			for _, container := range pod.Spec.Containers {
+				if container.Name == RsyncContainer {
+					script := container.Command[len(container.Command)-1]
+					if !strings.Contains(script, fmt.Sprintf("trap \"touch %s\"", tt.wantDoneFile)) {
+						t.Errorf("rsync script does not create %s", tt.wantDoneFile)
+					}
+					hasCompletionMount := false
+					for _, mount := range container.VolumeMounts {
+						if mount.Name == transport.CompletionVolumeName {
+							hasCompletionMount = true
+						}
+					}
+					if hasCompletionMount != tt.wantCompletionMount {
+						t.Errorf("rsync completion volume mounted = %v, want %v", hasCompletionMount, tt.wantCompletionMount)
+					}
+				}
+			}
+		})
+	}
+}
+
+func Test_client_reconcilePod_nativeSidecar(t *testing.T) {
+	for _, nativeSidecar := range []bool{false, true} {
+		t.Run(fmt.Sprintf("native sidecar %v", nativeSidecar), func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			tc := &client{
+				logger:     testr.New(t),
+				username:   "root",
+				pvcList:    transfer.NewSingletonPVC(testPVC("foo", "test-pvc")),
+				nameSuffix: "foo",
+				transportClient: &fakeTransportClient{
+					transportType: stunnel.TransportTypeStunnel,
+					nativeSidecar: nativeSidecar,
+				},
+			}
+			if err := tc.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			pod := &corev1.Pod{}
+			err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}, pod)
+			if err != nil {
+				t.Fatalf("unable to get pod %v", err)
+			}
+			containers, initContainers := []string{}, []string{}
+			for _, container := range pod.Spec.Containers {
+				containers = append(containers, container.Name)
+			}
+			for _, container := range pod.Spec.InitContainers {
+				initContainers = append(initContainers, container.Name)
+				if container.RestartPolicy == nil || *container.RestartPolicy != corev1.ContainerRestartPolicyAlways {
+					t.Errorf("init container %s is not a native sidecar", container.Name)
+				}
+			}
+			wantContainers, wantInitContainers := []string{RsyncContainer, stunnel.Container}, []string{}
+			if nativeSidecar {
+				// Kubernetes stops the transport once rsync exited
+				wantContainers, wantInitContainers = []string{RsyncContainer}, []string{stunnel.Container}
+			}
+			if !reflect.DeepEqual(containers, wantContainers) || !reflect.DeepEqual(initContainers, wantInitContainers) {
+				t.Errorf("pod containers = %v, init containers = %v, want %v, %v",
+					containers, initContainers, wantContainers, wantInitContainers)
+			}
+		})
+	}
+}
+
+func Test_client_reconcilePod_transportContainers(t *testing.T) {
+	ctx := context.Background()
+	pvcList, err := transfer.NewPVCList(testPVC("foo", "data"), testPVC("foo", "logs"), testPVC("foo", "cache"))
+	if err != nil {
+		t.Fatalf("unable to create pvc list %v", err)
+	}
+	fakeClient := fakeClientWithObjects()
+	stunnelClient, err := stunnel.NewClient(ctx, fakeClient, testr.New(t),
+		types.NamespacedName{Namespace: "foo", Name: "foo"}, "example.com", 443,
+		&transport.Options{Labels: map[string]string{"test": "me"}, Owners: testOwnerReferences()})
+	if err != nil {
+		t.Fatalf("stunnel.NewClient() error = %v", err)
+	}
+	tc := &client{
+		logger:          testr.New(t),
+		username:        "root",
+		pvcList:         pvcList,
+		nameSuffix:      "foo",
+		transportClient: stunnelClient,
+	}
+	if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	for _, pvc := range pvcList.PVCs() {
+		pod := &corev1.Pod{}
+		if err := fakeClient.Get(ctx, tc.podKey(pvc), pod); err != nil {
+			t.Fatalf("unable to get the client pod of PVC %s: %v", pvc.Claim().Name, err)
+		}
+		for _, container := range pod.Spec.Containers {
+			mountPaths := map[string]bool{}
+			for _, volumeMount := range container.VolumeMounts {
+				if mountPaths[volumeMount.MountPath] {
+					t.Errorf("container %s of the client pod of PVC %s mounts %s twice",
+						container.Name, pvc.Claim().Name, volumeMount.MountPath)
+				}
+				mountPaths[volumeMount.MountPath] = true
+			}
+			if container.Name == stunnel.Container && !mountPaths[rsyncCommunicationMountPath] {
+				t.Errorf("container %s of the client pod of PVC %s does not mount %s",
+					container.Name, pvc.Claim().Name, rsyncCommunicationMountPath)
+			}
+		}
+	}
+	// the containers of the transport are shared by the pods of all the PVCs
+	for _, container := range stunnelClient.Containers() {
+		for _, volumeMount := range container.VolumeMounts {
+			if volumeMount.MountPath == rsyncCommunicationMountPath {
+				t.Errorf("transport container %s was customized for an rsync client pod", container.Name)
+			}
+		}
+	}
+}
+
+func Test_client_reconcilePod_readOnlySource(t *testing.T) {
+	for _, readOnly := range []bool{false, true} {
+		t.Run(fmt.Sprintf("read only source %v", readOnly), func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			tc := &client{
+				logger:   testr.New(t),
+				username: "root",
+				pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+				}),
+				nameSuffix:      "foo",
+				options:         transfer.PodOptions{ReadOnlySource: readOnly},
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			}
+			if err := tc.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			pod := &corev1.Pod{}
+			err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}, pod)
+			if err != nil {
+				t.Fatalf("unable to get pod %v", err)
+			}
+			for _, volume := range pod.Spec.Volumes {
+				if volume.Name == "mnt" && volume.PersistentVolumeClaim.ReadOnly != readOnly {
+					t.Errorf("source volume read only = %v, want %v", volume.PersistentVolumeClaim.ReadOnly, readOnly)
+				}
+			}
+			for _, mount := range pod.Spec.Containers[0].VolumeMounts {
+				if mount.Name == "mnt" && mount.ReadOnly != readOnly {
+					t.Errorf("source volume mount read only = %v, want %v", mount.ReadOnly, readOnly)
+				}
+			}
+		})
+	}
+}
+
+func Test_parseTerminationMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		message   string
+		wantFiles int64
+		wantBytes int64
+	}{
+		{
+			name:      "stats written by the rsync script, must be parsed",
+			message:   "files=1032 bytes=4567890\n",
+			wantFiles: 1032,
+			wantBytes: 4567890,
+		},
+		{
+			name:    "no termination message, must return zeros",
+			message: "",
+		},
+		{
+			name:      "malformed fields, must be skipped",
+			message:   "files=many bytes=12 garbage",
+			wantBytes: 12,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files, bytes := parseTerminationMessage(tt.message)
+			if files != tt.wantFiles || bytes != tt.wantBytes {
+				t.Errorf("parseTerminationMessage() = %v, %v, want %v, %v", files, bytes, tt.wantFiles, tt.wantBytes)
+			}
+		})
+	}
+}
+
+func Test_parseStats(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    *transfer.Stats
+	}{
+		{
+			name:    "stats written by the rsync script, must be parsed",
+			message: "files=5 bytes=1234 total_files=1234 total_size=123456 created=10 deleted=3 sent=2345 received=123 speedup=50.02\n",
+			want: &transfer.Stats{
+				NumberOfFiles:            1234,
+				TotalFileSize:            123456,
+				CreatedFiles:             10,
+				DeletedFiles:             3,
+				RegularFilesTransferred:  5,
+				TotalTransferredFileSize: 1234,
+				BytesSent:                2345,
+				BytesReceived:            123,
+				Speedup:                  50.02,
+			},
+		},
+		{
+			name:    "stats written by older rsync scripts, must be parsed",
+			message: "files=5 bytes=1234",
+			want:    &transfer.Stats{RegularFilesTransferred: 5, TotalTransferredFileSize: 1234},
+		},
+		{
+			name:    "missing speedup, must be skipped",
+			message: "sent=10 speedup=",
+			want:    &transfer.Stats{BytesSent: 10},
+		},
+		{
+			name:    "no termination message, must return nil",
+			message: "",
+			want:    nil,
+		},
+		{
+			name:    "unknown fields, must return nil",
+			message: "Synchronization failed",
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseStats(tt.message); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseStats() = %v, want %v", got, tt.want)
+			}
 		})
 	}
 }
+
+func Test_client_getCommand_retryPolicy(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tests := []struct {
+		name        string
+		retryPolicy *transfer.RetryPolicy
+		want        []string
+	}{
+		{
+			name: "no retry policy, must render the default policy retrying the retryable exit codes",
+			want: []string{"MAX_RETRIES=4\n", "DELAY=2\n", "FACTOR=2\n", "MAX_DELAY=60\n", "RETRY_ON_EXIT_CODES=\"10 12 23 24 30 35\"\n", "SUCCESS_EXIT_CODES=\"0 24\"\n"},
+		},
+		{
+			name:        "retry policy without exit codes, must retry all failures",
+			retryPolicy: &transfer.RetryPolicy{MaxRetries: 3, InitialDelaySeconds: 5, Factor: 1},
+			want:        []string{"MAX_RETRIES=3\n", "RETRY_ON_EXIT_CODES=\"\"\n"},
+		},
+		{
+			name: "retry policy, must render the policy",
+			retryPolicy: &transfer.RetryPolicy{
+				MaxRetries: 10, InitialDelaySeconds: 5, Factor: 3, MaxDelaySeconds: 300, RetryOnExitCodes: []int32{12, 30},
+			},
+			want: []string{"MAX_RETRIES=10\n", "DELAY=5\n", "FACTOR=3\n", "MAX_DELAY=300\n", "RETRY_ON_EXIT_CODES=\"12 30\"\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &client{
+				username:        "root",
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+				options:         transfer.PodOptions{RetryPolicy: tt.retryPolicy},
+			}
+			script := tc.getCommand([]string{"-a"}, pvc)[2]
+			for _, want := range tt.want {
+				if !strings.Contains(script, want) {
+					t.Errorf("rsync script does not contain %q", want)
+				}
+			}
+		})
+	}
+}
+
+func Test_client_reconcilePod_pvcOverrides(t *testing.T) {
+	largeResources := corev1.ResourceRequirements{
+		Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("4Gi")},
+	}
+	fakeClient := fakeClientWithObjects()
+	tc := &client{
+		logger:   testr.New(t),
+		username: "root",
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "large", Namespace: "foo"},
+		}),
+		nameSuffix:      "foo",
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+		options: transfer.PodOptions{
+			Resources: corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("256Mi")},
+			},
+			PVCOverrides: map[string]transfer.PodOptionsOverride{
+				"foo/large": {Resources: &largeResources, PriorityClassName: "migration-high"},
+			},
+		},
+	}
+	if err := tc.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	pod := &corev1.Pod{}
+	err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}, pod)
+	if err != nil {
+		t.Fatalf("unable to get pod %v", err)
+	}
+	if pod.Spec.PriorityClassName != "migration-high" {
+		t.Errorf("pod priority class = %s, want migration-high", pod.Spec.PriorityClassName)
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == RsyncContainer && !container.Resources.Requests.Memory().Equal(resource.MustParse("4Gi")) {
+			t.Errorf("rsync container resources = %v, want %v", container.Resources, largeResources)
+		}
+	}
+}
+
+func Test_client_Status_exitCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		exitCode      int32
+		wantReason    string
+		wantRetryable bool
+	}{
+		{name: "success, must be successful", exitCode: 0, wantReason: "Success"},
+		{name: "vanished files, must be a retryable failure", exitCode: 24, wantReason: "VanishedFiles", wantRetryable: true},
+		{name: "authentication failure, must not be retryable", exitCode: 5, wantReason: "StartupFailed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "rsync-client-foo-data", Namespace: "foo", Labels: map[string]string{"test": "me"}},
+				Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+					Name:  RsyncContainer,
+					State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: tt.exitCode}},
+				}}},
+			}
+			tc := &client{
+				logger:     testr.New(t),
+				pvcList:    transfer.NewSingletonPVC(testPVC("foo", "data")),
+				nameSuffix: "foo",
+				namespace:  "foo",
+				labels:     map[string]string{"test": "me"},
+			}
+			status, err := tc.Status(context.Background(), fakeClientWithObjects(pod))
+			if err != nil || status.Completed == nil {
+				t.Fatalf("Status() = %v, %v, want completed", status, err)
+			}
+			completed := status.Completed
+			if completed.Successful != (tt.exitCode == 0) || completed.Failure != (tt.exitCode != 0) {
+				t.Errorf("Status() successful = %v, failure = %v for exit code %d", completed.Successful, completed.Failure, tt.exitCode)
+			}
+			if completed.Reason != tt.wantReason || completed.Retryable != tt.wantRetryable {
+				t.Errorf("Status() reason = %s, retryable = %v, want %s, %v", completed.Reason, completed.Retryable, tt.wantReason, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func Test_client_Status_pvcs(t *testing.T) {
+	ctx := context.Background()
+	pvcList, err := transfer.NewPVCList(testPVC("foo", "data"), testPVC("foo", "logs"), testPVC("foo", "cache"))
+	if err != nil {
+		t.Fatalf("unable to create pvc list %v", err)
+	}
+	fakeClient := fakeClientWithObjects()
+	tc, err := NewClientFromOptions(ctx, fakeClient, testr.New(t), ClientOptions{
+		PVCList:         pvcList,
+		Transport:       &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+		Labels:          map[string]string{"test": "me"},
+		OwnerReferences: testOwnerReferences(),
+	})
+	if err != nil {
+		t.Fatalf("NewClientFromOptions() error = %v", err)
+	}
+	pods, err := tc.Pods(ctx, fakeClient)
+	if err != nil || len(pods) != 3 {
+		t.Fatalf("Pods() = %v, %v, want a pod per PVC", pods, err)
+	}
+
+	succeeded := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 0}}
+	failed := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: 11}}
+	running := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+	// the steps run in order, each updating the state of the pods of some PVCs
+	steps := []struct {
+		name          string
+		states        map[string]corev1.ContainerState
+		wantCompleted bool
+		wantFailure   bool
+		wantSummary   transfer.StatusSummary
+	}{
+		{
+			name:        "one PVC running and the others pending, must report running",
+			states:      map[string]corev1.ContainerState{"data": running},
+			wantSummary: transfer.StatusSummary{Total: 3, Pending: 2, Running: 1, FailedPVCs: []types.NamespacedName{}},
+		},
+		{
+			name:        "one PVC succeeded and the others running, must report running",
+			states:      map[string]corev1.ContainerState{"data": succeeded, "logs": running, "cache": running},
+			wantSummary: transfer.StatusSummary{Total: 3, Running: 2, Succeeded: 1, FailedPVCs: []types.NamespacedName{}},
+		},
+		{
+			name:   "one PVC failed and the last one running, must report running",
+			states: map[string]corev1.ContainerState{"logs": failed},
+			wantSummary: transfer.StatusSummary{
+				Total: 3, Running: 1, Succeeded: 1, Failed: 1, AnyFailed: true,
+				FailedPVCs: []types.NamespacedName{{Namespace: "foo", Name: "logs"}},
+			},
+		},
+		{
+			name:          "all the PVCs completed, must report the failure",
+			states:        map[string]corev1.ContainerState{"cache": succeeded},
+			wantCompleted: true,
+			wantFailure:   true,
+			wantSummary: transfer.StatusSummary{
+				Total: 3, Succeeded: 2, Failed: 1, AllCompleted: true, AnyFailed: true,
+				FailedPVCs: []types.NamespacedName{{Namespace: "foo", Name: "logs"}},
+			},
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			for pvcName, state := range step.states {
+				pod := &corev1.Pod{}
+				err := fakeClient.Get(ctx, pods[types.NamespacedName{Namespace: "foo", Name: pvcName}], pod)
+				if err != nil {
+					t.Fatalf("unable to get the client pod of PVC %s: %v", pvcName, err)
+				}
+				pod.Status.ContainerStatuses = []corev1.ContainerStatus{{Name: RsyncContainer, State: state}}
+				if err := fakeClient.Status().Update(ctx, pod); err != nil {
+					t.Fatalf("unable to update the client pod of PVC %s: %v", pvcName, err)
+				}
+			}
+			status, err := tc.Status(ctx, fakeClient)
+			if err != nil {
+				t.Fatalf("Status() error = %v", err)
+			}
+			if (status.Completed != nil) != step.wantCompleted || (status.Running != nil) == step.wantCompleted {
+				t.Errorf("Status() completed = %v, running = %v, want completed %v", status.Completed, status.Running, step.wantCompleted)
+			}
+			if status.Completed != nil && status.Completed.Failure != step.wantFailure {
+				t.Errorf("Status() failure = %v, want %v", status.Completed.Failure, step.wantFailure)
+			}
+			if got := status.Summary(); !reflect.DeepEqual(got, step.wantSummary) {
+				t.Errorf("Summary() = %+v, want %+v", got, step.wantSummary)
+			}
+		})
+	}
+}
+
+func Test_client_listPods(t *testing.T) {
+	testClientPod := func(namespace, name string, labels map[string]string, ownerRefs []metav1.OwnerReference) *corev1.Pod {
+		return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            name,
+			Namespace:       namespace,
+			Labels:          labels,
+			OwnerReferences: ownerRefs,
+		}}
+	}
+	labels := map[string]string{"test": "me"}
+	otherOwner := []metav1.OwnerReference{{APIVersion: "api.foo", Kind: "Test", Name: "baz", UID: "456"}}
+	tests := []struct {
+		name     string
+		pods     []ctrlclient.Object
+		wantPods []types.NamespacedName
+	}{
+		{
+			name:     "client pod, must be listed",
+			pods:     []ctrlclient.Object{testClientPod("foo", "rsync-client-foo-data", labels, testOwnerReferences())},
+			wantPods: []types.NamespacedName{{Namespace: "foo", Name: "rsync-client-foo-data"}},
+		},
+		{
+			name: "pods reusing the labels, must be ignored",
+			pods: []ctrlclient.Object{
+				testClientPod("foo", "unrelated", labels, testOwnerReferences()),
+				testClientPod("other", "rsync-client-foo-data", labels, testOwnerReferences()),
+			},
+			wantPods: []types.NamespacedName{},
+		},
+		{
+			name:     "client pod with other owners, must be ignored",
+			pods:     []ctrlclient.Object{testClientPod("foo", "rsync-client-foo-data", labels, otherOwner)},
+			wantPods: []types.NamespacedName{},
+		},
+		{
+			name:     "client pod without the labels, must be found by name",
+			pods:     []ctrlclient.Object{testClientPod("foo", "rsync-client-foo-data", nil, testOwnerReferences())},
+			wantPods: []types.NamespacedName{{Namespace: "foo", Name: "rsync-client-foo-data"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &client{
+				logger:     testr.New(t),
+				pvcList:    transfer.NewSingletonPVC(testPVC("foo", "data")),
+				nameSuffix: "foo",
+				namespace:  "foo",
+				labels:     labels,
+				ownerRefs:  testOwnerReferences(),
+			}
+			pods, err := tc.listPods(context.Background(), fakeClientWithObjects(tt.pods...))
+			if err != nil {
+				t.Fatalf("listPods() error = %v", err)
+			}
+			got := []types.NamespacedName{}
+			for _, pod := range pods {
+				got = append(got, ctrlclient.ObjectKeyFromObject(&pod))
+			}
+			if !reflect.DeepEqual(got, tt.wantPods) {
+				t.Errorf("listPods() = %v, want %v", got, tt.wantPods)
+			}
+		})
+	}
+}
+
+func Test_client_Pods(t *testing.T) {
+	ctx := context.Background()
+	pvcList, err := transfer.NewPVCList(testPVC("foo", "data"), testPVC("foo", "logs"))
+	if err != nil {
+		t.Fatalf("unable to create pvc list %v", err)
+	}
+	tc := &client{
+		logger:     testr.New(t),
+		pvcList:    pvcList,
+		nameSuffix: "foo",
+		namespace:  "foo",
+		labels:     map[string]string{"test": "me"},
+		ownerRefs:  testOwnerReferences(),
+	}
+	dataPod, logsPod := tc.podKey(pvcList.PVCs()[0]), tc.podKey(pvcList.PVCs()[1])
+	if dataPod == logsPod {
+		t.Fatalf("PVCs of the same namespace share the client pod %v", dataPod)
+	}
+	objects := []ctrlclient.Object{}
+	for _, podKey := range []ctrlclient.ObjectKey{dataPod, logsPod} {
+		objects = append(objects, &corev1.Pod{ObjectMeta: metav1.ObjectMeta{
+			Name:            podKey.Name,
+			Namespace:       podKey.Namespace,
+			Labels:          map[string]string{"test": "me"},
+			OwnerReferences: testOwnerReferences(),
+		}})
+	}
+
+	pods, err := tc.Pods(ctx, fakeClientWithObjects(objects...))
+	want := map[types.NamespacedName]ctrlclient.ObjectKey{
+		{Namespace: "foo", Name: "data"}: dataPod,
+		{Namespace: "foo", Name: "logs"}: logsPod,
+	}
+	if err != nil || !reflect.DeepEqual(pods, want) {
+		t.Errorf("Pods() = %v, %v, want %v", pods, err, want)
+	}
+
+	// the pod of a PVC is only reported once it is created
+	pods, err = tc.Pods(ctx, fakeClientWithObjects(objects[0]))
+	want = map[types.NamespacedName]ctrlclient.ObjectKey{{Namespace: "foo", Name: "data"}: dataPod}
+	if err != nil || !reflect.DeepEqual(pods, want) {
+		t.Errorf("Pods() = %v, %v, want %v", pods, err, want)
+	}
+}
@@ -20,17 +20,105 @@ const (
 	optGroup         = "--group"
 	optHardLinks     = "--hard-links"
 	optPartial       = "--partial"
+	optPartialDir    = "--partial-dir=%s"
+	optInplace       = "--inplace"
+	optTempDir       = "--temp-dir=%s"
 	optDelete        = "--delete"
+	optDeleteWhen    = "--delete-%s"
+	optDeleteExclude = "--delete-excluded"
 	optBwLimit       = "--bwlimit=%d"
 	optInfo          = "--info=%s"
 	optHumanReadable = "--human-readable"
 	optLogFile       = "--log-file=%s"
+	optXattrs        = "--xattrs"
+	optACLs          = "--acls"
+	optExcludeXattr  = "--filter=-x %s"
+	optUserMap       = "--usermap=%s"
+	optGroupMap      = "--groupmap=%s"
+	optTimeout       = "--timeout=%d"
+	optConnTimeout   = "--contimeout=%d"
+	optNumericIDs    = "--numeric-ids"
+	optFakeSuper     = "--fake-super"
+	optSparse        = "--sparse"
+	optNoSparse      = "--no-sparse"
+	optPreallocate   = "--preallocate"
+	optWholeFile     = "--whole-file"
+	optCompress      = "--compress"
+	optCompressLevel = "--compress-level=%d"
+	optCompressWith  = "--compress-choice=%s"
+	optIncRecursive  = "--inc-recursive"
+	optNoIncRecurse  = "--no-inc-recursive"
+	optBlockSize     = "--block-size=%d"
+)
+
+// maxBlockSize is the largest block size of the delta-transfer algorithm of rsync
+const maxBlockSize = 131072
+
+const (
+	// DefaultTimeout is the number of seconds without I/O after which rsync gives up
+	DefaultTimeout = 600
+	// DefaultConnTimeout is the number of seconds rsync waits for the rsync daemon to accept connections
+	DefaultConnTimeout = 60
+)
+
+const (
+	// SparseAnnotation set to "true" on a PVC transfers its files as sparse files when the
+	// command options do not set Sparse, e.g. for PVCs of databases preallocating their files
+	SparseAnnotation = "pvc-transfer.backube.dev/sparse"
+	// cdiContentTypeAnnotation is set by CDI on PVCs holding VM disk images
+	cdiContentTypeAnnotation = "cdi.kubevirt.io/storage.contentType"
+	cdiContentTypeKubevirt   = "kubevirt"
 )
 
 const (
 	logFileStdOut = "/dev/stdout"
+	selinuxXattr  = "security.selinux"
+)
+
+// DeletePolicy is when rsync deletes the files of the destination missing from the source
+// relative to the transfer of files
+type DeletePolicy string
+
+const (
+	// DeletePolicyDefault lets rsync pick, recent versions delete during the transfer
+	DeletePolicyDefault DeletePolicy = ""
+	// DeletePolicyBefore deletes files before the transfer starts
+	DeletePolicyBefore DeletePolicy = "before"
+	// DeletePolicyDuring deletes files of each directory before transferring it
+	DeletePolicyDuring DeletePolicy = "during"
+	// DeletePolicyDelay finds files to delete during the transfer and deletes them after it
+	DeletePolicyDelay DeletePolicy = "delay"
+	// DeletePolicyAfter deletes files after the transfer completes, so that the destination
+	// keeps every file until all new files are in place
+	DeletePolicyAfter DeletePolicy = "after"
 )
 
+// CompressChoice is the compression algorithm of rsync 3.2 or later
+type CompressChoice string
+
+const (
+	// CompressChoiceDefault lets rsync negotiate the algorithm, zlib before rsync 3.2
+	CompressChoiceDefault CompressChoice = ""
+	// CompressChoiceZstd compresses as well as zlib with about half of its CPU, at levels up to 22
+	CompressChoiceZstd CompressChoice = "zstd"
+	// CompressChoiceLZ4 compresses less than zlib with little CPU, it has no levels
+	CompressChoiceLZ4 CompressChoice = "lz4"
+	// CompressChoiceZlib is the compression of rsync before 3.2
+	CompressChoiceZlib CompressChoice = "zlib"
+)
+
+// maxLevel returns the highest compression level of the algorithm, 0 when it has no levels
+func (c CompressChoice) maxLevel() int {
+	switch c {
+	case CompressChoiceZstd:
+		return 22
+	case CompressChoiceLZ4:
+		return 0
+	default:
+		return 9
+	}
+}
+
 type Applier interface {
 	ApplyTo(options *CommandOptions) error
 }
@@ -53,6 +141,76 @@ type CommandOptions struct {
 	LogFile       string
 	Info          []string
 	Extras        []string
+	// Xattrs preserves extended attributes, SELinux contexts included unless ExcludeSELinuxContext is set
+	Xattrs bool
+	// ACLs preserves POSIX ACLs
+	ACLs bool
+	// ExcludeSELinuxContext does not transfer SELinux contexts with extended attributes, files are
+	// then labeled by the destination
+	ExcludeSELinuxContext bool
+	// UserMap maps the owners of files on the destination, it requires Owners
+	UserMap []IDMapping
+	// GroupMap maps the groups of files on the destination, it requires Groups
+	GroupMap []IDMapping
+	// Timeout is the number of seconds without I/O after which rsync gives up, 0 waits forever
+	Timeout *int
+	// ConnTimeout is the number of seconds rsync waits for the rsync daemon to accept connections
+	ConnTimeout *int
+	// DeletePolicy is when files missing from the source are deleted, it requires Delete
+	DeletePolicy DeletePolicy
+	// NumericIDs transfers owners and groups as IDs rather than mapping them by name
+	NumericIDs bool
+	// FakeSuper stores the ownership, devices and special files in extended attributes of the
+	// destination rather than applying them, for destinations running as non-root, see
+	// fakeSuperEnabled
+	FakeSuper bool
+	// Sparse transfers runs of zeros as holes so that thin-provisioned files keep their size on
+	// the destination, unset it defaults to true for PVCs of VM images and PVCs annotated with
+	// SparseAnnotation, see withSparseDefault
+	Sparse *bool
+	// Preallocate allocates files on the destination before writing them, reducing their
+	// fragmentation
+	Preallocate bool
+	// WholeFile copies files whole instead of using the delta-transfer algorithm, which is
+	// faster when the destination is mostly empty
+	WholeFile bool
+	// DeleteExcluded also deletes the files of the destination excluded from the transfer, it
+	// requires Delete
+	DeleteExcluded bool
+	// PartialDir keeps partially transferred files in this directory, relative to the directory
+	// of the file unless absolute, it requires Partial
+	PartialDir string
+	// Inplace writes updated files directly instead of writing a copy and renaming it, so
+	// that destinations only need free space for the data changed. Files are inconsistent
+	// while being written, it cannot be combined with PartialDir or TempDir.
+	Inplace bool
+	// TempDir is the directory of the destination where copies of updated files are written
+	// before being renamed, e.g. on a volume with more free space
+	TempDir string
+	// Compress compresses the file data sent on the wire, trading CPU for bandwidth
+	Compress bool
+	// CompressLevel is the compression level from 1 to 9, or to 22 with zstd, rsync picks its
+	// default when unset. It requires Compress.
+	CompressLevel *int
+	// IncrementalRecursion scans directories while transferring instead of building the list
+	// of all the files first, unset rsync scans incrementally unless other options prevent it.
+	// Disabling it lets rsync know all the files before transferring, at the cost of memory
+	// growing with the number of files.
+	IncrementalRecursion *bool
+	// BlockSize is the size in bytes of the blocks of the delta-transfer algorithm, up to
+	// 131072, rsync picks it from the size of each file when unset
+	BlockSize *int
+	// CompressChoice is the compression algorithm, it requires Compress and an image with
+	// rsync 3.2 or later built with the algorithm, see ImageProfile
+	CompressChoice CompressChoice
+}
+
+// IDMapping maps a user or group of the source to a user or group of the destination.
+// From is a name, an ID, an ID range such as 1000-1999 or * to match all,
+// To is a name or an ID.
+type IDMapping struct {
+	From string
+	To   string
 }
 
 // Options returns validated rsync options and validation errors as two lists
@@ -88,9 +246,105 @@ func (c *CommandOptions) Options() ([]string, error) {
 	}
 	if c.Delete {
 		opts = append(opts, optDelete)
+		switch c.DeletePolicy {
+		case DeletePolicyDefault:
+		case DeletePolicyBefore, DeletePolicyDuring, DeletePolicyDelay, DeletePolicyAfter:
+			opts = append(opts, fmt.Sprintf(optDeleteWhen, c.DeletePolicy))
+		default:
+			errs = append(errs, fmt.Errorf("invalid rsync delete policy %s", c.DeletePolicy))
+		}
+		if c.DeleteExcluded {
+			opts = append(opts, optDeleteExclude)
+		}
+	} else if c.DeletePolicy != DeletePolicyDefault || c.DeleteExcluded {
+		errs = append(errs, fmt.Errorf("rsync delete policy requires delete to be enabled"))
+	}
+	if c.Xattrs {
+		opts = append(opts, optXattrs)
+		if c.ExcludeSELinuxContext {
+			opts = append(opts, fmt.Sprintf(optExcludeXattr, selinuxXattr))
+		}
+	}
+	if c.ACLs {
+		opts = append(opts, optACLs)
+	}
+	if c.Sparse != nil {
+		if *c.Sparse {
+			opts = append(opts, optSparse)
+		} else {
+			opts = append(opts, optNoSparse)
+		}
+	}
+	if c.Preallocate {
+		opts = append(opts, optPreallocate)
+	}
+	if c.WholeFile {
+		opts = append(opts, optWholeFile)
+	}
+	if c.NumericIDs {
+		opts = append(opts, optNumericIDs)
+	}
+	if c.FakeSuper {
+		opts = append(opts, optFakeSuper)
 	}
 	if c.Partial {
 		opts = append(opts, optPartial)
+		if c.PartialDir != "" {
+			errs = append(errs, validatePath("partial-dir", c.PartialDir))
+			opts = append(opts, fmt.Sprintf(optPartialDir, c.PartialDir))
+		}
+	} else if c.PartialDir != "" {
+		errs = append(errs, fmt.Errorf("rsync partial-dir requires partial to be enabled"))
+	}
+	if c.Inplace {
+		opts = append(opts, optInplace)
+		if c.PartialDir != "" {
+			errs = append(errs, fmt.Errorf("rsync inplace cannot be used with partial-dir"))
+		}
+		if c.TempDir != "" {
+			errs = append(errs, fmt.Errorf("rsync inplace cannot be used with temp-dir"))
+		}
+	}
+	if c.TempDir != "" {
+		errs = append(errs, validatePath("temp-dir", c.TempDir))
+		opts = append(opts, fmt.Sprintf(optTempDir, c.TempDir))
+	}
+	if c.IncrementalRecursion != nil {
+		if *c.IncrementalRecursion {
+			opts = append(opts, optIncRecursive)
+		} else {
+			opts = append(opts, optNoIncRecurse)
+		}
+	}
+	if c.BlockSize != nil {
+		if *c.BlockSize > 0 && *c.BlockSize <= maxBlockSize {
+			opts = append(opts, fmt.Sprintf(optBlockSize, *c.BlockSize))
+		} else {
+			errs = append(errs, fmt.Errorf("rsync block-size value must be between 1 and %d", maxBlockSize))
+		}
+	}
+	if c.Compress {
+		opts = append(opts, optCompress)
+		switch c.CompressChoice {
+		case CompressChoiceDefault:
+		case CompressChoiceZstd, CompressChoiceLZ4, CompressChoiceZlib:
+			opts = append(opts, fmt.Sprintf(optCompressWith, c.CompressChoice))
+		default:
+			errs = append(errs, fmt.Errorf("invalid rsync compress choice %s", c.CompressChoice))
+		}
+		if c.CompressLevel != nil {
+			maxLevel := c.CompressChoice.maxLevel()
+			switch {
+			case maxLevel == 0:
+				errs = append(errs, fmt.Errorf("rsync compress choice %s has no levels", c.CompressChoice))
+			case *c.CompressLevel >= 1 && *c.CompressLevel <= maxLevel:
+				opts = append(opts, fmt.Sprintf(optCompressLevel, *c.CompressLevel))
+			default:
+				errs = append(errs, fmt.Errorf("rsync compress-level value must be between 1 and %d", maxLevel))
+			}
+		}
+	} else if c.CompressLevel != nil || c.CompressChoice != CompressChoiceDefault {
+		errs = append(errs, fmt.Errorf("rsync compress-level and compress-choice require compress to be enabled"))
 	}
 	if c.BwLimit != nil {
 		if *c.BwLimit > 0 {
@@ -100,6 +354,20 @@ func (c *CommandOptions) Options() ([]string, error) {
 			errs = append(errs, fmt.Errorf("rsync bwlimit value must be a positive integer"))
 		}
 	}
+	if c.Timeout != nil {
+		if *c.Timeout >= 0 {
+			opts = append(opts, fmt.Sprintf(optTimeout, *c.Timeout))
+		} else {
+			errs = append(errs, fmt.Errorf("rsync timeout value must be a non-negative integer"))
+		}
+	}
+	if c.ConnTimeout != nil {
+		if *c.ConnTimeout > 0 {
+			opts = append(opts, fmt.Sprintf(optConnTimeout, *c.ConnTimeout))
+		} else {
+			errs = append(errs, fmt.Errorf("rsync contimeout value must be a positive integer"))
+		}
+	}
 	if c.HumanReadable {
 		opts = append(opts, optHumanReadable)
 	}
@@ -113,6 +381,22 @@ func (c *CommandOptions) Options() ([]string, error) {
 			fmt.Sprintf(
 				optInfo, strings.Join(validatedOptions, ",")))
 	}
+	if len(c.UserMap) > 0 {
+		if !c.Owners {
+			errs = append(errs, fmt.Errorf("rsync usermap requires owners to be preserved"))
+		}
+		mapping, err := formatIDMappings(c.UserMap)
+		errs = append(errs, err)
+		opts = append(opts, fmt.Sprintf(optUserMap, mapping))
+	}
+	if len(c.GroupMap) > 0 {
+		if !c.Groups {
+			errs = append(errs, fmt.Errorf("rsync groupmap requires groups to be preserved"))
+		}
+		mapping, err := formatIDMappings(c.GroupMap)
+		errs = append(errs, err)
+		opts = append(opts, fmt.Sprintf(optGroupMap, mapping))
+	}
 	if len(c.Extras) > 0 {
 		extraOpts, err := filterRsyncExtraOptions(c.Extras)
 		errs = append(errs, err)
@@ -154,10 +438,36 @@ func filterRsyncExtraOptions(options []string) (validatedOptions []string, err e
 	return validatedOptions, errorsutil.NewAggregate(errs)
 }
 
+// validatePath returns an error when the path of an rsync option has characters which are
+// not safe in the rsync script
+func validatePath(option string, path string) error {
+	r := regexp.MustCompile(`^[A-Za-z0-9._/-]+$`)
+	if !r.MatchString(path) {
+		return fmt.Errorf("invalid path %s for Rsync option --%s", path, option)
+	}
+	return nil
+}
+
+func formatIDMappings(mappings []IDMapping) (string, error) {
+	var errs []error
+	from := regexp.MustCompile(`^(\*|\d+(-\d+)?|[a-z_][a-z0-9_-]*)$`)
+	to := regexp.MustCompile(`^(\d+|[a-z_][a-z0-9_-]*)$`)
+	validatedMappings := []string{}
+	for _, mapping := range mappings {
+		if from.MatchString(mapping.From) && to.MatchString(mapping.To) {
+			validatedMappings = append(validatedMappings, fmt.Sprintf("%s:%s", mapping.From, mapping.To))
+		} else {
+			errs = append(errs, fmt.Errorf("invalid Rsync id mapping %s:%s", mapping.From, mapping.To))
+		}
+	}
+	return strings.Join(validatedMappings, ","), errorsutil.NewAggregate(errs)
+}
+
 func rsyncCommandDefaultOptions() []Applier {
 	return []Applier{
 		ArchiveFiles(true),
 		StandardProgress(true),
+		Timeouts{Timeout: DefaultTimeout, ConnTimeout: DefaultConnTimeout},
 	}
 }
 
@@ -219,3 +529,237 @@ func (d DeleteDestination) ApplyTo(opts *CommandOptions) error {
 	opts.Delete = bool(d)
 	return nil
 }
+
+// DeleteDestinationWith deletes the files of the destination missing from the source
+// according to the delete policy, and the files excluded from the transfer when Excluded is set
+type DeleteDestinationWith struct {
+	Policy   DeletePolicy
+	Excluded bool
+}
+
+func (d DeleteDestinationWith) ApplyTo(opts *CommandOptions) error {
+	opts.Delete = true
+	opts.DeletePolicy = d.Policy
+	opts.DeleteExcluded = d.Excluded
+	return nil
+}
+
+// MapUsers maps the owners of files transferred, e.g. to move data written with the random UIDs
+// of a restricted cluster to the UID of the destination workload
+type MapUsers []IDMapping
+
+func (m MapUsers) ApplyTo(opts *CommandOptions) error {
+	opts.Owners = true
+	opts.UserMap = m
+	return nil
+}
+
+// MapGroups maps the groups of files transferred
+type MapGroups []IDMapping
+
+func (m MapGroups) ApplyTo(opts *CommandOptions) error {
+	opts.Groups = true
+	opts.GroupMap = m
+	return nil
+}
+
+// ChownTo makes every file transferred owned by the given user and group, either can be empty
+type ChownTo struct {
+	User  string
+	Group string
+}
+
+func (c ChownTo) ApplyTo(opts *CommandOptions) error {
+	if c.User != "" {
+		opts.Owners = true
+		opts.UserMap = []IDMapping{{From: "*", To: c.User}}
+	}
+	if c.Group != "" {
+		opts.Groups = true
+		opts.GroupMap = []IDMapping{{From: "*", To: c.Group}}
+	}
+	return nil
+}
+
+// PreserveXattrs preserves extended attributes and, unless relabel is set, SELinux contexts.
+// With relabel files get the SELinux context of the destination volume.
+type PreserveXattrs struct {
+	Relabel bool
+}
+
+func (p PreserveXattrs) ApplyTo(opts *CommandOptions) error {
+	opts.Xattrs = true
+	opts.ExcludeSELinuxContext = p.Relabel
+	return nil
+}
+
+type PreserveACLs bool
+
+func (p PreserveACLs) ApplyTo(opts *CommandOptions) error {
+	opts.ACLs = bool(p)
+	return nil
+}
+
+// KeepPartial keeps partially transferred files to resume them, in Dir when it is set
+type KeepPartial struct {
+	Dir string
+}
+
+func (k KeepPartial) ApplyTo(opts *CommandOptions) error {
+	opts.Partial = true
+	opts.PartialDir = k.Dir
+	return nil
+}
+
+// UpdateInplace writes updated files in place, for destinations without the free space
+// to hold a copy of the largest file
+type UpdateInplace bool
+
+func (u UpdateInplace) ApplyTo(opts *CommandOptions) error {
+	opts.Inplace = bool(u)
+	return nil
+}
+
+// TempDir writes the copies of updated files to a directory of the destination
+type TempDir string
+
+func (t TempDir) ApplyTo(opts *CommandOptions) error {
+	opts.TempDir = string(t)
+	return nil
+}
+
+// SparseFiles transfers runs of zeros as holes, or fills them when false
+type SparseFiles bool
+
+func (s SparseFiles) ApplyTo(opts *CommandOptions) error {
+	sparse := bool(s)
+	opts.Sparse = &sparse
+	return nil
+}
+
+type PreallocateFiles bool
+
+func (p PreallocateFiles) ApplyTo(opts *CommandOptions) error {
+	opts.Preallocate = bool(p)
+	return nil
+}
+
+type WholeFiles bool
+
+func (w WholeFiles) ApplyTo(opts *CommandOptions) error {
+	opts.WholeFile = bool(w)
+	return nil
+}
+
+// Compression compresses the file data sent on the wire at the given level from 1 to 9, or
+// at the default level of rsync when 0
+type Compression int
+
+func (c Compression) ApplyTo(opts *CommandOptions) error {
+	opts.Compress = true
+	opts.CompressLevel = nil
+	if c != 0 {
+		level := int(c)
+		opts.CompressLevel = &level
+	}
+	return nil
+}
+
+// IncrementalRecursion scans directories while transferring, or builds the list of all the
+// files before transferring when false
+type IncrementalRecursion bool
+
+func (i IncrementalRecursion) ApplyTo(opts *CommandOptions) error {
+	incremental := bool(i)
+	opts.IncrementalRecursion = &incremental
+	return nil
+}
+
+// BlockSize sets the size in bytes of the blocks of the delta-transfer algorithm, larger blocks
+// exchange fewer checksums for large files changing in large extents
+type BlockSize int
+
+func (b BlockSize) ApplyTo(opts *CommandOptions) error {
+	size := int(b)
+	opts.BlockSize = &size
+	return nil
+}
+
+// ManySmallFiles is the profile of PVCs holding tens of millions of small files. It keeps the
+// memory of rsync bounded and starts transferring early by scanning directories incrementally,
+// and copies files whole since the delta-transfer algorithm saves little on small files while
+// checksumming all of them. Files missing from the source are deleted during the transfer
+// instead of before or after it, which requires the list of all the files.
+// The block size of the delta-transfer algorithm is unset, it is not used for whole files.
+type ManySmallFiles bool
+
+func (m ManySmallFiles) ApplyTo(opts *CommandOptions) error {
+	if !m {
+		return nil
+	}
+	incremental := true
+	opts.IncrementalRecursion = &incremental
+	opts.WholeFile = true
+	opts.BlockSize = nil
+	if opts.DeletePolicy == DeletePolicyBefore || opts.DeletePolicy == DeletePolicyAfter {
+		opts.DeletePolicy = DeletePolicyDuring
+	}
+	return nil
+}
+
+// CompressWith compresses the file data sent on the wire with the algorithm at its default level
+type CompressWith CompressChoice
+
+func (c CompressWith) ApplyTo(opts *CommandOptions) error {
+	opts.Compress = true
+	opts.CompressChoice = CompressChoice(c)
+	return nil
+}
+
+// withSparseDefault returns the rsync options of a PVC, --sparse is added for PVCs holding
+// VM images or annotated with SparseAnnotation unless the options already set Sparse. Such
+// PVCs are thin-provisioned, filling their holes would make them grow to their full size.
+func withSparseDefault(rsyncOptions []string, pvc transfer.PVC) []string {
+	for _, opt := range rsyncOptions {
+		if opt == optSparse || opt == optNoSparse {
+			return rsyncOptions
+		}
+	}
+	annotations := pvc.Claim().Annotations
+	if annotations[SparseAnnotation] != "true" && annotations[cdiContentTypeAnnotation] != cdiContentTypeKubevirt {
+		return rsyncOptions
+	}
+	return append(append([]string{}, rsyncOptions...), optSparse)
+}
+
+// NumericIDs keeps the user and group IDs of files, e.g. when the names of the source are
+// not known to the destination
+type NumericIDs bool
+
+func (n NumericIDs) ApplyTo(opts *CommandOptions) error {
+	opts.NumericIDs = bool(n)
+	return nil
+}
+
+// FakeSuper lets destinations running as non-root preserve the ownership of files, devices and
+// special files by storing them in extended attributes
+type FakeSuper bool
+
+func (f FakeSuper) ApplyTo(opts *CommandOptions) error {
+	opts.FakeSuper = bool(f)
+	return nil
+}
+
+// Timeouts stops rsync after Timeout seconds without I/O and when the rsync daemon does not
+// accept connections within ConnTimeout seconds, so that stalled transfers fail and are retried
+type Timeouts struct {
+	Timeout     int
+	ConnTimeout int
+}
+
+func (t Timeouts) ApplyTo(opts *CommandOptions) error {
+	timeout, connTimeout := t.Timeout, t.ConnTimeout
+	opts.Timeout = &timeout
+	opts.ConnTimeout = &connTimeout
+	return nil
+}
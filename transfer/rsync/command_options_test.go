@@ -0,0 +1,473 @@
+package rsync
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCommandOptions_Options_idMappings(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "user and group maps, must add usermap and groupmap options",
+			appliers: []Applier{MapUsers{{From: "1000-1999", To: "app"}, {From: "*", To: "1001"}}, MapGroups{{From: "0", To: "1001"}}},
+			want:     []string{optOwner, optGroup, "--usermap=1000-1999:app,*:1001", "--groupmap=0:1001"},
+		},
+		{
+			name:     "chown to user only, must map all users",
+			appliers: []Applier{ChownTo{User: "1001"}},
+			want:     []string{optOwner, "--usermap=*:1001"},
+		},
+		{
+			name:     "invalid mapping, must return an error",
+			appliers: []Applier{MapUsers{{From: "1000;rm", To: "app"}}},
+			want:     []string{optOwner, "--usermap="},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_xattrs(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+	}{
+		{
+			name:     "preserve xattrs and ACLs, must add xattrs and acls options",
+			appliers: []Applier{PreserveXattrs{}, PreserveACLs(true)},
+			want:     []string{optXattrs, optACLs},
+		},
+		{
+			name:     "preserve xattrs with relabel, must exclude SELinux contexts",
+			appliers: []Applier{PreserveXattrs{Relabel: true}},
+			want:     []string{optXattrs, "--filter=-x security.selinux"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if err != nil {
+				t.Fatalf("Options() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_idMappingsRequireOwnership(t *testing.T) {
+	c := &CommandOptions{UserMap: []IDMapping{{From: "*", To: "1001"}}}
+	if _, err := c.Options(); err == nil {
+		t.Error("Options() must return an error for a usermap without owners preserved")
+	}
+}
+
+func TestCommandOptions_Options_delete(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "delete, must only add delete",
+			appliers: []Applier{DeleteDestination(true)},
+			want:     []string{"--delete"},
+		},
+		{
+			name:     "delete after, must add delete-after",
+			appliers: []Applier{DeleteDestinationWith{Policy: DeletePolicyAfter}},
+			want:     []string{"--delete", "--delete-after"},
+		},
+		{
+			name:     "delete during with excluded files, must add delete-during and delete-excluded",
+			appliers: []Applier{DeleteDestinationWith{Policy: DeletePolicyDuring, Excluded: true}},
+			want:     []string{"--delete", "--delete-during", "--delete-excluded"},
+		},
+		{
+			name:     "unknown delete policy, must return an error",
+			appliers: []Applier{DeleteDestinationWith{Policy: "never"}},
+			want:     []string{"--delete"},
+			wantErr:  true,
+		},
+		{
+			name:     "delete policy without delete, must return an error",
+			appliers: []Applier{DeleteDestinationWith{Policy: DeletePolicyAfter}, DeleteDestination(false)},
+			want:     []string{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_nonRootDestination(t *testing.T) {
+	c := &CommandOptions{}
+	if err := c.Apply(NumericIDs(true), FakeSuper(true)); err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	got, err := c.Options()
+	if err != nil {
+		t.Fatalf("Options() error = %v", err)
+	}
+	if want := []string{"--numeric-ids", "--fake-super"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Options() = %v, want %v", got, want)
+	}
+}
+
+func TestCommandOptions_Options_sparse(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+	}{
+		{
+			name:     "sparse files, must add sparse",
+			appliers: []Applier{SparseFiles(true), PreallocateFiles(true)},
+			want:     []string{"--sparse", "--preallocate"},
+		},
+		{
+			name:     "sparse files disabled, must add no-sparse",
+			appliers: []Applier{SparseFiles(false), WholeFiles(true)},
+			want:     []string{"--no-sparse", "--whole-file"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if err != nil {
+				t.Fatalf("Options() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_withSparseDefault(t *testing.T) {
+	tests := []struct {
+		name         string
+		annotations  map[string]string
+		rsyncOptions []string
+		want         []string
+	}{
+		{
+			name:         "PVC without annotations, must keep the options",
+			rsyncOptions: []string{"--recursive"},
+			want:         []string{"--recursive"},
+		},
+		{
+			name:         "PVC of a VM image, must add sparse",
+			annotations:  map[string]string{"cdi.kubevirt.io/storage.contentType": "kubevirt"},
+			rsyncOptions: []string{"--recursive"},
+			want:         []string{"--recursive", "--sparse"},
+		},
+		{
+			name:         "PVC annotated as sparse, must add sparse",
+			annotations:  map[string]string{SparseAnnotation: "true"},
+			rsyncOptions: []string{"--recursive"},
+			want:         []string{"--recursive", "--sparse"},
+		},
+		{
+			name:         "PVC annotated as sparse with sparse disabled, must keep the options",
+			annotations:  map[string]string{SparseAnnotation: "true"},
+			rsyncOptions: []string{"--recursive", "--no-sparse"},
+			want:         []string{"--recursive", "--no-sparse"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo", Annotations: tt.annotations},
+			}).PVCs()[0]
+			rsyncOptions := append([]string{}, tt.rsyncOptions...)
+			if got := withSparseDefault(rsyncOptions, pvc); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withSparseDefault() = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(rsyncOptions, tt.rsyncOptions) {
+				t.Errorf("withSparseDefault() modified the options to %v", rsyncOptions)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_inplace(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "inplace, must add inplace",
+			appliers: []Applier{UpdateInplace(true)},
+			want:     []string{"--inplace"},
+		},
+		{
+			name:     "partial dir and temp dir, must add both",
+			appliers: []Applier{KeepPartial{Dir: ".rsync-partial"}, TempDir("/mnt/tmp")},
+			want:     []string{"--partial", "--partial-dir=.rsync-partial", "--temp-dir=/mnt/tmp"},
+		},
+		{
+			name:     "inplace with partial dir, must return an error",
+			appliers: []Applier{KeepPartial{Dir: ".rsync-partial"}, UpdateInplace(true)},
+			want:     []string{"--partial", "--partial-dir=.rsync-partial", "--inplace"},
+			wantErr:  true,
+		},
+		{
+			name:     "inplace with temp dir, must return an error",
+			appliers: []Applier{UpdateInplace(true), TempDir("/mnt/tmp")},
+			want:     []string{"--inplace", "--temp-dir=/mnt/tmp"},
+			wantErr:  true,
+		},
+		{
+			name:     "inplace with partial, must add both",
+			appliers: []Applier{KeepPartial{}, UpdateInplace(true)},
+			want:     []string{"--partial", "--inplace"},
+		},
+		{
+			name:     "temp dir with unsafe characters, must return an error",
+			appliers: []Applier{TempDir("/mnt/tmp; rm -rf /")},
+			want:     []string{"--temp-dir=/mnt/tmp; rm -rf /"},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_timeouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "timeouts, must add timeout and contimeout options",
+			appliers: []Applier{Timeouts{Timeout: 300, ConnTimeout: 30}},
+			want:     []string{"--timeout=300", "--contimeout=30"},
+		},
+		{
+			name:     "zero timeout, must disable the I/O timeout",
+			appliers: []Applier{Timeouts{Timeout: 0, ConnTimeout: 30}},
+			want:     []string{"--timeout=0", "--contimeout=30"},
+		},
+		{
+			name:     "invalid timeouts, must return an error",
+			appliers: []Applier{Timeouts{Timeout: -1, ConnTimeout: 0}},
+			want:     []string{},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_compression(t *testing.T) {
+	level := 3
+	tests := []struct {
+		name     string
+		appliers []Applier
+		options  CommandOptions
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "default level, must only add compress",
+			appliers: []Applier{Compression(0)},
+			want:     []string{"--compress"},
+		},
+		{
+			name:     "compression level, must add compress-level",
+			appliers: []Applier{Compression(6)},
+			want:     []string{"--compress", "--compress-level=6"},
+		},
+		{
+			name:     "invalid level, must return an error",
+			appliers: []Applier{Compression(12)},
+			want:     []string{"--compress"},
+			wantErr:  true,
+		},
+		{
+			name:    "level without compress, must return an error",
+			options: CommandOptions{CompressLevel: &level},
+			want:    []string{},
+			wantErr: true,
+		},
+		{
+			name:     "zstd at a high level, must add compress-choice",
+			appliers: []Applier{CompressWith(CompressChoiceZstd), Compression(19)},
+			want:     []string{"--compress", "--compress-choice=zstd", "--compress-level=19"},
+		},
+		{
+			name:     "lz4 with a level, must return an error",
+			appliers: []Applier{Compression(3), CompressWith(CompressChoiceLZ4)},
+			want:     []string{"--compress", "--compress-choice=lz4"},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown algorithm, must return an error",
+			appliers: []Applier{CompressWith("brotli")},
+			want:     []string{"--compress"},
+			wantErr:  true,
+		},
+		{
+			name:    "algorithm without compress, must return an error",
+			options: CommandOptions{CompressChoice: CompressChoiceZstd},
+			want:    []string{},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &tt.options
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandOptions_Options_fileList(t *testing.T) {
+	tests := []struct {
+		name     string
+		appliers []Applier
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "incremental recursion disabled with block size, must add no-inc-recursive and block-size",
+			appliers: []Applier{IncrementalRecursion(false), BlockSize(65536)},
+			want:     []string{"--no-inc-recursive", "--block-size=65536"},
+		},
+		{
+			name:     "block size above the maximum, must return an error",
+			appliers: []Applier{BlockSize(262144)},
+			want:     []string{},
+			wantErr:  true,
+		},
+		{
+			name: "many small files, must scan incrementally, copy whole files and delete during the transfer",
+			appliers: []Applier{IncrementalRecursion(false), BlockSize(65536),
+				DeleteDestinationWith{Policy: DeletePolicyAfter}, ManySmallFiles(true)},
+			want: []string{"--delete", "--delete-during", "--whole-file", "--inc-recursive"},
+		},
+		{
+			name:     "many small files disabled, must keep the options",
+			appliers: []Applier{BlockSize(65536), ManySmallFiles(false)},
+			want:     []string{"--block-size=65536"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &CommandOptions{}
+			if err := c.Apply(tt.appliers...); err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			got, err := c.Options()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Options() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Options() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_rsyncDefaultOptions_timeouts(t *testing.T) {
+	got, err := rsyncDefaultOptions()
+	if err != nil {
+		t.Fatalf("rsyncDefaultOptions() error = %v", err)
+	}
+	for _, want := range []string{"--timeout=600", "--contimeout=60"} {
+		found := false
+		for _, opt := range got {
+			found = found || opt == want
+		}
+		if !found {
+			t.Errorf("rsyncDefaultOptions() = %v, want %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package rsync
+
+import (
+	"context"
+	"sort"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Diagnose returns the reasons why the client pods created so far are not running, see
+// transfer.DiagnosePods
+func (tc *client) Diagnose(ctx context.Context, c ctrlclient.Client) ([]string, error) {
+	pods, err := tc.Pods(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return transfer.DiagnosePods(ctx, c, podKeys(pods)...)
+}
+
+// Diagnose returns the reasons why the server pod is not running, see transfer.DiagnosePods
+func (s *server) Diagnose(ctx context.Context, c ctrlclient.Client) ([]string, error) {
+	return transfer.DiagnosePods(ctx, c, s.workload().NamespacedName)
+}
+
+// podKeys returns the distinct pods of a map of pods keyed by PVCs, sorted
+func podKeys(pods map[types.NamespacedName]ctrlclient.ObjectKey) []ctrlclient.ObjectKey {
+	seen := map[ctrlclient.ObjectKey]bool{}
+	keys := []ctrlclient.ObjectKey{}
+	for _, key := range pods {
+		if !seen[key] {
+			seen[key] = true
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+	return keys
+}
@@ -0,0 +1,52 @@
+package rsync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_client_Diagnose(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "rsync-client-foo-data",
+			Namespace:   "foo",
+			Labels:      map[string]string{"test": "me"},
+			Annotations: map[string]string{"pvc": "data"},
+		},
+		Status: corev1.PodStatus{
+			Phase: corev1.PodPending,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  RsyncContainer,
+				Image: "rsync:latest",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ErrImagePull", Message: "not found"}},
+			}},
+		},
+	}
+	tc := &client{
+		logger:     testr.New(t),
+		pvcList:    transfer.NewSingletonPVC(testPVC("foo", "data")),
+		nameSuffix: "foo",
+		namespace:  "foo",
+		labels:     map[string]string{"test": "me"},
+	}
+	got, err := tc.Diagnose(context.Background(), fakeClientWithObjects(pod))
+	want := []string{"container rsync of pod foo/rsync-client-foo-data cannot pull image rsync:latest: not found"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Diagnose() = %q, %v, want %q", got, err, want)
+	}
+}
+
+func Test_server_Diagnose(t *testing.T) {
+	s := &server{pvcList: transfer.NewSingletonPVC(testPVC("foo", "data")), nameSuffix: "foo"}
+	got, err := s.Diagnose(context.Background(), fakeClientWithObjects())
+	want := []string{"pod foo/rsync-server-foo not found"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Diagnose() = %q, %v, want %q", got, err, want)
+	}
+}
@@ -0,0 +1,136 @@
+// Package exitcode interprets the exit codes of rsync, telling apart failures worth retrying,
+// such as source files vanishing during the transfer or network timeouts, from failures which
+// require changes from the user, such as authentication failures or invalid options.
+package exitcode
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Reason is a machine readable reason for an rsync exit code
+type Reason string
+
+const (
+	// ReasonSuccess is the reason of a successful transfer
+	ReasonSuccess Reason = "Success"
+	// ReasonSyntax is reported for invalid rsync options
+	ReasonSyntax Reason = "SyntaxError"
+	// ReasonProtocolIncompatible is reported when the rsync versions of the client and the
+	// server are not compatible
+	ReasonProtocolIncompatible Reason = "ProtocolIncompatible"
+	// ReasonFileSelection is reported when the source or the destination cannot be selected
+	ReasonFileSelection Reason = "FileSelection"
+	// ReasonUnsupported is reported when an option is not supported by the rsync binaries
+	ReasonUnsupported Reason = "Unsupported"
+	// ReasonStartup is reported when the client and the server fail to start the protocol,
+	// e.g. when the daemon rejects the credentials of the client or the module does not exist
+	ReasonStartup Reason = "StartupFailed"
+	// ReasonSocketIO is reported when the connection to the server fails
+	ReasonSocketIO Reason = "SocketIO"
+	// ReasonFileIO is reported when files cannot be read or written, e.g. a full destination
+	ReasonFileIO Reason = "FileIO"
+	// ReasonProtocolStream is reported when the data stream is interrupted or corrupted
+	ReasonProtocolStream Reason = "ProtocolStream"
+	// ReasonInterrupted is reported when rsync received SIGINT or SIGUSR1
+	ReasonInterrupted Reason = "Interrupted"
+	// ReasonOutOfMemory is reported when rsync cannot allocate memory
+	ReasonOutOfMemory Reason = "OutOfMemory"
+	// ReasonPartialTransfer is reported when some files could not be transferred, e.g.
+	// because of their permissions
+	ReasonPartialTransfer Reason = "PartialTransfer"
+	// ReasonVanishedFiles is reported when source files were deleted during the transfer
+	ReasonVanishedFiles Reason = "VanishedFiles"
+	// ReasonMaxDelete is reported when --max-delete stopped deletions
+	ReasonMaxDelete Reason = "MaxDelete"
+	// ReasonTimeout is reported when no data was exchanged during the I/O timeout
+	ReasonTimeout Reason = "Timeout"
+	// ReasonConnectTimeout is reported when the connection to the daemon timed out
+	ReasonConnectTimeout Reason = "ConnectTimeout"
+	// ReasonDestinationFull is reported when files cannot be written because the destination
+	// has no space left, rsync exits with 11 or 23 and the failure is found in its output
+	ReasonDestinationFull Reason = "DestinationFull"
+	// ReasonKilled is reported when the process was killed by a signal, e.g. by the OOM killer
+	ReasonKilled Reason = "Killed"
+	// ReasonUnknown is reported for the other exit codes, e.g. internal rsync errors
+	ReasonUnknown Reason = "Unknown"
+)
+
+// ExitCode is the exit code of an rsync process
+type ExitCode int32
+
+// info describes a documented rsync exit code
+type info struct {
+	reason      Reason
+	description string
+	retryable   bool
+}
+
+var codes = map[ExitCode]info{
+	0:  {ReasonSuccess, "success", false},
+	1:  {ReasonSyntax, "syntax or usage error", false},
+	2:  {ReasonProtocolIncompatible, "protocol incompatibility", false},
+	3:  {ReasonFileSelection, "errors selecting input/output files, dirs", false},
+	4:  {ReasonUnsupported, "requested action not supported", false},
+	5:  {ReasonStartup, "error starting client-server protocol", false},
+	6:  {ReasonUnknown, "daemon unable to append to log-file", false},
+	10: {ReasonSocketIO, "error in socket I/O", true},
+	11: {ReasonFileIO, "error in file I/O", false},
+	12: {ReasonProtocolStream, "error in rsync protocol data stream", true},
+	13: {ReasonUnknown, "errors with program diagnostics", false},
+	14: {ReasonUnknown, "error in IPC code", false},
+	20: {ReasonInterrupted, "received SIGUSR1 or SIGINT", false},
+	21: {ReasonUnknown, "some error returned by waitpid()", false},
+	22: {ReasonOutOfMemory, "error allocating core memory buffers", false},
+	23: {ReasonPartialTransfer, "partial transfer due to error", true},
+	24: {ReasonVanishedFiles, "partial transfer due to vanished source files", true},
+	25: {ReasonMaxDelete, "the --max-delete limit stopped deletions", false},
+	30: {ReasonTimeout, "timeout in data send/receive", true},
+	35: {ReasonConnectTimeout, "timeout waiting for daemon connection", true},
+}
+
+// Reason returns the reason of the exit code
+func (c ExitCode) Reason() Reason {
+	if i, ok := codes[c]; ok {
+		return i.reason
+	}
+	if c > 128 {
+		return ReasonKilled
+	}
+	return ReasonUnknown
+}
+
+// Retryable returns whether a new attempt may succeed without changes from the user, e.g.
+// after a network timeout or source files vanishing during the transfer
+func (c ExitCode) Retryable() bool {
+	return codes[c].retryable
+}
+
+// Successful returns whether the exit code is the one of a successful transfer
+func (c ExitCode) Successful() bool {
+	return c == 0
+}
+
+// String returns the exit code with the description of the rsync documentation
+func (c ExitCode) String() string {
+	if i, ok := codes[c]; ok {
+		return fmt.Sprintf("%d (%s)", c, i.description)
+	}
+	if c > 128 {
+		return fmt.Sprintf("%d (killed by signal %d)", c, c-128)
+	}
+	return fmt.Sprintf("%d (unknown)", c)
+}
+
+// RetryableCodes returns the exit codes for which Retryable is true in ascending order, e.g.
+// for the RetryOnExitCodes of a retry policy
+func RetryableCodes() []int32 {
+	retryable := []int32{}
+	for c, i := range codes {
+		if i.retryable {
+			retryable = append(retryable, int32(c))
+		}
+	}
+	sort.Slice(retryable, func(i, j int) bool { return retryable[i] < retryable[j] })
+	return retryable
+}
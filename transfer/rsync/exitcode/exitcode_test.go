@@ -0,0 +1,75 @@
+package exitcode
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name          string
+		code          ExitCode
+		wantReason    Reason
+		wantRetryable bool
+		wantString    string
+	}{
+		{
+			name:       "success, must not be retryable",
+			code:       0,
+			wantReason: ReasonSuccess,
+			wantString: "0 (success)",
+		},
+		{
+			name:          "vanished source files, must be retryable",
+			code:          24,
+			wantReason:    ReasonVanishedFiles,
+			wantRetryable: true,
+			wantString:    "24 (partial transfer due to vanished source files)",
+		},
+		{
+			name:          "timeout, must be retryable",
+			code:          30,
+			wantReason:    ReasonTimeout,
+			wantRetryable: true,
+			wantString:    "30 (timeout in data send/receive)",
+		},
+		{
+			name:       "authentication failure, must not be retryable",
+			code:       5,
+			wantReason: ReasonStartup,
+			wantString: "5 (error starting client-server protocol)",
+		},
+		{
+			name:       "killed by SIGKILL, must not be retryable",
+			code:       137,
+			wantReason: ReasonKilled,
+			wantString: "137 (killed by signal 9)",
+		},
+		{
+			name:       "undocumented exit code, must be unknown",
+			code:       42,
+			wantReason: ReasonUnknown,
+			wantString: "42 (unknown)",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.code.Reason(); got != tt.wantReason {
+				t.Errorf("Reason() = %v, want %v", got, tt.wantReason)
+			}
+			if got := tt.code.Retryable(); got != tt.wantRetryable {
+				t.Errorf("Retryable() = %v, want %v", got, tt.wantRetryable)
+			}
+			if got := tt.code.String(); got != tt.wantString {
+				t.Errorf("String() = %v, want %v", got, tt.wantString)
+			}
+		})
+	}
+}
+
+func TestRetryableCodes(t *testing.T) {
+	want := []int32{10, 12, 23, 24, 30, 35}
+	if got := RetryableCodes(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RetryableCodes() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,262 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/secrets"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	rsyncFanOutSecret        = "rsync-fanout"
+	fanOutSecretsKey         = "rsyncd.secrets"
+	fanOutSecretsMountPath   = "/etc/rsyncd.secrets"
+	fanOutPasswordKey        = "password"
+	fanOutPasswordMountPath  = "/etc/rsync-password"
+	fanOutPasswordVolumeName = "rsync-password"
+)
+
+var (
+	// ErrFanOutInvalid is returned when the fan-out options of a server or a client cannot be used
+	ErrFanOutInvalid = errors.New("fan-out options invalid")
+	// ErrPVCNotShared is returned when a fan-out server is given a PVC which cannot be mounted
+	// by several pods at once
+	ErrPVCNotShared = errors.New("PVC not ReadWriteMany or ReadOnlyMany")
+)
+
+// FanOutServer is implemented by rsync servers created with FanOutClients
+type FanOutServer interface {
+	transfer.Server
+	// FanOutCredentials returns the secret holding the password of the given client, the secret
+	// has to be copied to the namespace of the client
+	FanOutCredentials(client string) types.NamespacedName
+}
+
+// validateFanOutServer returns an error when the PVCs and options of a server cannot be shared
+// with the FanOutClients of options
+func validateFanOutServer(pvcList transfer.PVCList, podOptions transfer.PodOptions, options Options, mode Mode) error {
+	if len(options.FanOutClients) == 0 {
+		return nil
+	}
+	if mode != ModeDaemon {
+		return fmt.Errorf("%w: fan-out requires rsync mode %s", ErrFanOutInvalid, ModeDaemon)
+	}
+	if serverTerminatesOnCompletion(podOptions, options) {
+		return fmt.Errorf("%w: fan-out servers serve several clients and cannot terminate on completion", ErrFanOutInvalid)
+	}
+	if options.FanOutClient != "" {
+		return fmt.Errorf("%w: servers cannot be fan-out clients", ErrFanOutInvalid)
+	}
+	seen := map[string]bool{}
+	for _, client := range options.FanOutClients {
+		if errs := validation.IsDNS1123Label(client); len(errs) > 0 {
+			return fmt.Errorf("%w: client name %s: %s", ErrFanOutInvalid, client, strings.Join(errs, ", "))
+		}
+		if seen[client] {
+			return fmt.Errorf("%w: duplicate client name %s", ErrFanOutInvalid, client)
+		}
+		seen[client] = true
+	}
+	for _, pvc := range pvcList.PVCs() {
+		if !isShared(pvc.Claim()) {
+			return fmt.Errorf("%w: %s", ErrPVCNotShared, ctrlclient.ObjectKeyFromObject(pvc.Claim()))
+		}
+	}
+	return nil
+}
+
+// validateFanOutClient returns an error when the options of a fan-out client are incomplete
+func validateFanOutClient(podOptions transfer.PodOptions, options Options, mode Mode) error {
+	if options.FanOutClient == "" {
+		return nil
+	}
+	switch {
+	case mode != ModeDaemon:
+		return fmt.Errorf("%w: fan-out requires rsync mode %s", ErrFanOutInvalid, ModeDaemon)
+	case options.FanOutCredentials.Name == "":
+		return fmt.Errorf("%w: fan-out clients require credentials", ErrFanOutInvalid)
+	case podOptions.ReadOnlySource:
+		return fmt.Errorf("%w: fan-out clients write their PVCs", ErrFanOutInvalid)
+	case podOptions.PreScan:
+		return fmt.Errorf("%w: fan-out clients do not support pre-scans", ErrFanOutInvalid)
+	}
+	if errs := validation.IsDNS1123Label(options.FanOutClient); len(errs) > 0 {
+		return fmt.Errorf("%w: client name %s: %s", ErrFanOutInvalid, options.FanOutClient, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+func isShared(pvc *corev1.PersistentVolumeClaim) bool {
+	for _, mode := range pvc.Spec.AccessModes {
+		if mode == corev1.ReadWriteMany || mode == corev1.ReadOnlyMany {
+			return true
+		}
+	}
+	return false
+}
+
+// getFanOutModule returns the name of the rsync module of a PVC for a fan-out client
+func getFanOutModule(pvc transfer.PVC, client string) string {
+	return fmt.Sprintf("%s-%s", pvc.LabelSafeName(), client)
+}
+
+// FanOutCredentials returns the secret holding the password of the given fan-out client
+func (s *server) FanOutCredentials(client string) types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("%s-%s-%s", rsyncFanOutSecret, client, s.nameSuffix),
+	}
+}
+
+// fanOutSecretsName returns the secret holding the rsyncd secrets file of the fan-out clients
+func (s *server) fanOutSecretsName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("%s-%s", rsyncFanOutSecret, s.nameSuffix),
+	}
+}
+
+// reconcileFanOutSecrets generates a password for every fan-out client once and writes the
+// rsyncd secrets file authenticating all of them
+func (s *server) reconcileFanOutSecrets(ctx context.Context, c ctrlclient.Client, namespace string) error {
+	passwords := map[string]string{}
+	for _, client := range s.fanOutClients {
+		password, err := reconcileFanOutPassword(ctx, c, s.logger, s.FanOutCredentials(client), s.labels, s.ownerRefs, s.options)
+		if err != nil {
+			return err
+		}
+		passwords[client] = password
+	}
+
+	clients := append([]string{}, s.fanOutClients...)
+	sort.Strings(clients)
+	secretsFile := strings.Builder{}
+	for _, client := range clients {
+		fmt.Fprintf(&secretsFile, "%s:%s\n", client, passwords[client])
+	}
+	key := s.fanOutSecretsName()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+	}
+	_, err := reconcile.CreateOrUpdate(ctx, c, s.logger, secret, reconcileOptions(s.options), func() error {
+		secret.Labels = getLabels(s.labels, s.options)
+		secret.Annotations = getAnnotations(secret.Annotations, s.options)
+		secret.OwnerReferences = s.ownerRefs
+		secret.Data = map[string][]byte{
+			fanOutSecretsKey: []byte(secretsFile.String()),
+		}
+		return nil
+	})
+	return err
+}
+
+// reconcileFanOutPassword returns the password of the secret, generating it when the secret does not exist
+func reconcileFanOutPassword(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	secretRef types.NamespacedName,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	options transfer.PodOptions) (string, error) {
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, secretRef, existing)
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		return "", err
+	case len(existing.Data[fanOutPasswordKey]) > 0:
+		return string(existing.Data[fanOutPasswordKey]), nil
+	}
+
+	logger.Info("generating fan-out client password", "secret", secretRef)
+	password, err := secrets.GeneratePassword()
+	if err != nil {
+		return "", err
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secretRef.Namespace,
+			Name:      secretRef.Name,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, secret, reconcileOptions(options), func() error {
+		secret.Labels = getLabels(labels, options)
+		secret.Annotations = getAnnotations(secret.Annotations, options)
+		secret.OwnerReferences = ownerRefs
+		secret.Data = map[string][]byte{
+			fanOutPasswordKey: []byte(password),
+		}
+		return nil
+	})
+	return password, err
+}
+
+// getFanOutSecretsVolume returns the volume of the rsyncd secrets file, rsync refuses secrets
+// files readable by others
+func (s *server) getFanOutSecretsVolume() corev1.Volume {
+	mode := int32(0400)
+	return corev1.Volume{
+		Name: rsyncFanOutSecret,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  s.fanOutSecretsName().Name,
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
+
+func getFanOutSecretsVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      rsyncFanOutSecret,
+		MountPath: fanOutSecretsMountPath,
+		SubPath:   fanOutSecretsKey,
+	}
+}
+
+// getFanOutPasswordVolume returns the volume of the password of a fan-out client, rsync refuses
+// password files readable by others
+func getFanOutPasswordVolume(secretName string) corev1.Volume {
+	mode := int32(0400)
+	return corev1.Volume{
+		Name: fanOutPasswordVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  secretName,
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
+
+func getFanOutPasswordVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      fanOutPasswordVolumeName,
+		MountPath: fanOutPasswordMountPath,
+	}
+}
+
+// getFanOutCommand returns the rsync command of a fan-out client pulling pvc from its module
+func (tc *client) getFanOutCommand(rsyncOptions []string, pvc transfer.PVC) []string {
+	connection := tc.Transport().ConnectionInfo()
+	rsyncCommand := []string{getRsyncBinary(tc.options)}
+	rsyncCommand = append(rsyncCommand, rsyncOptions...)
+	rsyncCommand = append(rsyncCommand, fmt.Sprintf("--password-file=%s/%s", fanOutPasswordMountPath, fanOutPasswordKey))
+	rsyncCommand = append(rsyncCommand, strings.Fields(getRsyncURL(tc.fanOutClient, connection, getFanOutModule(pvc, tc.fanOutClient)))...)
+	rsyncCommand = append(rsyncCommand,
+		getLocalPath(tc.options, fmt.Sprintf("/mnt/%s/%s/", pvc.Claim().Namespace, pvc.LabelSafeName())))
+	return rsyncCommand
+}
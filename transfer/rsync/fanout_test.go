@@ -0,0 +1,195 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_validateFanOutServer(t *testing.T) {
+	shared := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteMany}},
+	})
+	notShared := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		Spec:       corev1.PersistentVolumeClaimSpec{AccessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce}},
+	})
+	terminate := true
+	tests := []struct {
+		name       string
+		pvcList    transfer.PVCList
+		podOptions transfer.PodOptions
+		options    Options
+		mode       Mode
+		wantErr    error
+	}{
+		{
+			name:    "no fan-out, must be valid",
+			pvcList: notShared,
+			mode:    ModeDaemon,
+		},
+		{
+			name:    "fan-out of a RWX PVC, must be valid",
+			pvcList: shared,
+			options: Options{FanOutClients: []string{"cluster-a", "cluster-b"}},
+			mode:    ModeDaemon,
+		},
+		{
+			name:    "fan-out of a RWO PVC, must return ErrPVCNotShared",
+			pvcList: notShared,
+			options: Options{FanOutClients: []string{"cluster-a"}},
+			mode:    ModeDaemon,
+			wantErr: ErrPVCNotShared,
+		},
+		{
+			name:    "duplicate client names, must return ErrFanOutInvalid",
+			pvcList: shared,
+			options: Options{FanOutClients: []string{"cluster-a", "cluster-a"}},
+			mode:    ModeDaemon,
+			wantErr: ErrFanOutInvalid,
+		},
+		{
+			name:    "invalid client name, must return ErrFanOutInvalid",
+			pvcList: shared,
+			options: Options{FanOutClients: []string{"Cluster_A"}},
+			mode:    ModeDaemon,
+			wantErr: ErrFanOutInvalid,
+		},
+		{
+			name:    "ssh mode, must return ErrFanOutInvalid",
+			pvcList: shared,
+			options: Options{FanOutClients: []string{"cluster-a"}},
+			mode:    ModeSSH,
+			wantErr: ErrFanOutInvalid,
+		},
+		{
+			name:       "terminate on completion, must return ErrFanOutInvalid",
+			pvcList:    shared,
+			podOptions: transfer.PodOptions{TerminateOnCompletion: &terminate},
+			options:    Options{FanOutClients: []string{"cluster-a"}},
+			mode:       ModeDaemon,
+			wantErr:    ErrFanOutInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFanOutServer(tt.pvcList, tt.podOptions, tt.options, tt.mode)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("validateFanOutServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_validateFanOutClient(t *testing.T) {
+	credentials := types.NamespacedName{Namespace: "bar", Name: "rsync-fanout-cluster-a"}
+	tests := []struct {
+		name       string
+		podOptions transfer.PodOptions
+		options    Options
+		wantErr    bool
+	}{
+		{
+			name: "no fan-out, must be valid",
+		},
+		{
+			name:    "fan-out client with credentials, must be valid",
+			options: Options{FanOutClient: "cluster-a", FanOutCredentials: credentials},
+		},
+		{
+			name:    "fan-out client without credentials, must return an error",
+			options: Options{FanOutClient: "cluster-a"},
+			wantErr: true,
+		},
+		{
+			name:       "fan-out client with a read-only PVC, must return an error",
+			podOptions: transfer.PodOptions{ReadOnlySource: true},
+			options:    Options{FanOutClient: "cluster-a", FanOutCredentials: credentials},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateFanOutClient(tt.podOptions, tt.options, ModeDaemon)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateFanOutClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_server_reconcileFanOutSecrets(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fakeClientWithObjects()
+	s := &server{
+		logger:        testr.New(t),
+		namespace:     "foo",
+		nameSuffix:    "foo",
+		labels:        map[string]string{"test": "me"},
+		ownerRefs:     testOwnerReferences(),
+		fanOutClients: []string{"cluster-b", "cluster-a"},
+	}
+	if err := s.reconcileFanOutSecrets(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcileFanOutSecrets() error = %v", err)
+	}
+	passwords := map[string]string{}
+	for _, client := range s.fanOutClients {
+		secret := &corev1.Secret{}
+		if err := fakeClient.Get(ctx, s.FanOutCredentials(client), secret); err != nil {
+			t.Fatalf("credentials of %s not created, error = %v", client, err)
+		}
+		passwords[client] = string(secret.Data[fanOutPasswordKey])
+		if passwords[client] == "" {
+			t.Errorf("credentials of %s have no password", client)
+		}
+	}
+	if passwords["cluster-a"] == passwords["cluster-b"] {
+		t.Error("fan-out clients share the same password")
+	}
+
+	// passwords are kept across reconciles
+	if err := s.reconcileFanOutSecrets(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcileFanOutSecrets() error = %v", err)
+	}
+	secrets := &corev1.Secret{}
+	if err := fakeClient.Get(ctx, s.fanOutSecretsName(), secrets); err != nil {
+		t.Fatalf("rsyncd secrets not created, error = %v", err)
+	}
+	want := "cluster-a:" + passwords["cluster-a"] + "\ncluster-b:" + passwords["cluster-b"] + "\n"
+	if got := string(secrets.Data[fanOutSecretsKey]); got != want {
+		t.Errorf("rsyncd secrets = %q, want %q", got, want)
+	}
+}
+
+func Test_client_getCommand_fanOut(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tc := &client{
+		username:        "root",
+		fanOutClient:    "cluster-a",
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	want := []string{"/usr/bin/rsync", "-a", "--password-file=" + fanOutPasswordMountPath + "/" + fanOutPasswordKey,
+		"rsync://cluster-a@foo.bar.dev/data-cluster-a/", "--port", "8080", "/mnt/foo/data/"}
+	if got := tc.getFanOutCommand([]string{"-a"}, pvc); !reflect.DeepEqual(got, want) {
+		t.Errorf("getFanOutCommand() = %v, want %v", got, want)
+	}
+	script := tc.getCommand([]string{"-a"}, pvc)[2]
+	if !strings.Contains(script, strings.Join(want, " ")) {
+		t.Errorf("rsync script does not pull from the fan-out module")
+	}
+	if strings.Contains(script, "rsync://root@") {
+		t.Errorf("rsync script pushes to the fan-out server")
+	}
+}
@@ -0,0 +1,345 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transfer"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrIntegrityManifestNotSupported is returned when the integrity manifest is enabled with
+	// options which do not push the data of the source to the server
+	ErrIntegrityManifestNotSupported = errors.New("integrity manifest not supported")
+)
+
+const (
+	// ManifestFile is the checksum manifest of a PVC written at the root of the destination PVC,
+	// it holds the sha256 of every file of the source PVC in the format of sha256sum
+	ManifestFile = ".pvc-transfer.sha256"
+	// VerifyContainer is the name of the container of the integrity verification Job
+	VerifyContainer = "verify"
+	// integrityResultKey holds Verified or Failed in the integrity ConfigMap, the result of each
+	// PVC is held in the key of its label safe name
+	integrityResultKey    = "result"
+	integrityMessageKey   = "message"
+	integrityResultPassed = "Verified"
+	integrityResultFailed = "Failed"
+	integrityMountPath    = "/mnt"
+	manifestLocalPath     = rsyncCommunicationMountPath + "/" + ManifestFile
+	jobNameLabel          = "job-name"
+)
+
+// manifestScript writes the manifest of the source PVC mounted at the first argument to the
+// third argument and sends it with the command of the fourth argument, the transfer fails when
+// the manifest cannot be sent
+const manifestScript = `    echo "Generating integrity manifest..."
+    if ! (cd %[1]s && find . -xdev -type f ! -path ./%[2]s -print0 | sort -z | xargs -0 -r sha256sum) > %[3]s || ! %[4]s; then
+        echo "Integrity manifest failed."
+        exit 1
+    fi
+`
+
+// verifyScript checks the manifest of each PVC mounted under the first argument, the PVCs are
+// listed in the second argument. A line per PVC is written to the termination message, either
+// "<pvc> files=<files> failed=<failed>" or "<pvc> missing" when the manifest was not received.
+const verifyScript = `for pvc in %[2]s; do
+	manifest="%[1]s/${pvc}/%[3]s"
+	if [ ! -f "${manifest}" ]; then
+		echo "integrity manifest of ${pvc} missing"
+		echo "${pvc} missing" >> /dev/termination-log
+		continue
+	fi
+	files=$(grep -c '' "${manifest}")
+	failed=$(cd "%[1]s/${pvc}" && sha256sum -c --quiet %[3]s 2>/dev/null | tee /dev/stderr | grep -c ': FAILED')
+	echo "${pvc} files=${files} failed=${failed}" >> /dev/termination-log
+done
+`
+
+// validateIntegrityManifest returns an error when the integrity manifest is enabled for a
+// server or a client which does not push the PVCs of the client with the rsync script
+func validateIntegrityManifest(options Options) error {
+	if !options.IntegrityManifest {
+		return nil
+	}
+	switch {
+	case options.Pull:
+		return fmt.Errorf("%w: pulling clients receive the data", ErrIntegrityManifestNotSupported)
+	case len(options.FanOutClients) > 0 || options.FanOutClient != "":
+		return fmt.Errorf("%w: fan-out clients receive the data", ErrIntegrityManifestNotSupported)
+	case options.Agent:
+		return fmt.Errorf("%w: the rsync agent does not generate manifests", ErrIntegrityManifestNotSupported)
+	}
+	return nil
+}
+
+// validateIntegrityManifestServer validates the integrity manifest of a server, which must also
+// terminate on completion since the verification starts once the server completed
+func validateIntegrityManifestServer(podOptions transfer.PodOptions, options Options) error {
+	err := validateIntegrityManifest(options)
+	if err != nil {
+		return err
+	}
+	if options.IntegrityManifest && !serverTerminatesOnCompletion(podOptions, options) {
+		return fmt.Errorf("%w: the server must terminate on completion to be verified", ErrIntegrityManifestNotSupported)
+	}
+	return nil
+}
+
+// getManifestScript returns the script generating the manifest of pvc and sending it to the
+// module of the PVC on the server, it is empty when the integrity manifest is disabled
+func (tc *client) getManifestScript(pvc transfer.PVC) string {
+	if !tc.integrityManifest {
+		return ""
+	}
+	connection := tc.Transport().ConnectionInfo()
+	sendCommand := fmt.Sprintf("/usr/bin/rsync %s %s", manifestLocalPath, getRsyncURL(tc.username, connection, pvc.LabelSafeName()))
+	if tc.mode == ModeSSH {
+		sendCommand = fmt.Sprintf("/usr/bin/rsync -e %q %s %s@%s:%s/%s/", getSSHCommand(connection.Port), manifestLocalPath,
+			tc.username, connection.Hostname, sshDataMountPath, pvc.LabelSafeName())
+	}
+	return fmt.Sprintf(manifestScript,
+		fmt.Sprintf("/mnt/%s/%s", pvc.Claim().Namespace, pvc.LabelSafeName()),
+		ManifestFile,
+		manifestLocalPath,
+		sendCommand)
+}
+
+// integrityName returns the namespaced name of the verification Job and of the ConfigMap
+// holding its result
+func (s *server) integrityName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("rsync-integrity-%s", s.nameSuffix),
+	}
+}
+
+// VerifyIntegrity runs a Job checking the PVCs of the server against the manifests sent by the
+// client once the server completed, see Options.IntegrityManifest. The result is kept in a
+// ConfigMap which is not marked for cleanup, the Job is.
+func (s *server) VerifyIntegrity(ctx context.Context, c ctrlclient.Client) (*transfer.Integrity, error) {
+	if !s.integrityManifest {
+		return nil, fmt.Errorf("%w: integrity manifest not enabled", ErrIntegrityManifestNotSupported)
+	}
+	key := s.integrityName()
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, key, cm)
+	switch {
+	case err == nil:
+		return getIntegrity(key, cm.Data), nil
+	case !k8serrors.IsNotFound(err):
+		return nil, err
+	}
+
+	completed, err := s.Completed(ctx, c)
+	if err != nil || !completed {
+		return nil, err
+	}
+
+	job, err := s.reconcileIntegrityJob(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	var data map[string]string
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			data, err = s.getIntegrityJobResult(ctx, c, job)
+			if err != nil {
+				return nil, err
+			}
+		case batchv1.JobFailed:
+			data = map[string]string{
+				integrityResultKey:  integrityResultFailed,
+				integrityMessageKey: fmt.Sprintf("verification job failed: %s", condition.Message),
+			}
+		}
+	}
+	if data == nil {
+		s.logger.Info("waiting for the integrity verification to complete")
+		return nil, nil
+	}
+
+	cm = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, s.logger, cm, reconcileOptions(s.options), func() error {
+		cm.Labels = getLabels(s.labels, s.options)
+		cm.OwnerReferences = s.ownerRefs
+		cm.Data = data
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return getIntegrity(key, data), nil
+}
+
+// reconcileIntegrityJob creates the verification Job, mounting the PVCs of the server read-only
+func (s *server) reconcileIntegrityJob(ctx context.Context, c ctrlclient.Client) (*batchv1.Job, error) {
+	volumes := []corev1.Volume{}
+	volumeMounts := []corev1.VolumeMount{}
+	pvcs := []string{}
+	for _, pvc := range s.pvcList.InNamespace(s.namespace).PVCs() {
+		volumes = append(volumes, corev1.Volume{
+			Name: pvc.LabelSafeName(),
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvc.Claim().Name,
+					ReadOnly:  true,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      pvc.LabelSafeName(),
+			MountPath: fmt.Sprintf("%s/%s", integrityMountPath, pvc.LabelSafeName()),
+			ReadOnly:  true,
+		})
+		pvcs = append(pvcs, pvc.LabelSafeName())
+	}
+	containers := []corev1.Container{{
+		Name:         VerifyContainer,
+		Command:      []string{"/bin/bash", "-c", fmt.Sprintf(verifyScript, integrityMountPath, strings.Join(pvcs, " "), ManifestFile)},
+		VolumeMounts: volumeMounts,
+	}}
+	applyContainerOptions(containers, s.options)
+	applySELinuxOptions(containers, s.options)
+	err := s.options.ValidateExtraVolumeNames(volumes)
+	if err != nil {
+		return nil, err
+	}
+
+	podSpec := corev1.PodSpec{
+		Containers:         containers,
+		Volumes:            volumes,
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: s.options.ServiceAccountName,
+		ImagePullSecrets:   s.options.ImagePullSecrets,
+	}
+	applyPodOptions(&podSpec, s.options)
+
+	key := s.integrityName()
+	backoffLimit := int32(0)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, s.logger, job, reconcileOptions(s.options), func() error {
+		job.Labels = getLabels(s.labels, s.options)
+		job.Annotations = getAnnotations(job.Annotations, s.options)
+		job.OwnerReferences = s.ownerRefs
+		// the pod template of jobs is immutable
+		if job.CreationTimestamp.IsZero() {
+			template := metav1.ObjectMeta{Labels: getLabels(s.labels, s.options)}
+			applyServiceMeshMode(&template, s.options)
+			applySCC(&template, s.options)
+			job.Spec = batchv1.JobSpec{
+				BackoffLimit: &backoffLimit,
+				Template: corev1.PodTemplateSpec{
+					ObjectMeta: template,
+					Spec:       podSpec,
+				},
+			}
+		}
+		return nil
+	})
+	return job, err
+}
+
+// getIntegrityJobResult returns the data of the integrity ConfigMap from the termination
+// message of the pod of the completed verification Job
+func (s *server) getIntegrityJobResult(ctx context.Context, c ctrlclient.Client, job *batchv1.Job) (map[string]string, error) {
+	pods := &corev1.PodList{}
+	err := c.List(ctx, pods, ctrlclient.InNamespace(job.Namespace), ctrlclient.MatchingLabels{jobNameLabel: job.Name})
+	if err != nil {
+		return nil, err
+	}
+	for _, pod := range pods.Items {
+		for _, containerStatus := range pod.Status.ContainerStatuses {
+			terminated := containerStatus.State.Terminated
+			if containerStatus.Name == VerifyContainer && terminated != nil && terminated.ExitCode == 0 {
+				return parseVerifyMessage(terminated.Message), nil
+			}
+		}
+	}
+	return map[string]string{
+		integrityResultKey:  integrityResultFailed,
+		integrityMessageKey: "verification job completed without result",
+	}, nil
+}
+
+// parseVerifyMessage returns the data of the integrity ConfigMap from the termination message
+// written by verifyScript
+func parseVerifyMessage(message string) map[string]string {
+	data := map[string]string{integrityResultKey: integrityResultPassed}
+	failed := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(message), "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		pvc, result := fields[0], fields[1]
+		data[pvc] = result
+		if result == "missing" || !strings.HasSuffix(result, " failed=0") {
+			failed = append(failed, pvc)
+		}
+	}
+	if len(data) == 1 {
+		data[integrityResultKey] = integrityResultFailed
+		data[integrityMessageKey] = "no PVC verified"
+	}
+	if len(failed) > 0 {
+		data[integrityResultKey] = integrityResultFailed
+		data[integrityMessageKey] = fmt.Sprintf("verification failed for %s", strings.Join(failed, ", "))
+	}
+	return data
+}
+
+// getIntegrity returns the integrity of the data of the integrity ConfigMap
+func getIntegrity(key types.NamespacedName, data map[string]string) *transfer.Integrity {
+	integrity := &transfer.Integrity{
+		Verified:  data[integrityResultKey] == integrityResultPassed,
+		Message:   data[integrityMessageKey],
+		ConfigMap: key,
+	}
+	for pvc, result := range data {
+		if pvc == integrityResultKey || pvc == integrityMessageKey {
+			continue
+		}
+		for _, field := range strings.Fields(result) {
+			parts := strings.SplitN(field, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			value, err := strconv.ParseInt(parts[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch parts[0] {
+			case "files":
+				integrity.FilesVerified += value
+			case "failed":
+				integrity.FilesFailed += value
+			}
+		}
+	}
+	return integrity
+}
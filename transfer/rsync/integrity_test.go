@@ -0,0 +1,232 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+)
+
+func Test_validateIntegrityManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		wantErr bool
+	}{
+		{name: "integrity manifest disabled, must be valid", options: Options{Pull: true}},
+		{name: "integrity manifest, must be valid", options: Options{IntegrityManifest: true, Mode: ModeSSH}},
+		{name: "integrity manifest with pull, must be invalid", options: Options{IntegrityManifest: true, Pull: true}, wantErr: true},
+		{name: "integrity manifest with fan-out, must be invalid", options: Options{IntegrityManifest: true, FanOutClient: "bar"}, wantErr: true},
+		{name: "integrity manifest with the agent, must be invalid", options: Options{IntegrityManifest: true, Agent: true}, wantErr: true},
+		{
+			name:    "integrity manifest with a client not terminating on completion, must be valid",
+			options: Options{IntegrityManifest: true, TerminateOnCompletion: pointer.Bool(false)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIntegrityManifest(tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateIntegrityManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIntegrityManifestNotSupported) {
+				t.Errorf("validateIntegrityManifest() error = %v, want ErrIntegrityManifestNotSupported", err)
+			}
+		})
+	}
+}
+
+func Test_validateIntegrityManifestServer(t *testing.T) {
+	tests := []struct {
+		name       string
+		podOptions transfer.PodOptions
+		options    Options
+		wantErr    bool
+	}{
+		{name: "integrity manifest disabled, must be valid", options: Options{TerminateOnCompletion: pointer.Bool(false)}},
+		{name: "integrity manifest, must be valid", options: Options{IntegrityManifest: true}},
+		{name: "integrity manifest with fan-out, must be invalid", options: Options{IntegrityManifest: true, FanOutClients: []string{"bar"}}, wantErr: true},
+		{
+			name:    "integrity manifest with a server not terminating on completion, must be invalid",
+			options: Options{IntegrityManifest: true, TerminateOnCompletion: pointer.Bool(false)},
+			wantErr: true,
+		},
+		{
+			name:       "integrity manifest with pods not terminating on completion, must be invalid",
+			podOptions: transfer.PodOptions{TerminateOnCompletion: pointer.Bool(false)},
+			options:    Options{IntegrityManifest: true},
+			wantErr:    true,
+		},
+		{
+			name:       "integrity manifest with a server terminating on completion, must be valid",
+			podOptions: transfer.PodOptions{TerminateOnCompletion: pointer.Bool(false)},
+			options:    Options{IntegrityManifest: true, TerminateOnCompletion: pointer.Bool(true)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIntegrityManifestServer(tt.podOptions, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateIntegrityManifestServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrIntegrityManifestNotSupported) {
+				t.Errorf("validateIntegrityManifestServer() error = %v, want ErrIntegrityManifestNotSupported", err)
+			}
+		})
+	}
+}
+
+func Test_client_getCommand_integrityManifest(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	for _, integrityManifest := range []bool{false, true} {
+		tc := &client{
+			username:          "root",
+			transportClient:   &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			integrityManifest: integrityManifest,
+		}
+		script := tc.getCommand([]string{"-a"}, pvc)[2]
+		if got := strings.Contains(script, "sha256sum"); got != integrityManifest {
+			t.Errorf("rsync script with integrity manifest %v generates manifest = %v", integrityManifest, got)
+		}
+		if integrityManifest && !strings.Contains(script, "/usr/bin/rsync "+manifestLocalPath+" rsync://root@") {
+			t.Errorf("rsync script does not send the manifest to the server:\n%s", script)
+		}
+	}
+}
+
+func Test_parseVerifyMessage(t *testing.T) {
+	tests := []struct {
+		name          string
+		message       string
+		wantVerified  bool
+		wantFiles     int64
+		wantFailed    int64
+		wantMessage   string
+		wantPVCResult map[string]string
+	}{
+		{
+			name:          "all files verified, must be verified",
+			message:       "pvc-a files=10 failed=0\npvc-b files=5 failed=0\n",
+			wantVerified:  true,
+			wantFiles:     15,
+			wantPVCResult: map[string]string{"pvc-a": "files=10 failed=0", "pvc-b": "files=5 failed=0"},
+		},
+		{
+			name:          "files failed, must not be verified",
+			message:       "pvc-a files=10 failed=2\npvc-b files=5 failed=0\n",
+			wantFiles:     15,
+			wantFailed:    2,
+			wantMessage:   "verification failed for pvc-a",
+			wantPVCResult: map[string]string{"pvc-a": "files=10 failed=2"},
+		},
+		{
+			name:          "manifest missing, must not be verified",
+			message:       "pvc-a missing\n",
+			wantMessage:   "verification failed for pvc-a",
+			wantPVCResult: map[string]string{"pvc-a": "missing"},
+		},
+		{
+			name:        "empty message, must not be verified",
+			wantMessage: "no PVC verified",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := parseVerifyMessage(tt.message)
+			for pvc, want := range tt.wantPVCResult {
+				if data[pvc] != want {
+					t.Errorf("parseVerifyMessage() result of %s = %q, want %q", pvc, data[pvc], want)
+				}
+			}
+			got := getIntegrity(types.NamespacedName{Namespace: "foo", Name: "bar"}, data)
+			if got.Verified != tt.wantVerified || got.FilesVerified != tt.wantFiles || got.FilesFailed != tt.wantFailed {
+				t.Errorf("getIntegrity() = %+v, want verified %v, files %d, failed %d", got, tt.wantVerified, tt.wantFiles, tt.wantFailed)
+			}
+			if got.Message != tt.wantMessage {
+				t.Errorf("getIntegrity() message = %q, want %q", got.Message, tt.wantMessage)
+			}
+		})
+	}
+}
+
+func Test_server_VerifyIntegrity(t *testing.T) {
+	ctx := context.Background()
+	serverPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-server-foo", Namespace: "foo"},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+			{Name: RsyncContainer, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+			{Name: stunnel.Container, State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}},
+		}},
+	}
+	fakeClient := fakeClientWithObjects(serverPod)
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer:   &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		nameSuffix:        "foo",
+		namespace:         "foo",
+		labels:            map[string]string{"test": "me"},
+		integrityManifest: true,
+	}
+
+	integrity, err := s.VerifyIntegrity(ctx, fakeClient)
+	if err != nil || integrity != nil {
+		t.Fatalf("VerifyIntegrity() = %v, %v, want nil until the job completed", integrity, err)
+	}
+	job := &batchv1.Job{}
+	if err := fakeClient.Get(ctx, s.integrityName(), job); err != nil {
+		t.Fatalf("unable to get job %v", err)
+	}
+	mounts := job.Spec.Template.Spec.Containers[0].VolumeMounts
+	pvcName := s.pvcList.PVCs()[0].LabelSafeName()
+	if len(mounts) != 1 || !mounts[0].ReadOnly || mounts[0].MountPath != "/mnt/"+pvcName {
+		t.Errorf("job volume mounts = %v, want %s mounted read-only", mounts, pvcName)
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobComplete, Status: corev1.ConditionTrue}}
+	if err := fakeClient.Status().Update(ctx, job); err != nil {
+		t.Fatalf("unable to update job %v", err)
+	}
+	verifyPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-integrity-foo-abcde", Namespace: "foo", Labels: map[string]string{jobNameLabel: job.Name}},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:  VerifyContainer,
+			State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: pvcName + " files=3 failed=0\n"}},
+		}}},
+	}
+	if err := fakeClient.Create(ctx, verifyPod); err != nil {
+		t.Fatalf("unable to create pod %v", err)
+	}
+	integrity, err = s.VerifyIntegrity(ctx, fakeClient)
+	if err != nil || integrity == nil {
+		t.Fatalf("VerifyIntegrity() = %v, %v, want a result", integrity, err)
+	}
+	if !integrity.Verified || integrity.FilesVerified != 3 || integrity.ConfigMap != s.integrityName() {
+		t.Errorf("VerifyIntegrity() = %+v, want 3 files verified", integrity)
+	}
+
+	// the result is kept once the job and its pods are deleted
+	if err := fakeClient.Delete(ctx, job); err != nil {
+		t.Fatalf("unable to delete job %v", err)
+	}
+	if err := fakeClient.Delete(ctx, verifyPod); err != nil {
+		t.Fatalf("unable to delete pod %v", err)
+	}
+	integrity, err = s.VerifyIntegrity(ctx, fakeClient)
+	if err != nil || integrity == nil || !integrity.Verified {
+		t.Errorf("VerifyIntegrity() = %v, %v, want the recorded result", integrity, err)
+	}
+}
@@ -0,0 +1,74 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ErrOptionsInvalid is returned when the options of a server or a client miss a required field
+var ErrOptionsInvalid = errors.New("rsync server or client options invalid")
+
+// ServerOptions are the parameters of NewServerFromOptions
+type ServerOptions struct {
+	// PVCList are the PVCs served by the server, they must be in a single namespace
+	PVCList transfer.PVCList
+	// Transport is the transport server the rsync server listens behind
+	Transport transport.Transport
+	// Endpoint exposes the transport server to the client
+	Endpoint endpoint.Endpoint
+	// Labels are applied to all the resources of the server
+	Labels map[string]string
+	// OwnerReferences are applied to all the resources of the server
+	OwnerReferences []metav1.OwnerReference
+	// PodOptions configure the server pod
+	PodOptions transfer.PodOptions
+	// Options select the rsync mode and the other rsync options of the server
+	Options Options
+}
+
+// Validate returns an error wrapping ErrOptionsInvalid when a required field is not set, the
+// options are further validated by NewServerFromOptions
+func (o ServerOptions) Validate() error {
+	switch {
+	case o.PVCList == nil:
+		return fmt.Errorf("%w: PVC list not set", ErrOptionsInvalid)
+	case o.Transport == nil:
+		return fmt.Errorf("%w: transport not set", ErrOptionsInvalid)
+	case o.Endpoint == nil:
+		return fmt.Errorf("%w: endpoint not set", ErrOptionsInvalid)
+	}
+	return nil
+}
+
+// ClientOptions are the parameters of NewClientFromOptions
+type ClientOptions struct {
+	// PVCList are the PVCs synced by the client, they must be in a single namespace
+	PVCList transfer.PVCList
+	// Transport is the transport client connecting to the transport server
+	Transport transport.Transport
+	// Labels are applied to all the resources of the client
+	Labels map[string]string
+	// OwnerReferences are applied to all the resources of the client
+	OwnerReferences []metav1.OwnerReference
+	// PodOptions configure the client pods
+	PodOptions transfer.PodOptions
+	// Options select the rsync mode and the other rsync options of the client
+	Options Options
+}
+
+// Validate returns an error wrapping ErrOptionsInvalid when a required field is not set, the
+// options are further validated by NewClientFromOptions
+func (o ClientOptions) Validate() error {
+	switch {
+	case o.PVCList == nil:
+		return fmt.Errorf("%w: PVC list not set", ErrOptionsInvalid)
+	case o.Transport == nil:
+		return fmt.Errorf("%w: transport not set", ErrOptionsInvalid)
+	}
+	return nil
+}
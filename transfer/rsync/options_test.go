@@ -0,0 +1,103 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+)
+
+type fakeEndpoint struct {
+	endpoint.Endpoint
+}
+
+func TestServerOptions_Validate(t *testing.T) {
+	pvcList := transfer.NewSingletonPVC(testPVC("foo", "data"))
+	transportServer := &fakeTransportServer{transportType: stunnel.TransportTypeStunnel}
+	tests := []struct {
+		name    string
+		options ServerOptions
+		wantErr error
+	}{
+		{
+			name:    "all required fields set, must be valid",
+			options: ServerOptions{PVCList: pvcList, Transport: transportServer, Endpoint: &fakeEndpoint{}},
+		},
+		{
+			name:    "no PVC list, must return ErrOptionsInvalid",
+			options: ServerOptions{Transport: transportServer, Endpoint: &fakeEndpoint{}},
+			wantErr: ErrOptionsInvalid,
+		},
+		{
+			name:    "no transport, must return ErrOptionsInvalid",
+			options: ServerOptions{PVCList: pvcList, Endpoint: &fakeEndpoint{}},
+			wantErr: ErrOptionsInvalid,
+		},
+		{
+			name:    "no endpoint, must return ErrOptionsInvalid",
+			options: ServerOptions{PVCList: pvcList, Transport: transportServer},
+			wantErr: ErrOptionsInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientOptions_Validate(t *testing.T) {
+	pvcList := transfer.NewSingletonPVC(testPVC("foo", "data"))
+	transportClient := &fakeTransportClient{transportType: stunnel.TransportTypeStunnel}
+	tests := []struct {
+		name    string
+		options ClientOptions
+		wantErr error
+	}{
+		{
+			name:    "all required fields set, must be valid",
+			options: ClientOptions{PVCList: pvcList, Transport: transportClient},
+		},
+		{
+			name:    "no PVC list, must return ErrOptionsInvalid",
+			options: ClientOptions{Transport: transportClient},
+			wantErr: ErrOptionsInvalid,
+		},
+		{
+			name:    "no transport, must return ErrOptionsInvalid",
+			options: ClientOptions{PVCList: pvcList},
+			wantErr: ErrOptionsInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.Validate()
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewClientFromOptions(t *testing.T) {
+	ctx := context.Background()
+	_, err := NewClientFromOptions(ctx, fakeClientWithObjects(), testr.New(t), ClientOptions{})
+	if !errors.Is(err, ErrOptionsInvalid) {
+		t.Errorf("NewClientFromOptions() error = %v, want %v", err, ErrOptionsInvalid)
+	}
+	_, err = NewClientFromOptions(ctx, fakeClientWithObjects(), testr.New(t), ClientOptions{
+		PVCList:   transfer.NewSingletonPVC(testPVC("foo", "data")),
+		Transport: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+		Options:   Options{Mode: ModeSSH},
+	})
+	if !errors.Is(err, ErrSSHCredentialsMissing) {
+		t.Errorf("NewClientFromOptions() error = %v, want %v", err, ErrSSHCredentialsMissing)
+	}
+}
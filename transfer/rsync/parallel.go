@@ -0,0 +1,101 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrStreamsInvalid is returned when the parallel streams of a client cannot be used
+	ErrStreamsInvalid = errors.New("parallel streams invalid")
+)
+
+// shardsDir holds the lists of files of the shards of a PVC and the output of their rsync
+const shardsDir = rsyncCommunicationMountPath + "/shards"
+
+// shardsFunction is the name of the bash function running the shards of a PVC in parallel
+const shardsFunction = "rsync_shards"
+
+// validateStreams returns an error when a client cannot transfer its PVCs in parallel streams
+func validateStreams(options Options, mode Mode) error {
+	switch {
+	case options.Streams < 0:
+		return fmt.Errorf("%w: streams must not be negative", ErrStreamsInvalid)
+	case options.Streams <= 1:
+		return nil
+	case mode != ModeDaemon:
+		return fmt.Errorf("%w: streams require rsync mode %s", ErrStreamsInvalid, ModeDaemon)
+	case options.Pull || options.FanOutClient != "":
+		return fmt.Errorf("%w: pulling clients do not list the files of the source", ErrStreamsInvalid)
+	case options.Agent:
+		return fmt.Errorf("%w: the rsync agent runs a single rsync", ErrStreamsInvalid)
+	}
+	return nil
+}
+
+// getShardsScript returns the definition of the bash function shardsFunction, which
+// partitions the top-level entries of the source of rsyncCommand in the given number of shards
+// and transfers them with as many rsync processes in parallel. The function passes its
+// arguments to each rsync and prints their output, its exit code is the exit code of the first
+// shard failing, or the highest success exit code. When rsyncCommand deletes files, a last rsync
+// deletes the top-level entries of the destination missing from the source, which no shard
+// transfers.
+func getShardsScript(rsyncCommand []string, streams int) string {
+	rsync := strings.Join(rsyncCommand[:len(rsyncCommand)-2], " ")
+	source, destination := rsyncCommand[len(rsyncCommand)-2], rsyncCommand[len(rsyncCommand)-1]
+	deletePass := ""
+	for _, opt := range rsyncCommand {
+		if opt == optDelete {
+			deletePass = fmt.Sprintf(`	if [[ " ${SUCCESS_EXIT_CODES} " =~ " ${rc} " ]]; then
+		%s --info=STATS0 --existing --ignore-existing %s %s
+		delete_rc=$?
+		if [[ ! " ${SUCCESS_EXIT_CODES} " =~ " ${delete_rc} " ]]; then
+			rc=$delete_rc
+		fi
+	fi
+`, rsync, source, destination)
+			break
+		}
+	}
+	return fmt.Sprintf(`%s() {
+	rm -rf %s && mkdir -p %s
+	i=0
+	while IFS= read -r -d '' entry
+	do
+		printf '%%s\0' "$entry" >> "%s/shard-$((i %% %d))"
+		i=$((i+1))
+	done < <(find %s -mindepth 1 -maxdepth 1 -printf '%%P\0')
+	pids=()
+	for shard in %s/shard-*
+	do
+		[ -e "$shard" ] || continue
+		%s "$@" --from0 --files-from="$shard" %s %s > "$shard.log" 2>&1 &
+		pids+=($!)
+	done
+	rc=0
+	for pid in "${pids[@]}"
+	do
+		wait $pid
+		shard_rc=$?
+		if [[ ! " ${SUCCESS_EXIT_CODES} " =~ " ${shard_rc} " ]]; then
+			if [[ " ${SUCCESS_EXIT_CODES} " =~ " ${rc} " ]]; then
+				rc=$shard_rc
+			fi
+		elif [[ " ${SUCCESS_EXIT_CODES} " =~ " ${rc} " && $shard_rc -gt $rc ]]; then
+			rc=$shard_rc
+		fi
+	done
+	cat %s/shard-*.log 2> /dev/null
+%s	return $rc
+}
+`,
+		shardsFunction,
+		shardsDir, shardsDir,
+		shardsDir, streams,
+		source,
+		shardsDir,
+		rsync, source, destination,
+		shardsDir,
+		deletePass)
+}
@@ -0,0 +1,112 @@
+package rsync
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_validateStreams(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		mode    Mode
+		wantErr error
+	}{
+		{
+			name: "single stream in ssh mode, must be valid",
+			mode: ModeSSH,
+		},
+		{
+			name:    "streams in daemon mode, must be valid",
+			options: Options{Streams: 4},
+			mode:    ModeDaemon,
+		},
+		{
+			name:    "negative streams, must return ErrStreamsInvalid",
+			options: Options{Streams: -1},
+			mode:    ModeDaemon,
+			wantErr: ErrStreamsInvalid,
+		},
+		{
+			name:    "streams in ssh mode, must return ErrStreamsInvalid",
+			options: Options{Streams: 4},
+			mode:    ModeSSH,
+			wantErr: ErrStreamsInvalid,
+		},
+		{
+			name:    "streams of a pulling client, must return ErrStreamsInvalid",
+			options: Options{Streams: 4, Pull: true},
+			mode:    ModeDaemon,
+			wantErr: ErrStreamsInvalid,
+		},
+		{
+			name:    "streams with the agent, must return ErrStreamsInvalid",
+			options: Options{Streams: 4, Agent: true},
+			mode:    ModeDaemon,
+			wantErr: ErrStreamsInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateStreams(tt.options, tt.mode)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("validateStreams() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_client_getCommand_streams(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tests := []struct {
+		name          string
+		options       []string
+		wantDeletion  bool
+		wantRsyncLine string
+	}{
+		{
+			name:          "streams, must run the shards in parallel",
+			options:       []string{"-a"},
+			wantRsyncLine: "/usr/bin/rsync -a \"$@\" --from0 --files-from=\"$shard\" /mnt/foo/data/ rsync://root@foo.bar.dev/data/",
+		},
+		{
+			name:          "streams deleting files, must delete the entries missing from the source",
+			options:       []string{"-a", "--delete"},
+			wantDeletion:  true,
+			wantRsyncLine: "/usr/bin/rsync -a --delete \"$@\" --from0 --files-from=\"$shard\" /mnt/foo/data/ rsync://root@foo.bar.dev/data/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &client{
+				username:        "root",
+				streams:         4,
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			}
+			script := tc.getCommand(tt.options, pvc)[2]
+			if !strings.HasPrefix(script, shardsFunction+"() {") {
+				t.Errorf("rsync script does not define %s:\n%s", shardsFunction, script)
+			}
+			if !strings.Contains(script, shardsFunction+" --stats 2>&1 | tee") {
+				t.Errorf("rsync script does not run the shards:\n%s", script)
+			}
+			if !strings.Contains(script, "shard-$((i % 4))") {
+				t.Errorf("rsync script does not partition the PVC in 4 shards:\n%s", script)
+			}
+			if !strings.Contains(script, tt.wantRsyncLine) {
+				t.Errorf("rsync script does not contain %q:\n%s", tt.wantRsyncLine, script)
+			}
+			if deletion := strings.Contains(script, "--existing --ignore-existing"); deletion != tt.wantDeletion {
+				t.Errorf("rsync script deletes the missing entries = %v, want %v", deletion, tt.wantDeletion)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package rsync
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const rsyncClientState = "rsync-client-state"
+
+// stateName returns the name of the configmap holding the state of the client
+func (tc *client) stateName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: tc.namespace,
+		Name:      fmt.Sprintf("%s-%s", rsyncClientState, tc.nameSuffix),
+	}
+}
+
+func (tc *client) updateState(ctx context.Context, c ctrlclient.Client, f func(data map[string]string)) error {
+	return updateState(ctx, c, tc.logger, tc.stateName(), tc.labels, tc.ownerRefs, tc.options, f)
+}
+
+// Pause records the paused state of the client and deletes the client pods, NewClient does not
+// recreate the pods until the client is resumed. Pausing a paused client keeps the time it was
+// first paused at.
+func (tc *client) Pause(ctx context.Context, c ctrlclient.Client) error {
+	err := tc.updateState(ctx, c, func(data map[string]string) {
+		setStateTime(data, pausedAtKey)
+	})
+	if err != nil {
+		tc.logger.Error(err, "unable to record paused state of rsync client")
+		return err
+	}
+	for _, podKey := range tc.podKeysByPVC() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podKey.Name,
+				Namespace: podKey.Namespace,
+			},
+		}
+		err = c.Delete(ctx, pod, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	tc.logger.Info("rsync client paused")
+	return nil
+}
+
+// Resume clears the paused state of the client and recreates the client pod. The previous pod
+// may still be terminating, in which case the pod is recreated by the next call to NewClient.
+func (tc *client) Resume(ctx context.Context, c ctrlclient.Client) error {
+	err := tc.updateState(ctx, c, func(data map[string]string) {
+		delete(data, pausedAtKey)
+	})
+	if err != nil {
+		tc.logger.Error(err, "unable to clear paused state of rsync client")
+		return err
+	}
+	tc.logger.Info("rsync client resumed")
+	return tc.reconcilePod(ctx, c, tc.namespace)
+}
@@ -0,0 +1,79 @@
+package rsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_client_pauseResume(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fakeClientWithObjects()
+	tc := &client{
+		logger:   testr.New(t),
+		username: "root",
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		nameSuffix:      "foo",
+		namespace:       "foo",
+		labels:          map[string]string{"test": "me"},
+		ownerRefs:       testOwnerReferences(),
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}
+	if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+
+	if err := tc.Pause(ctx, fakeClient); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	err := fakeClient.Get(ctx, podKey, &corev1.Pod{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("client pod not deleted on pause, error = %v", err)
+	}
+	status, err := tc.Status(ctx, fakeClient)
+	if err != nil || status.Paused == nil || status.Paused.PausedAt.IsZero() {
+		t.Fatalf("Status() = %v, %v, want paused", status, err)
+	}
+	pausedAt := status.Paused.PausedAt
+
+	// pausing again keeps the time the client was first paused at
+	if err := tc.Pause(ctx, fakeClient); err != nil {
+		t.Fatalf("Pause() error = %v", err)
+	}
+	status, err = tc.Status(ctx, fakeClient)
+	if err != nil || status.Paused == nil || !status.Paused.PausedAt.Equal(pausedAt) {
+		t.Errorf("Status() = %v, %v, want paused at %v", status, err, pausedAt)
+	}
+
+	// a paused client pod is not recreated
+	if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	err = fakeClient.Get(ctx, podKey, &corev1.Pod{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("client pod recreated while paused, error = %v", err)
+	}
+
+	if err := tc.Resume(ctx, fakeClient); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	err = fakeClient.Get(ctx, podKey, &corev1.Pod{})
+	if err != nil {
+		t.Errorf("client pod not recreated on resume, error = %v", err)
+	}
+	status, _ = tc.Status(ctx, fakeClient)
+	if status != nil && status.Paused != nil {
+		t.Errorf("Status() = %v, want not paused", status)
+	}
+
+}
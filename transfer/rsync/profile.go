@@ -0,0 +1,79 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+var (
+	// ErrCompressChoiceNotSupported is returned when the compression algorithm of the command
+	// options of a client is not supported by the image profile
+	ErrCompressChoiceNotSupported = errors.New("rsync compress choice not supported by the image")
+)
+
+// ImageProfile describes the rsync of the images of transfer pods, so that command options
+// requiring features the images lack are rejected when clients are created rather than
+// failing in their pods
+type ImageProfile struct {
+	// CompressChoices are the compression algorithms rsync was built with, rsync 3.2 or later.
+	// Images with an older rsync have none, they only compress with zlib without choosing it.
+	CompressChoices []CompressChoice
+}
+
+var (
+	// DefaultImageProfile is the profile of the default rsync image, its rsync predates 3.2
+	DefaultImageProfile = ImageProfile{}
+	// ZstdImageProfile is the profile of images with rsync 3.2 or later built with zstd and lz4
+	ZstdImageProfile = ImageProfile{
+		CompressChoices: []CompressChoice{CompressChoiceZstd, CompressChoiceLZ4, CompressChoiceZlib},
+	}
+)
+
+// Supports returns whether rsync of the image compresses with the algorithm, the default
+// algorithm is always supported
+func (p ImageProfile) Supports(choice CompressChoice) bool {
+	if choice == CompressChoiceDefault {
+		return true
+	}
+	for _, supported := range p.CompressChoices {
+		if supported == choice {
+			return true
+		}
+	}
+	return false
+}
+
+// NegotiateCompressChoice returns the first of the preferred algorithms supported by all the
+// profiles, e.g. the profiles of the images of the client and the server, and
+// CompressChoiceDefault when none is
+func NegotiateCompressChoice(profiles []ImageProfile, preferences ...CompressChoice) CompressChoice {
+	for _, choice := range preferences {
+		supported := true
+		for _, profile := range profiles {
+			supported = supported && profile.Supports(choice)
+		}
+		if supported {
+			return choice
+		}
+	}
+	return CompressChoiceDefault
+}
+
+// validateImageProfile returns an error when the command options of a client require
+// features missing from the image profile of the options
+func validateImageProfile(podOptions transfer.PodOptions, options Options) error {
+	commandOptions, ok := podOptions.CommandOptions.(*CommandOptions)
+	if !ok || !commandOptions.Compress {
+		return nil
+	}
+	profile := DefaultImageProfile
+	if options.ImageProfile != nil {
+		profile = *options.ImageProfile
+	}
+	if !profile.Supports(commandOptions.CompressChoice) {
+		return fmt.Errorf("%w: %s", ErrCompressChoiceNotSupported, commandOptions.CompressChoice)
+	}
+	return nil
+}
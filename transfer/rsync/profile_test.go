@@ -0,0 +1,76 @@
+package rsync
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+func TestNegotiateCompressChoice(t *testing.T) {
+	lz4Only := ImageProfile{CompressChoices: []CompressChoice{CompressChoiceLZ4}}
+	tests := []struct {
+		name        string
+		profiles    []ImageProfile
+		preferences []CompressChoice
+		want        CompressChoice
+	}{
+		{
+			name:        "zstd images, must pick the first preference",
+			profiles:    []ImageProfile{ZstdImageProfile, ZstdImageProfile},
+			preferences: []CompressChoice{CompressChoiceZstd, CompressChoiceLZ4},
+			want:        CompressChoiceZstd,
+		},
+		{
+			name:        "server without zstd, must pick the first preference of both",
+			profiles:    []ImageProfile{ZstdImageProfile, lz4Only},
+			preferences: []CompressChoice{CompressChoiceZstd, CompressChoiceLZ4},
+			want:        CompressChoiceLZ4,
+		},
+		{
+			name:        "default image, must fall back to the default algorithm",
+			profiles:    []ImageProfile{ZstdImageProfile, DefaultImageProfile},
+			preferences: []CompressChoice{CompressChoiceZstd, CompressChoiceLZ4},
+			want:        CompressChoiceDefault,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NegotiateCompressChoice(tt.profiles, tt.preferences...); got != tt.want {
+				t.Errorf("NegotiateCompressChoice() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateImageProfile(t *testing.T) {
+	tests := []struct {
+		name           string
+		commandOptions transfer.CommandOptions
+		options        Options
+		wantErr        error
+	}{
+		{
+			name:           "default compression with the default image, must be valid",
+			commandOptions: NewDefaultOptionsFrom(Compression(6)),
+		},
+		{
+			name:           "zstd with the default image, must return ErrCompressChoiceNotSupported",
+			commandOptions: NewDefaultOptionsFrom(CompressWith(CompressChoiceZstd)),
+			wantErr:        ErrCompressChoiceNotSupported,
+		},
+		{
+			name:           "zstd with a zstd image, must be valid",
+			commandOptions: NewDefaultOptionsFrom(CompressWith(CompressChoiceZstd)),
+			options:        Options{ImageProfile: &ZstdImageProfile},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateImageProfile(transfer.PodOptions{CommandOptions: tt.commandOptions}, tt.options)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("validateImageProfile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
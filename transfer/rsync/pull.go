@@ -0,0 +1,57 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+var (
+	// ErrPullInvalid is returned when the pull options of a server or a client cannot be used
+	ErrPullInvalid = errors.New("pull options invalid")
+)
+
+// validatePullServer returns an error when a server cannot serve its PVCs to a pulling client
+func validatePullServer(options Options, mode Mode) error {
+	if !options.Pull {
+		return nil
+	}
+	switch {
+	case mode != ModeDaemon:
+		return fmt.Errorf("%w: pull requires rsync mode %s", ErrPullInvalid, ModeDaemon)
+	case len(options.FanOutClients) > 0:
+		return fmt.Errorf("%w: fan-out clients already pull from the server", ErrPullInvalid)
+	}
+	return nil
+}
+
+// validatePullClient returns an error when a client cannot pull the PVCs of the server into its PVCs
+func validatePullClient(podOptions transfer.PodOptions, options Options, mode Mode) error {
+	if !options.Pull {
+		return nil
+	}
+	switch {
+	case mode != ModeDaemon:
+		return fmt.Errorf("%w: pull requires rsync mode %s", ErrPullInvalid, ModeDaemon)
+	case options.FanOutClient != "":
+		return fmt.Errorf("%w: fan-out clients already pull from the server", ErrPullInvalid)
+	case podOptions.ReadOnlySource:
+		return fmt.Errorf("%w: pulling clients write their PVCs", ErrPullInvalid)
+	case podOptions.PreScan:
+		return fmt.Errorf("%w: pulling clients do not support pre-scans", ErrPullInvalid)
+	}
+	return nil
+}
+
+// getPullCommand returns the rsync command of a client pulling pvc from its module on the server
+func (tc *client) getPullCommand(rsyncOptions []string, pvc transfer.PVC) []string {
+	connection := tc.Transport().ConnectionInfo()
+	rsyncCommand := []string{getRsyncBinary(tc.options)}
+	rsyncCommand = append(rsyncCommand, rsyncOptions...)
+	rsyncCommand = append(rsyncCommand, strings.Fields(getRsyncURL(tc.username, connection, pvc.LabelSafeName()))...)
+	rsyncCommand = append(rsyncCommand,
+		getLocalPath(tc.options, fmt.Sprintf("/mnt/%s/%s/", pvc.Claim().Namespace, pvc.LabelSafeName())))
+	return rsyncCommand
+}
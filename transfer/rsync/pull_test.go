@@ -0,0 +1,138 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_validatePull(t *testing.T) {
+	tests := []struct {
+		name          string
+		podOptions    transfer.PodOptions
+		options       Options
+		mode          Mode
+		wantServerErr error
+		wantClientErr error
+	}{
+		{
+			name: "push, must be valid",
+			mode: ModeSSH,
+		},
+		{
+			name:    "pull in daemon mode, must be valid",
+			options: Options{Pull: true},
+			mode:    ModeDaemon,
+		},
+		{
+			name:          "pull in ssh mode, must return ErrPullInvalid",
+			options:       Options{Pull: true},
+			mode:          ModeSSH,
+			wantServerErr: ErrPullInvalid,
+			wantClientErr: ErrPullInvalid,
+		},
+		{
+			name:          "pull with fan-out clients, must return ErrPullInvalid",
+			options:       Options{Pull: true, FanOutClients: []string{"cluster-a"}},
+			mode:          ModeDaemon,
+			wantServerErr: ErrPullInvalid,
+		},
+		{
+			name:          "pull into a read-only PVC, must return ErrPullInvalid",
+			podOptions:    transfer.PodOptions{ReadOnlySource: true},
+			options:       Options{Pull: true},
+			mode:          ModeDaemon,
+			wantClientErr: ErrPullInvalid,
+		},
+		{
+			name:          "pull with a pre-scan, must return ErrPullInvalid",
+			podOptions:    transfer.PodOptions{PreScan: true},
+			options:       Options{Pull: true},
+			mode:          ModeDaemon,
+			wantClientErr: ErrPullInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validatePullServer(tt.options, tt.mode)
+			if !errors.Is(err, tt.wantServerErr) || (err == nil) != (tt.wantServerErr == nil) {
+				t.Errorf("validatePullServer() error = %v, wantErr %v", err, tt.wantServerErr)
+			}
+			err = validatePullClient(tt.podOptions, tt.options, tt.mode)
+			if !errors.Is(err, tt.wantClientErr) || (err == nil) != (tt.wantClientErr == nil) {
+				t.Errorf("validatePullClient() error = %v, wantErr %v", err, tt.wantClientErr)
+			}
+		})
+	}
+}
+
+func Test_client_getCommand_pull(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tc := &client{
+		username:        "root",
+		pull:            true,
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	want := []string{"/usr/bin/rsync", "-a", "rsync://root@foo.bar.dev/data/", "--port", "8080", "/mnt/foo/data/"}
+	if got := tc.getPullCommand([]string{"-a"}, pvc); !reflect.DeepEqual(got, want) {
+		t.Errorf("getPullCommand() = %v, want %v", got, want)
+	}
+	script := tc.getCommand([]string{"-a"}, pvc)[2]
+	if !strings.Contains(script, strings.Join(want, " ")) {
+		t.Errorf("rsync script does not pull from the server module")
+	}
+	if !strings.Contains(script, "/usr/bin/rsync /mnt/termination/done-data rsync://root@foo.bar.dev/termination/") {
+		t.Errorf("rsync script does not notify the server of the completion")
+	}
+}
+
+func Test_server_pull(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fakeClientWithObjects()
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		nameSuffix:      "foo",
+		mode:            ModeDaemon,
+		pull:            true,
+	}
+	if err := s.reconcileConfigMap(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcileConfigMap() error = %v", err)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := fakeClient.Get(ctx, types.NamespacedName{Namespace: "foo", Name: rsyncConfig + "-foo"}, cm); err != nil {
+		t.Fatalf("configmap not created, error = %v", err)
+	}
+	module := "[data]\n    comment = archive for foo/test-pvc\n    path = /mnt/foo/data\n    read only = yes\n"
+	if !strings.Contains(cm.Data["rsyncd.conf"], module) {
+		t.Errorf("rsyncd.conf = %s, want read-only module %s", cm.Data["rsyncd.conf"], module)
+	}
+	if !strings.Contains(cm.Data["rsyncd.conf"], "[termination]") {
+		t.Error("rsyncd.conf has no termination module")
+	}
+
+	for _, volume := range s.getPVCVolumes("foo") {
+		if !volume.PersistentVolumeClaim.ReadOnly {
+			t.Errorf("volume %s is not read-only", volume.Name)
+		}
+	}
+	for _, volumeMount := range s.getPVCVolumeMounts("foo") {
+		if !volumeMount.ReadOnly {
+			t.Errorf("volume mount %s is not read-only", volumeMount.Name)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package rsync
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Retry deletes the client pods of a failed transfer, the pods are recreated by the next call
+// to NewClient and rsync transfers the files it did not transfer yet. The pre-scan of the
+// source is not repeated.
+func (tc *client) Retry(ctx context.Context, c ctrlclient.Client) error {
+	for _, podKey := range tc.podKeysByPVC() {
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      podKey.Name,
+				Namespace: podKey.Namespace,
+			},
+		}
+		err := c.Delete(ctx, pod, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground))
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+	tc.logger.Info("rsync client retried")
+	return nil
+}
@@ -0,0 +1,54 @@
+package rsync
+
+import (
+	"context"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_client_Retry(t *testing.T) {
+	ctx := context.Background()
+	fakeClient := fakeClientWithObjects()
+	tc := &client{
+		logger:   testr.New(t),
+		username: "root",
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		nameSuffix:      "foo",
+		namespace:       "foo",
+		labels:          map[string]string{"test": "me"},
+		ownerRefs:       testOwnerReferences(),
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	var _ transfer.RetriableClient = tc
+	podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}
+	if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+
+	if err := tc.Retry(ctx, fakeClient); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, &corev1.Pod{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("client pod not deleted on retry, error = %v", err)
+	}
+	// retrying a client without a pod is a no-op
+	if err := tc.Retry(ctx, fakeClient); err != nil {
+		t.Fatalf("Retry() error = %v", err)
+	}
+
+	if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, &corev1.Pod{}); err != nil {
+		t.Errorf("client pod not recreated after retry, error = %v", err)
+	}
+}
@@ -1,8 +1,19 @@
 package rsync
 
 import (
+	"context"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/rbac"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/exitcode"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/null"
+	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const (
@@ -20,27 +31,387 @@ const (
 	rsyncdLogDirPath            = "/var/log/rsyncd/"
 )
 
+// reservedMountPaths are used by the rsync containers, extra volume mounts of the pod options
+// cannot be mounted under them
+var reservedMountPaths = []string{
+	"/mnt",
+	"/etc/rsyncd.conf",
+	"/etc/ssh/sshd_config",
+	rsyncCommunicationMountPath,
+	rsyncdLogDirPath,
+	sshKeysMountPath,
+	sshDataMountPath,
+	fanOutSecretsMountPath,
+	fanOutPasswordMountPath,
+	transport.CompletionMountPath,
+}
+
+// getNamespace returns the namespace of the PVCs in the given list. rsync transfers
+// only support PVC lists in a single namespace.
+func getNamespace(pvcList transfer.PVCList) (string, error) {
+	var namespace string
+	namespaces := pvcList.Namespaces()
+	if len(namespaces) > 0 {
+		namespace = namespaces[0]
+	}
+
+	for _, ns := range namespaces {
+		if ns != namespace {
+			return "", transfer.ErrPVCsMultipleNamespaces
+		}
+	}
+
+	if namespace == "" {
+		return "", transfer.ErrPVCListEmpty
+	}
+	return namespace, nil
+}
+
+// reconcileOptions returns the options used to write the rsync resources to the cluster
+func reconcileOptions(options transfer.PodOptions) reconcile.Options {
+	return reconcile.Options{
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	}
+}
+
 // applyPodOptions take a PodSpec and PodOptions, applies
 // each option to the given podSpec
 // Following fields will be mutated:
-// - spec.NodeSelector
+// - spec.NodeSelector, the operating system label is added for pod options with an OS
 // - spec.SecurityContext
 // - spec.NodeName
-// - spec.Containers[*].SecurityContext
-// - spec.Containers[*].Resources
+// - spec.Tolerations
+// - spec.Affinity
+// - spec.TopologySpreadConstraints
+// - spec.PriorityClassName
 func applyPodOptions(podSpec *corev1.PodSpec, options transfer.PodOptions) {
-	podSpec.NodeSelector = options.NodeSelector
+	podSpec.NodeSelector = getNodeSelector(options)
 	podSpec.NodeName = options.NodeName
 	podSpec.SecurityContext = &options.PodSecurityContext
-	for i := range podSpec.Containers {
-		c := &podSpec.Containers[i]
-		if options.Image != "" {
-			c.Image = options.Image
-		} else {
-			c.Image = rsyncImage
-		}
+	podSpec.Tolerations = options.Tolerations
+	podSpec.Affinity = options.Affinity
+	podSpec.TopologySpreadConstraints = options.TopologySpreadConstraints
+	podSpec.PriorityClassName = options.PriorityClassName
+	podSpec.HostNetwork = options.HostNetwork
+	podSpec.DNSPolicy = options.DNSPolicy
+	if options.HostNetwork && options.DNSPolicy == "" {
+		podSpec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+	podSpec.DNSConfig = options.DNSConfig.DeepCopy()
+	podSpec.Volumes = append(append([]corev1.Volume{}, podSpec.Volumes...), options.ExtraVolumes...)
+}
+
+// applyContainerOptions take the rsync containers and PodOptions, applies
+// each option to the given containers. Transport containers are configured
+// through the transport options and must not be passed.
+// Following fields will be mutated:
+// - containers[*].Image
+// - containers[*].ImagePullPolicy
+// - containers[*].SecurityContext
+// - containers[*].Resources
+// - containers[*].VolumeMounts
+// - containers[*].Env
+// - containers[*].EnvFrom
+func applyContainerOptions(containers []corev1.Container, options transfer.PodOptions) {
+	for i := range containers {
+		c := &containers[i]
+		c.Image = getRsyncImage(options)
+		c.ImagePullPolicy = options.ImagePullPolicy
 		c.SecurityContext = &options.ContainerSecurityContext
 		c.Resources = options.Resources
+		c.VolumeMounts = append(append([]corev1.VolumeMount{}, c.VolumeMounts...), options.ExtraVolumeMounts...)
+		// the environment of the container comes last, it takes precedence
+		c.Env = append(append([]corev1.EnvVar{}, options.Env...), c.Env...)
+		c.EnvFrom = append(append([]corev1.EnvFromSource{}, options.EnvFrom...), c.EnvFrom...)
+	}
+}
+
+// getRsyncImage returns the image of the rsync containers
+func getRsyncImage(options transfer.PodOptions) string {
+	switch {
+	case options.ArchitectureImages[options.Architecture] != "":
+		return options.ArchitectureImages[options.Architecture]
+	case options.RsyncImage != "":
+		return options.RsyncImage
+	case options.Image != "":
+		return options.Image
+	default:
+		return rsyncImage
+	}
+}
+
+// getLabels returns the PodLabels of the transfer pod options merged with labels, labels
+// take precedence as they are used to select and clean up the transfer resources
+func getLabels(labels map[string]string, options transfer.PodOptions) map[string]string {
+	if len(options.PodLabels) == 0 {
+		return labels
+	}
+	merged := map[string]string{}
+	for key, value := range options.PodLabels {
+		merged[key] = value
+	}
+	for key, value := range labels {
+		merged[key] = value
+	}
+	return merged
+}
+
+// getAnnotations returns annotations with the PodAnnotations of the transfer pod options added,
+// other annotations are kept so that annotations set by other controllers are left untouched
+func getAnnotations(annotations map[string]string, options transfer.PodOptions) map[string]string {
+	if len(options.PodAnnotations) == 0 {
+		return annotations
+	}
+	merged := map[string]string{}
+	for key, value := range annotations {
+		merged[key] = value
+	}
+	for key, value := range options.PodAnnotations {
+		merged[key] = value
+	}
+	return merged
+}
+
+// rbacNames returns the names of the service account, role and role binding of a transfer pod
+func rbacNames(nameSuffix string) rbac.Names {
+	return rbac.Names{
+		ServiceAccount: fmt.Sprintf("%s-%s", rsyncServiceAccount, nameSuffix),
+		Role:           fmt.Sprintf("%s-%s", rsyncRole, nameSuffix),
+		RoleBinding:    fmt.Sprintf("%s-%s", rsyncRoleBinding, nameSuffix),
+	}
+}
+
+// reconcileServiceAccount creates the service account of a transfer pod and sets it in the pod
+// options, it is skipped when callers provide their own service account unless the pod options
+// require an SCC, the provided service account is then granted its use
+func reconcileServiceAccount(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	namespace, nameSuffix string,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	options *transfer.PodOptions) error {
+	if options.ServiceAccountName != "" && options.SCCName == "" {
+		return nil
+	}
+	if options.SCCName != "" {
+		err := rbac.ValidateSCC(ctx, c, options.SCCName)
+		if err != nil {
+			return err
+		}
+	}
+	names := rbacNames(nameSuffix)
+	err := rbac.Reconcile(ctx, c, logger, namespace, names, rbac.Options{
+		Labels:             labels,
+		OwnerReferences:    ownerRefs,
+		SCCName:            options.SCCName,
+		ServiceAccountName: options.ServiceAccountName,
+		Reconcile:          reconcileOptions(*options),
+	})
+	if err != nil {
+		return err
+	}
+	if options.ServiceAccountName == "" {
+		options.ServiceAccountName = names.ServiceAccount
+	}
+	return nil
+}
+
+// requiredSCCAnnotation makes OpenShift admit pods with the given SCC only
+const requiredSCCAnnotation = "openshift.io/required-scc"
+
+// applySCC requires the SCC of the pod options for the transfer pod
+func applySCC(pod *metav1.ObjectMeta, options transfer.PodOptions) {
+	if options.SCCName == "" {
+		return
+	}
+	pod.Annotations = getAnnotations(pod.Annotations, transfer.PodOptions{
+		PodAnnotations: map[string]string{requiredSCCAnnotation: options.SCCName},
+	})
+}
+
+// blockIOClassAnnotation is the annotation of the block IO class of the rsync container, see
+// transfer.IOThrottle
+const blockIOClassAnnotation = "blockio.resources.beta.kubernetes.io/container." + RsyncContainer
+
+// applyIOThrottle sets the block IO class of the rsync container of the pod when the pod
+// options throttle its IO
+func applyIOThrottle(pod *metav1.ObjectMeta, options transfer.PodOptions) {
+	if options.IOThrottle == nil || options.IOThrottle.BlockIOClass == "" {
+		return
+	}
+	pod.Annotations = getAnnotations(pod.Annotations, transfer.PodOptions{
+		PodAnnotations: map[string]string{blockIOClassAnnotation: options.IOThrottle.BlockIOClass},
+	})
+}
+
+// withIOPriority returns the command of the rsync container run with the idle IO priority
+// when the pod options require it, the processes started by the command inherit it
+func withIOPriority(command []string, options transfer.PodOptions) []string {
+	if options.IOThrottle == nil || !options.IOThrottle.IdlePriority {
+		return command
+	}
+	return append([]string{"ionice", "-c", "3"}, command...)
+}
+
+// applyServiceMeshMode opts the transfer pod out of sidecar injection when required by the
+// service mesh mode of the pod options
+func applyServiceMeshMode(pod *metav1.ObjectMeta, options transfer.PodOptions) {
+	if options.ServiceMesh != transfer.ServiceMeshModeDisableInjection {
+		return
+	}
+	labels := map[string]string{}
+	for key, value := range pod.Labels {
+		labels[key] = value
+	}
+	for key, value := range transfer.ServiceMeshInjectionLabels {
+		labels[key] = value
+	}
+	pod.Labels = labels
+	pod.Annotations = getAnnotations(pod.Annotations, transfer.PodOptions{PodAnnotations: transfer.ServiceMeshInjectionAnnotations})
+}
+
+// validateServiceMeshMode returns an error wrapping ErrServiceMeshModeNotSupported when the
+// service mesh mode of the pod options cannot be used with the transport
+func validateServiceMeshMode(options transfer.PodOptions, t transport.Transport) error {
+	switch options.ServiceMesh {
+	case transfer.ServiceMeshModeNone, transfer.ServiceMeshModeDisableInjection:
+		return nil
+	case transfer.ServiceMeshModeNative:
+		if t.Type() != null.TransportTypeNull {
+			return fmt.Errorf("%w: mode %s requires the %s transport, got %s",
+				transfer.ErrServiceMeshModeNotSupported, options.ServiceMesh, null.TransportTypeNull, t.Type())
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", transfer.ErrServiceMeshModeNotSupported, options.ServiceMesh)
+	}
+}
+
+// getRetryPolicy returns the retry policy of the pod options. The default policy of rsync
+// transfers only retries the exit codes for which exitcode.ExitCode.Retryable is true.
+func getRetryPolicy(options transfer.PodOptions) transfer.RetryPolicy {
+	policy := options.GetRetryPolicy()
+	if options.RetryPolicy == nil {
+		policy.RetryOnExitCodes = exitcode.RetryableCodes()
+	}
+	return policy
+}
+
+// applySELinuxOptions sets the SELinux options of the transfer pod options on all the containers
+// of the transfer pod, transport containers included. Security contexts are copied as they may
+// be shared with the transport.
+func applySELinuxOptions(containers []corev1.Container, options transfer.PodOptions) {
+	if options.SELinuxOptions == nil {
+		return
+	}
+	for i := range containers {
+		c := &containers[i]
+		securityContext := &corev1.SecurityContext{}
+		if c.SecurityContext != nil {
+			securityContext = c.SecurityContext.DeepCopy()
+		}
+		securityContext.SELinuxOptions = options.SELinuxOptions.DeepCopy()
+		c.SecurityContext = securityContext
+	}
+}
+
+// fakeSuperEnabled returns whether the rsync command options of the pod options store the
+// attributes of files in extended attributes, see CommandOptions.FakeSuper
+func fakeSuperEnabled(options transfer.PodOptions) bool {
+	commandOptions, ok := options.CommandOptions.(*CommandOptions)
+	return ok && commandOptions.FakeSuper
+}
+
+// applyFakeSuper drops the privileges of the rsync containers when fake super is enabled,
+// ownership is then written to extended attributes which only requires write access to files.
+// Capabilities and privilege escalation explicitly set in the security context are kept.
+// Security contexts are copied as they may be shared with other containers.
+func applyFakeSuper(containers []corev1.Container, options transfer.PodOptions) {
+	if !fakeSuperEnabled(options) {
+		return
+	}
+	for i := range containers {
+		c := &containers[i]
+		securityContext := &corev1.SecurityContext{}
+		if c.SecurityContext != nil {
+			securityContext = c.SecurityContext.DeepCopy()
+		}
+		if securityContext.AllowPrivilegeEscalation == nil {
+			allowPrivilegeEscalation := false
+			securityContext.AllowPrivilegeEscalation = &allowPrivilegeEscalation
+		}
+		if securityContext.Capabilities == nil {
+			securityContext.Capabilities = &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}
+		}
+		c.SecurityContext = securityContext
+	}
+}
+
+// getImagePullSecrets returns the image pull secrets of the transfer pod options
+// merged with the ones required by the transport, without duplicates
+func getImagePullSecrets(options transfer.PodOptions, t transport.Transport) []corev1.LocalObjectReference {
+	pullSecrets := []corev1.LocalObjectReference{}
+	seen := map[string]bool{}
+	add := func(secrets []corev1.LocalObjectReference) {
+		for _, secret := range secrets {
+			if !seen[secret.Name] {
+				seen[secret.Name] = true
+				pullSecrets = append(pullSecrets, secret)
+			}
+		}
+	}
+	add(options.ImagePullSecrets)
+	if provider, ok := t.(transport.ImagePullSecretsProvider); ok {
+		add(provider.ImagePullSecrets())
+	}
+	if len(pullSecrets) == 0 {
+		return nil
+	}
+	return pullSecrets
+}
+
+// terminatesOnCompletion returns whether the transport stops on its own once the
+// transfer creates transport.CompletionFile
+func terminatesOnCompletion(t transport.Transport) bool {
+	completionAware, ok := t.(transport.CompletionAware)
+	return ok && completionAware.TerminatesOnCompletion()
+}
+
+// runsAsNativeSidecar returns whether the containers of the transport are init containers
+// which Kubernetes stops once the rsync container exited
+func runsAsNativeSidecar(t transport.Transport) bool {
+	nativeSidecarAware, ok := t.(transport.NativeSidecarAware)
+	return ok && nativeSidecarAware.RunsAsNativeSidecar()
+}
+
+// getTransportContainers returns the containers and the init containers of an rsync pod
+// running the given rsync containers next to copies of the containers of the transport,
+// which are shared by all the pods of the transport
+func getTransportContainers(t transport.Transport, containers []corev1.Container) ([]corev1.Container, []corev1.Container) {
+	if runsAsNativeSidecar(t) {
+		return containers, transport.NativeSidecars(t.Containers())
+	}
+	for _, container := range t.Containers() {
+		containers = append(containers, *container.DeepCopy())
+	}
+	return containers, nil
+}
+
+// getContainerNames returns the names of the rsync container and of the containers of the
+// transport of an rsync pod
+func getContainerNames(t transport.Transport) []string {
+	names := []string{RsyncContainer}
+	for _, container := range t.Containers() {
+		names = append(names, container.Name)
+	}
+	return names
+}
+
+func getCompletionVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      transport.CompletionVolumeName,
+		MountPath: transport.CompletionMountPath,
 	}
 }
 
@@ -0,0 +1,510 @@
+package rsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/null"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func testPVC(namespace, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+}
+
+func Test_getNamespace(t *testing.T) {
+	tests := []struct {
+		name          string
+		pvcs          []*corev1.PersistentVolumeClaim
+		wantNamespace string
+		wantErr       error
+	}{
+		{
+			name:          "all pvcs in one namespace, must return the namespace",
+			pvcs:          []*corev1.PersistentVolumeClaim{testPVC("foo", "pvc-1"), testPVC("foo", "pvc-2")},
+			wantNamespace: "foo",
+			wantErr:       nil,
+		},
+		{
+			name:          "pvcs in different namespaces, must return ErrPVCsMultipleNamespaces",
+			pvcs:          []*corev1.PersistentVolumeClaim{testPVC("foo", "pvc-1"), testPVC("bar", "pvc-2")},
+			wantNamespace: "",
+			wantErr:       transfer.ErrPVCsMultipleNamespaces,
+		},
+		{
+			name:          "empty pvc list, must return ErrPVCListEmpty",
+			pvcs:          []*corev1.PersistentVolumeClaim{},
+			wantNamespace: "",
+			wantErr:       transfer.ErrPVCListEmpty,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvcList, err := transfer.NewPVCList(tt.pvcs...)
+			if err != nil {
+				t.Fatalf("unable to create pvc list %v", err)
+			}
+			got, err := getNamespace(pvcList)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("getNamespace() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantNamespace {
+				t.Errorf("getNamespace() = %v, want %v", got, tt.wantNamespace)
+			}
+		})
+	}
+}
+
+func Test_getImagePullSecrets(t *testing.T) {
+	tests := []struct {
+		name      string
+		options   transfer.PodOptions
+		transport transport.Transport
+		want      []corev1.LocalObjectReference
+	}{
+		{
+			name:      "no pull secrets, must return nil",
+			transport: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+			want:      nil,
+		},
+		{
+			name:      "transport not providing pull secrets, must return the pod options pull secrets",
+			options:   transfer.PodOptions{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "rsync-pull"}}},
+			transport: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+			want:      []corev1.LocalObjectReference{{Name: "rsync-pull"}},
+		},
+		{
+			name:    "pod options and transport pull secrets, must merge without duplicates",
+			options: transfer.PodOptions{ImagePullSecrets: []corev1.LocalObjectReference{{Name: "rsync-pull"}, {Name: "shared"}}},
+			transport: &fakeTransportServer{
+				transportType: stunnel.TransportTypeStunnel,
+				pullSecrets:   []corev1.LocalObjectReference{{Name: "shared"}, {Name: "stunnel-pull"}},
+			},
+			want: []corev1.LocalObjectReference{{Name: "rsync-pull"}, {Name: "shared"}, {Name: "stunnel-pull"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getImagePullSecrets(tt.options, tt.transport); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getImagePullSecrets() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_applySELinuxOptions(t *testing.T) {
+	seLinuxOptions := &corev1.SELinuxOptions{Level: "s0:c26,c5"}
+	shared := &corev1.SecurityContext{RunAsUser: new(int64)}
+	containers := []corev1.Container{
+		{Name: "rsync"},
+		{Name: "stunnel", SecurityContext: shared},
+	}
+	applySELinuxOptions(containers, transfer.PodOptions{SELinuxOptions: seLinuxOptions})
+	for _, c := range containers {
+		if c.SecurityContext == nil || !reflect.DeepEqual(c.SecurityContext.SELinuxOptions, seLinuxOptions) {
+			t.Errorf("container %s SELinux options = %v, want %v", c.Name, c.SecurityContext, seLinuxOptions)
+		}
+	}
+	if containers[1].SecurityContext.RunAsUser == nil {
+		t.Error("applySELinuxOptions() dropped the existing security context")
+	}
+	if shared.SELinuxOptions != nil {
+		t.Error("applySELinuxOptions() mutated a shared security context")
+	}
+}
+
+func Test_applyFakeSuper(t *testing.T) {
+	shared := &corev1.SecurityContext{RunAsUser: new(int64)}
+	containers := []corev1.Container{
+		{Name: "rsync", SecurityContext: shared},
+		{Name: "scan", SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{Add: []corev1.Capability{"DAC_OVERRIDE"}}}},
+	}
+	applyFakeSuper(containers, transfer.PodOptions{CommandOptions: &CommandOptions{FakeSuper: true}})
+	for _, c := range containers {
+		if c.SecurityContext.AllowPrivilegeEscalation == nil || *c.SecurityContext.AllowPrivilegeEscalation {
+			t.Errorf("container %s allows privilege escalation", c.Name)
+		}
+	}
+	if !reflect.DeepEqual(containers[0].SecurityContext.Capabilities, &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}}) {
+		t.Errorf("container rsync capabilities = %v, want all dropped", containers[0].SecurityContext.Capabilities)
+	}
+	if !reflect.DeepEqual(containers[1].SecurityContext.Capabilities.Add, []corev1.Capability{"DAC_OVERRIDE"}) {
+		t.Errorf("applyFakeSuper() replaced the capabilities of the security context")
+	}
+	if shared.AllowPrivilegeEscalation != nil || shared.Capabilities != nil {
+		t.Error("applyFakeSuper() mutated a shared security context")
+	}
+
+	containers = []corev1.Container{{Name: "rsync"}}
+	applyFakeSuper(containers, transfer.PodOptions{CommandOptions: &CommandOptions{}})
+	if containers[0].SecurityContext != nil {
+		t.Errorf("applyFakeSuper() without fake super set security context %v", containers[0].SecurityContext)
+	}
+}
+
+func Test_applyContainerOptions_env(t *testing.T) {
+	options := transfer.PodOptions{
+		Env: []corev1.EnvVar{
+			{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"},
+			{Name: agent.ConfigEnv, Value: "user"},
+		},
+		EnvFrom: []corev1.EnvFromSource{{
+			SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "rsync-env"}},
+		}},
+	}
+	generated := []corev1.EnvVar{{Name: agent.ConfigEnv, Value: "generated"}}
+	containers := []corev1.Container{{Name: RsyncContainer, Env: generated}}
+	applyContainerOptions(containers, options)
+	want := append(append([]corev1.EnvVar{}, options.Env...), generated...)
+	if !reflect.DeepEqual(containers[0].Env, want) {
+		t.Errorf("applyContainerOptions() env = %v, want %v", containers[0].Env, want)
+	}
+	if !reflect.DeepEqual(containers[0].EnvFrom, options.EnvFrom) {
+		t.Errorf("applyContainerOptions() env from = %v, want %v", containers[0].EnvFrom, options.EnvFrom)
+	}
+}
+
+func Test_applyPodOptions(t *testing.T) {
+	options := transfer.PodOptions{
+		NodeSelector: map[string]string{"node-role": "migration"},
+		Tolerations: []corev1.Toleration{{
+			Key:      "dedicated",
+			Operator: corev1.TolerationOpEqual,
+			Value:    "migration",
+			Effect:   corev1.TaintEffectNoSchedule,
+		}},
+		Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+				NodeSelectorTerms: []corev1.NodeSelectorTerm{{MatchExpressions: []corev1.NodeSelectorRequirement{{
+					Key:      "topology.kubernetes.io/zone",
+					Operator: corev1.NodeSelectorOpIn,
+					Values:   []string{"us-east-1a"},
+				}}}},
+			},
+		}},
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{{
+			MaxSkew:           1,
+			TopologyKey:       "kubernetes.io/hostname",
+			WhenUnsatisfiable: corev1.ScheduleAnyway,
+		}},
+		PriorityClassName: "migration",
+	}
+	podSpec := &corev1.PodSpec{}
+	applyPodOptions(podSpec, options)
+	if !reflect.DeepEqual(podSpec.Tolerations, options.Tolerations) {
+		t.Errorf("applyPodOptions() tolerations = %v, want %v", podSpec.Tolerations, options.Tolerations)
+	}
+	if !reflect.DeepEqual(podSpec.Affinity, options.Affinity) {
+		t.Errorf("applyPodOptions() affinity = %v, want %v", podSpec.Affinity, options.Affinity)
+	}
+	if !reflect.DeepEqual(podSpec.TopologySpreadConstraints, options.TopologySpreadConstraints) {
+		t.Errorf("applyPodOptions() topology spread constraints = %v, want %v",
+			podSpec.TopologySpreadConstraints, options.TopologySpreadConstraints)
+	}
+	if podSpec.PriorityClassName != options.PriorityClassName {
+		t.Errorf("applyPodOptions() priority class = %s, want %s", podSpec.PriorityClassName, options.PriorityClassName)
+	}
+}
+
+func Test_applyPodOptions_network(t *testing.T) {
+	dnsConfig := &corev1.PodDNSConfig{Nameservers: []string{"10.1.0.10"}, Searches: []string{"svc.other.cluster"}}
+	tests := []struct {
+		name          string
+		options       transfer.PodOptions
+		wantDNSPolicy corev1.DNSPolicy
+	}{
+		{
+			name: "pod network, must keep the default DNS policy",
+		},
+		{
+			name:          "host network, must resolve cluster services",
+			options:       transfer.PodOptions{HostNetwork: true},
+			wantDNSPolicy: corev1.DNSClusterFirstWithHostNet,
+		},
+		{
+			name:          "host network with a DNS policy and config, must apply them",
+			options:       transfer.PodOptions{HostNetwork: true, DNSPolicy: corev1.DNSNone, DNSConfig: dnsConfig},
+			wantDNSPolicy: corev1.DNSNone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			podSpec := &corev1.PodSpec{}
+			applyPodOptions(podSpec, tt.options)
+			if podSpec.HostNetwork != tt.options.HostNetwork || podSpec.DNSPolicy != tt.wantDNSPolicy {
+				t.Errorf("applyPodOptions() host network = %v, DNS policy = %s, want %v, %s",
+					podSpec.HostNetwork, podSpec.DNSPolicy, tt.options.HostNetwork, tt.wantDNSPolicy)
+			}
+			if !reflect.DeepEqual(podSpec.DNSConfig, tt.options.DNSConfig) {
+				t.Errorf("applyPodOptions() DNS config = %v, want %v", podSpec.DNSConfig, tt.options.DNSConfig)
+			}
+		})
+	}
+}
+
+func Test_getLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		options transfer.PodOptions
+		want    map[string]string
+	}{
+		{
+			name:   "no pod labels, must return labels",
+			labels: map[string]string{"app": "transfer"},
+			want:   map[string]string{"app": "transfer"},
+		},
+		{
+			name:    "pod labels, must not override selector labels",
+			labels:  map[string]string{"app": "transfer"},
+			options: transfer.PodOptions{PodLabels: map[string]string{"app": "other", "team": "storage"}},
+			want:    map[string]string{"app": "transfer", "team": "storage"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getLabels(tt.labels, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getAnnotations(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		options     transfer.PodOptions
+		want        map[string]string
+	}{
+		{
+			name: "no annotations, must return nil",
+		},
+		{
+			name:        "pod annotations, must keep existing annotations",
+			annotations: map[string]string{"set-by": "webhook"},
+			options:     transfer.PodOptions{PodAnnotations: map[string]string{"sidecar.istio.io/inject": "false"}},
+			want:        map[string]string{"set-by": "webhook", "sidecar.istio.io/inject": "false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getAnnotations(tt.annotations, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getAnnotations() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateServiceMeshMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      transfer.ServiceMeshMode
+		transport transport.Transport
+		wantErr   error
+	}{
+		{
+			name:      "disable injection with stunnel, must be valid",
+			mode:      transfer.ServiceMeshModeDisableInjection,
+			transport: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		},
+		{
+			name:      "native with null transport, must be valid",
+			mode:      transfer.ServiceMeshModeNative,
+			transport: &fakeTransportServer{transportType: null.TransportTypeNull},
+		},
+		{
+			name:      "native with stunnel, must return ErrServiceMeshModeNotSupported",
+			mode:      transfer.ServiceMeshModeNative,
+			transport: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+			wantErr:   transfer.ErrServiceMeshModeNotSupported,
+		},
+		{
+			name:      "unknown mode, must return ErrServiceMeshModeNotSupported",
+			mode:      "Ambient",
+			transport: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+			wantErr:   transfer.ErrServiceMeshModeNotSupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateServiceMeshMode(transfer.PodOptions{ServiceMesh: tt.mode}, tt.transport)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("validateServiceMeshMode() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_applyServiceMeshMode(t *testing.T) {
+	pod := &metav1.ObjectMeta{
+		Labels:      map[string]string{"app": "transfer"},
+		Annotations: map[string]string{"pvc": "data"},
+	}
+	applyServiceMeshMode(pod, transfer.PodOptions{ServiceMesh: transfer.ServiceMeshModeDisableInjection})
+	if pod.Labels["app"] != "transfer" || pod.Labels["sidecar.istio.io/inject"] != "false" {
+		t.Errorf("applyServiceMeshMode() labels = %v, want injection disabled", pod.Labels)
+	}
+	if pod.Annotations["pvc"] != "data" || pod.Annotations["linkerd.io/inject"] != "disabled" {
+		t.Errorf("applyServiceMeshMode() annotations = %v, want injection disabled", pod.Annotations)
+	}
+}
+
+func Test_reconcileServiceAccount(t *testing.T) {
+	tests := []struct {
+		name               string
+		serviceAccountName string
+		sccName            string
+		want               string
+		wantErr            error
+	}{
+		{
+			name: "no service account, must create one and use it",
+			want: "rsync-sa-foo",
+		},
+		{
+			name:               "service account provided, must be kept",
+			serviceAccountName: "custom",
+			want:               "custom",
+		},
+		{
+			name:    "SCC not found, must return ErrSCCNotUsable",
+			sccName: "missing",
+			wantErr: transfer.ErrSCCNotUsable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fakeClientWithObjects()
+			options := &transfer.PodOptions{ServiceAccountName: tt.serviceAccountName, SCCName: tt.sccName}
+			err := reconcileServiceAccount(context.Background(), c, testr.New(t), "ns", "foo", nil, nil, options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("reconcileServiceAccount() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if options.ServiceAccountName != tt.want {
+				t.Errorf("service account = %s, want %s", options.ServiceAccountName, tt.want)
+			}
+			err = c.Get(context.Background(), types.NamespacedName{Namespace: "ns", Name: "rsync-sa-foo"}, &corev1.ServiceAccount{})
+			if created := err == nil; created != (tt.serviceAccountName == "") {
+				t.Errorf("service account created = %v, error = %v", created, err)
+			}
+		})
+	}
+}
+
+func Test_applySCC(t *testing.T) {
+	pod := &metav1.ObjectMeta{Annotations: map[string]string{"pvc": "data"}}
+	applySCC(pod, transfer.PodOptions{SCCName: "rsync-scc"})
+	if pod.Annotations[requiredSCCAnnotation] != "rsync-scc" || pod.Annotations["pvc"] != "data" {
+		t.Errorf("applySCC() annotations = %v, want the required SCC", pod.Annotations)
+	}
+}
+
+func Test_applyIOThrottle(t *testing.T) {
+	pod := &metav1.ObjectMeta{Annotations: map[string]string{"pvc": "data"}}
+	applyIOThrottle(pod, transfer.PodOptions{IOThrottle: &transfer.IOThrottle{BlockIOClass: "throttled"}})
+	if pod.Annotations[blockIOClassAnnotation] != "throttled" || pod.Annotations["pvc"] != "data" {
+		t.Errorf("applyIOThrottle() annotations = %v, want the block IO class", pod.Annotations)
+	}
+	pod = &metav1.ObjectMeta{}
+	applyIOThrottle(pod, transfer.PodOptions{IOThrottle: &transfer.IOThrottle{IdlePriority: true}})
+	if len(pod.Annotations) != 0 {
+		t.Errorf("applyIOThrottle() annotations = %v, want none without a block IO class", pod.Annotations)
+	}
+}
+
+func Test_withIOPriority(t *testing.T) {
+	command := []string{"/bin/bash", "-c", "rsync"}
+	tests := []struct {
+		name    string
+		options transfer.PodOptions
+		want    []string
+	}{
+		{
+			name: "no IO throttle, must return the command",
+			want: command,
+		},
+		{
+			name:    "block IO class only, must return the command",
+			options: transfer.PodOptions{IOThrottle: &transfer.IOThrottle{BlockIOClass: "throttled"}},
+			want:    command,
+		},
+		{
+			name:    "idle priority, must run the command with ionice",
+			options: transfer.PodOptions{IOThrottle: &transfer.IOThrottle{IdlePriority: true}},
+			want:    []string{"ionice", "-c", "3", "/bin/bash", "-c", "rsync"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := withIOPriority(command, tt.options); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("withIOPriority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getRsyncImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		options transfer.PodOptions
+		want    string
+	}{
+		{
+			name: "no image, must return the default image",
+			want: rsyncImage,
+		},
+		{
+			name:    "image set, must return it",
+			options: transfer.PodOptions{Image: "registry.local/rsync:v1"},
+			want:    "registry.local/rsync:v1",
+		},
+		{
+			name:    "rsync image set, must take precedence over image",
+			options: transfer.PodOptions{Image: "registry.local/rsync:v1", RsyncImage: "registry.local/rsync:v2"},
+			want:    "registry.local/rsync:v2",
+		},
+		{
+			name: "image for the architecture set, must take precedence over rsync image",
+			options: transfer.PodOptions{
+				RsyncImage:         "registry.local/rsync:v2",
+				Architecture:       "arm64",
+				ArchitectureImages: map[string]string{"arm64": "registry.local/rsync:v2-arm64"},
+			},
+			want: "registry.local/rsync:v2-arm64",
+		},
+		{
+			name: "no image for the architecture, must return rsync image",
+			options: transfer.PodOptions{
+				RsyncImage:         "registry.local/rsync:v2",
+				Architecture:       "amd64",
+				ArchitectureImages: map[string]string{"arm64": "registry.local/rsync:v2-arm64"},
+			},
+			want: "registry.local/rsync:v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getRsyncImage(tt.options); got != tt.want {
+				t.Errorf("getRsyncImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package rsync
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transfer"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ScanContainer is the name of the container of the pre-scan pod
+	ScanContainer = "scan"
+	// totalFilesKey and totalBytesKey hold the result of the pre-scan in the state of the client
+	totalFilesKey = "totalFiles"
+	totalBytesKey = "totalBytes"
+	// scanMountPath is where the source PVCs are mounted in the pre-scan pod
+	scanMountPath = "/mnt"
+)
+
+// scanScript writes the number of regular files and their apparent size in bytes to the
+// termination message of the scan container, in the format of rsyncStatsScript
+const scanScript = `echo "files=$(find %[1]s -xdev -type f | wc -l) bytes=$(du -sb --apparent-size %[1]s | cut -f1)" > /dev/termination-log`
+
+// scanPodName returns the namespaced name of the pre-scan pod of the client
+func (tc *client) scanPodName() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: tc.namespace,
+		Name:      fmt.Sprintf("rsync-scan-%s", tc.nameSuffix),
+	}
+}
+
+// getEstimate returns the estimate recorded in the state of the client, or nil when the
+// pre-scan did not complete
+func getEstimate(state map[string]string) *transfer.Estimate {
+	files, err := strconv.ParseInt(state[totalFilesKey], 10, 64)
+	if err != nil {
+		return nil
+	}
+	bytes, err := strconv.ParseInt(state[totalBytesKey], 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &transfer.Estimate{TotalFiles: files, TotalBytes: bytes}
+}
+
+// reconcileScan runs the pre-scan pod of the client until it completes, records its result in
+// the state of the client and deletes it. It returns whether the pre-scan completed, failed
+// scans complete without an estimate so that the transfer is not blocked by them.
+func (tc *client) reconcileScan(ctx context.Context, c ctrlclient.Client, state map[string]string) (bool, error) {
+	if _, scanned := state[totalFilesKey]; scanned {
+		return true, nil
+	}
+
+	key := tc.scanPodName()
+	pod := &corev1.Pod{}
+	err := c.Get(ctx, key, pod)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, tc.createScanPod(ctx, c)
+	case err != nil:
+		return false, err
+	}
+
+	var terminated *corev1.ContainerStateTerminated
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.Name == ScanContainer && containerStatus.State.Terminated != nil {
+			terminated = containerStatus.State.Terminated
+		}
+	}
+	if terminated == nil {
+		tc.logger.Info("waiting for the pre-scan of the source PVCs to complete")
+		return false, nil
+	}
+
+	files, bytes := int64(0), int64(0)
+	if terminated.ExitCode == 0 {
+		files, bytes = parseTerminationMessage(terminated.Message)
+		tc.logger.Info("pre-scan of the source PVCs completed", "totalFiles", files, "totalBytes", bytes)
+	} else {
+		tc.logger.Info("pre-scan of the source PVCs failed, the transfer has no estimate", "exitCode", terminated.ExitCode)
+	}
+	err = tc.updateState(ctx, c, func(data map[string]string) {
+		data[totalFilesKey] = ""
+		data[totalBytesKey] = ""
+		if terminated.ExitCode == 0 {
+			data[totalFilesKey] = strconv.FormatInt(files, 10)
+			data[totalBytesKey] = strconv.FormatInt(bytes, 10)
+		}
+	})
+	if err != nil {
+		return false, err
+	}
+	err = c.Delete(ctx, pod, ctrlclient.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return false, err
+	}
+	return true, nil
+}
+
+func (tc *client) createScanPod(ctx context.Context, c ctrlclient.Client) error {
+	volumes := []corev1.Volume{}
+	volumeMounts := []corev1.VolumeMount{}
+	for _, pvc := range tc.pvcList.InNamespace(tc.namespace).PVCs() {
+		volumes = append(volumes, corev1.Volume{
+			Name: pvc.LabelSafeName(),
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: pvc.Claim().Name,
+					ReadOnly:  true,
+				},
+			},
+		})
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      pvc.LabelSafeName(),
+			MountPath: fmt.Sprintf("%s/%s", scanMountPath, pvc.LabelSafeName()),
+			ReadOnly:  true,
+		})
+	}
+	containers := []corev1.Container{{
+		Name:         ScanContainer,
+		Command:      []string{"/bin/bash", "-c", fmt.Sprintf(scanScript, scanMountPath)},
+		VolumeMounts: volumeMounts,
+	}}
+	applyContainerOptions(containers, tc.options)
+	applySELinuxOptions(containers, tc.options)
+	err := tc.options.ValidateExtraVolumeNames(volumes)
+	if err != nil {
+		return err
+	}
+
+	podSpec := corev1.PodSpec{
+		Containers:         containers,
+		Volumes:            volumes,
+		RestartPolicy:      corev1.RestartPolicyNever,
+		ServiceAccountName: tc.options.ServiceAccountName,
+		ImagePullSecrets:   tc.options.ImagePullSecrets,
+	}
+	applyPodOptions(&podSpec, tc.options)
+
+	key := tc.scanPodName()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, tc.logger, pod, reconcileOptions(tc.options), func() error {
+		pod.Labels = getLabels(tc.labels, tc.options)
+		pod.Annotations = getAnnotations(pod.Annotations, tc.options)
+		applyServiceMeshMode(&pod.ObjectMeta, tc.options)
+		applySCC(&pod.ObjectMeta, tc.options)
+		pod.OwnerReferences = tc.ownerRefs
+		if pod.CreationTimestamp.IsZero() {
+			pod.Spec = podSpec
+		}
+		return nil
+	})
+	return err
+}
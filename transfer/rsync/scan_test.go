@@ -0,0 +1,109 @@
+package rsync
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_client_reconcileScan(t *testing.T) {
+	tests := []struct {
+		name         string
+		exitCode     int32
+		message      string
+		wantEstimate *transfer.Estimate
+	}{
+		{
+			name:         "scan succeeds",
+			exitCode:     0,
+			message:      "files=12 bytes=4096",
+			wantEstimate: &transfer.Estimate{TotalFiles: 12, TotalBytes: 4096},
+		},
+		{
+			name:         "scan fails",
+			exitCode:     1,
+			wantEstimate: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeClient := fakeClientWithObjects()
+			tc := &client{
+				logger:   testr.New(t),
+				username: "root",
+				pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+				}),
+				nameSuffix:      "foo",
+				namespace:       "foo",
+				labels:          map[string]string{"test": "me"},
+				ownerRefs:       testOwnerReferences(),
+				transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+				options:         transfer.PodOptions{PreScan: true},
+			}
+			podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-client-foo-data"}
+
+			// the client pod waits for the scan pod
+			if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			scanPod := &corev1.Pod{}
+			if err := fakeClient.Get(ctx, tc.scanPodName(), scanPod); err != nil {
+				t.Fatalf("scan pod not created, error = %v", err)
+			}
+			for _, volume := range scanPod.Spec.Volumes {
+				if volume.PersistentVolumeClaim == nil || !volume.PersistentVolumeClaim.ReadOnly {
+					t.Errorf("scan pod volume %s is not a read-only PVC", volume.Name)
+				}
+			}
+			if err := fakeClient.Get(ctx, podKey, &corev1.Pod{}); !k8serrors.IsNotFound(err) {
+				t.Errorf("client pod created before the scan completed, error = %v", err)
+			}
+
+			scanPod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+				Name: ScanContainer,
+				State: corev1.ContainerState{
+					Terminated: &corev1.ContainerStateTerminated{ExitCode: tt.exitCode, Message: tt.message},
+				},
+			}}
+			if err := fakeClient.Status().Update(ctx, scanPod); err != nil {
+				t.Fatalf("unable to update scan pod status, error = %v", err)
+			}
+
+			// the client pod starts once the scan completed, failed or not
+			if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			if err := fakeClient.Get(ctx, tc.scanPodName(), &corev1.Pod{}); !k8serrors.IsNotFound(err) {
+				t.Errorf("scan pod not deleted, error = %v", err)
+			}
+			if err := fakeClient.Get(ctx, podKey, &corev1.Pod{}); err != nil {
+				t.Errorf("client pod not created, error = %v", err)
+			}
+			state, err := getState(ctx, fakeClient, tc.stateName())
+			if err != nil {
+				t.Fatalf("getState() error = %v", err)
+			}
+			if got := getEstimate(state); !reflect.DeepEqual(got, tt.wantEstimate) {
+				t.Errorf("getEstimate() = %v, want %v", got, tt.wantEstimate)
+			}
+
+			// the scan is not repeated
+			if err := tc.reconcilePod(ctx, fakeClient, "foo"); err != nil {
+				t.Fatalf("reconcilePod() error = %v", err)
+			}
+			if err := fakeClient.Get(ctx, tc.scanPodName(), &corev1.Pod{}); !k8serrors.IsNotFound(err) {
+				t.Errorf("scan pod recreated, error = %v", err)
+			}
+		})
+	}
+}
@@ -4,22 +4,26 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"strings"
 	"text/template"
 
 	"github.com/backube/pvc-transfer/endpoint"
 	"github.com/backube/pvc-transfer/endpoint/route"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/internal/utils"
 	"github.com/backube/pvc-transfer/transfer"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/backube/pvc-transfer/transport/stunnel"
 	"github.com/go-logr/logr"
+	securityv1 "github.com/openshift/api/security/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
-	ctrlutil "sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 // AddToScheme should be used as soon as scheme is created to add
@@ -33,7 +37,13 @@ func AddToScheme(scheme *runtime.Scheme) error {
 	if err != nil {
 		return err
 	}
-	return nil
+	// Jobs verify the integrity of transfers, see Options.IntegrityManifest
+	err = batchv1.AddToScheme(scheme)
+	if err != nil {
+		return err
+	}
+	// SCCs are only read when the pod options require one
+	return securityv1.AddToScheme(scheme)
 }
 
 // APIsToWatch give a list of APIs to watch if using this package
@@ -60,21 +70,47 @@ hosts allow = *.*.*.*, *
 use chroot = no
 munge symlinks = no
 read only = false
+{{- if $.FakeSuper }}
+fake super = yes
+{{- end }}
 
 [termination]
 	comment = special file for termination
-	path = /mnt/termination
+	path = {{ $.PathPrefix }}/mnt/termination
 {{ range $i, $pvc := .PVCList }}
+{{- if $.FanOutClients }}
+{{- range $client := $.FanOutClients }}
+[{{ $pvc.LabelSafeName }}-{{ $client }}]
+    comment = archive for {{ $pvc.Claim.Namespace }}/{{ $pvc.Claim.Name }} shared with {{ $client }}
+    path = {{ $.PathPrefix }}/mnt/{{ $pvc.Claim.Namespace }}/{{ $pvc.LabelSafeName }}
+    read only = yes
+    auth users = {{ $client }}
+    secrets file = {{ $.SecretsFile }}
+{{ end }}
+{{- else }}
 [{{ $pvc.LabelSafeName }}]
     comment = archive for {{ $pvc.Claim.Namespace }}/{{ $pvc.Claim.Name }}
-    path = /mnt/{{ $pvc.Claim.Namespace }}/{{ $pvc.LabelSafeName }}
+    path = {{ $.PathPrefix }}/mnt/{{ $pvc.Claim.Namespace }}/{{ $pvc.LabelSafeName }}
+{{- if $.ReadOnly }}
+    read only = yes
+{{- end }}
 {{ end }}
+{{- end }}
 `
 )
 
 type rsyncConfigData struct {
 	PVCList            transfer.PVCList
 	AllowLocalhostOnly bool
+	// PathPrefix is prepended to the paths of modules, see getLocalPath
+	PathPrefix string
+	// FanOutClients get read-only modules authenticated with SecretsFile, see Options.FanOutClients
+	FanOutClients []string
+	SecretsFile   string
+	// FakeSuper stores the attributes of files in extended attributes, see CommandOptions.FakeSuper
+	FakeSuper bool
+	// ReadOnly makes the modules of the PVCs read-only for pulling clients, see Options.Pull
+	ReadOnly bool
 }
 
 type reconcileFunc func(ctx context.Context, c ctrlclient.Client, namespace string) error
@@ -90,8 +126,21 @@ type server struct {
 	labels    map[string]string
 	ownerRefs []metav1.OwnerReference
 	options   transfer.PodOptions
+	mode      Mode
+	agent     bool
 	logger    logr.Logger
 
+	// fanOutClients are the clients pulling the PVCs of the server, see Options.FanOutClients
+	fanOutClients []string
+	// pull serves the PVCs to a pulling client, see Options.Pull
+	pull bool
+	// integrityManifest verifies the PVCs against the manifests of the client, see
+	// Options.IntegrityManifest
+	integrityManifest bool
+	// terminateOnCompletion stops the server once the client uploaded the termination file,
+	// see Options.TerminateOnCompletion
+	terminateOnCompletion bool
+
 	// TODO: this is a temporary field that needs to give away once multiple
 	//  namespace pvcList is supported
 	namespace string
@@ -105,12 +154,45 @@ func (s *server) Transport() transport.Transport {
 	return s.transportServer
 }
 
+// Containers returns the names of the rsync container and of the transport containers of the
+// server pod
+func (s *server) Containers() []string {
+	return getContainerNames(s.Transport())
+}
+
+// workload returns the reference of the server pod with the given containers
+func (s *server) workload(containers ...string) transfer.WorkloadReference {
+	return transfer.WorkloadReference{
+		Kind:           transfer.WorkloadPod,
+		NamespacedName: types.NamespacedName{Namespace: s.pvcList.Namespaces()[0], Name: fmt.Sprintf("rsync-server-%s", s.nameSuffix)},
+		Containers:     containers,
+	}
+}
+
+// Pods returns the key of the server pod for each PVC once the pod is created, a single
+// server pod receives the data of all the PVCs
+func (s *server) Pods(ctx context.Context, c ctrlclient.Client) (map[types.NamespacedName]ctrlclient.ObjectKey, error) {
+	pods := map[types.NamespacedName]ctrlclient.ObjectKey{}
+	podKey := s.workload().NamespacedName
+	err := c.Get(ctx, podKey, &corev1.Pod{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		return pods, nil
+	case err != nil:
+		return nil, err
+	}
+	for _, pvc := range s.pvcList.PVCs() {
+		pods[ctrlclient.ObjectKeyFromObject(pvc.Claim())] = podKey
+	}
+	return pods, nil
+}
+
 func (s *server) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
-	return transfer.IsPodHealthy(ctx, c, ctrlclient.ObjectKey{Namespace: s.pvcList.Namespaces()[0], Name: fmt.Sprintf("rsync-server-%s", s.nameSuffix)})
+	return transfer.IsWorkloadHealthy(ctx, c, s.workload(s.Containers()...))
 }
 
 func (s *server) Completed(ctx context.Context, c ctrlclient.Client) (bool, error) {
-	return transfer.IsPodCompleted(ctx, c, ctrlclient.ObjectKey{Namespace: s.pvcList.Namespaces()[0], Name: fmt.Sprintf("rsync-server-%s", s.nameSuffix)}, "rsync")
+	return transfer.IsWorkloadCompleted(ctx, c, s.workload(RsyncContainer))
 }
 
 // MarkForCleanup marks the provided "obj" to be deleted at the end of the
@@ -148,11 +230,66 @@ func (s *server) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, v
 		},
 	}
 	err = utils.UpdateWithLabel(ctx, c, pod, key, value)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	// the state is only created once the server was cancelled
+	state := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.stateName().Name,
+			Namespace: s.namespace,
+		},
+	}
+	err = utils.UpdateWithLabel(ctx, c, state, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
-	// update service account
+	// the integrity verification job is only created with Options.IntegrityManifest, its
+	// result is kept as a record of the migration
+	integrityJob := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.integrityName().Name,
+			Namespace: s.namespace,
+		},
+	}
+	err = utils.UpdateWithLabel(ctx, c, integrityJob, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	// update ssh keys
+	sshSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.SSHCredentials().Name,
+			Namespace: s.namespace,
+		},
+	}
+	err = utils.UpdateWithLabel(ctx, c, sshSecret, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+
+	// update fan-out secrets
+	fanOutSecrets := []types.NamespacedName{s.fanOutSecretsName()}
+	for _, client := range s.fanOutClients {
+		fanOutSecrets = append(fanOutSecrets, s.FanOutCredentials(client))
+	}
+	for _, secretRef := range fanOutSecrets {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      secretRef.Name,
+				Namespace: secretRef.Namespace,
+			},
+		}
+		err = utils.UpdateWithLabel(ctx, c, secret, key, value)
+		if err != nil && !k8serrors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	// service account and RBAC are not created when callers provide their own service account
 	sa := &corev1.ServiceAccount{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      fmt.Sprintf("%s-%s", rsyncServiceAccount, s.nameSuffix),
@@ -160,7 +297,7 @@ func (s *server) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, v
 		},
 	}
 	err = utils.UpdateWithLabel(ctx, c, sa, key, value)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
@@ -172,7 +309,7 @@ func (s *server) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, v
 		},
 	}
 	err = utils.UpdateWithLabel(ctx, c, role, key, value)
-	if err != nil {
+	if err != nil && !k8serrors.IsNotFound(err) {
 		return err
 	}
 
@@ -183,7 +320,11 @@ func (s *server) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, v
 			Namespace: s.namespace,
 		},
 	}
-	return utils.UpdateWithLabel(ctx, c, roleBinding, key, value)
+	err = utils.UpdateWithLabel(ctx, c, roleBinding, key, value)
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	return nil
 }
 
 func (s *server) PVCs() []*corev1.PersistentVolumeClaim {
@@ -205,53 +346,61 @@ func (s *server) ListenPort() int32 {
 // In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
 // +kubebuilder:rbac:groups=core,resources=services;secrets;configmaps;pods;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get;use
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get
 // +kubebuilder:rbac:groups=route.openshift.io,resources=routes,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
 func NewServerWithStunnelRoute(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	pvcList transfer.PVCList,
 	labels map[string]string,
 	ownerRefs []metav1.OwnerReference,
 	podOptions transfer.PodOptions) (transfer.Server, error) {
 
-	var namespace string
-	namespaces := pvcList.Namespaces()
-	if len(namespaces) > 0 {
-		namespace = pvcList.Namespaces()[0]
-	}
-
-	for _, ns := range namespaces {
-		if ns != namespace {
-			return nil, fmt.Errorf("PVC list provided has pvcs in different namespaces which is not supported")
-		}
-	}
-
-	if namespace == "" {
-		return nil, fmt.Errorf("ether PVC list is empty or namespace is not specified")
+	namespace, err := getNamespace(pvcList)
+	if err != nil {
+		return nil, err
 	}
 	hm := transfer.NamespaceHashForNames(pvcList)
-	e, err := route.New(ctx, c, logger, types.NamespacedName{
+	e, err := route.NewWithOptions(ctx, c, logger, types.NamespacedName{
 		Namespace: namespace,
 		Name:      hm[namespace],
-	}, route.EndpointTypePassthrough, nil,
-		labels, ownerRefs)
+	}, route.EndpointTypePassthrough, route.Options{
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		ServerSideApply: podOptions.ServerSideApply,
+		FieldManager:    podOptions.FieldManager,
+		Adopt:           podOptions.Adopt,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	t, err := stunnel.NewServer(ctx, c, logger, types.NamespacedName{Namespace: namespace, Name: hm[namespace]}, e, &transport.Options{Labels: labels, Owners: ownerRefs})
+	t, err := stunnel.NewServer(ctx, c, logger, types.NamespacedName{Namespace: namespace, Name: hm[namespace]}, e, &transport.Options{
+		Labels:          labels,
+		Owners:          ownerRefs,
+		ServerSideApply: podOptions.ServerSideApply,
+		FieldManager:    podOptions.FieldManager,
+		Adopt:           podOptions.Adopt,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return NewServer(ctx, c, logger, pvcList, t, e, labels, ownerRefs, podOptions)
+	return NewServerFromOptions(ctx, c, logger, ServerOptions{
+		PVCList:         pvcList,
+		Transport:       t,
+		Endpoint:        e,
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		PodOptions:      podOptions,
+	})
 }
 
 // NewServer takes PVCList, transport and endpoint object and all
 // the resources required by the transfer server pod as well as the transfer
 // pod. All the PVCs in the list can be sync'ed via the endpoint object
-
-// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
-// +kubebuilder:rbac:groups=core,resources=secrets;configmaps;pods;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+//
+// Deprecated: use NewServerFromOptions.
 func NewServer(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	pvcList transfer.PVCList,
 	t transport.Transport,
@@ -259,69 +408,202 @@ func NewServer(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	labels map[string]string,
 	ownerRefs []metav1.OwnerReference,
 	podOptions transfer.PodOptions) (transfer.Server, error) {
-	r := &server{
-		pvcList:         pvcList,
-		transportServer: t,
-		endpoint:        e,
-		listenPort:      t.ConnectPort(),
-		labels:          labels,
-		ownerRefs:       ownerRefs,
-		options:         podOptions,
-	}
+	return NewServerFromOptions(ctx, c, logger, ServerOptions{
+		PVCList:         pvcList,
+		Transport:       t,
+		Endpoint:        e,
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		PodOptions:      podOptions,
+	})
+}
 
-	var namespace string
-	namespaces := pvcList.Namespaces()
-	if len(namespaces) > 0 {
-		namespace = pvcList.Namespaces()[0]
+// NewServerWithOptions is NewServer with the rsync mode selected in options.
+//
+// Deprecated: use NewServerFromOptions.
+func NewServerWithOptions(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	pvcList transfer.PVCList,
+	t transport.Transport,
+	e endpoint.Endpoint,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	podOptions transfer.PodOptions,
+	options Options) (transfer.Server, error) {
+	return NewServerFromOptions(ctx, c, logger, ServerOptions{
+		PVCList:         pvcList,
+		Transport:       t,
+		Endpoint:        e,
+		Labels:          labels,
+		OwnerReferences: ownerRefs,
+		PodOptions:      podOptions,
+		Options:         options,
+	})
+}
+
+// NewServerFromOptions creates the resources of the rsync server of serverOptions, see
+// ServerOptions. All the PVCs in the list can be sync'ed via the endpoint. In ModeSSH the server
+// runs sshd instead of an rsync daemon and generates the keys of the transfer, clients need a
+// copy of the secret returned by SSHCredentials.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=secrets;configmaps;pods;serviceaccounts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=rbac.authorization.k8s.io,resources=roles;rolebindings,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=security.openshift.io,resources=securitycontextconstraints,verbs=get;use
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get
+func NewServerFromOptions(ctx context.Context, c ctrlclient.Client, logger logr.Logger, serverOptions ServerOptions) (transfer.Server, error) {
+	err := serverOptions.Validate()
+	if err != nil {
+		return nil, err
+	}
+	pvcList, t, podOptions, options := serverOptions.PVCList, serverOptions.Transport, serverOptions.PodOptions, serverOptions.Options
+	mode, err := getMode(options)
+	if err != nil {
+		return nil, err
+	}
+	podOptions, err = transfer.ResolveArchitecture(ctx, c, pvcList, podOptions)
+	if err != nil {
+		return nil, err
+	}
+	err = transport.ValidateImage(getRsyncImage(podOptions), podOptions.RequireImageDigests)
+	if err != nil {
+		return nil, err
+	}
+	err = validateServiceMeshMode(podOptions, t)
+	if err != nil {
+		return nil, err
+	}
+	err = validateOS(podOptions, options)
+	if err != nil {
+		return nil, err
+	}
+	err = podOptions.ValidateExtraVolumes(reservedMountPaths...)
+	if err != nil {
+		return nil, err
+	}
+	err = validateFanOutServer(pvcList, podOptions, options, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = validatePullServer(options, mode)
+	if err != nil {
+		return nil, err
+	}
+	err = validateIntegrityManifestServer(podOptions, options)
+	if err != nil {
+		return nil, err
 	}
+	r := &server{
+		mode:                  mode,
+		agent:                 options.Agent,
+		fanOutClients:         options.FanOutClients,
+		pull:                  options.Pull,
+		integrityManifest:     options.IntegrityManifest,
+		terminateOnCompletion: serverTerminatesOnCompletion(podOptions, options),
+		pvcList:               pvcList,
+		transportServer:       t,
+		endpoint:              serverOptions.Endpoint,
+		listenPort:            t.ConnectionInfo().Port,
+		labels:                serverOptions.Labels,
+		ownerRefs:             serverOptions.OwnerReferences,
+		options:               podOptions,
+	}
+
+	namespace, err := getNamespace(pvcList)
+	if err != nil {
+		return nil, err
+	}
+	r.namespace = namespace
 
 	r.nameSuffix = transfer.NamespaceHashForNames(pvcList)[namespace][:10]
 	r.logger = logger.WithValues("rsyncServer", r.nameSuffix)
 
-	for _, ns := range namespaces {
-		if ns != namespace {
-			return nil, fmt.Errorf("PVC list provided has pvcs in different namespaces which is not supported")
-		}
-	}
-	if namespace == "" {
-		return nil, fmt.Errorf("ether PVC list is empty or namespace is not specified")
+	err = r.reconcile(ctx, c)
+	if err != nil {
+		return nil, err
 	}
-	r.namespace = namespace
 
+	return r, nil
+}
+
+// reconcile reconciles all the resources of the server, the server pod last
+func (s *server) reconcile(ctx context.Context, c ctrlclient.Client) error {
 	reconcilers := []reconcileFunc{
-		r.reconcileConfigMap,
-		r.reconcilePod,
+		s.reconcileServiceAccount,
+		s.reconcileConfigMap,
+		s.reconcilePod,
+	}
+	if s.mode == ModeSSH {
+		reconcilers = append([]reconcileFunc{s.reconcileSSHSecret}, reconcilers...)
+	}
+	if len(s.fanOutClients) > 0 {
+		reconcilers = append([]reconcileFunc{s.reconcileFanOutSecrets}, reconcilers...)
 	}
 
-	for _, reconcile := range reconcilers {
-		err := reconcile(ctx, c, r.namespace)
+	for _, reconcileFn := range reconcilers {
+		err := reconcileFn(ctx, c, s.namespace)
 		if err != nil {
-			r.logger.Error(err, "error reconciling rsyncServer")
-			return nil, err
+			s.logger.Error(err, "error reconciling rsyncServer")
+			return err
 		}
 	}
+	return nil
+}
 
-	return r, nil
+// Reconcile restores the resources of the server and of its transport, e.g. after they were
+// edited mid-transfer, and restarts the server pod when the ConfigMaps and Secrets it mounts
+// changed since it was created. The pod is recreated by the next call.
+func (s *server) Reconcile(ctx context.Context, c ctrlclient.Client) error {
+	if r, ok := s.Transport().(transport.Reconciler); ok {
+		err := r.Reconcile(ctx, c)
+		if err != nil {
+			return err
+		}
+	}
+	return s.reconcile(ctx, c)
 }
 
 func (s *server) reconcileConfigMap(ctx context.Context, c ctrlclient.Client, namespace string) error {
 	var rsyncConf bytes.Buffer
-	rsyncConfTemplate, err := template.New("config").Parse(rsyncServerConfTemplate)
-	if err != nil {
-		s.logger.Error(err, "unable to parse rsyncServerConfTemplate")
-		return err
-	}
-
 	allowLocalhostOnly := s.Transport().Type() == stunnel.TransportTypeStunnel
-	configdata := rsyncConfigData{
-		PVCList:            s.pvcList.InNamespace(namespace),
-		AllowLocalhostOnly: allowLocalhostOnly,
-	}
+	configKey := "rsyncd.conf"
+	if s.mode == ModeSSH {
+		configKey = "sshd_config"
+		sshdConfTemplate, err := template.New("config").Parse(sshdConfTemplate)
+		if err != nil {
+			s.logger.Error(err, "unable to parse sshdConfTemplate")
+			return err
+		}
+		err = sshdConfTemplate.Execute(&rsyncConf, sshdConfigData{
+			Port:               s.ListenPort(),
+			AllowLocalhostOnly: allowLocalhostOnly,
+			KeysPath:           sshKeysMountPath,
+		})
+		if err != nil {
+			s.logger.Error(err, "unable to execute sshdConfTemplate")
+			return err
+		}
+	} else {
+		rsyncConfTemplate, err := template.New("config").Parse(rsyncServerConfTemplate)
+		if err != nil {
+			s.logger.Error(err, "unable to parse rsyncServerConfTemplate")
+			return err
+		}
 
-	err = rsyncConfTemplate.Execute(&rsyncConf, configdata)
-	if err != nil {
-		s.logger.Error(err, "unable to execute rsyncServerConfTemplate")
-		return err
+		configdata := rsyncConfigData{
+			PVCList:            s.pvcList.InNamespace(namespace),
+			AllowLocalhostOnly: allowLocalhostOnly,
+			PathPrefix:         getLocalPath(s.options, ""),
+			FanOutClients:      s.fanOutClients,
+			SecretsFile:        getLocalPath(s.options, fanOutSecretsMountPath),
+			ReadOnly:           s.pull,
+			FakeSuper:          fakeSuperEnabled(s.options),
+		}
+
+		err = rsyncConfTemplate.Execute(&rsyncConf, configdata)
+		if err != nil {
+			s.logger.Error(err, "unable to execute rsyncServerConfTemplate")
+			return err
+		}
 	}
 
 	rsyncConfigMap := &corev1.ConfigMap{
@@ -331,18 +613,45 @@ func (s *server) reconcileConfigMap(ctx context.Context, c ctrlclient.Client, na
 		},
 	}
 
-	_, err = ctrlutil.CreateOrUpdate(ctx, c, rsyncConfigMap, func() error {
-		rsyncConfigMap.Labels = s.labels
+	_, err := reconcile.CreateOrUpdate(ctx, c, s.logger, rsyncConfigMap, reconcileOptions(s.options), func() error {
+		rsyncConfigMap.Labels = getLabels(s.labels, s.options)
+		rsyncConfigMap.Annotations = getAnnotations(rsyncConfigMap.Annotations, s.options)
 		rsyncConfigMap.OwnerReferences = s.ownerRefs
 		rsyncConfigMap.Data = map[string]string{
-			"rsyncd.conf": rsyncConf.String(),
+			configKey: rsyncConf.String(),
 		}
 		return nil
 	})
 	return err
 }
 
+func (s *server) reconcileServiceAccount(ctx context.Context, c ctrlclient.Client, namespace string) error {
+	return reconcileServiceAccount(ctx, c, s.logger, namespace, s.nameSuffix, s.labels, s.ownerRefs, &s.options)
+}
+
+func (s *server) reconcileSSHSecret(ctx context.Context, c ctrlclient.Client, namespace string) error {
+	return reconcileSSHSecret(ctx, c, s.logger, s.SSHCredentials(), s.labels, s.ownerRefs, s.options)
+}
+
+// SSHCredentials returns the secret holding the keys of the transfer in ModeSSH, the secret
+// has to be copied to the namespace of the client
+func (s *server) SSHCredentials() types.NamespacedName {
+	return types.NamespacedName{
+		Namespace: s.namespace,
+		Name:      fmt.Sprintf("%s-%s", rsyncSSHSecret, s.nameSuffix),
+	}
+}
+
 func (s *server) reconcilePod(ctx context.Context, c ctrlclient.Client, namespace string) error {
+	cancelled, err := s.isCancelled(ctx, c)
+	if err != nil {
+		return err
+	}
+	if cancelled {
+		s.logger.Info("rsync server is cancelled, skipping the server pod")
+		return nil
+	}
+
 	volumeMounts := []corev1.VolumeMount{}
 	configVolumeMounts := s.getConfigVolumeMounts()
 	pvcVolumeMounts := s.getPVCVolumeMounts(namespace)
@@ -350,9 +659,16 @@ func (s *server) reconcilePod(ctx context.Context, c ctrlclient.Client, namespac
 	volumeMounts = append(volumeMounts, configVolumeMounts...)
 	volumeMounts = append(volumeMounts, pvcVolumeMounts...)
 	volumeMounts = append(volumeMounts, getTerminationVolumeMounts()...)
+	if terminatesOnCompletion(s.Transport()) {
+		volumeMounts = append(volumeMounts, getCompletionVolumeMount())
+	}
 	containers := s.getContainers(volumeMounts)
+	applyContainerOptions(containers, s.options)
+	applyFakeSuper(containers, s.options)
 
-	containers = append(containers, s.Transport().Containers()...)
+	containers, initContainers := getTransportContainers(s.Transport(), containers)
+	applySELinuxOptions(containers, s.options)
+	applySELinuxOptions(initContainers, s.options)
 
 	mode := int32(0600)
 
@@ -360,14 +676,23 @@ func (s *server) reconcilePod(ctx context.Context, c ctrlclient.Client, namespac
 	pvcVolumes := s.getPVCVolumes(namespace)
 
 	volumes := append(pvcVolumes, configVolumes...)
+	if s.mode == ModeSSH {
+		volumes = append(volumes, getSSHKeysVolume(s.SSHCredentials().Name, sshHostKey, sshAuthorizedKeys))
+	}
 	volumes = append(volumes, s.Transport().Volumes()...)
 	volumes = append(volumes, getTerminationVolumes()...)
+	err = s.options.ValidateExtraVolumeNames(volumes)
+	if err != nil {
+		return err
+	}
 
 	podSpec := corev1.PodSpec{
+		InitContainers:     initContainers,
 		Containers:         containers,
 		Volumes:            volumes,
 		RestartPolicy:      corev1.RestartPolicyNever,
 		ServiceAccountName: s.options.ServiceAccountName,
+		ImagePullSecrets:   getImagePullSecrets(s.options, s.Transport()),
 	}
 
 	applyPodOptions(&podSpec, s.options)
@@ -380,8 +705,26 @@ func (s *server) reconcilePod(ctx context.Context, c ctrlclient.Client, namespac
 		Spec: podSpec,
 	}
 
-	_, err := ctrlutil.CreateOrUpdate(ctx, c, server, func() error {
-		server.Labels = s.labels
+	// pod specs are only set at creation, pods mounting a configuration rendered differently
+	// since then are deleted and recreated by the next reconcile
+	restarted, err := transfer.RestartOnDrift(ctx, c, s.logger, ctrlclient.ObjectKeyFromObject(server))
+	if err != nil {
+		return err
+	}
+	if restarted {
+		return nil
+	}
+
+	configHash, err := transfer.PodConfigHash(ctx, c, ctrlclient.ObjectKeyFromObject(server), podSpec.Volumes)
+	if err != nil {
+		return err
+	}
+
+	_, err = reconcile.CreateOrUpdate(ctx, c, s.logger, server, reconcileOptions(s.options), func() error {
+		server.Labels = getLabels(s.labels, s.options)
+		server.Annotations = transfer.WithConfigHash(getAnnotations(server.Annotations, s.options), configHash)
+		applyServiceMeshMode(&server.ObjectMeta, s.options)
+		applySCC(&server.ObjectMeta, s.options)
 		server.OwnerReferences = s.ownerRefs
 		if server.CreationTimestamp.IsZero() {
 			server.Spec = podSpec
@@ -392,7 +735,7 @@ func (s *server) reconcilePod(ctx context.Context, c ctrlclient.Client, namespac
 }
 
 func (s *server) getConfigVolumes(mode int32) []corev1.Volume {
-	return []corev1.Volume{
+	volumes := []corev1.Volume{
 		{
 			Name: fmt.Sprintf("%s-%s", rsyncConfig, s.nameSuffix),
 			VolumeSource: corev1.VolumeSource{
@@ -410,6 +753,10 @@ func (s *server) getConfigVolumes(mode int32) []corev1.Volume {
 			},
 		},
 	}
+	if len(s.fanOutClients) > 0 {
+		volumes = append(volumes, s.getFanOutSecretsVolume())
+	}
+	return volumes
 }
 
 func (s *server) getPVCVolumeMounts(namespace string) []corev1.VolumeMount {
@@ -419,39 +766,75 @@ func (s *server) getPVCVolumeMounts(namespace string) []corev1.VolumeMount {
 			pvcVolumeMounts,
 			corev1.VolumeMount{
 				Name:      pvc.LabelSafeName(),
-				MountPath: fmt.Sprintf("/mnt/%s/%s", pvc.Claim().Namespace, pvc.LabelSafeName()),
+				MountPath: s.getPVCMountPath(pvc),
+				ReadOnly:  s.isSource(),
 			})
 	}
 	return pvcVolumeMounts
 }
 
+// isSource returns whether clients pull the PVCs of the server, which are then the source of the transfer
+func (s *server) isSource() bool {
+	return s.pull || len(s.fanOutClients) > 0
+}
+
+// getPVCMountPath returns where a PVC is mounted in the server, rsync clients in ModeSSH
+// sync to sshDataMountPath/<pvc.LabelSafeName()> as they do not know the destination namespace
+func (s *server) getPVCMountPath(pvc transfer.PVC) string {
+	if s.mode == ModeSSH {
+		return fmt.Sprintf("%s/%s", sshDataMountPath, pvc.LabelSafeName())
+	}
+	return fmt.Sprintf("/mnt/%s/%s", pvc.Claim().Namespace, pvc.LabelSafeName())
+}
+
 func (s *server) getContainers(volumeMounts []corev1.VolumeMount) []corev1.Container {
 	rsyncCommandTemplate := fmt.Sprintf(
 		"/usr/bin/rsync --daemon --port=%d --no-detach -vvv", int(s.ListenPort()))
-	if s.options.TerminateOnCompletion != nil && *s.options.TerminateOnCompletion {
+	portName := "rsyncd"
+	if s.mode == ModeSSH {
+		rsyncCommandTemplate = "/usr/sbin/sshd -D -e -f /etc/ssh/sshd_config"
+		portName = "sshd"
+	}
+	if s.terminateOnCompletion {
 		terminationScript := ` &
 while true; do
-	if [[ -f /mnt/termination/done ]]
+	if [[ %s ]]
 	then
-		sync
+		sync%s
 		exit 0; 
 	fi
 	sleep 1;
 done`
+		notifyTransport := ""
+		if terminatesOnCompletion(s.Transport()) {
+			notifyTransport = fmt.Sprintf("\n\t\ttouch %s", transport.CompletionFile)
+		}
+		terminationTests := []string{}
+		for _, pvc := range s.pvcList.PVCs() {
+			terminationTests = append(terminationTests, fmt.Sprintf("-f %s", pvcTerminationFile(pvc)))
+		}
+		terminationScript = fmt.Sprintf(terminationScript, strings.Join(terminationTests, " && "), notifyTransport)
 		rsyncCommandTemplate = fmt.Sprintf("%s%s", rsyncCommandTemplate, terminationScript)
 	}
 
+	command := []string{
+		"/bin/bash",
+		"-c",
+		rsyncCommandTemplate,
+	}
+	var env []corev1.EnvVar
+	if s.agent {
+		command, env = s.getAgentCommand()
+	}
+
 	return []corev1.Container{
 		{
-			Name: RsyncContainer,
-			Command: []string{
-				"/bin/bash",
-				"-c",
-				rsyncCommandTemplate,
-			},
+			Name:    RsyncContainer,
+			Command: command,
+			Env:     env,
 			Ports: []corev1.ContainerPort{
 				{
-					Name:          "rsyncd",
+					Name:          portName,
 					Protocol:      corev1.ProtocolTCP,
 					ContainerPort: s.ListenPort(),
 				},
@@ -461,6 +844,19 @@ done`
 	}
 }
 
+// serverTerminatesOnCompletion returns whether a server stops once the client uploaded the
+// termination file, see Options.TerminateOnCompletion
+func serverTerminatesOnCompletion(podOptions transfer.PodOptions, options Options) bool {
+	switch {
+	case options.TerminateOnCompletion != nil:
+		return *options.TerminateOnCompletion
+	case podOptions.TerminateOnCompletion != nil:
+		return *podOptions.TerminateOnCompletion
+	default:
+		return len(options.FanOutClients) == 0
+	}
+}
+
 func (s *server) getPVCVolumes(namespace string) []corev1.Volume {
 	pvcVolumes := []corev1.Volume{}
 	for _, pvc := range s.pvcList.InNamespace(namespace).PVCs() {
@@ -471,6 +867,7 @@ func (s *server) getPVCVolumes(namespace string) []corev1.Volume {
 				VolumeSource: corev1.VolumeSource{
 					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
 						ClaimName: pvc.Claim().Name,
+						ReadOnly:  s.isSource(),
 					},
 				},
 			},
@@ -480,7 +877,17 @@ func (s *server) getPVCVolumes(namespace string) []corev1.Volume {
 }
 
 func (s *server) getConfigVolumeMounts() []corev1.VolumeMount {
-	return []corev1.VolumeMount{
+	if s.mode == ModeSSH {
+		return []corev1.VolumeMount{
+			{
+				Name:      fmt.Sprintf("%s-%s", rsyncConfig, s.nameSuffix),
+				MountPath: "/etc/ssh/sshd_config",
+				SubPath:   "sshd_config",
+			},
+			getSSHKeysVolumeMount(),
+		}
+	}
+	volumeMounts := []corev1.VolumeMount{
 		{
 			Name:      fmt.Sprintf("%s-%s", rsyncConfig, s.nameSuffix),
 			MountPath: "/etc/rsyncd.conf",
@@ -491,4 +898,8 @@ func (s *server) getConfigVolumeMounts() []corev1.VolumeMount {
 			MountPath: rsyncdLogDirPath,
 		},
 	}
+	if len(s.fanOutClients) > 0 {
+		volumeMounts = append(volumeMounts, getFanOutSecretsVolumeMount())
+	}
+	return volumeMounts
 }
@@ -2,6 +2,7 @@ package rsync
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"strings"
@@ -10,8 +11,10 @@ import (
 	"github.com/backube/pvc-transfer/transfer"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/backube/pvc-transfer/transport/stunnel"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -33,6 +36,12 @@ func testOwnerReferences() []metav1.OwnerReference {
 
 type fakeTransportServer struct {
 	transportType transport.Type
+	containers    []corev1.Container
+	pullSecrets   []corev1.LocalObjectReference
+}
+
+func (f *fakeTransportServer) ImagePullSecrets() []corev1.LocalObjectReference {
+	return f.pullSecrets
 }
 
 func (f *fakeTransportServer) NamespacedName() types.NamespacedName {
@@ -48,6 +57,9 @@ func (f *fakeTransportServer) ConnectPort() int32 {
 }
 
 func (f *fakeTransportServer) Containers() []corev1.Container {
+	if f.containers != nil {
+		return f.containers
+	}
 	return []corev1.Container{{Name: "fakeTransportServerContainer"}}
 }
 
@@ -69,6 +81,18 @@ func (f *fakeTransportServer) Hostname() string {
 	panic("implement me")
 }
 
+func (f *fakeTransportServer) ConnectionInfo() transport.ConnectionInfo {
+	return transport.ConnectionInfo{
+		Scheme:   transport.SchemeTCP,
+		Hostname: f.Hostname(),
+		Port:     f.ConnectPort(),
+	}
+}
+
+func (f *fakeTransportServer) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	return true, nil
+}
+
 func (f *fakeTransportServer) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, value string) error {
 	panic("implement me")
 }
@@ -101,7 +125,7 @@ func Test_server_reconcileConfigMap(t *testing.T) {
 					Namespace: "foo",
 				},
 			}),
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -117,7 +141,7 @@ func Test_server_reconcileConfigMap(t *testing.T) {
 					Namespace: "foo",
 				},
 			}),
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -142,7 +166,7 @@ func Test_server_reconcileConfigMap(t *testing.T) {
 					Namespace: "foo",
 				},
 			}),
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -163,7 +187,7 @@ func Test_server_reconcileConfigMap(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			fakeClient := fakeClientWithObjects(tt.objects...)
 			s := &server{
-				logger:          logrtesting.TestLogger{t},
+				logger:          testr.New(t),
 				nameSuffix:      tt.nameSuffix,
 				pvcList:         tt.pvcList,
 				labels:          tt.labels,
@@ -202,6 +226,31 @@ func Test_server_reconcileConfigMap(t *testing.T) {
 	}
 }
 
+func Test_server_reconcileConfigMap_fakeSuper(t *testing.T) {
+	for _, fakeSuper := range []bool{false, true} {
+		fakeClient := fakeClientWithObjects()
+		s := &server{
+			logger:     testr.New(t),
+			nameSuffix: "foo",
+			pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+			}),
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+			options:         transfer.PodOptions{CommandOptions: &CommandOptions{FakeSuper: fakeSuper}},
+		}
+		if err := s.reconcileConfigMap(context.Background(), fakeClient, "foo"); err != nil {
+			t.Fatalf("reconcileConfigMap() error = %v", err)
+		}
+		cm := &corev1.ConfigMap{}
+		if err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: rsyncConfig + "-foo"}, cm); err != nil {
+			t.Fatalf("unable to get configmap: %v", err)
+		}
+		if got := strings.Contains(cm.Data["rsyncd.conf"], "fake super = yes"); got != fakeSuper {
+			t.Errorf("rsyncd.conf with fake super %v contains fake super = %v", fakeSuper, got)
+		}
+	}
+}
+
 func Test_server_reconcilePod(t *testing.T) {
 	tests := []struct {
 		name            string
@@ -226,7 +275,7 @@ func Test_server_reconcilePod(t *testing.T) {
 				},
 			}),
 			listenPort:      8080,
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -243,7 +292,7 @@ func Test_server_reconcilePod(t *testing.T) {
 				},
 			}),
 			listenPort:      8080,
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -269,7 +318,7 @@ func Test_server_reconcilePod(t *testing.T) {
 				},
 			}),
 			listenPort:      8080,
-			transportServer: &fakeTransportServer{stunnel.TransportTypeStunnel},
+			transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
 			labels:          map[string]string{"test": "me"},
 			ownerRefs:       testOwnerReferences(),
 			wantErr:         false,
@@ -291,7 +340,7 @@ func Test_server_reconcilePod(t *testing.T) {
 			fakeClient := fakeClientWithObjects(tt.objects...)
 			ctx := context.WithValue(context.Background(), "test", tt.name)
 			s := &server{
-				logger:          logrtesting.TestLogger{t},
+				logger:          testr.New(t),
 				pvcList:         tt.pvcList,
 				transportServer: tt.transportServer,
 				listenPort:      tt.listenPort,
@@ -321,3 +370,322 @@ func Test_server_reconcilePod(t *testing.T) {
 		})
 	}
 }
+
+func Test_server_reconcilePod_transportContainers(t *testing.T) {
+	transportContainer := corev1.Container{
+		Name:      stunnel.Container,
+		Image:     "quay.io/test/stunnel:latest",
+		Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}},
+	}
+	fakeClient := fakeClientWithObjects()
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel, containers: []corev1.Container{transportContainer}},
+		listenPort:      8080,
+		nameSuffix:      "foo",
+		options: transfer.PodOptions{
+			Image:     "quay.io/test/rsync:latest",
+			Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+		},
+	}
+	if err := s.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	pod := &corev1.Pod{}
+	err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}, pod)
+	if err != nil {
+		t.Fatalf("unable to get pod %v", err)
+	}
+	for _, container := range pod.Spec.Containers {
+		switch container.Name {
+		case RsyncContainer:
+			if container.Image != s.options.Image {
+				t.Errorf("rsync container image = %s, want %s", container.Image, s.options.Image)
+			}
+		case stunnel.Container:
+			if container.Image != transportContainer.Image {
+				t.Errorf("transport container image = %s, want %s", container.Image, transportContainer.Image)
+			}
+			if !container.Resources.Limits.Cpu().Equal(resource.MustParse("100m")) {
+				t.Errorf("transport container resources = %v, want %v", container.Resources, transportContainer.Resources)
+			}
+		}
+	}
+}
+
+func Test_server_Reconcile(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	ctx := context.Background()
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		listenPort:      8080,
+		nameSuffix:      "foo",
+		namespace:       "foo",
+		ownerRefs:       testOwnerReferences(),
+	}
+	if err := s.Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}
+	pod := &corev1.Pod{}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("unable to get pod %v", err)
+	}
+	configHash := pod.Annotations[transfer.ConfigHashAnnotation]
+	if configHash == "" {
+		t.Fatalf("pod does not have the config hash annotation")
+	}
+
+	// edits to the configmap are reverted, the pod is kept
+	cm := &corev1.ConfigMap{}
+	if err := fakeClient.Get(ctx, types.NamespacedName{Namespace: "foo", Name: rsyncConfig + "-foo"}, cm); err != nil {
+		t.Fatalf("unable to get configmap %v", err)
+	}
+	original := cm.Data
+	cm.Data = map[string]string{"rsyncd.conf": "edited"}
+	if err := fakeClient.Update(ctx, cm); err != nil {
+		t.Fatalf("unable to update configmap %v", err)
+	}
+	if err := s.Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, ctrlclient.ObjectKeyFromObject(cm), cm); err != nil {
+		t.Fatalf("unable to get configmap %v", err)
+	}
+	if !reflect.DeepEqual(cm.Data, original) {
+		t.Errorf("Reconcile() did not restore the configmap, got %v, want %v", cm.Data, original)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("Reconcile() must not restart the pod when the configuration was restored: %v", err)
+	}
+
+	// a pod created with a different configuration is restarted
+	pod.Annotations[transfer.ConfigHashAnnotation] = "stale"
+	if err := fakeClient.Update(ctx, pod); err != nil {
+		t.Fatalf("unable to update pod %v", err)
+	}
+	if err := s.Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); !k8serrors.IsNotFound(err) {
+		t.Fatalf("Reconcile() must delete the pod when its configuration drifted, got %v", err)
+	}
+	if err := s.Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("Reconcile() must recreate the pod: %v", err)
+	}
+	if pod.Annotations[transfer.ConfigHashAnnotation] != configHash {
+		t.Errorf("recreated pod config hash = %s, want %s", pod.Annotations[transfer.ConfigHashAnnotation], configHash)
+	}
+}
+
+func Test_server_reconcile_configChanged(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	ctx := context.Background()
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		listenPort:      8080,
+		nameSuffix:      "foo",
+		namespace:       "foo",
+		ownerRefs:       testOwnerReferences(),
+	}
+	podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}
+	if err := s.reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	pod := &corev1.Pod{}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("unable to get pod %v", err)
+	}
+	configHash := pod.Annotations[transfer.ConfigHashAnnotation]
+
+	// the same configuration must keep the pod
+	if err := s.reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("reconcile() must not restart the pod when the configuration is unchanged: %v", err)
+	}
+
+	// a configuration rendered differently must restart the pod
+	s.pull = true
+	if err := s.reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); !k8serrors.IsNotFound(err) {
+		t.Fatalf("reconcile() must delete the pod mounting a stale configuration, got %v", err)
+	}
+	if err := s.reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(ctx, podKey, pod); err != nil {
+		t.Fatalf("reconcile() must recreate the pod: %v", err)
+	}
+	if pod.Annotations[transfer.ConfigHashAnnotation] == configHash {
+		t.Errorf("recreated pod must be annotated with the hash of the new configuration")
+	}
+}
+
+func Test_server_reconcilePod_extraVolumes(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	s := &server{
+		logger: testr.New(t),
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		listenPort:      8080,
+		nameSuffix:      "foo",
+		options: transfer.PodOptions{
+			ExtraVolumes: []corev1.Volume{{
+				Name:         "scratch",
+				VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+			}},
+			ExtraVolumeMounts: []corev1.VolumeMount{{Name: "scratch", MountPath: "/scratch"}},
+		},
+	}
+	if err := s.reconcilePod(context.Background(), fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcilePod() error = %v", err)
+	}
+	pod := &corev1.Pod{}
+	err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}, pod)
+	if err != nil {
+		t.Fatalf("unable to get pod %v", err)
+	}
+	hasVolume := false
+	for _, volume := range pod.Spec.Volumes {
+		hasVolume = hasVolume || volume.Name == "scratch"
+	}
+	if !hasVolume {
+		t.Errorf("pod volumes %v do not have the extra volume", pod.Spec.Volumes)
+	}
+	for _, container := range pod.Spec.Containers {
+		mounted := false
+		for _, mount := range container.VolumeMounts {
+			mounted = mounted || (mount.Name == "scratch" && mount.MountPath == "/scratch")
+		}
+		if mounted != (container.Name == RsyncContainer) {
+			t.Errorf("container %s mounts the extra volume = %v, want %v", container.Name, mounted, container.Name == RsyncContainer)
+		}
+	}
+
+	// extra volumes named after the volumes of the transfer are rejected
+	s.options.ExtraVolumes[0].Name = "fakeVolume"
+	s.nameSuffix = "bar"
+	if err := s.reconcilePod(context.Background(), fakeClient, "foo"); !errors.Is(err, transfer.ErrExtraVolumesInvalid) {
+		t.Errorf("reconcilePod() error = %v, want ErrExtraVolumesInvalid", err)
+	}
+}
+
+func Test_serverTerminatesOnCompletion(t *testing.T) {
+	enabled, disabled := true, false
+	tests := []struct {
+		name       string
+		podOptions transfer.PodOptions
+		options    Options
+		want       bool
+	}{
+		{name: "no option, must terminate by default", want: true},
+		{name: "fan-out server, must not terminate by default", options: Options{FanOutClients: []string{"a"}}, want: false},
+		{name: "server option disabled, must not terminate", options: Options{TerminateOnCompletion: &disabled}, want: false},
+		{name: "pod option disabled, must not terminate", podOptions: transfer.PodOptions{TerminateOnCompletion: &disabled}, want: false},
+		{
+			name:       "server option enabled over disabled pod option, must terminate",
+			podOptions: transfer.PodOptions{TerminateOnCompletion: &disabled},
+			options:    Options{TerminateOnCompletion: &enabled},
+			want:       true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := serverTerminatesOnCompletion(tt.podOptions, tt.options); got != tt.want {
+				t.Errorf("serverTerminatesOnCompletion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_server_getContainers_terminateOnCompletion(t *testing.T) {
+	for _, terminate := range []bool{true, false} {
+		s := &server{
+			pvcList:               transfer.NewSingletonPVC(testPVC("foo", "data")),
+			mode:                  ModeDaemon,
+			listenPort:            8080,
+			transportServer:       &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+			terminateOnCompletion: terminate,
+		}
+		script := s.getContainers(nil)[0].Command[2]
+		if got := strings.Contains(script, "[[ -f /mnt/termination/done-data ]]"); got != terminate {
+			t.Errorf("server waits for the termination file = %v, want %v, script %q", got, terminate, script)
+		}
+		if !strings.HasPrefix(script, "/usr/bin/rsync --daemon --port=8080 --no-detach -vvv") {
+			t.Errorf("server script does not start the rsync daemon, script %q", script)
+		}
+	}
+}
+
+func Test_server_IsHealthy_sidecar(t *testing.T) {
+	ctx := context.Background()
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync-server-foo", Namespace: "foo"},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: RsyncContainer, Ready: true},
+				{Name: "fakeTransportServerContainer", Ready: true},
+				{Name: "istio-proxy", Ready: false},
+			},
+		},
+	}
+	s := &server{
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		nameSuffix:      "foo",
+		transportServer: &fakeTransportServer{},
+	}
+	if got := s.Containers(); !reflect.DeepEqual(got, []string{RsyncContainer, "fakeTransportServerContainer"}) {
+		t.Errorf("Containers() = %v, want the rsync and transport containers", got)
+	}
+	healthy, err := s.IsHealthy(ctx, fakeClientWithObjects(pod))
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v, want healthy regardless of the sidecar", healthy, err)
+	}
+}
+
+func Test_server_Pods(t *testing.T) {
+	ctx := context.Background()
+	pvcList, err := transfer.NewPVCList(testPVC("foo", "data"), testPVC("foo", "logs"))
+	if err != nil {
+		t.Fatalf("unable to create pvc list %v", err)
+	}
+	s := &server{pvcList: pvcList, nameSuffix: "foo", namespace: "foo"}
+
+	pods, err := s.Pods(ctx, fakeClientWithObjects())
+	if err != nil || len(pods) != 0 {
+		t.Errorf("Pods() = %v, %v, want no pod before the server pod is created", pods, err)
+	}
+
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "rsync-server-foo", Namespace: "foo"}}
+	pods, err = s.Pods(ctx, fakeClientWithObjects(pod))
+	podKey := types.NamespacedName{Namespace: "foo", Name: "rsync-server-foo"}
+	want := map[types.NamespacedName]ctrlclient.ObjectKey{
+		{Namespace: "foo", Name: "data"}: podKey,
+		{Namespace: "foo", Name: "logs"}: podKey,
+	}
+	if err != nil || !reflect.DeepEqual(pods, want) {
+		t.Errorf("Pods() = %v, %v, want %v", pods, err, want)
+	}
+}
@@ -0,0 +1,260 @@
+package rsync
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Mode is how rsync clients and servers talk to each other
+type Mode string
+
+const (
+	// ModeDaemon runs an rsync daemon in the server, PVCs are exposed as rsync modules
+	ModeDaemon Mode = "daemon"
+	// ModeSSH runs sshd in the server, clients run rsync over ssh authenticated with a
+	// key pair generated for the transfer
+	ModeSSH Mode = "ssh"
+)
+
+var (
+	// ErrSSHCredentialsMissing is returned when a client in ModeSSH is not given SSH credentials
+	ErrSSHCredentialsMissing = errors.New("ssh credentials missing")
+	// ErrModeNotSupported is returned for unknown rsync modes
+	ErrModeNotSupported = errors.New("rsync mode not supported")
+)
+
+// Options configure how rsync clients and servers talk to each other
+type Options struct {
+	// Mode defaults to ModeDaemon
+	Mode Mode
+	// SSHCredentials is the secret holding the client keys in ModeSSH, it is only used by
+	// clients and must hold the data of the secret returned by SSHCredentials of the server
+	SSHCredentials types.NamespacedName
+	// FanOutClients shares the PVCs of a server with the named clients, which pull them
+	// concurrently from their own read-only rsync modules. The PVCs must be ReadWriteMany or
+	// ReadOnlyMany, the password of each client is in the secret returned by FanOutCredentials
+	// of the server.
+	FanOutClients []string
+	// FanOutClient is the name of a client in the FanOutClients of the server, the client pulls
+	// the PVCs of the server into its PVCs instead of pushing its PVCs
+	FanOutClient string
+	// FanOutCredentials is the secret holding the password of FanOutClient, it must hold the data
+	// of the secret returned by FanOutCredentials of the server
+	FanOutCredentials types.NamespacedName
+	// Pull reverses the direction of the transfer, the server exposes its PVCs as read-only rsync
+	// modules and the client pulls them into its PVCs. It is used when only the cluster of the
+	// destination can open connections to the other cluster, the server then runs on the source.
+	// Server and client must both set it.
+	Pull bool
+	// Agent runs the rsync containers with the rsync-agent binary of the rsync image instead
+	// of bash scripts, the image must ship it at agent.Path
+	Agent bool
+	// SuccessExitCodes are the exit codes of rsync treated as a successful transfer, defaults
+	// to DefaultSuccessExitCodes. Statuses report the other success exit codes than 0 as
+	// warnings. It is only used by clients.
+	SuccessExitCodes []int32
+	// IntegrityManifest makes the client send a sha256 manifest of the files of each PVC to the
+	// server once rsync succeeded, in the ManifestFile of the PVC, and lets the server verify
+	// it, see VerifyIntegrity of the server. Files changing on the source during the transfer
+	// fail the verification. Server and client must both set it, and the server must
+	// terminate on completion, see TerminateOnCompletion.
+	IntegrityManifest bool
+	// Streams is the number of rsync processes transferring each PVC in parallel, the top-level
+	// entries of the PVC are partitioned in as many shards. It saturates links a single rsync
+	// cannot, e.g. high-bandwidth links with a high latency, and defaults to one. It is only used
+	// by clients in ModeDaemon, and cannot be used with Pull, fan-out or Agent.
+	Streams int
+	// ImageProfile describes the rsync of the images of the client and the server, both must
+	// support the command options of the client. It defaults to DefaultImageProfile and is
+	// only used by clients.
+	ImageProfile *ImageProfile
+	// DestinationCapacity is the capacity of the PVCs receiving the data, e.g. transfer.Capacity
+	// of the destination PVCs. Clients with PodOptions.PreScan do not start the transfer when
+	// the estimate of the source exceeds it, NewClient returns an error wrapping
+	// transfer.ErrDestinationFull until the destination PVCs were expanded and the capacity
+	// updated. It is only used by clients.
+	DestinationCapacity *resource.Quantity
+	// TerminateOnCompletion stops the rsync container of the server once the client uploaded
+	// the termination file at the end of the transfer, the server is then Completed. It
+	// defaults to true, or to false for servers with FanOutClients which keep serving the other
+	// clients, and takes precedence over transfer.PodOptions.TerminateOnCompletion. It is only
+	// used by servers.
+	TerminateOnCompletion *bool
+}
+
+func getMode(options Options) (Mode, error) {
+	switch options.Mode {
+	case "", ModeDaemon:
+		return ModeDaemon, nil
+	case ModeSSH:
+		return ModeSSH, nil
+	default:
+		return "", fmt.Errorf("%w: %s", ErrModeNotSupported, options.Mode)
+	}
+}
+
+const (
+	rsyncSSHSecret    = "rsync-ssh"
+	sshKeysMountPath  = "/etc/rsync-ssh"
+	sshDataMountPath  = "/data"
+	sshHostKey        = "host.key"
+	sshClientKey      = "client.key"
+	sshAuthorizedKeys = "authorized_keys"
+	sshKnownHosts     = "known_hosts"
+)
+
+const sshdConfTemplate = `Port {{ .Port }}
+{{- if .AllowLocalhostOnly }}
+ListenAddress 127.0.0.1
+{{- end }}
+HostKey {{ .KeysPath }}/host.key
+AuthorizedKeysFile {{ .KeysPath }}/authorized_keys
+PidFile /tmp/sshd.pid
+PermitRootLogin prohibit-password
+PasswordAuthentication no
+ChallengeResponseAuthentication no
+StrictModes no
+UsePAM no
+AllowTcpForwarding no
+AllowAgentForwarding no
+X11Forwarding no
+PermitTunnel no
+LogLevel INFO
+`
+
+type sshdConfigData struct {
+	Port               int32
+	AllowLocalhostOnly bool
+	KeysPath           string
+}
+
+// generateSSHKey returns an ECDSA key in PEM format and its public key in authorized_keys format
+func generateSSHKey() (private []byte, public string, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, "", err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, "", err
+	}
+	private = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+
+	var wire bytes.Buffer
+	for _, field := range [][]byte{
+		[]byte("ecdsa-sha2-nistp256"),
+		[]byte("nistp256"),
+		elliptic.Marshal(elliptic.P256(), key.X, key.Y),
+	} {
+		_ = binary.Write(&wire, binary.BigEndian, uint32(len(field)))
+		wire.Write(field)
+	}
+	return private, "ecdsa-sha2-nistp256 " + b64.StdEncoding.EncodeToString(wire.Bytes()), nil
+}
+
+// reconcileSSHSecret creates the host and client keys of a server in ModeSSH, existing keys are kept
+func reconcileSSHSecret(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	secretRef types.NamespacedName,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	options transfer.PodOptions) error {
+	existing := &corev1.Secret{}
+	err := c.Get(ctx, secretRef, existing)
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		return err
+	default:
+		valid := true
+		for _, key := range []string{sshHostKey, sshClientKey, sshAuthorizedKeys, sshKnownHosts} {
+			if len(existing.Data[key]) == 0 {
+				valid = false
+			}
+		}
+		if valid {
+			return nil
+		}
+	}
+
+	logger.Info("generating ssh keys", "secret", secretRef)
+	hostKey, hostPublicKey, err := generateSSHKey()
+	if err != nil {
+		return err
+	}
+	clientKey, clientPublicKey, err := generateSSHKey()
+	if err != nil {
+		return err
+	}
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: secretRef.Namespace,
+			Name:      secretRef.Name,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, secret, reconcileOptions(options), func() error {
+		secret.Labels = getLabels(labels, options)
+		secret.Annotations = getAnnotations(secret.Annotations, options)
+		secret.OwnerReferences = ownerRefs
+		secret.Data = map[string][]byte{
+			sshHostKey:        hostKey,
+			sshClientKey:      clientKey,
+			sshAuthorizedKeys: []byte(clientPublicKey + "\n"),
+			// clients reach the server through the transport, the host key is pinned for any hostname
+			sshKnownHosts: []byte("* " + hostPublicKey + "\n"),
+		}
+		return nil
+	})
+	return err
+}
+
+// getSSHKeysVolume returns the volume of the given ssh keys, ssh refuses keys readable by others
+func getSSHKeysVolume(secretName string, keys ...string) corev1.Volume {
+	mode := int32(0400)
+	items := []corev1.KeyToPath{}
+	for _, key := range keys {
+		items = append(items, corev1.KeyToPath{Key: key, Path: key})
+	}
+	return corev1.Volume{
+		Name: rsyncSSHSecret,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  secretName,
+				Items:       items,
+				DefaultMode: &mode,
+			},
+		},
+	}
+}
+
+func getSSHKeysVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      rsyncSSHSecret,
+		MountPath: sshKeysMountPath,
+	}
+}
+
+// getSSHCommand returns the ssh command used by rsync clients to reach the server on port
+func getSSHCommand(port int32) string {
+	return fmt.Sprintf("ssh -i %s/%s -p %d -o UserKnownHostsFile=%s/%s -o StrictHostKeyChecking=yes -o BatchMode=yes",
+		sshKeysMountPath, sshClientKey, port, sshKeysMountPath, sshKnownHosts)
+}
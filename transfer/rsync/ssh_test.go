@@ -0,0 +1,168 @@
+package rsync
+
+import (
+	"context"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func Test_generateSSHKey(t *testing.T) {
+	private, public, err := generateSSHKey()
+	if err != nil {
+		t.Fatalf("generateSSHKey() error = %v", err)
+	}
+	block, _ := pem.Decode(private)
+	if block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Fatalf("generateSSHKey() private key is not an EC PEM block")
+	}
+	if _, err := x509.ParseECPrivateKey(block.Bytes); err != nil {
+		t.Errorf("unable to parse private key %v", err)
+	}
+	fields := strings.Fields(public)
+	if len(fields) != 2 || fields[0] != "ecdsa-sha2-nistp256" {
+		t.Fatalf("generateSSHKey() public key = %s, want an ecdsa-sha2-nistp256 key", public)
+	}
+	if _, err := b64.StdEncoding.DecodeString(fields[1]); err != nil {
+		t.Errorf("unable to decode public key %v", err)
+	}
+}
+
+func Test_reconcileSSHSecret(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: rsyncSSHSecret + "-foo"}
+	validData := map[string][]byte{
+		sshHostKey:        []byte("host"),
+		sshClientKey:      []byte("client"),
+		sshAuthorizedKeys: []byte("authorized"),
+		sshKnownHosts:     []byte("known"),
+	}
+	tests := []struct {
+		name         string
+		objects      []ctrlclient.Object
+		wantReusable bool
+	}{
+		{
+			name: "no secret, must generate keys",
+		},
+		{
+			name: "secret with missing keys, must regenerate keys",
+			objects: []ctrlclient.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: secretRef.Namespace, Name: secretRef.Name},
+				Data:       map[string][]byte{sshHostKey: []byte("host")},
+			}},
+		},
+		{
+			name: "valid secret, must keep existing keys",
+			objects: []ctrlclient.Object{&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: secretRef.Namespace, Name: secretRef.Name},
+				Data:       validData,
+			}},
+			wantReusable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects(tt.objects...)
+			err := reconcileSSHSecret(context.Background(), fakeClient, testr.New(t),
+				secretRef, map[string]string{"test": "me"}, testOwnerReferences(), transfer.PodOptions{})
+			if err != nil {
+				t.Fatalf("reconcileSSHSecret() error = %v", err)
+			}
+			secret := &corev1.Secret{}
+			err = fakeClient.Get(context.Background(), secretRef, secret)
+			if err != nil {
+				t.Fatalf("unable to get ssh secret %v", err)
+			}
+			if tt.wantReusable {
+				if string(secret.Data[sshHostKey]) != "host" {
+					t.Error("reconcileSSHSecret() replaced valid keys")
+				}
+				return
+			}
+			if !strings.HasPrefix(string(secret.Data[sshKnownHosts]), "* ecdsa-sha2-nistp256 ") {
+				t.Errorf("known_hosts = %s, want the host key for any hostname", secret.Data[sshKnownHosts])
+			}
+			if !strings.HasPrefix(string(secret.Data[sshAuthorizedKeys]), "ecdsa-sha2-nistp256 ") {
+				t.Errorf("authorized_keys = %s, want the client public key", secret.Data[sshAuthorizedKeys])
+			}
+		})
+	}
+}
+
+func Test_server_reconcileConfigMap_ssh(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	s := &server{
+		logger:     testr.New(t),
+		nameSuffix: "foo",
+		mode:       ModeSSH,
+		pvcList: transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		transportServer: &fakeTransportServer{transportType: stunnel.TransportTypeStunnel},
+		namespace:       "foo",
+	}
+	if err := s.reconcileConfigMap(context.Background(), fakeClient, "foo"); err != nil {
+		t.Fatalf("reconcileConfigMap() error = %v", err)
+	}
+	cm := &corev1.ConfigMap{}
+	err := fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "foo", Name: rsyncConfig + "-foo"}, cm)
+	if err != nil {
+		t.Fatalf("unable to get configmap %v", err)
+	}
+	config, ok := cm.Data["sshd_config"]
+	if !ok {
+		t.Fatalf("configmap data = %v, want sshd_config", cm.Data)
+	}
+	for _, want := range []string{"ListenAddress 127.0.0.1", "PasswordAuthentication no", sshKeysMountPath + "/authorized_keys"} {
+		if !strings.Contains(config, want) {
+			t.Errorf("sshd_config does not contain %q", want)
+		}
+	}
+}
+
+func Test_client_getCommand_ssh(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tc := &client{
+		username:        "root",
+		mode:            ModeSSH,
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+	}
+	script := tc.getCommand([]string{"-a"}, pvc)[2]
+	if strings.Contains(script, "rsync://") {
+		t.Error("rsync command in ssh mode uses the rsync daemon protocol")
+	}
+	for _, want := range []string{
+		"-e \"ssh -i " + sshKeysMountPath + "/" + sshClientKey,
+		"root@foo.bar.dev:" + sshDataMountPath + "/" + pvc.LabelSafeName() + "/",
+		"root@foo.bar.dev touch /mnt/termination/done",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("rsync script does not contain %q", want)
+		}
+	}
+}
+
+func TestNewClientWithOptions_sshCredentialsMissing(t *testing.T) {
+	_, err := NewClientWithOptions(context.Background(), fakeClientWithObjects(),
+		transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+		}),
+		&fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+		testr.New(t), "foo", nil, nil, transfer.PodOptions{}, Options{Mode: ModeSSH})
+	if !errors.Is(err, ErrSSHCredentialsMissing) {
+		t.Errorf("NewClientWithOptions() error = %v, want %v", err, ErrSSHCredentialsMissing)
+	}
+}
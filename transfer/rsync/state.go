@@ -0,0 +1,92 @@
+package rsync
+
+import (
+	"context"
+	"time"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// pausedAtKey and cancelledAtKey are set in the state of paused and cancelled transfers
+	pausedAtKey    = "pausedAt"
+	cancelledAtKey = "cancelledAt"
+)
+
+// getState returns the data of the state configmap, the state outlives the transfer pods
+// so that pauses and cancellations survive their deletion. It is empty when the configmap
+// does not exist.
+func getState(ctx context.Context, c ctrlclient.Client, key types.NamespacedName) (map[string]string, error) {
+	state := &corev1.ConfigMap{}
+	err := c.Get(ctx, key, state)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return map[string]string{}, nil
+	case err != nil:
+		return nil, err
+	}
+	if state.Data == nil {
+		return map[string]string{}, nil
+	}
+	return state.Data, nil
+}
+
+// updateState reconciles the state configmap with the data mutated by f
+func updateState(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	key types.NamespacedName,
+	labels map[string]string,
+	ownerRefs []metav1.OwnerReference,
+	options transfer.PodOptions,
+	f func(data map[string]string)) error {
+	existing, err := getState(ctx, c, key)
+	if err != nil {
+		return err
+	}
+	data := map[string]string{}
+	for k, v := range existing {
+		data[k] = v
+	}
+	f(data)
+
+	state := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      key.Name,
+			Namespace: key.Namespace,
+		},
+	}
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, state, reconcileOptions(options), func() error {
+		state.Labels = getLabels(labels, options)
+		state.OwnerReferences = ownerRefs
+		state.Data = data
+		return nil
+	})
+	return err
+}
+
+// setStateTime sets key to the current time unless it is already set
+func setStateTime(data map[string]string, key string) {
+	if data[key] == "" {
+		data[key] = time.Now().UTC().Format(time.RFC3339)
+	}
+}
+
+// getStateTime returns the time set in key, or nil when it is not set
+func getStateTime(data map[string]string, key string) *metav1.Time {
+	value, ok := data[key]
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		// the time is informative, the state is set regardless
+		return &metav1.Time{}
+	}
+	return &metav1.Time{Time: t}
+}
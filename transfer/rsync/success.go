@@ -0,0 +1,91 @@
+package rsync
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	"github.com/backube/pvc-transfer/transfer/rsync/exitcode"
+)
+
+var (
+	// ErrSuccessExitCodesInvalid is returned when the SuccessExitCodes of the options are not
+	// rsync exit codes
+	ErrSuccessExitCodesInvalid = errors.New("success exit codes invalid")
+)
+
+// rsyncExitCodeField is the field of the termination message of the rsync container holding
+// the exit code of the last rsync attempt, the container exits successfully when it is one of
+// the success exit codes
+const rsyncExitCodeField = agent.ExitCodeField
+
+// DefaultSuccessExitCodes are the exit codes of rsync treated as a successful transfer when
+// the options do not set SuccessExitCodes. Source files routinely vanish during the first
+// passes over live workloads, exit code 24 is reported as a warning in the status.
+func DefaultSuccessExitCodes() []int32 {
+	return []int32{0, 24}
+}
+
+// validateSuccessExitCodes returns an error when the success exit codes of the options are
+// out of the range of exit codes
+func validateSuccessExitCodes(options Options) error {
+	for _, code := range options.SuccessExitCodes {
+		if code < 0 || code > 255 {
+			return fmt.Errorf("%w: exit code %d out of range 0-255", ErrSuccessExitCodesInvalid, code)
+		}
+	}
+	return nil
+}
+
+// getSuccessExitCodes returns the exit codes of rsync treated as a successful transfer, 0 is
+// always successful
+func getSuccessExitCodes(codes []int32) []int32 {
+	if len(codes) == 0 {
+		return DefaultSuccessExitCodes()
+	}
+	for _, code := range codes {
+		if code == 0 {
+			return codes
+		}
+	}
+	return append([]int32{0}, codes...)
+}
+
+// getWarning returns the warning of a successful rsync container whose last rsync attempt
+// exited with a success exit code other than 0, see rsyncExitCodeField
+func getWarning(fields map[string]string) (exitcode.ExitCode, string) {
+	rc, err := strconv.ParseInt(fields[rsyncExitCodeField], 10, 32)
+	if err != nil || rc == 0 {
+		return 0, ""
+	}
+	code := exitcode.ExitCode(rc)
+	return code, fmt.Sprintf("rsync completed with exit code %s", code)
+}
+
+// completedStatus returns the status of a terminated rsync container from its exit code and
+// its termination message
+func completedStatus(containerExitCode int32, message string) *transfer.Completed {
+	rc := exitcode.ExitCode(containerExitCode)
+	completed := &transfer.Completed{
+		Successful: rc.Successful(),
+		Failure:    !rc.Successful(),
+		ExitCode:   containerExitCode,
+		Reason:     string(rc.Reason()),
+		Retryable:  rc.Retryable(),
+	}
+	if rc.Successful() {
+		if warningCode, warning := getWarning(parseTerminationFields(message)); warning != "" {
+			completed.Reason = string(warningCode.Reason())
+			completed.Warning = warning
+		}
+	} else if isDestinationFull(parseTerminationFields(message)) {
+		completed.Reason = string(exitcode.ReasonDestinationFull)
+		completed.Retryable = false
+		completed.Err = fmt.Errorf("%w: rsync exited with %s", transfer.ErrDestinationFull, rc)
+	}
+	completed.FilesTransferred, completed.BytesTransferred = parseTerminationMessage(message)
+	completed.Stats = parseStats(message)
+	return completed
+}
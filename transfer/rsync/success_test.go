@@ -0,0 +1,120 @@
+package rsync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+)
+
+func Test_getSuccessExitCodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		codes []int32
+		want  []int32
+	}{
+		{name: "no codes, must return the default codes", want: []int32{0, 24}},
+		{name: "codes with 0, must return the codes", codes: []int32{0, 23}, want: []int32{0, 23}},
+		{name: "codes without 0, must add 0", codes: []int32{23, 24}, want: []int32{0, 23, 24}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getSuccessExitCodes(tt.codes); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getSuccessExitCodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_validateSuccessExitCodes(t *testing.T) {
+	tests := []struct {
+		name    string
+		codes   []int32
+		wantErr bool
+	}{
+		{name: "no codes, must be valid"},
+		{name: "rsync exit codes, must be valid", codes: []int32{0, 24, 255}},
+		{name: "negative exit code, must be invalid", codes: []int32{-1}, wantErr: true},
+		{name: "exit code out of range, must be invalid", codes: []int32{256}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSuccessExitCodes(Options{SuccessExitCodes: tt.codes})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateSuccessExitCodes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrSuccessExitCodesInvalid) {
+				t.Errorf("validateSuccessExitCodes() error = %v, want ErrSuccessExitCodesInvalid", err)
+			}
+		})
+	}
+}
+
+func Test_completedStatus(t *testing.T) {
+	tests := []struct {
+		name              string
+		containerExitCode int32
+		message           string
+		wantSuccessful    bool
+		wantReason        string
+		wantWarning       string
+		wantFiles         int64
+		wantErr           error
+	}{
+		{
+			name:           "rsync succeeded, must be successful without warning",
+			message:        "files=3 bytes=1024\nrsync_exit_code=0\n",
+			wantSuccessful: true,
+			wantReason:     "Success",
+			wantFiles:      3,
+		},
+		{
+			name:           "rsync exited with vanished files, must be successful with a warning",
+			message:        "files=3 bytes=1024\nrsync_exit_code=24\n",
+			wantSuccessful: true,
+			wantReason:     "VanishedFiles",
+			wantWarning:    "rsync completed with exit code 24 (partial transfer due to vanished source files)",
+			wantFiles:      3,
+		},
+		{
+			name:           "termination message without exit code, must be successful without warning",
+			message:        "files=3 bytes=1024\n",
+			wantSuccessful: true,
+			wantReason:     "Success",
+			wantFiles:      3,
+		},
+		{
+			name:              "rsync failed, must be a failure without warning",
+			containerExitCode: 23,
+			message:           "files=1 bytes=10\nrsync_exit_code=23\n",
+			wantReason:        "PartialTransfer",
+			wantFiles:         1,
+		},
+		{
+			name:              "rsync failed on a full destination, must be a failure wrapping ErrDestinationFull",
+			containerExitCode: 11,
+			message:           "files=1 bytes=10\nrsync_exit_code=11\ndestination_full=true\n",
+			wantReason:        "DestinationFull",
+			wantFiles:         1,
+			wantErr:           transfer.ErrDestinationFull,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := completedStatus(tt.containerExitCode, tt.message)
+			if got.Successful != tt.wantSuccessful || got.Failure == tt.wantSuccessful {
+				t.Errorf("completedStatus() successful = %v, failure = %v, want successful %v", got.Successful, got.Failure, tt.wantSuccessful)
+			}
+			if got.Reason != tt.wantReason || got.Warning != tt.wantWarning {
+				t.Errorf("completedStatus() reason = %q, warning = %q, want %q, %q", got.Reason, got.Warning, tt.wantReason, tt.wantWarning)
+			}
+			if got.FilesTransferred != tt.wantFiles {
+				t.Errorf("completedStatus() files = %d, want %d", got.FilesTransferred, tt.wantFiles)
+			}
+			if !errors.Is(got.Err, tt.wantErr) || (got.Err == nil) != (tt.wantErr == nil) {
+				t.Errorf("completedStatus() err = %v, want %v", got.Err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package rsync
+
+import (
+	"fmt"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// windowsDrivePath is where the cygwin rsync of Windows images sees the C: drive, volumes
+// mounted at Unix-style paths are mounted on the C: drive of Windows containers
+const windowsDrivePath = "/cygdrive/c"
+
+// validateOS returns an error wrapping ErrOSNotSupported when rsync pods cannot run on the
+// operating system of the pod options. Windows pods do not have bash, they require the agent
+// and an rsync image built for Windows.
+func validateOS(podOptions transfer.PodOptions, options Options) error {
+	switch podOptions.OS {
+	case "", transfer.OSLinux:
+		return nil
+	case transfer.OSWindows:
+		switch {
+		case !options.Agent:
+			return fmt.Errorf("%w: %s requires the rsync agent", transfer.ErrOSNotSupported, podOptions.OS)
+		case options.Mode == ModeSSH:
+			return fmt.Errorf("%w: %s does not support rsync mode %s", transfer.ErrOSNotSupported, podOptions.OS, ModeSSH)
+		case podOptions.RsyncImage == "":
+			return fmt.Errorf("%w: %s requires an rsync image built for Windows", transfer.ErrOSNotSupported, podOptions.OS)
+		case podOptions.SELinuxOptions != nil:
+			return fmt.Errorf("%w: %s does not support SELinux options", transfer.ErrOSNotSupported, podOptions.OS)
+		case podOptions.PreScan:
+			return fmt.Errorf("%w: %s does not support pre-scans", transfer.ErrOSNotSupported, podOptions.OS)
+		case podOptions.IOThrottle != nil && podOptions.IOThrottle.IdlePriority:
+			return fmt.Errorf("%w: %s does not support IO priorities", transfer.ErrOSNotSupported, podOptions.OS)
+		}
+		return nil
+	default:
+		return fmt.Errorf("%w: %s", transfer.ErrOSNotSupported, podOptions.OS)
+	}
+}
+
+// getNodeSelector returns the node selector of the pod options with the operating system and
+// architecture labels of the pod options, the node selector of the options is not modified
+func getNodeSelector(options transfer.PodOptions) map[string]string {
+	if options.OS == "" && (options.Architecture == "" || options.Architecture == transfer.ArchitectureAuto) {
+		return options.NodeSelector
+	}
+	nodeSelector := map[string]string{}
+	for k, v := range options.NodeSelector {
+		nodeSelector[k] = v
+	}
+	if options.OS != "" {
+		nodeSelector[corev1.LabelOSStable] = string(options.OS)
+	}
+	if options.Architecture != "" && options.Architecture != transfer.ArchitectureAuto {
+		nodeSelector[corev1.LabelArchStable] = options.Architecture
+	}
+	return nodeSelector
+}
+
+// getLocalPath returns the path rsync uses for a file mounted at path in rsync containers
+func getLocalPath(options transfer.PodOptions, path string) string {
+	if options.OS == transfer.OSWindows {
+		return windowsDrivePath + path
+	}
+	return path
+}
+
+// getRsyncBinary returns the rsync command of rsync containers
+func getRsyncBinary(options transfer.PodOptions) string {
+	if options.OS == transfer.OSWindows {
+		return "rsync.exe"
+	}
+	return "/usr/bin/rsync"
+}
+
+// getAgentPath returns the path of the rsync agent in rsync containers
+func getAgentPath(options transfer.PodOptions) string {
+	if options.OS == transfer.OSWindows {
+		return agent.WindowsPath
+	}
+	return agent.Path
+}
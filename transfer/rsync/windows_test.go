@@ -0,0 +1,125 @@
+package rsync
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transfer"
+	"github.com/backube/pvc-transfer/transfer/rsync/agent"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_validateOS(t *testing.T) {
+	windowsOptions := transfer.PodOptions{OS: transfer.OSWindows, RsyncImage: "quay.io/test/rsync:windows"}
+	tests := []struct {
+		name       string
+		podOptions transfer.PodOptions
+		options    Options
+		wantErr    bool
+	}{
+		{
+			name:       "no OS, must be valid",
+			podOptions: transfer.PodOptions{},
+		},
+		{
+			name:       "linux, must be valid",
+			podOptions: transfer.PodOptions{OS: transfer.OSLinux},
+		},
+		{
+			name:       "windows with the agent and a windows image, must be valid",
+			podOptions: windowsOptions,
+			options:    Options{Agent: true},
+		},
+		{
+			name:       "windows without the agent, must return an error",
+			podOptions: windowsOptions,
+			wantErr:    true,
+		},
+		{
+			name:       "windows in ssh mode, must return an error",
+			podOptions: windowsOptions,
+			options:    Options{Agent: true, Mode: ModeSSH},
+			wantErr:    true,
+		},
+		{
+			name:       "windows without a windows image, must return an error",
+			podOptions: transfer.PodOptions{OS: transfer.OSWindows},
+			options:    Options{Agent: true},
+			wantErr:    true,
+		},
+		{
+			name:       "windows with an idle IO priority, must return an error",
+			podOptions: transfer.PodOptions{OS: transfer.OSWindows, RsyncImage: "quay.io/test/rsync:windows", IOThrottle: &transfer.IOThrottle{IdlePriority: true}},
+			options:    Options{Agent: true},
+			wantErr:    true,
+		},
+		{
+			name:       "unknown OS, must return an error",
+			podOptions: transfer.PodOptions{OS: "plan9"},
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateOS(tt.podOptions, tt.options)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateOS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, transfer.ErrOSNotSupported) {
+				t.Errorf("validateOS() error = %v, want ErrOSNotSupported", err)
+			}
+		})
+	}
+}
+
+func Test_getNodeSelector(t *testing.T) {
+	nodeSelector := map[string]string{"zone": "a"}
+	got := getNodeSelector(transfer.PodOptions{OS: transfer.OSWindows, NodeSelector: nodeSelector})
+	want := map[string]string{"zone": "a", corev1.LabelOSStable: "windows"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getNodeSelector() = %v, want %v", got, want)
+	}
+	if _, ok := nodeSelector[corev1.LabelOSStable]; ok {
+		t.Error("getNodeSelector() modified the node selector of the pod options")
+	}
+	if got := getNodeSelector(transfer.PodOptions{NodeSelector: nodeSelector}); !reflect.DeepEqual(got, nodeSelector) {
+		t.Errorf("getNodeSelector() = %v, want %v", got, nodeSelector)
+	}
+	got = getNodeSelector(transfer.PodOptions{Architecture: "arm64"})
+	want = map[string]string{corev1.LabelArchStable: "arm64"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("getNodeSelector() = %v, want %v", got, want)
+	}
+	if got := getNodeSelector(transfer.PodOptions{Architecture: transfer.ArchitectureAuto}); got != nil {
+		t.Errorf("getNodeSelector() = %v, want no node selector for an unresolved architecture", got)
+	}
+}
+
+func Test_client_getAgentCommand_windows(t *testing.T) {
+	pvc := transfer.NewSingletonPVC(&corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+	}).PVCs()[0]
+	tc := &client{
+		username:        "root",
+		agent:           true,
+		transportClient: &fakeTransportClient{transportType: stunnel.TransportTypeStunnel},
+		options:         transfer.PodOptions{OS: transfer.OSWindows},
+	}
+	command, env, err := tc.getAgentCommand([]string{"-a"}, pvc)
+	if err != nil {
+		t.Fatalf("getAgentCommand() error = %v", err)
+	}
+	if !reflect.DeepEqual(command, []string{agent.WindowsPath, agent.RoleClient}) {
+		t.Errorf("getAgentCommand() command = %v", command)
+	}
+	config := agent.ClientConfig{}
+	decodeAgentEnv(t, env, &config)
+	wantCommand := []string{"rsync.exe", "-a", windowsDrivePath + "/mnt/foo/" + pvc.LabelSafeName() + "/",
+		"rsync://root@foo.bar.dev/" + pvc.LabelSafeName() + "/", "--port", "8080"}
+	if !reflect.DeepEqual(config.Command, wantCommand) {
+		t.Errorf("agent rsync command = %v, want %v", config.Command, wantCommand)
+	}
+}
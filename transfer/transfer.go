@@ -2,17 +2,95 @@ package transfer
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/transport"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+var (
+	// ErrPVCsMultipleNamespaces is returned when a transfer which only supports a single
+	// namespace is given a PVCList spanning multiple namespaces
+	ErrPVCsMultipleNamespaces = errors.New("PVC list provided has pvcs in different namespaces which is not supported")
+	// ErrPVCListEmpty is returned when a transfer is given a PVCList with no PVCs in it
+	ErrPVCListEmpty = errors.New("either PVC list is empty or namespace is not specified")
+	// ErrPodNotReady is returned when a transfer pod exists but its containers are not ready
+	ErrPodNotReady = errors.New("pod not ready")
+	// ErrStatusUnknown is returned when the status of a transfer cannot be determined yet
+	ErrStatusUnknown = errors.New("unable to determine transfer status")
+	// ErrClusterPairInvalid is returned when a client of a cluster pair is not set
+	ErrClusterPairInvalid = errors.New("cluster pair invalid")
+	// ErrServiceMeshModeNotSupported is returned when the service mesh mode of the pod options
+	// cannot be used with the transport of the transfer
+	ErrServiceMeshModeNotSupported = errors.New("service mesh mode not supported")
+	// ErrSCCNotUsable is returned when the OpenShift SCC of the pod options does not exist or
+	// cannot be granted to the service account of the transfer pods
+	ErrSCCNotUsable = errors.New("SCC not usable")
+	// ErrPVCInUse is returned when a PVC to be transferred is mounted by a running pod
+	ErrPVCInUse = errors.New("PVC in use")
+	// ErrTransferIDInvalid is returned when a transfer ID is not a valid label value
+	ErrTransferIDInvalid = errors.New("transfer ID invalid")
+	// ErrResourceConflict is returned when a resource to create already exists and is owned
+	// by another owner, see the Adopt option
+	ErrResourceConflict = reconcile.ErrResourceConflict
+	// ErrOSNotSupported is returned when transfer pods cannot run on the operating system of
+	// the pod options with the other options of the transfer
+	ErrOSNotSupported = errors.New("operating system not supported")
+	// ErrPauseNotSupported is returned when pausing or resuming a transfer whose client
+	// does not implement PausableClient
+	ErrPauseNotSupported = errors.New("pause not supported")
+)
+
+// TransferIDLabel is stamped on all the resources of a transfer with the ID of the transfer,
+// allowing controllers running several transfers to correlate logs and resources
+const TransferIDLabel = "pvc-transfer.backube.dev/transfer-id"
+
+// ValidateTransferID returns an error wrapping ErrTransferIDInvalid when id cannot be used as
+// the value of TransferIDLabel
+func ValidateTransferID(id string) error {
+	if id == "" {
+		return fmt.Errorf("%w: transfer ID is empty", ErrTransferIDInvalid)
+	}
+	if errs := validation.IsValidLabelValue(id); len(errs) > 0 {
+		return fmt.Errorf("%w: %s", ErrTransferIDInvalid, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ClusterPair holds the clients of the clusters on both ends of a transfer. Resources of
+// transfer servers are reconciled with the destination client, resources of transfer clients
+// with the source client. Both clients are the same for transfers within a cluster.
+type ClusterPair struct {
+	Source      client.Client
+	Destination client.Client
+}
+
+// SingleCluster returns the cluster pair of transfers within the cluster of c
+func SingleCluster(c client.Client) ClusterPair {
+	return ClusterPair{Source: c, Destination: c}
+}
+
+// Validate returns an error wrapping ErrClusterPairInvalid when a client is not set
+func (cp ClusterPair) Validate() error {
+	if cp.Source == nil {
+		return fmt.Errorf("%w: source client not set", ErrClusterPairInvalid)
+	}
+	if cp.Destination == nil {
+		return fmt.Errorf("%w: destination client not set", ErrClusterPairInvalid)
+	}
+	return nil
+}
+
 // Transfer knows how to transfer PV data from a source to a destination
 // Server creates an rsync server on the destination
 type Server interface {
@@ -31,6 +109,12 @@ type Server interface {
 	// MarkForCleanup add the required labels to all the resources for
 	// cleaning up
 	MarkForCleanup(ctx context.Context, c client.Client, key, value string) error
+	// Containers returns the names of the containers of the transfer server pods, other
+	// containers such as injected sidecars are ignored by IsHealthy and Completed
+	Containers() []string
+	// Pods returns the keys of the transfer server pods created so far keyed by the PVCs they
+	// receive data for, e.g. to stream their logs. PVCs whose pod is not created are omitted.
+	Pods(ctx context.Context, c client.Client) (map[types.NamespacedName]client.ObjectKey, error)
 }
 
 type Client interface {
@@ -42,12 +126,63 @@ type Client interface {
 	Status(ctx context.Context, c client.Client) (*Status, error)
 	// MarkForCleanup adds a key-value label to all the resources to be cleaned up
 	MarkForCleanup(ctx context.Context, c client.Client, key, value string) error
+	// Containers returns the names of the containers of the transfer client pods, other
+	// containers such as injected sidecars are ignored by Status
+	Containers() []string
+	// Pods returns the keys of the transfer client pods created so far keyed by the PVCs they
+	// send data from, e.g. to stream their logs. PVCs whose pod is not created are omitted.
+	Pods(ctx context.Context, c client.Client) (map[types.NamespacedName]client.ObjectKey, error)
+}
+
+// PausableClient is implemented by transfer clients which can be paused and resumed, e.g. to
+// yield bandwidth to production workloads. Pausing deletes the transfer client pods while the
+// data already transferred is kept, the transfer picks up where it stopped once resumed.
+// Transfers relying on partially transferred files must keep them, e.g. with the Partial
+// rsync command option.
+type PausableClient interface {
+	Client
+	// Pause records the paused state and deletes the transfer client pods
+	Pause(ctx context.Context, c client.Client) error
+	// Resume clears the paused state and recreates the transfer client pods
+	Resume(ctx context.Context, c client.Client) error
+}
+
+// RetriableClient is implemented by transfer clients whose failed transfer can be attempted
+// again once its cause was fixed, e.g. once the destination PVCs of a transfer which failed with
+// ErrDestinationFull were expanded
+type RetriableClient interface {
+	Client
+	// Retry deletes the transfer client pods of the failed attempt, they are recreated by the
+	// next reconcile of the transfer client
+	Retry(ctx context.Context, c client.Client) error
+}
+
+// CancelledLabel is set to "true" on the resources of cancelled transfers, marking them for
+// cleanup
+const CancelledLabel = "pvc-transfer.backube.dev/cancelled"
+
+// Cancellable is implemented by transfer clients and servers which can be cancelled mid-flight.
+// Cancel stops the transfer pods gracefully, their rsync and transport containers receive
+// SIGTERM, and returns false while they are terminating, callers are expected to requeue and
+// call it again. Pods still terminating past their grace period are force deleted by a later
+// call. Once the pods are gone all the resources are marked for cleanup with CancelledLabel.
+type Cancellable interface {
+	Cancel(ctx context.Context, c client.Client) (bool, error)
 }
 
 // PodOptions allow callers to pass custom configuration for the transfer pods
 type PodOptions struct {
 	// users can pass in the SA for transfer pods to use
 	ServiceAccountName string
+	// SCCName is the OpenShift SecurityContextConstraints the transfer pods run with. The service
+	// account of the transfer pods, generated or provided, is granted its use and the pods require it.
+	SCCName string
+	// PodLabels are added to the pods, configmaps and secrets generated for the transfer, on top of
+	// the labels used to select and clean up transfer resources which take precedence
+	PodLabels map[string]string
+	// PodAnnotations are added to the pods, configmaps and secrets generated for the transfer,
+	// e.g. to configure service mesh injection
+	PodAnnotations map[string]string
 	// PodSecurityContext determines what GID the rsync process gets
 	// In case of shared storage SupplementalGroups is configured to get the gid
 	// In case of block storage FSGroup is configured to get the gid
@@ -62,19 +197,150 @@ type PodOptions struct {
 	NodeName string
 	// NodeSelector is a wider net for scheduling the pods on node than NodeName.
 	NodeSelector map[string]string
+	// Tolerations allow scheduling the transfer pods on tainted nodes, e.g. nodes dedicated to migrations
+	Tolerations []corev1.Toleration
+	// Affinity constrains the nodes the transfer pods are scheduled on, e.g. the zone of the PVCs
+	Affinity *corev1.Affinity
+	// TopologySpreadConstraints spread the transfer pods across topology domains
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint
+	// PriorityClassName is the priority class of the transfer pods
+	PriorityClassName string
+	// HostNetwork runs the transfer pods in the network namespace of their node, e.g. when a
+	// direct connect link is only routed on nodes. The ports of the transport are then opened
+	// on the node, transfers with the same ports cannot run on the same node.
+	HostNetwork bool
+	// DNSPolicy is the DNS policy of the transfer pods, it defaults to ClusterFirstWithHostNet
+	// with HostNetwork so that cluster services keep resolving
+	DNSPolicy corev1.DNSPolicy
+	// DNSConfig adds nameservers, search domains and resolver options to the DNS configuration
+	// of the transfer pods, e.g. for hostnames of the other cluster only resolved by its DNS
+	DNSConfig *corev1.PodDNSConfig
 	// Resources allows for configuring the resources consumed by the transfer pods. In general
 	// it is good to provision destination transfer pod with same or larger resources than the source
 	// so that the network is not congested.
 	Resources corev1.ResourceRequirements
-	// Image allows specifying an alternate image for transfers
+	// Image allows specifying an alternate image for transfers.
+	// Deprecated: use RsyncImage
 	Image string
-	// TerminateOnCompletion determines whether transfer containers will terminate after transfer is complete
+	// RsyncImage is the image of the rsync containers, defaults to Image
+	RsyncImage string
+	// RequireImageDigests rejects transfer images which are not pinned to a digest, including
+	// the default images, so that the exact images mirrored by air-gapped clusters are used
+	RequireImageDigests bool
+	// ImagePullPolicy is applied to the transfer containers
+	ImagePullPolicy corev1.PullPolicy
+	// ImagePullSecrets are added to the transfer pods for pulling images from private registries
+	ImagePullSecrets []corev1.LocalObjectReference
+	// TerminateOnCompletion determines whether transfer containers will terminate after transfer is complete,
+	// transfer servers may terminate by default, e.g. see rsync.Options.TerminateOnCompletion
 	TerminateOnCompletion *bool
 	// CommandOptions allow configuring the additional options that are passed to entrypoint commands
 	// of transfer containers.
 	CommandOptions
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate,
+	// see the ServerSideApply field of reconcile.Options
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply, defaults to "pvc-transfer"
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping ErrResourceConflict
+	Adopt bool
+	// SELinuxOptions are applied to every container of the transfer pods, transport containers
+	// included, so that all of them can access the volumes with the same SELinux label
+	SELinuxOptions *corev1.SELinuxOptions
+	// ServiceMesh determines how transfer pods behave in namespaces with automatic sidecar
+	// injection, see ServiceMeshMode
+	ServiceMesh ServiceMeshMode
+	// ReadOnlySource mounts the source PVCs read-only in transfer client pods
+	ReadOnlySource bool
+	// PreScan measures the number of files and bytes in the source PVCs with a short-lived pod
+	// before the transfer starts, transfer statuses report them in their Estimate
+	PreScan bool
+	// OS schedules transfer pods on nodes of the given operating system, pods are scheduled
+	// regardless of the operating system of nodes when empty
+	OS OperatingSystem
+	// Architecture schedules transfer pods on nodes of the given architecture, such as amd64 or
+	// arm64, or on nodes of the architecture of the PVCs of the transfer with ArchitectureAuto.
+	// Images must be multi-arch unless ArchitectureImages has an image for the architecture.
+	Architecture string
+	// ArchitectureImages are the images of transfer containers on nodes of each architecture,
+	// they take precedence over RsyncImage
+	ArchitectureImages map[string]string
+	// RetryPolicy determines how transfer containers retry failed attempts, DefaultRetryPolicy
+	// is used when nil. The default policy of rsync transfers only retries the exit codes
+	// which may not happen again, see the exitcode package.
+	RetryPolicy *RetryPolicy
+	// AllowPVCInUse skips the check for pods other than the transfer pods using the source PVCs,
+	// data written by the application during the transfer may not be synced
+	AllowPVCInUse bool
+	// ExtraVolumes are added to the transfer pods, e.g. custom CA bundles, krb5 configs or
+	// scratch space required by the environment
+	ExtraVolumes []corev1.Volume
+	// ExtraVolumeMounts mount ExtraVolumes in the rsync containers of the transfer pods,
+	// transport containers are left untouched
+	ExtraVolumeMounts []corev1.VolumeMount
+	// Env is added to the environment of the rsync containers, e.g. HTTPS_PROXY. Variables
+	// set by the transfer take precedence.
+	Env []corev1.EnvVar
+	// EnvFrom populates the environment of the rsync containers from ConfigMaps and Secrets
+	EnvFrom []corev1.EnvFromSource
+	// PVCOverrides are merged over the pod options for the transfer pods of a single PVC, e.g.
+	// the rsync client pods, keyed by the namespace/name of the PVC, see PVCOverrideKey
+	PVCOverrides map[string]PodOptionsOverride
+	// IOThrottle limits the IO of the rsync containers of transfer client pods, which read the
+	// source PVCs unless the transfer pulls, so that transfers do not starve the workloads
+	// sharing the storage of the PVCs
+	IOThrottle *IOThrottle
 }
 
+// IOThrottle limits the IO of a container on the storage it shares with other workloads
+type IOThrottle struct {
+	// BlockIOClass is the block IO class of the container, the classes and their limits are
+	// defined in the blockio configuration of the CRI-O or containerd runtime of the nodes
+	BlockIOClass string
+	// IdlePriority runs the container in the idle IO scheduling class with ionice, so that it
+	// only accesses disks when no other process does. It requires ionice in the image and an IO
+	// scheduler of the nodes supporting priorities, such as BFQ.
+	IdlePriority bool
+}
+
+// OperatingSystem is the operating system of the nodes transfer pods are scheduled on
+type OperatingSystem string
+
+const (
+	// OSLinux schedules transfer pods on Linux nodes
+	OSLinux OperatingSystem = "linux"
+	// OSWindows schedules transfer pods on Windows nodes, the images of the transfer and of the
+	// transport must be built for Windows
+	OSWindows OperatingSystem = "windows"
+)
+
+// ServiceMeshMode determines how transfer pods behave in namespaces with automatic sidecar injection
+type ServiceMeshMode string
+
+const (
+	// ServiceMeshModeNone leaves sidecar injection to the namespace configuration
+	ServiceMeshModeNone ServiceMeshMode = ""
+	// ServiceMeshModeDisableInjection opts transfer pods out of sidecar injection with the
+	// Istio and Linkerd labels and annotations, transports keep encrypting traffic themselves
+	ServiceMeshModeDisableInjection ServiceMeshMode = "DisableInjection"
+	// ServiceMeshModeNative lets the mesh inject its sidecar and delegates encryption and
+	// authentication to the mesh mutual TLS, it requires the null transport. The mesh must be
+	// configured to enforce mutual TLS between the namespaces of the transfer.
+	ServiceMeshModeNative ServiceMeshMode = "Native"
+)
+
+// ServiceMeshInjectionLabels and ServiceMeshInjectionAnnotations opt pods out of sidecar injection
+var (
+	ServiceMeshInjectionLabels = map[string]string{
+		"sidecar.istio.io/inject": "false",
+	}
+	ServiceMeshInjectionAnnotations = map[string]string{
+		"sidecar.istio.io/inject": "false",
+		"linkerd.io/inject":       "disabled",
+	}
+)
+
 type CommandOptions interface {
 	Options() ([]string, error)
 }
@@ -82,6 +348,64 @@ type CommandOptions interface {
 type Status struct {
 	Running   *Running
 	Completed *Completed
+	// Paused is set while the transfer is paused, see PausableClient
+	Paused *Paused
+	// Cancelled is set once the transfer was cancelled, see Cancellable
+	Cancelled *Cancelled
+	// Estimate is the size of the data to transfer, it is set once the pre-scan of the
+	// source completed, see PodOptions.PreScan
+	Estimate *Estimate
+	// Integrity is the result of the verification of the data received by the destination, it
+	// is not set by transfer clients but by callers verifying the transfer with an
+	// IntegrityVerifier, such as plans
+	Integrity *Integrity
+	// PVCs is the status of the transfer of each PVC keyed by the namespace and name of the
+	// PVC, see Summary for a roll-up. It is nil when the transfer does not report the status
+	// of each PVC.
+	PVCs map[types.NamespacedName]*PVCStatus
+}
+
+// Integrity is the result of the verification of the data received by a transfer server
+// against a checksum manifest of the source, see IntegrityVerifier
+type Integrity struct {
+	// Verified is set when every file of the manifests of all the PVCs has the checksum it had
+	// on the source
+	Verified bool
+	// FilesVerified is the number of files in the manifests
+	FilesVerified int64
+	// FilesFailed is the number of files missing or with another checksum on the destination
+	FilesFailed int64
+	// Message explains failed verifications, e.g. a manifest which was not received
+	Message string
+	// ConfigMap holds the result of the verification of each PVC, it is kept when the resources
+	// of the transfer are cleaned up as a record of the migration
+	ConfigMap types.NamespacedName
+}
+
+// IntegrityVerifier is implemented by transfer servers verifying the data they received against
+// a checksum manifest generated on the source
+type IntegrityVerifier interface {
+	Server
+	// VerifyIntegrity starts the verification once the server completed and returns its result,
+	// it returns nil until the verification completed. It is idempotent, callers are expected
+	// to call it again until it returns a result.
+	VerifyIntegrity(ctx context.Context, c client.Client) (*Integrity, error)
+}
+
+// Estimate is the size of the data to transfer measured before the transfer starts
+type Estimate struct {
+	TotalFiles int64
+	TotalBytes int64
+}
+
+// Cancelled is the status of a cancelled transfer
+type Cancelled struct {
+	CancelledAt *metav1.Time
+}
+
+// Paused is the status of a paused transfer
+type Paused struct {
+	PausedAt *metav1.Time
 }
 
 type Running struct {
@@ -92,86 +416,215 @@ type Completed struct {
 	Successful bool
 	Failure    bool
 	FinishedAt *metav1.Time
+	// StartedAt is when the transfer container started
+	StartedAt *metav1.Time
+	// ExitCode is the exit code of the transfer container
+	ExitCode int32
+	// Reason is a machine readable interpretation of ExitCode, e.g. VanishedFiles for rsync
+	// transfers, it is empty when the transfer does not interpret its exit codes
+	Reason string
+	// Retryable is set for failures which may not happen again in a new attempt of the
+	// transfer, e.g. network timeouts, as opposed to failures requiring changes from the user
+	Retryable bool
+	// Warning is set when the transfer succeeded with warnings, e.g. source files vanishing
+	// during an rsync transfer
+	Warning string
+	// FilesTransferred and BytesTransferred are the number of regular files and bytes transferred,
+	// they are zero when the transfer does not report them
+	FilesTransferred int64
+	BytesTransferred int64
+	// Stats are the detailed statistics of the transfer, nil when the transfer does not report them
+	Stats *Stats
+	// Err wraps the sentinel error of failures callers can act upon, e.g. ErrDestinationFull,
+	// it is nil for successful transfers and for failures the transfer does not interpret
+	Err error
+}
+
+// Stats are the statistics reported by a transfer on completion
+type Stats struct {
+	// NumberOfFiles and TotalFileSize describe all the files of the source
+	NumberOfFiles int64
+	TotalFileSize int64
+	// CreatedFiles and DeletedFiles are the number of files created and deleted in the destination
+	CreatedFiles int64
+	DeletedFiles int64
+	// RegularFilesTransferred and TotalTransferredFileSize describe the regular files transferred
+	RegularFilesTransferred  int64
+	TotalTransferredFileSize int64
+	// BytesSent and BytesReceived are the bytes exchanged on the wire by the transfer client
+	BytesSent     int64
+	BytesReceived int64
+	// Speedup is the ratio of the total size of the source to the bytes exchanged on the wire
+	Speedup float64
 }
 
 // IsPodHealthy is a utility function that can be used by various
-// implementations to check if the server pod deployed is healthy
-func IsPodHealthy(ctx context.Context, c client.Client, pod client.ObjectKey) (bool, error) {
+// implementations to check if the server pod deployed is healthy. Only the
+// given containers are checked, e.g. the Containers of a transfer server, other
+// containers such as injected sidecars are ignored. All the containers are
+// checked when no container is given.
+func IsPodHealthy(ctx context.Context, c client.Client, pod client.ObjectKey, containers ...string) (bool, error) {
 	p := &corev1.Pod{}
 
-	err := c.Get(context.Background(), pod, p)
+	err := c.Get(ctx, pod, p)
 	if err != nil {
 		return false, err
 	}
 
-	return areContainersReady(p)
+	return areContainersReady(p, containers)
 }
 
 // IsPodCompleted is a utility function that can be used by various
 // implementations to check if the server pod deployed is completed.
 // if containerName is empty string then it will check for completion of
-// all the containers
+// all the containers, other containers are ignored otherwise
 func IsPodCompleted(ctx context.Context, c client.Client, podKey client.ObjectKey, containerName string) (bool, error) {
 	pod := &corev1.Pod{}
-	err := c.Get(context.Background(), podKey, pod)
+	err := c.Get(ctx, podKey, pod)
 	if err != nil {
 		return false, err
 	}
 
-	if len(pod.Status.ContainerStatuses) != 2 {
-		return false, fmt.Errorf("expected two contaier statuses found %d, for pod %s",
-			len(pod.Status.ContainerStatuses), client.ObjectKey{Namespace: pod.Namespace, Name: pod.Name})
+	if containerName != "" {
+		return areContainersTerminated(pod, []string{containerName}), nil
 	}
+	return areContainersTerminated(pod, nil), nil
+}
 
-	for _, containerStatus := range pod.Status.ContainerStatuses {
-		if containerName != "" && containerStatus.Name == containerName {
-			return containerStatus.State.Terminated != nil, nil
-		} else {
-			if containerStatus.State.Terminated == nil {
-				return false, nil
-			}
+// areContainersTerminated returns whether the given containers of the pod terminated, or all
+// its containers when none is given, native sidecars included. Given containers without a
+// status did not terminate.
+func areContainersTerminated(pod *corev1.Pod, containers []string) bool {
+	containerStatuses := transport.ContainerStatuses(pod)
+	if len(containerStatuses) == 0 {
+		return false
+	}
+	terminated := map[string]bool{}
+	for _, containerStatus := range containerStatuses {
+		terminated[containerStatus.Name] = containerStatus.State.Terminated != nil
+		if len(containers) == 0 && !terminated[containerStatus.Name] {
+			return false
 		}
 	}
-	return true, nil
+	for _, name := range containers {
+		if !terminated[name] {
+			return false
+		}
+	}
+	return true
 }
 
-func areContainersReady(pod *corev1.Pod) (bool, error) {
-	if len(pod.Status.ContainerStatuses) != 2 {
-		return false, fmt.Errorf("expected two contaier statuses found %d, for pod %s",
-			len(pod.Status.ContainerStatuses), client.ObjectKey{Namespace: pod.Namespace, Name: pod.Name})
+// areContainersReady returns whether the given containers of the pod are ready, or all its
+// containers when none is given, native sidecars included. Given containers without a status
+// are not ready.
+func areContainersReady(pod *corev1.Pod, containers []string) (bool, error) {
+	key := client.ObjectKey{Namespace: pod.Namespace, Name: pod.Name}
+	containerStatuses := transport.ContainerStatuses(pod)
+	if len(containerStatuses) == 0 {
+		return false, fmt.Errorf("%w: no container statuses for pod %s", ErrPodNotReady, key)
 	}
 
-	for _, containerStatus := range pod.Status.ContainerStatuses {
+	statuses := map[string]corev1.ContainerStatus{}
+	for _, containerStatus := range containerStatuses {
+		statuses[containerStatus.Name] = containerStatus
+	}
+	if len(containers) == 0 {
+		for name := range statuses {
+			containers = append(containers, name)
+		}
+		sort.Strings(containers)
+	}
+	for _, name := range containers {
+		containerStatus, ok := statuses[name]
+		if !ok {
+			return false, fmt.Errorf("%w: no status for container %s in pod %s", ErrPodNotReady, name, key)
+		}
 		if !containerStatus.Ready {
-			return false, fmt.Errorf("container %s in pod %s is not ready",
-				containerStatus.Name, client.ObjectKey{Namespace: pod.Namespace, Name: pod.Name})
+			return false, fmt.Errorf("%w: container %s in pod %s is not ready",
+				ErrPodNotReady, containerStatus.Name, key)
 		}
 	}
 	return true, nil
 }
 
+// podListPageSize is the number of pods listed per request by AreFilteredPodsHealthy
+const podListPageSize = 100
+
 // AreFilteredPodsHealthy is a utility function that can be used by various
-// implementations to check if the server pods deployed with some label selectors
+// implementations to check if the server pods deployed with some labels
 // are healthy. If atleast 1 replica will be healthy the function will return true
-func AreFilteredPodsHealthy(ctx context.Context, c client.Client, namespace string, labels fields.Set) (bool, error) {
-	pList := &corev1.PodList{}
+// along with the key of the healthy pod, e.g. to exec into it or read its logs.
+// Only the given containers are checked, see IsPodHealthy. Pods are listed in pages.
+func AreFilteredPodsHealthy(ctx context.Context, c client.Client, namespace string, labels map[string]string, containers ...string) (bool, client.ObjectKey, error) {
+	errs := []error{}
+	continueToken := ""
+	for {
+		pList := &corev1.PodList{}
+		err := c.List(ctx, pList, client.InNamespace(namespace), client.MatchingLabels(labels),
+			client.Limit(podListPageSize), client.Continue(continueToken))
+		if err != nil {
+			return false, client.ObjectKey{}, err
+		}
 
-	err := c.List(context.Background(), pList, client.InNamespace(namespace), client.MatchingFields(labels))
-	if err != nil {
-		return false, err
+		for i := range pList.Items {
+			podReady, err := areContainersReady(&pList.Items[i], containers)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if podReady {
+				return true, client.ObjectKeyFromObject(&pList.Items[i]), nil
+			}
+		}
+
+		continueToken = pList.Continue
+		if continueToken == "" {
+			break
+		}
 	}
 
-	errs := []error{}
+	return false, client.ObjectKey{}, errorsutil.NewAggregate(errs)
+}
 
-	for i := range pList.Items {
-		podReady, err := areContainersReady(&pList.Items[i])
+// CheckPVCsNotInUse returns an error wrapping ErrPVCInUse when a PVC in pvcList is mounted by a
+// pod which is not terminated. Transfer pods of the PVCs can be skipped with ignoredPods.
+func CheckPVCsNotInUse(ctx context.Context, c client.Client, pvcList PVCList, ignoredPods ...client.ObjectKey) error {
+	ignored := map[client.ObjectKey]bool{}
+	for _, key := range ignoredPods {
+		ignored[key] = true
+	}
+
+	errs := []error{}
+	for _, namespace := range pvcList.Namespaces() {
+		pList := &corev1.PodList{}
+		err := c.List(ctx, pList, client.InNamespace(namespace))
 		if err != nil {
-			errs = append(errs, err)
+			return err
 		}
-		if podReady {
-			return true, nil
+		for _, pvc := range pvcList.InNamespace(namespace).PVCs() {
+			for i := range pList.Items {
+				pod := &pList.Items[i]
+				if ignored[client.ObjectKeyFromObject(pod)] || isPodTerminated(pod) {
+					continue
+				}
+				if mountsPVC(pod, pvc.Claim().Name) {
+					errs = append(errs, fmt.Errorf("%w: %s is mounted by pod %s",
+						ErrPVCInUse, client.ObjectKeyFromObject(pvc.Claim()), client.ObjectKeyFromObject(pod)))
+				}
+			}
 		}
 	}
+	return errorsutil.NewAggregate(errs)
+}
 
-	return false, errorsutil.NewAggregate(errs)
+func isPodTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+func mountsPVC(pod *corev1.Pod, claimName string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
 }
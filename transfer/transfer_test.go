@@ -0,0 +1,369 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/backube/pvc-transfer/internal/testutils"
+	"github.com/backube/pvc-transfer/transport"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func testPod(ready bool, terminated bool) *corev1.Pod {
+	state := corev1.ContainerState{}
+	if terminated {
+		state.Terminated = &corev1.ContainerStateTerminated{ExitCode: 0}
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "foo",
+			Namespace: "bar",
+		},
+		Status: corev1.PodStatus{
+			ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "rsync", Ready: ready, State: state},
+				{Name: "stunnel", Ready: ready, State: state},
+			},
+		},
+	}
+}
+
+// withSidecar adds the status of a running sidecar container, e.g. injected by a service mesh
+func withSidecar(pod *corev1.Pod, ready bool) *corev1.Pod {
+	pod.Status.ContainerStatuses = append(pod.Status.ContainerStatuses, corev1.ContainerStatus{
+		Name:  "istio-proxy",
+		Ready: ready,
+		State: corev1.ContainerState{Running: &corev1.ContainerStateRunning{}},
+	})
+	return pod
+}
+
+// withNativeSidecar moves the status of the stunnel container to the statuses of the init
+// containers, running it as a native sidecar
+func withNativeSidecar(pod *corev1.Pod, ready bool) *corev1.Pod {
+	pod.Spec.InitContainers = transport.NativeSidecars([]corev1.Container{{Name: "stunnel"}})
+	pod.Status.InitContainerStatuses = pod.Status.ContainerStatuses[1:]
+	pod.Status.InitContainerStatuses[0].Ready = ready
+	pod.Status.ContainerStatuses = pod.Status.ContainerStatuses[:1]
+	return pod
+}
+
+func testContext(cancelled bool) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	if cancelled {
+		cancel()
+	}
+	return ctx, cancel
+}
+
+func TestIsPodHealthy(t *testing.T) {
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		containers []string
+		cancelled  bool
+		want       bool
+		wantErr    error
+	}{
+		{
+			name:      "pod with ready containers, must return healthy",
+			pod:       testPod(true, false),
+			cancelled: false,
+			want:      true,
+			wantErr:   nil,
+		},
+		{
+			name:      "pod with containers not ready, must return ErrPodNotReady",
+			pod:       testPod(false, false),
+			cancelled: false,
+			want:      false,
+			wantErr:   ErrPodNotReady,
+		},
+		{
+			name:       "pod with a sidecar not ready, must ignore the sidecar and return healthy",
+			pod:        withSidecar(testPod(true, false), false),
+			containers: []string{"rsync", "stunnel"},
+			want:       true,
+		},
+		{
+			name:    "pod with a sidecar not ready without containers, must return ErrPodNotReady",
+			pod:     withSidecar(testPod(true, false), false),
+			want:    false,
+			wantErr: ErrPodNotReady,
+		},
+		{
+			name:       "pod with a ready native sidecar, must return healthy",
+			pod:        withNativeSidecar(testPod(true, false), true),
+			containers: []string{"rsync", "stunnel"},
+			want:       true,
+		},
+		{
+			name:       "pod with a native sidecar not ready, must return ErrPodNotReady",
+			pod:        withNativeSidecar(testPod(true, false), false),
+			containers: []string{"rsync", "stunnel"},
+			want:       false,
+			wantErr:    ErrPodNotReady,
+		},
+		{
+			name:       "pod without status for a container, must return ErrPodNotReady",
+			pod:        testPod(true, false),
+			containers: []string{"rsync", "ssh"},
+			want:       false,
+			wantErr:    ErrPodNotReady,
+		},
+		{
+			name:      "context is cancelled, must return context error",
+			pod:       testPod(true, false),
+			cancelled: true,
+			want:      false,
+			wantErr:   context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testutils.ContextAwareClient{Client: fake.NewClientBuilder().WithObjects(tt.pod).Build()}
+			ctx, cancel := testContext(tt.cancelled)
+			defer cancel()
+			got, err := IsPodHealthy(ctx, c, client.ObjectKeyFromObject(tt.pod), tt.containers...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("IsPodHealthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsPodHealthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsPodCompleted(t *testing.T) {
+	tests := []struct {
+		name      string
+		pod       *corev1.Pod
+		container string
+		cancelled bool
+		want      bool
+		wantErr   error
+	}{
+		{
+			name:      "pod with terminated containers, must return completed",
+			pod:       testPod(false, true),
+			container: "rsync",
+			cancelled: false,
+			want:      true,
+			wantErr:   nil,
+		},
+		{
+			name:      "pod with running containers, must return not completed",
+			pod:       testPod(true, false),
+			container: "rsync",
+			cancelled: false,
+			want:      false,
+			wantErr:   nil,
+		},
+		{
+			name:      "pod with a running sidecar, must ignore the sidecar and return completed",
+			pod:       withSidecar(testPod(false, true), true),
+			container: "rsync",
+			want:      true,
+		},
+		{
+			name: "pod with a running sidecar without container, must return not completed",
+			pod:  withSidecar(testPod(false, true), true),
+			want: false,
+		},
+		{
+			name:      "context is cancelled, must return context error",
+			pod:       testPod(false, true),
+			container: "rsync",
+			cancelled: true,
+			want:      false,
+			wantErr:   context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testutils.ContextAwareClient{Client: fake.NewClientBuilder().WithObjects(tt.pod).Build()}
+			ctx, cancel := testContext(tt.cancelled)
+			defer cancel()
+			got, err := IsPodCompleted(ctx, c, client.ObjectKeyFromObject(tt.pod), tt.container)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("IsPodCompleted() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsPodCompleted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAreFilteredPodsHealthy(t *testing.T) {
+	labeled := func(pod *corev1.Pod) *corev1.Pod {
+		pod.Labels = map[string]string{"app": "rsync"}
+		return pod
+	}
+	tests := []struct {
+		name      string
+		pod       *corev1.Pod
+		cancelled bool
+		want      bool
+		wantPod   client.ObjectKey
+		wantErr   error
+	}{
+		{
+			name:      "pod with ready containers, must return healthy",
+			pod:       labeled(testPod(true, false)),
+			cancelled: false,
+			want:      true,
+			wantPod:   client.ObjectKey{Namespace: "bar", Name: "foo"},
+			wantErr:   nil,
+		},
+		{
+			name:    "pod with containers not ready, must return ErrPodNotReady",
+			pod:     labeled(testPod(false, false)),
+			want:    false,
+			wantErr: ErrPodNotReady,
+		},
+		{
+			name: "pod without the labels, must return not healthy",
+			pod:  testPod(true, false),
+			want: false,
+		},
+		{
+			name:      "context is cancelled, must return context error",
+			pod:       labeled(testPod(true, false)),
+			cancelled: true,
+			want:      false,
+			wantErr:   context.Canceled,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testutils.ContextAwareClient{Client: fake.NewClientBuilder().WithObjects(tt.pod).Build()}
+			ctx, cancel := testContext(tt.cancelled)
+			defer cancel()
+			got, pod, err := AreFilteredPodsHealthy(ctx, c, tt.pod.Namespace, map[string]string{"app": "rsync"})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("AreFilteredPodsHealthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want || pod != tt.wantPod {
+				t.Errorf("AreFilteredPodsHealthy() = %v, %v, want %v, %v", got, pod, tt.want, tt.wantPod)
+			}
+		})
+	}
+}
+
+func TestClusterPair_Validate(t *testing.T) {
+	c := fake.NewClientBuilder().Build()
+	tests := []struct {
+		name     string
+		clusters ClusterPair
+		wantErr  error
+	}{
+		{
+			name:     "single cluster, must be valid",
+			clusters: SingleCluster(c),
+		},
+		{
+			name:     "distinct clusters, must be valid",
+			clusters: ClusterPair{Source: c, Destination: fake.NewClientBuilder().Build()},
+		},
+		{
+			name:     "destination client not set, must return ErrClusterPairInvalid",
+			clusters: ClusterPair{Source: c},
+			wantErr:  ErrClusterPairInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.clusters.Validate()
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckPVCsNotInUse(t *testing.T) {
+	appPod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "foo"},
+			Spec: corev1.PodSpec{Volumes: []corev1.Volume{{
+				Name: "data",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "test-pvc"},
+				},
+			}}},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+	tests := []struct {
+		name        string
+		objects     []client.Object
+		ignoredPods []client.ObjectKey
+		wantErr     error
+	}{
+		{
+			name: "no pods, must not return an error",
+		},
+		{
+			name:    "running pod mounting the PVC, must return ErrPVCInUse",
+			objects: []client.Object{appPod("app", corev1.PodRunning)},
+			wantErr: ErrPVCInUse,
+		},
+		{
+			name:    "succeeded pod mounting the PVC, must not return an error",
+			objects: []client.Object{appPod("app", corev1.PodSucceeded)},
+		},
+		{
+			name:        "ignored transfer pod mounting the PVC, must not return an error",
+			objects:     []client.Object{appPod("rsync-client", corev1.PodRunning)},
+			ignoredPods: []client.ObjectKey{{Namespace: "foo", Name: "rsync-client"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pvcList := NewSingletonPVC(&corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-pvc", Namespace: "foo"},
+			})
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			err := CheckPVCsNotInUse(context.Background(), c, pvcList, tt.ignoredPods...)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("CheckPVCsNotInUse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTransferID(t *testing.T) {
+	tests := []struct {
+		name    string
+		id      string
+		wantErr error
+	}{
+		{
+			name: "valid label value, must be valid",
+			id:   "migration-42",
+		},
+		{
+			name:    "empty ID, must return ErrTransferIDInvalid",
+			wantErr: ErrTransferIDInvalid,
+		},
+		{
+			name:    "ID with a slash, must return ErrTransferIDInvalid",
+			id:      "ns/migration",
+			wantErr: ErrTransferIDInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTransferID(tt.id)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateTransferID() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package transfer
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ErrExtraVolumesInvalid is returned when the ExtraVolumes or ExtraVolumeMounts of the pod
+// options cannot be added to transfer pods
+var ErrExtraVolumesInvalid = errors.New("extra volumes invalid")
+
+// ValidateExtraVolumes returns an error wrapping ErrExtraVolumesInvalid when the extra volumes
+// are not valid volume names, are declared twice, or when the extra volume mounts do not mount
+// an extra volume at a unique absolute path. Mount paths under any of the reserved paths,
+// used by the transfer containers, are rejected too.
+func (p PodOptions) ValidateExtraVolumes(reservedPaths ...string) error {
+	var errs []error
+	volumes := map[string]bool{}
+	for _, volume := range p.ExtraVolumes {
+		if msgs := validation.IsDNS1123Label(volume.Name); len(msgs) > 0 {
+			errs = append(errs, fmt.Errorf("%w: volume name %q: %s", ErrExtraVolumesInvalid, volume.Name, strings.Join(msgs, ", ")))
+		}
+		if volumes[volume.Name] {
+			errs = append(errs, fmt.Errorf("%w: volume %s declared twice", ErrExtraVolumesInvalid, volume.Name))
+		}
+		volumes[volume.Name] = true
+	}
+	mountPaths := map[string]bool{}
+	for _, mount := range p.ExtraVolumeMounts {
+		mountPath := path.Clean(mount.MountPath)
+		switch {
+		case !volumes[mount.Name]:
+			errs = append(errs, fmt.Errorf("%w: mount %s does not reference an extra volume", ErrExtraVolumesInvalid, mount.Name))
+		case !path.IsAbs(mount.MountPath):
+			errs = append(errs, fmt.Errorf("%w: mount path %q of volume %s must be absolute", ErrExtraVolumesInvalid, mount.MountPath, mount.Name))
+		case mountPaths[mountPath]:
+			errs = append(errs, fmt.Errorf("%w: mount path %s used twice", ErrExtraVolumesInvalid, mountPath))
+		case isUnderAny(mountPath, reservedPaths):
+			errs = append(errs, fmt.Errorf("%w: mount path %s of volume %s is used by the transfer", ErrExtraVolumesInvalid, mountPath, mount.Name))
+		}
+		mountPaths[mountPath] = true
+	}
+	return errorsutil.NewAggregate(errs)
+}
+
+// isUnderAny returns whether p is one of the given paths or a sub-path of one of them
+func isUnderAny(p string, paths []string) bool {
+	for _, reserved := range paths {
+		reserved = path.Clean(reserved)
+		if p == reserved || strings.HasPrefix(p, strings.TrimSuffix(reserved, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateExtraVolumeNames returns an error wrapping ErrExtraVolumesInvalid when an extra
+// volume of the pod options has the name of one of the volumes of a transfer pod
+func (p PodOptions) ValidateExtraVolumeNames(volumes []corev1.Volume) error {
+	names := map[string]bool{}
+	for _, volume := range volumes {
+		names[volume.Name] = true
+	}
+	for _, volume := range p.ExtraVolumes {
+		if names[volume.Name] {
+			return fmt.Errorf("%w: volume %s is used by the transfer", ErrExtraVolumesInvalid, volume.Name)
+		}
+	}
+	return nil
+}
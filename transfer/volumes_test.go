@@ -0,0 +1,107 @@
+package transfer
+
+import (
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestPodOptions_ValidateExtraVolumes(t *testing.T) {
+	caBundle := corev1.Volume{
+		Name: "ca-bundle",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{LocalObjectReference: corev1.LocalObjectReference{Name: "ca-bundle"}},
+		},
+	}
+	scratch := corev1.Volume{
+		Name:         "scratch",
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	}
+	tests := []struct {
+		name    string
+		options PodOptions
+		wantErr bool
+	}{
+		{
+			name: "volumes mounted at unique paths, must be valid",
+			options: PodOptions{
+				ExtraVolumes: []corev1.Volume{caBundle, scratch},
+				ExtraVolumeMounts: []corev1.VolumeMount{
+					{Name: "ca-bundle", MountPath: "/etc/pki/ca-trust/extracted"},
+					{Name: "scratch", MountPath: "/scratch"},
+				},
+			},
+		},
+		{
+			name:    "volume not mounted, must be valid",
+			options: PodOptions{ExtraVolumes: []corev1.Volume{scratch}},
+		},
+		{
+			name:    "invalid volume name, must return an error",
+			options: PodOptions{ExtraVolumes: []corev1.Volume{{Name: "Scratch_Space"}}},
+			wantErr: true,
+		},
+		{
+			name:    "volume declared twice, must return an error",
+			options: PodOptions{ExtraVolumes: []corev1.Volume{scratch, scratch}},
+			wantErr: true,
+		},
+		{
+			name: "mount of an unknown volume, must return an error",
+			options: PodOptions{
+				ExtraVolumeMounts: []corev1.VolumeMount{{Name: "krb5", MountPath: "/etc/krb5.conf"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "relative mount path, must return an error",
+			options: PodOptions{
+				ExtraVolumes:      []corev1.Volume{scratch},
+				ExtraVolumeMounts: []corev1.VolumeMount{{Name: "scratch", MountPath: "scratch"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mount path used twice, must return an error",
+			options: PodOptions{
+				ExtraVolumes: []corev1.Volume{caBundle, scratch},
+				ExtraVolumeMounts: []corev1.VolumeMount{
+					{Name: "ca-bundle", MountPath: "/scratch"},
+					{Name: "scratch", MountPath: "/scratch/"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "mount under a reserved path, must return an error",
+			options: PodOptions{
+				ExtraVolumes:      []corev1.Volume{scratch},
+				ExtraVolumeMounts: []corev1.VolumeMount{{Name: "scratch", MountPath: "/mnt/scratch"}},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.options.ValidateExtraVolumes("/mnt")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateExtraVolumes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrExtraVolumesInvalid) {
+				t.Errorf("ValidateExtraVolumes() error = %v, want ErrExtraVolumesInvalid", err)
+			}
+		})
+	}
+}
+
+func TestPodOptions_ValidateExtraVolumeNames(t *testing.T) {
+	options := PodOptions{ExtraVolumes: []corev1.Volume{{Name: "scratch"}}}
+	if err := options.ValidateExtraVolumeNames([]corev1.Volume{{Name: "mnt"}}); err != nil {
+		t.Errorf("ValidateExtraVolumeNames() error = %v, want nil", err)
+	}
+	err := options.ValidateExtraVolumeNames([]corev1.Volume{{Name: "mnt"}, {Name: "scratch"}})
+	if !errors.Is(err, ErrExtraVolumesInvalid) {
+		t.Errorf("ValidateExtraVolumeNames() error = %v, want ErrExtraVolumesInvalid", err)
+	}
+}
@@ -0,0 +1,165 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	errorsutil "k8s.io/apimachinery/pkg/util/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrWorkloadKindNotSupported is returned for workload references of an unknown kind
+var ErrWorkloadKindNotSupported = errors.New("workload kind not supported")
+
+// WorkloadKind is the kind of the workload running transfer pods
+type WorkloadKind string
+
+const (
+	// WorkloadPod is a bare pod
+	WorkloadPod WorkloadKind = "Pod"
+	// WorkloadJob is a Job, it completes once it succeeded or failed
+	WorkloadJob WorkloadKind = "Job"
+	// WorkloadDeployment is a Deployment, it completes once scaled down to zero replicas
+	WorkloadDeployment WorkloadKind = "Deployment"
+)
+
+// WorkloadReference identifies the workload running transfer pods and the containers of its
+// pods the transfer relies on
+type WorkloadReference struct {
+	Kind           WorkloadKind
+	NamespacedName types.NamespacedName
+	// Containers are the names of the containers checked in the pods of the workload, e.g. the
+	// Containers of a transfer server, other containers such as injected sidecars are ignored.
+	// All the containers are checked when empty.
+	Containers []string
+}
+
+// IsWorkloadHealthy returns whether the workload runs a pod whose containers are ready, see
+// IsPodHealthy. Pods of Jobs and Deployments are found with the selector of their owner,
+// Jobs which failed are not healthy.
+func IsWorkloadHealthy(ctx context.Context, c client.Client, ref WorkloadReference) (bool, error) {
+	switch ref.Kind {
+	case WorkloadPod:
+		return IsPodHealthy(ctx, c, ref.NamespacedName, ref.Containers...)
+	case WorkloadJob:
+		job := &batchv1.Job{}
+		err := c.Get(ctx, ref.NamespacedName, job)
+		if err != nil {
+			return false, err
+		}
+		if condition := getJobCondition(job, batchv1.JobFailed); condition != nil {
+			return false, fmt.Errorf("%w: job %s failed: %s", ErrPodNotReady, ref.NamespacedName, condition.Message)
+		}
+		return areWorkloadPodsReady(ctx, c, ref, job.Spec.Selector)
+	case WorkloadDeployment:
+		deployment := &appsv1.Deployment{}
+		err := c.Get(ctx, ref.NamespacedName, deployment)
+		if err != nil {
+			return false, err
+		}
+		return areWorkloadPodsReady(ctx, c, ref, deployment.Spec.Selector)
+	default:
+		return false, fmt.Errorf("%w: %s", ErrWorkloadKindNotSupported, ref.Kind)
+	}
+}
+
+// IsWorkloadCompleted returns whether the workload completed, successfully or not. Pods
+// complete once their containers terminated, see IsPodCompleted. Jobs complete once they
+// succeeded or failed, or once the containers of one of their pods terminated, as Jobs whose
+// pods run sidecars never do. Deployments complete once scaled down to zero replicas.
+func IsWorkloadCompleted(ctx context.Context, c client.Client, ref WorkloadReference) (bool, error) {
+	switch ref.Kind {
+	case WorkloadPod:
+		pod := &corev1.Pod{}
+		err := c.Get(ctx, ref.NamespacedName, pod)
+		if err != nil {
+			return false, err
+		}
+		return areContainersTerminated(pod, ref.Containers), nil
+	case WorkloadJob:
+		job := &batchv1.Job{}
+		err := c.Get(ctx, ref.NamespacedName, job)
+		if err != nil {
+			return false, err
+		}
+		if getJobCondition(job, batchv1.JobComplete) != nil || getJobCondition(job, batchv1.JobFailed) != nil {
+			return true, nil
+		}
+		pods, err := listWorkloadPods(ctx, c, ref, job.Spec.Selector)
+		if err != nil {
+			return false, err
+		}
+		for i := range pods {
+			if areContainersTerminated(&pods[i], ref.Containers) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case WorkloadDeployment:
+		deployment := &appsv1.Deployment{}
+		err := c.Get(ctx, ref.NamespacedName, deployment)
+		if err != nil {
+			return false, err
+		}
+		scaledDown := deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == 0
+		return scaledDown && deployment.Status.Replicas == 0, nil
+	default:
+		return false, fmt.Errorf("%w: %s", ErrWorkloadKindNotSupported, ref.Kind)
+	}
+}
+
+// getJobCondition returns the condition of the given type of the job when it is true
+func getJobCondition(job *batchv1.Job, conditionType batchv1.JobConditionType) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		condition := &job.Status.Conditions[i]
+		if condition.Type == conditionType && condition.Status == corev1.ConditionTrue {
+			return condition
+		}
+	}
+	return nil
+}
+
+// listWorkloadPods returns the pods of the workload matching the selector of the workload
+func listWorkloadPods(ctx context.Context, c client.Client, ref WorkloadReference, selector *metav1.LabelSelector) ([]corev1.Pod, error) {
+	if selector == nil {
+		return nil, fmt.Errorf("%w: %s %s has no selector", ErrPodNotReady, ref.Kind, ref.NamespacedName)
+	}
+	labelSelector, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, err
+	}
+	podList := &corev1.PodList{}
+	err = c.List(ctx, podList, client.InNamespace(ref.NamespacedName.Namespace),
+		client.MatchingLabelsSelector{Selector: labelSelector})
+	if err != nil {
+		return nil, err
+	}
+	return podList.Items, nil
+}
+
+// areWorkloadPodsReady returns whether at least one pod of the workload has its containers
+// ready, the errors of the pods which are not are aggregated otherwise
+func areWorkloadPodsReady(ctx context.Context, c client.Client, ref WorkloadReference, selector *metav1.LabelSelector) (bool, error) {
+	pods, err := listWorkloadPods(ctx, c, ref, selector)
+	if err != nil {
+		return false, err
+	}
+	if len(pods) == 0 {
+		return false, fmt.Errorf("%w: no pod for %s %s", ErrPodNotReady, ref.Kind, ref.NamespacedName)
+	}
+	errs := []error{}
+	for i := range pods {
+		ready, err := areContainersReady(&pods[i], ref.Containers)
+		if ready {
+			return true, nil
+		}
+		errs = append(errs, err)
+	}
+	return false, errorsutil.NewAggregate(errs)
+}
@@ -0,0 +1,194 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var testWorkloadSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "rsync"}}
+
+// testWorkloadPod returns the pod of a workload selected by testWorkloadSelector
+func testWorkloadPod(ready bool, terminated bool) *corev1.Pod {
+	pod := testPod(ready, terminated)
+	pod.Labels = testWorkloadSelector.MatchLabels
+	return pod
+}
+
+func testJob(conditionType batchv1.JobConditionType) *batchv1.Job {
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync", Namespace: "bar"},
+		Spec:       batchv1.JobSpec{Selector: testWorkloadSelector},
+	}
+	if conditionType != "" {
+		job.Status.Conditions = []batchv1.JobCondition{{Type: conditionType, Status: corev1.ConditionTrue}}
+	}
+	return job
+}
+
+func testDeployment(replicas, currentReplicas int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "rsync", Namespace: "bar"},
+		Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32Ptr(replicas), Selector: testWorkloadSelector},
+		Status:     appsv1.DeploymentStatus{Replicas: currentReplicas},
+	}
+}
+
+func TestIsWorkloadHealthy(t *testing.T) {
+	podKey := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	workloadKey := types.NamespacedName{Namespace: "bar", Name: "rsync"}
+	tests := []struct {
+		name    string
+		objects []client.Object
+		ref     WorkloadReference
+		want    bool
+		wantErr error
+	}{
+		{
+			name:    "pod with ready containers, must return healthy",
+			objects: []client.Object{testPod(true, false)},
+			ref:     WorkloadReference{Kind: WorkloadPod, NamespacedName: podKey},
+			want:    true,
+		},
+		{
+			name:    "pod with a sidecar not ready, must ignore the sidecar and return healthy",
+			objects: []client.Object{withSidecar(testPod(true, false), false)},
+			ref:     WorkloadReference{Kind: WorkloadPod, NamespacedName: podKey, Containers: []string{"rsync", "stunnel"}},
+			want:    true,
+		},
+		{
+			name:    "job with a ready pod, must return healthy",
+			objects: []client.Object{testJob(""), testWorkloadPod(true, false)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			want:    true,
+		},
+		{
+			name:    "job with a pod not ready, must return ErrPodNotReady",
+			objects: []client.Object{testJob(""), testWorkloadPod(false, false)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			wantErr: ErrPodNotReady,
+		},
+		{
+			name:    "failed job, must return ErrPodNotReady",
+			objects: []client.Object{testJob(batchv1.JobFailed), testWorkloadPod(true, false)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			wantErr: ErrPodNotReady,
+		},
+		{
+			name:    "deployment with a ready pod, must return healthy",
+			objects: []client.Object{testDeployment(1, 1), testWorkloadPod(true, false)},
+			ref:     WorkloadReference{Kind: WorkloadDeployment, NamespacedName: workloadKey},
+			want:    true,
+		},
+		{
+			name:    "deployment without pods, must return ErrPodNotReady",
+			objects: []client.Object{testDeployment(1, 0)},
+			ref:     WorkloadReference{Kind: WorkloadDeployment, NamespacedName: workloadKey},
+			wantErr: ErrPodNotReady,
+		},
+		{
+			name:    "unknown kind, must return ErrWorkloadKindNotSupported",
+			ref:     WorkloadReference{Kind: "StatefulSet", NamespacedName: workloadKey},
+			wantErr: ErrWorkloadKindNotSupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			got, err := IsWorkloadHealthy(context.Background(), c, tt.ref)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("IsWorkloadHealthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsWorkloadHealthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsWorkloadCompleted(t *testing.T) {
+	podKey := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	workloadKey := types.NamespacedName{Namespace: "bar", Name: "rsync"}
+	tests := []struct {
+		name    string
+		objects []client.Object
+		ref     WorkloadReference
+		want    bool
+		wantErr error
+	}{
+		{
+			name:    "pod with terminated containers, must return completed",
+			objects: []client.Object{testPod(false, true)},
+			ref:     WorkloadReference{Kind: WorkloadPod, NamespacedName: podKey},
+			want:    true,
+		},
+		{
+			name:    "pod with a running sidecar, must ignore the sidecar and return completed",
+			objects: []client.Object{withSidecar(testPod(false, true), true)},
+			ref:     WorkloadReference{Kind: WorkloadPod, NamespacedName: podKey, Containers: []string{"rsync"}},
+			want:    true,
+		},
+		{
+			name:    "complete job, must return completed",
+			objects: []client.Object{testJob(batchv1.JobComplete)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			want:    true,
+		},
+		{
+			name:    "failed job, must return completed",
+			objects: []client.Object{testJob(batchv1.JobFailed)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			want:    true,
+		},
+		{
+			name:    "running job, must return not completed",
+			objects: []client.Object{testJob(""), testWorkloadPod(true, false)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey},
+			want:    false,
+		},
+		{
+			name:    "job with a running sidecar, must return completed once the containers terminated",
+			objects: []client.Object{testJob(""), withSidecar(testWorkloadPod(false, true), true)},
+			ref:     WorkloadReference{Kind: WorkloadJob, NamespacedName: workloadKey, Containers: []string{"rsync", "stunnel"}},
+			want:    true,
+		},
+		{
+			name:    "deployment scaled down, must return completed",
+			objects: []client.Object{testDeployment(0, 0)},
+			ref:     WorkloadReference{Kind: WorkloadDeployment, NamespacedName: workloadKey},
+			want:    true,
+		},
+		{
+			name:    "deployment scaling down, must return not completed",
+			objects: []client.Object{testDeployment(0, 1)},
+			ref:     WorkloadReference{Kind: WorkloadDeployment, NamespacedName: workloadKey},
+			want:    false,
+		},
+		{
+			name:    "unknown kind, must return ErrWorkloadKindNotSupported",
+			ref:     WorkloadReference{Kind: "StatefulSet", NamespacedName: workloadKey},
+			wantErr: ErrWorkloadKindNotSupported,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithObjects(tt.objects...).Build()
+			got, err := IsWorkloadCompleted(context.Background(), c, tt.ref)
+			if !errors.Is(err, tt.wantErr) || (err == nil) != (tt.wantErr == nil) {
+				t.Errorf("IsWorkloadCompleted() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsWorkloadCompleted() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
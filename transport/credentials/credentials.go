@@ -0,0 +1,259 @@
+// Package credentials provides the secret stores transports read their credentials from
+// when they are not stored in a Secret generated by the transport, see
+// transport.Credentials.Provider.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrProviderMisconfigured is returned when a credentials provider lacks the fields
+// required for reading the credentials from its store
+var ErrProviderMisconfigured = errors.New("credentials provider misconfigured")
+
+const (
+	// SecretStoreKind is the kind of the namespaced stores of the External Secrets Operator
+	SecretStoreKind = "SecretStore"
+	// ClusterSecretStoreKind is the kind of the cluster wide stores of the External Secrets Operator
+	ClusterSecretStoreKind = "ClusterSecretStore"
+	// SecretsStoreCSIDriver is the name of the Secrets Store CSI driver mounting Vault secrets
+	SecretsStoreCSIDriver = "secrets-store.csi.k8s.io"
+
+	defaultRefreshInterval = time.Hour
+)
+
+var (
+	externalSecretGVK = schema.GroupVersionKind{
+		Group:   "external-secrets.io",
+		Version: "v1beta1",
+		Kind:    "ExternalSecret",
+	}
+	secretProviderClassGVK = schema.GroupVersionKind{
+		Group:   "secrets-store.csi.x-k8s.io",
+		Version: "v1",
+		Kind:    "SecretProviderClass",
+	}
+)
+
+// Secret reads the credentials from an existing Secret managed outside of the transport,
+// e.g. by a sealed secrets controller, the transport never writes it
+type Secret struct{}
+
+// Reconcile does nothing, the Secret is managed outside of the transport
+func (s Secret) Reconcile(_ context.Context, _ client.Client, _ logr.Logger, _ types.NamespacedName, _ []string, _ *transport.Options) error {
+	return nil
+}
+
+// IsReady returns whether the Secret exists
+func (s Secret) IsReady(ctx context.Context, c client.Client, secretRef types.NamespacedName, _ []string) (bool, error) {
+	return secretExists(ctx, c, secretRef)
+}
+
+// VolumeSource returns a volume source projecting the items of the Secret
+func (s Secret) VolumeSource(secretRef types.NamespacedName, items []corev1.KeyToPath) corev1.VolumeSource {
+	return secretVolumeSource(secretRef, items)
+}
+
+// ExternalSecret reads the credentials from a store of the External Secrets Operator, the
+// operator syncs the remote secret to a Secret owned by the ExternalSecret created for it
+type ExternalSecret struct {
+	// SecretStoreName is the name of the store holding the credentials
+	SecretStoreName string
+	// SecretStoreKind is SecretStoreKind or ClusterSecretStoreKind, defaults to SecretStoreKind
+	SecretStoreKind string
+	// RemoteKey is the key of the credentials in the store, its properties are the keys
+	// of the credentials read by the transport
+	RemoteKey string
+	// RefreshInterval is how often the operator syncs the Secret, defaults to one hour
+	RefreshInterval time.Duration
+}
+
+// +kubebuilder:rbac:groups=external-secrets.io,resources=externalsecrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile creates the ExternalSecret syncing the credentials to the Secret secretRef
+func (e ExternalSecret) Reconcile(ctx context.Context, c client.Client, logger logr.Logger, secretRef types.NamespacedName, _ []string, options *transport.Options) error {
+	if e.SecretStoreName == "" || e.RemoteKey == "" {
+		return fmt.Errorf("%w: ExternalSecret requires a secret store name and a remote key", ErrProviderMisconfigured)
+	}
+	storeKind := e.SecretStoreKind
+	if storeKind == "" {
+		storeKind = SecretStoreKind
+	}
+	if storeKind != SecretStoreKind && storeKind != ClusterSecretStoreKind {
+		return fmt.Errorf("%w: unsupported secret store kind %s", ErrProviderMisconfigured, storeKind)
+	}
+	refreshInterval := e.RefreshInterval
+	if refreshInterval == 0 {
+		refreshInterval = defaultRefreshInterval
+	}
+
+	externalSecret := newUnstructured(externalSecretGVK, secretRef)
+	_, err := reconcile.CreateOrUpdate(ctx, c, logger, externalSecret, reconcileOptions(options), func() error {
+		externalSecret.SetLabels(options.Labels)
+		externalSecret.SetOwnerReferences(options.Owners)
+		return unstructured.SetNestedField(externalSecret.Object, map[string]interface{}{
+			"refreshInterval": refreshInterval.String(),
+			"secretStoreRef": map[string]interface{}{
+				"name": e.SecretStoreName,
+				"kind": storeKind,
+			},
+			"target": map[string]interface{}{
+				"name":           secretRef.Name,
+				"creationPolicy": "Owner",
+			},
+			"dataFrom": []interface{}{
+				map[string]interface{}{
+					"extract": map[string]interface{}{
+						"key": e.RemoteKey,
+					},
+				},
+			},
+		}, "spec")
+	})
+	return err
+}
+
+// IsReady returns whether the operator synced the Secret
+func (e ExternalSecret) IsReady(ctx context.Context, c client.Client, secretRef types.NamespacedName, _ []string) (bool, error) {
+	return secretExists(ctx, c, secretRef)
+}
+
+// VolumeSource returns a volume source projecting the items of the synced Secret
+func (e ExternalSecret) VolumeSource(secretRef types.NamespacedName, items []corev1.KeyToPath) corev1.VolumeSource {
+	return secretVolumeSource(secretRef, items)
+}
+
+// VaultCSI reads the credentials from Vault using the Secrets Store CSI driver and the
+// Vault CSI provider. The credentials are mounted in the pods of the transport and never
+// stored in the cluster.
+type VaultCSI struct {
+	// Address is the address of the Vault server, defaults to the address configured
+	// in the Vault CSI provider
+	Address string
+	// Role is the Vault role the service account of the transport pods authenticates with
+	Role string
+	// SecretPath is the path of the secret holding the credentials, its keys are the keys
+	// of the credentials read by the transport
+	SecretPath string
+}
+
+// +kubebuilder:rbac:groups=secrets-store.csi.x-k8s.io,resources=secretproviderclasses,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile creates the SecretProviderClass named secretRef mounting the keys of the secret
+func (v VaultCSI) Reconcile(ctx context.Context, c client.Client, logger logr.Logger, secretRef types.NamespacedName, keys []string, options *transport.Options) error {
+	if v.Role == "" || v.SecretPath == "" {
+		return fmt.Errorf("%w: VaultCSI requires a role and a secret path", ErrProviderMisconfigured)
+	}
+	parameters := map[string]interface{}{
+		"roleName": v.Role,
+		"objects":  vaultObjects(v.SecretPath, keys),
+	}
+	if v.Address != "" {
+		parameters["vaultAddress"] = v.Address
+	}
+
+	secretProviderClass := newUnstructured(secretProviderClassGVK, secretRef)
+	_, err := reconcile.CreateOrUpdate(ctx, c, logger, secretProviderClass, reconcileOptions(options), func() error {
+		secretProviderClass.SetLabels(options.Labels)
+		secretProviderClass.SetOwnerReferences(options.Owners)
+		return unstructured.SetNestedField(secretProviderClass.Object, map[string]interface{}{
+			"provider":   "vault",
+			"parameters": parameters,
+		}, "spec")
+	})
+	return err
+}
+
+// IsReady returns whether the SecretProviderClass exists, failures to read the secret from
+// Vault are reported as mount errors of the transport pods
+func (v VaultCSI) IsReady(ctx context.Context, c client.Client, secretRef types.NamespacedName, _ []string) (bool, error) {
+	err := c.Get(ctx, secretRef, newUnstructured(secretProviderClassGVK, secretRef))
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+// VolumeSource returns a CSI volume source mounting the keys of the secret as files, the
+// items are mounted by the SecretProviderClass and only listed there
+func (v VaultCSI) VolumeSource(secretRef types.NamespacedName, _ []corev1.KeyToPath) corev1.VolumeSource {
+	readOnly := true
+	return corev1.VolumeSource{
+		CSI: &corev1.CSIVolumeSource{
+			Driver:           SecretsStoreCSIDriver,
+			ReadOnly:         &readOnly,
+			VolumeAttributes: map[string]string{"secretProviderClass": secretRef.Name},
+		},
+	}
+}
+
+// vaultObjects returns the objects parameter of the Vault CSI provider mounting each key
+// of the secret to a file named after the key
+func vaultObjects(secretPath string, keys []string) string {
+	var objects strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&objects, "- objectName: %q\n  secretPath: %q\n  secretKey: %q\n", key, secretPath, key)
+	}
+	return objects.String()
+}
+
+func secretExists(ctx context.Context, c client.Client, secretRef types.NamespacedName) (bool, error) {
+	err := c.Get(ctx, secretRef, &corev1.Secret{})
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
+
+func secretVolumeSource(secretRef types.NamespacedName, items []corev1.KeyToPath) corev1.VolumeSource {
+	return corev1.VolumeSource{
+		Secret: &corev1.SecretVolumeSource{
+			SecretName: secretRef.Name,
+			Items:      items,
+		},
+	}
+}
+
+func newUnstructured(gvk schema.GroupVersionKind, key types.NamespacedName) *unstructured.Unstructured {
+	u := &unstructured.Unstructured{}
+	u.SetGroupVersionKind(gvk)
+	u.SetName(key.Name)
+	u.SetNamespace(key.Namespace)
+	return u
+}
+
+// reconcileOptions returns the options used to write the objects of the provider to the cluster
+func reconcileOptions(options *transport.Options) reconcile.Options {
+	return reconcile.Options{
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	}
+}
+
+var (
+	_ transport.CredentialsProvider = Secret{}
+	_ transport.CredentialsProvider = ExternalSecret{}
+	_ transport.CredentialsProvider = VaultCSI{}
+)
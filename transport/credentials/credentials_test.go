@@ -0,0 +1,188 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestExternalSecret_Reconcile(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: "creds"}
+	tests := []struct {
+		name             string
+		provider         ExternalSecret
+		wantErr          error
+		wantStoreKind    string
+		wantRefresh      string
+		wantRemoteKeyRef string
+	}{
+		{
+			name:             "store and remote key, must default the kind and refresh interval",
+			provider:         ExternalSecret{SecretStoreName: "vault", RemoteKey: "transfer/creds"},
+			wantStoreKind:    SecretStoreKind,
+			wantRefresh:      "1h0m0s",
+			wantRemoteKeyRef: "transfer/creds",
+		},
+		{
+			name: "cluster store with refresh interval, must be reconciled",
+			provider: ExternalSecret{SecretStoreName: "vault", SecretStoreKind: ClusterSecretStoreKind,
+				RemoteKey: "transfer/creds", RefreshInterval: 5 * time.Minute},
+			wantStoreKind:    ClusterSecretStoreKind,
+			wantRefresh:      "5m0s",
+			wantRemoteKeyRef: "transfer/creds",
+		},
+		{
+			name:     "remote key missing, must return ErrProviderMisconfigured",
+			provider: ExternalSecret{SecretStoreName: "vault"},
+			wantErr:  ErrProviderMisconfigured,
+		},
+		{
+			name:     "unsupported store kind, must return ErrProviderMisconfigured",
+			provider: ExternalSecret{SecretStoreName: "vault", SecretStoreKind: "Store", RemoteKey: "transfer/creds"},
+			wantErr:  ErrProviderMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().Build()
+			err := tt.provider.Reconcile(context.Background(), c, testr.New(t), secretRef,
+				[]string{"server.crt"}, &transport.Options{Labels: map[string]string{"test": "me"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			externalSecret := newUnstructured(externalSecretGVK, secretRef)
+			if err := c.Get(context.Background(), secretRef, externalSecret); err != nil {
+				t.Fatalf("unable to get ExternalSecret %v", err)
+			}
+			kind, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "secretStoreRef", "kind")
+			refresh, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "refreshInterval")
+			target, _, _ := unstructured.NestedString(externalSecret.Object, "spec", "target", "name")
+			dataFrom, _, _ := unstructured.NestedSlice(externalSecret.Object, "spec", "dataFrom")
+			if kind != tt.wantStoreKind || refresh != tt.wantRefresh || target != secretRef.Name {
+				t.Errorf("ExternalSecret spec = %v, want store kind %s, refresh %s, target %s",
+					externalSecret.Object["spec"], tt.wantStoreKind, tt.wantRefresh, secretRef.Name)
+			}
+			if len(dataFrom) != 1 {
+				t.Fatalf("ExternalSecret dataFrom = %v, want one extract", dataFrom)
+			}
+			key, _, _ := unstructured.NestedString(dataFrom[0].(map[string]interface{}), "extract", "key")
+			if key != tt.wantRemoteKeyRef {
+				t.Errorf("ExternalSecret remote key = %s, want %s", key, tt.wantRemoteKeyRef)
+			}
+			if externalSecret.GetLabels()["test"] != "me" {
+				t.Errorf("ExternalSecret labels = %v, want the labels of the options", externalSecret.GetLabels())
+			}
+
+			ready, err := tt.provider.IsReady(context.Background(), c, secretRef, nil)
+			if err != nil || ready {
+				t.Errorf("IsReady() = %v, %v before the Secret is synced, want not ready", ready, err)
+			}
+			err = c.Create(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace}})
+			if err != nil {
+				t.Fatalf("unable to create secret %v", err)
+			}
+			ready, err = tt.provider.IsReady(context.Background(), c, secretRef, nil)
+			if err != nil || !ready {
+				t.Errorf("IsReady() = %v, %v once the Secret is synced, want ready", ready, err)
+			}
+		})
+	}
+}
+
+func TestVaultCSI_Reconcile(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: "creds"}
+	tests := []struct {
+		name        string
+		provider    VaultCSI
+		wantErr     error
+		wantAddress bool
+	}{
+		{
+			name:     "role and secret path, must be reconciled",
+			provider: VaultCSI{Role: "transfer", SecretPath: "secret/data/transfer"},
+		},
+		{
+			name:        "vault address, must be set in the parameters",
+			provider:    VaultCSI{Address: "https://vault:8200", Role: "transfer", SecretPath: "secret/data/transfer"},
+			wantAddress: true,
+		},
+		{
+			name:     "role missing, must return ErrProviderMisconfigured",
+			provider: VaultCSI{SecretPath: "secret/data/transfer"},
+			wantErr:  ErrProviderMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().Build()
+			err := tt.provider.Reconcile(context.Background(), c, testr.New(t), secretRef,
+				[]string{"server.crt", "server.key", "ca.crt"}, &transport.Options{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ready, err := tt.provider.IsReady(context.Background(), c, secretRef, nil)
+			if err != nil || !ready {
+				t.Fatalf("IsReady() = %v, %v, want ready", ready, err)
+			}
+			spc := newUnstructured(secretProviderClassGVK, secretRef)
+			if err := c.Get(context.Background(), secretRef, spc); err != nil {
+				t.Fatalf("unable to get SecretProviderClass %v", err)
+			}
+			provider, _, _ := unstructured.NestedString(spc.Object, "spec", "provider")
+			objects, _, _ := unstructured.NestedString(spc.Object, "spec", "parameters", "objects")
+			_, hasAddress, _ := unstructured.NestedString(spc.Object, "spec", "parameters", "vaultAddress")
+			if provider != "vault" || hasAddress != tt.wantAddress {
+				t.Errorf("SecretProviderClass spec = %v", spc.Object["spec"])
+			}
+			for _, key := range []string{"server.crt", "server.key", "ca.crt"} {
+				if !strings.Contains(objects, `objectName: "`+key+`"`) {
+					t.Errorf("SecretProviderClass objects do not mount %s:\n%s", key, objects)
+				}
+			}
+
+			volumeSource := tt.provider.VolumeSource(secretRef, nil)
+			if volumeSource.CSI == nil || volumeSource.CSI.Driver != SecretsStoreCSIDriver ||
+				volumeSource.CSI.VolumeAttributes["secretProviderClass"] != secretRef.Name {
+				t.Errorf("VolumeSource() = %v, want a CSI volume of the SecretProviderClass", volumeSource)
+			}
+		})
+	}
+}
+
+func TestSecret_IsReady(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: "creds"}
+	items := []corev1.KeyToPath{{Key: "ca.crt", Path: "ca.crt"}}
+	c := fake.NewClientBuilder().Build()
+	ready, err := Secret{}.IsReady(context.Background(), c, secretRef, nil)
+	if err != nil || ready {
+		t.Errorf("IsReady() = %v, %v without the Secret, want not ready", ready, err)
+	}
+	err = c.Create(context.Background(), &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace}})
+	if err != nil {
+		t.Fatalf("unable to create secret %v", err)
+	}
+	ready, err = Secret{}.IsReady(context.Background(), c, secretRef, nil)
+	if err != nil || !ready {
+		t.Errorf("IsReady() = %v, %v, want ready", ready, err)
+	}
+	volumeSource := Secret{}.VolumeSource(secretRef, items)
+	if volumeSource.Secret == nil || volumeSource.Secret.SecretName != secretRef.Name || len(volumeSource.Secret.Items) != 1 {
+		t.Errorf("VolumeSource() = %v, want the items of the Secret", volumeSource)
+	}
+}
@@ -0,0 +1,144 @@
+package credentials
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// ServingCertSecretAnnotation makes the OpenShift service CA issue a certificate for the
+	// annotated Service, stored in the kubernetes.io/tls secret named by the annotation
+	ServingCertSecretAnnotation = "service.beta.openshift.io/serving-cert-secret-name"
+	// InjectCABundleAnnotation makes the OpenShift service CA inject its CA bundle in the
+	// annotated ConfigMap
+	InjectCABundleAnnotation = "service.beta.openshift.io/inject-cabundle"
+	// ServiceCABundleKey is the key of the CA bundle injected in ConfigMaps
+	ServiceCABundleKey = "service-ca.crt"
+
+	serverKey = "server.key"
+)
+
+// ServiceCA reads the credentials of transport servers from the certificate issued by the
+// OpenShift service CA for the Service of their endpoint, and trusts the service CA bundle in
+// transport clients. It is meant for transfers between namespaces of the same OpenShift cluster,
+// the service CA issues no client certificates so clients do not authenticate to the server.
+//
+// The transport server is recognized by server.key among the keys it reads. Its certificate
+// is stored in the secret of the credentials, the CA bundle of the clients is injected in a
+// ConfigMap named after the secret of their credentials.
+type ServiceCA struct {
+	// Service is the name of the Service of the endpoint of the transport server, plans
+	// default it to the Service of their endpoint
+	Service string
+}
+
+// +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+
+// Reconcile annotates the Service of the transport server for the service CA to issue its
+// certificate, or creates the ConfigMap receiving the service CA bundle for transport clients
+func (s ServiceCA) Reconcile(ctx context.Context, c client.Client, logger logr.Logger, secretRef types.NamespacedName, keys []string, options *transport.Options) error {
+	if !isServer(keys) {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace}}
+		_, err := reconcile.CreateOrUpdate(ctx, c, logger, cm, reconcileOptions(options), func() error {
+			cm.Labels = options.Labels
+			cm.OwnerReferences = options.Owners
+			if cm.Annotations == nil {
+				cm.Annotations = map[string]string{}
+			}
+			cm.Annotations[InjectCABundleAnnotation] = "true"
+			return nil
+		})
+		return err
+	}
+
+	if s.Service == "" {
+		return fmt.Errorf("%w: ServiceCA requires the name of the Service of the endpoint", ErrProviderMisconfigured)
+	}
+	service := &corev1.Service{}
+	err := c.Get(ctx, types.NamespacedName{Namespace: secretRef.Namespace, Name: s.Service}, service)
+	if err != nil {
+		return err
+	}
+	if service.Annotations[ServingCertSecretAnnotation] == secretRef.Name {
+		return nil
+	}
+	// only the annotation is patched, the Service is reconciled by the endpoint
+	patch := client.MergeFrom(service.DeepCopy())
+	if service.Annotations == nil {
+		service.Annotations = map[string]string{}
+	}
+	service.Annotations[ServingCertSecretAnnotation] = secretRef.Name
+	logger.Info("requesting serving certificate from the service CA", "service", s.Service, "secret", secretRef)
+	return c.Patch(ctx, service, patch)
+}
+
+// IsReady returns whether the service CA issued the certificate of the server or injected
+// the CA bundle of the clients
+func (s ServiceCA) IsReady(ctx context.Context, c client.Client, secretRef types.NamespacedName, keys []string) (bool, error) {
+	if isServer(keys) {
+		return secretExists(ctx, c, secretRef)
+	}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, secretRef, cm)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	_, ok := cm.Data[ServiceCABundleKey]
+	return ok, nil
+}
+
+// VolumeSource returns the volume source projecting the certificate of the server or the CA
+// bundle of the clients to the paths of the items
+func (s ServiceCA) VolumeSource(secretRef types.NamespacedName, items []corev1.KeyToPath) corev1.VolumeSource {
+	serverItems := []corev1.KeyToPath{}
+	for _, item := range items {
+		switch item.Key {
+		case "server.crt":
+			serverItems = append(serverItems, corev1.KeyToPath{Key: corev1.TLSCertKey, Path: item.Path})
+		case serverKey:
+			serverItems = append(serverItems, corev1.KeyToPath{Key: corev1.TLSPrivateKeyKey, Path: item.Path})
+		}
+	}
+	if len(serverItems) == 2 {
+		return secretVolumeSource(secretRef, serverItems)
+	}
+	return corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: secretRef.Name},
+			Items:                []corev1.KeyToPath{{Key: ServiceCABundleKey, Path: "ca.crt"}},
+		},
+	}
+}
+
+// OneWayTLS returns true, the service CA issues no client certificates
+func (s ServiceCA) OneWayTLS() bool {
+	return true
+}
+
+func isServer(keys []string) bool {
+	for _, key := range keys {
+		if key == serverKey {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	_ transport.CredentialsProvider = ServiceCA{}
+	_ transport.OneWayTLSProvider   = ServiceCA{}
+)
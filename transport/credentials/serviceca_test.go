@@ -0,0 +1,117 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServiceCA_Reconcile(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: "creds"}
+	serverKeys := []string{"server.crt", "server.key", "ca.crt"}
+	clientKeys := []string{"client.crt", "client.key", "ca.crt"}
+	tests := []struct {
+		name     string
+		provider ServiceCA
+		keys     []string
+		wantErr  error
+	}{
+		{
+			name:     "server, must annotate the service for a serving certificate",
+			provider: ServiceCA{Service: "transfer"},
+			keys:     serverKeys,
+		},
+		{
+			name:     "server without service, must return ErrProviderMisconfigured",
+			provider: ServiceCA{},
+			keys:     serverKeys,
+			wantErr:  ErrProviderMisconfigured,
+		},
+		{
+			name:     "client, must create the configmap receiving the CA bundle",
+			provider: ServiceCA{},
+			keys:     clientKeys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			c := fake.NewClientBuilder().WithObjects(&corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "transfer", Namespace: secretRef.Namespace},
+			}).Build()
+			err := tt.provider.Reconcile(ctx, c, testr.New(t), secretRef, tt.keys,
+				&transport.Options{Labels: map[string]string{"test": "me"}})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Reconcile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			ready, err := tt.provider.IsReady(ctx, c, secretRef, tt.keys)
+			if err != nil || ready {
+				t.Errorf("IsReady() = %v, %v before the service CA issued the credentials, want not ready", ready, err)
+			}
+
+			if tt.provider.Service != "" {
+				service := &corev1.Service{}
+				err = c.Get(ctx, types.NamespacedName{Namespace: secretRef.Namespace, Name: tt.provider.Service}, service)
+				if err != nil {
+					t.Fatalf("unable to get service %v", err)
+				}
+				if service.Annotations[ServingCertSecretAnnotation] != secretRef.Name {
+					t.Errorf("service annotations = %v, want the serving certificate in %s", service.Annotations, secretRef.Name)
+				}
+				err = c.Create(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace}})
+				if err != nil {
+					t.Fatalf("unable to create secret %v", err)
+				}
+			} else {
+				cm := &corev1.ConfigMap{}
+				err = c.Get(ctx, secretRef, cm)
+				if err != nil {
+					t.Fatalf("unable to get configmap %v", err)
+				}
+				if cm.Annotations[InjectCABundleAnnotation] != "true" || cm.Labels["test"] != "me" {
+					t.Errorf("configmap metadata = %v, want the CA bundle injected", cm.ObjectMeta)
+				}
+				cm.Data = map[string]string{ServiceCABundleKey: "bundle"}
+				if err := c.Update(ctx, cm); err != nil {
+					t.Fatalf("unable to update configmap %v", err)
+				}
+			}
+			ready, err = tt.provider.IsReady(ctx, c, secretRef, tt.keys)
+			if err != nil || !ready {
+				t.Errorf("IsReady() = %v, %v once the service CA issued the credentials, want ready", ready, err)
+			}
+		})
+	}
+}
+
+func TestServiceCA_VolumeSource(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "foo", Name: "creds"}
+	items := func(component string) []corev1.KeyToPath {
+		return []corev1.KeyToPath{
+			{Key: component + ".crt", Path: component + ".crt"},
+			{Key: component + ".key", Path: component + ".key"},
+			{Key: "ca.crt", Path: "ca.crt"},
+		}
+	}
+	server := ServiceCA{}.VolumeSource(secretRef, items("server"))
+	if server.Secret == nil || len(server.Secret.Items) != 2 ||
+		server.Secret.Items[0] != (corev1.KeyToPath{Key: corev1.TLSCertKey, Path: "server.crt"}) ||
+		server.Secret.Items[1] != (corev1.KeyToPath{Key: corev1.TLSPrivateKeyKey, Path: "server.key"}) {
+		t.Errorf("VolumeSource() = %v, want the serving certificate", server)
+	}
+	client := ServiceCA{}.VolumeSource(secretRef, items("client"))
+	if client.ConfigMap == nil || client.ConfigMap.Name != secretRef.Name ||
+		client.ConfigMap.Items[0] != (corev1.KeyToPath{Key: ServiceCABundleKey, Path: "ca.crt"}) {
+		t.Errorf("VolumeSource() = %v, want the service CA bundle", client)
+	}
+}
@@ -0,0 +1,194 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/null"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var (
+	// ErrTypeNotRegistered is returned when a transport type is not found in the registry
+	ErrTypeNotRegistered = errors.New("transport type not registered")
+	// ErrTypeAlreadyRegistered is returned when registering a transport type twice
+	ErrTypeAlreadyRegistered = errors.New("transport type already registered")
+	// ErrCapabilityNotSupported is returned when transport options require a capability
+	// the transport type does not have
+	ErrCapabilityNotSupported = errors.New("transport capability not supported")
+)
+
+// Capabilities describe the transport options supported by a transport type
+type Capabilities struct {
+	// CredentialsTypes are the types of credentials the transport accepts
+	CredentialsTypes []transport.CredentialsType
+	// SupportsProxy is set for transports able to reach their server through a proxy
+	SupportsProxy bool
+	// SupportsTerminateOnCompletion is set for transports able to terminate on transfer completion
+	SupportsTerminateOnCompletion bool
+	// SupportsNativeSidecar is set for transports able to run as native sidecars
+	SupportsNativeSidecar bool
+}
+
+// ServerConstructor creates the transport server of a registered type
+type ServerConstructor func(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	e endpoint.Endpoint,
+	options *transport.Options) (transport.Transport, error)
+
+// ClientConstructor creates the transport client of a registered type
+type ClientConstructor func(ctx context.Context, c client.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	hostname string,
+	connectPort int32,
+	options *transport.Options) (transport.Transport, error)
+
+// Registration describes a transport type known to the factory
+type Registration struct {
+	Capabilities Capabilities
+	NewServer    ServerConstructor
+	NewClient    ClientConstructor
+}
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[transport.Type]Registration{}
+)
+
+func init() {
+	err := Register(stunnel.TransportTypeStunnel, Registration{
+		Capabilities: Capabilities{
+			CredentialsTypes:              []transport.CredentialsType{stunnel.CredentialsTypeSSL, stunnel.CredentialsTypePSK},
+			SupportsProxy:                 true,
+			SupportsTerminateOnCompletion: true,
+			SupportsNativeSidecar:         true,
+		},
+		NewServer: stunnel.NewServer,
+		NewClient: stunnel.NewClient,
+	})
+	if err != nil {
+		panic(err)
+	}
+	err = Register(null.TransportTypeNull, Registration{
+		NewServer: null.NewServer,
+		NewClient: null.NewClient,
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Register adds a transport type to the registry, it is expected to be called from init functions
+func Register(t transport.Type, r Registration) error {
+	if r.NewServer == nil || r.NewClient == nil {
+		return fmt.Errorf("constructors of transport type %s are not set", t)
+	}
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if _, ok := registry[t]; ok {
+		return fmt.Errorf("%w: %s", ErrTypeAlreadyRegistered, t)
+	}
+	registry[t] = r
+	return nil
+}
+
+// Lookup returns the registration of a transport type
+func Lookup(t transport.Type) (Registration, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	r, ok := registry[t]
+	if !ok {
+		return Registration{}, fmt.Errorf("%w: %s", ErrTypeNotRegistered, t)
+	}
+	return r, nil
+}
+
+// RegisteredTypes returns the sorted list of registered transport types
+func RegisteredTypes() []transport.Type {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+	registered := make([]transport.Type, 0, len(registry))
+	for t := range registry {
+		registered = append(registered, t)
+	}
+	sort.Slice(registered, func(i, j int) bool { return registered[i] < registered[j] })
+	return registered
+}
+
+// Validate checks that the transport type is registered and supports the given options
+func Validate(t transport.Type, options *transport.Options) error {
+	r, err := Lookup(t)
+	if err != nil {
+		return err
+	}
+	if options == nil {
+		return nil
+	}
+	if options.Credentials != nil && options.Credentials.Type != "" {
+		supported := false
+		for _, credType := range r.Capabilities.CredentialsTypes {
+			if credType == options.Credentials.Type {
+				supported = true
+			}
+		}
+		if !supported {
+			return fmt.Errorf("%w: transport type %s does not accept credentials of type %s",
+				ErrCapabilityNotSupported, t, options.Credentials.Type)
+		}
+	}
+	if (options.ProxyURL != "" || options.ProxyCABundle != nil) && !r.Capabilities.SupportsProxy {
+		return fmt.Errorf("%w: transport type %s does not support proxies", ErrCapabilityNotSupported, t)
+	}
+	if options.TerminateOnCompletion && !r.Capabilities.SupportsTerminateOnCompletion {
+		return fmt.Errorf("%w: transport type %s does not terminate on completion", ErrCapabilityNotSupported, t)
+	}
+	if options.NativeSidecar && !r.Capabilities.SupportsNativeSidecar {
+		return fmt.Errorf("%w: transport type %s does not run as a native sidecar", ErrCapabilityNotSupported, t)
+	}
+	return nil
+}
+
+// NewServer validates the options and creates a transport server of the given registered type.
+// Refer the NewServer function of the transport package for details on using the transport.
+func NewServer(ctx context.Context, c client.Client, logger logr.Logger,
+	t transport.Type,
+	namespacedName types.NamespacedName,
+	e endpoint.Endpoint,
+	options *transport.Options) (transport.Transport, error) {
+	err := Validate(t, options)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Lookup(t)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewServer(ctx, c, logger, namespacedName, e, options)
+}
+
+// NewClient validates the options and creates a transport client of the given registered type.
+// Refer the NewClient function of the transport package for details on using the transport.
+func NewClient(ctx context.Context, c client.Client, logger logr.Logger,
+	t transport.Type,
+	namespacedName types.NamespacedName,
+	hostname string,
+	connectPort int32,
+	options *transport.Options) (transport.Transport, error) {
+	err := Validate(t, options)
+	if err != nil {
+		return nil, err
+	}
+	r, err := Lookup(t)
+	if err != nil {
+		return nil, err
+	}
+	return r.NewClient(ctx, c, logger, namespacedName, hostname, connectPort, options)
+}
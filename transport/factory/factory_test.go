@@ -0,0 +1,83 @@
+package factory
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/null"
+	"github.com/backube/pvc-transfer/transport/stunnel"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		transportType transport.Type
+		options       *transport.Options
+		wantErr       error
+	}{
+		{
+			name:          "stunnel with PSK credentials and proxy, must be valid",
+			transportType: stunnel.TransportTypeStunnel,
+			options: &transport.Options{
+				ProxyURL:    "proxy.example.com:3128",
+				Credentials: &transport.Credentials{Type: stunnel.CredentialsTypePSK},
+			},
+		},
+		{
+			name:          "stunnel with unknown credentials type, must return ErrCapabilityNotSupported",
+			transportType: stunnel.TransportTypeStunnel,
+			options: &transport.Options{
+				Credentials: &transport.Credentials{Type: "SSH"},
+			},
+			wantErr: ErrCapabilityNotSupported,
+		},
+		{
+			name:          "null transport as a native sidecar, must return ErrCapabilityNotSupported",
+			transportType: null.TransportTypeNull,
+			options:       &transport.Options{NativeSidecar: true},
+			wantErr:       ErrCapabilityNotSupported,
+		},
+		{
+			name:          "unknown transport type, must return ErrTypeNotRegistered",
+			transportType: "wireguard",
+			options:       &transport.Options{},
+			wantErr:       ErrTypeNotRegistered,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.transportType, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = corev1.AddToScheme(scheme)
+	c := fake.NewClientBuilder().WithScheme(scheme).Build()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+
+	got, err := NewClient(context.Background(), c, testr.New(t), stunnel.TransportTypeStunnel,
+		namespacedName, "example-test.com", 443, &transport.Options{})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	if got.Type() != stunnel.TransportTypeStunnel {
+		t.Errorf("Type() = %v, want %v", got.Type(), stunnel.TransportTypeStunnel)
+	}
+	want := []transport.Type{null.TransportTypeNull, stunnel.TransportTypeStunnel}
+	if !reflect.DeepEqual(RegisteredTypes(), want) {
+		t.Errorf("RegisteredTypes() = %v, want %v", RegisteredTypes(), want)
+	}
+}
@@ -0,0 +1,29 @@
+package transport
+
+import (
+	"fmt"
+	"regexp"
+)
+
+var (
+	// imageReferenceRegexp matches image references of the form [registry[:port]/]repository[:tag][@digest]
+	imageReferenceRegexp = regexp.MustCompile(`^(?:[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?(?:\.[a-zA-Z0-9](?:[a-zA-Z0-9-]*[a-zA-Z0-9])?)*(?::[0-9]+)?/)?` +
+		`[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*` +
+		`(?::[a-zA-Z0-9_][a-zA-Z0-9_.-]{0,127})?` +
+		`(@[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,})?$`)
+)
+
+// ValidateImage returns an error wrapping ErrImageInvalid when image is not a valid image
+// reference, or when requireDigest is set and image is not pinned to a digest, e.g.
+// quay.io/konveyor/rsync-transfer@sha256:<hex>. Pinned images allow mirroring the exact images
+// of each component in disconnected clusters.
+func ValidateImage(image string, requireDigest bool) error {
+	matches := imageReferenceRegexp.FindStringSubmatch(image)
+	if matches == nil {
+		return fmt.Errorf("%w: %q is not a valid image reference", ErrImageInvalid, image)
+	}
+	if requireDigest && matches[1] == "" {
+		return fmt.Errorf("%w: %q is not pinned to a digest", ErrImageInvalid, image)
+	}
+	return nil
+}
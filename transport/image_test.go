@@ -0,0 +1,64 @@
+package transport
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateImage(t *testing.T) {
+	digest := "sha256:2a5a4e7bd5b4e4a7a0d0d7c1d7c6b1ac0dfaf56c3a8a1e4a6a3e6cd1f0e0b6a1"
+	tests := []struct {
+		name          string
+		image         string
+		requireDigest bool
+		wantErr       error
+	}{
+		{
+			name:  "tagged image, must be valid",
+			image: "quay.io/konveyor/rsync-transfer:latest",
+		},
+		{
+			name:  "image of a registry with a port, must be valid",
+			image: "registry.local:5000/mirror/rsync-transfer:v1.0",
+		},
+		{
+			name:          "image pinned to a digest, must be valid when digests are required",
+			image:         "quay.io/konveyor/rsync-transfer@" + digest,
+			requireDigest: true,
+		},
+		{
+			name:          "tagged image pinned to a digest, must be valid when digests are required",
+			image:         "quay.io/konveyor/rsync-transfer:latest@" + digest,
+			requireDigest: true,
+		},
+		{
+			name:          "tagged image, must be invalid when digests are required",
+			image:         "quay.io/konveyor/rsync-transfer:latest",
+			requireDigest: true,
+			wantErr:       ErrImageInvalid,
+		},
+		{
+			name:    "uppercase repository, must be invalid",
+			image:   "quay.io/Konveyor/rsync-transfer",
+			wantErr: ErrImageInvalid,
+		},
+		{
+			name:    "truncated digest, must be invalid",
+			image:   "quay.io/konveyor/rsync-transfer@sha256:2a5a",
+			wantErr: ErrImageInvalid,
+		},
+		{
+			name:    "empty image, must be invalid",
+			image:   "",
+			wantErr: ErrImageInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateImage(tt.image, tt.requireDigest)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ValidateImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package null
+
+import (
+	"context"
+
+	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// TransportTypeNull is the transport which does not relay traffic, transfer clients connect
+// to the endpoint directly. It is meant for networks which already encrypt and authenticate
+// traffic, e.g. service meshes with mutual TLS, and for testing.
+const TransportTypeNull transport.Type = "null"
+
+// APIsToWatch give a list of APIs to watch if using this package
+// to deploy the transport
+func APIsToWatch() ([]ctrlclient.Object, error) {
+	return []ctrlclient.Object{}, nil
+}
+
+type null struct {
+	namespacedName types.NamespacedName
+	hostname       string
+	port           int32
+}
+
+// NewServer returns the null transport server of the endpoint, transfer servers listen on
+// the backend port of the endpoint. No resources are created.
+func NewServer(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	e endpoint.Endpoint,
+	options *transport.Options) (transport.Transport, error) {
+	return &null{
+		namespacedName: namespacedName,
+		hostname:       "0.0.0.0",
+		port:           e.BackendPort(),
+	}, nil
+}
+
+// NewClient returns the null transport client, transfer clients connect to hostname and
+// connectPort directly. No resources are created.
+func NewClient(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
+	namespacedName types.NamespacedName,
+	hostname string,
+	connectPort int32,
+	options *transport.Options) (transport.Transport, error) {
+	return &null{
+		namespacedName: namespacedName,
+		hostname:       hostname,
+		port:           connectPort,
+	}, nil
+}
+
+func (n *null) NamespacedName() types.NamespacedName {
+	return n.namespacedName
+}
+
+func (n *null) ListenPort() int32 {
+	return n.port
+}
+
+func (n *null) ConnectPort() int32 {
+	return n.port
+}
+
+func (n *null) Containers() []corev1.Container {
+	return []corev1.Container{}
+}
+
+func (n *null) Volumes() []corev1.Volume {
+	return []corev1.Volume{}
+}
+
+func (n *null) Type() transport.Type {
+	return TransportTypeNull
+}
+
+// Credentials returns an empty namespaced name, the null transport has no credentials
+func (n *null) Credentials() types.NamespacedName {
+	return types.NamespacedName{}
+}
+
+func (n *null) Hostname() string {
+	return n.hostname
+}
+
+func (n *null) ConnectionInfo() transport.ConnectionInfo {
+	return transport.ConnectionInfo{
+		Scheme:   transport.SchemeTCP,
+		Hostname: n.hostname,
+		Port:     n.port,
+	}
+}
+
+func (n *null) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	return true, nil
+}
+
+func (n *null) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, value string) error {
+	return nil
+}
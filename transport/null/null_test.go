@@ -0,0 +1,78 @@
+package null
+
+import (
+	"context"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr/testr"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type fakeEndpoint struct{}
+
+func (f fakeEndpoint) NamespacedName() types.NamespacedName {
+	return types.NamespacedName{Namespace: "bar", Name: "foo"}
+}
+
+func (f fakeEndpoint) Hostname() string {
+	return "foo.bar"
+}
+
+func (f fakeEndpoint) BackendPort() int32 {
+	return 1234
+}
+
+func (f fakeEndpoint) IngressPort() int32 {
+	return 443
+}
+
+func (f fakeEndpoint) IsHealthy(_ context.Context, _ ctrlclient.Client) (bool, error) {
+	return true, nil
+}
+
+func (f fakeEndpoint) MarkForCleanup(_ context.Context, _ ctrlclient.Client, _, _ string) error {
+	return nil
+}
+
+func TestNull_ConnectionInfo(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	server, err := NewServer(context.Background(), nil, testr.New(t), namespacedName, fakeEndpoint{}, &transport.Options{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	client, err := NewClient(context.Background(), nil, testr.New(t), namespacedName, "foo.bar", 443, &transport.Options{})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	tests := []struct {
+		name      string
+		transport transport.Transport
+		want      transport.ConnectionInfo
+	}{
+		{
+			name:      "server, transfer servers must listen on the endpoint backend port",
+			transport: server,
+			want:      transport.ConnectionInfo{Scheme: transport.SchemeTCP, Hostname: "0.0.0.0", Port: 1234},
+		},
+		{
+			name:      "client, transfer clients must connect to the endpoint directly",
+			transport: client,
+			want:      transport.ConnectionInfo{Scheme: transport.SchemeTCP, Hostname: "foo.bar", Port: 443},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.transport.ConnectionInfo(); got != tt.want {
+				t.Errorf("ConnectionInfo() = %v, want %v", got, tt.want)
+			}
+			if len(tt.transport.Containers()) != 0 || len(tt.transport.Volumes()) != 0 {
+				t.Error("null transport must not add containers or volumes")
+			}
+			if tt.transport.Credentials() != (types.NamespacedName{}) {
+				t.Errorf("Credentials() = %v, want none", tt.transport.Credentials())
+			}
+		})
+	}
+}
@@ -3,33 +3,64 @@ package stunnel
 import (
 	"bytes"
 	"context"
+	"net"
 	"text/template"
 
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const clientListenPort = 6443
 
 const (
+	// TCP keepalives detect servers gone without closing their connections, see the server template
 	stunnelClientConfTemplate = `
 pid =
-sslVersion = TLSv1.3
+foreground = {{ if .Foreground }}yes{{ else }}no{{ end }}
+{{ .TLSConfig }}
 client = yes
 syslog = no
 output = /dev/stdout
+socket = l:SO_KEEPALIVE=1
+socket = r:SO_KEEPALIVE=1
+socket = l:TCP_KEEPIDLE=60
+socket = r:TCP_KEEPIDLE=60
+socket = l:TCP_KEEPINTVL=10
+socket = r:TCP_KEEPINTVL=10
+socket = l:TCP_KEEPCNT=6
+socket = r:TCP_KEEPCNT=6
 {{ if .UseTLS }}
+{{- if not .OneWayTLS }}
 key = /etc/stunnel/certs/client.key
 cert = /etc/stunnel/certs/client.crt
+{{- end }}
+{{- if .ProxyCABundle }}
+CAfile = /etc/stunnel/ca-bundle/ca.crt
+{{- else if .PinServerCertificate }}
+CAfile = /etc/stunnel/certs/server.crt
+{{- else }}
 CAfile = /etc/stunnel/certs/ca.crt
+{{- end }}
+{{- if .PinServerCertificate }}
+verifyPeer = yes
+{{- else }}
 verify = 2
+{{- end }}
+{{- if not (eq .CheckHost "") }}
+checkHost = {{ .CheckHost }}
+{{- end }}
+{{- if not (eq .CheckIP "") }}
+checkIP = {{ .CheckIP }}
+{{- end }}
+{{- if not (eq .ServerName "") }}
+sni = {{ .ServerName }}
+{{- end }}
 {{ else }}
-ciphers = PSK
 PSKsecrets = /etc/stunnel/certs/key
 {{ end }}
 
@@ -39,7 +70,7 @@ accept = {{ .ListenPort }}
 {{- if not (eq .ProxyHost "") }}
 protocol = connect
 connect = {{ .ProxyHost }}
-protocolHost = {{ .Hostname }}:{{ .ListenPort }}
+protocolHost = {{ .Hostname }}:{{ .ConnectPort }}
 {{- if not (eq .ProxyUsername "") }}
 protocolUsername = {{ .ProxyUsername }}
 {{- end }}
@@ -99,6 +130,32 @@ func (sc *client) Credentials() types.NamespacedName {
 	return getCredentialsSecretRef(sc, sc.options.Credentials)
 }
 
+func (sc *client) ConnectionInfo() transport.ConnectionInfo {
+	return transport.ConnectionInfo{
+		Scheme:         transport.SchemeTCP,
+		Hostname:       sc.Hostname(),
+		Port:           sc.ListenPort(),
+		RequiresProxy:  sc.options.ProxyURL != "",
+		CredentialsRef: sc.Credentials(),
+	}
+}
+
+func (sc *client) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	return isHealthy(ctx, c, sc.logger, sc, sc.options, "client", sc.serverHostname)
+}
+
+func (sc *client) TerminatesOnCompletion() bool {
+	return sc.options.TerminateOnCompletion && !sc.options.NativeSidecar
+}
+
+func (sc *client) RunsAsNativeSidecar() bool {
+	return sc.options.NativeSidecar
+}
+
+func (sc *client) ImagePullSecrets() []corev1.LocalObjectReference {
+	return sc.options.ImagePullSecrets
+}
+
 func (sc *client) Hostname() string {
 	return "localhost"
 }
@@ -109,22 +166,37 @@ func (sc *client) Hostname() string {
 // Before passing the client c make sure to call AddToScheme() if core types are not already registered
 // In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
 // +kubebuilder:rbac:groups=core,resources=configmaps,secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 func NewClient(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	namespacedName types.NamespacedName,
 	hostname string,
 	connectPort int32,
 	options *transport.Options) (transport.Transport, error) {
 	clientLogger := logger.WithValues("stunnelClient", namespacedName)
+	err := transport.ValidateImage(getImage(options), options.RequireImageDigests)
+	if err != nil {
+		return nil, err
+	}
+	if options.ProxyCABundle != nil {
+		err = options.ProxyCABundle.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	listenPort, err := getPort(options.ClientListenPort, clientListenPort)
+	if err != nil {
+		return nil, err
+	}
 	tc := &client{
 		logger:         clientLogger,
 		namespacedName: namespacedName,
 		options:        options,
 		connectPort:    connectPort,
 		serverHostname: hostname,
-		listenPort:     clientListenPort,
+		listenPort:     listenPort,
 	}
 
-	err := tc.reconcileConfig(ctx, c)
+	err = tc.reconcileConfig(ctx, c)
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +212,15 @@ func NewClient(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	return tc, nil
 }
 
+// Reconcile restores the stunnel configuration and the credentials of the client
+func (sc *client) Reconcile(ctx context.Context, c ctrlclient.Client) error {
+	err := sc.reconcileConfig(ctx, c)
+	if err != nil {
+		return err
+	}
+	return sc.reconcileSecret(ctx, c)
+}
+
 func (sc *client) reconcileConfig(ctx context.Context, c ctrlclient.Client) error {
 	stunnelConfTemplate, err := template.New("config").Parse(stunnelClientConfTemplate)
 	if err != nil {
@@ -155,6 +236,21 @@ func (sc *client) reconcileConfig(ctx context.Context, c ctrlclient.Client) erro
 		ProxyUsername string
 		ProxyPassword string
 		UseTLS        bool
+		TLSConfig     string
+		// PinServerCertificate trusts only the server certificate instead of the CA
+		PinServerCertificate bool
+		// ProxyCABundle trusts the CA bundle combining the CAs of the credentials and the proxy
+		ProxyCABundle bool
+		// CheckHost or CheckIP is the identity expected in the server certificate
+		CheckHost string
+		CheckIP   string
+		// ServerName is sent in the TLS server name indication
+		ServerName string
+		// Foreground keeps stunnel attached to the supervisor terminating it on completion, or
+		// to the native sidecar container
+		Foreground bool
+		// OneWayTLS presents no client certificate to the server
+		OneWayTLS bool
 	}
 
 	fields := confFields{
@@ -169,6 +265,23 @@ func (sc *client) reconcileConfig(ctx context.Context, c ctrlclient.Client) erro
 	if sc.options.Credentials != nil && sc.options.Credentials.Type == CredentialsTypePSK {
 		fields.UseTLS = false
 	}
+	fields.TLSConfig, err = getTLSConfig(sc.options, !fields.UseTLS)
+	if err != nil {
+		return err
+	}
+	fields.OneWayTLS = oneWayTLS(sc.options.Credentials)
+	// one way providers issue no server certificate to the client, it trusts their CA
+	fields.PinServerCertificate = sc.options.PinServerCertificate && !fields.OneWayTLS
+	fields.ProxyCABundle = sc.usesProxyCABundle()
+	fields.Foreground = sc.TerminatesOnCompletion() || sc.RunsAsNativeSidecar()
+	fields.ServerName = sc.options.ServerName
+	if sc.options.VerifyServerHostname {
+		if net.ParseIP(sc.serverHostname) != nil {
+			fields.CheckIP = sc.serverHostname
+		} else {
+			fields.CheckHost = sc.serverHostname
+		}
+	}
 	var stunnelConf bytes.Buffer
 	err = stunnelConfTemplate.Execute(&stunnelConf, fields)
 	if err != nil {
@@ -182,7 +295,7 @@ func (sc *client) reconcileConfig(ctx context.Context, c ctrlclient.Client) erro
 			Name:      getResourceName(sc.namespacedName, "client", stunnelConfig),
 		},
 	}
-	_, err = controllerutil.CreateOrUpdate(ctx, c, stunnelConfigMap, func() error {
+	_, err = reconcile.CreateOrUpdate(ctx, c, sc.logger, stunnelConfigMap, reconcileOptions(sc.options), func() error {
 		stunnelConfigMap.Labels = sc.options.Labels
 		stunnelConfigMap.OwnerReferences = sc.options.Owners
 
@@ -195,18 +308,57 @@ func (sc *client) reconcileConfig(ctx context.Context, c ctrlclient.Client) erro
 }
 
 func (sc *client) reconcileSecret(ctx context.Context, c ctrlclient.Client) error {
-	return reconcileCredentialSecret(ctx, c, sc.logger, sc, sc.options)
+	return reconcileCredentialSecret(ctx, c, sc.logger, sc, sc.options, "client", sc.serverHostname)
 }
 
 func (sc *client) clientContainers(listenPort int32) []corev1.Container {
-	return []corev1.Container{
+	command := []string{
+		"/bin/stunnel",
+		"/etc/stunnel/stunnel.conf",
+	}
+	volumeMounts := []corev1.VolumeMount{
 		{
-			Name:  Container,
-			Image: getImage(sc.options),
-			Command: []string{
-				"/bin/stunnel",
-				"/etc/stunnel/stunnel.conf",
+			Name:      getResourceName(sc.namespacedName, "client", stunnelConfig),
+			MountPath: "/etc/stunnel/stunnel.conf",
+			SubPath:   "stunnel.conf",
+		},
+		{
+			Name:      getResourceName(sc.namespacedName, "certs", stunnelSecret),
+			MountPath: "/etc/stunnel/certs",
+		},
+	}
+	if sc.TerminatesOnCompletion() {
+		command = getSupervisedCommand()
+		volumeMounts = append(volumeMounts, getCompletionVolumeMount())
+	}
+	if sc.usesProxyCABundle() {
+		caFile := "/etc/stunnel/certs/ca.crt"
+		if sc.options.PinServerCertificate {
+			caFile = "/etc/stunnel/certs/server.crt"
+		}
+		command = getProxyCABundleCommand(command, caFile)
+		volumeMounts = append(volumeMounts,
+			corev1.VolumeMount{
+				Name:      getResourceName(sc.namespacedName, "proxy-ca", stunnelConfig),
+				MountPath: "/etc/stunnel/proxy-ca",
 			},
+			corev1.VolumeMount{
+				Name:      getResourceName(sc.namespacedName, "ca-bundle", stunnelConfig),
+				MountPath: "/etc/stunnel/ca-bundle",
+			})
+	}
+	return []corev1.Container{
+		{
+			Name:            Container,
+			Image:           getImage(sc.options),
+			Command:         command,
+			ImagePullPolicy: sc.options.ImagePullPolicy,
+			ReadinessProbe:  getReadinessProbe(),
+			LivenessProbe:   getLivenessProbe(sc.RunsAsNativeSidecar()),
+			Resources:       sc.options.Resources,
+			SecurityContext: sc.options.ContainerSecurityContext.DeepCopy(),
+			Env:             sc.options.Env,
+			EnvFrom:         sc.options.EnvFrom,
 			Ports: []corev1.ContainerPort{
 				{
 					Name:          "stunnel",
@@ -214,23 +366,19 @@ func (sc *client) clientContainers(listenPort int32) []corev1.Container {
 					ContainerPort: listenPort,
 				},
 			},
-			VolumeMounts: []corev1.VolumeMount{
-				{
-					Name:      getResourceName(sc.namespacedName, "client", stunnelConfig),
-					MountPath: "/etc/stunnel/stunnel.conf",
-					SubPath:   "stunnel.conf",
-				},
-				{
-					Name:      getResourceName(sc.namespacedName, "certs", stunnelSecret),
-					MountPath: "/etc/stunnel/certs",
-				},
-			},
+			VolumeMounts: volumeMounts,
 		},
 	}
 }
 
 func (sc *client) clientVolumes() []corev1.Volume {
-	return []corev1.Volume{
+	credentialsVolumeSource := getCredentialsVolumeSource(getCredentialsSecretRef(sc, sc.options.Credentials), sc.options, "client")
+	if sc.options.PinServerCertificate && !isPSK(sc.options.Credentials) && credentialsVolumeSource.Secret != nil {
+		// the pinned server certificate is used as the CAfile of the client
+		credentialsVolumeSource.Secret.Items = append(credentialsVolumeSource.Secret.Items,
+			corev1.KeyToPath{Key: "server.crt", Path: "server.crt"})
+	}
+	volumes := []corev1.Volume{
 		{
 			Name: getResourceName(sc.namespacedName, "client", stunnelConfig),
 			VolumeSource: corev1.VolumeSource{
@@ -243,7 +391,30 @@ func (sc *client) clientVolumes() []corev1.Volume {
 		},
 		{
 			Name:         getResourceName(sc.namespacedName, "certs", stunnelSecret),
-			VolumeSource: getCredentialsVolumeSource(sc, sc.options.Credentials, "client"),
+			VolumeSource: credentialsVolumeSource,
 		},
 	}
+	if sc.TerminatesOnCompletion() {
+		volumes = append(volumes, getCompletionVolume())
+	}
+	if sc.usesProxyCABundle() {
+		volumes = append(volumes,
+			corev1.Volume{
+				Name:         getResourceName(sc.namespacedName, "proxy-ca", stunnelConfig),
+				VolumeSource: sc.options.ProxyCABundle.VolumeSource("proxy-ca.crt"),
+			},
+			corev1.Volume{
+				Name: getResourceName(sc.namespacedName, "ca-bundle", stunnelConfig),
+				VolumeSource: corev1.VolumeSource{
+					EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory},
+				},
+			})
+	}
+	return volumes
+}
+
+// usesProxyCABundle returns whether the client trusts the CA bundle of the proxy, PSK
+// credentials do not verify certificates
+func (sc *client) usesProxyCABundle() bool {
+	return sc.options.ProxyCABundle != nil && !isPSK(sc.options.Credentials)
 }
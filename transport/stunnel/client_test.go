@@ -1,17 +1,23 @@
 package stunnel
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/credentials"
 	"github.com/backube/pvc-transfer/transport/tls/certs"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/pointer"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -105,7 +111,7 @@ func TestNewClient(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			fakeClient := fakeClientWithObjects(tt.objects...)
 			ctx := context.WithValue(context.Background(), "test", tt.name)
-			fakeLogger := logrtesting.TestLogger{t}
+			fakeLogger := testr.New(t)
 			stunnelClient, err := NewClient(ctx, fakeClient, fakeLogger, tt.namespacedName, tt.hostname, tt.ingressPort, &transport.Options{Labels: tt.labels, Owners: tt.ownerReferences})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
@@ -309,7 +315,7 @@ func Test_client_reconcileSecret(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			sc := &client{
-				logger:         logrtesting.TestLogger{T: t},
+				logger:         testr.New(t),
 				connectPort:    6443,
 				listenPort:     6443,
 				options:        tt.options,
@@ -345,3 +351,480 @@ func Test_client_reconcileSecret(t *testing.T) {
 		})
 	}
 }
+
+func TestNewClient_serverIdentity(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	tests := []struct {
+		name          string
+		hostname      string
+		options       *transport.Options
+		wantConfig    []string
+		notWantConfig []string
+		wantServerCrt bool
+	}{
+		{
+			name:          "no identity options, must verify the CA chain only",
+			hostname:      "example-test.com",
+			options:       &transport.Options{},
+			wantConfig:    []string{"CAfile = /etc/stunnel/certs/ca.crt", "verify = 2"},
+			notWantConfig: []string{"checkHost", "checkIP", "verifyPeer"},
+		},
+		{
+			name:       "verify server hostname, must check the hostname",
+			hostname:   "example-test.com",
+			options:    &transport.Options{VerifyServerHostname: true},
+			wantConfig: []string{"verify = 2", "checkHost = example-test.com"},
+		},
+		{
+			name:       "verify server IP, must check the IP",
+			hostname:   "10.0.0.1",
+			options:    &transport.Options{VerifyServerHostname: true},
+			wantConfig: []string{"verify = 2", "checkIP = 10.0.0.1"},
+		},
+		{
+			name:          "pin server certificate, must trust only the server certificate",
+			hostname:      "example-test.com",
+			options:       &transport.Options{PinServerCertificate: true},
+			wantConfig:    []string{"CAfile = /etc/stunnel/certs/server.crt", "verifyPeer = yes"},
+			notWantConfig: []string{"verify = 2"},
+			wantServerCrt: true,
+		},
+		{
+			name:       "server name, must send it in the SNI",
+			hostname:   "10.0.0.1",
+			options:    &transport.Options{ServerName: "transfer-foo.apps.example.com"},
+			wantConfig: []string{"sni = transfer-foo.apps.example.com"},
+		},
+		{
+			name:          "no server name, must not set the SNI",
+			hostname:      "example-test.com",
+			options:       &transport.Options{},
+			notWantConfig: []string{"sni ="},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			stunnelClient, err := NewClient(context.Background(), fakeClient, testr.New(t), namespacedName, tt.hostname, 8080, tt.options)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.Background(), types.NamespacedName{
+				Namespace: "bar",
+				Name:      stunnelConfig + "-client-foo",
+			}, cm)
+			if err != nil {
+				t.Fatalf("unable to get stunnel config %v", err)
+			}
+			for _, want := range tt.wantConfig {
+				if !strings.Contains(cm.Data["stunnel.conf"], want) {
+					t.Errorf("stunnel config %q does not contain %q", cm.Data["stunnel.conf"], want)
+				}
+			}
+			for _, notWant := range tt.notWantConfig {
+				if strings.Contains(cm.Data["stunnel.conf"], notWant) {
+					t.Errorf("stunnel config %q contains %q", cm.Data["stunnel.conf"], notWant)
+				}
+			}
+
+			if tt.options.VerifyServerHostname {
+				secret := &corev1.Secret{}
+				err = fakeClient.Get(context.Background(), types.NamespacedName{
+					Namespace: "bar",
+					Name:      stunnelSecret + "-certs-foo",
+				}, secret)
+				if err != nil {
+					t.Fatalf("unable to get stunnel secret %v", err)
+				}
+				verified, err := certs.VerifyHostname(bytes.NewBuffer(secret.Data["server.crt"]), tt.hostname)
+				if err != nil || !verified {
+					t.Errorf("server.crt is not issued for %s, error = %v", tt.hostname, err)
+				}
+			}
+
+			hasServerCrt := false
+			for _, volume := range stunnelClient.Volumes() {
+				if volume.Secret == nil {
+					continue
+				}
+				for _, item := range volume.Secret.Items {
+					if item.Key == "server.crt" {
+						hasServerCrt = true
+					}
+				}
+			}
+			if hasServerCrt != tt.wantServerCrt {
+				t.Errorf("server.crt mounted = %v, want %v", hasServerCrt, tt.wantServerCrt)
+			}
+		})
+	}
+}
+
+func TestNewClient_ports(t *testing.T) {
+	tests := []struct {
+		name           string
+		options        *transport.Options
+		wantListenPort int32
+		wantErr        error
+	}{
+		{
+			name:           "no listen port, must use the default port",
+			options:        &transport.Options{},
+			wantListenPort: clientListenPort,
+		},
+		{
+			name:           "custom listen port, must listen on the custom port",
+			options:        &transport.Options{ClientListenPort: 7443},
+			wantListenPort: 7443,
+		},
+		{
+			name:    "negative listen port, must return ErrTransportPortInvalid",
+			options: &transport.Options{ClientListenPort: -1},
+			wantErr: transport.ErrTransportPortInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+			got, err := NewClient(context.Background(), fakeClient, testr.New(t), namespacedName, "example-test.com", 443, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got.ListenPort() != tt.wantListenPort {
+				t.Errorf("ListenPort() = %v, want %v", got.ListenPort(), tt.wantListenPort)
+			}
+			if port := got.Containers()[0].Ports[0].ContainerPort; port != tt.wantListenPort {
+				t.Errorf("container port = %v, want %v", port, tt.wantListenPort)
+			}
+			// the probes must not dial stunnel, every connection opens a session to the server
+			readiness := got.Containers()[0].ReadinessProbe
+			if readiness == nil || readiness.Exec == nil || readiness.TCPSocket != nil ||
+				strings.Contains(strings.Join(readiness.Exec.Command, " "), "/dev/tcp") {
+				t.Errorf("readiness probe = %v, want exec check of the stunnel process", readiness)
+			}
+			if liveness := got.Containers()[0].LivenessProbe; liveness != nil {
+				t.Errorf("liveness probe = %v, want none", liveness)
+			}
+		})
+	}
+}
+
+func TestNewClient_containerOptions(t *testing.T) {
+	options := &transport.Options{
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+		},
+		ContainerSecurityContext: corev1.SecurityContext{
+			RunAsNonRoot:             pointer.Bool(true),
+			AllowPrivilegeEscalation: pointer.Bool(false),
+			Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+		},
+		Env: []corev1.EnvVar{{Name: "HTTPS_PROXY", Value: "http://proxy.example.com:3128"}},
+		EnvFrom: []corev1.EnvFromSource{{
+			ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "proxy-env"}},
+		}},
+	}
+	got, err := NewClient(context.Background(), fakeClientWithObjects(), testr.New(t),
+		types.NamespacedName{Namespace: "bar", Name: "foo"}, "example-test.com", 443, options)
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	for _, container := range got.Containers() {
+		if !reflect.DeepEqual(container.Resources, options.Resources) {
+			t.Errorf("container %s resources = %v, want %v", container.Name, container.Resources, options.Resources)
+		}
+		if !reflect.DeepEqual(container.SecurityContext, &options.ContainerSecurityContext) {
+			t.Errorf("container %s security context = %v, want %v", container.Name, container.SecurityContext, options.ContainerSecurityContext)
+		}
+		if !reflect.DeepEqual(container.Env, options.Env) || !reflect.DeepEqual(container.EnvFrom, options.EnvFrom) {
+			t.Errorf("container %s env = %v, %v, want %v, %v", container.Name, container.Env, container.EnvFrom, options.Env, options.EnvFrom)
+		}
+	}
+}
+
+func TestNewClient_terminateOnCompletion(t *testing.T) {
+	tests := []struct {
+		name                  string
+		terminateOnCompletion bool
+		nativeSidecar         bool
+		wantForeground        string
+		wantSupervisor        bool
+	}{
+		{
+			name:           "default, must run stunnel directly",
+			wantForeground: "foreground = no",
+		},
+		{
+			name:                  "terminate on completion, must run stunnel under the supervisor",
+			terminateOnCompletion: true,
+			wantForeground:        "foreground = yes",
+			wantSupervisor:        true,
+		},
+		{
+			name:                  "native sidecar, must run stunnel in the foreground without the supervisor",
+			terminateOnCompletion: true,
+			nativeSidecar:         true,
+			wantForeground:        "foreground = yes",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			got, err := NewClient(context.Background(), fakeClient, testr.New(t),
+				types.NamespacedName{Namespace: "bar", Name: "foo"}, "example-test.com", 443,
+				&transport.Options{TerminateOnCompletion: tt.terminateOnCompletion, NativeSidecar: tt.nativeSidecar})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if got.(transport.CompletionAware).TerminatesOnCompletion() != tt.wantSupervisor {
+				t.Errorf("TerminatesOnCompletion() = %v, want %v", !tt.wantSupervisor, tt.wantSupervisor)
+			}
+			if got.(transport.NativeSidecarAware).RunsAsNativeSidecar() != tt.nativeSidecar {
+				t.Errorf("RunsAsNativeSidecar() = %v, want %v", !tt.nativeSidecar, tt.nativeSidecar)
+			}
+			if liveness := got.Containers()[0].LivenessProbe; (liveness != nil) != tt.nativeSidecar {
+				t.Errorf("liveness probe = %v, want one for native sidecars only", liveness)
+			}
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-client-foo"}, cm)
+			if err != nil {
+				t.Fatalf("unable to get stunnel config %v", err)
+			}
+			if !strings.Contains(cm.Data["stunnel.conf"], tt.wantForeground) {
+				t.Errorf("stunnel config %q does not contain %q", cm.Data["stunnel.conf"], tt.wantForeground)
+			}
+			command := strings.Join(got.Containers()[0].Command, " ")
+			if supervised := strings.Contains(command, transport.CompletionFile); supervised != tt.wantSupervisor {
+				t.Errorf("stunnel command %q supervised = %v, want %v", command, supervised, tt.wantSupervisor)
+			}
+			hasCompletionVolume := false
+			for _, volume := range got.Volumes() {
+				if volume.Name == transport.CompletionVolumeName {
+					hasCompletionVolume = true
+				}
+			}
+			if hasCompletionVolume != tt.wantSupervisor {
+				t.Errorf("completion volume = %v, want %v", hasCompletionVolume, tt.wantSupervisor)
+			}
+		})
+	}
+}
+
+func TestNewClient_proxyCABundle(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     *transport.Options
+		wantConfig  string
+		wantCAFile  string
+		wantVolumes bool
+		wantErr     error
+	}{
+		{
+			name: "no bundle, must trust the CA of the credentials",
+			options: &transport.Options{
+				ProxyURL: "proxy.example.com:3128",
+			},
+			wantConfig: "CAfile = /etc/stunnel/certs/ca.crt",
+		},
+		{
+			name: "bundle in a configmap, must trust the combined bundle",
+			options: &transport.Options{
+				ProxyURL:      "proxy.example.com:3128",
+				ProxyCABundle: &transport.TrustBundle{ConfigMap: "trusted-ca"},
+			},
+			wantConfig:  "CAfile = /etc/stunnel/ca-bundle/ca.crt",
+			wantCAFile:  "/etc/stunnel/certs/ca.crt",
+			wantVolumes: true,
+		},
+		{
+			name: "bundle in a secret with a pinned server certificate, must combine the server certificate",
+			options: &transport.Options{
+				ProxyURL:             "proxy.example.com:3128",
+				PinServerCertificate: true,
+				ProxyCABundle:        &transport.TrustBundle{Secret: "trusted-ca", Key: "ca.pem"},
+			},
+			wantConfig:  "CAfile = /etc/stunnel/ca-bundle/ca.crt\nverifyPeer = yes",
+			wantCAFile:  "/etc/stunnel/certs/server.crt",
+			wantVolumes: true,
+		},
+		{
+			name: "bundle referencing a configmap and a secret, must return ErrTrustBundleInvalid",
+			options: &transport.Options{
+				ProxyURL:      "proxy.example.com:3128",
+				ProxyCABundle: &transport.TrustBundle{ConfigMap: "trusted-ca", Secret: "trusted-ca"},
+			},
+			wantErr: transport.ErrTrustBundleInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			got, err := NewClient(context.Background(), fakeClient, testr.New(t),
+				types.NamespacedName{Namespace: "bar", Name: "foo"}, "example-test.com", 443, tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewClient() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-client-foo"}, cm)
+			if err != nil {
+				t.Fatalf("unable to get stunnel config %v", err)
+			}
+			if !strings.Contains(cm.Data["stunnel.conf"], tt.wantConfig) {
+				t.Errorf("stunnel config %q does not contain %q", cm.Data["stunnel.conf"], tt.wantConfig)
+			}
+			command := strings.Join(got.Containers()[0].Command, " ")
+			if tt.wantCAFile != "" && !strings.Contains(command, "cat "+tt.wantCAFile+" /etc/stunnel/proxy-ca/proxy-ca.crt") {
+				t.Errorf("command %q does not combine %s with the proxy CA bundle", command, tt.wantCAFile)
+			}
+			hasBundleVolume := false
+			for _, volume := range got.Volumes() {
+				if volume.Name == getResourceName(got.NamespacedName(), "proxy-ca", stunnelConfig) {
+					hasBundleVolume = true
+					if tt.options.ProxyCABundle.Secret != "" && volume.Secret.Items[0].Key != tt.options.ProxyCABundle.Key {
+						t.Errorf("bundle volume key = %s, want %s", volume.Secret.Items[0].Key, tt.options.ProxyCABundle.Key)
+					}
+					if tt.options.ProxyCABundle.ConfigMap != "" && volume.ConfigMap.Items[0].Key != transport.DefaultTrustBundleKey {
+						t.Errorf("bundle volume key = %s, want %s", volume.ConfigMap.Items[0].Key, transport.DefaultTrustBundleKey)
+					}
+				}
+			}
+			if hasBundleVolume != tt.wantVolumes {
+				t.Errorf("bundle volume = %v, want %v", hasBundleVolume, tt.wantVolumes)
+			}
+		})
+	}
+}
+
+func TestNewClient_serviceCA(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	got, err := NewClient(context.Background(), fakeClient, testr.New(t),
+		types.NamespacedName{Namespace: "bar", Name: "foo"}, "foo.bar.svc", 443, &transport.Options{
+			PinServerCertificate: true,
+			Credentials:          &transport.Credentials{Provider: credentials.ServiceCA{}},
+		})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	bundle := &corev1.ConfigMap{}
+	err = fakeClient.Get(context.Background(), got.Credentials(), bundle)
+	if err != nil {
+		t.Fatalf("unable to get the service CA bundle configmap %v", err)
+	}
+	if bundle.Annotations[credentials.InjectCABundleAnnotation] != "true" {
+		t.Errorf("service CA bundle annotations = %v, want the CA bundle injected", bundle.Annotations)
+	}
+	cm := &corev1.ConfigMap{}
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-client-foo"}, cm)
+	if err != nil {
+		t.Fatalf("unable to get stunnel config %v", err)
+	}
+	config := cm.Data["stunnel.conf"]
+	if strings.Contains(config, "client.crt") || strings.Contains(config, "verifyPeer") ||
+		!strings.Contains(config, "CAfile = /etc/stunnel/certs/ca.crt\nverify = 2") {
+		t.Errorf("stunnel config %q, want the service CA trusted without client certificate", config)
+	}
+	for _, volume := range got.Volumes() {
+		if volume.Name == getResourceName(got.NamespacedName(), "certs", stunnelSecret) &&
+			(volume.ConfigMap == nil || volume.ConfigMap.Items[0].Key != credentials.ServiceCABundleKey) {
+			t.Errorf("credentials volume = %v, want the service CA bundle", volume.VolumeSource)
+		}
+	}
+}
+
+func TestNewClient_proxyProtocolHost(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    *transport.Options
+		wantConfig string
+	}{
+		{
+			name:       "default listen port, must ask the proxy for the connect port of the server",
+			options:    &transport.Options{ProxyURL: "proxy.example.com:3128"},
+			wantConfig: "accept = 6443\nprotocol = connect\nconnect = proxy.example.com:3128\nprotocolHost = example-test.com:443\n",
+		},
+		{
+			name:       "custom listen port, must ask the proxy for the connect port of the server",
+			options:    &transport.Options{ProxyURL: "proxy.example.com:3128", ClientListenPort: 7000},
+			wantConfig: "accept = 7000\nprotocol = connect\nconnect = proxy.example.com:3128\nprotocolHost = example-test.com:443\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			_, err := NewClient(context.Background(), fakeClient, testr.New(t),
+				types.NamespacedName{Namespace: "bar", Name: "foo"}, "example-test.com", 443, tt.options)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-client-foo"}, cm)
+			if err != nil {
+				t.Fatalf("unable to get stunnel config %v", err)
+			}
+			if !strings.Contains(cm.Data["stunnel.conf"], tt.wantConfig) {
+				t.Errorf("stunnel config %q does not contain %q", cm.Data["stunnel.conf"], tt.wantConfig)
+			}
+		})
+	}
+}
+
+func TestClient_ConnectionInfo(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	tests := []struct {
+		name    string
+		options *transport.Options
+		want    transport.ConnectionInfo
+	}{
+		{
+			name:    "default options, must accept connections on the default listen port",
+			options: &transport.Options{},
+			want: transport.ConnectionInfo{
+				Scheme:         transport.SchemeTCP,
+				Hostname:       "localhost",
+				Port:           clientListenPort,
+				CredentialsRef: types.NamespacedName{Namespace: "bar", Name: stunnelSecret + "-certs-foo"},
+			},
+		},
+		{
+			name: "proxy and PSK credentials, must require the proxy and reference the credentials",
+			options: &transport.Options{
+				ClientListenPort: 7000,
+				ProxyURL:         "proxy.example.com:3128",
+				Credentials: &transport.Credentials{
+					Type:      CredentialsTypePSK,
+					SecretRef: types.NamespacedName{Namespace: "bar", Name: "psk"},
+				},
+			},
+			want: transport.ConnectionInfo{
+				Scheme:         transport.SchemeTCP,
+				Hostname:       "localhost",
+				Port:           7000,
+				RequiresProxy:  true,
+				CredentialsRef: types.NamespacedName{Namespace: "bar", Name: "psk"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewClient(context.Background(), fakeClientWithObjects(), testr.New(t), namespacedName, "example-test.com", 443, tt.options)
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			if info := got.ConnectionInfo(); !reflect.DeepEqual(info, tt.want) {
+				t.Errorf("ConnectionInfo() = %#v, want %#v", info, tt.want)
+			}
+			if address := got.ConnectionInfo().Address(); address != fmt.Sprintf("localhost:%d", tt.want.Port) {
+				t.Errorf("Address() = %s, want localhost:%d", address, tt.want.Port)
+			}
+		})
+	}
+}
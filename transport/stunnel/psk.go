@@ -0,0 +1,192 @@
+package stunnel
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/secrets"
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	pskKey      = "key"
+	pskIdentity = "root"
+	// pskExpiryAnnotation holds the expiry of the identities kept in the server secret
+	// during the grace period of a rotation, as a JSON map of identity to RFC3339 time
+	pskExpiryAnnotation = "pvc-transfer.backube.dev/psk-expiry"
+)
+
+// PSKSecretRef is a secret holding PSK credentials of one side of a transfer, the client
+// allows the secrets of the transport client and server to live in different clusters
+type PSKSecretRef struct {
+	Client    ctrlclient.Client
+	SecretRef types.NamespacedName
+	// Owners are applied to the secret, they must live in the cluster and the namespace of
+	// the secret
+	Owners []metav1.OwnerReference
+}
+
+// pskEntry is an identity and key pair in the PSKsecrets file of stunnel
+type pskEntry struct {
+	identity string
+	key      string
+}
+
+func newPSKEntry(identity string) (pskEntry, error) {
+	key, err := secrets.GeneratePSK()
+	if err != nil {
+		return pskEntry{}, err
+	}
+	return pskEntry{identity: identity, key: key}, nil
+}
+
+func formatPSKEntries(entries []pskEntry) []byte {
+	var b strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "%s:%s\n", entry.identity, entry.key)
+	}
+	return []byte(b.String())
+}
+
+func parsePSKEntries(data []byte) []pskEntry {
+	entries := []pskEntry{}
+	for _, line := range strings.Split(string(data), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			continue
+		}
+		entries = append(entries, pskEntry{identity: parts[0], key: parts[1]})
+	}
+	return entries
+}
+
+// NewPSKSecretPair generates a pre-shared key and writes it to the secrets of the transport
+// server and client. The secrets can then be passed as PSK credentials in the transport options.
+// The labels of the options are applied to both secrets, the owners of the options are ignored
+// in favor of the owners of each PSKSecretRef.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+func NewPSKSecretPair(ctx context.Context, logger logr.Logger,
+	server, client PSKSecretRef,
+	options *transport.Options) error {
+	entry, err := newPSKEntry(pskIdentity)
+	if err != nil {
+		return err
+	}
+	for _, ref := range []PSKSecretRef{server, client} {
+		err = reconcilePSKEntries(ctx, ref, logger, options, []pskEntry{entry}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RotatePSKSecretPair generates a new pre-shared key for the transport server and client.
+// The server secret is updated first and keeps accepting the previous keys for gracePeriod,
+// so that clients using the previous key can still connect until the client secret is updated
+// and its transport restarted. Keys whose grace period expired are removed on every rotation.
+// As in NewPSKSecretPair, the owners of each secret are taken from its PSKSecretRef.
+func RotatePSKSecretPair(ctx context.Context, logger logr.Logger,
+	server, client PSKSecretRef,
+	gracePeriod time.Duration,
+	options *transport.Options) error {
+	serverSecret := &corev1.Secret{}
+	err := server.Client.Get(ctx, server.SecretRef, serverSecret)
+	switch {
+	case k8serrors.IsNotFound(err):
+		logger.Info("PSK secret not found, generating new secret pair", "secret", server.SecretRef)
+		return NewPSKSecretPair(ctx, logger, server, client, options)
+	case err != nil:
+		return err
+	}
+
+	expiry := map[string]time.Time{}
+	if value, ok := serverSecret.Annotations[pskExpiryAnnotation]; ok {
+		err = json.Unmarshal([]byte(value), &expiry)
+		if err != nil {
+			return fmt.Errorf("%w: annotation %s of secret %s: %v",
+				transport.ErrTransportSecretInvalid, pskExpiryAnnotation, server.SecretRef, err)
+		}
+	}
+
+	newEntry, err := newPSKEntry(fmt.Sprintf("%s-%d", pskIdentity, time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+	// the new key comes first, stunnel clients use the first identity of the file
+	serverEntries := []pskEntry{newEntry}
+	newExpiry := map[string]time.Time{}
+	now := time.Now()
+	for _, entry := range parsePSKEntries(serverSecret.Data[pskKey]) {
+		expiresAt, ok := expiry[entry.identity]
+		if !ok {
+			expiresAt = now.Add(gracePeriod)
+		}
+		if !expiresAt.After(now) {
+			logger.Info("removing expired PSK identity", "secret", server.SecretRef, "identity", entry.identity)
+			continue
+		}
+		serverEntries = append(serverEntries, entry)
+		newExpiry[entry.identity] = expiresAt
+	}
+
+	err = reconcilePSKEntries(ctx, server, logger, options, serverEntries, newExpiry)
+	if err != nil {
+		return err
+	}
+	return reconcilePSKEntries(ctx, client, logger, options, []pskEntry{newEntry}, nil)
+}
+
+// reconcilePSKEntries writes the PSK entries to the secret, expiry is recorded in
+// the secret annotations when the secret holds identities in their grace period
+func reconcilePSKEntries(ctx context.Context,
+	ref PSKSecretRef,
+	logger logr.Logger,
+	options *transport.Options,
+	entries []pskEntry,
+	expiry map[string]time.Time) error {
+	var expiryAnnotation string
+	if len(expiry) > 0 {
+		value, err := json.Marshal(expiry)
+		if err != nil {
+			return err
+		}
+		expiryAnnotation = string(value)
+	}
+
+	pskSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: ref.SecretRef.Namespace,
+			Name:      ref.SecretRef.Name,
+		},
+	}
+	_, err := reconcile.CreateOrUpdate(ctx, ref.Client, logger, pskSecret, reconcileOptions(options), func() error {
+		pskSecret.Labels = options.Labels
+		pskSecret.OwnerReferences = ref.Owners
+		if expiryAnnotation != "" {
+			if pskSecret.Annotations == nil {
+				pskSecret.Annotations = map[string]string{}
+			}
+			pskSecret.Annotations[pskExpiryAnnotation] = expiryAnnotation
+		} else {
+			delete(pskSecret.Annotations, pskExpiryAnnotation)
+		}
+		pskSecret.Data = map[string][]byte{
+			pskKey: formatPSKEntries(entries),
+		}
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,179 @@
+package stunnel
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func Test_parsePSKEntries(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want []pskEntry
+	}{
+		{
+			name: "single entry",
+			data: "root:Zm9v\n",
+			want: []pskEntry{{identity: "root", key: "Zm9v"}},
+		},
+		{
+			name: "multiple entries with blank and invalid lines",
+			data: "root-2:YmFy\n\ninvalid\nroot:Zm9v==\n",
+			want: []pskEntry{{identity: "root-2", key: "YmFy"}, {identity: "root", key: "Zm9v=="}},
+		},
+		{
+			name: "empty data",
+			data: "",
+			want: []pskEntry{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePSKEntries([]byte(tt.data))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePSKEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPSKSecretPair(t *testing.T) {
+	serverClient := fakeClientWithObjects()
+	clientClient := fakeClientWithObjects()
+	serverOwners := []metav1.OwnerReference{{APIVersion: "api.foo", Kind: "Server", Name: "foo", UID: "123"}}
+	clientOwners := []metav1.OwnerReference{{APIVersion: "api.foo", Kind: "Client", Name: "foo", UID: "456"}}
+	server := PSKSecretRef{Client: serverClient, SecretRef: types.NamespacedName{Name: "psk", Namespace: "server-ns"}, Owners: serverOwners}
+	client := PSKSecretRef{Client: clientClient, SecretRef: types.NamespacedName{Name: "psk", Namespace: "client-ns"}, Owners: clientOwners}
+	options := &transport.Options{Labels: map[string]string{"test": "me"}, Owners: testOwnerReferences()}
+
+	err := NewPSKSecretPair(context.Background(), testr.New(t), server, client, options)
+	if err != nil {
+		t.Fatalf("NewPSKSecretPair() error = %v", err)
+	}
+	serverEntries := getPSKEntries(t, server)
+	clientEntries := getPSKEntries(t, client)
+	if len(serverEntries) != 1 || serverEntries[0].identity != pskIdentity {
+		t.Fatalf("server PSK entries = %v, want a single %s identity", serverEntries, pskIdentity)
+	}
+	if !reflect.DeepEqual(serverEntries, clientEntries) {
+		t.Errorf("client PSK entries = %v, want %v", clientEntries, serverEntries)
+	}
+	// each secret is owned by the owners of its side
+	for _, ref := range []PSKSecretRef{server, client} {
+		secret := &corev1.Secret{}
+		if err := ref.Client.Get(context.Background(), ref.SecretRef, secret); err != nil {
+			t.Fatalf("unable to get secret %s: %v", ref.SecretRef, err)
+		}
+		if !reflect.DeepEqual(secret.OwnerReferences, ref.Owners) {
+			t.Errorf("owners of secret %s = %v, want %v", ref.SecretRef, secret.OwnerReferences, ref.Owners)
+		}
+	}
+}
+
+func TestRotatePSKSecretPair(t *testing.T) {
+	serverRef := types.NamespacedName{Name: "psk", Namespace: "server-ns"}
+	clientRef := types.NamespacedName{Name: "psk", Namespace: "client-ns"}
+	pskSecret := func(ref types.NamespacedName, data string, expiry map[string]time.Time) *corev1.Secret {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: ref.Namespace},
+			Data:       map[string][]byte{pskKey: []byte(data)},
+		}
+		if expiry != nil {
+			value, _ := json.Marshal(expiry)
+			secret.Annotations = map[string]string{pskExpiryAnnotation: string(value)}
+		}
+		return secret
+	}
+	tests := []struct {
+		name               string
+		serverObjects      []ctrlclient.Object
+		clientObjects      []ctrlclient.Object
+		wantServerPrevious []string
+		wantNewPair        bool
+	}{
+		{
+			name:               "existing pair, server must keep the previous identity during the grace period",
+			serverObjects:      []ctrlclient.Object{pskSecret(serverRef, "root:Zm9v\n", nil)},
+			clientObjects:      []ctrlclient.Object{pskSecret(clientRef, "root:Zm9v\n", nil)},
+			wantServerPrevious: []string{"root"},
+		},
+		{
+			name: "expired identity, must be removed from the server secret",
+			serverObjects: []ctrlclient.Object{pskSecret(serverRef, "root-2:YmFy\nroot:Zm9v\n", map[string]time.Time{
+				"root": time.Now().Add(-time.Minute),
+			})},
+			clientObjects:      []ctrlclient.Object{pskSecret(clientRef, "root-2:YmFy\n", nil)},
+			wantServerPrevious: []string{"root-2"},
+		},
+		{
+			name:        "server secret missing, must generate a new pair",
+			wantNewPair: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := PSKSecretRef{Client: fakeClientWithObjects(tt.serverObjects...), SecretRef: serverRef}
+			client := PSKSecretRef{Client: fakeClientWithObjects(tt.clientObjects...), SecretRef: clientRef}
+			err := RotatePSKSecretPair(context.Background(), testr.New(t), server, client, time.Hour, &transport.Options{})
+			if err != nil {
+				t.Fatalf("RotatePSKSecretPair() error = %v", err)
+			}
+			serverEntries := getPSKEntries(t, server)
+			clientEntries := getPSKEntries(t, client)
+			if len(clientEntries) != 1 {
+				t.Fatalf("client PSK entries = %v, want a single entry", clientEntries)
+			}
+			if serverEntries[0] != clientEntries[0] {
+				t.Errorf("first server PSK entry = %v, want %v", serverEntries[0], clientEntries[0])
+			}
+			if tt.wantNewPair {
+				if len(serverEntries) != 1 || serverEntries[0].identity != pskIdentity {
+					t.Errorf("server PSK entries = %v, want a single %s identity", serverEntries, pskIdentity)
+				}
+				return
+			}
+			previous := []string{}
+			for _, entry := range serverEntries[1:] {
+				previous = append(previous, entry.identity)
+			}
+			if !reflect.DeepEqual(previous, tt.wantServerPrevious) {
+				t.Errorf("previous server identities = %v, want %v", previous, tt.wantServerPrevious)
+			}
+
+			secret := &corev1.Secret{}
+			err = server.Client.Get(context.Background(), serverRef, secret)
+			if err != nil {
+				t.Fatalf("unable to get server secret %v", err)
+			}
+			expiry := map[string]time.Time{}
+			err = json.Unmarshal([]byte(secret.Annotations[pskExpiryAnnotation]), &expiry)
+			if err != nil {
+				t.Fatalf("unable to parse expiry annotation %v", err)
+			}
+			for _, identity := range tt.wantServerPrevious {
+				if !expiry[identity].After(time.Now()) {
+					t.Errorf("expiry of identity %s = %v, want a time in the future", identity, expiry[identity])
+				}
+			}
+		})
+	}
+}
+
+func getPSKEntries(t *testing.T, ref PSKSecretRef) []pskEntry {
+	secret := &corev1.Secret{}
+	err := ref.Client.Get(context.Background(), ref.SecretRef, secret)
+	if err != nil {
+		t.Fatalf("unable to get PSK secret %s: %v", ref.SecretRef, err)
+	}
+	return parsePSKEntries(secret.Data[pskKey])
+}
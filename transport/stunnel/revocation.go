@@ -0,0 +1,174 @@
+package stunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/tls/certs"
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// revocationListKey is the key of the certificate revocation list in the credentials secrets
+const revocationListKey = "crl.pem"
+
+// ErrCAKeyUnavailable is returned when the private key of the CA is required for signing
+// a revocation list or a certificate but is not stored with the credentials, e.g. when they
+// are scoped
+var ErrCAKeyUnavailable = errors.New("CA key unavailable")
+
+// RevokeClientCertificate revokes the client certificate of the SSL credentials of a transport
+// server stored in secretRef and issues a new client key pair signed by the same CA, so that a
+// leaked client certificate is rejected without regenerating the credentials. The transport
+// server must be created with the RevocationList option, its stunnel process loads the updated
+// list when it restarts. Transport clients must be given the new key pair, plans copy it on
+// their next reconcile.
+//
+// The private key of the CA must be stored in secretRef or, for split credentials, in its CA
+// secret, an error wrapping ErrCAKeyUnavailable is returned otherwise.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+func RevokeClientCertificate(ctx context.Context, c ctrlclient.Client, logger logr.Logger, secretRef types.NamespacedName) error {
+	serverSecret := &corev1.Secret{}
+	err := c.Get(ctx, secretRef, serverSecret)
+	if err != nil {
+		return err
+	}
+	caSecret, err := getCASecret(ctx, c, secretRef, serverSecret)
+	if err != nil {
+		return err
+	}
+
+	// the client key pair is stored in a secret of its own when the credentials are scoped
+	clientSecret := serverSecret
+	if _, ok := serverSecret.Data["client.crt"]; !ok {
+		clientSecret = &corev1.Secret{}
+		err = c.Get(ctx, getClientCredentialsSecretRef(secretRef), clientSecret)
+		if err != nil {
+			return err
+		}
+	}
+	_, tlsFormat := clientSecret.Data[corev1.TLSCertKey]
+	clientCrtKey, clientKeyKey := secretKeys(clientKeyPair, tlsFormat)
+	serial, err := certs.SerialNumber(bytes.NewBuffer(clientSecret.Data[clientCrtKey]))
+	if err != nil {
+		return fmt.Errorf("%w: %s in secret %s: %v", transport.ErrTransportSecretInvalid, clientCrtKey, ctrlclient.ObjectKeyFromObject(clientSecret), err)
+	}
+	revoked, err := revokedSerialNumbers(secretRef, serverSecret, caSecret)
+	if err != nil {
+		return err
+	}
+	crl, err := certs.NewRevocationList(bytes.NewBuffer(caSecret.Data["ca.crt"]), bytes.NewBuffer(caSecret.Data["ca.key"]),
+		append(revoked, serial))
+	if err != nil {
+		return fmt.Errorf("%w: unable to sign revocation list: %v", transport.ErrTransportSecretInvalid, err)
+	}
+	crtBundle, err := certs.NewFromCA(bytes.NewBuffer(caSecret.Data["ca.crt"]), bytes.NewBuffer(caSecret.Data["ca.key"]))
+	if err != nil {
+		return fmt.Errorf("%w: unable to issue client certificate: %v", transport.ErrTransportSecretInvalid, err)
+	}
+
+	// the new key pair is written before the revocation list, clients are not left without
+	// a valid certificate when the revocation list is written and the key pair is not
+	clientSecret.Data[clientCrtKey] = crtBundle.ClientCrt.Bytes()
+	clientSecret.Data[clientKeyKey] = crtBundle.ClientKey.Bytes()
+	if clientSecret != serverSecret {
+		err = c.Update(ctx, clientSecret)
+		if err != nil {
+			return err
+		}
+	}
+	serverSecret.Data[revocationListKey] = crl.Bytes()
+	err = c.Update(ctx, serverSecret)
+	if err != nil {
+		return err
+	}
+	if caSecret != serverSecret {
+		// split credentials keep the list with the CA, it is restored when the key pairs are re-issued
+		caSecret.Data[revocationListKey] = crl.Bytes()
+		err = c.Update(ctx, caSecret)
+		if err != nil {
+			return err
+		}
+	}
+	logger.Info("client certificate revoked", "secret", secretRef, "serialNumber", serial.String())
+	return nil
+}
+
+// reconcileRevocationList writes a revocation list to the credentials secretRef when it holds
+// none, the list of the CA secret of split credentials is restored and an empty list is signed
+// otherwise
+func reconcileRevocationList(ctx context.Context, c ctrlclient.Client, logger logr.Logger, secretRef types.NamespacedName) error {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, secretRef, secret)
+	if err != nil {
+		return err
+	}
+	if _, ok := secret.Data[revocationListKey]; ok {
+		return nil
+	}
+	caSecret, err := getCASecret(ctx, c, secretRef, secret)
+	if err != nil {
+		return err
+	}
+	crl, ok := caSecret.Data[revocationListKey]
+	if !ok {
+		signed, err := certs.NewRevocationList(bytes.NewBuffer(caSecret.Data["ca.crt"]), bytes.NewBuffer(caSecret.Data["ca.key"]), nil)
+		if err != nil {
+			return fmt.Errorf("%w: unable to sign revocation list: %v", transport.ErrTransportSecretInvalid, err)
+		}
+		crl = signed.Bytes()
+	}
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data[revocationListKey] = crl
+	logger.Info("writing certificate revocation list", "secret", secretRef)
+	return c.Update(ctx, secret)
+}
+
+// getCASecret returns the secret holding the private key of the CA of the credentials stored
+// in secret, the secret itself or the CA secret of split credentials
+func getCASecret(ctx context.Context, c ctrlclient.Client, secretRef types.NamespacedName, secret *corev1.Secret) (*corev1.Secret, error) {
+	if _, ok := secret.Data["ca.key"]; ok {
+		return secret, nil
+	}
+	caRef := getCACredentialsSecretRef(secretRef)
+	caSecret := &corev1.Secret{}
+	err := c.Get(ctx, caRef, caSecret)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return nil, fmt.Errorf("%w: neither secret %s nor %s hold ca.key", ErrCAKeyUnavailable, secretRef, caRef)
+	case err != nil:
+		return nil, err
+	}
+	if _, ok := caSecret.Data["ca.key"]; !ok {
+		return nil, fmt.Errorf("%w: secret %s missing key ca.key", ErrCAKeyUnavailable, caRef)
+	}
+	return caSecret, nil
+}
+
+// revokedSerialNumbers returns the serial numbers revoked by the revocation list of the
+// credentials, the list of the CA secret is used when the credentials hold none
+func revokedSerialNumbers(secretRef types.NamespacedName, secret, caSecret *corev1.Secret) ([]*big.Int, error) {
+	crl, ok := secret.Data[revocationListKey]
+	if !ok {
+		crl, ok = caSecret.Data[revocationListKey]
+	}
+	if !ok {
+		return nil, nil
+	}
+	revoked, err := certs.RevokedSerialNumbers(bytes.NewBuffer(crl))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s in secret %s: %v", transport.ErrTransportSecretInvalid, revocationListKey, secretRef, err)
+	}
+	return revoked, nil
+}
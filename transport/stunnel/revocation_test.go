@@ -0,0 +1,151 @@
+package stunnel
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/backube/pvc-transfer/transport"
+	"github.com/backube/pvc-transfer/transport/tls/certs"
+	"github.com/go-logr/logr/testr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestRevokeClientCertificate(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	tests := []struct {
+		name    string
+		options *transport.Options
+		wantErr error
+	}{
+		{
+			name:    "credentials with the CA key, must revoke the client certificate",
+			options: &transport.Options{RevocationList: true},
+		},
+		{
+			name:    "split credentials, must revoke the client certificate",
+			options: &transport.Options{RevocationList: true, SplitCredentials: true},
+		},
+		{
+			name:    "scoped credentials, must return ErrCAKeyUnavailable",
+			options: &transport.Options{RevocationList: true, ScopedCredentials: true},
+			wantErr: ErrCAKeyUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fakeClient := fakeClientWithObjects()
+			s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			clientRef := s.(transport.ClientCredentialsProvider).ClientCredentials()
+			getData := func(ref types.NamespacedName) map[string][]byte {
+				secret := &corev1.Secret{}
+				if err := fakeClient.Get(ctx, ref, secret); err != nil {
+					t.Fatalf("unable to get secret %s %v", ref, err)
+				}
+				return secret.Data
+			}
+			assertRevoked := func(serials ...*big.Int) {
+				revoked, err := certs.RevokedSerialNumbers(bytes.NewBuffer(getData(s.Credentials())[revocationListKey]))
+				if err != nil {
+					t.Fatalf("RevokedSerialNumbers() error = %v", err)
+				}
+				if len(revoked) != len(serials) {
+					t.Fatalf("revocation list revokes %v, want %v", revoked, serials)
+				}
+				for i := range serials {
+					if revoked[i].Cmp(serials[i]) != 0 {
+						t.Errorf("revocation list revokes %v, want %v", revoked, serials)
+					}
+				}
+			}
+			assertRevoked()
+
+			config := &corev1.ConfigMap{}
+			err = fakeClient.Get(ctx, types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-server-foo"}, config)
+			if err != nil {
+				t.Fatalf("unable to get config %v", err)
+			}
+			if !strings.Contains(config.Data["stunnel.conf"], "CRLfile = /etc/stunnel/certs/crl.pem") {
+				t.Errorf("stunnel server config does not load the revocation list:\n%s", config.Data["stunnel.conf"])
+			}
+			mountsList := false
+			for _, volume := range s.Volumes() {
+				if volume.Secret == nil {
+					continue
+				}
+				for _, item := range volume.Secret.Items {
+					mountsList = mountsList || item.Key == revocationListKey
+				}
+			}
+			if !mountsList {
+				t.Errorf("Volumes() = %v, want the revocation list mounted", s.Volumes())
+			}
+
+			var serials []*big.Int
+			for i := 0; i < 2; i++ {
+				clientCrt := getData(clientRef)["client.crt"]
+				serial, err := certs.SerialNumber(bytes.NewBuffer(clientCrt))
+				if err != nil {
+					t.Fatalf("SerialNumber() error = %v", err)
+				}
+				serials = append(serials, serial)
+				if err := RevokeClientCertificate(ctx, fakeClient, testr.New(t), s.Credentials()); err != nil {
+					t.Fatalf("RevokeClientCertificate() error = %v", err)
+				}
+				data := getData(clientRef)
+				if bytes.Equal(data["client.crt"], clientCrt) {
+					t.Errorf("RevokeClientCertificate() did not issue a new client certificate")
+				}
+				if ok, _ := certs.VerifyCertificate(bytes.NewBuffer(data["ca.crt"]), bytes.NewBuffer(data["client.crt"])); !ok {
+					t.Errorf("new client certificate not signed by the CA")
+				}
+				assertRevoked(serials...)
+			}
+
+			if !tt.options.SplitCredentials {
+				return
+			}
+			// the list is restored with the key pairs re-issued by the stored CA
+			err = fakeClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: s.Credentials().Name, Namespace: "bar"}})
+			if err != nil {
+				t.Fatalf("unable to delete credentials %v", err)
+			}
+			if err := s.(transport.Reconciler).Reconcile(ctx, fakeClient); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			assertRevoked(serials...)
+		})
+	}
+}
+
+func Test_reconcileRevocationList_keepsExistingList(t *testing.T) {
+	secretRef := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace},
+		Data:       map[string][]byte{revocationListKey: []byte("existing")},
+	}
+	fakeClient := fakeClientWithObjects(secret)
+	err := reconcileRevocationList(context.Background(), fakeClient, testr.New(t), secretRef)
+	if err != nil {
+		t.Fatalf("reconcileRevocationList() error = %v", err)
+	}
+	if err := fakeClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(secret), secret); err != nil {
+		t.Fatalf("unable to get secret %v", err)
+	}
+	if string(secret.Data[revocationListKey]) != "existing" {
+		t.Errorf("reconcileRevocationList() replaced the existing list")
+	}
+}
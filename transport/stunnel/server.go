@@ -7,6 +7,7 @@ import (
 	"text/template"
 
 	"github.com/backube/pvc-transfer/endpoint"
+	"github.com/backube/pvc-transfer/internal/reconcile"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
@@ -14,29 +15,42 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
 	// TCP_NODELAY=1 bypasses Nagle's Delay algorithm
 	// this means that the tcp stack does not wait for receiving an ack
 	// before sending the next packet https://en.wikipedia.org/wiki/Nagle%27s_algorithm
-	// At scale setting/unsetting this option might drive different network characteristics
-	stunnelServerConfTemplate = `foreground = no
+	// At scale setting/unsetting this option might drive different network characteristics.
+	// TCP keepalives detect peers gone without closing their connections after about 2 minutes,
+	// they also keep idle connections open through load balancers and NAT gateways
+	stunnelServerConfTemplate = `foreground = {{ if .Foreground }}yes{{ else }}no{{ end }}
 pid =
 socket = l:TCP_NODELAY=1
 socket = r:TCP_NODELAY=1
+socket = l:SO_KEEPALIVE=1
+socket = r:SO_KEEPALIVE=1
+socket = l:TCP_KEEPIDLE=60
+socket = r:TCP_KEEPIDLE=60
+socket = l:TCP_KEEPINTVL=10
+socket = r:TCP_KEEPINTVL=10
+socket = l:TCP_KEEPCNT=6
+socket = r:TCP_KEEPCNT=6
 debug = 7
-sslVersion = TLSv1.3
+{{ .TLSConfig }}
 output=/dev/stdout
 {{ if .UsePSK }}
-ciphers = PSK
 PSKsecrets = /etc/stunnel/certs/key
 {{ else }}
 key = /etc/stunnel/certs/server.key
 cert = /etc/stunnel/certs/server.crt
+{{- if not .OneWayTLS }}
 CAfile = /etc/stunnel/certs/ca.crt
 verify = 2
+{{- end }}
+{{- if .RevocationList }}
+CRLfile = /etc/stunnel/certs/crl.pem
+{{- end }}
 {{ end }}
 
 [transfer]
@@ -56,7 +70,7 @@ func AddToScheme(scheme *runtime.Scheme) error {
 // APIsToWatch give a list of APIs to watch if using this package
 // to deploy the transport
 func APIsToWatch() ([]ctrlclient.Object, error) {
-	return []ctrlclient.Object{&corev1.Secret{}, &corev1.ConfigMap{}}, nil
+	return []ctrlclient.Object{&corev1.Secret{}, &corev1.ConfigMap{}, &corev1.Pod{}}, nil
 }
 
 type server struct {
@@ -66,6 +80,7 @@ type server struct {
 	containers     []corev1.Container
 	volumes        []corev1.Volume
 	options        *transport.Options
+	hostname       string
 	namespacedName types.NamespacedName
 }
 
@@ -75,6 +90,7 @@ type server struct {
 // Before passing the client c make sure to call AddToScheme() if core types are not already registered
 // In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
 // +kubebuilder:rbac:groups=core,resources=configmaps;secrets,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
 func NewServer(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	namespacedName types.NamespacedName,
 	e endpoint.Endpoint,
@@ -82,15 +98,30 @@ func NewServer(ctx context.Context, c ctrlclient.Client, logger logr.Logger,
 	transportLogger := logger.WithValues("transportServer", namespacedName)
 	transferPort := e.BackendPort()
 
+	err := transport.ValidateImage(getImage(options), options.RequireImageDigests)
+	if err != nil {
+		return nil, err
+	}
+
+	connectPort, err := getPort(options.ServerConnectPort, stunnelConnectPort)
+	if err != nil {
+		return nil, err
+	}
+	if connectPort == transferPort {
+		return nil, fmt.Errorf("%w: connect port %d clashes with the listen port of the stunnel server",
+			transport.ErrTransportPortInvalid, connectPort)
+	}
+
 	s := &server{
 		namespacedName: namespacedName,
 		options:        options,
 		listenPort:     transferPort,
-		connectPort:    stunnelConnectPort,
+		hostname:       e.Hostname(),
+		connectPort:    connectPort,
 		logger:         transportLogger,
 	}
 
-	err := s.reconcileConfig(ctx, c)
+	err = s.reconcileConfig(ctx, c)
 	if err != nil {
 		s.logger.Error(err, "unable to reconcile stunnel server config")
 		return nil, err
@@ -112,6 +143,19 @@ func (s *server) NamespacedName() types.NamespacedName {
 	return s.namespacedName
 }
 
+func (s *server) ConnectionInfo() transport.ConnectionInfo {
+	return transport.ConnectionInfo{
+		Scheme:         transport.SchemeTCP,
+		Hostname:       s.Hostname(),
+		Port:           s.ConnectPort(),
+		CredentialsRef: s.Credentials(),
+	}
+}
+
+func (s *server) IsHealthy(ctx context.Context, c ctrlclient.Client) (bool, error) {
+	return isHealthy(ctx, c, s.logger, s, s.options, "server", s.hostname)
+}
+
 func (s *server) ListenPort() int32 {
 	return s.listenPort
 }
@@ -136,6 +180,27 @@ func (s *server) Credentials() types.NamespacedName {
 	return getCredentialsSecretRef(s, s.options.Credentials)
 }
 
+// ClientCredentials returns the secret holding the credentials of the clients, a secret of its
+// own when the credentials are scoped
+func (s *server) ClientCredentials() types.NamespacedName {
+	if isScoped(s.options) && !isPSK(s.options.Credentials) {
+		return getClientCredentialsSecretRef(s.Credentials())
+	}
+	return s.Credentials()
+}
+
+func (s *server) TerminatesOnCompletion() bool {
+	return s.options.TerminateOnCompletion && !s.options.NativeSidecar
+}
+
+func (s *server) RunsAsNativeSidecar() bool {
+	return s.options.NativeSidecar
+}
+
+func (s *server) ImagePullSecrets() []corev1.LocalObjectReference {
+	return s.options.ImagePullSecrets
+}
+
 func (s *server) Hostname() string {
 	return "localhost"
 }
@@ -144,6 +209,21 @@ func (s *server) MarkForCleanup(ctx context.Context, c ctrlclient.Client, key, v
 	return markForCleanup(ctx, c, s.namespacedName, key, value, "server")
 }
 
+// Reconcile restores the stunnel configuration and the credentials of the server
+func (s *server) Reconcile(ctx context.Context, c ctrlclient.Client) error {
+	err := s.reconcileConfig(ctx, c)
+	if err != nil {
+		s.logger.Error(err, "unable to reconcile stunnel server config")
+		return err
+	}
+	err = s.reconcileSecret(ctx, c)
+	if err != nil {
+		s.logger.Error(err, "unable to reconcile stunnel server secret")
+		return err
+	}
+	return nil
+}
+
 func (s *server) reconcileConfig(ctx context.Context, c ctrlclient.Client) error {
 	stunnelConfTemplate, err := template.New("config").Parse(stunnelServerConfTemplate)
 	if err != nil {
@@ -155,6 +235,14 @@ func (s *server) reconcileConfig(ctx context.Context, c ctrlclient.Client) error
 		AcceptPort  int32
 		ConnectPort int32
 		UsePSK      bool
+		TLSConfig   string
+		// Foreground keeps stunnel attached to the supervisor terminating it on completion, or
+		// to the native sidecar container
+		Foreground bool
+		// RevocationList rejects the client certificates revoked in crl.pem
+		RevocationList bool
+		// OneWayTLS does not request client certificates
+		OneWayTLS bool
 	}
 	fields := confFields{
 		// acceptPort on which Stunnel service listens on, must connect with endpoint
@@ -162,10 +250,17 @@ func (s *server) reconcileConfig(ctx context.Context, c ctrlclient.Client) error
 		// connectPort in the container on which Transfer is listening on
 		ConnectPort: s.ConnectPort(),
 		UsePSK:      false,
+		Foreground:  s.TerminatesOnCompletion() || s.RunsAsNativeSidecar(),
 	}
 	if s.options.Credentials != nil && s.options.Credentials.Type == CredentialsTypePSK {
 		fields.UsePSK = true
 	}
+	fields.RevocationList = s.usesRevocationList()
+	fields.OneWayTLS = oneWayTLS(s.options.Credentials)
+	fields.TLSConfig, err = getTLSConfig(s.options, fields.UsePSK)
+	if err != nil {
+		return err
+	}
 	var stunnelConf bytes.Buffer
 	err = stunnelConfTemplate.Execute(&stunnelConf, fields)
 	if err != nil {
@@ -180,7 +275,7 @@ func (s *server) reconcileConfig(ctx context.Context, c ctrlclient.Client) error
 		},
 	}
 
-	_, err = controllerutil.CreateOrUpdate(ctx, c, stunnelConfigMap, func() error {
+	_, err = reconcile.CreateOrUpdate(ctx, c, s.logger, stunnelConfigMap, reconcileOptions(s.options), func() error {
 		stunnelConfigMap.Labels = s.options.Labels
 		stunnelConfigMap.OwnerReferences = s.options.Owners
 
@@ -193,7 +288,17 @@ func (s *server) reconcileConfig(ctx context.Context, c ctrlclient.Client) error
 }
 
 func (s *server) reconcileSecret(ctx context.Context, c ctrlclient.Client) error {
-	return reconcileCredentialSecret(ctx, c, s.logger, s, s.options)
+	err := reconcileCredentialSecret(ctx, c, s.logger, s, s.options, "server", s.hostname)
+	if err != nil || !s.usesRevocationList() {
+		return err
+	}
+	return reconcileRevocationList(ctx, c, s.logger, s.Credentials())
+}
+
+// usesRevocationList returns whether the server checks client certificates against the
+// revocation list of its credentials
+func (s *server) usesRevocationList() bool {
+	return s.options.RevocationList && !isPSK(s.options.Credentials) && !hasProvider(s.options.Credentials)
 }
 
 func (s *server) serverContainers() []corev1.Container {
@@ -216,15 +321,42 @@ func (s *server) serverContainers() []corev1.Container {
 	done
 	`
 	stunnelScript = fmt.Sprintf(stunnelScript, s.ConnectPort())
+	command := []string{
+		"/bin/bash",
+		"-c",
+		stunnelScript,
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      getResourceName(s.namespacedName, "server", stunnelConfig),
+			MountPath: "/etc/stunnel/stunnel.conf",
+			SubPath:   "stunnel.conf",
+		},
+		{
+			Name:      getResourceName(s.namespacedName, "certs", stunnelSecret),
+			MountPath: "/etc/stunnel/certs",
+		},
+	}
+	switch {
+	case s.TerminatesOnCompletion():
+		command = getSupervisedCommand()
+		volumeMounts = append(volumeMounts, getCompletionVolumeMount())
+	case s.RunsAsNativeSidecar():
+		// Kubernetes stops native sidecars once the transfer exited
+		command = []string{"/bin/stunnel", "/etc/stunnel/stunnel.conf"}
+	}
 	return []corev1.Container{
 		{
-			Name:  Container,
-			Image: getImage(s.options),
-			Command: []string{
-				"/bin/bash",
-				"-c",
-				stunnelScript,
-			},
+			Name:            Container,
+			Image:           getImage(s.options),
+			Command:         command,
+			ImagePullPolicy: s.options.ImagePullPolicy,
+			ReadinessProbe:  getReadinessProbe(),
+			LivenessProbe:   getLivenessProbe(s.RunsAsNativeSidecar()),
+			Resources:       s.options.Resources,
+			SecurityContext: s.options.ContainerSecurityContext.DeepCopy(),
+			Env:             s.options.Env,
+			EnvFrom:         s.options.EnvFrom,
 			Ports: []corev1.ContainerPort{
 				{
 					Name:          "stunnel",
@@ -232,23 +364,18 @@ func (s *server) serverContainers() []corev1.Container {
 					ContainerPort: s.ListenPort(),
 				},
 			},
-			VolumeMounts: []corev1.VolumeMount{
-				{
-					Name:      getResourceName(s.namespacedName, "server", stunnelConfig),
-					MountPath: "/etc/stunnel/stunnel.conf",
-					SubPath:   "stunnel.conf",
-				},
-				{
-					Name:      getResourceName(s.namespacedName, "certs", stunnelSecret),
-					MountPath: "/etc/stunnel/certs",
-				},
-			},
+			VolumeMounts: volumeMounts,
 		},
 	}
 }
 
 func (s *server) serverVolumes() []corev1.Volume {
-	return []corev1.Volume{
+	credentialsVolumeSource := getCredentialsVolumeSource(getCredentialsSecretRef(s, s.options.Credentials), s.options, "server")
+	if s.usesRevocationList() {
+		credentialsVolumeSource.Secret.Items = append(credentialsVolumeSource.Secret.Items,
+			corev1.KeyToPath{Key: revocationListKey, Path: revocationListKey})
+	}
+	volumes := []corev1.Volume{
 		{
 			Name: getResourceName(s.namespacedName, "server", stunnelConfig),
 			VolumeSource: corev1.VolumeSource{
@@ -261,7 +388,11 @@ func (s *server) serverVolumes() []corev1.Volume {
 		},
 		{
 			Name:         getResourceName(s.namespacedName, "certs", stunnelSecret),
-			VolumeSource: getCredentialsVolumeSource(s, s.options.Credentials, "server"),
+			VolumeSource: credentialsVolumeSource,
 		},
 	}
+	if s.TerminatesOnCompletion() {
+		volumes = append(volumes, getCompletionVolume())
+	}
+	return volumes
 }
@@ -1,15 +1,20 @@
 package stunnel
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/backube/pvc-transfer/endpoint"
 	"github.com/backube/pvc-transfer/transport"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/backube/pvc-transfer/transport/credentials"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -163,7 +168,7 @@ func TestNewServer(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			fakeClient := fakeClientWithObjects(tt.objects...)
 			ctx := context.WithValue(context.Background(), "test", tt.name)
-			fakeLogger := logrtesting.TestLogger{T: t}
+			fakeLogger := testr.New(t)
 			stunnelServer, err := NewServer(ctx, fakeClient, fakeLogger, tt.namespacedName, tt.endpoint, &transport.Options{Labels: tt.labels, Owners: tt.ownerReferences})
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
@@ -220,3 +225,565 @@ func TestNewServer(t *testing.T) {
 		})
 	}
 }
+
+func TestNewServer_ports(t *testing.T) {
+	tests := []struct {
+		name            string
+		options         *transport.Options
+		wantConnectPort int32
+		wantErr         error
+	}{
+		{
+			name:            "no connect port, must use the default port",
+			options:         &transport.Options{},
+			wantConnectPort: stunnelConnectPort,
+		},
+		{
+			name:            "custom connect port, must relay to the custom port",
+			options:         &transport.Options{ServerConnectPort: 9090},
+			wantConnectPort: 9090,
+		},
+		{
+			name:    "connect port out of range, must return ErrTransportPortInvalid",
+			options: &transport.Options{ServerConnectPort: 70000},
+			wantErr: transport.ErrTransportPortInvalid,
+		},
+		{
+			name:    "connect port same as the listen port, must return ErrTransportPortInvalid",
+			options: &transport.Options{ServerConnectPort: newFakeEndpoint().BackendPort()},
+			wantErr: transport.ErrTransportPortInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+			got, err := NewServer(context.Background(), fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), tt.options)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("NewServer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if got.ConnectPort() != tt.wantConnectPort {
+				t.Errorf("ConnectPort() = %v, want %v", got.ConnectPort(), tt.wantConnectPort)
+			}
+			readiness := got.Containers()[0].ReadinessProbe
+			if readiness == nil || readiness.Exec == nil || readiness.TCPSocket != nil {
+				t.Errorf("readiness probe = %v, want exec check of the stunnel process", readiness)
+			}
+			if liveness := got.Containers()[0].LivenessProbe; liveness != nil {
+				t.Errorf("liveness probe = %v, want none", liveness)
+			}
+			cm := &corev1.ConfigMap{}
+			err = fakeClient.Get(context.Background(), types.NamespacedName{
+				Namespace: "bar",
+				Name:      stunnelConfig + "-server-foo",
+			}, cm)
+			if err != nil {
+				t.Fatalf("unable to get stunnel config %v", err)
+			}
+			if want := fmt.Sprintf("connect = %d", tt.wantConnectPort); !strings.Contains(cm.Data["stunnel.conf"], want) {
+				t.Errorf("stunnel config %q does not contain %q", cm.Data["stunnel.conf"], want)
+			}
+		})
+	}
+}
+
+func TestNewServer_nativeSidecar(t *testing.T) {
+	fakeClient := fakeClientWithObjects()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	got, err := NewServer(context.Background(), fakeClient, testr.New(t), namespacedName, newFakeEndpoint(),
+		&transport.Options{NativeSidecar: true, TerminateOnCompletion: true})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	if !got.(transport.NativeSidecarAware).RunsAsNativeSidecar() || got.(transport.CompletionAware).TerminatesOnCompletion() {
+		t.Errorf("native sidecar servers must not terminate on completion")
+	}
+	// Kubernetes stops native sidecars, stunnel runs in the foreground without the supervisor
+	wantCommand := []string{"/bin/stunnel", "/etc/stunnel/stunnel.conf"}
+	if command := got.Containers()[0].Command; !reflect.DeepEqual(command, wantCommand) {
+		t.Errorf("stunnel command = %v, want %v", command, wantCommand)
+	}
+	// the restartPolicy Always of native sidecars restarts stunnel when it is not alive
+	if liveness := got.Containers()[0].LivenessProbe; liveness == nil || liveness.Exec == nil {
+		t.Errorf("liveness probe = %v, want exec check of the stunnel process", liveness)
+	}
+	cm := &corev1.ConfigMap{}
+	err = fakeClient.Get(context.Background(), types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-server-foo"}, cm)
+	if err != nil {
+		t.Fatalf("unable to get stunnel config %v", err)
+	}
+	if !strings.Contains(cm.Data["stunnel.conf"], "foreground = yes") {
+		t.Errorf("stunnel config %q does not run stunnel in the foreground", cm.Data["stunnel.conf"])
+	}
+}
+
+func TestServer_IsHealthy(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	labels := map[string]string{"app": "transfer"}
+	transportPod := func(status corev1.ContainerStatus) *corev1.Pod {
+		status.Name = Container
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "rsync-server", Namespace: "bar", Labels: labels},
+			Spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{{
+					Name: stunnelConfig + "-server-foo",
+					VolumeSource: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{
+						LocalObjectReference: corev1.LocalObjectReference{Name: stunnelConfig + "-server-foo"},
+					}},
+				}},
+			},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+		}
+	}
+	tests := []struct {
+		name    string
+		mutate  func(c ctrlclient.Client) error
+		want    bool
+		wantErr error
+	}{
+		{
+			name: "resources reconciled without pods, must be healthy",
+			want: true,
+		},
+		{
+			name: "stunnel container ready, must be healthy",
+			mutate: func(c ctrlclient.Client) error {
+				return c.Create(context.Background(), transportPod(corev1.ContainerStatus{Ready: true}))
+			},
+			want: true,
+		},
+		{
+			name: "stunnel container starting, must be unhealthy without error",
+			mutate: func(c ctrlclient.Client) error {
+				return c.Create(context.Background(), transportPod(corev1.ContainerStatus{
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}},
+				}))
+			},
+			want: false,
+		},
+		{
+			name: "stunnel container crashing, must return ErrTransportMisconfigured",
+			mutate: func(c ctrlclient.Client) error {
+				return c.Create(context.Background(), transportPod(corev1.ContainerStatus{
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				}))
+			},
+			wantErr: transport.ErrTransportMisconfigured,
+		},
+		{
+			name: "stunnel container of a pod without the transport labels crashing, must be healthy",
+			mutate: func(c ctrlclient.Client) error {
+				pod := transportPod(corev1.ContainerStatus{
+					State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}},
+				})
+				pod.Labels = nil
+				return c.Create(context.Background(), pod)
+			},
+			want: true,
+		},
+		{
+			name: "config deleted, must return ErrTransportMisconfigured",
+			mutate: func(c ctrlclient.Client) error {
+				return c.Delete(context.Background(), &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Name: stunnelConfig + "-server-foo", Namespace: "bar"},
+				})
+			},
+			wantErr: transport.ErrTransportMisconfigured,
+		},
+		{
+			name: "credentials deleted, must return ErrTransportMisconfigured",
+			mutate: func(c ctrlclient.Client) error {
+				return c.Delete(context.Background(), &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: stunnelSecret + "-certs-foo", Namespace: "bar"},
+				})
+			},
+			wantErr: transport.ErrTransportMisconfigured,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects()
+			s, err := NewServer(context.Background(), fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), &transport.Options{Labels: labels})
+			if err != nil {
+				t.Fatalf("NewServer() error = %v", err)
+			}
+			if tt.mutate != nil {
+				err = tt.mutate(fakeClient)
+				if err != nil {
+					t.Fatalf("unable to update transport resources %v", err)
+				}
+			}
+			got, err := s.IsHealthy(context.Background(), fakeClient)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("IsHealthy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("IsHealthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServer_Reconcile(t *testing.T) {
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	s, err := NewServer(context.Background(), fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), &transport.Options{})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	config := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: stunnelConfig + "-server-foo", Namespace: "bar"}}
+	if err := fakeClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(config), config); err != nil {
+		t.Fatalf("unable to get config %v", err)
+	}
+	original := config.Data
+	config.Data = map[string]string{"stunnel.conf": "edited"}
+	if err := fakeClient.Update(context.Background(), config); err != nil {
+		t.Fatalf("unable to update config %v", err)
+	}
+	err = fakeClient.Delete(context.Background(), &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: stunnelSecret + "-certs-foo", Namespace: "bar"},
+	})
+	if err != nil {
+		t.Fatalf("unable to delete credentials %v", err)
+	}
+
+	r, ok := s.(transport.Reconciler)
+	if !ok {
+		t.Fatalf("stunnel server must implement transport.Reconciler")
+	}
+	if err := r.Reconcile(context.Background(), fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	if err := fakeClient.Get(context.Background(), ctrlclient.ObjectKeyFromObject(config), config); err != nil {
+		t.Fatalf("unable to get config %v", err)
+	}
+	if !reflect.DeepEqual(config.Data, original) {
+		t.Errorf("Reconcile() did not restore the config, got %v, want %v", config.Data, original)
+	}
+	healthy, err := s.IsHealthy(context.Background(), fakeClient)
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v after Reconcile(), want healthy", healthy, err)
+	}
+}
+
+func TestNewServer_scopedCredentials(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), &transport.Options{ScopedCredentials: true})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	provider, ok := s.(transport.ClientCredentialsProvider)
+	if !ok {
+		t.Fatalf("stunnel server must implement transport.ClientCredentialsProvider")
+	}
+	clientRef := provider.ClientCredentials()
+	if clientRef == s.Credentials() {
+		t.Fatalf("ClientCredentials() = %v, want a secret other than the server secret", clientRef)
+	}
+
+	tests := []struct {
+		name       string
+		secretRef  types.NamespacedName
+		wantKeys   []string
+		absentKeys []string
+	}{
+		{
+			name:       "server secret, must hold the server key pair only",
+			secretRef:  s.Credentials(),
+			wantKeys:   []string{"server.crt", "server.key", "ca.crt"},
+			absentKeys: []string{"client.key", "ca.key"},
+		},
+		{
+			name:       "client secret, must hold the client key pair only",
+			secretRef:  clientRef,
+			wantKeys:   []string{"client.crt", "client.key", "server.crt", "ca.crt"},
+			absentKeys: []string{"server.key", "ca.key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{}
+			if err := fakeClient.Get(ctx, tt.secretRef, secret); err != nil {
+				t.Fatalf("unable to get secret %v", err)
+			}
+			for _, key := range tt.wantKeys {
+				if _, ok := secret.Data[key]; !ok {
+					t.Errorf("secret %s missing key %s", tt.secretRef, key)
+				}
+			}
+			for _, key := range tt.absentKeys {
+				if _, ok := secret.Data[key]; ok {
+					t.Errorf("secret %s holds key %s", tt.secretRef, key)
+				}
+			}
+		})
+	}
+
+	healthy, err := s.IsHealthy(ctx, fakeClient)
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v, want healthy", healthy, err)
+	}
+	_, err = NewClient(ctx, fakeClient, testr.New(t), namespacedName, "example.com", 443, &transport.Options{
+		ScopedCredentials: true,
+		Credentials:       &transport.Credentials{SecretRef: clientRef},
+	})
+	if err != nil {
+		t.Errorf("NewClient() with the client secret error = %v", err)
+	}
+	_, err = NewClient(ctx, fakeClient, testr.New(t), namespacedName, "example.com", 443, &transport.Options{
+		ScopedCredentials: true,
+		Credentials:       &transport.Credentials{SecretRef: s.Credentials()},
+	})
+	if !errors.Is(err, transport.ErrTransportSecretInvalid) {
+		t.Errorf("NewClient() with the server secret error = %v, want ErrTransportSecretInvalid", err)
+	}
+
+	// the client secret is reissued with the server secret so that both hold the same CA
+	err = fakeClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: clientRef.Name, Namespace: clientRef.Namespace}})
+	if err != nil {
+		t.Fatalf("unable to delete client credentials %v", err)
+	}
+	if _, err := s.IsHealthy(ctx, fakeClient); !errors.Is(err, transport.ErrTransportMisconfigured) {
+		t.Errorf("IsHealthy() error = %v without client credentials, want ErrTransportMisconfigured", err)
+	}
+	if err := s.(transport.Reconciler).Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	valid, err := isScopedTLSSecretValid(ctx, fakeClient, testr.New(t), s.Credentials(), "", false)
+	if err != nil || !valid {
+		t.Errorf("isScopedTLSSecretValid() = %v, %v after Reconcile(), want valid", valid, err)
+	}
+}
+
+func TestNewServer_splitCredentials(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), &transport.Options{SplitCredentials: true})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	clientRef := s.(transport.ClientCredentialsProvider).ClientCredentials()
+	caRef := getCACredentialsSecretRef(s.Credentials())
+	getSecret := func(ref types.NamespacedName) *corev1.Secret {
+		secret := &corev1.Secret{}
+		if err := fakeClient.Get(ctx, ref, secret); err != nil {
+			t.Fatalf("unable to get secret %s %v", ref, err)
+		}
+		return secret
+	}
+
+	ca := getSecret(caRef)
+	if _, ok := ca.Data["ca.key"]; !ok {
+		t.Fatalf("CA secret %s missing key ca.key", caRef)
+	}
+	for _, ref := range []types.NamespacedName{s.Credentials(), clientRef} {
+		secret := getSecret(ref)
+		if _, ok := secret.Data["ca.key"]; ok {
+			t.Errorf("secret %s holds key ca.key", ref)
+		}
+		if !bytes.Equal(secret.Data["ca.crt"], ca.Data["ca.crt"]) {
+			t.Errorf("secret %s not signed by the CA of secret %s", ref, caRef)
+		}
+	}
+
+	// key pairs are re-issued by the stored CA
+	clientKey := getSecret(clientRef).Data["client.key"]
+	err = fakeClient.Delete(ctx, &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: clientRef.Name, Namespace: clientRef.Namespace}})
+	if err != nil {
+		t.Fatalf("unable to delete client credentials %v", err)
+	}
+	if err := s.(transport.Reconciler).Reconcile(ctx, fakeClient); err != nil {
+		t.Fatalf("Reconcile() error = %v", err)
+	}
+	reissued := getSecret(clientRef)
+	if bytes.Equal(reissued.Data["client.key"], clientKey) {
+		t.Errorf("Reconcile() did not re-issue the client key pair")
+	}
+	if !bytes.Equal(reissued.Data["ca.crt"], ca.Data["ca.crt"]) || !bytes.Equal(getSecret(s.Credentials()).Data["ca.crt"], ca.Data["ca.crt"]) {
+		t.Errorf("Reconcile() rotated the CA of split credentials")
+	}
+
+	if err := s.MarkForCleanup(ctx, fakeClient, "cleanup", "true"); err != nil {
+		t.Fatalf("MarkForCleanup() error = %v", err)
+	}
+	if getSecret(caRef).Labels["cleanup"] != "true" {
+		t.Errorf("MarkForCleanup() did not label the CA secret")
+	}
+}
+
+func TestNewServer_credentialsProvider(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	options := &transport.Options{Credentials: &transport.Credentials{
+		Provider: credentials.VaultCSI{Role: "transfer", SecretPath: "secret/data/transfer"},
+	}}
+	s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), options)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	err = fakeClient.Get(ctx, s.Credentials(), &corev1.Secret{})
+	if !k8serrors.IsNotFound(err) {
+		t.Errorf("credentials secret written with a credentials provider, error = %v", err)
+	}
+	var credentialsVolume *corev1.Volume
+	for i, volume := range s.Volumes() {
+		if volume.CSI != nil {
+			credentialsVolume = &s.Volumes()[i]
+		}
+		if volume.Secret != nil {
+			t.Errorf("server volume %s mounts secret %s", volume.Name, volume.Secret.SecretName)
+		}
+	}
+	if credentialsVolume == nil || credentialsVolume.CSI.VolumeAttributes["secretProviderClass"] != s.Credentials().Name {
+		t.Errorf("Volumes() = %v, want the credentials mounted by the SecretProviderClass", s.Volumes())
+	}
+	healthy, err := s.IsHealthy(ctx, fakeClient)
+	if err != nil || !healthy {
+		t.Errorf("IsHealthy() = %v, %v, want healthy", healthy, err)
+	}
+
+	options.Credentials.Provider = credentials.VaultCSI{}
+	_, err = NewServer(ctx, fakeClientWithObjects(), testr.New(t), namespacedName, newFakeEndpoint(), options)
+	if !errors.Is(err, credentials.ErrProviderMisconfigured) {
+		t.Errorf("NewServer() error = %v, want ErrProviderMisconfigured", err)
+	}
+}
+
+func TestNewServer_serviceCA(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects(&corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: "bar"}})
+	options := &transport.Options{Credentials: &transport.Credentials{
+		Provider: credentials.ServiceCA{Service: "foo"},
+	}}
+	s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), options)
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	service := &corev1.Service{}
+	err = fakeClient.Get(ctx, namespacedName, service)
+	if err != nil {
+		t.Fatalf("unable to get service %v", err)
+	}
+	if service.Annotations[credentials.ServingCertSecretAnnotation] != s.Credentials().Name {
+		t.Errorf("service annotations = %v, want the serving certificate in %s", service.Annotations, s.Credentials().Name)
+	}
+	cm := &corev1.ConfigMap{}
+	err = fakeClient.Get(ctx, types.NamespacedName{Namespace: "bar", Name: stunnelConfig + "-server-foo"}, cm)
+	if err != nil {
+		t.Fatalf("unable to get stunnel config %v", err)
+	}
+	if strings.Contains(cm.Data["stunnel.conf"], "CAfile") || strings.Contains(cm.Data["stunnel.conf"], "verify = 2") {
+		t.Errorf("stunnel config %q verifies client certificates", cm.Data["stunnel.conf"])
+	}
+	for _, volume := range s.Volumes() {
+		if volume.Secret == nil || volume.Secret.SecretName != s.Credentials().Name {
+			continue
+		}
+		for _, item := range volume.Secret.Items {
+			if item.Key != corev1.TLSCertKey && item.Key != corev1.TLSPrivateKeyKey {
+				t.Errorf("credentials volume item %s, want only the serving certificate", item.Key)
+			}
+		}
+	}
+	healthy, err := s.IsHealthy(ctx, fakeClient)
+	if err != nil || healthy {
+		t.Errorf("IsHealthy() = %v, %v before the certificate is issued, want unhealthy", healthy, err)
+	}
+}
+
+func TestNewServer_kubernetesTLSSecrets(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	issued := func(name string, crt, key []byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "bar"},
+			Type:       corev1.SecretTypeTLS,
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       crt,
+				corev1.TLSPrivateKeyKey: key,
+				"ca.crt":                certificateBundle.CACrt.Bytes(),
+			},
+		}
+	}
+	tests := []struct {
+		name        string
+		credentials *transport.Credentials
+		objects     []ctrlclient.Object
+		wantIssued  bool
+	}{
+		{
+			name: "generated credentials, must be written as kubernetes.io/tls secrets",
+		},
+		{
+			name:        "secrets issued by cert-manager, must be used as credentials",
+			credentials: &transport.Credentials{SecretRef: types.NamespacedName{Namespace: "bar", Name: "transfer-tls"}},
+			objects: []ctrlclient.Object{
+				issued("transfer-tls", certificateBundle.ServerCrt.Bytes(), certificateBundle.ServerKey.Bytes()),
+				issued("transfer-tls-client", certificateBundle.ClientCrt.Bytes(), certificateBundle.ClientKey.Bytes()),
+			},
+			wantIssued: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fakeClientWithObjects(tt.objects...)
+			options := &transport.Options{KubernetesTLSSecrets: true, Credentials: tt.credentials}
+			s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), options)
+			if err != nil {
+				t.Fatalf("NewServer() error = %v", err)
+			}
+			clientRef := s.(transport.ClientCredentialsProvider).ClientCredentials()
+			for _, ref := range []types.NamespacedName{s.Credentials(), clientRef} {
+				secret := &corev1.Secret{}
+				if err := fakeClient.Get(ctx, ref, secret); err != nil {
+					t.Fatalf("unable to get secret %s %v", ref, err)
+				}
+				if secret.Type != corev1.SecretTypeTLS {
+					t.Errorf("secret %s type = %s, want %s", ref, secret.Type, corev1.SecretTypeTLS)
+				}
+				if _, ok := secret.Data[corev1.TLSPrivateKeyKey]; !ok {
+					t.Errorf("secret %s missing key %s", ref, corev1.TLSPrivateKeyKey)
+				}
+				if tt.wantIssued && !bytes.Equal(secret.Data["ca.crt"], certificateBundle.CACrt.Bytes()) {
+					t.Errorf("secret %s was regenerated", ref)
+				}
+			}
+			for _, volume := range s.Volumes() {
+				if volume.Secret == nil {
+					continue
+				}
+				if items := volume.Secret.Items; items[0].Key != corev1.TLSCertKey || items[0].Path != "server.crt" {
+					t.Errorf("server credentials items = %v, want tls.crt mounted as server.crt", items)
+				}
+			}
+
+			c, err := NewClient(ctx, fakeClient, testr.New(t), namespacedName, "example.com", 443, &transport.Options{
+				KubernetesTLSSecrets: true,
+				Credentials:          &transport.Credentials{SecretRef: clientRef},
+			})
+			if err != nil {
+				t.Fatalf("NewClient() error = %v", err)
+			}
+			healthy, err := c.IsHealthy(ctx, fakeClient)
+			if err != nil || !healthy {
+				t.Errorf("client IsHealthy() = %v, %v, want healthy", healthy, err)
+			}
+			for _, volume := range c.Volumes() {
+				if volume.Secret == nil {
+					continue
+				}
+				if items := volume.Secret.Items; items[1].Key != corev1.TLSPrivateKeyKey || items[1].Path != "client.key" {
+					t.Errorf("client credentials items = %v, want tls.key mounted as client.key", items)
+				}
+			}
+		})
+	}
+}
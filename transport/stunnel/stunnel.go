@@ -3,12 +3,12 @@ package stunnel
 import (
 	"bytes"
 	"context"
-	"crypto/rand"
+	"errors"
 	"fmt"
-	"math/big"
-
-	b64 "encoding/base64"
+	"strings"
 
+	"github.com/backube/pvc-transfer/internal/reconcile"
+	"github.com/backube/pvc-transfer/internal/secrets"
 	"github.com/backube/pvc-transfer/internal/utils"
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/backube/pvc-transfer/transport/tls/certs"
@@ -17,8 +17,8 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
 	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
 const (
@@ -27,6 +27,11 @@ const (
 	stunnelSecret       = "stunnel-creds"
 )
 
+const (
+	serverKeyPair = "server"
+	clientKeyPair = "client"
+)
+
 const (
 	CredentialsTypePSK transport.CredentialsType = "PSK"
 	CredentialsTypeSSL transport.CredentialsType = "SSL"
@@ -37,11 +42,229 @@ const (
 	Container                           = "stunnel"
 )
 
+const (
+	tlsVersion12 = "TLSv1.2"
+	tlsVersion13 = "TLSv1.3"
+	pskCiphers   = "PSK"
+)
+
+// ErrTLSOptionNotAllowed is returned when a TLS version, cipher suite or curve
+// requested in the transport options is not in the allow-list of the stunnel transport
+var ErrTLSOptionNotAllowed = errors.New("TLS option not allowed")
+
+var (
+	// allowedTLSVersions in increasing order
+	allowedTLSVersions = []string{tlsVersion12, tlsVersion13}
+	// allowedTLS13Ciphersuites are the TLSv1.3 cipher suites which can be requested
+	allowedTLS13Ciphersuites = map[string]bool{
+		"TLS_AES_128_GCM_SHA256":       true,
+		"TLS_AES_256_GCM_SHA384":       true,
+		"TLS_CHACHA20_POLY1305_SHA256": true,
+	}
+	// allowedTLS12Ciphers are the TLSv1.2 ciphers which can be requested with SSL credentials
+	allowedTLS12Ciphers = map[string]bool{
+		"ECDHE-ECDSA-AES128-GCM-SHA256": true,
+		"ECDHE-ECDSA-AES256-GCM-SHA384": true,
+		"ECDHE-RSA-AES128-GCM-SHA256":   true,
+		"ECDHE-RSA-AES256-GCM-SHA384":   true,
+	}
+	// allowedTLS12PSKCiphers are the TLSv1.2 ciphers which can be requested with PSK credentials
+	allowedTLS12PSKCiphers = map[string]bool{
+		"PSK-AES128-GCM-SHA256":     true,
+		"PSK-AES256-GCM-SHA384":     true,
+		"DHE-PSK-AES128-GCM-SHA256": true,
+		"DHE-PSK-AES256-GCM-SHA384": true,
+	}
+	allowedTLSCurves = map[string]bool{
+		"prime256v1": true,
+		"secp384r1":  true,
+		"secp521r1":  true,
+		"X25519":     true,
+		"X448":       true,
+	}
+)
+
+// getTLSConfig validates the TLS options against the allow-lists and renders the
+// global stunnel options restricting the protocol versions, ciphers and curves
+func getTLSConfig(options *transport.Options, usePSK bool) (string, error) {
+	var lines []string
+
+	requested := map[string]bool{}
+	for _, version := range options.TLSVersions {
+		if version != tlsVersion12 && version != tlsVersion13 {
+			return "", fmt.Errorf("%w: TLS version %s", ErrTLSOptionNotAllowed, version)
+		}
+		requested[version] = true
+	}
+	versions := []string{}
+	for _, version := range allowedTLSVersions {
+		if requested[version] {
+			versions = append(versions, version)
+		}
+	}
+	switch len(versions) {
+	case 0:
+		lines = append(lines, fmt.Sprintf("sslVersion = %s", tlsVersion13))
+	case 1:
+		lines = append(lines, fmt.Sprintf("sslVersion = %s", versions[0]))
+	default:
+		lines = append(lines,
+			fmt.Sprintf("sslVersionMin = %s", versions[0]),
+			fmt.Sprintf("sslVersionMax = %s", versions[len(versions)-1]))
+	}
+
+	allowedCiphers := allowedTLS12Ciphers
+	if usePSK {
+		allowedCiphers = allowedTLS12PSKCiphers
+	}
+	var ciphersuites, ciphers []string
+	for _, cipher := range options.TLSCiphersuites {
+		switch {
+		case allowedTLS13Ciphersuites[cipher]:
+			ciphersuites = append(ciphersuites, cipher)
+		case allowedCiphers[cipher]:
+			ciphers = append(ciphers, cipher)
+		default:
+			return "", fmt.Errorf("%w: cipher suite %s", ErrTLSOptionNotAllowed, cipher)
+		}
+	}
+	switch {
+	case len(ciphers) > 0:
+		lines = append(lines, fmt.Sprintf("ciphers = %s", strings.Join(ciphers, ":")))
+	case usePSK:
+		lines = append(lines, fmt.Sprintf("ciphers = %s", pskCiphers))
+	}
+	if len(ciphersuites) > 0 {
+		lines = append(lines, fmt.Sprintf("ciphersuites = %s", strings.Join(ciphersuites, ":")))
+	}
+
+	for _, curve := range options.TLSCurves {
+		if !allowedTLSCurves[curve] {
+			return "", fmt.Errorf("%w: curve %s", ErrTLSOptionNotAllowed, curve)
+		}
+	}
+	if len(options.TLSCurves) > 0 {
+		lines = append(lines, fmt.Sprintf("curves = %s", strings.Join(options.TLSCurves, ":")))
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
 func getImage(options *transport.Options) string {
-	if options.Image == "" {
-		return defaultStunnelImage
-	} else {
+	switch {
+	case options.StunnelImage != "":
+		return options.StunnelImage
+	case options.Image != "":
 		return options.Image
+	default:
+		return defaultStunnelImage
+	}
+}
+
+// getPort returns the port set in the transport options or the default port of
+// the stunnel transport when not set
+func getPort(port, defaultPort int32) (int32, error) {
+	if port == 0 {
+		return defaultPort, nil
+	}
+	if errs := validation.IsValidPortNum(int(port)); len(errs) > 0 {
+		return 0, fmt.Errorf("%w: %d: %s", transport.ErrTransportPortInvalid, port, strings.Join(errs, ", "))
+	}
+	return port, nil
+}
+
+// supervisorScript runs stunnel in the foreground and stops it gracefully once the transfer
+// creates the completion file or the container is asked to terminate
+const supervisorScript = `/bin/stunnel /etc/stunnel/stunnel.conf &
+STUNNEL_PID=$!
+trap 'kill -TERM ${STUNNEL_PID}; wait ${STUNNEL_PID}; exit 0' TERM INT
+while kill -0 ${STUNNEL_PID} 2> /dev/null; do
+	if [ -f %s ]; then
+		kill -TERM ${STUNNEL_PID}
+		wait ${STUNNEL_PID}
+		exit 0
+	fi
+	sleep 1 &
+	wait $!
+done
+wait ${STUNNEL_PID}
+`
+
+// getSupervisedCommand returns the command of stunnel containers terminating on transfer completion
+func getSupervisedCommand() []string {
+	return []string{"/bin/bash", "-c", fmt.Sprintf(supervisorScript, transport.CompletionFile)}
+}
+
+// proxyCABundleScript combines the CA file of the client credentials with the proxy CA bundle
+// into the CA file referenced by the stunnel config before starting stunnel
+const proxyCABundleScript = `cat %s /etc/stunnel/proxy-ca/proxy-ca.crt > /etc/stunnel/ca-bundle/ca.crt || exit 1
+`
+
+// getProxyCABundleCommand returns command preceded by the combination of caFile and the proxy CA bundle
+func getProxyCABundleCommand(command []string, caFile string) []string {
+	script := fmt.Sprintf(proxyCABundleScript, caFile)
+	if len(command) == 3 && command[1] == "-c" {
+		return []string{command[0], "-c", script + command[2]}
+	}
+	return []string{"/bin/bash", "-c", script + "exec " + strings.Join(command, " ")}
+}
+
+func getCompletionVolumeMount() corev1.VolumeMount {
+	return corev1.VolumeMount{
+		Name:      transport.CompletionVolumeName,
+		MountPath: transport.CompletionMountPath,
+	}
+}
+
+func getCompletionVolume() corev1.Volume {
+	return corev1.Volume{
+		Name: transport.CompletionVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory},
+		},
+	}
+}
+
+// stunnelProbeScript fails when the stunnel config is no longer readable or no stunnel
+// process is running, it does not dial the listen port since every connection to stunnel
+// opens a session to the other end of the tunnel
+const stunnelProbeScript = `[[ -r /etc/stunnel/stunnel.conf ]] || exit 1
+for comm in /proc/[0-9]*/comm; do
+    [[ "$(cat "${comm}" 2>/dev/null)" == stunnel ]] && exit 0
+done
+exit 1`
+
+// getReadinessProbe returns the readiness probe of a stunnel container
+func getReadinessProbe() *corev1.Probe {
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/bash", "-c", stunnelProbeScript},
+			},
+		},
+		PeriodSeconds:    5,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
+	}
+}
+
+// getLivenessProbe returns the liveness probe of a stunnel container, only native sidecars
+// have one. Their restartPolicy Always restarts stunnel when the probe fails. A liveness
+// probe can't be used with the restartPolicy Never of the other transfer containers, a
+// failing probe would only kill stunnel for good.
+func getLivenessProbe(nativeSidecar bool) *corev1.Probe {
+	if !nativeSidecar {
+		return nil
+	}
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/bash", "-c", stunnelProbeScript},
+			},
+		},
+		PeriodSeconds:    10,
+		TimeoutSeconds:   5,
+		FailureThreshold: 3,
 	}
 }
 
@@ -53,7 +276,20 @@ func getResourceName(obj types.NamespacedName, component, prefix string) string
 	return resourceName
 }
 
-func isTLSSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logger, secretRef types.NamespacedName) (bool, error) {
+func isTLSSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logger, secretRef types.NamespacedName, serverName string) (bool, error) {
+	return isKeyPairSecretValid(ctx, c, logger, secretRef, serverName, false, clientKeyPair, serverKeyPair)
+}
+
+// isKeyPairSecretValid checks that the secret holds ca.crt and the key pairs named by keyPairs,
+// signed by the CA. The server certificate must be issued for serverName when it is set.
+// Secrets in kubernetes.io/tls format hold the key pair of their side as tls.crt and tls.key.
+func isKeyPairSecretValid(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	secretRef types.NamespacedName,
+	serverName string,
+	tlsFormat bool,
+	keyPairs ...string) (bool, error) {
 	secret := &corev1.Secret{}
 	err := c.Get(ctx, secretRef, secret)
 	switch {
@@ -63,42 +299,94 @@ func isTLSSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logg
 		return false, err
 	}
 
-	_, ok := secret.Data["client.key"]
-	if !ok {
-		logger.Info("secret data missing key client.key", "secret", secretRef)
-		return false, nil
+	crts := map[string][]byte{}
+	for _, keyPair := range keyPairs {
+		crtKey, keyKey := secretKeys(keyPair, tlsFormat)
+		_, ok := secret.Data[keyKey]
+		if !ok {
+			logger.Info("secret data missing key "+keyKey, "secret", secretRef)
+			return false, nil
+		}
+		crts[keyPair], ok = secret.Data[crtKey]
+		if !ok {
+			logger.Info("secret data missing key "+crtKey, "secret", secretRef)
+			return false, nil
+		}
 	}
 
-	_, ok = secret.Data["server.key"]
+	ca, ok := secret.Data["ca.crt"]
 	if !ok {
-		logger.Info("secret data missing key server.key", "secret", secretRef)
+		logger.Info("secret data missing key ca.crt", "secret", secretRef)
 		return false, nil
 	}
 
-	clientCrt, ok := secret.Data["client.crt"]
-	if !ok {
-		logger.Info("secret data missing key client.crt", "secret", secretRef)
-		return false, nil
+	for _, keyPair := range keyPairs {
+		verified, err := certs.VerifyCertificate(bytes.NewBuffer(ca), bytes.NewBuffer(crts[keyPair]))
+		if err != nil {
+			crtKey, _ := secretKeys(keyPair, tlsFormat)
+			return verified, fmt.Errorf("%w: %s in secret %s: %v", transport.ErrTransportSecretInvalid, crtKey, secretRef, err)
+		}
+		if !verified {
+			return false, nil
+		}
 	}
 
-	serverCrt, ok := secret.Data["server.crt"]
-	if !ok {
-		logger.Info("secret data missing key server.crt", "secret", secretRef)
-		return false, nil
+	serverCrt, ok := crts[serverKeyPair]
+	if !ok || serverName == "" {
+		return true, nil
 	}
 
-	ca, ok := secret.Data["ca.crt"]
-	if !ok {
-		logger.Info("secret data missing key ca.crt", "secret", secretRef)
-		return false, nil
+	verified, err := certs.VerifyHostname(bytes.NewBuffer(serverCrt), serverName)
+	if err != nil {
+		return verified, fmt.Errorf("%w: server.crt in secret %s: %v", transport.ErrTransportSecretInvalid, secretRef, err)
+	}
+	if !verified {
+		logger.Info("server.crt not issued for server hostname", "secret", secretRef, "hostname", serverName)
 	}
+	return verified, nil
+}
 
-	verified, err := certs.VerifyCertificate(bytes.NewBuffer(ca), bytes.NewBuffer(clientCrt))
-	if err != nil {
-		return verified, err
+// secretKeys returns the keys of the certificate and the private key of a key pair in the
+// credentials secrets
+func secretKeys(keyPair string, tlsFormat bool) (crtKey string, keyKey string) {
+	if tlsFormat {
+		return corev1.TLSCertKey, corev1.TLSPrivateKeyKey
 	}
+	return keyPair + ".crt", keyPair + ".key"
+}
 
-	return certs.VerifyCertificate(bytes.NewBuffer(ca), bytes.NewBuffer(serverCrt))
+// isScopedTLSSecretValid checks the secrets of scoped credentials, the server key pair must be
+// valid in secretRef and the client key pair in the client secret, both signed by the same CA
+func isScopedTLSSecretValid(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	secretRef types.NamespacedName,
+	serverName string,
+	tlsFormat bool) (bool, error) {
+	clientRef := getClientCredentialsSecretRef(secretRef)
+	valid, err := isKeyPairSecretValid(ctx, c, logger, secretRef, serverName, tlsFormat, serverKeyPair)
+	if err != nil || !valid {
+		return valid, err
+	}
+	valid, err = isKeyPairSecretValid(ctx, c, logger, clientRef, "", tlsFormat, clientKeyPair)
+	if err != nil || !valid {
+		return valid, err
+	}
+
+	serverSecret, clientSecret := &corev1.Secret{}, &corev1.Secret{}
+	err = c.Get(ctx, secretRef, serverSecret)
+	if err != nil {
+		return false, err
+	}
+	err = c.Get(ctx, clientRef, clientSecret)
+	if err != nil {
+		return false, err
+	}
+	if !bytes.Equal(serverSecret.Data["ca.crt"], clientSecret.Data["ca.crt"]) {
+		logger.Info("server and client secrets hold different CAs", "secret", secretRef, "clientSecret", clientRef)
+		return false, nil
+	}
+	return true, nil
 }
 
 func isPSKSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logger, secretRef types.NamespacedName) (bool, error) {
@@ -111,7 +399,7 @@ func isPSKSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logg
 		return false, err
 	}
 
-	_, ok := secret.Data["key"]
+	_, ok := secret.Data[pskKey]
 	if !ok {
 		logger.Info("secret data missing PSK key", "secret", secretRef)
 		return false, nil
@@ -120,12 +408,15 @@ func isPSKSecretValid(ctx context.Context, c ctrlclient.Client, logger logr.Logg
 	return true, nil
 }
 
-// reconcileCredentialSecret reconciles credential secrets for a stunnel transport
+// reconcileCredentialSecret reconciles credential secrets for a stunnel transport, the server
+// certificate is issued for serverHostname when clients verify the hostname of the server.
+// Clients of scoped credentials cannot issue them, they expect the client secret of the server.
 func reconcileCredentialSecret(ctx context.Context,
 	c ctrlclient.Client,
 	logger logr.Logger,
 	t transport.Transport,
-	o *transport.Options) error {
+	o *transport.Options,
+	component, serverHostname string) error {
 	var err error
 	secretValid := false
 	credType := CredentialsTypeSSL
@@ -135,6 +426,13 @@ func reconcileCredentialSecret(ctx context.Context,
 		}
 	}
 	secretRef := getCredentialsSecretRef(t, o.Credentials)
+	if hasProvider(o.Credentials) {
+		return o.Credentials.Provider.Reconcile(ctx, c, logger, secretRef, credentialKeys(o, component), o)
+	}
+	serverName := ""
+	if o.VerifyServerHostname {
+		serverName = serverHostname
+	}
 
 	switch credType {
 	case CredentialsTypePSK:
@@ -144,7 +442,7 @@ func reconcileCredentialSecret(ctx context.Context,
 			return err
 		}
 	case CredentialsTypeSSL:
-		secretValid, err = isTLSSecretValid(ctx, c, logger, secretRef)
+		secretValid, err = isSSLSecretValid(ctx, c, logger, o, secretRef, component, serverName)
 		if err != nil {
 			logger.Error(err, "error getting existing ssl certs from secret")
 			return err
@@ -157,46 +455,127 @@ func reconcileCredentialSecret(ctx context.Context,
 		logger.V(4).Info("found secret with valid certs")
 		return nil
 	}
+	if credType == CredentialsTypeSSL && isScoped(o) && component == "client" {
+		return fmt.Errorf("%w: client credentials in secret %s are missing or invalid, scoped credentials are issued by the transport server",
+			transport.ErrTransportSecretInvalid, secretRef)
+	}
 
 	logger.Info("generating new certificate bundle")
 
 	switch credType {
 	case CredentialsTypeSSL:
-		crtBundle, err := certs.New()
+		crtBundle, err := issueCertificateBundle(ctx, c, logger, secretRef, o, serverName)
 		if err != nil {
 			logger.Error(err, "error generating ssl certs for stunnel server")
 			return err
 		}
-		return reconcileSSLSecret(ctx, c, secretRef, o, crtBundle)
+		if isScoped(o) {
+			return reconcileScopedSSLSecrets(ctx, c, logger, secretRef, o, crtBundle)
+		}
+		return reconcileSSLSecret(ctx, c, logger, secretRef, o, map[string][]byte{
+			"server.crt": crtBundle.ServerCrt.Bytes(),
+			"server.key": crtBundle.ServerKey.Bytes(),
+			"client.crt": crtBundle.ClientCrt.Bytes(),
+			"client.key": crtBundle.ClientKey.Bytes(),
+			"ca.crt":     crtBundle.CACrt.Bytes(),
+			"ca.key":     crtBundle.CAKey.Bytes(),
+		})
+	default:
+		return reconcilePSKSecret(ctx, c, logger, secretRef, o)
+	}
+}
+
+// isSSLSecretValid checks the SSL credentials needed by the component of the transport
+func isSSLSecretValid(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	o *transport.Options,
+	secretRef types.NamespacedName,
+	component, serverName string) (bool, error) {
+	switch {
+	case isScoped(o) && component == "client":
+		return isKeyPairSecretValid(ctx, c, logger, secretRef, "", o.KubernetesTLSSecrets, clientKeyPair)
+	case isScoped(o):
+		return isScopedTLSSecretValid(ctx, c, logger, secretRef, serverName, o.KubernetesTLSSecrets)
 	default:
-		return reconcilePSKSecret(ctx, c, secretRef, o)
+		return isTLSSecretValid(ctx, c, logger, secretRef, serverName)
 	}
 }
 
+// issueCertificateBundle issues the certificates of the credentials stored in secretRef. Split
+// credentials reuse the CA stored in the CA secret, a new CA is stored there when it is missing
+// or invalid.
+func issueCertificateBundle(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	secretRef types.NamespacedName,
+	o *transport.Options,
+	serverName string) (*certs.CertificateBundle, error) {
+	if !o.SplitCredentials {
+		return certs.NewWithServerNames(serverNames(serverName)...)
+	}
+	caRef := getCACredentialsSecretRef(secretRef)
+	caSecret := &corev1.Secret{}
+	err := c.Get(ctx, caRef, caSecret)
+	switch {
+	case k8serrors.IsNotFound(err):
+	case err != nil:
+		return nil, err
+	default:
+		crtBundle, err := certs.NewFromCA(bytes.NewBuffer(caSecret.Data["ca.crt"]), bytes.NewBuffer(caSecret.Data["ca.key"]),
+			serverNames(serverName)...)
+		if err == nil {
+			logger.Info("issuing certificates with the existing CA", "secret", caRef)
+			return crtBundle, nil
+		}
+		logger.Info("unable to issue certificates with the existing CA, generating a new CA", "secret", caRef, "error", err.Error())
+	}
+
+	crtBundle, err := certs.NewWithServerNames(serverNames(serverName)...)
+	if err != nil {
+		return nil, err
+	}
+	err = reconcileSSLSecret(ctx, c, logger, caRef, o, map[string][]byte{
+		"ca.crt": crtBundle.CACrt.Bytes(),
+		"ca.key": crtBundle.CAKey.Bytes(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crtBundle, nil
+}
+
+func serverNames(serverName string) []string {
+	if serverName == "" {
+		return nil
+	}
+	return []string{serverName}
+}
+
 // reconcileSSLSecret reconciles secret of TLS type
 func reconcileSSLSecret(ctx context.Context,
 	c ctrlclient.Client,
+	logger logr.Logger,
 	secretRef types.NamespacedName,
 	options *transport.Options,
-	crtBundle *certs.CertificateBundle) error {
+	data map[string][]byte) error {
 	crtBundleSecret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: secretRef.Namespace,
 			Name:      secretRef.Name,
 		},
 	}
-	_, err := controllerutil.CreateOrUpdate(ctx, c, crtBundleSecret, func() error {
+	_, err := reconcile.CreateOrUpdate(ctx, c, logger, crtBundleSecret, reconcileOptions(options), func() error {
 		crtBundleSecret.Labels = options.Labels
 		crtBundleSecret.OwnerReferences = options.Owners
 
-		crtBundleSecret.Data = map[string][]byte{
-			"server.crt": crtBundle.ServerCrt.Bytes(),
-			"server.key": crtBundle.ServerKey.Bytes(),
-			"client.crt": crtBundle.ClientCrt.Bytes(),
-			"client.key": crtBundle.ClientKey.Bytes(),
-			"ca.crt":     crtBundle.CACrt.Bytes(),
-			"ca.key":     crtBundle.CAKey.Bytes(),
+		// the type of a secret is immutable, it is only set when the secret is created
+		if options.KubernetesTLSSecrets && crtBundleSecret.CreationTimestamp.IsZero() {
+			if _, ok := data[corev1.TLSCertKey]; ok {
+				crtBundleSecret.Type = corev1.SecretTypeTLS
+			}
 		}
+		crtBundleSecret.Data = data
 		return nil
 	})
 	if err != nil {
@@ -206,9 +585,38 @@ func reconcileSSLSecret(ctx context.Context,
 	return err
 }
 
+// reconcileScopedSSLSecrets reconciles the secrets of scoped credentials, the private key of the
+// CA is dropped and each secret holds the key pair of one side only. The client secret holds the
+// server certificate for clients pinning it, it is written first so that a failure is detected
+// by the CA check of the server secret on the next reconcile.
+func reconcileScopedSSLSecrets(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	secretRef types.NamespacedName,
+	options *transport.Options,
+	crtBundle *certs.CertificateBundle) error {
+	clientCrtKey, clientKeyKey := secretKeys(clientKeyPair, options.KubernetesTLSSecrets)
+	err := reconcileSSLSecret(ctx, c, logger, getClientCredentialsSecretRef(secretRef), options, map[string][]byte{
+		clientCrtKey: crtBundle.ClientCrt.Bytes(),
+		clientKeyKey: crtBundle.ClientKey.Bytes(),
+		"server.crt": crtBundle.ServerCrt.Bytes(),
+		"ca.crt":     crtBundle.CACrt.Bytes(),
+	})
+	if err != nil {
+		return err
+	}
+	serverCrtKey, serverKeyKey := secretKeys(serverKeyPair, options.KubernetesTLSSecrets)
+	return reconcileSSLSecret(ctx, c, logger, secretRef, options, map[string][]byte{
+		serverCrtKey: crtBundle.ServerCrt.Bytes(),
+		serverKeyKey: crtBundle.ServerKey.Bytes(),
+		"ca.crt":     crtBundle.CACrt.Bytes(),
+	})
+}
+
 // reconcilePSKSecret reconciles secret of TLS type
 func reconcilePSKSecret(ctx context.Context,
 	c ctrlclient.Client,
+	logger logr.Logger,
 	secretRef types.NamespacedName,
 	options *transport.Options) error {
 	pskSecret := &corev1.Secret{
@@ -217,18 +625,16 @@ func reconcilePSKSecret(ctx context.Context,
 			Name:      secretRef.Name,
 		},
 	}
-	pass, err := GeneratePassword()
+	entry, err := newPSKEntry(pskIdentity)
 	if err != nil {
 		return err
 	}
-	_, err = controllerutil.CreateOrUpdate(ctx, c, pskSecret, func() error {
+	_, err = reconcile.CreateOrUpdate(ctx, c, logger, pskSecret, reconcileOptions(options), func() error {
 		pskSecret.Labels = options.Labels
 		pskSecret.OwnerReferences = options.Owners
 
-		// stunnel requires key to be base64 encoded
 		pskSecret.Data = map[string][]byte{
-			"key": []byte(fmt.Sprintf(
-				"root:%s\n", b64.StdEncoding.EncodeToString([]byte(pass)))),
+			pskKey: formatPSKEntries([]pskEntry{entry}),
 		}
 		return nil
 	})
@@ -239,6 +645,15 @@ func reconcilePSKSecret(ctx context.Context,
 	return err
 }
 
+// reconcileOptions returns the options used to write the stunnel resources to the cluster
+func reconcileOptions(options *transport.Options) reconcile.Options {
+	return reconcile.Options{
+		ServerSideApply: options.ServerSideApply,
+		FieldManager:    options.FieldManager,
+		Adopt:           options.Adopt,
+	}
+}
+
 func getCredentialsSecretRef(t transport.Transport, c *transport.Credentials) types.NamespacedName {
 	secretRef := types.NamespacedName{
 		Name:      getResourceName(t.NamespacedName(), "certs", stunnelSecret),
@@ -252,30 +667,99 @@ func getCredentialsSecretRef(t transport.Transport, c *transport.Credentials) ty
 	return secretRef
 }
 
-func getCredentialsVolumeSource(t transport.Transport, c *transport.Credentials, key string) corev1.VolumeSource {
+// getClientCredentialsSecretRef returns the secret holding the client key pair of the scoped
+// credentials stored in secretRef
+func getClientCredentialsSecretRef(secretRef types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{Namespace: secretRef.Namespace, Name: secretRef.Name + "-client"}
+}
+
+// getCACredentialsSecretRef returns the secret holding the CA of the split credentials stored
+// in secretRef
+func getCACredentialsSecretRef(secretRef types.NamespacedName) types.NamespacedName {
+	return types.NamespacedName{Namespace: secretRef.Namespace, Name: secretRef.Name + "-ca"}
+}
+
+// isScoped returns whether each secret holds the key pair of one side only
+func isScoped(o *transport.Options) bool {
+	return o.ScopedCredentials || o.SplitCredentials || o.KubernetesTLSSecrets
+}
+
+// hasProvider returns whether the credentials are read from a secret store
+func hasProvider(c *transport.Credentials) bool {
+	return c != nil && c.Provider != nil
+}
+
+// oneWayTLS returns whether the provider of the credentials issues only a server
+// certificate, clients are not authenticated
+func oneWayTLS(c *transport.Credentials) bool {
+	if !hasProvider(c) {
+		return false
+	}
+	p, ok := c.Provider.(transport.OneWayTLSProvider)
+	return ok && p.OneWayTLS()
+}
+
+// credentialKeys returns the keys of the credentials read by the component of the transport
+func credentialKeys(o *transport.Options, component string) []string {
+	if isPSK(o.Credentials) {
+		return []string{pskKey}
+	}
+	keys := []string{component + ".crt", component + ".key", "ca.crt"}
+	if component == "client" && o.PinServerCertificate {
+		keys = append(keys, "server.crt")
+	}
+	return keys
+}
+
+func isPSK(c *transport.Credentials) bool {
+	return c != nil && c.Type == CredentialsTypePSK
+}
+
+// ClientCredentialsVolumeSource returns the volume source of the credentials of a transport
+// client, projecting them to the paths read by stunnel clients whatever the format of the
+// secret: client.crt, client.key and ca.crt. Clients of one way credentials only get ca.crt.
+func ClientCredentialsVolumeSource(o *transport.Options) corev1.VolumeSource {
+	return getCredentialsVolumeSource(o.Credentials.SecretRef, o, "client")
+}
+
+func getCredentialsVolumeSource(secretRef types.NamespacedName, o *transport.Options, key string) corev1.VolumeSource {
+	c := o.Credentials
+	crtKey, keyKey := secretKeys(key, o.KubernetesTLSSecrets)
 	sslItems := []corev1.KeyToPath{
-		{
-			Key:  fmt.Sprintf("%s.crt", key),
-			Path: fmt.Sprintf("%s.crt", key),
-		},
-		{
-			Key:  fmt.Sprintf("%s.key", key),
-			Path: fmt.Sprintf("%s.key", key),
-		},
 		{
 			Key:  "ca.crt",
 			Path: "ca.crt",
 		},
 	}
+	// one way providers issue no client certificate
+	if key != "client" || !oneWayTLS(c) {
+		sslItems = append([]corev1.KeyToPath{
+			{
+				Key:  crtKey,
+				Path: fmt.Sprintf("%s.crt", key),
+			},
+			{
+				Key:  keyKey,
+				Path: fmt.Sprintf("%s.key", key),
+			},
+		}, sslItems...)
+	}
 	pskItems := []corev1.KeyToPath{
 		{
 			Key:  "key",
 			Path: "key",
 		},
 	}
+	if hasProvider(c) {
+		items := sslItems
+		if isPSK(c) {
+			items = pskItems
+		}
+		return c.Provider.VolumeSource(secretRef, items)
+	}
 	volumeSource := corev1.VolumeSource{
 		Secret: &corev1.SecretVolumeSource{
-			SecretName: getCredentialsSecretRef(t, c).Name,
+			SecretName: secretRef.Name,
 			Items:      sslItems,
 		},
 	}
@@ -293,6 +777,111 @@ func getCredentialsVolumeSource(t transport.Transport, c *transport.Credentials,
 	return volumeSource
 }
 
+// containerFailureReasons are the waiting reasons of stunnel containers which cannot
+// start without changes to the transport configuration or options
+var containerFailureReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ErrImagePull":               true,
+	"ImagePullBackOff":           true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// isHealthy checks that the config and credentials of the transport exist and are valid, and
+// that the stunnel containers of the pods mounting the config are running. Pods are optional,
+// a transport whose containers were not added to a pod yet is healthy as long as its resources are.
+func isHealthy(ctx context.Context,
+	c ctrlclient.Client,
+	logger logr.Logger,
+	t transport.Transport,
+	o *transport.Options,
+	component, serverHostname string) (bool, error) {
+	configRef := types.NamespacedName{
+		Namespace: t.NamespacedName().Namespace,
+		Name:      getResourceName(t.NamespacedName(), component, stunnelConfig),
+	}
+	cm := &corev1.ConfigMap{}
+	err := c.Get(ctx, configRef, cm)
+	switch {
+	case k8serrors.IsNotFound(err):
+		return false, fmt.Errorf("%w: configmap %s not found", transport.ErrTransportMisconfigured, configRef)
+	case err != nil:
+		logger.Error(err, "unable to get stunnel config")
+		return false, err
+	}
+	if _, ok := cm.Data["stunnel.conf"]; !ok {
+		return false, fmt.Errorf("%w: configmap %s missing stunnel.conf", transport.ErrTransportMisconfigured, configRef)
+	}
+
+	secretRef := getCredentialsSecretRef(t, o.Credentials)
+	if hasProvider(o.Credentials) {
+		ready, err := o.Credentials.Provider.IsReady(ctx, c, secretRef, credentialKeys(o, component))
+		if err != nil || !ready {
+			logger.Info("credentials not available from the provider yet", "secret", secretRef)
+			return false, err
+		}
+		return isPodHealthy(ctx, c, logger, configRef, o.Labels)
+	}
+	var secretValid bool
+	if isPSK(o.Credentials) {
+		secretValid, err = isPSKSecretValid(ctx, c, logger, secretRef)
+	} else {
+		serverName := ""
+		if o.VerifyServerHostname {
+			serverName = serverHostname
+		}
+		secretValid, err = isSSLSecretValid(ctx, c, logger, o, secretRef, component, serverName)
+	}
+	if err != nil {
+		return false, err
+	}
+	if !secretValid {
+		return false, fmt.Errorf("%w: credentials in secret %s are missing or invalid", transport.ErrTransportMisconfigured, secretRef)
+	}
+	return isPodHealthy(ctx, c, logger, configRef, o.Labels)
+}
+
+// isPodHealthy checks that the stunnel containers of the pods with the labels of the transport
+// mounting the config are running
+func isPodHealthy(ctx context.Context, c ctrlclient.Client, logger logr.Logger, configRef types.NamespacedName, labels map[string]string) (bool, error) {
+	pods := &corev1.PodList{}
+	err := c.List(ctx, pods, ctrlclient.InNamespace(configRef.Namespace), ctrlclient.MatchingLabels(labels))
+	if err != nil {
+		logger.Error(err, "unable to list pods running the transport")
+		return false, err
+	}
+	healthy := true
+	for _, pod := range pods.Items {
+		if !mountsConfigMap(pod, configRef.Name) {
+			continue
+		}
+		for _, status := range transport.ContainerStatuses(&pod) {
+			if status.Name != Container {
+				continue
+			}
+			if status.State.Waiting != nil && containerFailureReasons[status.State.Waiting.Reason] {
+				return false, fmt.Errorf("%w: container %s of pod %s: %s %s", transport.ErrTransportMisconfigured,
+					Container, pod.Name, status.State.Waiting.Reason, status.State.Waiting.Message)
+			}
+			if !status.Ready {
+				logger.Info("stunnel container not ready", "pod", pod.Name)
+				healthy = false
+			}
+		}
+	}
+	return healthy, nil
+}
+
+func mountsConfigMap(pod corev1.Pod, name string) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.ConfigMap != nil && volume.ConfigMap.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
 func markForCleanup(ctx context.Context, c ctrlclient.Client, objKey types.NamespacedName, key, value, component string) error {
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
@@ -305,34 +894,57 @@ func markForCleanup(ctx context.Context, c ctrlclient.Client, objKey types.Names
 		return err
 	}
 
-	clientSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      getResourceName(objKey, "certs", stunnelSecret),
-			Namespace: objKey.Namespace,
-		},
+	secretRef := types.NamespacedName{
+		Name:      getResourceName(objKey, "certs", stunnelSecret),
+		Namespace: objKey.Namespace,
 	}
-	err = utils.UpdateWithLabel(ctx, c, clientSecret, key, value)
-	switch {
-	case k8serrors.IsNotFound(err):
-		break
-	case err != nil:
-		return err
+	for _, ref := range []types.NamespacedName{
+		secretRef, getClientCredentialsSecretRef(secretRef), getCACredentialsSecretRef(secretRef),
+	} {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      ref.Name,
+				Namespace: ref.Namespace,
+			},
+		}
+		err = utils.UpdateWithLabel(ctx, c, secret, key, value)
+		switch {
+		case k8serrors.IsNotFound(err):
+			break
+		case err != nil:
+			return err
+		}
 	}
 
 	return nil
 }
 
-// GeneratePassword can be used to generate random character string of 32 bytes
+// GeneratePassword can be used to generate random alphanumeric string of 32 characters
 func GeneratePassword() (string, error) {
-	var letters = []byte("abcdefghijklmnopqrstuvwxyz0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ")
-	password := make([]byte, 32)
-	for i := 0; i < 32; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
-		if err != nil {
-			return "", err
-		}
-		password = append(password, letters[num.Int64()])
-	}
+	return secrets.GeneratePassword()
+}
 
-	return string(password), nil
+// CredentialsFingerprints returns the SHA-256 fingerprints of the certificates of the SSL
+// credentials stored in secretRef, operators compare the fingerprints of the credentials of
+// the transport server and client to verify out-of-band that both hold matching bundles.
+// Fingerprints of certificates missing from the secret are empty.
+//
+// In order to generate the right RBAC, add the following lines to the Reconcile function annotations.
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+func CredentialsFingerprints(ctx context.Context, c ctrlclient.Client, secretRef types.NamespacedName) (certs.Fingerprints, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, secretRef, secret)
+	if err != nil {
+		return certs.Fingerprints{}, err
+	}
+	bundle := &certs.CertificateBundle{
+		CACrt:     bytes.NewBuffer(secret.Data["ca.crt"]),
+		ServerCrt: bytes.NewBuffer(secret.Data["server.crt"]),
+		ClientCrt: bytes.NewBuffer(secret.Data["client.crt"]),
+	}
+	fingerprints, err := bundle.Fingerprints()
+	if err != nil {
+		return certs.Fingerprints{}, fmt.Errorf("%w: secret %s: %v", transport.ErrTransportSecretInvalid, secretRef, err)
+	}
+	return fingerprints, nil
 }
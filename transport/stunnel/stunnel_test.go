@@ -2,13 +2,14 @@ package stunnel
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
 	"testing"
 
 	"github.com/backube/pvc-transfer/transport"
 	"github.com/backube/pvc-transfer/transport/tls/certs"
-	logrtesting "github.com/go-logr/logr/testing"
+	"github.com/go-logr/logr/testr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -109,7 +110,7 @@ func Test_getExistingCert(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &server{
-				logger:         logrtesting.TestLogger{T: t},
+				logger:         testr.New(t),
 				namespacedName: tt.namespacedName,
 				options: &transport.Options{
 					Labels: tt.labels,
@@ -121,7 +122,7 @@ func Test_getExistingCert(t *testing.T) {
 				Name:      fmt.Sprintf("%s-%s-%s", stunnelSecret, "foo", s.namespacedName.Name),
 			}
 			ctx := context.WithValue(context.Background(), "test", tt.name)
-			found, err := isTLSSecretValid(ctx, fakeClientWithObjects(tt.objects...), s.logger, secretRef)
+			found, err := isTLSSecretValid(ctx, fakeClientWithObjects(tt.objects...), s.logger, secretRef, "")
 			if err != nil {
 				t.Error("found unexpected error", err)
 			}
@@ -135,6 +136,58 @@ func Test_getExistingCert(t *testing.T) {
 	}
 }
 
+func Test_isTLSSecretValid_invalidData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		wantErr error
+	}{
+		{
+			name: "ca.crt cannot be parsed, must return ErrTransportSecretInvalid",
+			data: map[string][]byte{
+				"server.key": certificateBundle.ServerKey.Bytes(), "server.crt": certificateBundle.ServerCrt.Bytes(),
+				"client.key": certificateBundle.ClientKey.Bytes(), "client.crt": certificateBundle.ClientCrt.Bytes(),
+				"ca.crt": []byte("invalid"),
+			},
+			wantErr: transport.ErrTransportSecretInvalid,
+		},
+		{
+			name: "server.crt cannot be decoded, must return ErrTransportSecretInvalid",
+			data: map[string][]byte{
+				"server.key": certificateBundle.ServerKey.Bytes(), "server.crt": []byte("invalid"),
+				"client.key": certificateBundle.ClientKey.Bytes(), "client.crt": certificateBundle.ClientCrt.Bytes(),
+				"ca.crt": certificateBundle.CACrt.Bytes(),
+			},
+			wantErr: transport.ErrTransportSecretInvalid,
+		},
+		{
+			name: "valid secret, must not return error",
+			data: map[string][]byte{
+				"server.key": certificateBundle.ServerKey.Bytes(), "server.crt": certificateBundle.ServerCrt.Bytes(),
+				"client.key": certificateBundle.ClientKey.Bytes(), "client.crt": certificateBundle.ClientCrt.Bytes(),
+				"ca.crt": certificateBundle.CACrt.Bytes(),
+			},
+			wantErr: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secretRef := types.NamespacedName{Namespace: "bar", Name: "foo"}
+			c := fakeClientWithObjects(&corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace},
+				Data:       tt.data,
+			})
+			_, err := isTLSSecretValid(context.TODO(), c, testr.New(t), secretRef, "")
+			if tt.wantErr == nil && err != nil {
+				t.Errorf("isTLSSecretValid() unexpected error = %v", err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("isTLSSecretValid() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_mrkForCleanup(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -253,3 +306,144 @@ func Test_mrkForCleanup(t *testing.T) {
 		})
 	}
 }
+
+func Test_getTLSConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *transport.Options
+		usePSK  bool
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "no TLS options, must default to TLSv1.3",
+			options: &transport.Options{},
+			want:    "sslVersion = TLSv1.3",
+		},
+		{
+			name:    "no TLS options with PSK, must default to PSK ciphers",
+			options: &transport.Options{},
+			usePSK:  true,
+			want:    "sslVersion = TLSv1.3\nciphers = PSK",
+		},
+		{
+			name: "TLSv1.2 and TLSv1.3 with FIPS ciphers and curves, must render all options",
+			options: &transport.Options{
+				TLSVersions:     []string{"TLSv1.3", "TLSv1.2"},
+				TLSCiphersuites: []string{"TLS_AES_256_GCM_SHA384", "ECDHE-RSA-AES256-GCM-SHA384"},
+				TLSCurves:       []string{"secp384r1", "prime256v1"},
+			},
+			want: "sslVersionMin = TLSv1.2\nsslVersionMax = TLSv1.3\n" +
+				"ciphers = ECDHE-RSA-AES256-GCM-SHA384\nciphersuites = TLS_AES_256_GCM_SHA384\n" +
+				"curves = secp384r1:prime256v1",
+		},
+		{
+			name: "PSK ciphers with PSK credentials, must render the ciphers",
+			options: &transport.Options{
+				TLSVersions:     []string{"TLSv1.2"},
+				TLSCiphersuites: []string{"PSK-AES256-GCM-SHA384"},
+			},
+			usePSK: true,
+			want:   "sslVersion = TLSv1.2\nciphers = PSK-AES256-GCM-SHA384",
+		},
+		{
+			name:    "TLSv1.0, must return ErrTLSOptionNotAllowed",
+			options: &transport.Options{TLSVersions: []string{"TLSv1"}},
+			wantErr: ErrTLSOptionNotAllowed,
+		},
+		{
+			name:    "weak cipher, must return ErrTLSOptionNotAllowed",
+			options: &transport.Options{TLSCiphersuites: []string{"RC4-SHA"}},
+			wantErr: ErrTLSOptionNotAllowed,
+		},
+		{
+			name:    "PSK cipher with SSL credentials, must return ErrTLSOptionNotAllowed",
+			options: &transport.Options{TLSCiphersuites: []string{"PSK-AES256-GCM-SHA384"}},
+			wantErr: ErrTLSOptionNotAllowed,
+		},
+		{
+			name:    "unknown curve, must return ErrTLSOptionNotAllowed",
+			options: &transport.Options{TLSCurves: []string{"secp112r1"}},
+			wantErr: ErrTLSOptionNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := getTLSConfig(tt.options, tt.usePSK)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("getTLSConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("getTLSConfig() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_getImage(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *transport.Options
+		want    string
+	}{
+		{
+			name:    "no image, must return the default image",
+			options: &transport.Options{},
+			want:    defaultStunnelImage,
+		},
+		{
+			name:    "image set, must return it",
+			options: &transport.Options{Image: "registry.local/stunnel:v1"},
+			want:    "registry.local/stunnel:v1",
+		},
+		{
+			name:    "stunnel image set, must take precedence over image",
+			options: &transport.Options{Image: "registry.local/stunnel:v1", StunnelImage: "registry.local/stunnel:v2"},
+			want:    "registry.local/stunnel:v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := getImage(tt.options); got != tt.want {
+				t.Errorf("getImage() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCredentialsFingerprints(t *testing.T) {
+	ctx := context.Background()
+	namespacedName := types.NamespacedName{Namespace: "bar", Name: "foo"}
+	fakeClient := fakeClientWithObjects()
+	s, err := NewServer(ctx, fakeClient, testr.New(t), namespacedName, newFakeEndpoint(), &transport.Options{ScopedCredentials: true})
+	if err != nil {
+		t.Fatalf("NewServer() error = %v", err)
+	}
+	server, err := CredentialsFingerprints(ctx, fakeClient, s.Credentials())
+	if err != nil {
+		t.Fatalf("CredentialsFingerprints() error = %v", err)
+	}
+	client, err := CredentialsFingerprints(ctx, fakeClient, s.(transport.ClientCredentialsProvider).ClientCredentials())
+	if err != nil {
+		t.Fatalf("CredentialsFingerprints() error = %v", err)
+	}
+	if server.CA == "" || server.CA != client.CA || server.Server != client.Server {
+		t.Errorf("CredentialsFingerprints() server = %+v, client = %+v, want matching CA and server certificate", server, client)
+	}
+	if server.Client != "" || client.Client == "" {
+		t.Errorf("CredentialsFingerprints() server = %+v, client = %+v, want the client certificate in the client secret only", server, client)
+	}
+
+	secretRef := types.NamespacedName{Namespace: "bar", Name: "invalid"}
+	err = fakeClient.Create(ctx, &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretRef.Name, Namespace: secretRef.Namespace},
+		Data:       map[string][]byte{"ca.crt": []byte("invalid")},
+	})
+	if err != nil {
+		t.Fatalf("unable to create secret %v", err)
+	}
+	_, err = CredentialsFingerprints(ctx, fakeClient, secretRef)
+	if !errors.Is(err, transport.ErrTransportSecretInvalid) {
+		t.Errorf("CredentialsFingerprints() error = %v, want ErrTransportSecretInvalid", err)
+	}
+}
@@ -4,14 +4,20 @@ import (
 	"bytes"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"math/big"
+	"net"
+	"strings"
 	"time"
 )
 
+// serialNumberLimit bounds the random serial numbers of issued certificates to 128 bits
+var serialNumberLimit = new(big.Int).Lsh(big.NewInt(1), 128)
+
 var (
 	keySize          = 2048
 	defaultCASubject = &pkix.Name{
@@ -53,6 +59,13 @@ type CertificateBundle struct {
 // ideally be persisted in kubernetes objects (secrets) by consumers. If the secret is
 // lost or deleted, New should be called again to get a fresh bundle.
 func New() (*CertificateBundle, error) {
+	return NewWithServerNames()
+}
+
+// NewWithServerNames returns a CertificateBundle like New, the server certificate is
+// issued for the given hostnames or IP addresses so that clients can verify the identity
+// of the server beyond the CA signature.
+func NewWithServerNames(serverNames ...string) (*CertificateBundle, error) {
 	c := &CertificateBundle{}
 	var err error
 	c.CACrt, c.caRSAKey, c.caCrtTemplate, err = GenerateCA(defaultCASubject)
@@ -62,18 +75,176 @@ func New() (*CertificateBundle, error) {
 
 	c.CAKey, err = rsaKeyBytes(c.caRSAKey)
 
-	c.ServerCrt, c.ServerKey, err = Generate(defaultCrtSubject, *c.caCrtTemplate, *c.caRSAKey)
+	err = c.issueKeyPairs(serverNames)
 	if err != nil {
 		return nil, err
 	}
+	return c, nil
+}
 
-	c.ClientCrt, c.ClientKey, err = Generate(defaultCrtSubject, *c.caCrtTemplate, *c.caRSAKey)
+// NewFromCA returns a CertificateBundle like NewWithServerNames, the server and client
+// certificates are issued by an existing CA given as PEM encoded certificate and key
+// instead of a new CA. It allows re-issuing the key pairs without rotating the CA.
+func NewFromCA(caCrt *bytes.Buffer, caKey *bytes.Buffer, serverNames ...string) (*CertificateBundle, error) {
+	caCrtTemplate, caRSAKey, err := parseCA(caCrt, caKey)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CertificateBundle{
+		caRSAKey:      caRSAKey,
+		caCrtTemplate: caCrtTemplate,
+		CACrt:         bytes.NewBuffer(caCrt.Bytes()),
+		CAKey:         bytes.NewBuffer(caKey.Bytes()),
+	}
+	err = c.issueKeyPairs(serverNames)
 	if err != nil {
 		return nil, err
 	}
 	return c, nil
 }
 
+// Fingerprints are the SHA-256 fingerprints of the certificates of a bundle, formatted like
+// the fingerprints printed by openssl x509 -fingerprint -sha256. Fingerprints of certificates
+// missing from the bundle are empty.
+type Fingerprints struct {
+	CA     string
+	Server string
+	Client string
+}
+
+// Fingerprints returns the fingerprints of the certificates of the bundle, allowing operators
+// to verify out-of-band that the clusters of a transfer hold matching bundles
+func (c *CertificateBundle) Fingerprints() (Fingerprints, error) {
+	var fingerprints Fingerprints
+	for _, crt := range []struct {
+		pem         *bytes.Buffer
+		fingerprint *string
+	}{
+		{c.CACrt, &fingerprints.CA},
+		{c.ServerCrt, &fingerprints.Server},
+		{c.ClientCrt, &fingerprints.Client},
+	} {
+		if crt.pem == nil || crt.pem.Len() == 0 {
+			continue
+		}
+		fingerprint, err := Fingerprint(crt.pem)
+		if err != nil {
+			return Fingerprints{}, err
+		}
+		*crt.fingerprint = fingerprint
+	}
+	return fingerprints, nil
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the PEM encoded certificate as colon
+// separated upper case hex bytes
+func Fingerprint(crt *bytes.Buffer) (string, error) {
+	block, _ := pem.Decode(crt.Bytes())
+	if block == nil {
+		return "", fmt.Errorf("unable to decode certificate")
+	}
+	sum := sha256.Sum256(block.Bytes)
+	hexBytes := make([]string, len(sum))
+	for i, b := range sum {
+		hexBytes[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(hexBytes, ":"), nil
+}
+
+// NewRevocationList returns a PEM encoded certificate revocation list signed by the CA given
+// as PEM encoded certificate and key, revoking the certificates with the given serial numbers.
+// The CA must be allowed to sign revocation lists, which CAs generated by GenerateCA are.
+func NewRevocationList(caCrt *bytes.Buffer, caKey *bytes.Buffer, revoked []*big.Int) (*bytes.Buffer, error) {
+	caCrtTemplate, caRSAKey, err := parseCA(caCrt, caKey)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.AddDate(10, 0, 0),
+	}
+	for _, serial := range revoked {
+		template.RevokedCertificates = append(template.RevokedCertificates, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: now,
+		})
+	}
+	crlBytes, err := x509.CreateRevocationList(rand.Reader, template, caCrtTemplate, caRSAKey)
+	if err != nil {
+		return nil, err
+	}
+	crl := new(bytes.Buffer)
+	err = pem.Encode(crl, &pem.Block{
+		Type:  "X509 CRL",
+		Bytes: crlBytes,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+// RevokedSerialNumbers returns the serial numbers revoked by the PEM encoded revocation list
+func RevokedSerialNumbers(crl *bytes.Buffer) ([]*big.Int, error) {
+	list, err := x509.ParseCRL(crl.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse revocation list: %#v", err)
+	}
+	serials := []*big.Int{}
+	for _, revoked := range list.TBSCertList.RevokedCertificates {
+		serials = append(serials, revoked.SerialNumber)
+	}
+	return serials, nil
+}
+
+// SerialNumber returns the serial number of the PEM encoded certificate
+func SerialNumber(crt *bytes.Buffer) (*big.Int, error) {
+	block, _ := pem.Decode(crt.Bytes())
+	if block == nil {
+		return nil, fmt.Errorf("unable to decode certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate: %#v", err)
+	}
+	return cert.SerialNumber, nil
+}
+
+func parseCA(caCrt *bytes.Buffer, caKey *bytes.Buffer) (*x509.Certificate, *rsa.PrivateKey, error) {
+	crtBlock, _ := pem.Decode(caCrt.Bytes())
+	if crtBlock == nil {
+		return nil, nil, fmt.Errorf("unable to decode CA certificate")
+	}
+	caCrtTemplate, err := x509.ParseCertificate(crtBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA certificate: %#v", err)
+	}
+	keyBlock, _ := pem.Decode(caKey.Bytes())
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("unable to decode CA key")
+	}
+	caRSAKey, err := x509.ParsePKCS1PrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse CA key: %#v", err)
+	}
+	return caCrtTemplate, caRSAKey, nil
+}
+
+// issueKeyPairs issues the server and client key pairs signed by the CA of the bundle
+func (c *CertificateBundle) issueKeyPairs(serverNames []string) error {
+	var err error
+	c.ServerCrt, c.ServerKey, err = GenerateWithSANs(defaultCrtSubject, *c.caCrtTemplate, *c.caRSAKey, serverNames)
+	if err != nil {
+		return err
+	}
+
+	c.ClientCrt, c.ClientKey, err = Generate(defaultCrtSubject, *c.caCrtTemplate, *c.caRSAKey)
+	return err
+}
+
 // GenerateCA take a subject and returns caCrt, caKey and caCrtTemplate
 // The caKey and caCrtTemplate should be passed into Generate
 // along with a similar subject except the CN name should be different from
@@ -82,14 +253,18 @@ func GenerateCA(subject *pkix.Name) (caCrt *bytes.Buffer, caKey *rsa.PrivateKey,
 	if subject == nil {
 		subject = defaultCASubject
 	}
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return
+	}
 	caCrtTemplate = &x509.Certificate{
-		SerialNumber:          big.NewInt(2021),
+		SerialNumber:          serialNumber,
 		Subject:               *subject,
 		NotBefore:             time.Now(),
 		NotAfter:              time.Now().AddDate(10, 0, 0),
 		IsCA:                  true,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageAny},
-		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
 		BasicConstraintsValid: true,
 	}
 	caCrt, caKey, err = createCrtKeyPair(caCrtTemplate, nil, nil)
@@ -102,14 +277,35 @@ func GenerateCA(subject *pkix.Name) (caCrt *bytes.Buffer, caKey *rsa.PrivateKey,
 // Generate takes a subject, caCrtTemplate and caKey and returns crt, key and error
 // if error is not nil, do not rely on crt or keys being not nil.
 func Generate(subject *pkix.Name, caCrtTemplate x509.Certificate, caKey rsa.PrivateKey) (crt *bytes.Buffer, key *bytes.Buffer, err error) {
+	return GenerateWithSANs(subject, caCrtTemplate, caKey, nil)
+}
+
+// GenerateWithSANs is like Generate, sans are added to the certificate as DNS names or
+// IP addresses depending on their format
+func GenerateWithSANs(subject *pkix.Name, caCrtTemplate x509.Certificate, caKey rsa.PrivateKey, sans []string) (crt *bytes.Buffer, key *bytes.Buffer, err error) {
+	// revocation lists identify certificates by serial number alone: with the fixed serial the
+	// server and client certificates used to share, revoking the client would revoke the server
+	// too, and the client certificate re-issued by stunnel.RevokeClientCertificate would be
+	// rejected by the very list revoking its predecessor. Each certificate gets a random one.
+	serialNumber, err := rand.Int(rand.Reader, serialNumberLimit)
+	if err != nil {
+		return
+	}
 	crtTemplate := &x509.Certificate{
-		SerialNumber: big.NewInt(2020),
+		SerialNumber: serialNumber,
 		Subject:      *subject,
 		NotBefore:    time.Now(),
 		NotAfter:     time.Now().AddDate(10, 0, 0),
 		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
 		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
 	}
+	for _, san := range sans {
+		if ip := net.ParseIP(san); ip != nil {
+			crtTemplate.IPAddresses = append(crtTemplate.IPAddresses, ip)
+		} else {
+			crtTemplate.DNSNames = append(crtTemplate.DNSNames, san)
+		}
+	}
 
 	crt, rsaKey, err := createCrtKeyPair(crtTemplate, &caCrtTemplate, &caKey)
 	if err != nil {
@@ -151,6 +347,19 @@ func VerifyCertificate(caCrt *bytes.Buffer, crt *bytes.Buffer) (bool, error) {
 	return true, nil
 }
 
+// VerifyHostname returns true if the crt is valid for the given hostname or IP address
+func VerifyHostname(crt *bytes.Buffer, hostname string) (bool, error) {
+	block, _ := pem.Decode(crt.Bytes())
+	if block == nil {
+		return false, fmt.Errorf("unable to decode certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse certificate: %#v", err)
+	}
+	return cert.VerifyHostname(hostname) == nil, nil
+}
+
 func createCrtKeyPair(crtTemplate, parent *x509.Certificate, signer *rsa.PrivateKey) (crt *bytes.Buffer, key *rsa.PrivateKey, err error) {
 	key, err = rsa.GenerateKey(rand.Reader, keySize)
 	if err != nil {
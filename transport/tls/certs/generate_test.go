@@ -1,6 +1,12 @@
 package certs
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"strings"
 	"testing"
 )
 
@@ -72,3 +78,197 @@ func TestNew(t *testing.T) {
 		})
 	}
 }
+
+func TestNewWithServerNames(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverNames []string
+		hostname    string
+		want        bool
+	}{
+		{
+			name:        "server cert issued for the hostname, must verify",
+			serverNames: []string{"transfer.apps.example.com"},
+			hostname:    "transfer.apps.example.com",
+			want:        true,
+		},
+		{
+			name:        "server cert issued for the IP address, must verify",
+			serverNames: []string{"10.0.0.1"},
+			hostname:    "10.0.0.1",
+			want:        true,
+		},
+		{
+			name:        "server cert issued for another hostname, must not verify",
+			serverNames: []string{"other.apps.example.com"},
+			hostname:    "transfer.apps.example.com",
+			want:        false,
+		},
+		{
+			name:     "server cert without server names, must not verify",
+			hostname: "transfer.apps.example.com",
+			want:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewWithServerNames(tt.serverNames...)
+			if err != nil {
+				t.Fatalf("NewWithServerNames() error = %v", err)
+			}
+			if ok, _ := VerifyCertificate(got.CACrt, got.ServerCrt); !ok {
+				t.Error("server cert is not verified with root CA")
+			}
+			verified, err := VerifyHostname(got.ServerCrt, tt.hostname)
+			if err != nil {
+				t.Fatalf("VerifyHostname() error = %v", err)
+			}
+			if verified != tt.want {
+				t.Errorf("VerifyHostname() = %v, want %v", verified, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewFromCA(t *testing.T) {
+	ca, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tests := []struct {
+		name    string
+		caCrt   []byte
+		caKey   []byte
+		wantErr bool
+	}{
+		{
+			name:  "existing CA, must issue key pairs signed by the CA",
+			caCrt: ca.CACrt.Bytes(),
+			caKey: ca.CAKey.Bytes(),
+		},
+		{
+			name:    "CA certificate cannot be decoded, must return error",
+			caCrt:   []byte("invalid"),
+			caKey:   ca.CAKey.Bytes(),
+			wantErr: true,
+		},
+		{
+			name:    "CA key cannot be decoded, must return error",
+			caCrt:   ca.CACrt.Bytes(),
+			caKey:   []byte("invalid"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewFromCA(bytes.NewBuffer(tt.caCrt), bytes.NewBuffer(tt.caKey), "transfer.apps.example.com")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewFromCA() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if !bytes.Equal(got.CACrt.Bytes(), ca.CACrt.Bytes()) {
+				t.Error("NewFromCA() rotated the CA")
+			}
+			if ok, _ := VerifyCertificate(ca.CACrt, got.ClientCrt); !ok {
+				t.Error("client cert is not verified with the existing CA")
+			}
+			if ok, _ := VerifyCertificate(ca.CACrt, got.ServerCrt); !ok {
+				t.Error("server cert is not verified with the existing CA")
+			}
+			if ok, _ := VerifyHostname(got.ServerCrt, "transfer.apps.example.com"); !ok {
+				t.Error("server cert is not issued for the server name")
+			}
+		})
+	}
+}
+
+func TestNewRevocationList(t *testing.T) {
+	bundle, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	clientSerial, err := SerialNumber(bundle.ClientCrt)
+	if err != nil {
+		t.Fatalf("SerialNumber() error = %v", err)
+	}
+	serverSerial, err := SerialNumber(bundle.ServerCrt)
+	if err != nil {
+		t.Fatalf("SerialNumber() error = %v", err)
+	}
+	if clientSerial.Cmp(serverSerial) == 0 {
+		t.Fatalf("client and server certificates share serial number %v", clientSerial)
+	}
+	tests := []struct {
+		name    string
+		revoked []*big.Int
+	}{
+		{
+			name: "empty list, must revoke nothing",
+		},
+		{
+			name:    "client certificate revoked, must be listed",
+			revoked: []*big.Int{clientSerial},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			crl, err := NewRevocationList(bundle.CACrt, bundle.CAKey, tt.revoked)
+			if err != nil {
+				t.Fatalf("NewRevocationList() error = %v", err)
+			}
+			got, err := RevokedSerialNumbers(crl)
+			if err != nil {
+				t.Fatalf("RevokedSerialNumbers() error = %v", err)
+			}
+			if len(got) != len(tt.revoked) {
+				t.Fatalf("RevokedSerialNumbers() = %v, want %v", got, tt.revoked)
+			}
+			for i := range got {
+				if got[i].Cmp(tt.revoked[i]) != 0 {
+					t.Errorf("RevokedSerialNumbers() = %v, want %v", got, tt.revoked)
+				}
+			}
+		})
+	}
+}
+
+func TestCertificateBundle_Fingerprints(t *testing.T) {
+	first, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	second, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	firstSerial, _ := SerialNumber(first.CACrt)
+	secondSerial, _ := SerialNumber(second.CACrt)
+	if firstSerial.Cmp(secondSerial) == 0 {
+		t.Errorf("CAs share serial number %v", firstSerial)
+	}
+
+	fingerprints, err := first.Fingerprints()
+	if err != nil {
+		t.Fatalf("Fingerprints() error = %v", err)
+	}
+	block, _ := pem.Decode(first.CACrt.Bytes())
+	sum := sha256.Sum256(block.Bytes)
+	want := strings.ToUpper(hex.EncodeToString(sum[:1])) + ":"
+	if len(fingerprints.CA) != 95 || !strings.HasPrefix(fingerprints.CA, want) {
+		t.Errorf("Fingerprints() CA = %s, want the colon separated SHA-256 of the certificate", fingerprints.CA)
+	}
+	if fingerprints.CA == fingerprints.Server || fingerprints.Server == fingerprints.Client {
+		t.Errorf("Fingerprints() = %+v, want distinct fingerprints", fingerprints)
+	}
+
+	partial := &CertificateBundle{CACrt: first.CACrt}
+	fingerprints, err = partial.Fingerprints()
+	if err != nil || fingerprints.Server != "" || fingerprints.CA == "" {
+		t.Errorf("Fingerprints() = %+v, %v of a bundle holding only the CA", fingerprints, err)
+	}
+	if _, err := Fingerprint(bytes.NewBufferString("invalid")); err == nil {
+		t.Errorf("Fingerprint() of an invalid certificate must return an error")
+	}
+}
@@ -2,13 +2,36 @@ package transport
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
 
+	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+var (
+	// ErrTransportSecretInvalid is returned when the secret holding transport
+	// credentials exists but its data cannot be used by the transport
+	ErrTransportSecretInvalid = errors.New("transport secret invalid")
+	// ErrTransportPortInvalid is returned when a port set in the transport options
+	// is not a valid port number or clashes with another port used by the transport
+	ErrTransportPortInvalid = errors.New("transport port invalid")
+	// ErrTransportMisconfigured is returned by IsHealthy when the resources of the transport
+	// are missing or invalid, or when its containers cannot start
+	ErrTransportMisconfigured = errors.New("transport misconfigured")
+	// ErrImageInvalid is returned when an image set in the options is not a valid image
+	// reference, or is not pinned to a digest while digests are required
+	ErrImageInvalid = errors.New("image invalid")
+	// ErrTrustBundleInvalid is returned when a trust bundle set in the transport options does
+	// not reference exactly one ConfigMap or Secret
+	ErrTrustBundleInvalid = errors.New("trust bundle invalid")
+)
+
 // Transport exposes the methods required for transfers to add
 // a tunneling mechanism for the traffic sent over the network.
 type Transport interface {
@@ -33,21 +56,205 @@ type Transport interface {
 	// in case of a null transport, it will simple relay the endpoint hostname
 	// in case of a valid transport, it will have a custom hostname where transfers will have to connect to.
 	Hostname() string
+	// ConnectionInfo returns how transfers reach the transport, transfer clients connect to a
+	// transport client on it and transfer servers listen on it for traffic relayed by a transport server
+	ConnectionInfo() ConnectionInfo
+	// IsHealthy returns whether the transport is ready to relay traffic. It returns an error
+	// wrapping ErrTransportMisconfigured when the transport cannot become healthy without
+	// changes, and false without an error while the transport containers are starting
+	IsHealthy(ctx context.Context, c client.Client) (bool, error)
 	// MarkForCleanup adds a label to all the resources created for the endpoint
 	// Callers are expected to not overwrite
 	MarkForCleanup(ctx context.Context, c client.Client, key, value string) error
 }
 
+const (
+	// CompletionVolumeName is the name of the volume shared by transfer and transport containers
+	// of transports terminating on transfer completion
+	CompletionVolumeName = "transport-completion"
+	// CompletionMountPath is where the completion volume is mounted in transfer and transport containers
+	CompletionMountPath = "/var/run/transport"
+	// CompletionFile is created by transfer containers once the transfer completes, transports
+	// terminating on transfer completion stop gracefully as soon as it exists
+	CompletionFile = CompletionMountPath + "/done"
+)
+
+// CompletionAware is implemented by transports which can terminate on transfer completion.
+// When TerminatesOnCompletion returns true, transfers must mount the volume named
+// CompletionVolumeName at CompletionMountPath in their containers and create CompletionFile
+// once the transfer completes
+type CompletionAware interface {
+	TerminatesOnCompletion() bool
+}
+
+// NativeSidecarAware is implemented by transports which can run as native sidecars. When
+// RunsAsNativeSidecar returns true, transfers must add the containers of the transport to the
+// init containers of their pods with NativeSidecars instead of their containers
+type NativeSidecarAware interface {
+	RunsAsNativeSidecar() bool
+}
+
+// NativeSidecars returns copies of containers running as native sidecars, Kubernetes starts
+// them before the containers of the pod and stops them once the containers of the pod exited
+func NativeSidecars(containers []corev1.Container) []corev1.Container {
+	sidecars := []corev1.Container{}
+	for _, container := range containers {
+		sidecar := *container.DeepCopy()
+		restartPolicy := corev1.ContainerRestartPolicyAlways
+		sidecar.RestartPolicy = &restartPolicy
+		sidecars = append(sidecars, sidecar)
+	}
+	return sidecars
+}
+
+// ContainerStatuses returns the statuses of the containers of a pod followed by the statuses
+// of its native sidecars, other init containers are left out
+func ContainerStatuses(pod *corev1.Pod) []corev1.ContainerStatus {
+	statuses := append([]corev1.ContainerStatus{}, pod.Status.ContainerStatuses...)
+	sidecars := map[string]bool{}
+	for _, container := range pod.Spec.InitContainers {
+		if container.RestartPolicy != nil && *container.RestartPolicy == corev1.ContainerRestartPolicyAlways {
+			sidecars[container.Name] = true
+		}
+	}
+	for _, status := range pod.Status.InitContainerStatuses {
+		if sidecars[status.Name] {
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses
+}
+
+// Reconciler is implemented by transports which can restore their resources, e.g. after their
+// ConfigMaps or Secrets were edited mid-transfer. Transfers reconciling their pods call it before
+// checking whether the configuration mounted by the pods drifted.
+type Reconciler interface {
+	Reconcile(ctx context.Context, c client.Client) error
+}
+
+// ImagePullSecretsProvider is implemented by transports whose containers need image pull
+// secrets, transfers add them to the pods running the transport containers
+type ImagePullSecretsProvider interface {
+	ImagePullSecrets() []corev1.LocalObjectReference
+}
+
+// ClientCredentialsProvider is implemented by transport servers storing the credentials of their
+// clients in a secret of its own, callers give it to transport clients instead of Credentials
+type ClientCredentialsProvider interface {
+	ClientCredentials() types.NamespacedName
+}
+
+// OneWayTLSProvider is implemented by credentials providers issuing a certificate to transport
+// servers only, e.g. the OpenShift service CA. Transport clients verify the server without
+// presenting a certificate of their own and servers accept clients without verifying them.
+type OneWayTLSProvider interface {
+	OneWayTLS() bool
+}
+
+// DefaultTrustBundleKey is the key of a trust bundle when none is set, it is the key of the
+// CA bundles injected by OpenShift in ConfigMaps labeled config.openshift.io/inject-trusted-cabundle
+const DefaultTrustBundleKey = "ca-bundle.crt"
+
+// TrustBundle references PEM encoded CA certificates stored in a ConfigMap or a Secret
+// in the namespace of the transport. Exactly one of ConfigMap and Secret must be set.
+type TrustBundle struct {
+	// ConfigMap is the name of the ConfigMap holding the bundle
+	ConfigMap string
+	// Secret is the name of the Secret holding the bundle
+	Secret string
+	// Key is the key of the bundle in the ConfigMap or Secret, defaults to DefaultTrustBundleKey
+	Key string
+}
+
+// Validate returns an error wrapping ErrTrustBundleInvalid when the bundle does not
+// reference exactly one ConfigMap or Secret
+func (b *TrustBundle) Validate() error {
+	if (b.ConfigMap == "") == (b.Secret == "") {
+		return fmt.Errorf("%w: exactly one of ConfigMap and Secret must be set", ErrTrustBundleInvalid)
+	}
+	return nil
+}
+
+// GetKey returns the key of the bundle in the ConfigMap or Secret
+func (b *TrustBundle) GetKey() string {
+	if b.Key == "" {
+		return DefaultTrustBundleKey
+	}
+	return b.Key
+}
+
+// VolumeSource returns the volume source projecting the bundle to path
+func (b *TrustBundle) VolumeSource(path string) corev1.VolumeSource {
+	items := []corev1.KeyToPath{{Key: b.GetKey(), Path: path}}
+	if b.Secret != "" {
+		return corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: b.Secret, Items: items},
+		}
+	}
+	return corev1.VolumeSource{
+		ConfigMap: &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: corev1.LocalObjectReference{Name: b.ConfigMap},
+			Items:                items,
+		},
+	}
+}
+
 // Options allows users of the transport to configure certain field
 type Options struct {
-	// Labels will be applied to objects reconciled by the transport
+	// Labels will be applied to objects reconciled by the transport, the pods running the
+	// transport containers must carry them to be checked by IsHealthy
 	Labels map[string]string
 	// Owners will be applied to all objects reconciled by the transport
 	Owners []metav1.OwnerReference
-	// Image allows for specifying the image used for running the transport containers
+	// Image allows for specifying the image used for running the transport containers.
+	// Deprecated: use the image field of the transport component, e.g. StunnelImage
 	Image string
+	// StunnelImage is the image of the stunnel containers, defaults to Image
+	StunnelImage string
+	// RequireImageDigests rejects images which are not pinned to a digest, including the
+	// default images of the transport
+	RequireImageDigests bool
+	// ImagePullPolicy is applied to the transport containers
+	ImagePullPolicy corev1.PullPolicy
+	// ImagePullSecrets are required by the pods running the transport containers for pulling
+	// the transport image from a private registry
+	ImagePullSecrets []corev1.LocalObjectReference
+	// Resources allows for configuring the resources consumed by the transport containers
+	Resources corev1.ResourceRequirements
+	// ContainerSecurityContext is applied to the transport containers, e.g. to run them
+	// in namespaces enforcing the restricted pod security standard
+	ContainerSecurityContext corev1.SecurityContext
+	// Env is added to the environment of the transport containers
+	Env []corev1.EnvVar
+	// EnvFrom populates the environment of the transport containers from ConfigMaps and Secrets
+	EnvFrom []corev1.EnvFromSource
 	// Credentials allows specifying pre-existing transport credentials
 	*Credentials
+	// ScopedCredentials writes generated TLS credentials without the private key of the CA, which
+	// is only kept in memory while issuing the certificates, and splits them so that each secret
+	// holds the key pair of one side. Transport servers implement ClientCredentialsProvider and
+	// clients expect the credentials of the client secret instead of the server secret.
+	ScopedCredentials bool
+	// SplitCredentials splits the generated TLS credentials like ScopedCredentials and stores the
+	// CA in a secret of its own next to the server secret, where transport servers sign the key
+	// pairs. Key pairs re-issued by the server are signed by the stored CA instead of a new one.
+	SplitCredentials bool
+	// RevocationList makes transport servers reject client certificates revoked in a certificate
+	// revocation list stored with the generated TLS credentials, an empty list is written when
+	// it is missing. The list is signed by the CA, which is not kept with ScopedCredentials.
+	RevocationList bool
+	// KubernetesTLSSecrets stores the TLS credentials of each side in a kubernetes.io/tls secret
+	// holding tls.crt, tls.key and ca.crt, like ScopedCredentials. Secrets in this format, e.g.
+	// issued by cert-manager, are accepted as credentials: the server secret is the secret of
+	// the credentials and the client secret is named after it with the suffix -client.
+	KubernetesTLSSecrets bool
+
+	// ClientListenPort is the port on which transport clients listen for connections
+	// from the transfer client, defaults to a port chosen by the transport
+	ClientListenPort int32
+	// ServerConnectPort is the port on which the transfer server listens for connections
+	// relayed by transport servers, defaults to a port chosen by the transport
+	ServerConnectPort int32
 
 	// ProxyURL is used if the cluster is behind a proxy
 	ProxyURL string
@@ -55,6 +262,71 @@ type Options struct {
 	ProxyUsername string
 	// ProxyPassword password for connecting to the proxy
 	ProxyPassword string
+	// ProxyCABundle is added to the CAs trusted by transport clients, it is required when the
+	// proxy intercepts TLS connections and presents certificates signed by its own CA
+	ProxyCABundle *TrustBundle
+
+	// TLSVersions restricts the TLS protocol versions negotiated by the transport,
+	// defaults to TLSv1.3
+	TLSVersions []string
+	// TLSCiphersuites restricts the cipher suites negotiated by the transport, defaults
+	// to the cipher suites enabled by the TLS library of the transport
+	TLSCiphersuites []string
+	// TLSCurves sets the elliptic curves used for key exchange in order of preference
+	TLSCurves []string
+	// VerifyServerHostname makes transport clients verify that the certificate of the server
+	// was issued for the hostname they connect to. Generated server certificates include the
+	// hostname of the endpoint in their SANs. It can't be used through endpoints terminating
+	// TLS, e.g. reencrypt routes
+	VerifyServerHostname bool
+	// PinServerCertificate makes transport clients accept only the server certificate stored
+	// in the transport credentials instead of any certificate signed by the CA. It can't be
+	// used through endpoints terminating TLS, e.g. reencrypt routes
+	PinServerCertificate bool
+	// ServerName is sent by transport clients in the TLS server name indication, e.g. the
+	// hostname of a route or an ingress selecting the backend by SNI when clients connect
+	// through another address, defaults to the behavior of the transport
+	ServerName string
+
+	// TerminateOnCompletion runs the transport containers under a supervisor stopping the
+	// transport gracefully once the transfer creates CompletionFile
+	TerminateOnCompletion bool
+	// NativeSidecar runs the transport containers as native sidecars, init containers always
+	// restarted which Kubernetes stops once the transfer containers exited. It requires
+	// Kubernetes 1.29 or later, TerminateOnCompletion is ignored when it is set. Only native
+	// sidecars get a liveness probe, other transport containers are never restarted.
+	NativeSidecar bool
+
+	// ServerSideApply reconciles objects using Server-Side Apply instead of CreateOrUpdate,
+	// see the ServerSideApply field of reconcile.Options
+	ServerSideApply bool
+	// FieldManager is the field manager used for Server-Side Apply, defaults to "pvc-transfer"
+	FieldManager string
+	// Adopt takes over existing resources owned by other owners instead of returning an
+	// error wrapping transfer.ErrResourceConflict
+	Adopt bool
+}
+
+// SchemeTCP is the scheme of transports relaying plain TCP streams to and from transfers
+const SchemeTCP = "tcp"
+
+// ConnectionInfo describes the connection between a transfer and its transport
+type ConnectionInfo struct {
+	// Scheme is the protocol spoken between the transfer and the transport
+	Scheme string
+	// Hostname is the host on which the transport accepts or relays transfer connections
+	Hostname string
+	// Port is the port on which the transport accepts or relays transfer connections
+	Port int32
+	// RequiresProxy is true when the transport reaches its peer through a proxy
+	RequiresProxy bool
+	// CredentialsRef is the secret holding the credentials used by the transport
+	CredentialsRef types.NamespacedName
+}
+
+// Address returns the host:port address of the connection
+func (i ConnectionInfo) Address() string {
+	return net.JoinHostPort(i.Hostname, strconv.Itoa(int(i.Port)))
 }
 
 // Credentials are used by transports to encrypt data
@@ -63,6 +335,23 @@ type Credentials struct {
 	SecretRef types.NamespacedName
 	// Type type of credentials used
 	Type CredentialsType
+	// Provider reads the credentials from a secret store instead of a Secret generated by
+	// the transport, see the providers of package transport/credentials
+	Provider CredentialsProvider
+}
+
+// CredentialsProvider makes credentials kept in a secret store available to the pods of a
+// transport. Transports do not generate credentials read from a provider, the store must hold
+// the keys of the transport, e.g. server.crt, server.key and ca.crt for stunnel servers.
+type CredentialsProvider interface {
+	// Reconcile creates the objects making the credentials named secretRef available in its
+	// namespace, keys are the keys of the credentials read by the transport
+	Reconcile(ctx context.Context, c client.Client, logger logr.Logger, secretRef types.NamespacedName, keys []string, options *Options) error
+	// IsReady returns whether the credentials named secretRef are available to the pods,
+	// keys are the keys of the credentials read by the transport
+	IsReady(ctx context.Context, c client.Client, secretRef types.NamespacedName, keys []string) (bool, error)
+	// VolumeSource returns the volume source projecting the items of the credentials
+	VolumeSource(secretRef types.NamespacedName, items []corev1.KeyToPath) corev1.VolumeSource
 }
 
 type CredentialsType string
@@ -0,0 +1,41 @@
+package transport
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestNativeSidecars(t *testing.T) {
+	containers := []corev1.Container{{Name: "stunnel"}}
+	sidecars := NativeSidecars(containers)
+	if len(sidecars) != 1 || sidecars[0].Name != "stunnel" {
+		t.Fatalf("NativeSidecars() = %v, want the stunnel container", sidecars)
+	}
+	if sidecars[0].RestartPolicy == nil || *sidecars[0].RestartPolicy != corev1.ContainerRestartPolicyAlways {
+		t.Errorf("NativeSidecars() restart policy = %v, want Always", sidecars[0].RestartPolicy)
+	}
+	if containers[0].RestartPolicy != nil {
+		t.Errorf("NativeSidecars() must not modify the containers of the transport")
+	}
+}
+
+func TestContainerStatuses(t *testing.T) {
+	pod := &corev1.Pod{
+		Spec: corev1.PodSpec{InitContainers: append([]corev1.Container{{Name: "setup"}},
+			NativeSidecars([]corev1.Container{{Name: "stunnel"}})...)},
+		Status: corev1.PodStatus{
+			InitContainerStatuses: []corev1.ContainerStatus{{Name: "setup"}, {Name: "stunnel"}},
+			ContainerStatuses:     []corev1.ContainerStatus{{Name: "rsync"}},
+		},
+	}
+	names := []string{}
+	for _, status := range ContainerStatuses(pod) {
+		names = append(names, status.Name)
+	}
+	// init containers which are not native sidecars are left out
+	if want := []string{"rsync", "stunnel"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("ContainerStatuses() = %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,111 @@
+// Package watch helps controllers using this library to watch the resources it creates.
+//
+// Owner references cannot cross namespaces nor clusters, the resources of a transfer are
+// instead labelled with OwnerLabels and mapped back to their owner with EnqueueRequestForOwner:
+//
+//	labels, err := watch.OwnerLabels("Migration", client.ObjectKeyFromObject(migration))
+//	...
+//	c, err := ctrl.NewControllerManagedBy(mgr).For(&v1.Migration{}).Build(r)
+//	...
+//	objs, err := rsync.APIsToWatch()
+//	...
+//	err = watch.Watch(c, mgr.GetCache(), "Migration", objs...)
+package watch
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// OwnerKindLabel is the kind of the owner of a resource created by this library
+	OwnerKindLabel = "pvc-transfer.backube.dev/owner-kind"
+	// OwnerNameLabel is the name of the owner of a resource created by this library
+	OwnerNameLabel = "pvc-transfer.backube.dev/owner-name"
+	// OwnerNamespaceLabel is the namespace of the owner of a resource created by this library,
+	// it is not set for cluster scoped owners
+	OwnerNamespaceLabel = "pvc-transfer.backube.dev/owner-namespace"
+)
+
+// Watcher is implemented by controller.Controller
+type Watcher interface {
+	Watch(src source.Source) error
+}
+
+// OwnerLabels returns the labels identifying the owner of the resources of a transfer, callers
+// are expected to add them to the labels passed to the endpoints, transports and transfers.
+// The name of the owner must be a valid label value.
+func OwnerLabels(kind string, owner types.NamespacedName) (map[string]string, error) {
+	labels := map[string]string{
+		OwnerKindLabel: kind,
+		OwnerNameLabel: owner.Name,
+	}
+	if owner.Namespace != "" {
+		labels[OwnerNamespaceLabel] = owner.Namespace
+	}
+	for key, value := range labels {
+		if value == "" {
+			return nil, fmt.Errorf("label %s of owner %s cannot be empty", key, owner)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid label %s of owner %s: %s", key, owner, strings.Join(errs, ", "))
+		}
+	}
+	return labels, nil
+}
+
+// IsOwnedBy returns whether obj is labelled with OwnerLabels for the given kind of owner
+func IsOwnedBy(obj client.Object, kind string) bool {
+	labels := obj.GetLabels()
+	return labels[OwnerKindLabel] == kind && labels[OwnerNameLabel] != ""
+}
+
+// OwnedBy returns a predicate filtering the events of resources labelled with OwnerLabels
+// for the given kind of owner
+func OwnedBy(kind string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		return IsOwnedBy(obj, kind)
+	})
+}
+
+// EnqueueRequestForOwner returns an event handler enqueuing a request for the owner labelled
+// on the resource with OwnerLabels, resources of other kinds of owners are ignored
+func EnqueueRequestForOwner(kind string) handler.EventHandler {
+	return handler.EnqueueRequestsFromMapFunc(func(_ context.Context, obj client.Object) []reconcile.Request {
+		return ownerRequests(obj, kind)
+	})
+}
+
+func ownerRequests(obj client.Object, kind string) []reconcile.Request {
+	if !IsOwnedBy(obj, kind) {
+		return nil
+	}
+	labels := obj.GetLabels()
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Namespace: labels[OwnerNamespaceLabel],
+		Name:      labels[OwnerNameLabel],
+	}}}
+}
+
+// Watch watches the given APIs with the cache of the manager, typically returned by the
+// APIsToWatch functions, enqueuing requests for the owners of the resources labelled with
+// OwnerLabels for the given kind
+func Watch(w Watcher, cache cache.Cache, kind string, objs ...client.Object) error {
+	for _, obj := range objs {
+		err := w.Watch(source.Kind(cache, obj, EnqueueRequestForOwner(kind), OwnedBy(kind)))
+		if err != nil {
+			return fmt.Errorf("unable to watch %T: %w", obj, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+type fakeWatcher struct {
+	// watched are the descriptions of the sources, e.g. "kind source: *v1.Pod"
+	watched []string
+	err     error
+}
+
+func (f *fakeWatcher) Watch(src source.Source) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.watched = append(f.watched, fmt.Sprint(src))
+	return nil
+}
+
+func testPod(labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "rsync-server", Namespace: "dst", Labels: labels}}
+}
+
+func TestOwnerLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		owner   types.NamespacedName
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:  "namespaced owner, must return kind, name and namespace",
+			owner: types.NamespacedName{Namespace: "foo", Name: "bar"},
+			want:  map[string]string{OwnerKindLabel: "Migration", OwnerNameLabel: "bar", OwnerNamespaceLabel: "foo"},
+		},
+		{
+			name:  "cluster scoped owner, must not return a namespace",
+			owner: types.NamespacedName{Name: "bar"},
+			want:  map[string]string{OwnerKindLabel: "Migration", OwnerNameLabel: "bar"},
+		},
+		{
+			name:    "owner name longer than a label value, must return error",
+			owner:   types.NamespacedName{Namespace: "foo", Name: strings.Repeat("a", 64)},
+			wantErr: true,
+		},
+		{
+			name:    "owner without a name, must return error",
+			owner:   types.NamespacedName{Namespace: "foo"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := OwnerLabels("Migration", tt.owner)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OwnerLabels() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("OwnerLabels() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_ownerRequests(t *testing.T) {
+	owned, err := OwnerLabels("Migration", types.NamespacedName{Namespace: "foo", Name: "bar"})
+	if err != nil {
+		t.Fatalf("OwnerLabels() error = %v", err)
+	}
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   []reconcile.Request
+	}{
+		{
+			name:   "resource labelled with the owner, must enqueue the owner",
+			labels: owned,
+			want:   []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: "foo", Name: "bar"}}},
+		},
+		{
+			name:   "resource of another kind of owner, must not enqueue",
+			labels: map[string]string{OwnerKindLabel: "Backup", OwnerNameLabel: "bar"},
+		},
+		{
+			name:   "resource without owner labels, must not enqueue",
+			labels: map[string]string{"app": "rsync"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := testPod(tt.labels)
+			if got := ownerRequests(pod, "Migration"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ownerRequests() = %v, want %v", got, tt.want)
+			}
+			if got := OwnedBy("Migration").Create(event.CreateEvent{Object: pod}); got != (tt.want != nil) {
+				t.Errorf("OwnedBy() = %v, want %v", got, tt.want != nil)
+			}
+		})
+	}
+}
+
+func TestWatch(t *testing.T) {
+	objs := []client.Object{&corev1.Pod{}, &corev1.Service{}}
+	w := &fakeWatcher{}
+	err := Watch(w, nil, "Migration", objs...)
+	if err != nil {
+		t.Fatalf("Watch() error = %v", err)
+	}
+	want := []string{"kind source: *v1.Pod", "kind source: *v1.Service"}
+	if !reflect.DeepEqual(w.watched, want) {
+		t.Errorf("Watch() watched %v, want %v", w.watched, want)
+	}
+
+	err = Watch(&fakeWatcher{err: errors.New("cache not started")}, nil, "Migration", objs...)
+	if err == nil {
+		t.Errorf("Watch() must return the errors of the controller")
+	}
+}